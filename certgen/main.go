@@ -0,0 +1,341 @@
+// Command certgen writes the certificates the mock server, test client and
+// proxy use for mTLS: a CA, a server certificate for the hosts they are
+// reached on, client certificates, a revoked client certificate with the
+// CRL that lists it, and an intermediate CA with a server and a client
+// certificate issued from it. It needs no openssl, and writes the same
+// files, under the same names, as certs/generate.sh.
+package main
+
+import (
+	"crypto/x509/pkix"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// options are what certgen generates, from its flags.
+type options struct {
+	out   string
+	days  int
+	keys  keySpec
+	force bool
+
+	keyPassword string
+
+	p12         bool
+	p12Password string
+	p12Legacy   bool
+
+	org          string
+	caName       string
+	intermediate string
+	server       string
+	hosts        []string
+	clients      []clientName
+	revoked      string
+}
+
+// clientName is the subject of a client certificate: a common name and
+// any organizational units.
+type clientName struct {
+	cn  string
+	ous []string
+}
+
+// parseClient parses a -clients entry: a common name, optionally followed
+// by organizational units, each after a slash, as in ci-runner/ci.
+func parseClient(spec string) (clientName, error) {
+	parts := strings.Split(spec, "/")
+	name := clientName{cn: strings.TrimSpace(parts[0])}
+	for _, ou := range parts[1:] {
+		if ou = strings.TrimSpace(ou); ou == "" {
+			return clientName{}, fmt.Errorf("client %q has an empty OU", spec)
+		}
+		name.ous = append(name.ous, ou)
+	}
+	if name.cn == "" {
+		return clientName{}, fmt.Errorf("client %q has no common name", spec)
+	}
+	return name, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseFlags(args []string) (*options, error) {
+	fs := flag.NewFlagSet("certgen", flag.ContinueOnError)
+	opts := &options{}
+	fs.StringVar(&opts.out, "out", "../certs", "Directory to write the certificates and keys to")
+	fs.IntVar(&opts.days, "days", 365, "Days the certificates are valid for")
+	fs.StringVar(&opts.keys.algorithm, "key-type", keyRSA, "Algorithm of every key: rsa, ecdsa-p256, ecdsa-p384 or ed25519")
+	fs.IntVar(&opts.keys.rsaBits, "rsa-bits", 4096, "Size of the RSA keys")
+	fs.BoolVar(&opts.force, "force", false, "Replace certificates already in -out")
+	keyPass := fs.String("key-pass", "", "Passphrase to encrypt every key with (PKCS #8, AES-256), as pass:<passphrase>, env:<variable> or file:<path>; empty for none")
+	fs.StringVar(&opts.org, "org", "MockOpenAI", "Organization (O) of every certificate")
+	fs.StringVar(&opts.caName, "ca-name", "MockOpenAI-CA", "Common name of the CA")
+	fs.StringVar(&opts.intermediate, "intermediate-name", "MockOpenAI-Intermediate-CA", "Common name of an intermediate CA to issue server-chain.crt and client-chain.crt from, each written with the intermediate after the leaf; none if empty")
+	fs.StringVar(&opts.server, "server-name", "localhost", "Common name of the server certificate")
+	hosts := fs.String("hosts", "localhost,127.0.0.1,::1", "Comma-separated DNS names and IP addresses the server certificate is valid for (its SANs)")
+	clients := fs.String("clients", "test-client", "Comma-separated client certificates to issue, each a common name optionally followed by OUs after slashes, such as ci-runner/ci; the first is written to client.crt, the rest to client-<name>.crt")
+	fs.StringVar(&opts.revoked, "revoked", "revoked-client", "Common name of a client certificate to issue and revoke, written to revoked.crt and listed in crl.pem; none if empty")
+	fs.BoolVar(&opts.p12, "p12", false, "Also write each client identity, with its chain, as a PKCS #12 bundle, client.p12 beside client.crt, for Java and Windows")
+	p12Pass := fs.String("p12-pass", "", "Passphrase of the -p12 bundles, as pass:<passphrase>, env:<variable> or file:<path>; empty for none")
+	fs.BoolVar(&opts.p12Legacy, "p12-legacy", false, "Encrypt -p12 bundles with triple DES and a SHA-1 MAC, for Java before 8u301 and Windows before Server 2019, rather than AES-256 and SHA-256")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if opts.days <= 0 {
+		return nil, errors.New("-days must be positive")
+	}
+	switch opts.keys.algorithm {
+	case keyRSA:
+		if opts.keys.rsaBits < 2048 {
+			return nil, errors.New("-rsa-bits must be at least 2048")
+		}
+	case keyECDSAP256, keyECDSAP384, keyEd25519:
+	default:
+		return nil, fmt.Errorf("invalid -key-type %q: must be %s, %s, %s or %s", opts.keys.algorithm, keyRSA, keyECDSAP256, keyECDSAP384, keyEd25519)
+	}
+	if opts.hosts = splitList(*hosts); len(opts.hosts) == 0 {
+		return nil, errors.New("-hosts must name at least one host")
+	}
+	if !opts.p12 && (*p12Pass != "" || opts.p12Legacy) {
+		return nil, errors.New("-p12-pass and -p12-legacy need -p12")
+	}
+	var err error
+	if opts.keyPassword, err = readPassphrase("key-pass", *keyPass); err != nil {
+		return nil, err
+	}
+	if opts.p12Password, err = readPassphrase("p12-pass", *p12Pass); err != nil {
+		return nil, err
+	}
+	for _, spec := range splitList(*clients) {
+		name, err := parseClient(spec)
+		if err != nil {
+			return nil, err
+		}
+		opts.clients = append(opts.clients, name)
+	}
+	return opts, nil
+}
+
+// clientFiles returns the name each client's files are written under,
+// without the extension: client for the first, as the other tools expect,
+// and client-<common name> for the rest.
+func clientFiles(clients []clientName) ([]string, error) {
+	names := make([]string, len(clients))
+	seen := make(map[string]bool)
+	for i, client := range clients {
+		names[i] = "client"
+		if i > 0 {
+			names[i] = "client-" + fileName(client.cn)
+		}
+		if seen[names[i]] {
+			return nil, fmt.Errorf("clients %q would share %s.crt", client.cn, names[i])
+		}
+		seen[names[i]] = true
+	}
+	return names, nil
+}
+
+// fileName makes a common name safe to use in a file name.
+func fileName(cn string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '-'
+	}, cn)
+}
+
+// generate writes the certificates opts asks for to opts.out, listing them
+// on out as it goes.
+func generate(opts *options, out io.Writer) error {
+	files, err := clientFiles(opts.clients)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(opts.out, 0o755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(opts.out, "ca.key")); err == nil && !opts.force {
+		return fmt.Errorf("%s already has a CA; pass -force to replace it and everything it issued", opts.out)
+	}
+	subject := func(cn string, ous ...string) pkix.Name {
+		return pkix.Name{Organization: []string{opts.org}, OrganizationalUnit: ous, CommonName: cn}
+	}
+
+	fmt.Fprintf(out, "Generating mTLS certificates in %s (%s keys)...\n", opts.out, describeKeys(opts.keys))
+	ca, err := newAuthority(subject(opts.caName), opts.days, opts.keys)
+	if err != nil {
+		return err
+	}
+	if err := writePEM(filepath.Join(opts.out, "ca.crt"), "CERTIFICATE", false, ca.cert.Raw); err != nil {
+		return err
+	}
+	if err := writeKey(filepath.Join(opts.out, "ca.key"), ca.key, opts.keyPassword); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "  CA:     ca.crt, ca.key (CN=%s)\n", opts.caName)
+
+	server, err := ca.issue(serverTemplate(subject(opts.server), opts.hosts), opts.days, opts.keys)
+	if err != nil {
+		return err
+	}
+	if err := server.write(opts.out, "server", opts.keyPassword); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "  Server: server.crt, server.key (CN=%s, for %s)\n", opts.server, strings.Join(opts.hosts, ", "))
+
+	for i, client := range opts.clients {
+		id, err := ca.issue(clientTemplate(subject(client.cn, client.ous...)), opts.days, opts.keys)
+		if err != nil {
+			return err
+		}
+		if err := id.write(opts.out, files[i], opts.keyPassword); err != nil {
+			return err
+		}
+		if opts.p12 {
+			if err := id.writePKCS12(filepath.Join(opts.out, files[i]+".p12"), client.cn, opts.p12Password, ca.cert, opts.p12Legacy); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "  Client: %s.crt, %s.key, %s.p12 (%s)\n", files[i], files[i], files[i], describeName(id.cert.Subject))
+		} else {
+			fmt.Fprintf(out, "  Client: %s.crt, %s.key (%s)\n", files[i], files[i], describeName(id.cert.Subject))
+		}
+	}
+
+	// The intermediate's CRL follows the root's in crl.pem, so that
+	// certificates at every level of a chain can be checked
+	var crls [][]byte
+	if opts.intermediate != "" {
+		intermediate, err := ca.intermediate(subject(opts.intermediate), opts.days, opts.keys)
+		if err != nil {
+			return err
+		}
+		if err := writePEM(filepath.Join(opts.out, "intermediate.crt"), "CERTIFICATE", false, intermediate.cert.Raw); err != nil {
+			return err
+		}
+		if err := writeKey(filepath.Join(opts.out, "intermediate.key"), intermediate.key, opts.keyPassword); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "  Intermediate CA: intermediate.crt, intermediate.key (CN=%s)\n", opts.intermediate)
+
+		server, err := intermediate.issue(serverTemplate(subject(opts.server), opts.hosts), opts.days, opts.keys)
+		if err != nil {
+			return err
+		}
+		if err := server.write(opts.out, "server-chain", opts.keyPassword); err != nil {
+			return err
+		}
+		client, err := intermediate.issue(clientTemplate(subject("chain-client")), opts.days, opts.keys)
+		if err != nil {
+			return err
+		}
+		if err := client.write(opts.out, "client-chain", opts.keyPassword); err != nil {
+			return err
+		}
+		chained := "server-chain.crt, client-chain.crt and their keys"
+		if opts.p12 {
+			if err := client.writePKCS12(filepath.Join(opts.out, "client-chain.p12"), "chain-client", opts.p12Password, ca.cert, opts.p12Legacy); err != nil {
+				return err
+			}
+			chained += ", client-chain.p12"
+		}
+		fmt.Fprintf(out, "  Chained: %s (leaf, then intermediate)\n", chained)
+
+		crl, err := intermediate.revocationList(nil, opts.days)
+		if err != nil {
+			return err
+		}
+		crls = append(crls, crl)
+	}
+
+	var revoked []*identity
+	if opts.revoked != "" {
+		id, err := ca.issue(clientTemplate(subject(opts.revoked)), opts.days, opts.keys)
+		if err != nil {
+			return err
+		}
+		if err := id.write(opts.out, "revoked", opts.keyPassword); err != nil {
+			return err
+		}
+		revoked = append(revoked, id)
+	}
+	crl, err := ca.revocationList(revoked, opts.days)
+	if err != nil {
+		return err
+	}
+	if err := writePEM(filepath.Join(opts.out, "crl.pem"), "X509 CRL", false, append([][]byte{crl}, crls...)...); err != nil {
+		return err
+	}
+	if opts.revoked != "" {
+		fmt.Fprintf(out, "  Revoked client: revoked.crt, revoked.key (CN=%s, listed in crl.pem)\n", opts.revoked)
+	} else {
+		fmt.Fprintf(out, "  CRL:    crl.pem (empty)\n")
+	}
+
+	if opts.keyPassword != "" {
+		fmt.Fprintf(out, "  Keys:   encrypted with the -key-pass passphrase\n")
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Usage:")
+	fmt.Fprintln(out, "  Server: ./openai-mock-server -cert ../certs/server.crt -key ../certs/server.key -ca ../certs/ca.crt -crl ../certs/crl.pem")
+	fmt.Fprintln(out, "  Client: ./openai-test-client -cert ../certs/client.crt -key ../certs/client.key -ca ../certs/ca.crt")
+	return nil
+}
+
+// describeKeys names the kind of key each certificate gets, for the
+// listing.
+func describeKeys(keys keySpec) string {
+	switch keys.algorithm {
+	case keyECDSAP256:
+		return "ECDSA P-256"
+	case keyECDSAP384:
+		return "ECDSA P-384"
+	case keyEd25519:
+		return "Ed25519"
+	}
+	return fmt.Sprintf("RSA %d", keys.rsaBits)
+}
+
+// describeName gives a certificate subject's CN and OUs, for the listing.
+func describeName(name pkix.Name) string {
+	parts := []string{"CN=" + name.CommonName}
+	for _, ou := range name.OrganizationalUnit {
+		parts = append(parts, "OU="+ou)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func main() {
+	opts, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "certgen: %v\n", err)
+		os.Exit(2)
+	}
+	if err := generate(opts, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "certgen: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// loadPair loads name.crt and name.key from dir, checking they match.
+func loadPair(t *testing.T, dir, name string) *x509.Certificate {
+	t.Helper()
+	return loadChain(t, dir, name)[0]
+}
+
+// loadChain loads name.crt and name.key from dir, checking they match,
+// and returns the certificates in name.crt, leaf first.
+func loadChain(t *testing.T, dir, name string) []*x509.Certificate {
+	t.Helper()
+	pair, err := tls.LoadX509KeyPair(filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, name+".key")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("%s.key: mode %v, %v; want 0600", name, info.Mode().Perm(), err)
+	}
+	var chain []*x509.Certificate
+	for _, der := range pair.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, cert)
+	}
+	return chain
+}
+
+// readPEM reads the PEM blocks of path.
+func readPEM(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks [][]byte
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		blocks = append(blocks, block.Bytes)
+	}
+	if len(blocks) == 0 {
+		t.Fatalf("%s: no PEM", path)
+	}
+	return blocks
+}
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	opts, err := parseFlags([]string{"-out", dir, "-rsa-bits", "2048", "-hosts", "localhost,proxy.internal,127.0.0.1",
+		"-clients", "test-client,ci-runner/ci/build,billing/finance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := generate(opts, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := x509.ParseCertificate(readPEM(t, filepath.Join(dir, "ca.crt"))[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	server := loadPair(t, dir, "server")
+	for _, host := range []string{"localhost", "proxy.internal", "127.0.0.1"} {
+		if _, err := server.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("server certificate for %s: %v", host, err)
+		}
+	}
+	if err := server.VerifyHostname("example.com"); err == nil {
+		t.Error("server certificate valid for a host not in -hosts")
+	}
+
+	for name, want := range map[string][]string{"client": nil, "client-ci-runner": {"ci", "build"}, "client-billing": {"finance"}} {
+		client := loadPair(t, dir, name)
+		if _, err := client.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if !slices.Equal(client.Subject.OrganizationalUnit, want) {
+			t.Errorf("%s: OUs %v, want %v", name, client.Subject.OrganizationalUnit, want)
+		}
+	}
+
+	// The chained certificates verify with only the intermediate sent
+	// after them, and not without it
+	intermediate := loadPair(t, dir, "intermediate")
+	if !intermediate.IsCA || intermediate.MaxPathLen != 0 || !intermediate.MaxPathLenZero {
+		t.Errorf("intermediate: IsCA %v, pathlen %d; want a CA with pathlen 0", intermediate.IsCA, intermediate.MaxPathLen)
+	}
+	for name, usage := range map[string]x509.ExtKeyUsage{"server-chain": x509.ExtKeyUsageServerAuth, "client-chain": x509.ExtKeyUsageClientAuth} {
+		chain := loadChain(t, dir, name)
+		if len(chain) != 2 || !chain[1].Equal(intermediate) {
+			t.Fatalf("%s.crt holds %d certificates, want the leaf and the intermediate", name, len(chain))
+		}
+		intermediates := x509.NewCertPool()
+		intermediates.AddCert(chain[1])
+		if _, err := chain[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{usage}}); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if _, err := chain[0].Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{usage}}); err == nil {
+			t.Errorf("%s verified without its intermediate", name)
+		}
+	}
+
+	// crl.pem holds the CA's CRL, listing the revoked client and no
+	// other, then the intermediate's, listing none
+	crls := readPEM(t, filepath.Join(dir, "crl.pem"))
+	if len(crls) != 2 {
+		t.Fatalf("crl.pem holds %d CRLs, want 2", len(crls))
+	}
+	crl, err := x509.ParseRevocationList(crls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("CRL: %v", err)
+	}
+	revoked := loadPair(t, dir, "revoked")
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(revoked.SerialNumber) != 0 {
+		t.Errorf("CRL lists %d certificates, want the revoked client's", len(crl.RevokedCertificateEntries))
+	}
+	crl, err = x509.ParseRevocationList(crls[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(intermediate); err != nil || len(crl.RevokedCertificateEntries) != 0 {
+		t.Errorf("intermediate CRL: %v, %d entries; want an empty one it signed", err, len(crl.RevokedCertificateEntries))
+	}
+
+	// A CA is not replaced without -force
+	if err := generate(opts, io.Discard); err == nil {
+		t.Error("replaced the CA without -force")
+	}
+	opts.force, opts.clients, opts.revoked, opts.intermediate = true, opts.clients[:1], "", ""
+	if err := generate(opts, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	crls = readPEM(t, filepath.Join(dir, "crl.pem"))
+	if crl, err := x509.ParseRevocationList(crls[0]); err != nil || len(crls) != 1 || len(crl.RevokedCertificateEntries) != 0 {
+		t.Errorf("without -revoked or -intermediate-name: %d CRLs, %v; want one empty one", len(crls), err)
+	}
+}
+
+func TestKeyTypes(t *testing.T) {
+	for _, tt := range []struct {
+		keyType   string
+		algorithm x509.PublicKeyAlgorithm
+		curve     string // of ECDSA keys
+	}{
+		{keyECDSAP256, x509.ECDSA, "P-256"},
+		{keyECDSAP384, x509.ECDSA, "P-384"},
+		{keyEd25519, x509.Ed25519, ""},
+	} {
+		dir := t.TempDir()
+		opts, err := parseFlags([]string{"-out", dir, "-key-type", tt.keyType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := generate(opts, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"ca", "intermediate", "server", "client", "server-chain", "client-chain", "revoked"} {
+			cert := loadPair(t, dir, name)
+			if cert.PublicKeyAlgorithm != tt.algorithm {
+				t.Errorf("%s %s: %v key", tt.keyType, name, cert.PublicKeyAlgorithm)
+			}
+			if key, ok := cert.PublicKey.(*ecdsa.PublicKey); ok && key.Curve.Params().Name != tt.curve {
+				t.Errorf("%s %s: curve %s", tt.keyType, name, key.Curve.Params().Name)
+			}
+			if cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+				t.Errorf("%s %s: key encipherment allowed without an RSA key", tt.keyType, name)
+			}
+		}
+
+		// The chained server and client complete an mTLS handshake
+		server, err := tls.LoadX509KeyPair(filepath.Join(dir, "server-chain.crt"), filepath.Join(dir, "server-chain.key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := tls.LoadX509KeyPair(filepath.Join(dir, "client-chain.crt"), filepath.Join(dir, "client-chain.key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: readPEM(t, filepath.Join(dir, "ca.crt"))[0]}))
+		for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+			serverConn, clientConn := net.Pipe()
+			errs := make(chan error, 1)
+			go func() {
+				errs <- tls.Server(serverConn, &tls.Config{
+					Certificates: []tls.Certificate{server},
+					ClientCAs:    roots,
+					ClientAuth:   tls.RequireAndVerifyClientCert,
+					MaxVersion:   version,
+				}).Handshake()
+				serverConn.Close()
+			}()
+			err := tls.Client(clientConn, &tls.Config{
+				Certificates: []tls.Certificate{client},
+				RootCAs:      roots,
+				ServerName:   "localhost",
+				MaxVersion:   version,
+			}).Handshake()
+			clientConn.Close()
+			if serverErr := <-errs; err != nil || serverErr != nil {
+				t.Errorf("%s over %s: client %v, server %v", tt.keyType, tls.VersionName(version), err, serverErr)
+			}
+		}
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-days", "0"},
+		{"-rsa-bits", "1024"},
+		{"-key-type", "dsa"},
+		{"-key-pass", "client.key"},
+		{"-key-pass", "prompt"},
+		{"-p12-pass", "pass:changeit"},
+		{"-p12", "-p12-pass", "client.key"},
+		{"-p12", "-p12-pass", "env:CERTGEN_TEST_UNSET"},
+		{"-hosts", " , "},
+		{"-clients", "/ci"},
+		{"-clients", "ci-runner//build"},
+		{"extra"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+
+	// -rsa-bits only applies to RSA keys
+	if _, err := parseFlags([]string{"-key-type", keyEd25519, "-rsa-bits", "1024"}); err != nil {
+		t.Errorf("-rsa-bits checked for Ed25519 keys: %v", err)
+	}
+
+	// Names that would share files are refused
+	if _, err := clientFiles([]clientName{{cn: "a"}, {cn: "b c"}, {cn: "b/c"}}); err == nil {
+		t.Error("clients sharing a file name accepted")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"mtls"
+)
+
+// PKCS #12 bundles (.p12) hold a client identity, its key and chain, for
+// Java keystores and the Windows certificate store, which do not read PEM.
+// They are encoded by the mtls module, which the other tools load them
+// with.
+
+// readPassphrase reads the passphrase given to flag name as for openssl
+// -passin: pass:<passphrase>, env:<variable> or file:<path>, its first
+// line. A passphrase to encrypt with is not asked for, as one typed wrong
+// would go unnoticed.
+func readPassphrase(name, source string) (string, error) {
+	if source == "prompt" {
+		return "", fmt.Errorf("-%s must be pass:<passphrase>, env:<variable> or file:<path>", name)
+	}
+	password, err := mtls.ReadPassphrase(source, "-"+name)
+	if err != nil {
+		return "", fmt.Errorf("-%s: %w", name, err)
+	}
+	return password, nil
+}
+
+// writePKCS12 writes id to path as a PKCS #12 bundle named name, with its
+// chain and root, encrypted with password.
+func (id *identity) writePKCS12(path, name, password string, root *x509.Certificate, legacy bool) error {
+	certs := append(append([][]byte{id.der}, id.chain...), root.Raw)
+	data, err := mtls.EncodePKCS12(id.key, certs, name, password, legacy)
+	if err != nil {
+		return fmt.Errorf("PKCS #12 bundle for %s: %w", name, err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0o600)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestPKCS12 checks that openssl opens the bundles -p12 writes, modern and
+// legacy, and finds the key, leaf and chain in them.
+func TestPKCS12(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("needs openssl")
+	}
+	for _, legacy := range []bool{false, true} {
+		dir := t.TempDir()
+		args := []string{"-out", dir, "-key-type", keyECDSAP256, "-clients", "test-client,java-client", "-p12", "-p12-pass", "pass:chângeit"}
+		if legacy {
+			args = append(args, "-p12-legacy")
+		}
+		opts, err := parseFlags(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := generate(opts, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+
+		for name, want := range map[string]int{"client": 2, "client-java-client": 2, "client-chain": 3} {
+			bundle := filepath.Join(dir, name+".p12")
+			out, err := exec.Command(openssl, "pkcs12", "-in", bundle, "-passin", "pass:chângeit", "-nodes").CombinedOutput()
+			if err != nil {
+				t.Fatalf("legacy %v: openssl pkcs12 %s: %v\n%s", legacy, name, err, out)
+			}
+			var certs, keys []byte
+			count := 0
+			for block, rest := pem.Decode(out); block != nil; block, rest = pem.Decode(rest) {
+				switch block.Type {
+				case "CERTIFICATE":
+					count++
+					if count == 1 {
+						certs = pem.EncodeToMemory(block)
+					}
+				case "PRIVATE KEY":
+					keys = pem.EncodeToMemory(block)
+				}
+			}
+			if count != want {
+				t.Errorf("legacy %v: %s.p12 holds %d certificates, want %d", legacy, name, count, want)
+			}
+			if _, err := tls.X509KeyPair(certs, keys); err != nil {
+				t.Errorf("legacy %v: %s.p12: %v", legacy, name, err)
+			}
+			if out, err := exec.Command(openssl, "pkcs12", "-in", bundle, "-passin", "pass:changeit", "-noout").CombinedOutput(); err == nil {
+				t.Errorf("legacy %v: %s.p12 opened with the wrong passphrase\n%s", legacy, name, out)
+			}
+		}
+	}
+}
+
+// TestEncryptedKeys checks that openssl decrypts the keys -key-pass
+// writes, and that they still match their certificates.
+func TestEncryptedKeys(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("needs openssl")
+	}
+	dir := t.TempDir()
+	t.Setenv("CERTGEN_TEST_KEY_PASS", "battery-staple")
+	opts, err := parseFlags([]string{"-out", dir, "-key-type", keyECDSAP256, "-key-pass", "env:CERTGEN_TEST_KEY_PASS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := generate(opts, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"ca", "intermediate", "server", "client", "client-chain", "revoked"} {
+		keyFile := filepath.Join(dir, name+".key")
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block, _ := pem.Decode(data); block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+			t.Fatalf("%s.key is not an encrypted PKCS #8 key", name)
+		}
+		key, err := exec.Command(openssl, "pkey", "-in", keyFile, "-passin", "pass:battery-staple").CombinedOutput()
+		if err != nil {
+			t.Fatalf("openssl pkey %s.key: %v\n%s", name, err, key)
+		}
+		cert, err := os.ReadFile(filepath.Join(dir, name+".crt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tls.X509KeyPair(cert, key); err != nil {
+			t.Errorf("%s.key: %v", name, err)
+		}
+		if out, err := exec.Command(openssl, "pkey", "-in", keyFile, "-passin", "pass:correct-horse", "-noout").CombinedOutput(); err == nil {
+			t.Errorf("%s.key decrypted with the wrong passphrase\n%s", name, out)
+		}
+	}
+}
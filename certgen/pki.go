@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"mtls"
+)
+
+// authority is a CA that certificates are issued from.
+type authority struct {
+	cert  *x509.Certificate
+	key   crypto.Signer
+	chain [][]byte // DER, sent after the certificates it issues: none for a root
+}
+
+// identity is a certificate and its key, as issued.
+type identity struct {
+	cert  *x509.Certificate
+	der   []byte
+	chain [][]byte // its issuer's chain, written after it
+	key   crypto.Signer
+}
+
+// Key algorithms, for -key-type.
+const (
+	keyRSA       = "rsa"
+	keyECDSAP256 = "ecdsa-p256"
+	keyECDSAP384 = "ecdsa-p384"
+	keyEd25519   = "ed25519"
+)
+
+// keySpec is the kind of key each certificate gets: an algorithm, and the
+// size of RSA keys.
+type keySpec struct {
+	algorithm string
+	rsaBits   int
+}
+
+// newKey makes a key for a CA or leaf certificate.
+func (spec keySpec) newKey() (crypto.Signer, error) {
+	switch spec.algorithm {
+	case keyECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case keyECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case keyEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return rsa.GenerateKey(rand.Reader, spec.rsaBits)
+}
+
+// serialNumber returns a random 128-bit serial, so certificates from
+// separate runs against the same CA never share one.
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// validity returns the window a certificate issued now is valid for: from
+// an hour ago, to allow for clock skew, for days.
+func validity(days int) (notBefore, notAfter time.Time) {
+	now := time.Now()
+	return now.Add(-time.Hour), now.AddDate(0, 0, days)
+}
+
+// caTemplate is a CA certificate for subject.
+func caTemplate(subject pkix.Name) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:  subject,
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IsCA:     true,
+	}
+}
+
+// newAuthority makes a self-signed root CA named subject.
+func newAuthority(subject pkix.Name, days int, keys keySpec) (*authority, error) {
+	key, err := keys.newKey()
+	if err != nil {
+		return nil, fmt.Errorf("generate CA key: %w", err)
+	}
+	template := caTemplate(subject)
+	if template.SerialNumber, err = serialNumber(); err != nil {
+		return nil, err
+	}
+	template.NotBefore, template.NotAfter = validity(days)
+	template.BasicConstraintsValid = true
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &authority{cert: cert, key: key}, nil
+}
+
+// intermediate issues a CA named subject under this one that may sign
+// only leaf certificates, as pathlen:0 says.
+func (ca *authority) intermediate(subject pkix.Name, days int, keys keySpec) (*authority, error) {
+	template := caTemplate(subject)
+	template.MaxPathLenZero = true
+	id, err := ca.issue(template, days, keys)
+	if err != nil {
+		return nil, err
+	}
+	return &authority{cert: id.cert, key: id.key, chain: append([][]byte{id.der}, id.chain...)}, nil
+}
+
+// issue makes a key and a certificate for it from template, which gives
+// the subject, names and key usage, signed by the CA. The certificate
+// expires with the CA at the latest, since it cannot be verified after.
+func (ca *authority) issue(template *x509.Certificate, days int, keys keySpec) (*identity, error) {
+	key, err := keys.newKey()
+	if err != nil {
+		return nil, fmt.Errorf("generate key for %s: %w", template.Subject.CommonName, err)
+	}
+	// Only an RSA key can encrypt a TLS 1.2 key exchange
+	if _, ok := key.(*rsa.PrivateKey); !ok {
+		template.KeyUsage &^= x509.KeyUsageKeyEncipherment
+	}
+	if template.SerialNumber, err = serialNumber(); err != nil {
+		return nil, err
+	}
+	template.NotBefore, template.NotAfter = validity(days)
+	if template.NotAfter.After(ca.cert.NotAfter) {
+		template.NotAfter = ca.cert.NotAfter
+	}
+	template.BasicConstraintsValid = true
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate for %s: %w", template.Subject.CommonName, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &identity{cert: cert, der: der, chain: ca.chain, key: key}, nil
+}
+
+// serverTemplate is a server certificate for hosts, names or IP addresses.
+func serverTemplate(subject pkix.Name, hosts []string) *x509.Certificate {
+	template := &x509.Certificate{
+		Subject:     subject,
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return template
+}
+
+// clientTemplate is a client certificate for subject.
+func clientTemplate(subject pkix.Name) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:     subject,
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+}
+
+// revocationList makes a CRL, signed by the CA, listing revoked.
+func (ca *authority) revocationList(revoked []*identity, days int) ([]byte, error) {
+	now := time.Now()
+	list := &x509.RevocationList{
+		Number:     big.NewInt(now.Unix()),
+		ThisUpdate: now.Add(-time.Hour),
+		NextUpdate: now.AddDate(0, 0, days),
+	}
+	for _, id := range revoked {
+		list.RevokedCertificateEntries = append(list.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   id.cert.SerialNumber,
+			RevocationTime: now,
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, list, ca.cert, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("create CRL: %w", err)
+	}
+	return der, nil
+}
+
+// writePEM writes blocks of typ to path, readable only by the owner if
+// private.
+func writePEM(path, typ string, private bool, blocks ...[]byte) error {
+	mode := os.FileMode(0o644)
+	if private {
+		mode = 0o600
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	for _, der := range blocks {
+		if err := pem.Encode(f, &pem.Block{Type: typ, Bytes: der}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// An existing file keeps its mode through O_TRUNC
+	return os.Chmod(path, mode)
+}
+
+// writeKey writes key to path as PKCS #8, as openssl genrsa does, and
+// encrypted with password, as openssl pkey -aes256 does, unless it is
+// empty.
+func writeKey(path string, key crypto.Signer, password string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if password == "" {
+		return writePEM(path, "PRIVATE KEY", true, der)
+	}
+	block, err := mtls.EncryptKey(key, password)
+	if err != nil {
+		return err
+	}
+	return writePEM(path, block.Type, true, block.Bytes)
+}
+
+// write writes id to dir as name.crt, followed by its issuer's chain, and
+// name.key, encrypted with password unless it is empty.
+func (id *identity) write(dir, name, password string) error {
+	if err := writePEM(filepath.Join(dir, name+".crt"), "CERTIFICATE", false, append([][]byte{id.der}, id.chain...)...); err != nil {
+		return err
+	}
+	return writeKey(filepath.Join(dir, name+".key"), id.key, password)
+}
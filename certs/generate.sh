@@ -2,6 +2,10 @@
 #
 # Generate certificates for mTLS authentication
 # Usage: ./generate.sh
+#        KEY_TYPE=ecdsa-p256 ./generate.sh   (rsa, ecdsa-p256, ecdsa-p384 or ed25519)
+#        P12_PASS=changeit ./generate.sh     (also write client.p12 and client-chain.p12;
+#                                             P12_LEGACY=1 for triple DES and a SHA-1 MAC)
+#        KEY_PASS=changeit ./generate.sh     (encrypt every key with AES-256)
 #
 
 set -e
@@ -9,12 +13,32 @@ set -e
 SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
 cd "$SCRIPT_DIR"
 
-echo "Generating mTLS certificates..."
+echo "Generating mTLS certificates ($KEY_TYPE keys)..."
 echo ""
 
 # Configuration
 DAYS=365
 KEY_SIZE=4096
+KEY_TYPE=${KEY_TYPE:-rsa}
+
+# Key usage of the leaf certificates: only an RSA key can encrypt a TLS 1.2
+# key exchange
+LEAF_KEY_USAGE="digitalSignature, nonRepudiation"
+case "$KEY_TYPE" in
+    rsa) LEAF_KEY_USAGE="$LEAF_KEY_USAGE, keyEncipherment, dataEncipherment" ;;
+    ecdsa-p256|ecdsa-p384|ed25519) ;;
+    *) echo "Unknown KEY_TYPE $KEY_TYPE: must be rsa, ecdsa-p256, ecdsa-p384 or ed25519" >&2; exit 1 ;;
+esac
+
+# genkey writes a new private key of KEY_TYPE to $1
+genkey() {
+    case "$KEY_TYPE" in
+        rsa) openssl genrsa -out "$1" $KEY_SIZE 2>/dev/null ;;
+        ecdsa-p256) openssl genpkey -algorithm EC -pkeyopt ec_paramgen_curve:P-256 -out "$1" ;;
+        ecdsa-p384) openssl genpkey -algorithm EC -pkeyopt ec_paramgen_curve:P-384 -out "$1" ;;
+        ed25519) openssl genpkey -algorithm ed25519 -out "$1" ;;
+    esac
+}
 
 # CA details
 CA_SUBJ="/C=US/ST=Test/L=Test/O=MockOpenAI/CN=MockOpenAI-CA"
@@ -30,22 +54,25 @@ echo "Cleaning up old certificates..."
 rm -f ca.key ca.crt ca.srl
 rm -f server.key server.csr server.crt server.ext
 rm -f client.key client.csr client.crt client.ext
+rm -f revoked.key revoked.csr revoked.crt crl.pem
+rm -f intermediate.key intermediate.crt server-chain.key server-chain.crt client-chain.key client-chain.crt
+rm -f client.p12 client-chain.p12
 
 # Generate CA
 echo "Generating CA certificate..."
-openssl genrsa -out ca.key $KEY_SIZE 2>/dev/null
+genkey ca.key
 openssl req -new -x509 -days $DAYS -key ca.key -out ca.crt -subj "$CA_SUBJ"
 echo "  Created: ca.key, ca.crt"
 
 # Generate Server certificate
 echo "Generating server certificate..."
-openssl genrsa -out server.key $KEY_SIZE 2>/dev/null
+genkey server.key
 openssl req -new -key server.key -out server.csr -subj "$SERVER_SUBJ"
 
 cat > server.ext << EOF
 authorityKeyIdentifier=keyid,issuer
 basicConstraints=CA:FALSE
-keyUsage = digitalSignature, nonRepudiation, keyEncipherment, dataEncipherment
+keyUsage = $LEAF_KEY_USAGE
 subjectAltName = @alt_names
 
 [alt_names]
@@ -60,13 +87,13 @@ echo "  Created: server.key, server.crt"
 
 # Generate Client certificate
 echo "Generating client certificate..."
-openssl genrsa -out client.key $KEY_SIZE 2>/dev/null
+genkey client.key
 openssl req -new -key client.key -out client.csr -subj "$CLIENT_SUBJ"
 
 cat > client.ext << EOF
 authorityKeyIdentifier=keyid,issuer
 basicConstraints=CA:FALSE
-keyUsage = digitalSignature, nonRepudiation, keyEncipherment, dataEncipherment
+keyUsage = $LEAF_KEY_USAGE
 extendedKeyUsage = clientAuth
 EOF
 
@@ -74,8 +101,92 @@ openssl x509 -req -in client.csr -CA ca.crt -CAkey ca.key -CAcreateserial \
     -out client.crt -days $DAYS -extfile client.ext 2>/dev/null
 echo "  Created: client.key, client.crt"
 
+# Generate a client certificate and revoke it, for negative mTLS tests
+echo "Generating revoked client certificate and CRL..."
+genkey revoked.key
+openssl req -new -key revoked.key -out revoked.csr -subj "/C=US/ST=Test/L=Test/O=MockOpenAI/CN=revoked-client"
+openssl x509 -req -in revoked.csr -CA ca.crt -CAkey ca.key -CAcreateserial \
+    -out revoked.crt -days $DAYS -extfile client.ext 2>/dev/null
+
+mkdir -p crl-db
+touch crl-db/index.txt
+echo 1000 > crl-db/crlnumber
+cat > crl-db/ca.cnf << EOF
+[ ca ]
+default_ca = crl_ca
+
+[ crl_ca ]
+database = crl-db/index.txt
+crlnumber = crl-db/crlnumber
+default_md = sha256
+default_crl_days = $DAYS
+EOF
+
+openssl ca -config crl-db/ca.cnf -cert ca.crt -keyfile ca.key -revoke revoked.crt 2>/dev/null
+openssl ca -config crl-db/ca.cnf -cert ca.crt -keyfile ca.key -gencrl -out crl.pem 2>/dev/null
+rm -rf crl-db
+echo "  Created: revoked.key, revoked.crt, crl.pem"
+
+# Generate an intermediate CA and certificates issued by it, sent with
+# their chain, for the intermediate CA chain tests
+echo "Generating intermediate CA and chained certificates..."
+genkey intermediate.key
+openssl req -new -key intermediate.key -out intermediate.csr -subj "/C=US/ST=Test/L=Test/O=MockOpenAI/CN=MockOpenAI-Intermediate-CA"
+
+cat > intermediate.ext << EOF
+authorityKeyIdentifier=keyid,issuer
+basicConstraints=critical,CA:TRUE,pathlen:0
+keyUsage = critical, keyCertSign, cRLSign
+EOF
+
+openssl x509 -req -in intermediate.csr -CA ca.crt -CAkey ca.key -CAcreateserial \
+    -out intermediate.crt -days $DAYS -extfile intermediate.ext 2>/dev/null
+
+genkey server-chain.key
+openssl req -new -key server-chain.key -out server-chain.csr -subj "$SERVER_SUBJ"
+openssl x509 -req -in server-chain.csr -CA intermediate.crt -CAkey intermediate.key -CAcreateserial \
+    -out server-chain.crt -days $DAYS -extfile server.ext 2>/dev/null
+cat intermediate.crt >> server-chain.crt
+
+genkey client-chain.key
+openssl req -new -key client-chain.key -out client-chain.csr -subj "/C=US/ST=Test/L=Test/O=MockOpenAI/CN=chain-client"
+openssl x509 -req -in client-chain.csr -CA intermediate.crt -CAkey intermediate.key -CAcreateserial \
+    -out client-chain.crt -days $DAYS -extfile client.ext 2>/dev/null
+cat intermediate.crt >> client-chain.crt
+rm -f intermediate.srl
+echo "  Created: intermediate.key, intermediate.crt, server-chain.key/.crt, client-chain.key/.crt"
+
+# Export the client identities, with their chain and the CA, as PKCS #12
+# bundles for Java and Windows consumers, if P12_PASS is set
+if [ -n "${P12_PASS+set}" ]; then
+    echo "Exporting PKCS #12 bundles..."
+    P12_OPTS=""
+    if [ -n "$P12_LEGACY" ]; then
+        P12_OPTS="-keypbe PBE-SHA1-3DES -certpbe PBE-SHA1-3DES -macalg sha1"
+    fi
+    openssl pkcs12 -export -in client.crt -inkey client.key -certfile ca.crt \
+        -name test-client -passout env:P12_PASS $P12_OPTS -out client.p12
+    openssl pkcs12 -export -in client-chain.crt -inkey client-chain.key -certfile ca.crt \
+        -name chain-client -passout env:P12_PASS $P12_OPTS -out client-chain.p12
+    chmod 600 client.p12 client-chain.p12
+    echo "  Created: client.p12, client-chain.p12"
+fi
+
+# Encrypt every key with KEY_PASS, if it is set, once nothing more is
+# signed with them
+if [ -n "${KEY_PASS+set}" ]; then
+    echo "Encrypting private keys..."
+    for key in ca.key server.key client.key revoked.key intermediate.key server-chain.key client-chain.key; do
+        openssl pkey -in "$key" -aes256 -passout env:KEY_PASS -out "$key.enc"
+        mv "$key.enc" "$key"
+        chmod 600 "$key"
+    done
+    echo "  Encrypted: every .key, as PKCS #8 with AES-256"
+fi
+
 # Clean up CSR and extension files
-rm -f server.csr server.ext client.csr client.ext
+rm -f server.csr server.ext client.csr client.ext revoked.csr
+rm -f intermediate.csr intermediate.ext server-chain.csr client-chain.csr
 
 echo ""
 echo "Certificate generation complete!"
@@ -84,7 +195,16 @@ echo "Files created:"
 echo "  CA:     ca.crt, ca.key"
 echo "  Server: server.crt, server.key"
 echo "  Client: client.crt, client.key"
+echo "  Revoked client: revoked.crt, revoked.key (listed in crl.pem)"
+echo "  Intermediate CA: intermediate.crt, intermediate.key"
+echo "  Chained server/client: server-chain.crt/.key, client-chain.crt/.key (leaf + intermediate)"
+if [ -n "${P12_PASS+set}" ]; then
+    echo "  PKCS #12: client.p12, client-chain.p12"
+fi
+if [ -n "${KEY_PASS+set}" ]; then
+    echo "  Keys are encrypted: give their passphrase with -key-pass, or type it when asked"
+fi
 echo ""
 echo "Usage:"
-echo "  Server: ./openai-mock-server -cert ../certs/server.crt -key ../certs/server.key -ca ../certs/ca.crt"
+echo "  Server: ./openai-mock-server -cert ../certs/server.crt -key ../certs/server.key -ca ../certs/ca.crt -crl ../certs/crl.pem"
 echo "  Client: ./openai-test-client -cert ../certs/client.crt -key ../certs/client.key -ca ../certs/ca.crt"
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// parseClientRanges parses -allow-clients: comma-separated CIDR ranges,
+// such as 10.0.0.0/8, or single addresses.
+func parseClientRanges(list string) ([]netip.Prefix, error) {
+	var ranges []netip.Prefix
+	for _, r := range strings.Split(list, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		if !strings.Contains(r, "/") {
+			addr, err := netip.ParseAddr(r)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", r)
+			}
+			ranges = append(ranges, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q", r)
+		}
+		ranges = append(ranges, prefix.Masked())
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no ranges given")
+	}
+	return ranges, nil
+}
+
+// Access is the access section of -config: who may use the proxy and, in
+// forward mode, what it may reach. Each setting given takes the place of
+// its flag.
+type Access struct {
+	AllowClients  []string `yaml:"allow_clients"`
+	AllowHosts    []string `yaml:"allow_hosts"`
+	DenyHosts     []string `yaml:"deny_hosts"`
+	ProxyAuthFile string   `yaml:"proxy_auth_file"`
+
+	clients    []netip.Prefix
+	allowHosts hostPatterns
+	denyHosts  hostPatterns
+	proxyAuth  *proxyAuth
+}
+
+func (a *Access) setDefaults() error {
+	if len(a.AllowClients) > 0 {
+		clients, err := parseClientRanges(strings.Join(a.AllowClients, ","))
+		if err != nil {
+			return fmt.Errorf("allow_clients: %w", err)
+		}
+		a.clients = clients
+	}
+	a.allowHosts = parseHostPatterns(strings.Join(a.AllowHosts, ","))
+	a.denyHosts = parseHostPatterns(strings.Join(a.DenyHosts, ","))
+	if a.ProxyAuthFile != "" {
+		auth, err := loadProxyAuth(a.ProxyAuthFile)
+		if err != nil {
+			return fmt.Errorf("proxy_auth_file: %w", err)
+		}
+		a.proxyAuth = auth
+	}
+	return nil
+}
+
+// inRanges reports whether addr, a connection's remote address, is in one
+// of ranges.
+func inRanges(ranges []netip.Prefix, addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip := tcp.AddrPort().Addr().Unmap()
+	for _, r := range ranges {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAllowed reports whether a client connecting from addr may use the
+// proxy: whether it is in the -allow-clients ranges, if there are any.
+// Clients of -listen-unix are let in by the socket's permissions instead.
+func (p *ProxyServer) clientAllowed(addr net.Addr) bool {
+	if _, ok := addr.(*net.UnixAddr); ok {
+		return true
+	}
+	p.policyMu.RLock()
+	defer p.policyMu.RUnlock()
+	return p.allowClients == nil || inRanges(p.allowClients, addr)
+}
+
+// clientListener accepts connections only from clients the proxy allows.
+// Others are closed as soon as they are accepted, before the TLS handshake
+// or a byte of the request is read.
+type clientListener struct {
+	net.Listener
+	proxy *ProxyServer
+	name  string // the listener, for the log and metrics: http or socks
+}
+
+func (l *clientListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil || l.proxy.clientAllowed(conn.RemoteAddr()) {
+			return conn, err
+		}
+		log.Printf("[DENIED] Connection from %s to the %s listener: not an allowed client", conn.RemoteAddr(), l.name)
+		l.proxy.metrics.connectionRefused(l.name)
+		conn.Close()
+	}
+}
+
+// destination returns the host (with port, if given) that a forward-mode
+// request asks the proxy to reach.
+func destination(r *http.Request) string {
+	if r.Method != http.MethodConnect && r.URL.Host != "" {
+		return r.URL.Host
+	}
+	return r.Host
+}
+
+// hostAllowed applies -allow-hosts and -deny-hosts to a destination. A
+// denied host is refused even if it is also allowed; with no allowlist
+// every host not denied is allowed.
+func (p *ProxyServer) hostAllowed(host string) bool {
+	p.policyMu.RLock()
+	defer p.policyMu.RUnlock()
+	if p.denyHosts.match(host) {
+		return false
+	}
+	return len(p.allowHosts) == 0 || p.allowHosts.match(host)
+}
+
+// checkDestination refuses a forward-mode request to a host that is not
+// allowed, with 403, and reports whether it may go ahead.
+func (p *ProxyServer) checkDestination(w http.ResponseWriter, r *http.Request) bool {
+	host := destination(r)
+	if p.hostAllowed(host) {
+		return true
+	}
+	log.Printf("[DENIED] %s %s from %s", r.Method, host, r.RemoteAddr)
+	http.Error(w, "Destination host not allowed by proxy policy", http.StatusForbidden)
+	return false
+}
+
+// parsePorts parses a comma-separated list of ports, or "*" for any port,
+// which is returned as a nil set.
+func parsePorts(list string) (map[string]bool, error) {
+	ports := make(map[string]bool)
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if p == "*" {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("invalid port %q", p)
+		}
+		ports[strconv.Itoa(n)] = true
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no ports given; use * to allow any")
+	}
+	return ports, nil
+}
+
+// checkConnectPort refuses, with 403, a CONNECT tunnel to a port that is
+// not in -connect-ports, and reports whether it may go ahead.
+func (p *ProxyServer) checkConnectPort(w http.ResponseWriter, r *http.Request) bool {
+	if p.connectPorts == nil {
+		return true
+	}
+	_, port, err := net.SplitHostPort(r.Host)
+	if err == nil && p.connectPorts[port] {
+		return true
+	}
+	log.Printf("[DENIED] CONNECT %s from %s: port not allowed", r.Host, r.RemoteAddr)
+	http.Error(w, "CONNECT to this port not allowed by proxy policy", http.StatusForbidden)
+	return false
+}
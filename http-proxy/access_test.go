@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHostAllowed(t *testing.T) {
+	p := &ProxyServer{
+		allowHosts: parseHostPatterns("api.openai.com,*.azure.com"),
+		denyHosts:  parseHostPatterns("blocked.azure.com"),
+	}
+	for host, want := range map[string]bool{
+		"api.openai.com:443":    true,
+		"x.azure.com":           true,
+		"blocked.azure.com:443": false,
+		"example.com":           false,
+		"10.0.0.1:443":          false,
+	} {
+		if got := p.hostAllowed(host); got != want {
+			t.Errorf("hostAllowed(%q) = %v, want %v", host, got, want)
+		}
+	}
+
+	open := &ProxyServer{denyHosts: parseHostPatterns("metadata.google.internal")}
+	if !open.hostAllowed("example.com") || open.hostAllowed("metadata.google.internal:80") {
+		t.Error("with only a denylist, other hosts should be allowed and the denied one refused")
+	}
+}
+
+func TestDestinationPolicy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	proxy := httptest.NewServer(&ProxyServer{allowHosts: parseHostPatterns(upstreamHost)})
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	for target, want := range map[string]int{
+		upstream.URL:              http.StatusOK,
+		"http://127.0.0.2:1/":     http.StatusForbidden,
+		"http://example.invalid/": http.StatusForbidden,
+	} {
+		resp, err := client.Get(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s: got %d, want %d", target, resp.StatusCode, want)
+		}
+	}
+
+	for target, want := range map[string]int{
+		upstreamHost:          http.StatusOK,
+		"example.invalid:443": http.StatusForbidden,
+	} {
+		conn, err := net.Dial("tcp", proxyURL.Host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			t.Errorf("CONNECT %s: got %d, want %d", target, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestConnectPorts(t *testing.T) {
+	ports, err := parsePorts("443, 8443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(&ProxyServer{connectPorts: ports})
+	defer proxy.Close()
+
+	for target, want := range map[string]int{
+		"example.invalid:22":   http.StatusForbidden,
+		"example.invalid":      http.StatusForbidden,
+		"example.invalid:8443": http.StatusServiceUnavailable, // allowed, but the dial fails
+	} {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			t.Errorf("CONNECT %s: got %d, want %d", target, resp.StatusCode, want)
+		}
+	}
+
+	if ports, err := parsePorts("*"); err != nil || ports != nil {
+		t.Errorf(`parsePorts("*") = %v, %v; want any port`, ports, err)
+	}
+	for _, bad := range []string{"", "https", "0", "70000"} {
+		if _, err := parsePorts(bad); err == nil {
+			t.Errorf("parsePorts(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestClientRanges(t *testing.T) {
+	ranges, err := parseClientRanges("10.0.0.0/8, 192.168.1.7, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"10.1.2.3:5000":        true,
+		"[::ffff:10.1.2.3]:80": true,
+		"192.168.1.7:5000":     true,
+		"192.168.1.8:5000":     false,
+		"[fd12::1]:5000":       true,
+		"[2001:db8::1]:5000":   false,
+		"127.0.0.1:5000":       false,
+	} {
+		tcp, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := inRanges(ranges, tcp); got != want {
+			t.Errorf("inRanges(%s) = %v, want %v", addr, got, want)
+		}
+	}
+	if inRanges(ranges, &net.UnixAddr{Name: "/tmp/x", Net: "unix"}) {
+		t.Error("a non-TCP address was allowed")
+	}
+
+	for _, bad := range []string{"", " , ", "10.0.0.0/33", "example.com", "10.0.0"} {
+		if _, err := parseClientRanges(bad); err == nil {
+			t.Errorf("parseClientRanges(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestClientListener(t *testing.T) {
+	for _, tt := range []struct {
+		allow string
+		ok    bool
+	}{
+		{"127.0.0.0/8,::1", true},
+		{"10.0.0.0/8", false},
+	} {
+		ranges, _ := parseClientRanges(tt.allow)
+		p := &ProxyServer{allowClients: ranges, metrics: newMetrics()}
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.Listener = &clientListener{Listener: server.Listener, proxy: p, name: "http"}
+		server.Start()
+
+		resp, err := http.Get(server.URL)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("allowing %s: %v", tt.allow, err)
+			} else {
+				resp.Body.Close()
+			}
+		} else {
+			if err == nil {
+				resp.Body.Close()
+				t.Errorf("allowing %s: got %d, want the connection closed", tt.allow, resp.StatusCode)
+			}
+			if !strings.Contains(scrape(t, p), `http_proxy_connections_refused_total{listener="http"} 1`) {
+				t.Errorf("allowing %s: refused connection not counted", tt.allow)
+			}
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// accessRecord is the access log entry for one request or tunnel, filled
+// in as it is served.
+type accessRecord struct {
+	Time              time.Time   `json:"time"`
+	RequestID         string      `json:"request_id,omitempty"`
+	Client            string      `json:"client"`
+	User              string      `json:"user,omitempty"`
+	Method            string      `json:"method"`
+	Host              string      `json:"host"`
+	Path              string      `json:"path,omitempty"`
+	Proto             string      `json:"proto"`
+	Status            int         `json:"status"`
+	BytesIn           int64       `json:"bytes_in"`
+	BytesOut          int64       `json:"bytes_out"`
+	DurationMS        float64     `json:"duration_ms"`
+	Upstream          string      `json:"upstream,omitempty"`
+	UpstreamProto     string      `json:"upstream_proto,omitempty"`
+	UpstreamRequestID string      `json:"upstream_request_id,omitempty"`
+	SNI               string      `json:"sni,omitempty"`
+	CloseReason       string      `json:"close_reason,omitempty"`
+	CloseError        string      `json:"close_error,omitempty"`
+	TLS               *tlsDetails `json:"tls,omitempty"`
+	UpstreamTLS       *tlsDetails `json:"upstream_tls,omitempty"`
+	Referer           string      `json:"referer,omitempty"`
+	UserAgent         string      `json:"user_agent,omitempty"`
+
+	uri    string       // the request target, for the combined format
+	bodyIn atomic.Int64 // request body bytes, added to BytesIn
+	end    tunnelEnd    // how a tunnel ended, for CloseReason and CloseError
+}
+
+// tlsDetails describe a TLS connection: the client's to the proxy, or the
+// proxy's to the upstream.
+type tlsDetails struct {
+	Version    string `json:"version"`
+	Cipher     string `json:"cipher"`
+	ServerName string `json:"server_name,omitempty"`
+	PeerCert   string `json:"peer_cert,omitempty"`
+}
+
+func describeTLS(state *tls.ConnectionState) *tlsDetails {
+	if state == nil {
+		return nil
+	}
+	details := &tlsDetails{
+		Version:    tls.VersionName(state.Version),
+		Cipher:     tls.CipherSuiteName(state.CipherSuite),
+		ServerName: state.ServerName,
+	}
+	if len(state.PeerCertificates) > 0 {
+		details.PeerCert = state.PeerCertificates[0].Subject.String()
+	}
+	return details
+}
+
+// accessKey is the context key for a request's accessRecord.
+type accessKey struct{}
+
+// recordOf returns the access record of a request served by ServeHTTP, or
+// a throwaway one for requests that were not.
+func recordOf(r *http.Request) *accessRecord {
+	if rec, ok := r.Context().Value(accessKey{}).(*accessRecord); ok {
+		return rec
+	}
+	return &accessRecord{}
+}
+
+// startRecord begins the access record for r, with its request ID, and
+// returns w and r wrapped to fill it in.
+func startRecord(w http.ResponseWriter, r *http.Request) (*accessRecord, http.ResponseWriter, *http.Request) {
+	rec := &accessRecord{
+		Time:      time.Now(),
+		Client:    r.RemoteAddr,
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+		Proto:     r.Proto,
+		TLS:       describeTLS(r.TLS),
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+		uri:       r.RequestURI,
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		rec.Client = host
+	}
+	setRequestID(r, rec)
+	r = r.WithContext(context.WithValue(r.Context(), accessKey{}, rec))
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &countingBody{ReadCloser: r.Body, n: &rec.bodyIn}
+	}
+	return rec, &accessWriter{ResponseWriter: w, rec: rec}, r
+}
+
+// accessWriter records the status and size of a response, and gives it
+// the request's ID. It passes flushes and hijacks through, for streams
+// and tunnels.
+type accessWriter struct {
+	http.ResponseWriter
+	rec *accessRecord
+}
+
+func (w *accessWriter) WriteHeader(code int) {
+	if w.rec.Status == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.rec.Status = code
+		w.addRequestID()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	if w.rec.Status == 0 {
+		w.rec.Status = http.StatusOK
+		w.addRequestID()
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.rec.BytesOut += int64(n)
+	return n, err
+}
+
+// addRequestID puts the request's ID on the response, whether the proxy
+// made it or the upstream did, unless the upstream gave its own.
+func (w *accessWriter) addRequestID() {
+	if w.rec.RequestID != "" && w.Header().Get(requestIDHeader) == "" {
+		w.Header().Set(requestIDHeader, w.rec.RequestID)
+	}
+}
+
+func (w *accessWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *accessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *accessWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// countingBody counts the bytes read from a request body. The transport
+// may still be reading it when the record is logged, hence the atomic.
+type countingBody struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// accessLogger writes access records as JSON lines or in Apache combined
+// format, for -access-log.
+type accessLogger struct {
+	format string
+	mu     sync.Mutex
+	out    io.Writer
+}
+
+func newAccessLogger(format string, out io.Writer) (*accessLogger, error) {
+	if format != "json" && format != "combined" {
+		return nil, fmt.Errorf("invalid -access-log %q: must be text, json or combined", format)
+	}
+	return &accessLogger{format: format, out: out}, nil
+}
+
+// logAccess writes rec, finished now, to the access log, or as the usual
+// log line without one.
+func (p *ProxyServer) logAccess(rec *accessRecord) {
+	duration := time.Since(rec.Time)
+	rec.DurationMS = float64(duration.Microseconds()) / 1000
+	rec.BytesIn += rec.bodyIn.Load()
+	rec.CloseReason = rec.end.reason
+	if rec.end.err != nil {
+		rec.CloseError = rec.end.err.Error()
+	}
+	p.metrics.request(rec)
+	p.stats.record(rec)
+	l := p.accessLog
+	if l == nil {
+		switch {
+		case rec.CloseReason != "":
+			// A tunnel's audit line
+			log.Printf("[TUNNEL] %s %s -> %s (%s): %v, %d bytes from client, %d from server, %s",
+				tunnelProto(rec), rec.Client, rec.Host, rec.Upstream, duration, rec.BytesIn, rec.BytesOut, describeTunnelEnd(rec))
+		case rec.Proto != socksProto:
+			details := []string{duration.String()}
+			if rec.UpstreamProto != "" {
+				details = append(details, "upstream "+rec.UpstreamProto)
+			}
+			details = append(details, "id "+rec.RequestID)
+			log.Printf("[%s] %s %s (%s)", rec.Method, rec.Host, rec.Path, strings.Join(details, ", "))
+		case rec.Status == http.StatusOK:
+			log.Printf("[SOCKS] %s from %s (%v)", rec.Host, rec.Client, duration)
+		}
+		return
+	}
+
+	var line []byte
+	if l.format == "json" {
+		line, _ = json.Marshal(rec)
+	} else {
+		line = fmt.Appendf(nil, "%s - %s [%s] %q %d %s %q %q",
+			rec.Client, orDash(rec.User), rec.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			rec.Method+" "+rec.uri+" "+rec.Proto, rec.Status, combinedBytes(rec.BytesOut),
+			orDash(rec.Referer), orDash(rec.UserAgent))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(line, '\n'))
+}
+
+// tunnelProto names the kind of tunnel rec is for: CONNECT or SOCKS5.
+func tunnelProto(rec *accessRecord) string {
+	if rec.Proto == socksProto {
+		return socksProto
+	}
+	return rec.Method
+}
+
+// describeTunnelEnd says why a tunnel ended, for its log line.
+func describeTunnelEnd(rec *accessRecord) string {
+	if rec.CloseError != "" {
+		return rec.CloseReason + ": " + rec.CloseError
+	}
+	return rec.CloseReason
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func combinedBytes(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lines collects what an accessLogger writes, a line at a time.
+type lines chan string
+
+func (l lines) Write(b []byte) (int, error) {
+	l <- string(b)
+	return len(b), nil
+}
+
+func (l lines) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case line := <-l:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("no access record")
+		return ""
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "hello")
+	}))
+	defer upstream.Close()
+
+	out := make(lines, 10)
+	accessLog, err := newAccessLogger("json", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := loadProxyAuth(writeProxyAuth(t, "alice:s3cret\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(&ProxyServer{accessLog: accessLog, proxyAuth: auth})
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("alice", "s3cret")
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Post(upstream.URL+"/v1/embeddings", "application/json", strings.NewReader(`{"input":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	var rec accessRecord
+	if err := json.Unmarshal([]byte(out.next(t)), &rec); err != nil {
+		t.Fatal(err)
+	}
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+	if rec.Client != "127.0.0.1" || rec.User != "alice" || rec.Method != http.MethodPost || rec.Host != upstreamHost ||
+		rec.Path != "/v1/embeddings" || rec.Status != http.StatusOK || rec.BytesIn != 13 || rec.BytesOut != 5 ||
+		rec.Upstream != upstreamHost || rec.UpstreamProto != "HTTP/1.1" || rec.DurationMS <= 0 {
+		t.Errorf("got %+v", &rec)
+	}
+
+	// A tunnel is one record, with the bytes sent through it
+	tlsUpstream := httptest.NewTLSServer(upstream.Config.Handler)
+	defer tlsUpstream.Close()
+	transport := tlsUpstream.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	transport.DisableKeepAlives = true
+	resp, err = (&http.Client{Transport: transport}).Get(tlsUpstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	rec = accessRecord{}
+	if err := json.Unmarshal([]byte(out.next(t)), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Method != http.MethodConnect || rec.Status != http.StatusOK || rec.BytesIn == 0 || rec.BytesOut == 0 ||
+		rec.Upstream != strings.TrimPrefix(tlsUpstream.URL, "https://") {
+		t.Errorf("tunnel: got %+v", &rec)
+	}
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "missing", http.StatusNotFound)
+	}))
+	defer upstream.Close()
+	target, err := parseUpstream(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(lines, 10)
+	accessLog, err := newAccessLogger("combined", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(&ProxyServer{
+		reverse:   newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false),
+		upstream:  singlePool(target),
+		accessLog: accessLog,
+	})
+	defer proxy.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/v1/models/nope?x=1", nil)
+	req.Header.Set("User-Agent", "test-client/1.0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := regexp.MustCompile(`^127\.0\.0\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}\] "GET /v1/models/nope\?x=1 HTTP/1\.1" 404 8 "-" "test-client/1\.0"\n$`)
+	if line := out.next(t); !want.MatchString(line) {
+		t.Errorf("got %q", line)
+	}
+
+	if _, err := newAccessLogger("xml", out); err == nil {
+		t.Error("-access-log xml accepted")
+	}
+}
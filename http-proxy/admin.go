@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+	"time"
+)
+
+// proxyStats counts what the proxy has served since it started, for
+// /admin. Unlike metrics they are always kept.
+type proxyStats struct {
+	since    time.Time
+	requests atomic.Int64 // finished, tunnels included
+	errors   atomic.Int64 // of those, answered with a 5xx
+	active   atomic.Int64 // HTTP requests under way
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+}
+
+// record counts a finished request or tunnel from its access record.
+func (s *proxyStats) record(rec *accessRecord) {
+	s.requests.Add(1)
+	if rec.Status >= 500 {
+		s.errors.Add(1)
+	}
+	s.bytesIn.Add(rec.BytesIn)
+	s.bytesOut.Add(rec.BytesOut)
+}
+
+// namedPool is a pool with the route it serves, for reporting.
+type namedPool struct {
+	name string
+	pool *pool
+}
+
+// pools lists the reverse-mode pools: one per route, then -upstream's as
+// "default".
+func (p *ProxyServer) pools() []namedPool {
+	var pools []namedPool
+	routes, _ := p.routeTable()
+	for _, route := range routes {
+		pools = append(pools, namedPool{describeRoute(route), route.pool})
+	}
+	if p.upstream != nil {
+		pools = append(pools, namedPool{"default", p.upstream})
+	}
+	return pools
+}
+
+// adminHandler serves the -admin-addr listener.
+func (p *ProxyServer) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin", p.adminOverviewHandler)
+	mux.HandleFunc("/admin/health", p.adminHealthHandler)
+	mux.HandleFunc("/admin/usage", p.adminUsageHandler)
+	mux.HandleFunc("POST /admin/drain", p.adminDrainHandler(true))
+	mux.HandleFunc("POST /admin/enable", p.adminDrainHandler(false))
+	return mux
+}
+
+type backendStatus struct {
+	URL          string     `json:"url"`
+	Weight       int        `json:"weight"`
+	Healthy      bool       `json:"healthy"`
+	Drained      bool       `json:"drained"`
+	InFlight     int64      `json:"in_flight"`
+	Fails        int        `json:"consecutive_failures"`
+	DownUntil    *time.Time `json:"down_until,omitempty"`
+	Circuit      string     `json:"circuit"`
+	CircuitUntil *time.Time `json:"circuit_open_until,omitempty"`
+	Checked      *time.Time `json:"last_check,omitempty"`
+	Error        string     `json:"last_check_error,omitempty"`
+}
+
+type routeStatus struct {
+	Route    string          `json:"route"`
+	Balance  string          `json:"balance"`
+	Sticky   string          `json:"sticky,omitempty"`
+	Backends []backendStatus `json:"backends"`
+}
+
+// status reports b's state for /admin/health. A backend that is drained,
+// or whose circuit breaker is open, is not healthy.
+func (b *backend) status(now time.Time) backendStatus {
+	circuit, openUntil := b.breaker.status()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := backendStatus{
+		URL:      b.url.String(),
+		Weight:   b.weight,
+		Healthy:  b.up(now) && !b.drained.Load() && circuit != breakerOpen,
+		Drained:  b.drained.Load(),
+		InFlight: b.inFlight.Load(),
+		Fails:    b.fails,
+		Circuit:  circuit,
+	}
+	if now.Before(b.downUntil) {
+		until := b.downUntil
+		s.DownUntil = &until
+	}
+	if circuit == breakerOpen {
+		s.CircuitUntil = &openUntil
+	}
+	if !b.checked.IsZero() {
+		checked := b.checked
+		s.Checked = &checked
+	}
+	if b.checkErr != nil {
+		s.Error = b.checkErr.Error()
+	}
+	return s
+}
+
+// adminHealthHandler reports the health of every backend, with 503 if any
+// route has none healthy, so the proxy itself can be health checked.
+func (p *ProxyServer) adminHealthHandler(w http.ResponseWriter, r *http.Request) {
+	routes, up := p.routeStatuses(time.Now())
+	code := http.StatusOK
+	if !up {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"routes": routes})
+}
+
+// routeStatuses reports every route's backends, and whether each route
+// has one healthy.
+func (p *ProxyServer) routeStatuses(now time.Time) ([]routeStatus, bool) {
+	routes := []routeStatus{}
+	allUp := true
+	for _, named := range p.pools() {
+		status := routeStatus{Route: named.name, Balance: named.pool.strategy, Sticky: named.pool.sticky}
+		up := false
+		for _, b := range named.pool.backends {
+			s := b.status(now)
+			up = up || s.Healthy
+			status.Backends = append(status.Backends, s)
+		}
+		allUp = allUp && up
+		routes = append(routes, status)
+	}
+	return routes, allUp
+}
+
+// adminConfig is the part of the running config /admin reports: what
+// -config and the flags made of the routes and access lists. Credentials
+// are left out.
+type adminConfig struct {
+	Mode         string         `json:"mode"`
+	TLS          bool           `json:"tls"`
+	AllowClients []netip.Prefix `json:"allow_clients,omitempty"`
+	AllowHosts   []string       `json:"allow_hosts,omitempty"`
+	DenyHosts    []string       `json:"deny_hosts,omitempty"`
+	ProxyUsers   int            `json:"proxy_auth_users,omitempty"`
+	ProxyTokens  int            `json:"proxy_auth_tokens,omitempty"`
+	Routes       []string       `json:"routes,omitempty"`
+}
+
+// runningConfig reports the policy in effect now.
+func (p *ProxyServer) runningConfig() adminConfig {
+	p.policyMu.RLock()
+	defer p.policyMu.RUnlock()
+	c := adminConfig{
+		Mode:         "forward",
+		TLS:          p.listenerTLS != nil,
+		AllowClients: p.allowClients,
+		AllowHosts:   p.allowHosts,
+		DenyHosts:    p.denyHosts,
+	}
+	if p.reverse != nil {
+		c.Mode = "reverse"
+	}
+	if p.proxyAuth != nil {
+		c.ProxyUsers, c.ProxyTokens = len(p.proxyAuth.users), len(p.proxyAuth.tokens)
+	}
+	for _, route := range p.routes {
+		c.Routes = append(c.Routes, describeRoute(route)+" -> "+describePool(route.pool))
+	}
+	if p.upstream != nil {
+		c.Routes = append(c.Routes, "default -> "+describePool(p.upstream))
+	}
+	return c
+}
+
+// adminOverviewHandler reports, at /admin, what the proxy has served, the
+// config it is running and the state of every backend.
+func (p *ProxyServer) adminOverviewHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	routes, _ := p.routeStatuses(now)
+	s := &p.stats
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"since":          s.since,
+		"uptime_seconds": int64(now.Sub(s.since).Seconds()),
+		"stats": map[string]int64{
+			"requests":        s.requests.Load(),
+			"server_errors":   s.errors.Load(),
+			"requests_active": s.active.Load(),
+			"tunnels_active":  int64(p.hijacked.count()),
+			"bytes_in":        s.bytesIn.Load(),
+			"bytes_out":       s.bytesOut.Load(),
+		},
+		"config": p.runningConfig(),
+		"routes": routes,
+	})
+}
+
+// adminDrainHandler drains a backend, so no new requests go to it while
+// those under way finish, or with drain false puts it back in rotation.
+// The upstream parameter names it by URL or host, and route, if given,
+// limits the change to that route's pool; the backends changed are
+// reported as /admin/health reports them.
+func (p *ProxyServer) adminDrainHandler(drain bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		upstream, route := r.FormValue("upstream"), r.FormValue("route")
+		if upstream == "" {
+			http.Error(w, "Name the backend with ?upstream=<url or host>", http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		changed := []routeStatus{}
+		for _, named := range p.pools() {
+			if route != "" && named.name != route {
+				continue
+			}
+			status := routeStatus{Route: named.name, Balance: named.pool.strategy, Sticky: named.pool.sticky}
+			for _, b := range named.pool.backends {
+				if b.url.String() != upstream && b.url.Host != upstream {
+					continue
+				}
+				if b.drained.Swap(drain) != drain {
+					if drain {
+						log.Printf("[ADMIN] Drained %s on route %s", b.url, named.name)
+					} else {
+						log.Printf("[ADMIN] Put %s back in rotation on route %s", b.url, named.name)
+					}
+				}
+				status.Backends = append(status.Backends, b.status(now))
+			}
+			if len(status.Backends) > 0 {
+				changed = append(changed, status)
+			}
+		}
+		if len(changed) == 0 {
+			http.Error(w, "No backend "+upstream, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"routes": changed})
+	}
+}
+
+// drainedBackends names the backends drained now, by route and URL.
+func (p *ProxyServer) drainedBackends() map[string]bool {
+	drained := make(map[string]bool)
+	for _, named := range p.pools() {
+		for _, b := range named.pool.backends {
+			if b.drained.Load() {
+				drained[named.name+" "+b.url.String()] = true
+			}
+		}
+	}
+	return drained
+}
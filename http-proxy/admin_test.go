@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminDrain(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	a, b := backend("a"), backend("b")
+	path := writeConfig(t, "routes:\n  - path: /v1\n    upstreams:\n      - url: "+a.URL+"\n      - url: "+b.URL+"\n")
+	p, r := startReloader(t, path)
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+	admin := httptest.NewServer(p.adminHandler())
+	defer admin.Close()
+
+	get := func() (int, string) {
+		t.Helper()
+		resp, err := http.Get(proxy.URL + "/v1/models")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	post := func(action, upstream string) int {
+		t.Helper()
+		resp, err := http.PostForm(admin.URL+"/admin/"+action, url.Values{"upstream": {upstream}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// A drained backend gets no requests
+	if code := post("drain", a.URL); code != http.StatusOK {
+		t.Fatalf("drain: got %d", code)
+	}
+	for range 4 {
+		if _, body := get(); body != "b" {
+			t.Fatalf("with a drained: got %q, want b", body)
+		}
+	}
+
+	// It stays drained across a reload that keeps it
+	os.WriteFile(path, []byte("routes:\n  - path: /v1\n    upstreams:\n      - url: "+a.URL+"\n      - url: "+b.URL+"\n    balance: least-in-flight\n"), 0o600)
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, body := get(); body != "b" {
+		t.Errorf("after reloading: got %q, want a still drained", body)
+	}
+
+	// With every backend drained the route answers 503; by host works too
+	if code := post("drain", strings.TrimPrefix(b.URL, "http://")); code != http.StatusOK {
+		t.Fatalf("drain by host: got %d", code)
+	}
+	if code, body := get(); code != http.StatusServiceUnavailable || !strings.Contains(body, "drained") {
+		t.Errorf("all drained: got %d %q, want 503", code, body)
+	}
+
+	post("enable", a.URL)
+	if _, body := get(); body != "a" {
+		t.Errorf("re-enabled: got %q, want a", body)
+	}
+
+	if code := post("drain", "http://c.invalid"); code != http.StatusNotFound {
+		t.Errorf("unknown backend: got %d, want 404", code)
+	}
+	resp, err := http.Get(admin.URL + "/admin/drain?upstream=" + a.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /admin/drain: got %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestAdminOverview(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+	p, _ := startReloader(t, writeConfig(t, "routes:\n  - path: /v1\n    upstream: "+upstream.URL+"\n"))
+	p.stats.since = time.Now().Add(-time.Minute)
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+	admin := httptest.NewServer(p.adminHandler())
+	defer admin.Close()
+
+	for _, path := range []string{"/v1/models", "/v1/fail"} {
+		resp, err := http.Get(proxy.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(admin.URL + "/admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Uptime int64 `json:"uptime_seconds"`
+		Stats  map[string]int64
+		Config adminConfig
+		Routes []routeStatus
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Uptime < 60 || body.Stats["requests"] != 2 || body.Stats["server_errors"] != 1 || body.Stats["bytes_out"] == 0 {
+		t.Errorf("got uptime %d and stats %v, want two requests, one failed", body.Uptime, body.Stats)
+	}
+	if body.Config.Mode != "reverse" || len(body.Config.Routes) != 1 || !strings.Contains(body.Config.Routes[0], upstream.URL) {
+		t.Errorf("config reported as %+v", body.Config)
+	}
+	if len(body.Routes) != 1 || len(body.Routes[0].Backends) != 1 || !body.Routes[0].Backends[0].Healthy || body.Routes[0].Balance != roundRobin {
+		t.Errorf("routes reported as %+v", body.Routes)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// apiKeyInjector sets the API key on requests to its hosts, replacing any
+// key the client sent, so that applications never hold the real key.
+type apiKeyInjector struct {
+	key    string
+	header string // Authorization gets "Bearer <key>"; any other header the bare key
+	hosts  hostPatterns
+}
+
+// loadAPIKey reads the key from exactly one of: the value itself, the
+// environment variable named env, or file (trimmed of surrounding
+// whitespace). It returns "" if none is given.
+func loadAPIKey(value, env, file string) (string, error) {
+	var sources int
+	for _, s := range []string{value, env, file} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return "", fmt.Errorf("give only one of -api-key, -api-key-env and -api-key-file")
+	}
+
+	switch {
+	case env != "":
+		key := os.Getenv(env)
+		if key == "" {
+			return "", fmt.Errorf("$%s is empty", env)
+		}
+		return key, nil
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read API key: %w", err)
+		}
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return "", fmt.Errorf("%s is empty", file)
+		}
+		return key, nil
+	}
+	return value, nil
+}
+
+// apply sets the key on h if host is one of the injector's hosts.
+func (k *apiKeyInjector) apply(h http.Header, host string) {
+	if k == nil || !k.hosts.match(host) {
+		return
+	}
+	if http.CanonicalHeaderKey(k.header) == "Authorization" {
+		h.Set("Authorization", "Bearer "+k.key)
+	} else {
+		h.Set(k.header, k.key)
+	}
+}
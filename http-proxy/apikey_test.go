@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyInjection(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("Authorization")+"|"+r.Header.Get("Api-Key"))
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	get := func(t *testing.T, handler http.Handler, target string) string {
+		t.Helper()
+		proxy := httptest.NewServer(handler)
+		defer proxy.Close()
+		proxyURL, _ := url.Parse(proxy.URL)
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+		if !strings.HasPrefix(target, "http") {
+			target = proxy.URL + target
+			client = http.DefaultClient
+		}
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer client-key")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	t.Run("Forward", func(t *testing.T) {
+		proxy := &ProxyServer{apiKey: &apiKeyInjector{key: "real-key", header: "Authorization", hosts: parseHostPatterns(upstreamHost)}}
+		if got := get(t, proxy, upstream.URL+"/v1/models"); got != "Bearer real-key|" {
+			t.Errorf("upstream saw %q, want the injected key", got)
+		}
+		proxy.apiKey.hosts = parseHostPatterns("api.openai.com")
+		if got := get(t, proxy, upstream.URL+"/v1/models"); got != "Bearer client-key|" {
+			t.Errorf("other host saw %q, want the client's key", got)
+		}
+	})
+
+	t.Run("ReverseHeader", func(t *testing.T) {
+		target, _ := parseUpstream(upstream.URL)
+		key := &apiKeyInjector{key: "azure-key", header: "api-key", hosts: hostPatterns{"*"}}
+		proxy := &ProxyServer{reverse: newReverseProxy(newTransport(defaultTransportOptions, nil), key, false), upstream: singlePool(target)}
+		if got := get(t, proxy, "/v1/models"); got != "Bearer client-key|azure-key" {
+			t.Errorf("upstream saw %q, want api-key set", got)
+		}
+	})
+}
+
+func TestLoadAPIKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(file, []byte("  file-key\n"), 0o600)
+	t.Setenv("PROXY_TEST_KEY", "env-key")
+
+	for _, tc := range []struct{ value, env, file, want string }{
+		{"flag-key", "", "", "flag-key"},
+		{"", "PROXY_TEST_KEY", "", "env-key"},
+		{"", "", file, "file-key"},
+		{"", "", "", ""},
+	} {
+		got, err := loadAPIKey(tc.value, tc.env, tc.file)
+		if err != nil || got != tc.want {
+			t.Errorf("loadAPIKey(%q, %q, %q) = %q, %v; want %q", tc.value, tc.env, tc.file, got, err, tc.want)
+		}
+	}
+	if _, err := loadAPIKey("flag-key", "PROXY_TEST_KEY", ""); err == nil {
+		t.Error("two sources accepted")
+	}
+	if _, err := loadAPIKey("", "PROXY_TEST_UNSET", ""); err == nil {
+		t.Error("an empty variable accepted")
+	}
+}
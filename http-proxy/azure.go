@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// azureDeploymentEndpoints are the path suffixes of requests that Azure
+// OpenAI serves from a deployment, under /openai/deployments/<name>.
+// Everything else, such as files and batches, is directly under /openai.
+var azureDeploymentEndpoints = []string{
+	"/chat/completions", "/completions", "/embeddings",
+	"/audio/transcriptions", "/audio/translations", "/audio/speech",
+	"/images/generations", "/images/edits",
+}
+
+// AzureDialect translates the OpenAI-style requests a route gets into
+// Azure OpenAI's, so that applications written for the OpenAI API can use
+// an Azure resource by its URL alone (https://<resource>.openai.azure.com)
+// as the route's upstream.
+type AzureDialect struct {
+	// APIVersion is sent as api-version unless the client gives one
+	// (default 2024-10-21)
+	APIVersion string `yaml:"api_version"`
+
+	// Deployments maps models to the deployments that serve them. A model
+	// not listed is taken to be deployed under its own name.
+	Deployments map[string]string `yaml:"deployments"`
+
+	// Deployment is for requests that name no model
+	Deployment string `yaml:"deployment"`
+}
+
+// setDefaults checks a and fills in what it leaves out.
+func (a *AzureDialect) setDefaults() error {
+	if a.APIVersion == "" {
+		a.APIVersion = "2024-10-21"
+	}
+	for model, deployment := range a.Deployments {
+		if deployment == "" || strings.Contains(deployment, "/") {
+			return fmt.Errorf("invalid deployment %q for %s", deployment, model)
+		}
+	}
+	if strings.Contains(a.Deployment, "/") {
+		return fmt.Errorf("invalid deployment %q", a.Deployment)
+	}
+	return nil
+}
+
+// deployed reports whether a request for path is served by a deployment,
+// which is then picked by the model it names.
+func (a *AzureDialect) deployed(path string) bool {
+	for _, suffix := range azureDeploymentEndpoints {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// deployment returns the deployment serving model, or "" if there is
+// none.
+func (a *AzureDialect) deployment(model string) string {
+	if deployment := a.Deployments[model]; deployment != "" {
+		return deployment
+	}
+	if model != "" {
+		return model
+	}
+	return a.Deployment
+}
+
+// translate returns Azure's URL for in, the URL of a request for /v1/...
+// naming model, which the backend serves: /v1/chat/completions for gpt-4o
+// becomes /openai/deployments/gpt-4o/chat/completions?api-version=...,
+// and /v1/files becomes /openai/files?api-version=....
+func (a *AzureDialect) translate(in *url.URL, model string) *url.URL {
+	rest := strings.TrimPrefix(in.Path, "/v1")
+	u := *in
+	u.Path, u.RawPath = "/openai"+rest, ""
+	if a.deployed(rest) {
+		u.Path = "/openai/deployments/" + a.deployment(model) + rest
+	}
+	query := u.Query()
+	if !query.Has("api-version") {
+		query.Set("api-version", a.APIVersion)
+		u.RawQuery = query.Encode()
+	}
+	return &u
+}
+
+// azureCredentials moves an API key sent as a bearer token, as OpenAI
+// takes it, to the api-key header, as Azure does. Microsoft Entra ID
+// tokens, which Azure also takes as bearer tokens, are left where they
+// are.
+func azureCredentials(h http.Header) {
+	token, ok := strings.CutPrefix(h.Get("Authorization"), "Bearer ")
+	if !ok || h.Get("Api-Key") != "" || isJWT(token) {
+		return
+	}
+	h.Del("Authorization")
+	h.Set("Api-Key", token)
+}
+
+// isJWT reports whether token looks like a JSON Web Token: three
+// base64url parts, the first a JSON object.
+func isJWT(token string) bool {
+	return strings.HasPrefix(token, "eyJ") && strings.Count(token, ".") == 2
+}
+
+// azureLocationHeaders are the response headers that may point at another
+// Azure URL, such as the status of an operation.
+var azureLocationHeaders = []string{"Location", "Operation-Location"}
+
+// untranslateLocations points the Location headers of an Azure response
+// back at the proxy, by the OpenAI-style path that translates to the URL
+// they give, so that clients following them come back through it.
+func untranslateLocations(h http.Header) {
+	for _, name := range azureLocationHeaders {
+		location, err := url.Parse(h.Get(name))
+		if err != nil || location.Path == "" {
+			continue
+		}
+		rest, ok := strings.CutPrefix(location.Path, "/openai/")
+		if !ok || strings.HasPrefix(rest, "deployments/") {
+			// A deployment's URL has no OpenAI-style path to go back to
+			continue
+		}
+		back := url.URL{Path: "/v1/" + rest, RawQuery: location.RawQuery}
+		h.Set(name, back.String())
+	}
+}
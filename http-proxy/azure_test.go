@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAzureDialect(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/openai/images/generations:submit" {
+			w.Header().Set("Operation-Location", "https://"+r.Host+"/openai/operations/images/op1?api-version=2024-10-21")
+			w.WriteHeader(http.StatusAccepted)
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"url":           r.URL.String(),
+			"api_key":       r.Header.Get("Api-Key"),
+			"authorization": r.Header.Get("Authorization"),
+			"body_size":     len(body),
+		})
+	}))
+	defer upstream.Close()
+
+	config, err := loadConfig(writeConfig(t, `
+routes:
+  - path: /v1/
+    upstream: `+upstream.URL+`
+    azure:
+      deployments:
+        gpt-4o: prod-gpt4o
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(&ProxyServer{reverse: newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false), routes: config.Routes})
+	defer proxy.Close()
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	file, _ := writer.CreateFormFile("file", "speech.mp3")
+	file.Write(make([]byte, 1000))
+	writer.WriteField("model", "whisper-1")
+	writer.Close()
+
+	jwt := "eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJ4In0.c2ln"
+	for _, tt := range []struct {
+		name, method, path, contentType, body, token string
+		url, apiKey, authorization                   string
+	}{
+		{"mapped model", http.MethodPost, "/v1/chat/completions", "application/json", `{"model":"gpt-4o"}`, "sk-1",
+			"/openai/deployments/prod-gpt4o/chat/completions?api-version=2024-10-21", "sk-1", ""},
+		{"model as deployment", http.MethodPost, "/v1/embeddings", "application/json", `{"model":"text-embedding-3-small"}`, "sk-1",
+			"/openai/deployments/text-embedding-3-small/embeddings?api-version=2024-10-21", "sk-1", ""},
+		{"form with model after file", http.MethodPost, "/v1/audio/transcriptions", writer.FormDataContentType(), form.String(), "sk-1",
+			"/openai/deployments/whisper-1/audio/transcriptions?api-version=2024-10-21", "sk-1", ""},
+		{"not deployed", http.MethodGet, "/v1/files?purpose=batch", "", "", "sk-1",
+			"/openai/files?api-version=2024-10-21&purpose=batch", "sk-1", ""},
+		{"client api-version", http.MethodGet, "/v1/models?api-version=2025-01-01-preview", "", "", "sk-1",
+			"/openai/models?api-version=2025-01-01-preview", "sk-1", ""},
+		{"Entra ID token", http.MethodGet, "/v1/models", "", "", jwt,
+			"/openai/models?api-version=2024-10-21", "", "Bearer " + jwt},
+	} {
+		req, _ := http.NewRequest(tt.method, proxy.URL+tt.path, strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			URL           string `json:"url"`
+			APIKey        string `json:"api_key"`
+			Authorization string `json:"authorization"`
+			BodySize      int    `json:"body_size"`
+		}
+		json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if got.URL != tt.url || got.APIKey != tt.apiKey || got.Authorization != tt.authorization || got.BodySize != len(tt.body) {
+			t.Errorf("%s: upstream got %+v, want %s with api-key %q, Authorization %q and %d bytes", tt.name, got, tt.url, tt.apiKey, tt.authorization, len(tt.body))
+		}
+	}
+
+	// A deployment must be named, or set for the route
+	resp, err := http.Post(proxy.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"messages":[]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Request naming no model got %d, want 400", resp.StatusCode)
+	}
+
+	// Azure's URLs in responses are turned back into the proxy's
+	resp, err = http.Post(proxy.URL+"/v1/images/generations:submit", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if location := resp.Header.Get("Operation-Location"); location != "/v1/operations/images/op1?api-version=2024-10-21" {
+		t.Errorf("Operation-Location %q, want the proxy's path for it", location)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Load-balancing strategies for a route with several upstreams.
+const (
+	roundRobin    = "round-robin"
+	leastInFlight = "least-in-flight"
+	failover      = "failover"
+)
+
+// Defaults for taking failing backends out of rotation.
+const (
+	defaultMaxFails    = 3
+	defaultFailTimeout = 30 * time.Second
+)
+
+// backend is one upstream of a pool.
+type backend struct {
+	url    *url.URL
+	weight int
+
+	inFlight atomic.Int64
+
+	mu        sync.Mutex
+	current   int       // smooth weighted round-robin state
+	fails     int       // consecutive failures
+	downUntil time.Time // out of rotation until then
+
+	// Result of the last health check, if health_check is set
+	checked   time.Time
+	checkErr  error
+	checkDown bool
+
+	// breaker is set by circuit_breaker
+	breaker *breaker
+
+	// drained, set on the admin listener, keeps new requests away
+	drained atomic.Bool
+
+	// Set by the upstream's config: credentials replacing the client's,
+	// models renamed, and the Azure OpenAI dialect
+	apiKey       *apiKeyInjector
+	rewriteModel map[string]string
+	azure        *AzureDialect
+}
+
+// serves reports whether b can take a request for path naming model: an
+// Azure upstream must have a deployment for it.
+func (b *backend) serves(path, model string) bool {
+	return b.azure == nil || !b.azure.deployed(path) || b.azure.deployment(model) != ""
+}
+
+// healthy reports whether b is in rotation: it passed its last health
+// check and has not been taken out for failing requests.
+func (b *backend) healthy(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.up(now)
+}
+
+// up is healthy for callers holding b.mu.
+func (b *backend) up(now time.Time) bool {
+	return !b.checkDown && !now.Before(b.downUntil)
+}
+
+// pool balances requests over a route's backends. A backend that fails
+// maxFails times in a row is left out for failTimeout.
+type pool struct {
+	backends    []*backend
+	strategy    string
+	maxFails    int
+	failTimeout time.Duration
+
+	// sticky, if set, is what keeps a client on one backend: user, the
+	// user field of the request body, or header:<name>
+	sticky string
+
+	// headers, if set, rewrites the headers of requests to the backends
+	headers *HeaderRewrite
+
+	mu sync.Mutex // serialises round-robin picks
+}
+
+// newPool builds a pool; weights of 0 count as 1.
+func newPool(strategy string, maxFails int, failTimeout time.Duration, upstreams []*url.URL, weights []int) (*pool, error) {
+	switch strategy {
+	case "":
+		strategy = roundRobin
+	case roundRobin, leastInFlight, failover:
+	default:
+		return nil, fmt.Errorf("invalid balance %q: must be %s, %s or %s", strategy, roundRobin, leastInFlight, failover)
+	}
+	if maxFails <= 0 {
+		maxFails = defaultMaxFails
+	}
+	if failTimeout <= 0 {
+		failTimeout = defaultFailTimeout
+	}
+
+	p := &pool{strategy: strategy, maxFails: maxFails, failTimeout: failTimeout}
+	for i, u := range upstreams {
+		weight := 1
+		if i < len(weights) && weights[i] > 0 {
+			weight = weights[i]
+		}
+		p.backends = append(p.backends, &backend{url: u, weight: weight})
+	}
+	return p, nil
+}
+
+// singlePool is a pool of one upstream.
+func singlePool(upstream *url.URL) *pool {
+	p, _ := newPool(roundRobin, 0, 0, []*url.URL{upstream}, nil)
+	return p
+}
+
+// pick chooses the backend for the next request, or returns nil if every
+// backend's circuit breaker is open. If every other backend is out of
+// rotation they are all considered, since trying one beats failing the
+// request outright.
+func (p *pool) pick() *backend {
+	return p.pickFor("")
+}
+
+// pickFor is pick for a request with the sticky key key, which, if not
+// empty, picks the same backend for every request with that key for as
+// long as the backend is in rotation.
+func (p *pool) pickFor(key string) *backend {
+	now := time.Now()
+	// Another request may take the last half-open slot of the backend
+	// chosen, so choose again if need be
+	for range p.backends {
+		var healthy, allowed []*backend
+		for _, b := range p.backends {
+			if b.drained.Load() || !b.breaker.available(now) {
+				continue
+			}
+			allowed = append(allowed, b)
+			if b.healthy(now) {
+				healthy = append(healthy, b)
+			}
+		}
+		candidates := healthy
+		if len(candidates) == 0 {
+			candidates = allowed
+		}
+		if len(candidates) == 0 {
+			return nil
+		}
+		b := p.choose(candidates)
+		if key != "" {
+			b = rendezvous(candidates, key)
+			// If its breaker is half-open and busy, balance as usual
+			key = ""
+		}
+		if b.breaker.acquire(now) {
+			return b
+		}
+	}
+	return nil
+}
+
+// choose picks one of candidates by the pool's strategy.
+func (p *pool) choose(candidates []*backend) *backend {
+	if len(candidates) == 1 || p.strategy == failover {
+		// Candidates are in the order listed
+		return candidates[0]
+	}
+
+	if p.strategy == leastInFlight {
+		best := candidates[0]
+		for _, b := range candidates[1:] {
+			// Compare in-flight/weight without dividing
+			if b.inFlight.Load()*int64(best.weight) < best.inFlight.Load()*int64(b.weight) {
+				best = b
+			}
+		}
+		return best
+	}
+
+	// Smooth weighted round-robin, as in nginx: each pick raises every
+	// candidate by its weight and lowers the chosen one by the total, so
+	// weights 5,1,1 give a a b a c a a rather than a a a a a b c.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var best *backend
+	total := 0
+	for _, b := range candidates {
+		b.mu.Lock()
+		b.current += b.weight
+		total += b.weight
+		if best == nil || b.current > best.current {
+			best = b
+		}
+		b.mu.Unlock()
+	}
+	best.mu.Lock()
+	best.current -= total
+	best.mu.Unlock()
+	return best
+}
+
+// rendezvous picks the candidate with the highest weighted score for key
+// (rendezvous hashing), so that a key keeps its backend as others come and
+// go, and only the keys of a backend that leaves rotation move, spread
+// over the rest in proportion to their weights.
+func rendezvous(candidates []*backend, key string) *backend {
+	var best *backend
+	var bestScore float64
+	for _, b := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(b.url.String()))
+		// FNV barely mixes its last bytes into the high bits, so finish
+		// with MurmurHash3's mixer
+		x := h.Sum64()
+		x ^= x >> 33
+		x *= 0xff51afd7ed558ccd
+		x ^= x >> 33
+		x *= 0xc4ceb9fe1a85ec53
+		x ^= x >> 33
+		// A uniform number in (0, 1), made an exponential draw scaled by
+		// the weight: the largest wins with the weight's share of keys
+		u := (float64(x>>11) + 0.5) / (1 << 53)
+		score := float64(b.weight) / -math.Log(u)
+		if best == nil || score > bestScore {
+			best, bestScore = b, score
+		}
+	}
+	return best
+}
+
+// stickyKey returns what keeps r on one backend of p, whose body, if it
+// was read, is body, or "" if p is not sticky or r has none.
+func (p *pool) stickyKey(r *http.Request, body []byte) string {
+	if name, ok := strings.CutPrefix(p.sticky, "header:"); ok {
+		return r.Header.Get(name)
+	}
+	if p.sticky == "user" && body != nil {
+		var fields struct {
+			User string `json:"user"`
+		}
+		json.Unmarshal(body, &fields)
+		return fields.User
+	}
+	return ""
+}
+
+// validSticky reports whether sticky is a route's sticky setting.
+func validSticky(sticky string) bool {
+	return sticky == "" || sticky == "user" || strings.HasPrefix(sticky, "header:") && len(sticky) > len("header:")
+}
+
+// next chooses the backend a failover request goes to after those in
+// tried, the first listed that serves it, preferring those in rotation,
+// or returns nil if there is none.
+func (p *pool) next(tried map[*backend]bool, path, model string) *backend {
+	now := time.Now()
+	for _, inRotation := range []bool{true, false} {
+		for _, b := range p.backends {
+			if tried[b] || b.drained.Load() || b.healthy(now) != inRotation || !b.serves(path, model) {
+				continue
+			}
+			if b.breaker.acquire(now) {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+// needsBody reports whether p needs to see r's body to pick a backend and
+// send r there: for the model, to pick an Azure deployment or rename it, or
+// for the user p is sticky by.
+func (p *pool) needsBody(r *http.Request) bool {
+	if p.sticky == "user" && hasModelBody(r) {
+		return true
+	}
+	for _, b := range p.backends {
+		if b.azure != nil && b.azure.deployed(r.URL.Path) || len(b.rewriteModel) > 0 && hasModelBody(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// drained reports whether every backend of p is drained.
+func (p *pool) drained() bool {
+	for _, b := range p.backends {
+		if !b.drained.Load() {
+			return false
+		}
+	}
+	return true
+}
+
+// reopens returns when the first of the pool's open circuit breakers
+// lets requests through again.
+func (p *pool) reopens() time.Time {
+	var first time.Time
+	for _, b := range p.backends {
+		if state, until := b.breaker.status(); state == breakerOpen && (first.IsZero() || until.Before(first)) {
+			first = until
+		}
+	}
+	return first
+}
+
+// succeeded puts b back in rotation after a good response.
+func (p *pool) succeeded(b *backend) {
+	b.breaker.record(time.Now(), true)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fails = 0
+	b.downUntil = time.Time{}
+}
+
+// released ends a request to b that says nothing about its health, such
+// as one the client gave up on.
+func (p *pool) released(b *backend) {
+	b.breaker.release()
+}
+
+// failed counts a failure of b and reports whether it took b out of
+// rotation.
+func (p *pool) failed(b *backend) bool {
+	b.breaker.record(time.Now(), false)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fails++
+	if b.fails < p.maxFails {
+		return false
+	}
+	b.fails = 0
+	b.downUntil = time.Now().Add(p.failTimeout)
+	return true
+}
+
+// describePool lists a pool's upstreams, for the startup log.
+func describePool(p *pool) string {
+	var urls []string
+	for _, b := range p.backends {
+		if b.weight > 1 {
+			urls = append(urls, fmt.Sprintf("%s (weight %d)", b.url, b.weight))
+		} else {
+			urls = append(urls, b.url.String())
+		}
+	}
+	if len(urls) == 1 {
+		return urls[0]
+	}
+	how := p.strategy
+	if p.sticky != "" {
+		how += ", sticky by " + p.sticky
+	}
+	return strings.Join(urls, ", ") + " (" + how + ")"
+}
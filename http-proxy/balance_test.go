@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPool(t *testing.T, strategy string, weights ...int) *pool {
+	t.Helper()
+	var urls []*url.URL
+	for i := range weights {
+		urls = append(urls, &url.URL{Scheme: "http", Host: string(rune('a' + i))})
+	}
+	p, err := newPool(strategy, 2, time.Minute, urls, weights)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func picks(p *pool, n int) string {
+	var seq strings.Builder
+	for range n {
+		seq.WriteString(p.pick().url.Host)
+	}
+	return seq.String()
+}
+
+func TestRoundRobin(t *testing.T) {
+	if got := picks(testPool(t, roundRobin, 0, 0, 0), 6); got != "abcabc" {
+		t.Errorf("equal weights: got %s, want abcabc", got)
+	}
+	if got := picks(testPool(t, roundRobin, 5, 1, 1), 7); got != "aabacaa" {
+		t.Errorf("weights 5,1,1: got %s, want aabacaa", got)
+	}
+}
+
+func TestLeastInFlight(t *testing.T) {
+	p := testPool(t, leastInFlight, 1, 2)
+	a, b := p.backends[0], p.backends[1]
+	a.inFlight.Store(1)
+	b.inFlight.Store(1)
+	if got := p.pick(); got != b {
+		t.Errorf("1/1 vs 1/2 in flight per weight: picked %s, want b", got.url.Host)
+	}
+	b.inFlight.Store(3)
+	if got := p.pick(); got != a {
+		t.Errorf("1/1 vs 3/2 in flight per weight: picked %s, want a", got.url.Host)
+	}
+}
+
+func TestFailedBackend(t *testing.T) {
+	p := testPool(t, roundRobin, 1, 1)
+	a := p.backends[0]
+	if p.failed(a) {
+		t.Error("out of rotation after one failure, want two")
+	}
+	if !p.failed(a) {
+		t.Error("still in rotation after two failures")
+	}
+	if got := picks(p, 3); got != "bbb" {
+		t.Errorf("with a down: got %s, want bbb", got)
+	}
+
+	p.backends[1].downUntil = time.Now().Add(time.Minute)
+	if got := p.pick(); got == nil {
+		t.Error("no backend picked with all down")
+	}
+
+	p.succeeded(a)
+	if !a.healthy(time.Now()) {
+		t.Error("still out of rotation after a success")
+	}
+}
+
+func TestBalancedRoute(t *testing.T) {
+	var hits [2]int
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[0]++
+		io.WriteString(w, "ok")
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[1]++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	config, err := loadConfig(writeConfig(t, `
+routes:
+  - path: /v1/
+    upstreams:
+      - url: `+up.URL+`
+      - url: `+down.URL+`
+    max_fails: 2
+    fail_timeout: 1m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(&ProxyServer{reverse: newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false), routes: config.Routes})
+	defer server.Close()
+
+	for range 10 {
+		resp, err := http.Get(server.URL + "/v1/models")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if hits[1] != 2 || hits[0] != 8 {
+		t.Errorf("hits = %v, want the failing backend dropped after 2", hits)
+	}
+}
+
+func TestSticky(t *testing.T) {
+	p := testPool(t, roundRobin, 1, 1, 1, 1)
+	before := make(map[string]*backend)
+	counts := make(map[string]int)
+	for i := range 1000 {
+		key := fmt.Sprintf("user-%d", i)
+		b := p.pickFor(key)
+		if again := p.pickFor(key); again != b {
+			t.Fatalf("%s picked %s, then %s", key, b.url.Host, again.url.Host)
+		}
+		before[key] = b
+		counts[b.url.Host]++
+	}
+	for host, n := range counts {
+		if n < 150 || n > 350 {
+			t.Errorf("%s has %d of 1000 keys, want about 250", host, n)
+		}
+	}
+
+	// Only the keys of a backend out of rotation move
+	c := p.backends[2]
+	c.downUntil = time.Now().Add(time.Minute)
+	for key, was := range before {
+		now := p.pickFor(key)
+		if was != c && now != was {
+			t.Fatalf("%s moved from %s to %s", key, was.url.Host, now.url.Host)
+		}
+		if now == c {
+			t.Fatalf("%s still on the backend out of rotation", key)
+		}
+	}
+
+	weighted := testPool(t, roundRobin, 3, 1)
+	var onA int
+	for i := range 1000 {
+		if weighted.pickFor(fmt.Sprintf("user-%d", i)) == weighted.backends[0] {
+			onA++
+		}
+	}
+	if onA < 700 || onA > 800 {
+		t.Errorf("Weights 3,1: %d of 1000 keys on a, want about 750", onA)
+	}
+}
+
+func TestStickyRoute(t *testing.T) {
+	var upstreams []string
+	for i := range 3 {
+		up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			fmt.Fprint(w, i)
+		}))
+		defer up.Close()
+		upstreams = append(upstreams, "      - url: "+up.URL)
+	}
+	for _, sticky := range []string{"user", "header:X-Session-Id"} {
+		config, err := loadConfig(writeConfig(t, `
+routes:
+  - path: /v1/
+    sticky: `+sticky+`
+    upstreams:
+`+strings.Join(upstreams, "\n")+"\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := httptest.NewServer(&ProxyServer{reverse: newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false), routes: config.Routes})
+		defer server.Close()
+
+		served := make(map[string]string)
+		for i := range 30 {
+			user := fmt.Sprintf("user-%d", i%5)
+			req, _ := http.NewRequest(http.MethodPost, server.URL+"/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","user":"`+user+`"}`))
+			req.Header.Set("X-Session-Id", user)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			backend, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if first, ok := served[user]; ok && first != string(backend) {
+				t.Errorf("sticky %s: %s served by %s, then %s", sticky, user, first, backend)
+			}
+			served[user] = string(backend)
+		}
+	}
+}
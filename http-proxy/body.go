@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// checkBodySize refuses, with 413, a request whose body is declared to be
+// over p.maxBodySize, and reports whether it may go ahead. A body of
+// unknown length is cut off where it goes over instead, failing the
+// request to the upstream, which is answered with 413 too.
+func (p *ProxyServer) checkBodySize(w http.ResponseWriter, r *http.Request) bool {
+	if p.maxBodySize <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > p.maxBodySize {
+		log.Printf("[LIMITED] %s %s%s body of %d bytes is over %d", r.Method, r.Host, r.URL.Path, r.ContentLength, p.maxBodySize)
+		// The body is not read, so the connection cannot be used again
+		w.Header().Set("Connection", "close")
+		http.Error(w, "Request body over "+strconv.FormatInt(p.maxBodySize, 10)+" bytes", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, p.maxBodySize)
+	return true
+}
+
+// statusClientClosed is logged, as nginx logs it, for a request whose
+// client went away before sending all of its body. The client never sees
+// it.
+const statusClientClosed = 499
+
+// refuseBody answers r, whose body could not be read for its model because
+// of err: with 413 if the body was too large, 499 if the client went away
+// while sending it, and otherwise 400.
+func refuseBody(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, io.ErrUnexpectedEOF) || r.Context().Err() != nil:
+		status = statusClientClosed
+	}
+	log.Printf("[ERROR] Failed to read request body (%d): %v", status, err)
+	http.Error(w, err.Error(), status)
+}
+
+// bodyTooLarge reports whether err came from reading a body cut off by
+// checkBodySize.
+func bodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
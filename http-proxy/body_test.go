@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// countingUpstream answers with the length of the body it got.
+var countingUpstream = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	n, err := io.Copy(io.Discard, r.Body)
+	if err != nil {
+		return
+	}
+	io.WriteString(w, strconv.FormatInt(n, 10))
+})
+
+func TestMaxBodySize(t *testing.T) {
+	upstream := httptest.NewServer(countingUpstream)
+	defer upstream.Close()
+	target, err := parseUpstream(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reverse := httptest.NewServer(&ProxyServer{
+		reverse:     newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false),
+		upstream:    singlePool(target),
+		maxBodySize: 1000,
+	})
+	defer reverse.Close()
+	forward := httptest.NewServer(&ProxyServer{maxBodySize: 1000})
+	defer forward.Close()
+	forwardURL, _ := url.Parse(forward.URL)
+
+	for name, post := range map[string]func(body io.Reader) (*http.Response, error){
+		"reverse": func(body io.Reader) (*http.Response, error) {
+			return http.Post(reverse.URL+"/v1/embeddings", "application/json", body)
+		},
+		"forward": func(body io.Reader) (*http.Response, error) {
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(forwardURL)}}
+			return client.Post(upstream.URL+"/v1/embeddings", "application/json", body)
+		},
+	} {
+		for _, tt := range []struct {
+			name   string
+			body   io.Reader
+			status int
+		}{
+			{"at the limit", bytes.NewReader(make([]byte, 1000)), http.StatusOK},
+			{"over the limit", bytes.NewReader(make([]byte, 1001)), http.StatusRequestEntityTooLarge},
+			// Sent chunked, so only found to be over as it is forwarded
+			{"unknown length over the limit", io.MultiReader(strings.NewReader(strings.Repeat("x", 5000))), http.StatusRequestEntityTooLarge},
+		} {
+			resp, err := post(tt.body)
+			if err != nil {
+				t.Fatalf("%s, %s: %v", name, tt.name, err)
+			}
+			got, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("%s, %s: got %d %s, want %d", name, tt.name, resp.StatusCode, got, tt.status)
+			}
+		}
+	}
+}
+
+func TestBodyStreamed(t *testing.T) {
+	received := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadFull(r.Body, make([]byte, 5)); err != nil {
+			return
+		}
+		close(received)
+		countingUpstream(w, r)
+	}))
+	defer upstream.Close()
+	target, err := parseUpstream(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := newTransport(defaultTransportOptions, nil)
+	reverse := newReverseProxy(transport, nil, false)
+	reverse.Transport = newRetryTransport(transport, RetryPolicy{Attempts: 2, Posts: true}, false)
+	proxy := httptest.NewServer(&ProxyServer{reverse: reverse, upstream: singlePool(target)})
+	defer proxy.Close()
+
+	// Larger than is held for retries, so the upstream gets the start of
+	// it before the rest is sent
+	body, send := io.Pipe()
+	go func() {
+		send.Write([]byte("first"))
+		select {
+		case <-received:
+			send.Write(make([]byte, maxReplayBody-4))
+			send.Close()
+		case <-time.After(5 * time.Second):
+			send.CloseWithError(io.ErrUnexpectedEOF)
+		}
+	}()
+	req, _ := http.NewRequest(http.MethodPost, proxy.URL+"/v1/audio/transcriptions", body)
+	req.ContentLength = maxReplayBody + 1
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	// The upstream counts what came after "first"
+	if resp.StatusCode != http.StatusOK || string(got) != strconv.Itoa(maxReplayBody-4) {
+		t.Errorf("Got %d %s, want the whole body forwarded as it was sent", resp.StatusCode, got)
+	}
+}
+
+// TestRefuseBody checks that only a body too large to read for its model
+// is refused with 413.
+func TestRefuseBody(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		body   func(w http.ResponseWriter) io.ReadCloser
+		status int
+	}{
+		{"over -max-body-size", func(w http.ResponseWriter) io.ReadCloser {
+			return http.MaxBytesReader(w, io.NopCloser(strings.NewReader(strings.Repeat("x", 1001))), 1000)
+		}, http.StatusRequestEntityTooLarge},
+		{"over the model limit", func(http.ResponseWriter) io.ReadCloser {
+			return io.NopCloser(bytes.NewReader(make([]byte, maxModelBody+1)))
+		}, http.StatusRequestEntityTooLarge},
+		{"client went away", func(http.ResponseWriter) io.ReadCloser {
+			return io.NopCloser(io.MultiReader(strings.NewReader(`{"model":`), iotest.ErrReader(io.ErrUnexpectedEOF)))
+		}, statusClientClosed},
+		{"read failed", func(http.ResponseWriter) io.ReadCloser {
+			return io.NopCloser(iotest.ErrReader(errors.New("malformed chunked encoding")))
+		}, http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		r.Body = tt.body(w)
+		_, _, err := readModel(r)
+		if err == nil {
+			t.Fatalf("%s: body read", tt.name)
+		}
+		if got := errors.Is(err, errBodyTooLarge); got != (tt.status == http.StatusRequestEntityTooLarge) {
+			t.Errorf("%s: errors.Is(%v, errBodyTooLarge) = %v", tt.name, err, got)
+		}
+		refuseBody(w, r, err)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker says when a backend's breaker trips: when at least
+// ErrorRate of the requests to it in a Window fail, once there have been
+// MinRequests. It then stays open for OpenFor, after which it lets
+// HalfOpenRequests through to test the backend before closing again.
+type CircuitBreaker struct {
+	ErrorRate        float64       `yaml:"error_rate"`
+	MinRequests      int           `yaml:"min_requests"`
+	Window           time.Duration `yaml:"window"`
+	OpenFor          time.Duration `yaml:"open_for"`
+	HalfOpenRequests int           `yaml:"half_open_requests"`
+}
+
+// setDefaults checks c and fills in what it leaves out.
+func (c *CircuitBreaker) setDefaults() error {
+	if c.ErrorRate == 0 {
+		c.ErrorRate = 0.5
+	}
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("error_rate %v must be between 0 and 1", c.ErrorRate)
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 20
+	}
+	if c.Window <= 0 {
+		c.Window = time.Minute
+	}
+	if c.OpenFor <= 0 {
+		c.OpenFor = 30 * time.Second
+	}
+	if c.HalfOpenRequests <= 0 {
+		c.HalfOpenRequests = 1
+	}
+	return nil
+}
+
+// addBreakers gives every reverse-mode backend without a circuit breaker
+// one.
+func (p *ProxyServer) addBreakers(config CircuitBreaker) {
+	for _, named := range p.pools() {
+		for _, b := range named.pool.backends {
+			if b.breaker != nil {
+				continue
+			}
+			b.breaker = newBreaker(config, b.url.Host)
+			b.breaker.metrics = p.metrics
+		}
+	}
+}
+
+// Breaker states.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// breaker is the circuit breaker of one backend. A nil breaker is always
+// closed.
+type breaker struct {
+	config  CircuitBreaker
+	name    string
+	metrics *metrics
+
+	mu          sync.Mutex
+	state       string
+	windowStart time.Time
+	requests    int
+	failures    int
+	openUntil   time.Time
+	trials      int // half-open requests let through
+	passed      int // of which succeeded
+}
+
+func newBreaker(config CircuitBreaker, name string) *breaker {
+	return &breaker{config: config, name: name, state: breakerClosed}
+}
+
+// available reports whether a request could be sent through b now.
+func (b *breaker) available(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		return !now.Before(b.openUntil)
+	case breakerHalfOpen:
+		return b.trials < b.config.HalfOpenRequests
+	}
+	return true
+}
+
+// acquire lets a request through b, making an open breaker whose time is
+// up half-open, and reports whether it may go ahead.
+func (b *breaker) acquire(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen {
+		if now.Before(b.openUntil) {
+			return false
+		}
+		b.state, b.trials, b.passed = breakerHalfOpen, 0, 0
+		log.Printf("[BREAKER] %s half-open; letting %d requests through", b.name, b.config.HalfOpenRequests)
+	}
+	if b.state == breakerHalfOpen {
+		if b.trials >= b.config.HalfOpenRequests {
+			return false
+		}
+		b.trials++
+	}
+	return true
+}
+
+// record counts the outcome of a request acquired through b.
+func (b *breaker) record(now time.Time, ok bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerHalfOpen:
+		if !ok {
+			b.trip(now, "a half-open request failed")
+			return
+		}
+		if b.passed++; b.passed >= b.config.HalfOpenRequests {
+			b.state = breakerClosed
+			b.windowStart, b.requests, b.failures = now, 0, 0
+			log.Printf("[BREAKER] %s closed", b.name)
+		}
+	case breakerClosed:
+		if now.Sub(b.windowStart) >= b.config.Window {
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+		b.requests++
+		if !ok {
+			b.failures++
+		}
+		if b.requests >= b.config.MinRequests && float64(b.failures) >= b.config.ErrorRate*float64(b.requests) {
+			b.trip(now, fmt.Sprintf("%d of %d requests failed", b.failures, b.requests))
+		}
+	}
+}
+
+// release gives back a half-open slot taken by a request that ended
+// without saying anything about the backend, such as one the client
+// abandoned.
+func (b *breaker) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+// trip opens b; b.mu must be held.
+func (b *breaker) trip(now time.Time, why string) {
+	b.state = breakerOpen
+	b.openUntil = now.Add(b.config.OpenFor)
+	log.Printf("[BREAKER] %s open for %v: %s", b.name, b.config.OpenFor, why)
+	b.metrics.breakerTripped(b.name)
+}
+
+// status returns b's state, and when an open breaker will let requests
+// through again.
+func (b *breaker) status() (string, time.Time) {
+	if b == nil {
+		return breakerClosed, time.Time{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.openUntil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreakerStates(t *testing.T) {
+	config := CircuitBreaker{ErrorRate: 0.5, MinRequests: 4, OpenFor: time.Minute, HalfOpenRequests: 2}
+	config.setDefaults()
+	b := newBreaker(config, "test")
+	now := time.Now()
+
+	for _, ok := range []bool{true, false, true} {
+		b.acquire(now)
+		b.record(now, ok)
+	}
+	if state, _ := b.status(); state != breakerClosed {
+		t.Fatalf("below min_requests: %s, want closed", state)
+	}
+	b.acquire(now)
+	b.record(now, false)
+	if state, until := b.status(); state != breakerOpen || !until.Equal(now.Add(time.Minute)) {
+		t.Fatalf("2 of 4 failed: %s until %v, want open for a minute", state, until)
+	}
+	if b.available(now) || b.acquire(now) {
+		t.Error("open breaker let a request through")
+	}
+
+	later := now.Add(time.Minute)
+	if !b.acquire(later) || !b.acquire(later) || b.acquire(later) {
+		t.Fatal("half-open breaker did not let exactly 2 requests through")
+	}
+	b.release()
+	if !b.acquire(later) {
+		t.Error("released half-open slot not reused")
+	}
+	b.record(later, true)
+	b.record(later, false)
+	if state, _ := b.status(); state != breakerOpen {
+		t.Fatalf("half-open request failed: %s, want open", state)
+	}
+
+	later = later.Add(time.Minute)
+	b.acquire(later)
+	b.acquire(later)
+	b.record(later, true)
+	b.record(later, true)
+	if state, _ := b.status(); state != breakerClosed {
+		t.Errorf("half-open requests passed: %s, want closed", state)
+	}
+}
+
+func TestBreakerWindow(t *testing.T) {
+	b := newBreaker(CircuitBreaker{ErrorRate: 0.5, MinRequests: 2, Window: time.Second}, "test")
+	b.config.setDefaults()
+	now := time.Now()
+	b.record(now, false)
+	b.record(now.Add(2*time.Second), false)
+	if state, _ := b.status(); state != breakerClosed {
+		t.Errorf("failures in different windows tripped the breaker")
+	}
+}
+
+func TestBreakerProxy(t *testing.T) {
+	var down, up atomic.Int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		down.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		up.Add(1)
+	}))
+	defer good.Close()
+
+	config, err := loadConfig(writeConfig(t, `
+circuit_breaker:
+  min_requests: 2
+  open_for: 1m
+routes:
+  - path: /bad
+    upstream: `+bad.URL+`
+  - path: /
+    max_fails: 100
+    upstreams:
+      - url: `+bad.URL+`
+      - url: `+good.URL+`
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &ProxyServer{reverse: newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false), routes: config.Routes}
+	proxy.addBreakers(*config.CircuitBreaker)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	get := func(path string) *http.Response {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	get("/bad")
+	get("/bad")
+	resp := get("/bad")
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" || down.Load() != 2 {
+		t.Errorf("with the breaker open: got %d (Retry-After %q) after %d upstream requests, want a fast 503",
+			resp.StatusCode, resp.Header.Get("Retry-After"), down.Load())
+	}
+
+	// The other route's breaker for the same upstream is its own; once it
+	// opens, requests fail over to the working backend
+	down.Store(0)
+	for range 6 {
+		get("/v1/models")
+	}
+	if down.Load() != 2 || up.Load() != 4 {
+		t.Errorf("failing backend got %d requests and working one %d, want 2 and 4", down.Load(), up.Load())
+	}
+}
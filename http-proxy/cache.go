@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCache keeps the responses to reverse-proxied POSTs, such as
+// embeddings, and answers identical requests from it until they expire.
+type ResponseCache struct {
+	// TTL is how long a response is kept (default 10m)
+	TTL time.Duration `yaml:"ttl"`
+
+	// MaxBytes caps the size of the response bodies kept (default
+	// 100MiB). The least recently used go first, and one over an eighth
+	// of MaxBytes is not kept at all.
+	MaxBytes int64 `yaml:"max_bytes"`
+
+	// Paths are the request paths cached, matched as route paths are
+	// (default /v1/embeddings and /v1/chat/completions)
+	Paths []string `yaml:"paths"`
+}
+
+// setDefaults checks c and fills in what it leaves out.
+func (c *ResponseCache) setDefaults() error {
+	if c.TTL == 0 {
+		c.TTL = 10 * time.Minute
+	}
+	if c.TTL < 0 {
+		return fmt.Errorf("ttl must be positive")
+	}
+	if c.MaxBytes == 0 {
+		c.MaxBytes = 100 << 20
+	}
+	if c.MaxBytes < 0 {
+		return fmt.Errorf("max_bytes must be positive")
+	}
+	if len(c.Paths) == 0 {
+		c.Paths = []string{"/v1/embeddings", "/v1/chat/completions"}
+	}
+	for _, path := range c.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("path %q must start with /", path)
+		}
+	}
+	return nil
+}
+
+// responseCache is an LRU cache of responses by request. A nil
+// *responseCache caches nothing.
+type responseCache struct {
+	config  ResponseCache
+	metrics *metrics
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *cachedResponse
+	lru     *list.List               // most recently used first
+	size    int64
+}
+
+type cachedResponse struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+func newResponseCache(config ResponseCache) *responseCache {
+	return &responseCache{config: config, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// matches reports whether r is a request the cache is for.
+func (c *responseCache) matches(r *http.Request) bool {
+	if c == nil || r.Method != http.MethodPost {
+		return false
+	}
+	for _, path := range c.config.Paths {
+		if matchPath(path, r.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the cache key of r, whose body is body, or "" if its
+// response is not to be cached: its body is not JSON, or it streams. The
+// body is compared as JSON, so key order and spacing do not matter, and
+// without its user field, which names the end user rather than changing
+// the answer. The credentials are part of the key, so a client is only
+// answered from responses to requests it could have made itself.
+func (c *responseCache) key(r *http.Request, body []byte) string {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var fields map[string]any
+	if err := decoder.Decode(&fields); err != nil || fields == nil {
+		return ""
+	}
+	if stream, _ := fields["stream"].(bool); stream {
+		return ""
+	}
+	delete(fields, "user")
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		return ""
+	}
+
+	h := sha256.New()
+	for _, part := range []string{
+		r.URL.Path,
+		r.Header.Get("Authorization"),
+		r.Header.Get("Api-Key"),
+		r.Header.Get("OpenAI-Organization"),
+		r.Header.Get("OpenAI-Project"),
+		r.Header.Get("Accept-Encoding"),
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(canonical)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// get returns the response kept for key, if it has not expired.
+func (c *responseCache) get(key string, now time.Time) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*cachedResponse)
+	if now.After(entry.expires) {
+		c.remove(element)
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return entry
+}
+
+// put keeps entry, making room for it by dropping the least recently
+// used responses.
+func (c *responseCache) put(entry *cachedResponse) {
+	size := int64(len(entry.body))
+	if size > c.config.MaxBytes/8 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[entry.key]; ok {
+		c.remove(element)
+	}
+	for c.size+size > c.config.MaxBytes {
+		c.remove(c.lru.Back())
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += size
+}
+
+func (c *responseCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*cachedResponse)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.body))
+}
+
+// serve answers r from the cache if it can, and otherwise returns w
+// wrapped to keep the response, and the function to call once it has
+// been written. A client's Cache-Control: no-cache skips the cache for
+// the answer, and no-store also keeps the response out of it.
+func (c *responseCache) serve(w http.ResponseWriter, r *http.Request, body []byte) (http.ResponseWriter, func(), bool) {
+	noCache, noStore := cacheDirectives(r.Header)
+	key := c.key(r, body)
+	if key == "" || noStore {
+		return w, func() {}, false
+	}
+	now := time.Now()
+	if !noCache {
+		if entry := c.get(key, now); entry != nil {
+			c.metrics.cacheLookup("hit")
+			header := w.Header()
+			for name, values := range entry.header {
+				header[name] = values
+			}
+			header.Set("Age", strconv.Itoa(int(now.Sub(entry.stored).Seconds())))
+			header.Set("X-Cache", "HIT")
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			return w, nil, true
+		}
+	}
+	c.metrics.cacheLookup("miss")
+
+	cw := &cacheWriter{ResponseWriter: w, max: c.config.MaxBytes / 8}
+	return cw, func() {
+		if cw.status != http.StatusOK || cw.over || r.Context().Err() != nil {
+			return
+		}
+		if responseNoStore, _ := cacheDirectives(cw.header); responseNoStore {
+			return
+		}
+		c.put(&cachedResponse{key: key, status: cw.status, header: cw.header, body: cw.body, stored: now, expires: now.Add(c.config.TTL)})
+	}, false
+}
+
+// cacheDirectives reads the no-cache and no-store directives of a
+// Cache-Control header.
+func cacheDirectives(h http.Header) (noCache, noStore bool) {
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "no-cache":
+				noCache = true
+			case "no-store":
+				noCache, noStore = true, true
+			}
+		}
+	}
+	return noCache, noStore
+}
+
+// cacheWriter keeps a copy of a response as it is written, up to max
+// bytes of body. Streams are not kept. It passes flushes through.
+type cacheWriter struct {
+	http.ResponseWriter
+	max int64
+
+	status int
+	header http.Header
+	body   []byte
+	over   bool
+}
+
+// uncachedHeaders are response headers not kept with a response.
+var uncachedHeaders = []string{"Date", "Set-Cookie", "Age", "X-Cache", requestIDHeader}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+		w.over = strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+		w.header = w.Header().Clone()
+		for _, name := range uncachedHeaders {
+			w.header.Del(name)
+		}
+		w.Header().Set("X-Cache", "MISS")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	if err != nil {
+		w.over = true
+	}
+	if !w.over {
+		if int64(len(w.body)+n) > w.max {
+			w.over, w.body = true, nil
+		} else {
+			w.body = append(w.body, b[:n]...)
+		}
+	}
+	return n, err
+}
+
+func (w *cacheWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+	var calls atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		n := calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"object":"list","data":[{"embedding":[%d]}]}`, n)
+	}))
+	defer upstream.Close()
+	target, err := parseUpstream(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := ResponseCache{}
+	if err := config.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	p := &ProxyServer{upstream: singlePool(target), cache: newResponseCache(config)}
+	p.reverse = newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false)
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	post := func(path, body string, header ...string) (string, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, proxy.URL+path, strings.NewReader(body))
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		got, _ := io.ReadAll(resp.Body)
+		return string(got), resp.Header.Get("X-Cache")
+	}
+
+	first, result := post("/v1/embeddings", `{"model":"text-embedding-3-small","input":"hello","user":"alice"}`)
+	if result != "MISS" {
+		t.Errorf("First request X-Cache = %q, want MISS", result)
+	}
+	// The same request, in another order and for another user
+	second, result := post("/v1/embeddings", `{"input": "hello", "user": "bob", "model": "text-embedding-3-small"}`)
+	if result != "HIT" || second != first {
+		t.Errorf("Repeated request X-Cache = %q, body %s; want HIT, %s", result, second, first)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Upstream called %d times, want once", calls.Load())
+	}
+
+	// Each is sent twice, and only goes upstream the second time if it
+	// is not cached
+	for _, tt := range []struct {
+		name, path, body string
+		header           []string
+		calls            int64
+	}{
+		{"other input", "/v1/embeddings", `{"model":"text-embedding-3-small","input":"bye"}`, nil, 1},
+		{"other credentials", "/v1/embeddings", `{"model":"text-embedding-3-small","input":"hello"}`, []string{"Authorization", "Bearer other"}, 1},
+		{"no-cache", "/v1/embeddings", `{"model":"text-embedding-3-small","input":"hello"}`, []string{"Cache-Control", "no-cache"}, 2},
+		{"stream", "/v1/chat/completions", `{"model":"gpt-4o","stream":true}`, nil, 2},
+		{"path not cached", "/v1/responses", `{"model":"gpt-4o","input":"hello"}`, nil, 2},
+	} {
+		before := calls.Load()
+		post(tt.path, tt.body, tt.header...)
+		post(tt.path, tt.body, tt.header...)
+		if got := calls.Load() - before; got != tt.calls {
+			t.Errorf("%s: upstream called %d times, want %d", tt.name, got, tt.calls)
+		}
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	c := newResponseCache(ResponseCache{TTL: time.Minute, MaxBytes: 80})
+	now := time.Now()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		c.put(&cachedResponse{key: key, body: make([]byte, 10), stored: now, expires: now.Add(time.Minute)})
+		if key == "c" {
+			// Keep a in use, so b is the least recently used
+			c.get("a", now)
+		}
+	}
+	c.put(&cachedResponse{key: "big", body: make([]byte, 11), stored: now, expires: now.Add(time.Minute)})
+	if c.get("big", now) != nil {
+		t.Error("Kept a response over an eighth of max_bytes")
+	}
+
+	// Eight fit into 80 bytes; the ninth and tenth push out the least
+	// recently used: b, then c
+	for _, key := range []string{"f", "g", "h", "i", "j"} {
+		c.put(&cachedResponse{key: key, body: make([]byte, 10), stored: now, expires: now.Add(time.Minute)})
+	}
+	for key, want := range map[string]bool{"a": true, "b": false, "c": false, "d": true, "j": true} {
+		if got := c.get(key, now) != nil; got != want {
+			t.Errorf("%s kept = %v, want %v", key, got, want)
+		}
+	}
+	if c.get("a", now.Add(2*time.Minute)) != nil {
+		t.Error("Expired response served")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"mtls"
+)
+
+// Certificates are checked for expiry as they are loaded, and daily after:
+// any within -cert-warn-days of expiry, or past it, is warned of, and
+// -check-certs lists them all and exits non-zero if any is, for cron jobs
+// and CI.
+
+// certSource is a file of certificates the proxy loads, what for, and how
+// to open it if it is a PKCS #12 bundle.
+type certSource struct {
+	use, cert, key, keyPass string
+}
+
+// readCertSources reads the certificates in sources, skipping those with
+// no file.
+func readCertSources(sources ...certSource) ([]mtls.CertExpiry, error) {
+	var certs []mtls.CertExpiry
+	for _, s := range sources {
+		if s.cert == "" {
+			continue
+		}
+		read, err := mtls.ReadCertExpiry(s.use, s.cert, s.key, s.keyPass)
+		if err != nil {
+			return nil, fmt.Errorf("%s certificate: %w", s.use, err)
+		}
+		certs = append(certs, read...)
+	}
+	return certs, nil
+}
+
+// certMonitor holds the certificates the proxy has loaded, in groups that
+// are replaced together, such as those of the listener when -config is
+// reloaded, or an upstream certificate when it is renewed. It logs those
+// close to expiry, and the metrics report when each expires.
+type certMonitor struct {
+	warnDays int
+
+	mu     sync.Mutex
+	order  []string
+	groups map[string][]mtls.CertExpiry
+}
+
+func newCertMonitor(warnDays int) *certMonitor {
+	return &certMonitor{warnDays: warnDays, groups: make(map[string][]mtls.CertExpiry)}
+}
+
+// set replaces the certificates of group.
+func (m *certMonitor) set(group string, certs []mtls.CertExpiry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !slices.Contains(m.order, group) {
+		m.order = append(m.order, group)
+	}
+	m.groups[group] = certs
+}
+
+// all returns every certificate, by group in the order they were first
+// set.
+func (m *certMonitor) all() []mtls.CertExpiry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var certs []mtls.CertExpiry
+	for _, group := range m.order {
+		certs = append(certs, m.groups[group]...)
+	}
+	return certs
+}
+
+// logExpiring logs a warning for each of certs that expires within
+// -cert-warn-days, or has.
+func (m *certMonitor) logExpiring(certs []mtls.CertExpiry) {
+	for _, warning := range mtls.ExpiryWarnings(certs, time.Now(), m.warnDays) {
+		log.Printf("[CERT] Warning: %s", warning)
+	}
+}
+
+// watch logs the certificates close to expiry every interval, for as long
+// as the proxy runs.
+func (m *certMonitor) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.logExpiring(m.all())
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"mtls"
+)
+
+func TestReadCertSources(t *testing.T) {
+	// The test PKI's certificates expire in an hour
+	pki := newTestPKI(t)
+	certs, err := readCertSources(
+		certSource{"listener", pki.serverCertFile, pki.serverKeyFile, ""},
+		certSource{"client CA", pki.caFile, "", ""},
+		certSource{"upstream", "", "", ""},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || certs[0].Use != "listener" || certs[1].Subject != "CN=Proxy-Test-CA" {
+		t.Fatalf("certificates: %+v", certs)
+	}
+	if _, err := readCertSources(certSource{"client CA", pki.clientKeyFile, "", ""}); err == nil || !strings.HasPrefix(err.Error(), "client CA certificate: ") {
+		t.Errorf("key file read as certificates: %v", err)
+	}
+}
+
+func TestCertMonitor(t *testing.T) {
+	m := newCertMonitor(30)
+	expires := time.Now().Add(10 * 24 * time.Hour)
+	m.set("listener", []mtls.CertExpiry{{Use: "listener", Subject: "CN=old", Serial: "1", NotAfter: expires}})
+	m.set("upstream", []mtls.CertExpiry{{Use: "upstream", Subject: "CN=client", Serial: "2", NotAfter: expires}})
+	// A reload replaces the group, keeping its place
+	m.set("listener", []mtls.CertExpiry{{Use: "listener", Subject: "CN=new", Serial: "3", NotAfter: expires}})
+	if all := m.all(); len(all) != 2 || all[0].Subject != "CN=new" || all[1].Subject != "CN=client" {
+		t.Errorf("all: %+v", all)
+	}
+
+	body := scrape(t, &ProxyServer{metrics: newMetrics(), certs: m})
+	if !strings.Contains(body, `http_proxy_certificate_expiry_seconds{use="listener",subject="CN=new",serial="3"} 86`) {
+		t.Errorf("no expiry gauge for the listener:\n%s", body)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"openaitypes"
+)
+
+// completionEndpoints are the path suffixes of requests whose responses
+// are completions.
+var completionEndpoints = []string{"/chat/completions", "/completions", "/responses"}
+
+// hasCompletion reports whether r asks for a completion.
+func hasCompletion(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	for _, suffix := range completionEndpoints {
+		if strings.HasSuffix(r.URL.Path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// completionLog writes the completions of reverse-mode requests as JSON
+// lines, for -completion-log. Streamed completions are put back together
+// from their deltas.
+type completionLog struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// completionRecord is the completion-log entry for one request.
+type completionRecord struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Client     string    `json:"client"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Upstream   string    `json:"upstream,omitempty"`
+	ID         string    `json:"id,omitempty"`
+	Model      string    `json:"model,omitempty"`
+	Stream     bool      `json:"stream"`
+	Choices    []*choice `json:"choices"`
+	Incomplete bool      `json:"incomplete,omitempty"`
+	Usage      *tokens   `json:"usage,omitempty"`
+	DurationMS float64   `json:"duration_ms"`
+
+	done bool // the stream ended as it should
+}
+
+// choice is one completion of a request: there are several if it set n.
+type choice struct {
+	Index        int         `json:"index"`
+	Text         string      `json:"text"`
+	ToolCalls    []*toolCall `json:"tool_calls,omitempty"`
+	FinishReason string      `json:"finish_reason,omitempty"`
+
+	text strings.Builder
+}
+
+type toolCall struct {
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+
+	arguments strings.Builder
+}
+
+// completionChunk holds the fields of a completion, a stream chunk of
+// one, or a Responses API event that make up the completion log. The Chat
+// Completions API puts text in choices as message or delta, the legacy
+// Completions API as text, and the Responses API in output items, or
+// output_text deltas when streaming.
+type completionChunk struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index        int                      `json:"index"`
+		Text         string                   `json:"text"`
+		Message      *openaitypes.ChatMessage `json:"message"`
+		Delta        *openaitypes.ChatMessage `json:"delta"`
+		FinishReason string                   `json:"finish_reason"`
+	} `json:"choices"`
+
+	Type     string           `json:"type"`
+	Delta    string           `json:"delta"`
+	Response *completionChunk `json:"response"`
+	Usage    *tokenUsage      `json:"usage"`
+	Output   []struct {
+		Type      string `json:"type"`
+		CallID    string `json:"call_id"`
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+		Content   []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	} `json:"output"`
+	Status string `json:"status"`
+}
+
+// add adds a completion, or a piece of one, to rec.
+func (rec *completionRecord) add(chunk *completionChunk) {
+	if rec.ID == "" {
+		rec.ID = chunk.ID
+	}
+	if rec.Model == "" {
+		rec.Model = chunk.Model
+	}
+	if chunk.Usage != nil {
+		rec.Usage = chunk.Usage.tokens()
+	}
+	for _, c := range chunk.Choices {
+		ch := rec.choice(c.Index)
+		ch.text.WriteString(c.Text)
+		if c.FinishReason != "" {
+			ch.FinishReason = c.FinishReason
+		}
+		message, streamed := c.Delta, true
+		if message == nil {
+			message, streamed = c.Message, false
+		}
+		if message == nil {
+			continue
+		}
+		ch.text.WriteString(message.Content.GetText())
+		for i, tc := range message.ToolCalls {
+			// Whole messages list their tool calls in order; deltas say
+			// which one they add to
+			if streamed && tc.Index != nil {
+				i = *tc.Index
+			}
+			call := ch.toolCall(i)
+			if tc.ID != "" {
+				call.ID = tc.ID
+			}
+			if tc.Function.Name != "" {
+				call.Name = tc.Function.Name
+			}
+			call.arguments.WriteString(tc.Function.Arguments)
+		}
+	}
+
+	switch chunk.Type {
+	case "response.output_text.delta":
+		rec.choice(0).text.WriteString(chunk.Delta)
+	case "response.completed", "response.incomplete", "response.failed":
+		// The whole response, which replaces the deltas so far
+		rec.done = true
+		if chunk.Response != nil {
+			rec.Choices = nil
+			rec.add(chunk.Response)
+		}
+		return
+	}
+	if len(chunk.Output) > 0 {
+		ch := rec.choice(0)
+		ch.FinishReason = chunk.Status
+		for _, item := range chunk.Output {
+			switch item.Type {
+			case "message":
+				for _, content := range item.Content {
+					if content.Type == "output_text" {
+						ch.text.WriteString(content.Text)
+					}
+				}
+			case "function_call":
+				call := ch.toolCall(len(ch.ToolCalls))
+				call.ID, call.Name = item.CallID, item.Name
+				call.arguments.WriteString(item.Arguments)
+			}
+		}
+	}
+}
+
+func (rec *completionRecord) choice(index int) *choice {
+	for len(rec.Choices) <= index {
+		rec.Choices = append(rec.Choices, &choice{Index: len(rec.Choices)})
+	}
+	return rec.Choices[index]
+}
+
+func (ch *choice) toolCall(index int) *toolCall {
+	for len(ch.ToolCalls) <= index {
+		ch.ToolCalls = append(ch.ToolCalls, &toolCall{})
+	}
+	return ch.ToolCalls[index]
+}
+
+// completionWriter reads the completion in a response as it is written to
+// the client. Each write goes to the client before it is looked at, so
+// streams are not held up. It passes flushes through.
+type completionWriter struct {
+	http.ResponseWriter
+	rec *completionRecord
+
+	capture bool
+	stream  bool
+	pending []byte // a stream's unfinished line, or a whole response so far
+	data    []byte // the data lines of a stream's unfinished event
+}
+
+func newCompletionWriter(w http.ResponseWriter, r *http.Request) *completionWriter {
+	return &completionWriter{
+		ResponseWriter: w,
+		rec: &completionRecord{
+			Time:      time.Now(),
+			RequestID: recordOf(r).RequestID,
+			Client:    recordOf(r).Client,
+			Path:      r.URL.Path,
+		},
+	}
+}
+
+func (w *completionWriter) WriteHeader(code int) {
+	if w.rec.Status == 0 && code >= 200 {
+		w.rec.Status = code
+		h := w.Header()
+		w.stream = strings.HasPrefix(h.Get("Content-Type"), "text/event-stream")
+		w.capture = code < 300 && h.Get("Content-Encoding") == "" &&
+			(w.stream || strings.HasPrefix(h.Get("Content-Type"), "application/json"))
+		w.rec.Stream = w.stream
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *completionWriter) Write(b []byte) (int, error) {
+	if w.rec.Status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	if !w.capture {
+		return n, err
+	}
+	if !w.stream {
+		if len(w.pending)+n > maxModelBody {
+			w.capture, w.pending = false, nil
+			w.rec.Incomplete = true
+		} else {
+			w.pending = append(w.pending, b[:n]...)
+		}
+		return n, err
+	}
+
+	w.pending = append(w.pending, b[:n]...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(bytes.TrimSuffix(w.pending[:i], []byte("\r")))
+		w.pending = w.pending[i+1:]
+	}
+	return n, err
+}
+
+// line takes in one line of a server-sent event stream. An event's data
+// lines are joined, and the event handled at the blank line ending it.
+func (w *completionWriter) line(line []byte) {
+	if len(line) == 0 {
+		w.event()
+		return
+	}
+	if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+		if len(w.data) > 0 {
+			w.data = append(w.data, '\n')
+		}
+		w.data = append(w.data, bytes.TrimPrefix(data, []byte(" "))...)
+	}
+}
+
+func (w *completionWriter) event() {
+	data := w.data
+	w.data = nil
+	if len(data) == 0 {
+		return
+	}
+	if string(data) == "[DONE]" {
+		w.rec.done = true
+		return
+	}
+	var chunk completionChunk
+	if json.Unmarshal(data, &chunk) == nil {
+		w.rec.add(&chunk)
+	}
+}
+
+func (w *completionWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *completionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish reads what is left of the response and returns the completion
+// in it, served by upstream, or nil if it had none.
+func (w *completionWriter) finish(upstream string) *completionRecord {
+	if w == nil || !w.capture && !w.rec.Incomplete {
+		return nil
+	}
+	rec := w.rec
+	if w.capture && !w.stream {
+		var chunk completionChunk
+		if json.Unmarshal(w.pending, &chunk) != nil {
+			return nil
+		}
+		rec.add(&chunk)
+	} else if w.stream {
+		// A stream cut off mid-event still logs what arrived
+		w.event()
+		rec.Incomplete = !rec.done
+	}
+	rec.Upstream = upstream
+	rec.DurationMS = float64(time.Since(rec.Time).Microseconds()) / 1000
+	for _, ch := range rec.Choices {
+		ch.Text = ch.text.String()
+		for _, call := range ch.ToolCalls {
+			call.Arguments = call.arguments.String()
+		}
+	}
+	return rec
+}
+
+// log writes rec to the completion log.
+func (l *completionLog) log(rec *completionRecord) {
+	if l == nil || rec == nil {
+		return
+	}
+	line, _ := json.Marshal(rec)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(line, '\n'))
+}
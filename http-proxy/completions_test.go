@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// completionUpstream answers /v1/chat/completions with a stream of the
+// text and a tool call in pieces, and /v1/responses with a stream ending
+// in the whole response. Anything else gets a chat completion as one JSON
+// object.
+func completionUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		var events []string
+		switch r.URL.Path {
+		case "/v1/chat/completions":
+			events = []string{
+				`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+				`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"lo"}}]}`,
+				`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"get_weather","arguments":"{\"ci"}}]}}]}`,
+				`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"ty\":\"Paris\"}"}}]}}]}`,
+				`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+				`[DONE]`,
+			}
+		case "/v1/responses":
+			events = []string{
+				`{"type":"response.output_text.delta","output_index":0,"delta":"Bon"}`,
+				`{"type":"response.output_text.delta","output_index":0,"delta":"jour"}`,
+				`{"type":"response.completed","response":{"id":"resp_1","model":"gpt-4o","status":"completed","output":[{"type":"message","content":[{"type":"output_text","text":"Bonjour"}]}]}}`,
+			}
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"id":"chatcmpl-2","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			io.WriteString(w, "data: "+event+"\n\n")
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCompletionLog(t *testing.T) {
+	upstream := completionUpstream(t)
+	target, err := parseUpstream(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(lines, 10)
+	p := &ProxyServer{upstream: singlePool(target), completions: &completionLog{out: out}}
+	p.reverse = newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false)
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	tests := []struct {
+		path string
+		want completionRecord
+	}{
+		{"/v1/chat/completions", completionRecord{ID: "chatcmpl-1", Stream: true, Choices: []*choice{{
+			Text:         "Hello",
+			ToolCalls:    []*toolCall{{ID: "call_1", Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+			FinishReason: "tool_calls",
+		}}}},
+		{"/v1/responses", completionRecord{ID: "resp_1", Stream: true, Choices: []*choice{{Text: "Bonjour", FinishReason: "completed"}}}},
+		{"/v1/completions", completionRecord{ID: "chatcmpl-2", Choices: []*choice{{Text: "Hi", FinishReason: "stop"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := http.Post(proxy.URL+tt.path, "application/json", strings.NewReader(`{"model":"gpt-4o","stream":true}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if !strings.Contains(string(body), tt.want.ID) {
+				t.Errorf("Client got %q, want the upstream's response", body)
+			}
+
+			var got completionRecord
+			if err := json.Unmarshal([]byte(out.next(t)), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != tt.want.ID || got.Model != "gpt-4o" || got.Stream != tt.want.Stream || got.Status != http.StatusOK || got.Incomplete {
+				t.Errorf("Record %+v, want ID %s, stream %v, status 200, complete", got, tt.want.ID, tt.want.Stream)
+			}
+			if got.Upstream != target.Host || got.Path != tt.path {
+				t.Errorf("Record upstream %s, path %s; want %s, %s", got.Upstream, got.Path, target.Host, tt.path)
+			}
+			gotChoices, _ := json.Marshal(got.Choices)
+			wantChoices, _ := json.Marshal(tt.want.Choices)
+			if string(gotChoices) != string(wantChoices) {
+				t.Errorf("Choices %s, want %s", gotChoices, wantChoices)
+			}
+		})
+	}
+
+	// Other requests are not logged
+	resp, err := http.Get(proxy.URL + "/v1/models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case line := <-out:
+		t.Errorf("Logged %s for /v1/models", line)
+	default:
+	}
+}
+
+func TestCompletionLogIncomplete(t *testing.T) {
+	w := newCompletionWriter(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+	w.Header().Set("Content-Type", "text/event-stream")
+	io.WriteString(w, "data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+	io.WriteString(w, "data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"lo\"}}]}\n")
+
+	out := make(lines, 1)
+	(&completionLog{out: out}).log(w.finish("upstream"))
+	var got completionRecord
+	if err := json.Unmarshal([]byte(out.next(t)), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Incomplete || len(got.Choices) != 1 || got.Choices[0].Text != "Hello" {
+		t.Errorf("Record %+v, want incomplete with the text so far", got)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// -upstream-compression settings.
+const (
+	compressionPassthrough = "passthrough"
+	compressionRecompress  = "recompress"
+	compressionIdentity    = "identity"
+)
+
+// parseCompression checks -upstream-compression, returning "" for
+// passthrough.
+func parseCompression(setting string) (string, error) {
+	switch setting {
+	case "", compressionPassthrough:
+		return "", nil
+	case compressionRecompress, compressionIdentity:
+		return setting, nil
+	}
+	return "", fmt.Errorf("invalid -upstream-compression %q: must be %s, %s or %s",
+		setting, compressionPassthrough, compressionRecompress, compressionIdentity)
+}
+
+// negotiateCompression applies -upstream-compression to a request before
+// it is proxied. With identity the upstream is asked not to compress. With
+// recompress the client's Accept-Encoding is dropped, so the transport
+// asks for gzip itself and decompresses the response, and the response is
+// compressed again for the client if it accepts gzip; finish must be
+// called once it is written. Anything else is left as the client and
+// upstream agree, compressed or not.
+func (p *ProxyServer) negotiateCompression(w http.ResponseWriter, r *http.Request) (_ http.ResponseWriter, finish func()) {
+	finish = func() {}
+	if p.compression == "" || r.Method == http.MethodConnect || upgradeType(r.Header) != "" {
+		return w, finish
+	}
+	if p.compression == compressionIdentity {
+		r.Header.Set("Accept-Encoding", "identity")
+		return w, finish
+	}
+	gzipped := acceptsGzip(r.Header)
+	r.Header.Del("Accept-Encoding")
+	if !gzipped || r.Method == http.MethodHead {
+		return w, finish
+	}
+	gw := &gzipWriter{ResponseWriter: w}
+	return gw, gw.close
+}
+
+// acceptsGzip reports whether a client's Accept-Encoding allows gzip.
+func acceptsGzip(h http.Header) bool {
+	for _, value := range h.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "gzip" && name != "x-gzip" && name != "*" {
+				continue
+			}
+			q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+			if !found {
+				return true
+			}
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gzipWriter compresses a response for a client that accepts gzip. Streams
+// are left alone, so each event reaches the client as soon as it is sent,
+// as are responses with no body or an encoding already. It passes flushes
+// through, flushing the compressor first, and hijacks.
+type gzipWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer // nil unless the response is compressed
+	wroteHeader bool
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= 200 {
+		w.wroteHeader = true
+		h := w.Header()
+		if code != http.StatusNoContent && code != http.StatusNotModified &&
+			h.Get("Content-Encoding") == "" && !strings.HasPrefix(h.Get("Content-Type"), "text/event-stream") {
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length")
+			h.Add("Vary", "Accept-Encoding")
+			w.gz = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// close writes the end of the compressed body.
+func (w *gzipWriter) close() {
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                   false,
+		"gzip":               true,
+		"gzip, deflate, br":  true,
+		"br, GZIP;q=0.5":     true,
+		"gzip;q=0":           false,
+		"*":                  true,
+		"identity":           false,
+		"deflate, x-gzip":    true,
+		"br;q=1.0, gzip;q=0": false,
+	} {
+		if got := acceptsGzip(http.Header{"Accept-Encoding": {accept}}); got != want {
+			t.Errorf("Accept-Encoding %q: got %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestUpstreamCompression(t *testing.T) {
+	const body = `{"object":"list","data":[{"embedding":[0.1,0.2,0.3]}]}`
+	// The upstream gzips when asked, and says what it was asked for
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Accept-Encoding", r.Header.Get("Accept-Encoding"))
+		if r.URL.Path == "/stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: [DONE]\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			io.WriteString(w, body)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		io.WriteString(gz, body)
+		gz.Close()
+	}))
+	defer upstream.Close()
+	target, _ := parseUpstream(upstream.URL)
+
+	for _, tt := range []struct {
+		compression, accept string
+		path                string
+		wantUpstream        string // Accept-Encoding
+		wantEncoding        string // of the response to the client
+	}{
+		{"", "gzip", "/", "gzip", "gzip"},
+		{"", "", "/", "", ""},
+		{compressionRecompress, "gzip, br", "/", "gzip", "gzip"},
+		{compressionRecompress, "br", "/", "gzip", ""},
+		{compressionRecompress, "gzip", "/stream", "gzip", ""},
+		{compressionIdentity, "gzip", "/", "identity", ""},
+	} {
+		options := defaultTransportOptions
+		options.decompress = tt.compression == compressionRecompress
+		forward := &ProxyServer{compression: tt.compression}
+		forward.setTransports(options)
+		forwardServer := httptest.NewServer(forward)
+		forwardURL, _ := url.Parse(forwardServer.URL)
+		reverseServer := httptest.NewServer(&ProxyServer{
+			reverse:     newReverseProxy(newTransport(options, nil), nil, false),
+			upstream:    singlePool(target),
+			compression: tt.compression,
+		})
+
+		for mode, get := range map[string]struct {
+			url   string
+			proxy *url.URL
+		}{
+			"forward": {upstream.URL + tt.path, forwardURL},
+			"reverse": {reverseServer.URL + tt.path, nil},
+		} {
+			// A client that shows the response as it came
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(get.proxy), DisableCompression: true}}
+			req, _ := http.NewRequest(http.MethodGet, get.url, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var reader io.Reader = resp.Body
+			if resp.Header.Get("Content-Encoding") == "gzip" {
+				if reader, err = gzip.NewReader(resp.Body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := io.ReadAll(reader)
+			resp.Body.Close()
+
+			name := mode + " " + tt.compression + " " + tt.accept + " " + tt.path
+			if gotUpstream := resp.Header.Get("X-Accept-Encoding"); gotUpstream != tt.wantUpstream {
+				t.Errorf("%s: upstream asked for %q, want %q", name, gotUpstream, tt.wantUpstream)
+			}
+			if encoding := resp.Header.Get("Content-Encoding"); encoding != tt.wantEncoding {
+				t.Errorf("%s: client got Content-Encoding %q, want %q", name, encoding, tt.wantEncoding)
+			}
+			want := body
+			if tt.path == "/stream" {
+				want = "data: [DONE]\n\n"
+			}
+			if string(got) != want || err != nil {
+				t.Errorf("%s: client read %q, %v", name, got, err)
+			}
+		}
+		forwardServer.Close()
+		reverseServer.Close()
+	}
+}
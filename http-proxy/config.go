@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the document read from -config.
+type Config struct {
+	// Routes are tried in order in reverse mode; the first that matches a
+	// request picks its upstream
+	Routes []Route `yaml:"routes"`
+
+	// HealthCheck, if set, probes every upstream in the background
+	HealthCheck *HealthCheck `yaml:"health_check"`
+
+	// Retry, if set, retries failed requests on the route's next backend
+	Retry *RetryPolicy `yaml:"retry"`
+
+	// CircuitBreaker, if set, gives every backend a circuit breaker
+	CircuitBreaker *CircuitBreaker `yaml:"circuit_breaker"`
+
+	// Usage, if set, counts the tokens each client uses of each model
+	Usage *UsageAccounting `yaml:"usage"`
+
+	// Cache, if set, answers repeated requests with the response kept from
+	// the first
+	Cache *ResponseCache `yaml:"cache"`
+
+	// Access, if set, says which clients may connect and, in forward mode,
+	// which hosts they may reach and the credentials they must give
+	Access *Access `yaml:"access"`
+
+	// TLS, if set, serves the proxy over TLS
+	TLS *ListenerTLS `yaml:"tls"`
+
+	// UpstreamTLS, if set, is the client certificate and CA for TLS to
+	// upstreams, by host if they have their own
+	UpstreamTLS *UpstreamTLS `yaml:"upstream_tls"`
+}
+
+// Route sends requests whose path starts with Path and whose body names a
+// model matching Model to Upstream, or balances them over Upstreams, or
+// fails over from one to the next, renaming models in RewriteModel. An
+// empty Path or Model matches any.
+type Route struct {
+	Path         string            `yaml:"path"`
+	Model        string            `yaml:"model"`
+	Upstream     string            `yaml:"upstream"`
+	Upstreams    []Upstream        `yaml:"upstreams"`
+	RewriteModel map[string]string `yaml:"rewrite_model"`
+
+	// Azure, if set, translates requests for the route's upstreams, Azure
+	// OpenAI resources, from the OpenAI API's paths and credentials
+	Azure *AzureDialect `yaml:"azure"`
+
+	// Headers, if set, are set on or removed from the route's requests
+	Headers *HeaderRewrite `yaml:"headers"`
+
+	// Balancing over Upstreams: round-robin (the default),
+	// least-in-flight or failover, and how many failures in a row take a
+	// backend out of rotation (default 3) and for how long (default 30s)
+	Balance     string        `yaml:"balance"`
+	MaxFails    int           `yaml:"max_fails"`
+	FailTimeout time.Duration `yaml:"fail_timeout"`
+
+	// Sticky keeps the requests of a user or session on one of Upstreams,
+	// for as long as it is in rotation, so that the upstream's prompt
+	// cache serves them: user, by the user field of the body, or
+	// header:<name>. Requests without one are balanced as usual.
+	Sticky string `yaml:"sticky"`
+
+	pool *pool
+}
+
+// Upstream is one of a route's backends. Weight (default 1) sets its
+// share of requests.
+type Upstream struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"`
+
+	// The API key for this upstream alone, replacing the client's and
+	// -api-key: read from the environment variable APIKeyEnv or the file
+	// APIKeyFile, and sent in APIKeyHeader (default Authorization)
+	APIKeyEnv    string `yaml:"api_key_env"`
+	APIKeyFile   string `yaml:"api_key_file"`
+	APIKeyHeader string `yaml:"api_key_header"`
+
+	// RewriteModel renames models for this upstream alone, after the
+	// route's RewriteModel
+	RewriteModel map[string]string `yaml:"rewrite_model"`
+
+	// Azure, if set, translates requests for this upstream, in place of
+	// the route's Azure
+	Azure *AzureDialect `yaml:"azure"`
+}
+
+// matches reports whether a request for path naming model takes the route.
+func (route *Route) matches(path, model string) bool {
+	return (route.Path == "" || matchPath(route.Path, path)) && matchModel(route.Model, model)
+}
+
+// needsModel reports whether any route looks at the model in request
+// bodies, which then have to be read before routing.
+func (c *Config) needsModel() bool {
+	for _, route := range c.Routes {
+		if route.Model != "" || len(route.RewriteModel) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfig reads and checks a config file.
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		if route.Path == "" && route.Model == "" {
+			return nil, fmt.Errorf("%s: route %d has neither path nor model", path, i+1)
+		}
+		if route.Path != "" && !strings.HasPrefix(route.Path, "/") {
+			return nil, fmt.Errorf("%s: route %d: path %q must start with /", path, i+1, route.Path)
+		}
+		if !validModelPattern(route.Model) {
+			return nil, fmt.Errorf("%s: route %d: invalid model pattern %q", path, i+1, route.Model)
+		}
+		if route.Azure != nil {
+			if err := route.Azure.setDefaults(); err != nil {
+				return nil, fmt.Errorf("%s: route %d: azure: %w", path, i+1, err)
+			}
+		}
+		if route.Headers != nil {
+			if err := route.Headers.setDefaults(); err != nil {
+				return nil, fmt.Errorf("%s: route %d: headers: %w", path, i+1, err)
+			}
+		}
+		if route.pool, err = route.buildPool(); err != nil {
+			return nil, fmt.Errorf("%s: route %d: %w", path, i+1, err)
+		}
+	}
+	if config.HealthCheck != nil {
+		if err := config.HealthCheck.setDefaults(); err != nil {
+			return nil, fmt.Errorf("%s: health_check: %w", path, err)
+		}
+	}
+	if config.Retry != nil {
+		if err := config.Retry.setDefaults(); err != nil {
+			return nil, fmt.Errorf("%s: retry: %w", path, err)
+		}
+	}
+	if config.CircuitBreaker != nil {
+		if err := config.CircuitBreaker.setDefaults(); err != nil {
+			return nil, fmt.Errorf("%s: circuit_breaker: %w", path, err)
+		}
+	}
+	if config.Usage != nil {
+		if err := config.Usage.setDefaults(); err != nil {
+			return nil, fmt.Errorf("%s: usage: %w", path, err)
+		}
+	}
+	if config.Cache != nil {
+		if err := config.Cache.setDefaults(); err != nil {
+			return nil, fmt.Errorf("%s: cache: %w", path, err)
+		}
+	}
+	if config.Access != nil {
+		if err := config.Access.setDefaults(); err != nil {
+			return nil, fmt.Errorf("%s: access: %w", path, err)
+		}
+	}
+	if config.TLS != nil {
+		if err := config.TLS.setDefaults(); err != nil {
+			return nil, fmt.Errorf("%s: tls: %w", path, err)
+		}
+	}
+	if config.UpstreamTLS != nil {
+		if err := config.UpstreamTLS.setDefaults(); err != nil {
+			return nil, fmt.Errorf("%s: upstream_tls: %w", path, err)
+		}
+	}
+	return &config, nil
+}
+
+// buildPool checks the route's upstreams and makes its pool.
+func (route *Route) buildPool() (*pool, error) {
+	upstreams := route.Upstreams
+	switch {
+	case route.Upstream != "" && len(upstreams) > 0:
+		return nil, fmt.Errorf("give upstream or upstreams, not both")
+	case route.Upstream != "":
+		upstreams = []Upstream{{URL: route.Upstream}}
+	case len(upstreams) == 0:
+		return nil, fmt.Errorf("no upstream")
+	}
+
+	urls := make([]*url.URL, len(upstreams))
+	weights := make([]int, len(upstreams))
+	for i, u := range upstreams {
+		parsed, err := parseUpstream(u.URL)
+		if err != nil {
+			return nil, err
+		}
+		if u.Weight < 0 {
+			return nil, fmt.Errorf("negative weight for %s", u.URL)
+		}
+		urls[i], weights[i] = parsed, u.Weight
+	}
+	p, err := newPool(route.Balance, route.MaxFails, route.FailTimeout, urls, weights)
+	if err != nil {
+		return nil, err
+	}
+	if !validSticky(route.Sticky) {
+		return nil, fmt.Errorf("invalid sticky %q: must be user or header:<name>", route.Sticky)
+	}
+	if route.Sticky != "" && p.strategy == failover {
+		return nil, fmt.Errorf("sticky does not apply to balance: %s", failover)
+	}
+	p.sticky = route.Sticky
+	p.headers = route.Headers
+
+	for i, u := range upstreams {
+		b := p.backends[i]
+		if u.APIKeyEnv != "" && u.APIKeyFile != "" {
+			return nil, fmt.Errorf("%s: give api_key_env or api_key_file, not both", u.URL)
+		}
+		key, err := loadAPIKey("", u.APIKeyEnv, u.APIKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", u.URL, err)
+		}
+		if key != "" {
+			header := u.APIKeyHeader
+			if header == "" {
+				header = "Authorization"
+			}
+			b.apiKey = &apiKeyInjector{key: key, header: header, hosts: hostPatterns{"*"}}
+		} else if u.APIKeyHeader != "" {
+			return nil, fmt.Errorf("%s: api_key_header needs api_key_env or api_key_file", u.URL)
+		}
+		b.rewriteModel = u.RewriteModel
+		b.azure = route.Azure
+		if u.Azure != nil {
+			if err := u.Azure.setDefaults(); err != nil {
+				return nil, fmt.Errorf("%s: azure: %w", u.URL, err)
+			}
+			b.azure = u.Azure
+		}
+	}
+	return p, nil
+}
+
+// matchPath reports whether path is prefix or below it: /v1/embeddings
+// matches /v1/embeddings and /v1/embeddings/x but not /v1/embeddingsx.
+func matchPath(prefix, path string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// describeRoute names what a route matches, for the startup log.
+func describeRoute(route Route) string {
+	var parts []string
+	if route.Path != "" {
+		parts = append(parts, route.Path)
+	}
+	if route.Model != "" {
+		parts = append(parts, "model "+route.Model)
+	}
+	if route.Azure != nil {
+		parts = append(parts, "(Azure, api-version "+route.Azure.APIVersion+")")
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "proxy.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRouting(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name+" "+r.URL.Path)
+		}))
+	}
+	embeddings, chat, fallback := backend("embeddings"), backend("chat"), backend("fallback")
+	defer embeddings.Close()
+	defer chat.Close()
+	defer fallback.Close()
+
+	config, err := loadConfig(writeConfig(t, `
+routes:
+  - path: /v1/embeddings
+    upstream: `+embeddings.URL+`
+  - path: /v1/chat/
+    upstream: `+chat.URL+`/openai
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &ProxyServer{reverse: newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false), routes: config.Routes}
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	for path, want := range map[string]string{
+		"/v1/embeddings":        "embeddings /v1/embeddings",
+		"/v1/chat/completions":  "chat /openai/v1/chat/completions",
+		"/v1/embeddings-legacy": "",
+		"/v1/models":            "",
+	} {
+		status, body := get(path)
+		if want == "" {
+			if status != http.StatusNotFound {
+				t.Errorf("%s: got %d %q, want 404 without a fallback", path, status, body)
+			}
+			continue
+		}
+		if body != want {
+			t.Errorf("%s: got %q, want %q", path, body, want)
+		}
+	}
+
+	target, _ := parseUpstream(fallback.URL)
+	proxy.upstream = singlePool(target)
+	if _, body := get("/v1/models"); body != "fallback /v1/models" {
+		t.Errorf("unrouted request went to %q, want the fallback", body)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for name, yaml := range map[string]string{
+		"relative path":          "routes:\n  - path: v1/models\n    upstream: http://localhost\n",
+		"bad upstream":           "routes:\n  - path: /v1\n    upstream: localhost:8000\n",
+		"unknown field":          "routes:\n  - path: /v1\n    upstream: http://localhost\n    weight: 2\n",
+		"no upstream":            "routes:\n  - path: /v1\n",
+		"both":                   "routes:\n  - path: /v1\n    upstream: http://a\n    upstreams:\n      - url: http://b\n",
+		"bad balance":            "routes:\n  - path: /v1\n    upstreams:\n      - url: http://a\n    balance: random\n",
+		"bad sticky":             "routes:\n  - path: /v1\n    upstream: http://a\n    sticky: ip\n",
+		"bad weight":             "routes:\n  - path: /v1\n    upstreams:\n      - url: http://a\n        weight: -1\n",
+		"bad check":              "health_check:\n  type: icmp\n",
+		"bad budget":             "retry:\n  budget: 2\n",
+		"bad rate":               "circuit_breaker:\n  error_rate: -0.5\n",
+		"bad client":             "usage:\n  client: user\n",
+		"bad price":              "usage:\n  prices:\n    - model: gpt-4o\n      input: -1\n",
+		"bad deployment":         "routes:\n  - path: /v1\n    upstream: http://a\n    azure:\n      deployments:\n        gpt-4o: a/b\n",
+		"bad header":             "routes:\n  - path: /v1\n    upstream: http://a\n    headers:\n      set:\n        Host: b\n",
+		"bad range":              "access:\n  allow_clients: [10.0.0.0/33]\n",
+		"no auth file":           "access:\n  proxy_auth_file: /nonexistent\n",
+		"tls without key":        "tls:\n  cert: /nonexistent\n",
+		"per_host no hosts":      "upstream_tls:\n  per_host:\n    - ca: /nonexistent\n",
+		"per_host no key":        "upstream_tls:\n  per_host:\n    - hosts: [a.internal]\n      cert: /nonexistent\n",
+		"per_host renew no cert": "upstream_tls:\n  per_host:\n    - hosts: [a.internal]\n      renew_url: https://ca.internal/ca/sign\n",
+	} {
+		if _, err := loadConfig(writeConfig(t, yaml)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, path string
+		want         bool
+	}{
+		{"/v1/embeddings", "/v1/embeddings", true},
+		{"/v1/embeddings", "/v1/embeddings/x", true},
+		{"/v1/embeddings", "/v1/embeddingsx", false},
+		{"/v1/", "/v1/models", true},
+		{"/", "/anything", true},
+		{"/v1", "/v2", false},
+	} {
+		if got := matchPath(tc.prefix, tc.path); got != tc.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tc.prefix, tc.path, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+)
+
+// failoverTransport sends the requests of a route with balance: failover
+// to its backends in the order they are listed: on to the next when one
+// cannot be reached or answers with a 5xx or 429, until one answers or
+// every one has been tried. Unlike retries, this is immediate and applies
+// to POSTs too, since the backends are separate providers. Failures of all
+// but the last backend tried are counted here; the last is left to the
+// reverse proxy. Requests of other routes pass straight through.
+type failoverTransport struct {
+	base    http.RoundTripper
+	metrics *metrics
+}
+
+func (ft *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := targetOf(req)
+	if t.pool.strategy != failover || len(t.pool.backends) == 1 || !replayable(req) {
+		return ft.base.RoundTrip(req)
+	}
+
+	tried := make(map[*backend]bool)
+	for {
+		resp, err := ft.base.RoundTrip(req)
+		if err == nil && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			// The client gave up, or -request-timeout ran out
+			return resp, err
+		}
+		tried[t.backend] = true
+		next := t.pool.next(tried, t.in.Path, t.model)
+		if next == nil {
+			return resp, err
+		}
+
+		var reason string
+		if err != nil {
+			markFailed(t)
+			reason = err.Error()
+		} else {
+			if resp.StatusCode == http.StatusTooManyRequests {
+				// Busy rather than failing
+				t.pool.succeeded(t.backend)
+			} else {
+				markFailed(t)
+			}
+			reason = resp.Status
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		ft.metrics.failover(t.backend.url.Host)
+		t.backend.inFlight.Add(-1)
+		next.inFlight.Add(1)
+		log.Printf("[FAILOVER] %s %s to %s failed (%s); trying %s", req.Method, t.in.Path, t.backend.url.Host, reason, next.url.Host)
+		t.backend = next
+
+		if req, err = retarget(req, t); err != nil {
+			return nil, err
+		}
+	}
+}
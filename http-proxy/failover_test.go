@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// providerRequest is what a fake provider got.
+type providerRequest struct {
+	path, auth, apiKey, model string
+}
+
+// fakeProvider answers every request with status, keeping what it got.
+type fakeProvider struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	requests []providerRequest
+}
+
+func newFakeProvider(t *testing.T, status int) *fakeProvider {
+	p := &fakeProvider{status: status}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.requests = append(p.requests, providerRequest{r.URL.RequestURI(), r.Header.Get("Authorization"), r.Header.Get("Api-Key"), body.Model})
+		w.WriteHeader(p.status)
+		io.WriteString(w, `{"served_by":"`+r.Host+`"}`)
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakeProvider) got() []providerRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]providerRequest(nil), p.requests...)
+}
+
+func TestFailover(t *testing.T) {
+	openai := newFakeProvider(t, http.StatusInternalServerError)
+	azure := newFakeProvider(t, http.StatusTooManyRequests)
+	vllm := newFakeProvider(t, http.StatusOK)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	t.Setenv("TEST_OPENAI_KEY", "sk-openai")
+	azureKey := filepath.Join(t.TempDir(), "azure.key")
+	os.WriteFile(azureKey, []byte("azure-key\n"), 0o600)
+	config, err := loadConfig(writeConfig(t, `
+routes:
+  - path: /v1/
+    balance: failover
+    upstreams:
+      - url: `+down.URL+`
+      - url: `+openai.URL+`
+        api_key_env: TEST_OPENAI_KEY
+      - url: `+azure.URL+`
+        api_key_file: `+azureKey+`
+        azure:
+          deployments:
+            gpt-4o: prod-gpt4o
+      - url: `+vllm.URL+`
+        rewrite_model:
+          gpt-4o: meta-llama/Llama-3.1-70B-Instruct
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ProxyServer{reverse: newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false), routes: config.Routes, metrics: newMetrics()}
+	p.reverse.Transport = &failoverTransport{base: p.reverse.Transport, metrics: p.metrics}
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	post := func() (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, proxy.URL+"/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[]}`))
+		req.Header.Set("Authorization", "Bearer sk-client")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// Each provider in turn gets the request in its own dialect, with its
+	// own credentials, until one answers
+	if status, body := post(); status != http.StatusOK || !strings.Contains(body, strings.TrimPrefix(vllm.URL, "http://")) {
+		t.Fatalf("Got %d %s, want 200 from the last provider", status, body)
+	}
+	for _, tt := range []struct {
+		name     string
+		provider *fakeProvider
+		want     providerRequest
+	}{
+		{"openai", openai, providerRequest{"/v1/chat/completions", "Bearer sk-openai", "", "gpt-4o"}},
+		{"azure", azure, providerRequest{"/openai/deployments/prod-gpt4o/chat/completions?api-version=2024-10-21", "", "azure-key", "gpt-4o"}},
+		{"vllm", vllm, providerRequest{"/v1/chat/completions", "Bearer sk-client", "", "meta-llama/Llama-3.1-70B-Instruct"}},
+	} {
+		if got := tt.provider.got(); len(got) != 1 || got[0] != tt.want {
+			t.Errorf("%s got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+	if body := scrape(t, p); !strings.Contains(body, `http_proxy_failovers_total{upstream="`+strings.TrimPrefix(openai.URL, "http://")+`"} 1`) {
+		t.Error("Failover from openai not counted")
+	}
+
+	// A client error is the client's, and is not failed over
+	openai.mu.Lock()
+	openai.status = http.StatusBadRequest
+	openai.mu.Unlock()
+	if status, _ := post(); status != http.StatusBadRequest {
+		t.Errorf("Got %d, want openai's 400", status)
+	}
+	if got := azure.got(); len(got) != 1 {
+		t.Errorf("Azure got %d requests after a 400, want none", len(got)-1)
+	}
+
+	// When every provider fails, the last one's answer is passed back
+	vllm.mu.Lock()
+	vllm.status = http.StatusServiceUnavailable
+	vllm.mu.Unlock()
+	openai.mu.Lock()
+	openai.status = http.StatusBadGateway
+	openai.mu.Unlock()
+	if status, _ := post(); status != http.StatusServiceUnavailable {
+		t.Errorf("Got %d, want the last provider's 503", status)
+	}
+}
@@ -1,3 +1,23 @@
 module http-proxy
 
 go 1.25.1
+
+require (
+	gopkg.in/yaml.v3 v3.0.1
+	mtls v0.0.0
+	openai-mock-server v0.0.0
+	openaitypes v0.0.0
+)
+
+require (
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/miekg/pkcs11 v1.1.1 // indirect
+	golang.org/x/sys v0.38.0 // indirect
+	golang.org/x/term v0.37.0 // indirect
+)
+
+replace mtls => ../mtls
+
+replace openai-mock-server => ../openai-mock-server
+
+replace openaitypes => ../openaitypes
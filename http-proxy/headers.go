@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HeaderRewrite changes the headers of a route's requests before they go
+// upstream, as if the client had sent them so: the headers in Remove are
+// dropped, then those in Set replace any the request has. Credentials set
+// here are the client's as far as upstreams' own API keys are concerned.
+type HeaderRewrite struct {
+	Set    map[string]string `yaml:"set"`
+	Remove []string          `yaml:"remove"`
+}
+
+func (h *HeaderRewrite) setDefaults() error {
+	names := append([]string(nil), h.Remove...)
+	for name, value := range h.Set {
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("value of %s has a line break", name)
+		}
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if http.CanonicalHeaderKey(name) == "Host" {
+			return fmt.Errorf("the Host header is the upstream's, from its URL")
+		}
+	}
+	return nil
+}
+
+// apply rewrites header. A nil HeaderRewrite leaves it as it is.
+func (h *HeaderRewrite) apply(header http.Header) {
+	if h == nil {
+		return
+	}
+	for _, name := range h.Remove {
+		header.Del(name)
+	}
+	for name, value := range h.Set {
+		header.Set(name, value)
+	}
+}
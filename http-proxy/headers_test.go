@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHeaderRewrite(t *testing.T) {
+	h := &HeaderRewrite{
+		Set:    map[string]string{"openai-organization": "org-123", "X-Debug": "off"},
+		Remove: []string{"X-Debug", "Cookie"},
+	}
+	if err := h.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"X-Debug": {"on"}, "Cookie": {"a=b"}, "Accept": {"*/*"}}
+	h.apply(header)
+	want := http.Header{"Openai-Organization": {"org-123"}, "X-Debug": {"off"}, "Accept": {"*/*"}}
+	if len(header) != len(want) {
+		t.Fatalf("got %v, want %v", header, want)
+	}
+	for name, values := range want {
+		if got := header.Values(name); len(got) != 1 || got[0] != values[0] {
+			t.Errorf("%s: got %v, want %v", name, got, values)
+		}
+	}
+
+	var none *HeaderRewrite
+	none.apply(header)
+
+	for _, bad := range []HeaderRewrite{
+		{Set: map[string]string{"host": "example.com"}},
+		{Set: map[string]string{"X-A": "one\r\nX-B: two"}},
+		{Set: map[string]string{"X A": "one"}},
+		{Remove: []string{""}},
+	} {
+		if err := bad.setDefaults(); err == nil {
+			t.Errorf("%+v accepted", bad)
+		}
+	}
+}
+
+func TestConnectionHeaders(t *testing.T) {
+	// Headers the Connection header names go no further than the proxy,
+	// either way
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var got []string
+		for _, name := range []string{"X-Hop", "X-Other-Hop", "Proxy-Connection", "X-Kept"} {
+			if r.Header.Get(name) != "" {
+				got = append(got, name)
+			}
+		}
+		w.Header().Set("X-Received", strings.Join(got, ","))
+		w.Header().Set("Connection", "X-Upstream-Hop")
+		w.Header().Set("X-Upstream-Hop", "1")
+		w.Header().Set("X-Upstream-Kept", "1")
+	}))
+	defer upstream.Close()
+	target, _ := parseUpstream(upstream.URL)
+	reverse := httptest.NewServer(&ProxyServer{
+		reverse:  newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false),
+		upstream: singlePool(target),
+	})
+	defer reverse.Close()
+	forward := httptest.NewServer(&ProxyServer{})
+	defer forward.Close()
+	forwardURL, _ := url.Parse(forward.URL)
+
+	for name, client := range map[string]struct {
+		*http.Client
+		url string
+	}{
+		"forward": {&http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(forwardURL)}}, upstream.URL},
+		"reverse": {http.DefaultClient, reverse.URL},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, client.url, nil)
+		req.Header.Set("Connection", "X-Hop, keep-alive")
+		req.Header.Add("Connection", "x-other-hop")
+		req.Header.Set("X-Hop", "1")
+		req.Header.Set("X-Other-Hop", "1")
+		req.Header.Set("Proxy-Connection", "keep-alive")
+		req.Header.Set("X-Kept", "1")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-Received"); got != "X-Kept" {
+			t.Errorf("%s: upstream got %s, want only X-Kept", name, got)
+		}
+		if resp.Header.Get("X-Upstream-Hop") != "" || resp.Header.Get("X-Upstream-Kept") == "" {
+			t.Errorf("%s: client got %v, want X-Upstream-Kept and not X-Upstream-Hop", name, resp.Header)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Kinds of health check.
+const (
+	checkHTTP = "http"
+	checkTCP  = "tcp"
+	checkTLS  = "tls"
+)
+
+// HealthCheck says how upstreams are probed: an HTTP GET of Path below the
+// upstream URL, where any response but a 5xx passes, or just a TCP
+// connection or TLS handshake.
+type HealthCheck struct {
+	Type     string        `yaml:"type"`
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// setDefaults checks c and fills in what it leaves out.
+func (c *HealthCheck) setDefaults() error {
+	switch c.Type {
+	case "":
+		c.Type = checkHTTP
+	case checkHTTP, checkTCP, checkTLS:
+	default:
+		return fmt.Errorf("invalid type %q: must be %s, %s or %s", c.Type, checkHTTP, checkTCP, checkTLS)
+	}
+	if c.Path == "" {
+		c.Path = "/v1/models"
+	} else if !strings.HasPrefix(c.Path, "/") {
+		return fmt.Errorf("path %q must start with /", c.Path)
+	}
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	return nil
+}
+
+// describeHealthCheck says what a check does, for the startup log.
+func describeHealthCheck(c HealthCheck) string {
+	if c.Type == checkHTTP {
+		return "GET " + c.Path
+	}
+	return c.Type + " connect"
+}
+
+// healthChecker probes backends with the same TLS settings and API key as
+// proxied requests, so a check fails if they would.
+type healthChecker struct {
+	check     HealthCheck
+	tlsConfig *tls.Config
+	apiKey    *apiKeyInjector
+	client    *http.Client
+
+	// hosts, if set, gives upstreams with a PKI of their own their TLS
+	// settings, in place of tlsConfig
+	hosts hostTLS
+
+	// dial makes tcp and tls checks' connections
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func newHealthChecker(check HealthCheck, tlsConfig *tls.Config, apiKey *apiKeyInjector) *healthChecker {
+	c := &healthChecker{
+		check:     check,
+		tlsConfig: tlsConfig,
+		apiKey:    apiKey,
+		dial:      (&net.Dialer{}).DialContext,
+	}
+	c.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return c.dial(ctx, network, addr)
+			},
+			TLSClientConfig:   tlsConfig,
+			DisableKeepAlives: true,
+		},
+		Timeout: check.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return c
+}
+
+// useHostTLS makes c check the upstreams of rules with their own TLS
+// settings, as requests to them are made.
+func (c *healthChecker) useHostTLS(rules hostTLS) {
+	if len(rules) == 0 {
+		return
+	}
+	c.hosts = rules
+	c.client.Transport = newHostTransport(c.client.Transport.(*http.Transport), rules)
+}
+
+// watch checks b now and then every interval, until ctx is done.
+func (c *healthChecker) watch(ctx context.Context, b *backend) {
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, c.check.Timeout)
+		err := c.probe(probeCtx, b.url, b.apiKey)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		c.record(b, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.check.Interval):
+		}
+	}
+}
+
+// probe checks one upstream, whose own API key, if it has one, is key.
+func (c *healthChecker) probe(ctx context.Context, upstream *url.URL, key *apiKeyInjector) error {
+	addr := upstreamAddr(upstream)
+	switch c.check.Type {
+	case checkTCP:
+		conn, err := c.dial(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case checkTLS:
+		config := &tls.Config{}
+		if own := c.hosts.lookup(upstream.Host); own != nil {
+			config = own.Clone()
+		} else if c.tlsConfig != nil {
+			config = c.tlsConfig.Clone()
+		}
+		config.ServerName = upstream.Hostname()
+		raw, err := c.dial(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		conn := tls.Client(raw, config)
+		defer conn.Close()
+		return conn.HandshakeContext(ctx)
+	}
+
+	probe := *upstream
+	probe.Path = strings.TrimSuffix(upstream.Path, "/") + c.check.Path
+	probe.RawPath = ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probe.String(), nil)
+	if err != nil {
+		return err
+	}
+	c.apiKey.apply(req.Header, upstream.Host)
+	key.apply(req.Header, upstream.Host)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("GET %s: %s", probe.Path, resp.Status)
+	}
+	return nil
+}
+
+// record stores the result of a check of b, logging when it changes b's
+// health.
+func (c *healthChecker) record(b *backend, err error) {
+	b.mu.Lock()
+	wasDown := b.checkDown
+	b.checked = time.Now()
+	b.checkErr = err
+	b.checkDown = err != nil
+	b.mu.Unlock()
+
+	switch {
+	case err != nil && !wasDown:
+		log.Printf("[HEALTH] %s unhealthy: %v", b.url.Host, err)
+	case err == nil && wasDown:
+		log.Printf("[HEALTH] %s healthy again", b.url.Host)
+	}
+}
+
+// upstreamAddr returns the host:port to dial for an upstream URL.
+func upstreamAddr(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
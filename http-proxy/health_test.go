@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckProbe(t *testing.T) {
+	pki := newTestPKI(t)
+	status := http.StatusOK
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/openai/v1/models" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	upstream.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	config, err := loadUpstreamTLS(pki.clientCertFile, pki.clientKeyFile, "", pki.caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _ := url.Parse(strings.Replace(upstream.URL, "127.0.0.1", "localhost", 1) + "/openai")
+	key := &apiKeyInjector{key: "sk-test", header: "Authorization", hosts: hostPatterns{"*"}}
+
+	for _, tc := range []struct {
+		kind   string
+		status int
+		ok     bool
+	}{
+		{checkHTTP, http.StatusOK, true},
+		{checkHTTP, http.StatusUnauthorized, true},
+		{checkHTTP, http.StatusServiceUnavailable, false},
+		{checkTCP, http.StatusServiceUnavailable, true},
+		{checkTLS, http.StatusServiceUnavailable, true},
+	} {
+		check := HealthCheck{Type: tc.kind}
+		if err := check.setDefaults(); err != nil {
+			t.Fatal(err)
+		}
+		status = tc.status
+		err := newHealthChecker(check, config, key).probe(context.Background(), target, nil)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s check with %d: error = %v, want ok %v", tc.kind, tc.status, err, tc.ok)
+		}
+	}
+
+	check := HealthCheck{Type: checkTLS}
+	check.setDefaults()
+	if err := newHealthChecker(check, nil, nil).probe(context.Background(), target, nil); err == nil {
+		t.Error("TLS check passed without the client certificate or CA")
+	}
+}
+
+func TestHealthCheckRotation(t *testing.T) {
+	p := testPool(t, roundRobin, 1, 1)
+	a := p.backends[0]
+	checker := newHealthChecker(HealthCheck{Type: checkTCP}, nil, nil)
+
+	checker.record(a, context.DeadlineExceeded)
+	if got := picks(p, 3); got != "bbb" {
+		t.Errorf("with a failing its check: got %s, want bbb", got)
+	}
+	p.succeeded(a)
+	if a.healthy(time.Now()) {
+		t.Error("a request success put a back before its check passed")
+	}
+	checker.record(a, nil)
+	if got := picks(p, 2); got != "ab" && got != "ba" {
+		t.Errorf("with a passing again: got %s, want both", got)
+	}
+}
+
+func TestAdminHealth(t *testing.T) {
+	proxy := &ProxyServer{routes: []Route{{Path: "/v1/", pool: testPool(t, roundRobin, 1, 1)}}}
+	admin := httptest.NewServer(proxy.adminHandler())
+	defer admin.Close()
+
+	get := func() (int, []routeStatus) {
+		resp, err := http.Get(admin.URL + "/admin/health")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body struct{ Routes []routeStatus }
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, body.Routes
+	}
+
+	checker := newHealthChecker(HealthCheck{}, nil, nil)
+	checker.record(proxy.routes[0].pool.backends[0], context.DeadlineExceeded)
+	code, routes := get()
+	if code != http.StatusOK || len(routes) != 1 || len(routes[0].Backends) != 2 {
+		t.Fatalf("got %d %+v, want 200 and one route of two backends", code, routes)
+	}
+	if a := routes[0].Backends[0]; a.Healthy || a.Error == "" || a.Checked == nil {
+		t.Errorf("failed backend reported as %+v", a)
+	}
+
+	checker.record(proxy.routes[0].pool.backends[1], context.DeadlineExceeded)
+	if code, _ := get(); code != http.StatusServiceUnavailable {
+		t.Errorf("with no healthy backend: got %d, want 503", code)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"mtls"
+)
+
+// ListenerTLS is the tls section of -config: the proxy's certificate and
+// key, and the client certificates it asks for, as with -tls-cert,
+// -tls-key, -key-pass, -client-ca and -client-auth.
+type ListenerTLS struct {
+	Cert       string `yaml:"cert"`
+	Key        string `yaml:"key"`
+	KeyPass    string `yaml:"key_pass"`
+	ClientCA   string `yaml:"client_ca"`
+	ClientAuth string `yaml:"client_auth"`
+
+	config *tls.Config
+}
+
+func (l *ListenerTLS) setDefaults() error {
+	config, err := loadListenerTLS(l.Cert, l.Key, l.KeyPass, l.ClientCA, l.ClientAuth)
+	if err != nil {
+		return err
+	}
+	l.config = config
+	return nil
+}
+
+// certSources are the files of l's certificate and client CA.
+func (l *ListenerTLS) certSources() []certSource {
+	return []certSource{{"listener", l.Cert, l.Key, l.KeyPass}, {"client CA", l.ClientCA, "", ""}}
+}
+
+// loadListenerTLS builds the TLS configuration for the proxy's own
+// listener from its certificate and key, with the passphrase of the key
+// from keyPass. Client certificates are checked against caFile according
+// to clientAuth: "require" (the default when caFile is given), "optional"
+// to verify one only if the client sends it, or "none".
+func loadListenerTLS(certFile, keyFile, keyPass, caFile, clientAuth string) (*tls.Config, error) {
+	c := mtls.Config{CertFile: certFile, KeyFile: keyFile, KeyPass: keyPass, CAFile: caFile}
+	if clientAuth == "" {
+		clientAuth = "none"
+		if caFile != "" {
+			clientAuth = "require"
+		}
+	}
+	switch clientAuth {
+	case "none":
+		if caFile != "" {
+			return nil, fmt.Errorf("a client CA has no effect with client auth none")
+		}
+	case "require":
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("invalid client auth %q: must be require, optional or none", clientAuth)
+	}
+	if clientAuth != "none" && caFile == "" {
+		return nil, fmt.Errorf("client auth %s needs a client CA", clientAuth)
+	}
+
+	config, err := mtls.LoadServerTLSConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("listener: %w", err)
+	}
+	return config, nil
+}
+
+// describeClientAuth summarises a listener's client certificate policy
+// for the startup log.
+func describeClientAuth(config *tls.Config) string {
+	switch config.ClientAuth {
+	case tls.RequireAndVerifyClientCert:
+		return "client certificates required"
+	case tls.VerifyClientCertIfGiven:
+		return "client certificates verified if sent"
+	}
+	return "no client certificates"
+}
+
+// describeKey names the algorithm of a certificate's key, such as ECDSA
+// P-256, for the startup log.
+func describeKey(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + key.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
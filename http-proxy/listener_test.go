@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenerTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("X-Forwarded-Proto"))
+	}))
+	defer upstream.Close()
+	target, _ := parseUpstream(upstream.URL)
+
+	for _, tc := range []struct {
+		clientAuth string
+		withCert   bool
+		wantOK     bool
+	}{
+		{"require", true, true},
+		{"require", false, false},
+		{"optional", false, true},
+		{"optional", true, true},
+	} {
+		config, err := loadListenerTLS(pki.serverCertFile, pki.serverKeyFile, "", pki.caFile, tc.clientAuth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxy := httptest.NewUnstartedServer(&ProxyServer{reverse: newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false), upstream: singlePool(target)})
+		proxy.TLS = config
+		proxy.StartTLS()
+
+		clientConfig := &tls.Config{RootCAs: pki.pool}
+		if tc.withCert {
+			clientConfig.Certificates = []tls.Certificate{pki.client}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+		resp, err := client.Get(proxy.URL + "/v1/models")
+		proxy.Close()
+
+		if !tc.wantOK {
+			if err == nil {
+				resp.Body.Close()
+				t.Errorf("%s without a certificate: got %d, want a handshake failure", tc.clientAuth, resp.StatusCode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s, certificate %v: %v", tc.clientAuth, tc.withCert, err)
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "https" {
+			t.Errorf("upstream saw X-Forwarded-Proto %q, want https", body)
+		}
+	}
+}
+
+func TestLoadListenerTLSErrors(t *testing.T) {
+	pki := newTestPKI(t)
+	for _, tc := range []struct{ cert, ca, clientAuth string }{
+		{"", "", ""},
+		{pki.serverCertFile, pki.caFile, "none"},
+		{pki.serverCertFile, "", "require"},
+		{pki.serverCertFile, pki.caFile, "sometimes"},
+	} {
+		if _, err := loadListenerTLS(tc.cert, pki.serverKeyFile, "", tc.ca, tc.clientAuth); err == nil {
+			t.Errorf("loadListenerTLS(%q, %q, %q) succeeded", tc.cert, tc.ca, tc.clientAuth)
+		}
+	}
+
+	config, err := loadListenerTLS(pki.serverCertFile, pki.serverKeyFile, "", pki.caFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("-client-ca alone: ClientAuth %v, want required", config.ClientAuth)
+	}
+}
+
+// TestKeyFiles checks that the listener and upstream take encrypted keys
+// and PKCS #12 bundles, using the files of the mtls package's tests.
+func TestKeyFiles(t *testing.T) {
+	certFile := filepath.Join("..", "mtls", "testdata", "encrypted-client.crt")
+	if _, err := loadListenerTLS(certFile, filepath.Join("..", "mtls", "testdata", "encrypted-pbes2.key"), "pass:correct-horse", "", ""); err != nil {
+		t.Error(err)
+	}
+	config, err := loadUpstreamTLS(certFile, filepath.Join("..", "mtls", "testdata", "encrypted-legacy.key"), "pass:correct-horse", "")
+	if err != nil || len(config.Certificates) != 1 {
+		t.Errorf("upstream: %v", err)
+	}
+
+	bundle := filepath.Join("..", "mtls", "testdata", "identity.p12")
+	if config, err = loadUpstreamTLS(bundle, "pass:correct-horse", "", ""); err != nil || len(config.Certificates) != 1 {
+		t.Errorf("upstream bundle: %v", err)
+	}
+	if _, err := loadListenerTLS(bundle, "pass:correct-horse", "", "", ""); err != nil {
+		t.Error(err)
+	}
+
+	// Only a bundle may go without a key
+	if _, err := loadUpstreamTLS(filepath.Join("..", "mtls", "testdata", "plain.p12"), "", "", ""); err != nil {
+		t.Error(err)
+	}
+	if _, err := loadUpstreamTLS(certFile, "", "", ""); err == nil {
+		t.Error("PEM certificate loaded without a key")
+	}
+}
+
+// TestKeyTypes checks that the listener and upstream TLS settings work with
+// each kind of key certificates are issued for, over TLS 1.2 and 1.3.
+func TestKeyTypes(t *testing.T) {
+	pki := newTestPKI(t)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+
+	for i, tt := range []struct {
+		key  crypto.Signer
+		want string
+	}{
+		{rsaKey, "RSA 2048"},
+		{p384Key, "ECDSA P-384"},
+		{ed25519Key, "Ed25519"},
+	} {
+		serial := int64(10 + 2*i)
+		server, serverCert, serverKey := issueTestCert(t, pki.dir, pki.ca, pki.caKey, serial, tt.key, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "localhost-" + tt.want},
+			DNSNames:    []string{"localhost"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		client, clientCert, clientKey := issueTestCert(t, pki.dir, pki.ca, pki.caKey, serial+1, tt.key, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "client-" + tt.want},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if got := describeKey(server.Leaf); got != tt.want {
+			t.Errorf("describeKey: got %q, want %q", got, tt.want)
+		}
+
+		listener, err := loadListenerTLS(serverCert, serverKey, "", pki.caFile, "require")
+		if err != nil {
+			t.Fatal(err)
+		}
+		upstream, err := loadUpstreamTLS(clientCert, clientKey, "", pki.caFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := describeClientCert(upstream), " presenting "+client.Leaf.Subject.CommonName+" ("+tt.want+")"; got != want {
+			t.Errorf("describeClientCert: got %q, want %q", got, want)
+		}
+		for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+			listener.MaxVersion, upstream.MaxVersion = version, version
+			upstream.ServerName = "localhost"
+			serverConn, clientConn := net.Pipe()
+			errs := make(chan error, 1)
+			go func() {
+				errs <- tls.Server(serverConn, listener).Handshake()
+				serverConn.Close()
+			}()
+			err := tls.Client(clientConn, upstream).Handshake()
+			clientConn.Close()
+			if serverErr := <-errs; err != nil || serverErr != nil {
+				t.Errorf("%s over %s: client %v, server %v", tt.want, tls.VersionName(version), err, serverErr)
+			}
+		}
+	}
+}
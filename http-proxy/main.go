@@ -1,39 +1,770 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"mtls"
 )
 
 var (
-	port    = flag.Int("port", 8080, "Proxy server port")
+	port    = flag.Int("port", 8080, "Proxy server port; 0 with -listen-unix to listen only on the socket")
 	verbose = flag.Bool("verbose", false, "Enable verbose logging")
+
+	// Unix socket listener
+	listenUnix     = flag.String("listen-unix", "", "Also listen on a Unix socket at this path, as on -port; disabled if empty")
+	listenUnixMode = flag.String("listen-unix-mode", "0660", "Permissions of the -listen-unix socket, in octal: who may connect to it")
+
+	// TLS listener
+	tlsCert    = flag.String("tls-cert", "", "Certificate for serving the proxy over TLS (with -tls-key), PEM or a PKCS #12 .p12/.pfx bundle; plain HTTP if empty")
+	tlsKey     = flag.String("tls-key", "", "Key for -tls-cert, or a pkcs11: URI naming a key on a token; for a PKCS #12 -tls-cert, its passphrase as pass:, env: or file:")
+	keyPass    = flag.String("key-pass", "", "Passphrase of encrypted -tls-key, -upstream-key and -mitm-ca-key files: pass:<passphrase>, env:<variable>, file:<path> or prompt; asked for on the terminal if empty")
+	clientCA   = flag.String("client-ca", "", "CA bundle for verifying client certificates on the TLS listener")
+	clientAuth = flag.String("client-auth", "", "Client certificates on the TLS listener: require (default with -client-ca), optional or none")
+
+	// Certificate expiry
+	certWarnDays = flag.Int("cert-warn-days", 30, "Warn of certificates the proxy loads that expire within this many days, at startup and daily")
+	checkCerts   = flag.Bool("check-certs", false, "Check the certificates the proxy would load with the other flags, print when each expires and exit: non-zero if any expires within -cert-warn-days or has")
+
+	// Client allowlist
+	allowClients = flag.String("allow-clients", "", "Comma-separated CIDR ranges or addresses clients may connect from, on the proxy and SOCKS5 ports; others are closed at once. Any client if empty")
+
+	// Destination policy
+	allowHosts   = flag.String("allow-hosts", "", "Forward mode: comma-separated hosts that may be reached (exact, *.domain or *, optionally with :port); any host if empty")
+	denyHosts    = flag.String("deny-hosts", "", "Forward mode: comma-separated hosts that may not be reached, even if allowed")
+	connectPorts = flag.String("connect-ports", "443", "Forward mode: comma-separated ports CONNECT tunnels may reach, or * for any")
+
+	// Tunnels
+	tunnelIdleTimeout = flag.Duration("tunnel-idle-timeout", 10*time.Minute, "Close CONNECT and SOCKS5 tunnels with no traffic either way for this long (0 for never)")
+	connectSNI        = flag.String("connect-sni", "off", "Forward mode: take CONNECT tunnels to the server name in the TLS ClientHello, checked against -allow-hosts and -deny-hosts: off, on (CONNECT host if there is none) or require")
+
+	// PROXY protocol
+	proxyProtocolFrom = flag.String("proxy-protocol-from", "", "Comma-separated CIDR ranges of load balancers whose connections start with a PROXY protocol v1 or v2 header giving the client's address; none if empty")
+	sendProxyProtocol = flag.String("send-proxy-protocol", "", "Forward mode: start CONNECT and SOCKS5 tunnels' connections with a PROXY protocol header giving the client's address: v1 or v2; none if empty")
+
+	// SOCKS5
+	socksPort     = flag.Int("socks-port", 0, "Forward mode: also accept SOCKS5 clients on this port; disabled if 0")
+	socksUpstream = flag.String("socks-upstream", "", "Make every outgoing connection through this SOCKS5 proxy (socks5://[user:password@]host:port)")
+
+	// DNS overrides
+	resolve = flag.String("resolve", "", "Comma-separated host=address overrides for outgoing connections, such as api.openai.com=10.0.0.5:8443; hosts as for -allow-hosts, and an address without a port keeps the one asked for")
+
+	// Proxy authentication
+	proxyAuthFile = flag.String("proxy-auth-file", "", "Forward mode: file of user:password (Basic) and Bearer <token> lines; clients must send one in Proxy-Authorization")
+
+	// Rate limiting
+	rateLimit = flag.String("rate-limit", "", "Requests each client may make, such as 10/s, 600/m or 5000/h; unlimited if empty")
+	rateBurst = flag.Int("rate-burst", 0, "Requests a client may make at once before -rate-limit applies (default: the count in -rate-limit)")
+	rateKey   = flag.String("rate-key", "ip", "What identifies a client for -rate-limit: ip, cert (the client certificate subject) or header:<name>")
+
+	// Reverse mode
+	mode       = flag.String("mode", "forward", "Proxy mode: forward (HTTP proxy with CONNECT tunneling) or reverse (send requests to -upstream or the routes in -config)")
+	upstream   = flag.String("upstream", "", "Reverse mode: URL that requests are forwarded to, with their path appended (e.g. https://api.openai.com); with -config, for requests no route matches")
+	configFile = flag.String("config", "", "YAML config file with the reverse-mode routing table, access lists and TLS settings; reloaded on SIGHUP and when it changes")
+	configPoll = flag.Duration("config-poll", 5*time.Second, "How often to check -config for changes (0 to reload it only on SIGHUP)")
+
+	// Upstream mTLS
+	upstreamCert     = flag.String("upstream-cert", "", "Client certificate presented to the -upstream in reverse mode, or to -upstream-hosts in forward mode")
+	upstreamKey      = flag.String("upstream-key", "", "Key for -upstream-cert, or a pkcs11: URI naming a key on a token; for a PKCS #12 -upstream-cert, its passphrase as pass:, env: or file:")
+	upstreamCA       = flag.String("upstream-ca", "", "CA bundle for verifying the upstream (default: system roots)")
+	upstreamRenewURL = flag.String("upstream-renew-url", "", "CA service that renews -upstream-cert two thirds of the way through its life, such as the mock server's https://localhost:8000/ca/sign (default: read -upstream-cert and -upstream-key again then)")
+	upstreamHosts    = flag.String("upstream-hosts", "", "Forward mode: comma-separated hosts (exact, *.domain or *, optionally with :port) that plain HTTP requests are forwarded to over TLS with -upstream-cert")
+
+	// API key injection
+	apiKey       = flag.String("api-key", "", "API key sent upstream in place of the client's, so applications need not hold it")
+	apiKeyEnv    = flag.String("api-key-env", "", "Read the API key from this environment variable instead")
+	apiKeyFile   = flag.String("api-key-file", "", "Read the API key from this file instead")
+	apiKeyHeader = flag.String("api-key-header", "Authorization", "Header carrying the API key: Authorization (as a bearer token) or another, such as api-key for Azure")
+	apiKeyHosts  = flag.String("api-key-hosts", "", "Forward mode: hosts that get the API key, as for -upstream-hosts (default: -upstream-hosts)")
+
+	// Timeouts
+	dialTimeout           = flag.Duration("dial-timeout", 30*time.Second, "Longest wait to connect to an upstream or CONNECT destination (0 for no limit)")
+	tlsHandshakeTimeout   = flag.Duration("tls-handshake-timeout", 10*time.Second, "Longest wait for the TLS handshake with an upstream (0 for no limit)")
+	responseHeaderTimeout = flag.Duration("response-header-timeout", 0, "Longest wait for an upstream's response headers once the request is sent (0 for no limit)")
+	requestTimeout        = flag.Duration("request-timeout", 0, "Longest a request may take in all; streams are exempt once they start (0 for no limit)")
+	readHeaderTimeout     = flag.Duration("read-header-timeout", 30*time.Second, "Longest wait for a client's request headers (0 for no limit)")
+
+	// Request bodies
+	maxBodySize = flag.Int64("max-body-size", 0, "Largest request body accepted, in bytes; larger ones are refused with 413 (0 for no limit)")
+
+	// Streaming
+	streamBufferSize    = flag.Int("stream-buffer-size", 0, "Size in bytes of the buffer responses are streamed to clients through (default: 4096 for forward-mode streams, 32768 in reverse mode)")
+	streamFlushInterval = flag.Duration("stream-flush-interval", 0, "Flush streamed responses to clients at most this often, sending what arrives in between together (0 to flush each read as it arrives)")
+
+	// Connection pooling
+	maxIdleConns        = flag.Int("max-idle-conns", defaultTransportOptions.maxIdleConns, "Idle upstream connections kept open in total (0 for no limit)")
+	maxIdleConnsPerHost = flag.Int("max-idle-conns-per-host", defaultTransportOptions.maxIdleConnsPerHost, "Idle connections kept open to each upstream host")
+	maxConnsPerHost     = flag.Int("max-conns-per-host", defaultTransportOptions.maxConnsPerHost, "Connections to each upstream host, in use or idle, beyond which requests wait (0 for no limit)")
+	idleConnTimeout     = flag.Duration("idle-conn-timeout", defaultTransportOptions.idleConnTimeout, "How long an idle upstream connection is kept open (0 for no limit)")
+
+	// Upstream protocols
+	upstreamHTTP1 = flag.Bool("upstream-http1", false, "Speak only HTTP/1.1 to upstreams, even those that offer HTTP/2 in the TLS handshake")
+	upstreamH2C   = flag.String("upstream-h2c", "", "Comma-separated plaintext (http://) upstream hosts to speak HTTP/2 to without TLS (h2c), as for -upstream-hosts")
+
+	// Compression
+	upstreamCompression = flag.String("upstream-compression", compressionPassthrough, "Response compression: passthrough (as the client and upstream agree), recompress (gzip from upstreams, decompressed for the logs and cache, and compressed again for clients that accept it) or identity (never compressed)")
+
+	// Access log
+	accessLogFormat = flag.String("access-log", "text", "Access log format: text (a log line per request), json (a JSON object per line) or combined (Apache combined)")
+	accessLogFile   = flag.String("access-log-file", "", "File to append json or combined access records to (default: stdout)")
+
+	// Completion log
+	completionLogFile = flag.String("completion-log", "", "Reverse mode, or -mitm-hosts: file to append each completion to as a JSON line, streams put back together (- for stdout); disabled if empty")
+
+	// TLS interception
+	mitmHosts  = flag.String("mitm-hosts", "", "Forward mode: comma-separated hosts whose CONNECT tunnels are decrypted and proxied request by request, for debugging (e.g. api.openai.com); clients must trust -mitm-ca-cert")
+	mitmCACert = flag.String("mitm-ca-cert", "", "CA certificate (PEM) that certificates for -mitm-hosts are issued from, followed by any certificates it was issued by, which are sent after them")
+	mitmCAKey  = flag.String("mitm-ca-key", "", "Private key (PEM) of -mitm-ca-cert; for a PKCS #12 -mitm-ca-cert, its passphrase as pass:, env: or file:")
+
+	// Shutdown
+	drainTimeout = flag.Duration("drain-timeout", 30*time.Second, "On SIGTERM or SIGINT, how long requests and tunnels under way have to finish before they are cut off")
+
+	// Metrics
+	metricsAddr = flag.String("metrics-addr", "", "Address for the Prometheus metrics listener with /metrics (e.g. localhost:9091); disabled if empty")
+
+	// Tracing
+	otlpEndpoint = flag.String("otlp-endpoint", "", "OTLP/HTTP URL to export trace spans to as JSON (e.g. http://localhost:4318/v1/traces); disabled if empty")
+	traceSample  = flag.Float64("trace-sample", 1, "Fraction of new traces to sample, 0 to 1; requests with a traceparent follow its sampled flag")
+	serviceName  = flag.String("service-name", "http-proxy", "service.name of the exported spans")
+
+	// Admin
+	adminAddr = flag.String("admin-addr", "", "Address for the admin listener with /admin, /admin/health, /admin/usage and /admin/drain (e.g. localhost:9090); disabled if empty")
 )
 
 func main() {
 	flag.Parse()
 
 	proxy := &ProxyServer{
-		verbose: *verbose,
+		verbose:       *verbose,
+		stats:         proxyStats{since: time.Now()},
+		upstreamHosts: parseHostPatterns(*upstreamHosts),
+		tunnelIdle:    *tunnelIdleTimeout,
+
+		dialTimeout:         *dialTimeout,
+		tlsHandshakeTimeout: *tlsHandshakeTimeout,
+		requestTimeout:      *requestTimeout,
+
+		maxBodySize: *maxBodySize,
+
+		streamBufferSize:    *streamBufferSize,
+		streamFlushInterval: *streamFlushInterval,
+	}
+	if *streamBufferSize < 0 {
+		log.Fatalf("-stream-buffer-size must not be negative")
+	}
+	if *streamFlushInterval < 0 {
+		log.Fatalf("-stream-flush-interval must not be negative")
+	}
+
+	transportOptions := transportOptions{
+		maxIdleConns:        *maxIdleConns,
+		maxIdleConnsPerHost: *maxIdleConnsPerHost,
+		maxConnsPerHost:     *maxConnsPerHost,
+		idleConnTimeout:     *idleConnTimeout,
+
+		tlsHandshakeTimeout:   *tlsHandshakeTimeout,
+		responseHeaderTimeout: *responseHeaderTimeout,
+
+		http1Only: *upstreamHTTP1,
+		h2cHosts:  parseHostPatterns(*upstreamH2C),
+	}
+	var err error
+	if proxy.compression, err = parseCompression(*upstreamCompression); err != nil {
+		log.Fatal(err)
+	}
+	transportOptions.decompress = proxy.compression == compressionRecompress
+	if transportOptions.http1Only && len(transportOptions.h2cHosts) > 0 {
+		log.Fatalf("-upstream-http1 and -upstream-h2c cannot be used together")
+	}
+
+	var config Config
+	configVersion := fileVersion(*configFile)
+	if *configFile != "" {
+		loaded, err := loadConfig(*configFile)
+		if err != nil {
+			log.Fatalf("Config: %v", err)
+		}
+		config = *loaded
+	}
+	upstreamKeyPass := *keyPass
+	if u := config.UpstreamTLS; u != nil {
+		if u.Cert != "" || u.Key != "" || u.CA != "" {
+			if *upstreamCert != "" || *upstreamKey != "" || *upstreamCA != "" {
+				log.Fatalf("Config: give -upstream-cert, -upstream-key and -upstream-ca or upstream_tls, not both")
+			}
+			*upstreamCert, *upstreamKey, *upstreamCA = u.Cert, u.Key, u.CA
+			if u.KeyPass != "" {
+				upstreamKeyPass = u.KeyPass
+			}
+		}
+		if u.RenewURL != "" {
+			if *upstreamRenewURL != "" {
+				log.Fatalf("Config: give -upstream-renew-url or renew_url in upstream_tls, not both")
+			}
+			*upstreamRenewURL = u.RenewURL
+		}
+		proxy.hostTLS = u.hosts
+	}
+	if *upstreamRenewURL != "" && *upstreamCert == "" {
+		log.Fatalf("-upstream-renew-url needs -upstream-cert to renew")
+	}
+
+	// Client certificates presented upstream are renewed as the proxy
+	// runs, before the transports take their TLS settings
+	var renewers []*certRenewer
+	renewClientCert := func(use string, config *tls.Config, certFile, keyFile, keyPass, url string) {
+		if len(config.Certificates) == 0 {
+			return
+		}
+		renewer, err := newCertRenewer(use, config, certFile, keyFile, keyPass, url)
+		if err != nil {
+			log.Fatalf("Renewing the %s certificate: %v", use, err)
+		}
+		renewers = append(renewers, renewer)
+	}
+	if u := config.UpstreamTLS; u != nil {
+		for i, h := range u.PerHost {
+			renewClientCert(fmt.Sprintf("per_host %d", i+1), u.hosts[i].config, h.Cert, h.Key, h.KeyPass, h.RenewURL)
+		}
+	}
+
+	// What the config file can change as the proxy runs, as the command
+	// line has it
+	flags := policy{
+		allowHosts: parseHostPatterns(*allowHosts),
+		denyHosts:  parseHostPatterns(*denyHosts),
+	}
+	if *allowClients != "" {
+		ranges, err := parseClientRanges(*allowClients)
+		if err != nil {
+			log.Fatalf("Invalid -allow-clients: %v", err)
+		}
+		flags.allowClients = ranges
+	}
+
+	if *socksUpstream != "" {
+		egress, err := parseSOCKSUpstream(*socksUpstream)
+		if err != nil {
+			log.Fatalf("Invalid -socks-upstream: %v", err)
+		}
+		proxy.egress = egress
+	}
+	if *resolve != "" {
+		overrides, err := parseResolve(*resolve)
+		if err != nil {
+			log.Fatalf("Invalid -resolve: %v", err)
+		}
+		proxy.resolve = overrides
+	}
+
+	if *metricsAddr != "" {
+		proxy.metrics = newMetrics()
+	}
+
+	if *otlpEndpoint != "" {
+		tracer, err := newTracer(*otlpEndpoint, *serviceName, *traceSample)
+		if err != nil {
+			log.Fatal(err)
+		}
+		proxy.tracer = tracer
+	}
+
+	if *accessLogFormat != "text" {
+		out := io.Writer(os.Stdout)
+		if *accessLogFile != "" {
+			f, err := os.OpenFile(*accessLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+			if err != nil {
+				log.Fatalf("Access log: %v", err)
+			}
+			defer f.Close()
+			out = f
+		}
+		accessLog, err := newAccessLogger(*accessLogFormat, out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		proxy.accessLog = accessLog
+	} else if *accessLogFile != "" {
+		log.Fatalf("-access-log-file needs -access-log json or combined")
+	}
+
+	if *completionLogFile != "" {
+		out := io.Writer(os.Stdout)
+		if *completionLogFile != "-" {
+			f, err := os.OpenFile(*completionLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+			if err != nil {
+				log.Fatalf("Completion log: %v", err)
+			}
+			defer f.Close()
+			out = f
+		}
+		proxy.completions = &completionLog{out: out}
+	}
+
+	if *rateLimit != "" {
+		limiter, err := newRateLimiter(*rateLimit, *rateBurst, *rateKey)
+		if err != nil {
+			log.Fatalf("Invalid -rate-limit: %v", err)
+		}
+		proxy.rateLimit = limiter
+	}
+
+	key, err := loadAPIKey(*apiKey, *apiKeyEnv, *apiKeyFile)
+	if err != nil {
+		log.Fatalf("API key: %v", err)
+	}
+	if key != "" {
+		proxy.apiKey = &apiKeyInjector{key: key, header: *apiKeyHeader}
+	}
+
+	switch *mode {
+	case "forward":
+		if proxy.connectPorts, err = parsePorts(*connectPorts); err != nil {
+			log.Fatalf("Invalid -connect-ports: %v", err)
+		}
+		if proxy.connectSNI, err = parseConnectSNI(*connectSNI); err != nil {
+			log.Fatal(err)
+		}
+		if err := parseSendProxyProtocol(*sendProxyProtocol); err != nil {
+			log.Fatal(err)
+		}
+		proxy.sendProxyProtocol = *sendProxyProtocol
+		if *proxyAuthFile != "" {
+			if flags.proxyAuth, err = loadProxyAuth(*proxyAuthFile); err != nil {
+				log.Fatalf("Proxy authentication: %v", err)
+			}
+		}
+		if proxy.apiKey != nil {
+			proxy.apiKey.hosts = parseHostPatterns(*apiKeyHosts)
+			if len(proxy.apiKey.hosts) == 0 {
+				proxy.apiKey.hosts = proxy.upstreamHosts
+			}
+			if len(proxy.apiKey.hosts) == 0 {
+				log.Fatalf("-api-key needs -api-key-hosts or -upstream-hosts in forward mode, so the key is not sent to every host")
+			}
+		}
+		if *upstream != "" {
+			log.Fatalf("-upstream needs -mode reverse")
+		}
+		if *mitmHosts != "" {
+			if proxy.mitm, err = loadInterceptor(*mitmHosts, *mitmCACert, *mitmCAKey, *keyPass); err != nil {
+				log.Fatalf("TLS interception: %v", err)
+			}
+		} else if *mitmCACert != "" || *mitmCAKey != "" {
+			log.Fatalf("-mitm-ca-cert and -mitm-ca-key need -mitm-hosts")
+		}
+		if proxy.completions != nil && proxy.mitm == nil {
+			log.Fatalf("-completion-log applies to reverse mode, or to -mitm-hosts in forward mode")
+		}
+		if len(proxy.upstreamHosts) > 0 {
+			config, err := loadUpstreamTLS(*upstreamCert, *upstreamKey, upstreamKeyPass, *upstreamCA)
+			if err != nil {
+				log.Fatalf("Upstream TLS: %v", err)
+			}
+			proxy.upstreamTLS = config
+			renewClientCert("upstream", config, *upstreamCert, *upstreamKey, upstreamKeyPass, *upstreamRenewURL)
+		} else if *upstreamCert != "" || *upstreamCA != "" {
+			log.Fatalf("-upstream-cert and -upstream-ca need -upstream-hosts in forward mode")
+		}
+		proxy.setTransports(transportOptions)
+	case "reverse":
+		if *upstream == "" && len(config.Routes) == 0 {
+			log.Fatalf("-mode reverse needs -upstream (e.g. https://api.openai.com) or routes in -config")
+		}
+		if *upstream != "" {
+			target, err := parseUpstream(*upstream)
+			if err != nil {
+				log.Fatal(err)
+			}
+			proxy.upstream = singlePool(target)
+		}
+		if len(proxy.upstreamHosts) > 0 {
+			log.Fatalf("-upstream-hosts applies to forward mode; reverse mode sends everything to -upstream")
+		}
+		if *upstreamCert != "" || *upstreamKey != "" || *upstreamCA != "" {
+			if proxy.upstreamTLS, err = loadUpstreamTLS(*upstreamCert, *upstreamKey, upstreamKeyPass, *upstreamCA); err != nil {
+				log.Fatalf("Upstream TLS: %v", err)
+			}
+			renewClientCert("upstream", proxy.upstreamTLS, *upstreamCert, *upstreamKey, upstreamKeyPass, *upstreamRenewURL)
+		}
+		if len(flags.allowHosts) > 0 || len(flags.denyHosts) > 0 {
+			log.Fatalf("-allow-hosts and -deny-hosts apply to forward mode; reverse mode only reaches -upstream")
+		}
+		if *apiKeyHosts != "" {
+			log.Fatalf("-api-key-hosts applies to forward mode; reverse mode sends the key to -upstream")
+		}
+		if *proxyAuthFile != "" {
+			log.Fatalf("-proxy-auth-file applies to forward mode; use -client-ca to authenticate reverse-mode clients")
+		}
+		if *socksPort != 0 {
+			log.Fatalf("-socks-port applies to forward mode")
+		}
+		if *connectSNI != sniOff {
+			log.Fatalf("-connect-sni applies to forward mode")
+		}
+		if *sendProxyProtocol != "" {
+			log.Fatalf("-send-proxy-protocol applies to forward mode; reverse-mode upstreams get X-Forwarded-For")
+		}
+		if proxy.apiKey != nil {
+			proxy.apiKey.hosts = hostPatterns{"*"}
+		}
+		transport := newTransport(transportOptions, proxy.upstreamTLS)
+		transport.DialContext = proxy.dialContext
+		proxy.reverse = newReverseProxy(transport, proxy.apiKey, *verbose)
+		if *streamBufferSize > 0 {
+			proxy.reverse.BufferPool = newBufferPool(*streamBufferSize)
+		}
+		if len(proxy.hostTLS) > 0 {
+			proxy.reverse.Transport = newHostTransport(transport, proxy.hostTLS)
+		}
+		proxy.reverse.Transport = proxy.tracer.wrap(proxy.metrics.wrap(proxy.reverse.Transport))
+		if *configFile != "" {
+			// Passes requests straight through unless their route fails
+			// over, which a reload may make it do
+			proxy.reverse.Transport = &failoverTransport{base: proxy.reverse.Transport, metrics: proxy.metrics}
+		}
+		if config.Retry != nil {
+			retry := newRetryTransport(proxy.reverse.Transport, *config.Retry, *verbose)
+			retry.metrics = proxy.metrics
+			proxy.reverse.Transport = retry
+		}
+	default:
+		log.Fatalf("Invalid -mode %q: must be forward or reverse", *mode)
+	}
+
+	if *tlsCert != "" || *tlsKey != "" {
+		if flags.listenerTLS, err = loadListenerTLS(*tlsCert, *tlsKey, *keyPass, *clientCA, *clientAuth); err != nil {
+			log.Fatalf("Listener TLS: %v", err)
+		}
+	} else if *clientCA != "" || *clientAuth != "" {
+		log.Fatalf("-client-ca and -client-auth need -tls-cert and -tls-key")
+	}
+	pol, err := config.policy(flags, proxy.reverse != nil)
+	if err != nil {
+		log.Fatalf("Config: %s: %v", *configFile, err)
+	}
+
+	// The certificates loaded, watched for expiry in groups that a reload
+	// replaces together
+	if *certWarnDays < 0 {
+		log.Fatalf("-cert-warn-days must not be negative")
+	}
+	listenerCerts := []certSource{{"listener", *tlsCert, *tlsKey, *keyPass}, {"client CA", *clientCA, "", ""}}
+	if config.TLS != nil {
+		listenerCerts = config.TLS.certSources()
+	}
+	var upstreamCerts, mitmCerts []certSource
+	if proxy.upstreamTLS != nil {
+		upstreamCerts = append(upstreamCerts, certSource{"upstream", *upstreamCert, *upstreamKey, upstreamKeyPass}, certSource{"upstream CA", *upstreamCA, "", ""})
+	}
+	if u := config.UpstreamTLS; u != nil {
+		for i, h := range u.PerHost {
+			rule := fmt.Sprintf("per_host %d", i+1)
+			upstreamCerts = append(upstreamCerts, certSource{rule, h.Cert, h.Key, h.KeyPass}, certSource{rule + " CA", h.CA, "", ""})
+		}
+	}
+	if proxy.mitm != nil {
+		mitmCerts = append(mitmCerts, certSource{"MITM CA", *mitmCACert, *mitmCAKey, *keyPass})
+	}
+	// Each upstream certificate is a group of its own, which its renewer
+	// replaces
+	type certGroup struct {
+		name    string
+		sources []certSource
+	}
+	groups := []certGroup{{"listener", listenerCerts}}
+	for _, source := range upstreamCerts {
+		groups = append(groups, certGroup{source.use, []certSource{source}})
+	}
+	groups = append(groups, certGroup{"mitm", mitmCerts})
+	proxy.certs = newCertMonitor(*certWarnDays)
+	for _, group := range groups {
+		certs, err := readCertSources(group.sources...)
+		if err != nil {
+			log.Fatalf("Certificate expiry: %v", err)
+		}
+		proxy.certs.set(group.name, certs)
+	}
+	if *checkCerts {
+		if !mtls.PrintCertCheck(os.Stdout, proxy.certs.all(), time.Now(), *certWarnDays) {
+			os.Exit(1)
+		}
+		return
+	}
+	for _, renewer := range renewers {
+		if renewer.url != "" {
+			log.Printf("[CERT] Renewing the %s certificate from %s", renewer.use, renewer.url)
+		}
+		go renewer.run(proxy.certs)
 	}
 
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", *port),
-		Handler: proxy,
+		Addr:              fmt.Sprintf(":%d", *port),
+		Handler:           proxy,
+		ReadHeaderTimeout: *readHeaderTimeout,
+	}
+	if pol.listenerTLS != nil {
+		server.TLSConfig = proxy.serverTLS()
+		if proxy.metrics != nil {
+			server.ErrorLog = log.New(tlsErrorLog{log.Writer(), proxy.metrics}, "", log.LstdFlags)
+		}
+	}
+
+	// Without -port, only the Unix socket
+	listenTCP := *port != 0 || *listenUnix == ""
+	socketMode, err := parseSocketMode(*listenUnixMode)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	printBanner()
-	log.Printf("Proxy server listening on http://localhost:%d", *port)
+	scheme, listening := "http", ""
+	if pol.listenerTLS != nil {
+		scheme, listening = "https", " ("+describeKey(pol.listenerTLS.Certificates[0].Leaf)+" certificate, "+describeClientAuth(pol.listenerTLS)+")"
+	}
+	if listenTCP {
+		log.Printf("Proxy server listening on %s://localhost:%d%s", scheme, *port, listening)
+	}
+	if *listenUnix != "" {
+		log.Printf("Proxy server listening on %s over Unix socket %s (mode %04o)%s", scheme, *listenUnix, socketMode, listening)
+	}
+	switch {
+	case proxy.reverse != nil:
+		for _, route := range pol.routes {
+			log.Printf("Route %s -> %s", describeRoute(route), describePool(route.pool))
+		}
+		if proxy.upstream != nil {
+			log.Printf("Reverse proxying to %s", describePool(proxy.upstream))
+		}
+		if proxy.upstreamTLS != nil {
+			log.Printf("Upstream TLS%s", describeClientCert(proxy.upstreamTLS))
+		}
+		for _, rule := range proxy.hostTLS {
+			log.Printf("Upstream TLS for %s%s", strings.Join(rule.hosts, ", "), describeClientCert(rule.config))
+		}
+	default:
+		if proxy.upstreamTLS != nil {
+			log.Printf("Originating TLS to %s%s", strings.Join(proxy.upstreamHosts, ", "), describeClientCert(proxy.upstreamTLS))
+		}
+		for _, rule := range proxy.hostTLS {
+			log.Printf("Originating TLS to %s%s", strings.Join(rule.hosts, ", "), describeClientCert(rule.config))
+		}
+	}
+	switch {
+	case proxy.apiKey != nil && proxy.reverse != nil:
+		log.Printf("Injecting API key in %s", proxy.apiKey.header)
+	case proxy.apiKey != nil:
+		log.Printf("Injecting API key in %s for %s", proxy.apiKey.header, strings.Join(proxy.apiKey.hosts, ", "))
+	}
+	if len(pol.allowHosts) > 0 {
+		log.Printf("Allowed destinations: %s", strings.Join(pol.allowHosts, ", "))
+	}
+	if len(pol.denyHosts) > 0 {
+		log.Printf("Denied destinations: %s", strings.Join(pol.denyHosts, ", "))
+	}
+	if auth := pol.proxyAuth; auth != nil {
+		log.Printf("Proxy authentication: %d users, %d bearer tokens", len(auth.users), len(auth.tokens))
+	}
+	if proxy.rateLimit != nil {
+		log.Printf("Rate limit: %s per %s, burst %.0f", *rateLimit, *rateKey, proxy.rateLimit.burst)
+	}
+	if proxy.reverse == nil && proxy.connectPorts != nil {
+		log.Printf("CONNECT ports: %s", *connectPorts)
+	}
+	if proxy.connectSNI != "" {
+		log.Printf("CONNECT tunnels go to the TLS server name (-connect-sni %s)", proxy.connectSNI)
+	}
+	if proxy.sendProxyProtocol != "" {
+		log.Printf("Sending PROXY protocol %s headers on tunnels", proxy.sendProxyProtocol)
+	}
+	if transportOptions.http1Only {
+		log.Printf("Upstreams: HTTP/1.1 only")
+	}
+	if len(transportOptions.h2cHosts) > 0 {
+		log.Printf("Upstreams: HTTP/2 without TLS (h2c) to %s", strings.Join(transportOptions.h2cHosts, ", "))
+	}
+	if proxy.compression != "" {
+		log.Printf("Response compression: %s", proxy.compression)
+	}
+
+	reloader := &configReloader{
+		path:     *configFile,
+		proxy:    proxy,
+		reverse:  proxy.reverse != nil,
+		flags:    flags,
+		started:  &config,
+		breakers: config.CircuitBreaker,
+		version:  configVersion,
+	}
+	if check := config.HealthCheck; check != nil {
+		log.Printf("Health checking upstreams every %v (%s)", check.Interval, describeHealthCheck(*check))
+		reloader.checker = newHealthChecker(*check, proxy.upstreamTLS, proxy.apiKey)
+		reloader.checker.useHostTLS(proxy.hostTLS)
+		if proxy.egress != nil || proxy.resolve != nil {
+			reloader.checker.dial = proxy.dialContext
+		}
+	}
+	if retry := config.Retry; retry != nil {
+		methods := "idempotent requests"
+		if retry.Posts {
+			methods += " and POSTs"
+		}
+		log.Printf("Retrying %s up to %d attempts, backoff %v to %v, budget %.0f%%", methods, retry.Attempts, retry.Backoff, retry.MaxBackoff, retry.Budget*100)
+	}
+	if cb := config.CircuitBreaker; cb != nil {
+		log.Printf("Circuit breakers open for %v at %.0f%% errors over %v (at least %d requests)", cb.OpenFor, cb.ErrorRate*100, cb.Window, cb.MinRequests)
+	}
+	if usage := config.Usage; usage != nil {
+		log.Printf("Counting token usage by client %s, with %d prices", usage.Client, len(usage.Prices))
+		proxy.usage = newUsageMeter(*usage)
+		proxy.usage.metrics = proxy.metrics
+	}
+	if cache := config.Cache; cache != nil {
+		log.Printf("Caching responses to %s for %v, up to %d bytes", strings.Join(cache.Paths, ", "), cache.TTL, cache.MaxBytes)
+		proxy.cache = newResponseCache(*cache)
+		proxy.cache.metrics = proxy.metrics
+	}
+	if proxy.egress != nil {
+		log.Printf("Connecting through SOCKS5 proxy %s", proxy.egress.addr)
+	}
+	for _, rule := range proxy.resolve {
+		log.Printf("Resolving %s to %s", strings.Join(rule.pattern, ","), rule.addr)
+	}
+	if pol.allowClients != nil {
+		log.Printf("Accepting connections only from %v", pol.allowClients)
+	}
+	if certs := proxy.certs.all(); len(certs) > 0 {
+		log.Printf("Checking %d certificates daily for expiry within %d days", len(certs), *certWarnDays)
+		proxy.certs.logExpiring(certs)
+		go proxy.certs.watch(24 * time.Hour)
+	}
+	reloader.apply(pol)
+	if *configFile != "" {
+		if *configPoll > 0 {
+			log.Printf("Reloading %s on SIGHUP and when it changes", *configFile)
+		} else {
+			log.Printf("Reloading %s on SIGHUP", *configFile)
+		}
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go reloader.watch(context.Background(), hup, *configPoll)
+	}
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Server error: %v", err)
+	var loadBalancers []netip.Prefix
+	if *proxyProtocolFrom != "" {
+		if loadBalancers, err = parseClientRanges(*proxyProtocolFrom); err != nil {
+			log.Fatalf("Invalid -proxy-protocol-from: %v", err)
+		}
+		log.Printf("Reading PROXY protocol headers from %v", loadBalancers)
 	}
+	accept := func(name string, listener net.Listener) net.Listener {
+		if loadBalancers != nil {
+			// The client allowlist sees the addresses the headers give
+			listener = newProxyProtocolListener(listener, loadBalancers, *readHeaderTimeout)
+		}
+		return &clientListener{Listener: listener, proxy: proxy, name: name}
+	}
+	listen := func(name string, port int) (net.Listener, error) {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			return nil, err
+		}
+		return accept(name, listener), nil
+	}
+
+	var listeners []net.Listener
+	if *socksPort != 0 {
+		listener, err := listen("socks", *socksPort)
+		if err != nil {
+			log.Fatalf("SOCKS listener: %v", err)
+		}
+		log.Printf("SOCKS5 listening on localhost:%d", *socksPort)
+		go func() {
+			if err := serveErr(proxy.serveSOCKS(listener)); err != nil {
+				log.Fatalf("SOCKS server error: %v", err)
+			}
+		}()
+		listeners = append(listeners, listener)
+	}
+	if *metricsAddr != "" {
+		log.Printf("Metrics listener on http://%s/metrics", *metricsAddr)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", proxy.metricsHandler)
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsAddr, mux))
+		}()
+	}
+	if *adminAddr != "" {
+		log.Printf("Admin listener on http://%s/admin", *adminAddr)
+		go func() {
+			log.Fatal(http.ListenAndServe(*adminAddr, proxy.adminHandler()))
+		}()
+	}
+
+	// On SIGTERM or SIGINT, stop taking connections and let those under way
+	// finish; a second signal exits at once
+	drained := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		sig := <-signals
+		signal.Stop(signals)
+		log.Printf("[SHUTDOWN] %v: draining connections for up to %v", sig, *drainTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+		proxy.drain(ctx, server, listeners...)
+		cancel()
+		close(drained)
+	}()
+
+	var serving []net.Listener
+	if listenTCP {
+		listener, err := listen("http", *port)
+		if err != nil {
+			log.Fatalf("Listener: %v", err)
+		}
+		serving = append(serving, listener)
+	}
+	if *listenUnix != "" {
+		listener, err := listenSocket(*listenUnix, socketMode)
+		if err != nil {
+			log.Fatalf("Unix socket listener: %v", err)
+		}
+		serving = append(serving, accept("http", listener))
+	}
+	served := make(chan error, len(serving))
+	for _, listener := range serving {
+		go func() {
+			if server.TLSConfig != nil {
+				served <- server.ServeTLS(listener, "", "")
+			} else {
+				served <- server.Serve(listener)
+			}
+		}()
+	}
+	for range serving {
+		if err := serveErr(<-served); err != nil {
+			log.Fatalf("Server error: %v", err)
+		}
+	}
+	<-drained
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	proxy.tracer.close(ctx)
+	cancel()
+	log.Printf("[SHUTDOWN] Done")
 }
 
 func printBanner() {
@@ -44,35 +775,221 @@ func printBanner() {
 	fmt.Println("Features:")
 	fmt.Println("  - HTTP/HTTPS proxy support")
 	fmt.Println("  - CONNECT tunneling for HTTPS")
+	fmt.Println("  - Reverse proxy mode with path and model routing")
+	fmt.Println("  - Azure OpenAI request translation")
+	fmt.Println("  - Load balancing across upstreams")
+	fmt.Println("  - Upstream health checks")
+	fmt.Println("  - Retries with backoff")
+	fmt.Println("  - Failover between providers")
+	fmt.Println("  - Per-upstream circuit breakers")
+	fmt.Println("  - TLS listener with client certificate verification")
+	fmt.Println("  - mTLS origination to upstreams")
+	fmt.Println("  - API key injection")
+	fmt.Println("  - Client IP allowlist")
+	fmt.Println("  - PROXY protocol v1 and v2, accepted and sent")
+	fmt.Println("  - Config file reloaded on SIGHUP or change")
+	fmt.Println("  - Destination host allow and deny lists")
+	fmt.Println("  - CONNECT port restrictions")
+	fmt.Println("  - SNI policy for CONNECT tunnels (-connect-sni)")
+	fmt.Println("  - Per-client rate limiting")
+	fmt.Println("  - Proxy authentication (Basic and Bearer)")
+	fmt.Println("  - SOCKS5 listener and SOCKS5 upstream")
+	fmt.Println("  - Static DNS overrides (-resolve)")
+	fmt.Println("  - Unix socket listener and upstreams")
 	fmt.Println("  - SSE/streaming support (unbuffered)")
-	fmt.Println("  - Request logging")
+	fmt.Println("  - Stream buffer size and flush interval tuning")
+	fmt.Println("  - WebSocket proxying")
+	fmt.Println("  - Pooled upstream connections")
+	fmt.Println("  - HTTP/2 and h2c to upstreams")
+	fmt.Println("  - Upstream compression: passthrough, recompress or identity")
+	fmt.Println("  - Configurable timeouts")
+	fmt.Println("  - Request body size limit, bodies streamed upstream")
+	fmt.Println("  - Graceful shutdown with connection draining")
+	fmt.Println("  - Access logs (text, JSON or Apache combined)")
+	fmt.Println("  - Prometheus metrics")
+	fmt.Println("  - Admin endpoint with stats and backend draining")
+	fmt.Println("  - OpenTelemetry tracing")
+	fmt.Println("  - Completion logging, streams reassembled")
+	fmt.Println("  - Token usage accounting and cost estimates")
+	fmt.Println("  - Response caching")
+	fmt.Println("  - TLS interception for debugging (-mitm-hosts)")
 	fmt.Println("========================================")
 }
 
 type ProxyServer struct {
 	verbose bool
+
+	// upstreamTLS is used for connections to upstreamHosts, which are made
+	// over TLS even when the client sent plain HTTP
+	upstreamTLS   *tls.Config
+	upstreamHosts hostPatterns
+
+	// hostTLS, from upstream_tls in -config, gives the upstreams that
+	// have a PKI of their own their client certificate and CA
+	hostTLS hostTLS
+
+	// policyMu guards what a reload of -config changes: routes,
+	// routeByModel, allowClients, allowHosts, denyHosts, proxyAuth and
+	// listenerTLS
+	policyMu sync.RWMutex
+
+	// allowClients, if set, are the ranges clients may connect from
+	allowClients []netip.Prefix
+
+	// listenerTLS, if set, is the listener's certificate and client CA
+	listenerTLS *tls.Config
+
+	// Forward-mode destinations, by -allow-hosts and -deny-hosts
+	allowHosts hostPatterns
+	denyHosts  hostPatterns
+
+	// Ports CONNECT may reach, from -connect-ports; nil for any
+	connectPorts map[string]bool
+
+	// sendProxyProtocol, if set, is the PROXY protocol version that
+	// tunnels' connections start with
+	sendProxyProtocol string
+
+	// connectSNI, if set, takes CONNECT tunnels to the server name in
+	// their ClientHello: on, or require to refuse tunnels without one
+	connectSNI string
+
+	// compression, if set, is how responses are compressed: recompress
+	// or identity
+	compression string
+
+	// tunnelIdle, if set, closes tunnels with no traffic for that long
+	tunnelIdle time.Duration
+
+	// metrics, if set, are served on -metrics-addr
+	metrics *metrics
+
+	// tracer, if set, makes spans for requests and exports them to
+	// -otlp-endpoint
+	tracer *tracer
+
+	// accessLog, if set, gets a record of each request and tunnel in
+	// place of the usual log line
+	accessLog *accessLogger
+
+	// completions, if set, gets the completion of each reverse-mode
+	// request
+	completions *completionLog
+
+	// usage, if set, counts the tokens each client uses
+	usage *usageMeter
+
+	// cache, if set, answers repeated reverse-mode requests
+	cache *responseCache
+
+	// mitm, if set, decrypts tunnels to its hosts
+	mitm *interceptor
+
+	// certs are the certificates loaded, watched for expiry
+	certs *certMonitor
+
+	// hijacked holds tunnels and WebSockets, for draining on shutdown
+	hijacked connTracker
+
+	// stats count what the proxy has served, for /admin
+	stats proxyStats
+
+	// Timeouts for connecting, the TLS handshake when originating on a
+	// tunnel, and a whole request; zero for none
+	dialTimeout         time.Duration
+	tlsHandshakeTimeout time.Duration
+	requestTimeout      time.Duration
+
+	// maxBodySize, if set, is the largest request body accepted
+	maxBodySize int64
+
+	// Streams are copied to clients through a buffer of streamBufferSize,
+	// or defaultStreamBufferSize, and flushed at most once per
+	// streamFlushInterval if it is set
+	streamBufferSize    int
+	streamFlushInterval time.Duration
+
+	// apiKey, if set, replaces the client's credentials
+	apiKey *apiKeyInjector
+
+	// rateLimit, if set, limits each client's requests
+	rateLimit *rateLimiter
+
+	// egress, if set, is the SOCKS5 proxy outgoing connections go through
+	egress *socksDialer
+
+	// resolve overrides the addresses outgoing connections go to
+	resolve resolveOverrides
+
+	// Forward-mode requests share these transports, so connections are
+	// pooled; originating is for upstreamHosts and hostTLS
+	transport      *http.Transport
+	originating    http.RoundTripper
+	transportsOnce sync.Once
+
+	// proxyAuth, if set, holds the credentials forward-mode clients must
+	// send in Proxy-Authorization
+	proxyAuth *proxyAuth
+
+	// In reverse mode requests go to the first matching route's upstream,
+	// or to upstream if none matches
+	reverse      *httputil.ReverseProxy
+	routes       []Route
+	routeByModel bool
+	upstream     *pool
 }
 
 func (p *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	startTime := time.Now()
+	p.stats.active.Add(1)
+	defer p.stats.active.Add(-1)
+	rec, w, r := startRecord(w, r)
+	r, span := p.tracer.start(r)
+	r, done := p.limitRequest(r)
+	defer done()
+	w, finish := p.negotiateCompression(w, r)
 
-	if r.Method == http.MethodConnect {
+	switch {
+	case !p.checkRate(w, r):
+		// Refused with 429
+	case !p.checkBodySize(w, r):
+		// Refused with 413
+	case p.reverse != nil && r.Method == http.MethodConnect:
+		http.Error(w, "CONNECT is not supported in reverse mode", http.StatusMethodNotAllowed)
+	case p.reverse != nil:
+		p.serveReverse(w, r)
+	case !p.checkProxyAuth(w, r):
+		// Refused with 407
+	case !p.checkDestination(w, r):
+		// Refused with 403
+	case r.Method == http.MethodConnect && !p.checkConnectPort(w, r):
+		// Refused with 403
+	case r.Method == http.MethodConnect:
 		p.handleConnect(w, r)
-	} else {
+	default:
 		p.handleHTTP(w, r)
 	}
 
-	log.Printf("[%s] %s %s (%v)", r.Method, r.Host, r.URL.Path, time.Since(startTime))
+	finish()
+	p.logAccess(rec)
+	span.finishRequest(rec)
 }
 
 // handleConnect handles HTTPS tunneling via CONNECT method
 func (p *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
+	if p.mitm != nil && p.mitm.hosts.match(r.Host) {
+		p.intercept(w, r)
+		return
+	}
+	if p.connectSNI != "" {
+		p.connectBySNI(w, r)
+		return
+	}
 	if p.verbose {
 		log.Printf("[CONNECT] Establishing tunnel to %s", r.Host)
 	}
 
 	// Connect to the target server
-	targetConn, err := net.DialTimeout("tcp", r.Host, 30*time.Second)
+	targetConn, err := p.dialContext(r.Context(), "tcp", r.Host)
 	if err != nil {
 		log.Printf("[ERROR] Failed to connect to %s: %v", r.Host, err)
 		http.Error(w, err.Error(), http.StatusServiceUnavailable)
@@ -103,25 +1020,19 @@ func (p *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := p.sendProxyHeader(targetConn, clientConn); err != nil {
+		log.Printf("[ERROR] Failed to send PROXY protocol header to %s: %v", r.Host, err)
+		return
+	}
 	if p.verbose {
 		log.Printf("[CONNECT] Tunnel established to %s", r.Host)
 	}
 
-	// Bidirectional copy
-	done := make(chan struct{}, 2)
-
-	go func() {
-		io.Copy(targetConn, clientConn)
-		done <- struct{}{}
-	}()
-
-	go func() {
-		io.Copy(clientConn, targetConn)
-		done <- struct{}{}
-	}()
-
-	// Wait for either direction to finish
-	<-done
+	rec := recordOf(r)
+	rec.Status = http.StatusOK
+	rec.Upstream = targetConn.RemoteAddr().String()
+	defer p.metrics.tunnelOpened("CONNECT")()
+	rec.BytesIn, rec.BytesOut, rec.end = p.tunnel(clientConn, targetConn)
 
 	if p.verbose {
 		log.Printf("[CONNECT] Tunnel closed for %s", r.Host)
@@ -140,20 +1051,34 @@ func (p *ProxyServer) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		targetURL.Scheme = "http"
 		targetURL.Host = r.Host
 	}
+	originate := p.originateTLS(targetURL)
+	if originate && p.verbose {
+		log.Printf("[TLS] Originating TLS to %s", targetURL.Host)
+	}
 
 	// Create a new request
-	proxyReq, err := http.NewRequest(r.Method, targetURL.String(), r.Body)
+	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, targetURL.String(), r.Body)
 	if err != nil {
 		log.Printf("[ERROR] Failed to create proxy request: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// The body is streamed as it arrives, with the length the client gave
+	proxyReq.ContentLength = r.ContentLength
 
 	// Copy headers
 	copyHeaders(proxyReq.Header, r.Header)
 
-	// Remove hop-by-hop headers
+	// Remove hop-by-hop headers, keeping a request to switch protocols,
+	// such as a WebSocket handshake
 	removeHopByHopHeaders(proxyReq.Header)
+	if upgrade := upgradeType(r.Header); upgrade != "" {
+		proxyReq.Header.Set("Connection", "Upgrade")
+		proxyReq.Header.Set("Upgrade", upgrade)
+	}
+
+	// Replace the client's credentials for hosts the proxy holds a key for
+	p.apiKey.apply(proxyReq.Header, targetURL.Host)
 
 	// Set X-Forwarded headers
 	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
@@ -163,31 +1088,34 @@ func (p *ProxyServer) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		proxyReq.Header.Set("X-Forwarded-For", clientIP)
 	}
 	proxyReq.Header.Set("X-Forwarded-Host", r.Host)
-	proxyReq.Header.Set("X-Forwarded-Proto", "http")
-
-	// Use a transport that doesn't buffer for streaming
-	transport := &http.Transport{
-		DisableCompression: true,
-		// Don't limit idle connections for streaming
-		MaxIdleConnsPerHost: 100,
-		IdleConnTimeout:     90 * time.Second,
-	}
-
-	client := &http.Client{
-		Transport: transport,
-		// Don't follow redirects
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
+	if r.TLS != nil {
+		proxyReq.Header.Set("X-Forwarded-Proto", "https")
+	} else {
+		proxyReq.Header.Set("X-Forwarded-Proto", "http")
 	}
 
-	resp, err := client.Do(proxyReq)
+	// Redirects are passed back to the client rather than followed
+	transport := p.tracer.wrap(p.metrics.wrap(p.forwardTransport(originate)))
+	resp, err := transport.RoundTrip(proxyReq)
 	if err != nil {
 		log.Printf("[ERROR] Failed to proxy request: %v", err)
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		http.Error(w, err.Error(), gatewayStatus(r, err))
 		return
 	}
 	defer resp.Body.Close()
+	if isStream(resp) {
+		keepStreaming(r)
+	}
+	rec := recordOf(r)
+	rec.Upstream = targetURL.Host
+	rec.UpstreamProto = resp.Proto
+	upstreamRequestID(rec, resp.Header)
+	rec.UpstreamTLS = describeTLS(resp.TLS)
+
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		p.switchProtocols(w, r, resp)
+		return
+	}
 
 	// Copy response headers
 	copyHeaders(w.Header(), resp.Header)
@@ -218,7 +1146,16 @@ func (p *ProxyServer) streamResponse(w http.ResponseWriter, body io.Reader) {
 		return
 	}
 
-	buf := make([]byte, 4096)
+	if p.streamFlushInterval > 0 {
+		fw := newFlushWriter(w, p.streamFlushInterval)
+		defer fw.stop()
+		w, flusher = fw, fw
+	}
+	size := p.streamBufferSize
+	if size == 0 {
+		size = defaultStreamBufferSize
+	}
+	buf := make([]byte, size)
 	for {
 		n, err := body.Read(buf)
 		if n > 0 {
@@ -247,6 +1184,7 @@ func copyHeaders(dst, src http.Header) {
 
 var hopByHopHeaders = []string{
 	"Connection",
+	"Proxy-Connection",
 	"Keep-Alive",
 	"Proxy-Authenticate",
 	"Proxy-Authorization",
@@ -256,7 +1194,17 @@ var hopByHopHeaders = []string{
 	"Upgrade",
 }
 
+// removeHopByHopHeaders removes the headers meant for one connection
+// only: those listed above, and any the Connection header names, as RFC
+// 9110 section 7.6.1 requires.
 func removeHopByHopHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
 	for _, h := range hopByHopHeaders {
 		header.Del(h)
 	}
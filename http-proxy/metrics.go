@@ -0,0 +1,395 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metrics are the Prometheus metrics served on -metrics-addr. A nil
+// *metrics records nothing, so the proxy runs the same without them.
+type metrics struct {
+	requests         *counterVec
+	bytes            *counterVec
+	tunnels          *counterVec
+	tunnelsClosed    *counterVec
+	activeTunnels    atomic.Int64
+	upstreamRequests *counterVec
+	upstreamLatency  *histogramVec
+	retries          *counterVec
+	failovers        *counterVec
+	breakerTrips     *counterVec
+	tlsErrors        *counterVec
+	tokens           *counterVec
+	cost             *counterVec
+	cacheLookups     *counterVec
+	refused          *counterVec
+	reloads          *counterVec
+}
+
+// latencyBuckets suit LLM APIs, which answer in anything from tens of
+// milliseconds (a model list) to minutes (a long completion).
+var latencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:         newCounterVec("http_proxy_requests_total", "Requests and tunnels served, by method and status.", "method", "code"),
+		bytes:            newCounterVec("http_proxy_bytes_total", "Bytes received from clients (in) and sent to them (out), bodies and tunnels.", "direction"),
+		tunnels:          newCounterVec("http_proxy_tunnels_total", "CONNECT and SOCKS5 tunnels and WebSockets opened, by protocol.", "proto"),
+		tunnelsClosed:    newCounterVec("http_proxy_tunnels_closed_total", "CONNECT and SOCKS5 tunnels closed, by protocol and why: client_closed, server_closed, idle_timeout, shutdown or error.", "proto", "reason"),
+		upstreamRequests: newCounterVec("http_proxy_upstream_requests_total", "Requests sent upstream, by upstream and status, or error if there was no response.", "upstream", "code"),
+		upstreamLatency:  newHistogramVec("http_proxy_upstream_duration_seconds", "Time from sending a request upstream to its response headers.", latencyBuckets, "upstream"),
+		retries:          newCounterVec("http_proxy_retries_total", "Requests retried, by the upstream that failed them.", "upstream"),
+		failovers:        newCounterVec("http_proxy_failovers_total", "Requests moved to the next upstream of a failover route, by the upstream that failed them.", "upstream"),
+		breakerTrips:     newCounterVec("http_proxy_circuit_breaker_trips_total", "Times an upstream's circuit breaker opened.", "upstream"),
+		tlsErrors:        newCounterVec("http_proxy_tls_handshake_errors_total", "Failed TLS handshakes with clients on the TLS listener, and with upstreams.", "side"),
+		tokens:           newCounterVec("http_proxy_tokens_total", "Tokens used, as responses reported them, by client, model and type (input, cached_input or output).", "client", "model", "type"),
+		cost:             newCounterVec("http_proxy_cost_dollars_total", "Estimated cost of the tokens used, by client and model, from the usage price table.", "client", "model"),
+		cacheLookups:     newCounterVec("http_proxy_cache_requests_total", "Requests the response cache answered (hit) or passed upstream (miss).", "result"),
+		refused:          newCounterVec("http_proxy_connections_refused_total", "Connections closed on accept because the client is not allowed, by listener (http or socks).", "listener"),
+		reloads:          newCounterVec("http_proxy_config_reloads_total", "Reloads of -config, by result (ok, or error if the running config was kept).", "result"),
+	}
+}
+
+// request counts a finished request or tunnel from its access record.
+func (m *metrics) request(rec *accessRecord) {
+	if m == nil {
+		return
+	}
+	m.requests.add(1, rec.Method, strconv.Itoa(rec.Status))
+	m.bytes.add(float64(rec.BytesIn), "in")
+	m.bytes.add(float64(rec.BytesOut), "out")
+	if rec.CloseReason != "" {
+		m.tunnelsClosed.add(1, tunnelProto(rec), rec.CloseReason)
+	}
+}
+
+// tunnelOpened counts a tunnel or WebSocket, and returns the function to
+// call when it closes.
+func (m *metrics) tunnelOpened(proto string) (closed func()) {
+	if m == nil {
+		return func() {}
+	}
+	m.tunnels.add(1, proto)
+	m.activeTunnels.Add(1)
+	return func() { m.activeTunnels.Add(-1) }
+}
+
+// upstream records a request to upstream that took from start to its
+// response headers, or failed with err.
+func (m *metrics) upstream(upstream string, start time.Time, resp *http.Response, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.upstreamRequests.add(1, upstream, "error")
+		if isTLSError(err) {
+			m.tlsError("upstream")
+		}
+		return
+	}
+	m.upstreamRequests.add(1, upstream, strconv.Itoa(resp.StatusCode))
+	m.upstreamLatency.observe(time.Since(start).Seconds(), upstream)
+}
+
+// tlsError counts a failed TLS handshake on side: client or upstream.
+func (m *metrics) tlsError(side string) {
+	if m == nil {
+		return
+	}
+	m.tlsErrors.add(1, side)
+}
+
+// tokensUsed counts the tokens client used of model in one response, and
+// their cost.
+func (m *metrics) tokensUsed(client, model string, t tokens, cost float64) {
+	if m == nil {
+		return
+	}
+	m.tokens.add(float64(t.Input), client, model, "input")
+	m.tokens.add(float64(t.CachedInput), client, model, "cached_input")
+	m.tokens.add(float64(t.Output), client, model, "output")
+	m.cost.add(cost, client, model)
+}
+
+func (m *metrics) connectionRefused(listener string) {
+	if m == nil {
+		return
+	}
+	m.refused.add(1, listener)
+}
+
+func (m *metrics) configReloaded(result string) {
+	if m == nil {
+		return
+	}
+	m.reloads.add(1, result)
+}
+
+func (m *metrics) cacheLookup(result string) {
+	if m == nil {
+		return
+	}
+	m.cacheLookups.add(1, result)
+}
+
+func (m *metrics) retry(upstream string) {
+	if m == nil {
+		return
+	}
+	m.retries.add(1, upstream)
+}
+
+func (m *metrics) failover(upstream string) {
+	if m == nil {
+		return
+	}
+	m.failovers.add(1, upstream)
+}
+
+func (m *metrics) breakerTripped(upstream string) {
+	if m == nil {
+		return
+	}
+	m.breakerTrips.add(1, upstream)
+}
+
+// isTLSError reports whether err is a failed TLS handshake or certificate
+// check.
+func isTLSError(err error) bool {
+	var (
+		header   tls.RecordHeaderError
+		alert    tls.AlertError
+		verify   *tls.CertificateVerificationError
+		unknown  x509.UnknownAuthorityError
+		hostname x509.HostnameError
+		invalid  x509.CertificateInvalidError
+	)
+	return errors.As(err, &header) || errors.As(err, &alert) || errors.As(err, &verify) ||
+		errors.As(err, &unknown) || errors.As(err, &hostname) || errors.As(err, &invalid) ||
+		strings.Contains(err.Error(), "tls: ")
+}
+
+// metricsTransport records the latency and outcome of each request base
+// sends upstream, retries included.
+type metricsTransport struct {
+	base    http.RoundTripper
+	metrics *metrics
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	t.metrics.upstream(req.URL.Host, start, resp, err)
+	return resp, err
+}
+
+// wrap returns base with its requests recorded.
+func (m *metrics) wrap(base http.RoundTripper) http.RoundTripper {
+	if m == nil {
+		return base
+	}
+	return &metricsTransport{base: base, metrics: m}
+}
+
+// tlsErrorLog passes the HTTP server's error log through to w, counting
+// failed TLS handshakes with clients, which the server only reports
+// there.
+type tlsErrorLog struct {
+	w       io.Writer
+	metrics *metrics
+}
+
+func (l tlsErrorLog) Write(b []byte) (int, error) {
+	if strings.Contains(string(b), "TLS handshake error") {
+		l.metrics.tlsError("client")
+	}
+	return l.w.Write(b)
+}
+
+// metricsHandler serves the metrics in the Prometheus text format, with
+// gauges for tunnels and backends read as it is scraped.
+func (p *ProxyServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	m := p.metrics
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, c := range []*counterVec{m.requests, m.bytes, m.tunnels, m.tunnelsClosed, m.upstreamRequests, m.retries, m.failovers, m.breakerTrips, m.tlsErrors, m.tokens, m.cost, m.cacheLookups, m.refused, m.reloads} {
+		c.write(w)
+	}
+	m.upstreamLatency.write(w)
+
+	writeHeader(w, "http_proxy_tunnels_active", "CONNECT and SOCKS5 tunnels and WebSockets open now.", "gauge")
+	fmt.Fprintf(w, "http_proxy_tunnels_active %d\n", m.activeTunnels.Load())
+
+	if p.certs != nil {
+		now := time.Now()
+		writeHeader(w, "http_proxy_certificate_expiry_seconds", "Seconds until a certificate the proxy loaded expires, negative once it has, by use, subject and serial number.", "gauge")
+		for _, c := range p.certs.all() {
+			fmt.Fprintf(w, "http_proxy_certificate_expiry_seconds{use=%s,subject=%s,serial=%s} %s\n", quoteLabel(c.Use), quoteLabel(c.Subject), quoteLabel(c.Serial), formatValue(c.NotAfter.Sub(now).Truncate(time.Second).Seconds()))
+		}
+	}
+
+	pools := p.pools()
+	if len(pools) == 0 {
+		return
+	}
+	now := time.Now()
+	gauges := []struct {
+		name, help string
+		value      func(backendStatus) float64
+	}{
+		{"http_proxy_backend_healthy", "Whether a reverse-mode backend is in rotation.", func(s backendStatus) float64 { return boolValue(s.Healthy) }},
+		{"http_proxy_backend_in_flight", "Requests to a reverse-mode backend under way.", func(s backendStatus) float64 { return float64(s.InFlight) }},
+		{"http_proxy_circuit_breaker_open", "Whether a reverse-mode backend's circuit breaker is open.", func(s backendStatus) float64 { return boolValue(s.Circuit == breakerOpen) }},
+	}
+	for _, gauge := range gauges {
+		writeHeader(w, gauge.name, gauge.help, "gauge")
+		for _, named := range pools {
+			for _, b := range named.pool.backends {
+				fmt.Fprintf(w, "%s{route=%s,upstream=%s} %s\n", gauge.name, quoteLabel(named.name), quoteLabel(b.url.Host), formatValue(gauge.value(b.status(now))))
+			}
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// counterVec is a Prometheus counter with labels.
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64 // by labelKey
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelKey(labelValues)] += v
+}
+
+func (c *counterVec) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key, ""), formatValue(c.values[key]))
+	}
+}
+
+// histogramVec is a Prometheus histogram with labels.
+type histogramVec struct {
+	name, help string
+	buckets    []float64
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*histogram // by labelKey
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, buckets: buckets, labels: labels, values: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := labelKey(labelValues)
+	hist := h.values[key]
+	if hist == nil {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		hist.counts[i]++
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		hist := h.values[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += hist.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, formatValue(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, ""), formatValue(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, ""), hist.count)
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labelKey joins label values into a map key; formatLabels splits it.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels renders the labels for key, with an le label for a
+// histogram bucket if le is set.
+func formatLabels(names []string, key, le string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, names[i]+"="+quoteLabel(value))
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, "le="+quoteLabel(le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// quoteLabel quotes a label value as the text format wants: only
+// backslash, double quote and newline are escaped.
+func quoteLabel(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, p *ProxyServer) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	p.metricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+	target, err := parseUpstream(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &ProxyServer{upstream: singlePool(target), metrics: newMetrics()}
+	p.reverse = newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false)
+	p.reverse.Transport = p.metrics.wrap(p.reverse.Transport)
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+	for range 2 {
+		resp, err := http.Get(proxy.URL + "/v1/models")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	// Tunnels through a forward proxy with the same metrics
+	forward := httptest.NewServer(&ProxyServer{metrics: p.metrics})
+	defer forward.Close()
+	forwardURL, _ := url.Parse(forward.URL)
+	tlsUpstream := httptest.NewTLSServer(upstream.Config.Handler)
+	defer tlsUpstream.Close()
+	transport := tlsUpstream.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(forwardURL)
+	transport.DisableKeepAlives = true
+	resp, err := (&http.Client{Transport: transport}).Get(tlsUpstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+	deadline := time.Now().Add(5 * time.Second)
+	var body string
+	for {
+		body = scrape(t, p)
+		if strings.Contains(body, `http_proxy_requests_total{method="CONNECT",code="200"} 1`) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, want := range []string{
+		"# TYPE http_proxy_requests_total counter",
+		`http_proxy_requests_total{method="GET",code="200"} 2`,
+		`http_proxy_requests_total{method="CONNECT",code="200"} 1`,
+		`http_proxy_bytes_total{direction="out"} `,
+		`http_proxy_tunnels_total{proto="CONNECT"} 1`,
+		"http_proxy_tunnels_active 0",
+		`http_proxy_upstream_requests_total{upstream="` + upstreamHost + `",code="200"} 2`,
+		"# TYPE http_proxy_upstream_duration_seconds histogram",
+		`http_proxy_upstream_duration_seconds_bucket{upstream="` + upstreamHost + `",le="+Inf"} 2`,
+		`http_proxy_upstream_duration_seconds_count{upstream="` + upstreamHost + `"} 2`,
+		`http_proxy_backend_healthy{route="default",upstream="` + upstreamHost + `"} 1`,
+		`http_proxy_circuit_breaker_open{route="default",upstream="` + upstreamHost + `"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+	if t.Failed() {
+		t.Log(body)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "upstream")
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		h.observe(v, `a"b`)
+	}
+	var b strings.Builder
+	h.write(&b)
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{upstream="a\"b",le="0.1"} 2
+latency_seconds_bucket{upstream="a\"b",le="1"} 3
+latency_seconds_bucket{upstream="a\"b",le="+Inf"} 4
+latency_seconds_sum{upstream="a\"b"} 2.65
+latency_seconds_count{upstream="a\"b"} 4
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
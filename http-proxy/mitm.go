@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"mtls"
+)
+
+// leafLifetime is how long the certificates made for intercepted hosts
+// are valid, at most; they are made again once they expire.
+const leafLifetime = 24 * time.Hour
+
+// interceptor decrypts CONNECT tunnels to its hosts, posing as them with
+// certificates it issues from a local CA, so that the requests inside can
+// be logged and inspected. Clients must trust the CA, or the root it was
+// issued from.
+type interceptor struct {
+	hosts hostPatterns
+	ca    *x509.Certificate
+	caKey crypto.Signer
+	chain [][]byte // DER, the CA and any certificates after it in its file, sent after each leaf
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+// loadInterceptor reads the CA for -mitm-hosts. The CA may be an
+// intermediate, with its issuers after it in certFile; they are sent
+// after each leaf, so that clients trusting only the root can verify it.
+func loadInterceptor(hosts, certFile, keyFile, keyPass string) (*interceptor, error) {
+	if certFile == "" || keyFile == "" && !mtls.IsPKCS12(certFile) {
+		return nil, fmt.Errorf("-mitm-hosts needs -mitm-ca-cert and -mitm-ca-key")
+	}
+	pair, err := mtls.LoadKeyPair(certFile, keyFile, keyPass)
+	if err != nil {
+		return nil, fmt.Errorf("loading CA: %w", err)
+	}
+	ca := pair.Leaf
+	if !ca.IsCA || ca.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, fmt.Errorf("%s is not a CA certificate", certFile)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type", keyFile)
+	}
+	return &interceptor{
+		hosts: parseHostPatterns(hosts),
+		ca:    ca,
+		caKey: key,
+		chain: pair.Certificate,
+		certs: make(map[string]*tls.Certificate),
+	}, nil
+}
+
+// certificate returns a certificate for name, a host name or IP address,
+// issuing one if there is none yet or it is about to expire.
+func (i *interceptor) certificate(name string) (*tls.Certificate, error) {
+	now := time.Now()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if cert := i.certs[name]; cert != nil && now.Add(time.Minute).Before(cert.Leaf.NotAfter) {
+		return cert, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if template.NotAfter.After(i.ca.NotAfter) {
+		template.NotAfter = i.ca.NotAfter
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, i.ca, &key.PublicKey, i.caKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{Certificate: append([][]byte{der}, i.chain...), PrivateKey: key, Leaf: leaf}
+	i.certs[name] = cert
+	return cert, nil
+}
+
+// intercept answers a CONNECT to one of the interceptor's hosts itself:
+// it completes the TLS handshake with the client as the host, then serves
+// the requests inside the tunnel as it would plain forward-proxy
+// requests, with their own access records, sending them to the host over
+// a new TLS connection.
+func (p *ProxyServer) intercept(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		log.Printf("[ERROR] Hijacking not supported")
+		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("[ERROR] Failed to hijack connection: %v", err)
+		return
+	}
+	defer clientConn.Close()
+	defer p.hijacked.track(clientConn)()
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+	rec := recordOf(r)
+	rec.Status = http.StatusOK
+	defer p.metrics.tunnelOpened("CONNECT")()
+
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	conn := tls.Server(clientConn, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			// The name the client asked for is the one it checks, but an
+			// IP address is never sent as SNI
+			name := hello.ServerName
+			if name == "" {
+				name = host
+			}
+			return p.mitm.certificate(name)
+		},
+		NextProtos: []string{"http/1.1"},
+	})
+	ctx, cancel := withTimeout(r.Context(), p.tlsHandshakeTimeout)
+	err = conn.HandshakeContext(ctx)
+	cancel()
+	if err != nil {
+		log.Printf("[MITM] TLS handshake with client for %s failed: %v", r.Host, err)
+		p.metrics.tlsError("client")
+		return
+	}
+	log.Printf("[MITM] Intercepting tunnel to %s", r.Host)
+
+	user := rec.User
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			req.URL.Scheme, req.URL.Host = "https", r.Host
+			p.serveIntercepted(w, req, user)
+		}),
+		IdleTimeout: p.tunnelIdle,
+	}
+	listener := newConnListener(conn)
+	server.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed || state == http.StateHijacked {
+			listener.Close()
+		}
+	}
+	server.Serve(listener)
+}
+
+// serveIntercepted proxies one request from inside an intercepted tunnel
+// by user, who was authenticated for the CONNECT.
+func (p *ProxyServer) serveIntercepted(w http.ResponseWriter, r *http.Request, user string) {
+	rec, w, r := startRecord(w, r)
+	rec.User = user
+	r, span := p.tracer.start(r)
+	r, done := p.limitRequest(r)
+	defer done()
+	if p.verbose {
+		log.Printf("[MITM] %s %s\n%s", r.Method, r.URL, describeHeaders(r.Header))
+	}
+
+	w, finish := p.negotiateCompression(w, r)
+	if p.checkBodySize(w, r) {
+		var completion *completionWriter
+		if p.completions != nil && hasCompletion(r) {
+			completion = newCompletionWriter(w, r)
+			w = completion
+		}
+		p.handleHTTP(w, r)
+		p.completions.log(completion.finish(r.URL.Host))
+	}
+	finish()
+
+	if p.verbose {
+		log.Printf("[MITM] %d from %s%s\n%s", rec.Status, r.URL.Host, r.URL.Path, describeHeaders(w.Header()))
+	}
+	p.logAccess(rec)
+	span.finishRequest(rec)
+}
+
+// redactedHeaders are headers whose values are credentials, which are not
+// logged.
+var redactedHeaders = []string{"Authorization", "Api-Key", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// describeHeaders lists h a header to a line, for the verbose log, with
+// credentials redacted.
+func describeHeaders(h http.Header) string {
+	h = h.Clone()
+	for _, name := range redactedHeaders {
+		if h.Get(name) != "" {
+			h.Set(name, "[redacted]")
+		}
+	}
+	var b strings.Builder
+	h.Write(&b)
+	return strings.TrimSuffix(b.String(), "\r\n")
+}
+
+// connListener is a listener that accepts one connection, so that an
+// http.Server can serve it, and then blocks until closed.
+type connListener struct {
+	conn   chan net.Conn
+	addr   net.Addr
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	l := &connListener{conn: make(chan net.Conn, 1), addr: conn.LocalAddr(), closed: make(chan struct{})}
+	l.conn <- conn
+	return l
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conn:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCA makes a CA named name, issued by parent, or self-signed if
+// parent is nil.
+func newTestCA(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, key
+}
+
+// writeTestMITMCA writes key and chain, the CA first, for -mitm-ca-cert
+// and -mitm-ca-key.
+func writeTestMITMCA(t *testing.T, key *ecdsa.PrivateKey, chain ...*x509.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certs []byte
+	for _, cert := range chain {
+		certs = append(certs, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "mitm-ca.crt"), filepath.Join(dir, "mitm-ca.key")
+	if err := os.WriteFile(certFile, certs, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+// newTestMITMCA writes a CA for -mitm-ca-cert and -mitm-ca-key, and
+// returns a pool trusting it.
+func newTestMITMCA(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	ca, key := newTestCA(t, "Proxy-Test-MITM-CA", nil, nil)
+	certFile, keyFile = writeTestMITMCA(t, key, ca)
+	pool = x509.NewCertPool()
+	pool.AddCert(ca)
+	return certFile, keyFile, pool
+}
+
+func TestIntercept(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"path":"`+r.URL.Path+`"}`)
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	certFile, keyFile, pool := newTestMITMCA(t)
+	mitm, err := loadInterceptor(upstreamURL.Hostname(), certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make(lines, 10)
+	accessLog, _ := newAccessLogger("json", records)
+	p := &ProxyServer{mitm: mitm, accessLog: accessLog}
+	p.setTransports(defaultTransportOptions)
+	p.transport.TLSClientConfig = upstream.Client().Transport.(*http.Transport).TLSClientConfig
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}}
+	for _, path := range []string{"/v1/models", "/v1/files"} {
+		resp, err := client.Get(upstream.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != `{"path":"`+path+`"}` {
+			t.Errorf("Got %s, want the upstream's answer for %s", body, path)
+		}
+		if issuer := resp.TLS.PeerCertificates[0].Issuer.CommonName; issuer != "Proxy-Test-MITM-CA" {
+			t.Errorf("Certificate issued by %s, want the MITM CA", issuer)
+		}
+	}
+
+	// Each request in the tunnel has a record of its own
+	for _, path := range []string{"/v1/models", "/v1/files"} {
+		var rec struct {
+			Method, Path, Upstream string
+			Status                 int
+		}
+		json.Unmarshal([]byte(records.next(t)), &rec)
+		if rec.Method != http.MethodGet || rec.Path != path || rec.Status != http.StatusOK || rec.Upstream != upstreamURL.Host {
+			t.Errorf("Record %+v, want GET %s to %s with 200", rec, path, upstreamURL.Host)
+		}
+	}
+
+	// Other hosts are tunnelled as they are
+	p.mitm.hosts = parseHostPatterns("example.com")
+	direct := upstream.Client().Transport.(*http.Transport).Clone()
+	direct.Proxy = http.ProxyURL(proxyURL)
+	resp, err := (&http.Client{Transport: direct}).Get(upstream.URL + "/v1/models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if issuer := resp.TLS.PeerCertificates[0].Issuer.CommonName; issuer == "Proxy-Test-MITM-CA" {
+		t.Error("Tunnel to a host not in -mitm-hosts was intercepted")
+	}
+}
+
+func TestInterceptorCertificate(t *testing.T) {
+	certFile, keyFile, pool := newTestMITMCA(t)
+	mitm, err := loadInterceptor("*", certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"api.openai.com", "10.0.0.1"} {
+		cert, err := mitm.certificate(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: pool}); err != nil {
+			t.Errorf("Certificate for %s: %v", name, err)
+		}
+		if again, _ := mitm.certificate(name); again != cert {
+			t.Errorf("Certificate for %s issued again", name)
+		}
+	}
+
+	// An intermediate CA's certificates are sent with the chain in its
+	// file, so clients trusting only the root can verify them
+	root, rootKey := newTestCA(t, "Proxy-Test-Root", nil, nil)
+	intermediate, intermediateKey := newTestCA(t, "Proxy-Test-MITM-Intermediate", root, rootKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	for _, chain := range [][]*x509.Certificate{{intermediate}, {intermediate, root}} {
+		certFile, keyFile := writeTestMITMCA(t, intermediateKey, chain...)
+		mitm, err := loadInterceptor("*", certFile, keyFile, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := mitm.certificate("api.openai.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cert.Certificate) != 1+len(chain) {
+			t.Errorf("%d certificates in the file: %d sent, want the leaf and them", len(chain), len(cert.Certificate))
+		}
+		intermediates := x509.NewCertPool()
+		for _, der := range cert.Certificate[1:] {
+			c, _ := x509.ParseCertificate(der)
+			intermediates.AddCert(c)
+		}
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "api.openai.com", Roots: roots, Intermediates: intermediates}); err != nil {
+			t.Errorf("%d certificates in the file: %v", len(chain), err)
+		}
+	}
+
+	// A server certificate is not a CA
+	pki := newTestPKI(t)
+	if _, err := loadInterceptor("*", pki.serverCertFile, pki.serverKeyFile, ""); err == nil {
+		t.Error("Loaded a server certificate as the MITM CA")
+	}
+}
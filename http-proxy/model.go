@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// maxModelBody caps how much of a request body is read to find its model.
+const maxModelBody = 32 << 20
+
+// modelEndpoints are the path suffixes of requests whose JSON body names
+// the model.
+var modelEndpoints = []string{"/chat/completions", "/completions", "/embeddings", "/responses"}
+
+// hasModelBody reports whether r is a request whose body names the model.
+func hasModelBody(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	for _, suffix := range modelEndpoints {
+		if strings.HasSuffix(r.URL.Path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// errBodyTooLarge is returned by readModel for a body over maxModelBody,
+// or over -max-body-size.
+var errBodyTooLarge = errors.New("request body too large")
+
+// readModel reads r's body, replacing it with a copy, and returns it with
+// the model it names ("" if it is not JSON or has no model).
+func readModel(r *http.Request) ([]byte, string, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxModelBody+1))
+	r.Body.Close()
+	if bodyTooLarge(err) {
+		return nil, "", fmt.Errorf("%w: %w", errBodyTooLarge, err)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if len(body) > maxModelBody {
+		return nil, "", fmt.Errorf("%w: over %d bytes", errBodyTooLarge, maxModelBody)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var fields struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal(body, &fields)
+	return body, fields.Model, nil
+}
+
+// readFormModel reads r's multipart form body, such as an audio upload's,
+// as far as its model field, and returns the model, putting what it read
+// back in front of the rest. It returns "" if the body is not a form or
+// the model is not in its first maxModelBody bytes; the file usually
+// comes after it, and is then left unread.
+func readFormModel(r *http.Request) string {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return ""
+	}
+	var read bytes.Buffer
+	form := multipart.NewReader(io.TeeReader(io.LimitReader(r.Body, maxModelBody), &read), params["boundary"])
+	var model string
+	for {
+		part, err := form.NextPart()
+		if err != nil {
+			break
+		}
+		if part.FormName() == "model" {
+			value, _ := io.ReadAll(io.LimitReader(part, 256))
+			model = strings.TrimSpace(string(value))
+			break
+		}
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(&read, r.Body), r.Body}
+	return model
+}
+
+// requestModel reads r's body, JSON or a multipart form, for the model it
+// names. It returns a JSON body as readModel does; a form is left to be
+// streamed.
+func requestModel(r *http.Request) ([]byte, string, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return nil, readFormModel(r), nil
+	}
+	return readModel(r)
+}
+
+// setModel replaces the model named in a JSON request body. Other fields
+// are kept as they are, although their order may change.
+func setModel(body []byte, model string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	name, err := json.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+	fields["model"] = name
+	return json.Marshal(fields)
+}
+
+// replaceBody sets body as r's body.
+func replaceBody(r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Del("Content-Length")
+}
+
+// matchModel reports whether model matches pattern, a shell-style pattern
+// such as gpt-4* (empty matches any model).
+func matchModel(pattern, model string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, model)
+	return ok
+}
+
+// validModelPattern reports whether pattern is well formed.
+func validModelPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestModelRouting(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var fields map[string]any
+			json.Unmarshal(body, &fields)
+			io.WriteString(w, name+" "+fields["model"].(string))
+		}))
+	}
+	azure, mock := backend("azure"), backend("mock")
+	defer azure.Close()
+	defer mock.Close()
+
+	config, err := loadConfig(writeConfig(t, `
+routes:
+  - model: gpt-4*
+    upstream: `+azure.URL+`
+    rewrite_model:
+      gpt-4o: my-gpt4o-deployment
+  - path: /v1/
+    upstream: `+mock.URL+`
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.needsModel() {
+		t.Fatal("needsModel() = false with a model route")
+	}
+	server := httptest.NewServer(&ProxyServer{reverse: newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false), routes: config.Routes, routeByModel: true})
+	defer server.Close()
+
+	for _, tc := range []struct{ path, model, want string }{
+		{"/v1/chat/completions", "gpt-4o", "azure my-gpt4o-deployment"},
+		{"/v1/chat/completions", "gpt-4-turbo", "azure gpt-4-turbo"},
+		{"/v1/chat/completions", "gpt-3.5-turbo", "mock gpt-3.5-turbo"},
+		{"/v1/embeddings", "text-embedding-3-small", "mock text-embedding-3-small"},
+	} {
+		resp, err := http.Post(server.URL+tc.path, "application/json",
+			strings.NewReader(`{"model":"`+tc.model+`","messages":[{"role":"user","content":"hi"}],"stream":false}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != tc.want {
+			t.Errorf("%s %s: got %q, want %q", tc.path, tc.model, body, tc.want)
+		}
+	}
+}
+
+func TestSetModel(t *testing.T) {
+	body, err := setModel([]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"temperature":0.5}`), "deployment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if string(fields["model"]) != `"deployment"` || string(fields["temperature"]) != "0.5" || !strings.Contains(string(fields["messages"]), `"content":"hi"`) {
+		t.Errorf("setModel gave %s", body)
+	}
+	if _, err := setModel([]byte("not json"), "x"); err == nil {
+		t.Error("setModel accepted a body that is not JSON")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPKI is a throwaway CA with a server identity for localhost and a
+// client identity, written as PEM files to a temporary directory.
+type testPKI struct {
+	pool   *x509.CertPool
+	server tls.Certificate
+	client tls.Certificate
+
+	caFile, clientCertFile, clientKeyFile, serverCertFile, serverKeyFile string
+
+	dir   string
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Proxy-Test-CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	pki := &testPKI{pool: x509.NewCertPool(), caFile: filepath.Join(dir, "ca.crt"), dir: dir, ca: ca, caKey: caKey}
+	pki.pool.AddCert(ca)
+	writePEM(t, pki.caFile, "CERTIFICATE", caDER)
+
+	pki.server, pki.serverCertFile, pki.serverKeyFile = issueTestCert(t, dir, ca, caKey, 2, nil, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	pki.client, pki.clientCertFile, pki.clientKeyFile = issueTestCert(t, dir, ca, caKey, 3, nil, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "proxy-client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return pki
+}
+
+// issueTestCert issues a certificate for key, or a new P-256 key if nil,
+// and writes both to dir.
+func issueTestCert(t *testing.T, dir string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, serial int64, key crypto.Signer, template *x509.Certificate) (tls.Certificate, string, string) {
+	t.Helper()
+	if key == nil {
+		var err error
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore, template.NotAfter = ca.NotBefore, ca.NotAfter
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, template.Subject.CommonName+".crt")
+	keyFile := filepath.Join(dir, template.Subject.CommonName+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// proxyAuthRealm is the realm in Proxy-Authenticate challenges.
+const proxyAuthRealm = "http-proxy"
+
+// proxyAuth holds the credentials from -proxy-auth-file.
+type proxyAuth struct {
+	users  map[string]string // Basic: user to password
+	tokens []string          // Bearer
+}
+
+// loadProxyAuth reads a credentials file: one "user:password" for Basic
+// or "Bearer <token>" per line, with blank lines and # comments ignored.
+func loadProxyAuth(path string) (*proxyAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	auth := &proxyAuth{users: make(map[string]string)}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if scheme, token, ok := strings.Cut(line, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			if token = strings.TrimSpace(token); token == "" {
+				return nil, fmt.Errorf("%s:%d: empty bearer token", path, n)
+			}
+			auth.tokens = append(auth.tokens, token)
+			continue
+		}
+		user, password, ok := strings.Cut(line, ":")
+		if !ok || user == "" || password == "" {
+			return nil, fmt.Errorf("%s:%d: want user:password or Bearer <token>", path, n)
+		}
+		auth.users[user] = password
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(auth.users) == 0 && len(auth.tokens) == 0 {
+		return nil, fmt.Errorf("%s: no credentials", path)
+	}
+	return auth, nil
+}
+
+// check reports whether a Proxy-Authorization header holds valid
+// credentials, and who they belong to ("bearer" for a token).
+func (a *proxyAuth) check(header string) (string, bool) {
+	scheme, credentials, _ := strings.Cut(header, " ")
+	credentials = strings.TrimSpace(credentials)
+	switch {
+	case strings.EqualFold(scheme, "Basic"):
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return "", false
+		}
+		user, password, _ := strings.Cut(string(decoded), ":")
+		want, ok := a.users[user]
+		if ok && subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1 {
+			return user, true
+		}
+	case strings.EqualFold(scheme, "Bearer"):
+		for _, token := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(credentials), []byte(token)) == 1 {
+				return "bearer", true
+			}
+		}
+	}
+	return "", false
+}
+
+// challenges are the Proxy-Authenticate headers for the schemes a accepts.
+func (a *proxyAuth) challenges() []string {
+	var challenges []string
+	if len(a.users) > 0 {
+		challenges = append(challenges, fmt.Sprintf("Basic realm=%q", proxyAuthRealm))
+	}
+	if len(a.tokens) > 0 {
+		challenges = append(challenges, fmt.Sprintf("Bearer realm=%q", proxyAuthRealm))
+	}
+	return challenges
+}
+
+// auth returns the credentials clients must give, if any: those of
+// -proxy-auth-file, or the config file's proxy_auth_file as last loaded.
+func (p *ProxyServer) auth() *proxyAuth {
+	p.policyMu.RLock()
+	defer p.policyMu.RUnlock()
+	return p.proxyAuth
+}
+
+// checkProxyAuth refuses, with 407 and a challenge, a request without
+// valid Proxy-Authorization when -proxy-auth-file is set, and reports
+// whether it may go ahead.
+func (p *ProxyServer) checkProxyAuth(w http.ResponseWriter, r *http.Request) bool {
+	auth := p.auth()
+	if auth == nil {
+		return true
+	}
+	header := r.Header.Get("Proxy-Authorization")
+	if user, ok := auth.check(header); ok {
+		recordOf(r).User = user
+		if p.verbose {
+			log.Printf("[AUTH] %s %s as %s", r.Method, destination(r), user)
+		}
+		return true
+	}
+	if header != "" {
+		log.Printf("[DENIED] %s %s from %s: bad proxy credentials", r.Method, destination(r), r.RemoteAddr)
+	}
+	for _, challenge := range auth.challenges() {
+		w.Header().Add("Proxy-Authenticate", challenge)
+	}
+	http.Error(w, "Proxy authentication required", http.StatusProxyAuthRequired)
+	return false
+}
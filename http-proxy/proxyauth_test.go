@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeProxyAuth(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "proxy-auth")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadProxyAuth(t *testing.T) {
+	auth, err := loadProxyAuth(writeProxyAuth(t, "# services\nalice:s3cret:with:colons\n\nBearer tok-123\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]string{
+		"Basic YWxpY2U6czNjcmV0OndpdGg6Y29sb25z": "alice",
+		"basic YWxpY2U6czNjcmV0OndpdGg6Y29sb25z": "alice",
+		"Bearer tok-123":                         "bearer",
+		"Basic YWxpY2U6d3Jvbmc=":                 "",
+		"Basic !!!":                              "",
+		"Bearer tok-12":                          "",
+		"":                                       "",
+	} {
+		user, ok := auth.check(header)
+		if ok != (want != "") || user != want {
+			t.Errorf("check(%q) = %q, %v; want %q", header, user, ok, want)
+		}
+	}
+
+	for name, content := range map[string]string{
+		"empty":        "# nothing\n",
+		"no password":  "alice:\n",
+		"no separator": "alice\n",
+		"empty token":  "Bearer  \n",
+	} {
+		if _, err := loadProxyAuth(writeProxyAuth(t, content)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestProxyAuthRequired(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "" {
+			t.Error("Proxy-Authorization forwarded upstream")
+		}
+	}))
+	defer upstream.Close()
+	plain := httptest.NewServer(upstream.Config.Handler)
+	defer plain.Close()
+
+	auth, err := loadProxyAuth(writeProxyAuth(t, "alice:s3cret\nBearer tok-123\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(&ProxyServer{proxyAuth: auth})
+	defer proxy.Close()
+
+	for _, tc := range []struct {
+		user   *url.Userinfo
+		target string
+		want   int
+	}{
+		{nil, plain.URL, http.StatusProxyAuthRequired},
+		{url.UserPassword("alice", "wrong"), plain.URL, http.StatusProxyAuthRequired},
+		{url.UserPassword("alice", "s3cret"), plain.URL, http.StatusOK},
+		{url.UserPassword("alice", "s3cret"), upstream.URL, http.StatusOK},
+	} {
+		proxyURL, _ := url.Parse(proxy.URL)
+		proxyURL.User = tc.user
+		transport := upstream.Client().Transport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxyURL)
+		resp, err := (&http.Client{Transport: transport}).Get(tc.target)
+		if err != nil {
+			t.Errorf("%v to %s: %v", tc.user, tc.target, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%v to %s: got %d, want %d", tc.user, tc.target, resp.StatusCode, tc.want)
+		}
+		if tc.want == http.StatusProxyAuthRequired && len(resp.Header.Values("Proxy-Authenticate")) != 2 {
+			t.Errorf("challenges %q, want Basic and Bearer", resp.Header.Values("Proxy-Authenticate"))
+		}
+	}
+
+	// Bearer tokens, and a CONNECT without credentials
+	req, _ := http.NewRequest(http.MethodGet, plain.URL, nil)
+	req.Header.Set("Proxy-Authorization", "Bearer tok-123")
+	proxyURL, _ := url.Parse(proxy.URL)
+	resp, err := (&http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("bearer token: got %d, want 200", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest(http.MethodConnect, proxy.URL, nil)
+	req.URL = &url.URL{Scheme: "http", Host: proxyURL.Host, Opaque: strings.TrimPrefix(upstream.URL, "https://")}
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("CONNECT without credentials: got %d, want 407", resp.StatusCode)
+	}
+}
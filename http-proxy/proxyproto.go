@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PROXY protocol versions for -send-proxy-protocol.
+const (
+	proxyProtocolV1 = "v1"
+	proxyProtocolV2 = "v2"
+)
+
+// proxyV2Signature starts a PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLength is the longest a v1 header can be, line end included.
+const proxyV1MaxLength = 107
+
+// proxyProtocolListener reads the PROXY protocol header that a load
+// balancer in trusted sends before each connection's own bytes, and gives
+// the connection the client's addresses from it. Connections from
+// elsewhere are taken as they are. Headers are read as connections come,
+// each in its own goroutine, so one slow to send its header does not hold
+// up the rest.
+type proxyProtocolListener struct {
+	net.Listener
+	trusted []netip.Prefix
+	timeout time.Duration // to read a header; zero for no limit
+
+	accepted chan acceptResult
+	done     chan struct{}
+	once     sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newProxyProtocolListener(l net.Listener, trusted []netip.Prefix, timeout time.Duration) *proxyProtocolListener {
+	pl := &proxyProtocolListener{
+		Listener: l,
+		trusted:  trusted,
+		timeout:  timeout,
+		accepted: make(chan acceptResult),
+		done:     make(chan struct{}),
+	}
+	go pl.acceptLoop()
+	return pl
+}
+
+func (l *proxyProtocolListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.deliver(acceptResult{err: err})
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.readHeader(conn)
+	}
+}
+
+// readHeader reads conn's header, if it comes from a load balancer, and
+// hands it to Accept. A connection with a bad header is closed.
+func (l *proxyProtocolListener) readHeader(conn net.Conn) {
+	if !inRanges(l.trusted, conn.RemoteAddr()) {
+		l.deliver(acceptResult{conn: conn})
+		return
+	}
+	if l.timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(l.timeout))
+	}
+	proxied, err := readProxyHeader(conn)
+	if err != nil {
+		log.Printf("[PROXY] Closing connection from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	l.deliver(acceptResult{conn: proxied})
+}
+
+func (l *proxyProtocolListener) deliver(result acceptResult) {
+	select {
+	case l.accepted <- result:
+	case <-l.done:
+		if result.conn != nil {
+			result.conn.Close()
+		}
+	}
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	select {
+	case result := <-l.accepted:
+		return result.conn, result.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *proxyProtocolListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// proxiedConn is a connection whose PROXY protocol header has been read:
+// its addresses are the ones the header gave, and reads start after it.
+type proxiedConn struct {
+	net.Conn
+	r             *bufio.Reader
+	remote, local net.Addr
+}
+
+func (c *proxiedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+func (c *proxiedConn) RemoteAddr() net.Addr       { return c.remote }
+func (c *proxiedConn) LocalAddr() net.Addr        { return c.local }
+
+func (c *proxiedConn) CloseWrite() error {
+	closeWrite(c.Conn)
+	return nil
+}
+
+func (c *proxiedConn) readAhead() (io.Reader, net.Conn) {
+	return io.LimitReader(c.r, int64(c.r.Buffered())), c.Conn
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header from conn. A
+// header for a connection of the load balancer's own, such as a health
+// check, or of a kind other than TCP, keeps conn's addresses.
+func readProxyHeader(conn net.Conn) (net.Conn, error) {
+	r := bufio.NewReader(conn)
+	start, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("reading PROXY protocol header: %w", err)
+	}
+	var src, dst *net.TCPAddr
+	switch {
+	case bytes.Equal(start, proxyV2Signature):
+		src, dst, err = readProxyV2(r)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		src, dst, err = readProxyV1(r)
+	default:
+		err = fmt.Errorf("no PROXY protocol header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	proxied := &proxiedConn{Conn: conn, r: r, remote: conn.RemoteAddr(), local: conn.LocalAddr()}
+	if src != nil {
+		proxied.remote, proxied.local = src, dst
+	}
+	return proxied, nil
+}
+
+// readProxyV1 reads a text header: PROXY TCP4 <src> <dst> <sport> <dport>,
+// or PROXY UNKNOWN.
+func readProxyV1(r *bufio.Reader) (src, dst *net.TCPAddr, err error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength && !bytes.HasSuffix(line, []byte("\r\n")) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading PROXY protocol header: %w", err)
+		}
+		line = append(line, b)
+	}
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	switch {
+	case !bytes.HasSuffix(line, []byte("\r\n")):
+		return nil, nil, fmt.Errorf("PROXY protocol v1 header longer than %d bytes", proxyV1MaxLength)
+	case len(fields) >= 2 && fields[1] == "UNKNOWN":
+		return nil, nil, nil
+	case len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6":
+		return nil, nil, fmt.Errorf("invalid PROXY protocol v1 header %q", line)
+	}
+	src, err = parseProxyV1Addr(fields[2], fields[4])
+	if err == nil {
+		dst, err = parseProxyV1Addr(fields[3], fields[5])
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PROXY protocol v1 header %q: %w", line, err)
+	}
+	return src, dst, nil
+}
+
+func parseProxyV1Addr(ip, port string) (*net.TCPAddr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(n))), nil
+}
+
+// readProxyV2 reads a binary header: the signature, the version and
+// command, the address family, the length of the rest, and the addresses
+// followed by any TLVs, which are skipped.
+func readProxyV2(r *bufio.Reader) (src, dst *net.TCPAddr, err error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, fmt.Errorf("reading PROXY protocol header: %w", err)
+	}
+	versionCommand, family := header[12], header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, fmt.Errorf("reading PROXY protocol header: %w", err)
+	}
+	if versionCommand>>4 != 2 {
+		return nil, nil, fmt.Errorf("PROXY protocol version %d", versionCommand>>4)
+	}
+	switch command := versionCommand & 0xf; {
+	case command == 0:
+		// LOCAL: the load balancer's own connection
+		return nil, nil, nil
+	case command != 1:
+		return nil, nil, fmt.Errorf("PROXY protocol v2 command %d", command)
+	}
+
+	var size int
+	switch family {
+	case 0x11: // TCP over IPv4
+		size = 4
+	case 0x21: // TCP over IPv6
+		size = 16
+	default:
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, fmt.Errorf("PROXY protocol v2 addresses cut short")
+	}
+	ip := func(b []byte) netip.Addr {
+		addr, _ := netip.AddrFromSlice(b)
+		return addr
+	}
+	ports := body[2*size:]
+	src = net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip(body[:size]), binary.BigEndian.Uint16(ports)))
+	dst = net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip(body[size:2*size]), binary.BigEndian.Uint16(ports[2:])))
+	return src, dst, nil
+}
+
+// parseSendProxyProtocol checks -send-proxy-protocol, which is empty for
+// none.
+func parseSendProxyProtocol(version string) error {
+	switch version {
+	case "", proxyProtocolV1, proxyProtocolV2:
+		return nil
+	}
+	return fmt.Errorf("invalid -send-proxy-protocol %q: must be %s or %s", version, proxyProtocolV1, proxyProtocolV2)
+}
+
+// proxyHeader makes a PROXY protocol header of version for a connection
+// from src to dst. Addresses that are not TCP give a header without them:
+// UNKNOWN in v1, LOCAL in v2.
+func proxyHeader(version string, src, dst net.Addr) []byte {
+	from, fromOK := src.(*net.TCPAddr)
+	to, toOK := dst.(*net.TCPAddr)
+	var srcIP, dstIP netip.Addr
+	if fromOK && toOK {
+		srcIP, dstIP = from.AddrPort().Addr().Unmap(), to.AddrPort().Addr().Unmap()
+	}
+	ipv4 := srcIP.Is4() && dstIP.Is4()
+	if !ipv4 && fromOK && toOK {
+		// Mixed families go as IPv6, IPv4 addresses mapped
+		srcIP, dstIP = netip.AddrFrom16(srcIP.As16()), netip.AddrFrom16(dstIP.As16())
+	}
+
+	if version == proxyProtocolV1 {
+		switch {
+		case !fromOK || !toOK:
+			return []byte("PROXY UNKNOWN\r\n")
+		case ipv4:
+			return fmt.Appendf(nil, "PROXY TCP4 %s %s %d %d\r\n", srcIP, dstIP, from.Port, to.Port)
+		default:
+			return fmt.Appendf(nil, "PROXY TCP6 %s %s %d %d\r\n", srcIP, dstIP, from.Port, to.Port)
+		}
+	}
+
+	header := append([]byte(nil), proxyV2Signature...)
+	if !fromOK || !toOK {
+		return append(header, 0x20, 0x00, 0, 0)
+	}
+	family := byte(0x21)
+	if ipv4 {
+		family = 0x11
+	}
+	addrs := append(srcIP.AsSlice(), dstIP.AsSlice()...)
+	addrs = binary.BigEndian.AppendUint16(addrs, uint16(from.Port))
+	addrs = binary.BigEndian.AppendUint16(addrs, uint16(to.Port))
+	header = append(header, 0x21, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+// sendProxyHeader starts target, a tunnel's connection, with a PROXY
+// protocol header giving the addresses of client's connection, with
+// -send-proxy-protocol.
+func (p *ProxyServer) sendProxyHeader(target, client net.Conn) error {
+	if p.sendProxyProtocol == "" {
+		return nil
+	}
+	_, err := target.Write(proxyHeader(p.sendProxyProtocol, client.RemoteAddr(), client.LocalAddr()))
+	return err
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := func(command, family byte, addrs ...byte) string {
+		header := append([]byte(nil), proxyV2Signature...)
+		header = append(header, 0x20|command, family)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+		return string(append(header, addrs...))
+	}
+	for _, tt := range []struct {
+		name, header string
+		remote       string // "" for the connection's own
+		wantErr      bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 203.0.113.7 10.0.0.1 51000 443\r\n", "203.0.113.7:51000", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::7 2001:db8::1 51000 443\r\n", "[2001:db8::7]:51000", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "", false},
+		{"v2 tcp4", v2(1, 0x11, 203, 0, 113, 7, 10, 0, 0, 1, 0xc7, 0x38, 0x01, 0xbb), "203.0.113.7:51000", false},
+		{"v2 tcp4 with TLV", v2(1, 0x11, 203, 0, 113, 7, 10, 0, 0, 1, 0xc7, 0x38, 0x01, 0xbb, 0x04, 0x00, 0x01, 0x00), "203.0.113.7:51000", false},
+		{"v2 local", v2(0, 0x00), "", false},
+		{"v2 udp", v2(1, 0x12, 203, 0, 113, 7, 10, 0, 0, 1, 0xc7, 0x38, 0x01, 0xbb), "", false},
+		{"v2 short", v2(1, 0x11, 203, 0, 113, 7), "", true},
+		{"no header", "GET / HTTP/1.1\r\nHost: x\r\n\r\n", "", true},
+		{"v1 bad address", "PROXY TCP4 example.com 10.0.0.1 51000 443\r\n", "", true},
+		{"v1 bad port", "PROXY TCP4 203.0.113.7 10.0.0.1 70000 443\r\n", "", true},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", "", true},
+	} {
+		client, server := net.Pipe()
+		go func() {
+			io.WriteString(client, tt.header+"hello")
+			client.Close()
+		}()
+		conn, err := readProxyHeader(server)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: read a header from %q", tt.name, tt.header)
+			}
+			server.Close()
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			server.Close()
+			continue
+		}
+		want := tt.remote
+		if want == "" {
+			want = server.RemoteAddr().String()
+		}
+		if got := conn.RemoteAddr().String(); got != want {
+			t.Errorf("%s: remote address %s, want %s", tt.name, got, want)
+		}
+		if rest, _ := io.ReadAll(conn); string(rest) != "hello" {
+			t.Errorf("%s: read %q after the header, want hello", tt.name, rest)
+		}
+		server.Close()
+	}
+}
+
+func TestProxyHeader(t *testing.T) {
+	tcp := func(s string) *net.TCPAddr { return net.TCPAddrFromAddrPort(netip.MustParseAddrPort(s)) }
+	for _, tt := range []struct {
+		src, dst   net.Addr
+		wantV1     string
+		wantRemote string
+	}{
+		{tcp("203.0.113.7:51000"), tcp("10.0.0.1:8080"), "PROXY TCP4 203.0.113.7 10.0.0.1 51000 8080\r\n", "203.0.113.7:51000"},
+		{tcp("[2001:db8::7]:51000"), tcp("[2001:db8::1]:8080"), "PROXY TCP6 2001:db8::7 2001:db8::1 51000 8080\r\n", "[2001:db8::7]:51000"},
+		{tcp("203.0.113.7:51000"), tcp("[2001:db8::1]:8080"), "PROXY TCP6 ::ffff:203.0.113.7 2001:db8::1 51000 8080\r\n", "203.0.113.7:51000"},
+		{&net.UnixAddr{Name: "/run/proxy.sock", Net: "unix"}, tcp("10.0.0.1:8080"), "PROXY UNKNOWN\r\n", ""},
+	} {
+		if got := string(proxyHeader(proxyProtocolV1, tt.src, tt.dst)); got != tt.wantV1 {
+			t.Errorf("v1 header for %s: got %q, want %q", tt.src, got, tt.wantV1)
+		}
+		// Each version reads back as the addresses it was made from
+		for _, version := range []string{proxyProtocolV1, proxyProtocolV2} {
+			client, server := net.Pipe()
+			go func() {
+				client.Write(proxyHeader(version, tt.src, tt.dst))
+				client.Close()
+			}()
+			conn, err := readProxyHeader(server)
+			if err != nil {
+				t.Errorf("%s header for %s: %v", version, tt.src, err)
+				server.Close()
+				continue
+			}
+			want := tt.wantRemote
+			if want == "" {
+				want = server.RemoteAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != want {
+				t.Errorf("%s header for %s: read back %s, want %s", version, tt.src, got, want)
+			}
+			server.Close()
+		}
+	}
+
+	for _, bad := range []string{"v3", "1", "yes"} {
+		if err := parseSendProxyProtocol(bad); err == nil {
+			t.Errorf("parseSendProxyProtocol(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	for _, tt := range []struct {
+		trusted, header, want string
+	}{
+		// A load balancer's header gives the client, to the allowlist too
+		{"127.0.0.0/8", "PROXY TCP4 203.0.113.7 10.0.0.1 51000 8080\r\n", "203.0.113.7:51000"},
+		// Anyone else's connection is taken as it is
+		{"10.0.0.0/8", "", "127.0.0.1"},
+		// and a load balancer's without a header is closed
+		{"127.0.0.0/8", "", ""},
+	} {
+		trusted, _ := parseClientRanges(tt.trusted)
+		allow, _ := parseClientRanges("127.0.0.0/8,203.0.113.0/24")
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := &ProxyServer{allowClients: allow}
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.RemoteAddr)
+		}))
+		server.Listener = &clientListener{Listener: newProxyProtocolListener(l, trusted, time.Second), proxy: p, name: "test"}
+		server.Start()
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(conn, tt.header+"GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+		var got string
+		if resp, err := http.ReadResponse(bufio.NewReader(conn), nil); err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			got = string(body)
+		}
+		conn.Close()
+		server.Close()
+
+		switch {
+		case tt.want == "" && got != "":
+			t.Errorf("trusting %s, no header: served as %s", tt.trusted, got)
+		case !strings.HasPrefix(got, tt.want):
+			t.Errorf("trusting %s, header %q: served as %q, want %s", tt.trusted, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestSendProxyHeader(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+
+	proxy := httptest.NewServer(&ProxyServer{sendProxyProtocol: proxyProtocolV1})
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	conn, err := net.Dial("tcp", proxyURL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "CONNECT "+target.Addr().String()+" HTTP/1.1\r\nHost: "+target.Addr().String()+"\r\n\r\n")
+	if resp, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT: %v", err)
+	}
+
+	want := "PROXY TCP4 127.0.0.1 127.0.0.1 " + strings.TrimPrefix(conn.LocalAddr().String(), "127.0.0.1:") + " " + proxyURL.Port() + "\r\n"
+	select {
+	case got := <-received:
+		if got != want {
+			t.Errorf("target read %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("target got no header")
+	}
+}
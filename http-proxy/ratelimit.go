@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket per client: each holds up to burst
+// requests and refills at perSecond.
+type rateLimiter struct {
+	perSecond float64
+	burst     float64
+	key       string // ip, cert or header:<name>
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// parseRate parses a rate such as 10/s, 600/m or 5000/h into requests per
+// second, also returning the count, which is the default burst.
+func parseRate(s string) (float64, int, error) {
+	count, unit, ok := strings.Cut(s, "/")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n < 1 {
+		return 0, 0, fmt.Errorf("invalid rate %q: want requests per unit, such as 10/s, 600/m or 5000/h", s)
+	}
+	per := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+	if per == 0 {
+		return 0, 0, fmt.Errorf("invalid rate %q: unit must be s, m or h", s)
+	}
+	return float64(n) / per.Seconds(), n, nil
+}
+
+// newRateLimiter makes a limiter from -rate-limit, -rate-burst (0 for the
+// rate's count) and -rate-key.
+func newRateLimiter(rate string, burst int, key string) (*rateLimiter, error) {
+	perSecond, count, err := parseRate(rate)
+	if err != nil {
+		return nil, err
+	}
+	if burst <= 0 {
+		burst = count
+	}
+	if !validClientKey(key) {
+		return nil, fmt.Errorf("invalid -rate-key %q: must be ip, cert or header:<name>", key)
+	}
+	return &rateLimiter{perSecond: perSecond, burst: float64(burst), key: key, buckets: make(map[string]*bucket)}, nil
+}
+
+// clientKey names the client r is counted against.
+func (l *rateLimiter) clientKey(r *http.Request) string {
+	return identifyClient(r, l.key)
+}
+
+// validClientKey reports whether key is a way to tell clients apart: ip,
+// cert or header:<name>.
+func validClientKey(key string) bool {
+	return key == "ip" || key == "cert" || strings.HasPrefix(key, "header:") && len(key) > len("header:")
+}
+
+// identifyClient names the client that sent r by its IP, certificate or a
+// header, as key says. A request without the certificate or header asked
+// for is named by IP, so that leaving it out does not get round a limit.
+func identifyClient(r *http.Request, key string) string {
+	switch {
+	case key == "cert":
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			return "cert " + r.TLS.PeerCertificates[0].Subject.String()
+		}
+	case strings.HasPrefix(key, "header:"):
+		if value := r.Header.Get(strings.TrimPrefix(key, "header:")); value != "" {
+			return "header " + value
+		}
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return "ip " + ip
+}
+
+// allow takes a token from key's bucket, or returns how long until there
+// will be one.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Drop buckets that have refilled, as they are the same as new ones
+	if now.Sub(l.swept) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.last).Seconds()*l.perSecond >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.perSecond)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.perSecond * float64(time.Second))
+}
+
+// checkRate refuses, with 429 and Retry-After, a request from a client
+// over -rate-limit, and reports whether it may go ahead.
+func (p *ProxyServer) checkRate(w http.ResponseWriter, r *http.Request) bool {
+	if p.rateLimit == nil {
+		return true
+	}
+	key := p.rateLimit.clientKey(r)
+	ok, wait := p.rateLimit.allow(key, time.Now())
+	if ok {
+		return true
+	}
+	log.Printf("[LIMITED] %s %s%s from %s", r.Method, r.Host, r.URL.Path, key)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	for s, want := range map[string]float64{
+		"10/s":   10,
+		"600/m":  10,
+		"3600/h": 1,
+		"10":     0,
+		"0/s":    0,
+		"10/d":   0,
+		"x/s":    0,
+	} {
+		got, _, err := parseRate(s)
+		if want == 0 {
+			if err == nil {
+				t.Errorf("parseRate(%q) accepted", s)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("parseRate(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+}
+
+func TestRateLimiterBucket(t *testing.T) {
+	l, err := newRateLimiter("2/s", 3, "ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i := range 3 {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := l.allow("a", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("after the burst: allowed %v, wait %v; want refused for 500ms", ok, wait)
+	}
+	if ok, _ := l.allow("b", now); !ok {
+		t.Error("another client was refused")
+	}
+	if ok, _ := l.allow("a", now.Add(500*time.Millisecond)); !ok {
+		t.Error("refused after refilling")
+	}
+
+	l.allow("a", now.Add(2*time.Minute))
+	if _, ok := l.buckets["b"]; ok {
+		t.Error("full bucket not swept")
+	}
+}
+
+func TestRateLimiterKey(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	withCert := r.Clone(r.Context())
+	withCert.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "svc-a"}}}}
+	withHeader := r.Clone(r.Context())
+	withHeader.Header.Set("X-Client-Id", "svc-b")
+
+	for _, tc := range []struct {
+		key  string
+		r    *http.Request
+		want string
+	}{
+		{"ip", withCert, "ip 192.0.2.1"},
+		{"cert", withCert, "cert CN=svc-a"},
+		{"cert", r, "ip 192.0.2.1"},
+		{"header:X-Client-Id", withHeader, "header svc-b"},
+		{"header:X-Client-Id", r, "ip 192.0.2.1"},
+	} {
+		l, err := newRateLimiter("1/s", 0, tc.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := l.clientKey(tc.r); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.key, got, tc.want)
+		}
+	}
+
+	if _, err := newRateLimiter("1/s", 0, "header:"); err == nil {
+		t.Error("empty header name accepted")
+	}
+}
+
+func TestRateLimitedProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	limiter, err := newRateLimiter("1/m", 2, "ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(&ProxyServer{rateLimit: limiter})
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("request %d: got %d, want %d", i+1, resp.StatusCode, want)
+		}
+		if want == http.StatusTooManyRequests && resp.Header.Get("Retry-After") != "60" {
+			t.Errorf("Retry-After %q, want 60", resp.Header.Get("Retry-After"))
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// policy is what -config can change while the proxy runs: the routing
+// table, who may connect and what they may reach, and the listener's
+// certificate.
+type policy struct {
+	routes       []Route
+	routeByModel bool
+	allowClients []netip.Prefix
+	allowHosts   hostPatterns
+	denyHosts    hostPatterns
+	proxyAuth    *proxyAuth
+	listenerTLS  *tls.Config
+}
+
+// policy combines c with flags, the policy of the command line, for a
+// proxy in reverse mode or not. A setting comes from one or the other,
+// not both.
+func (c *Config) policy(flags policy, reverse bool) (policy, error) {
+	p := flags
+	if !reverse && (len(c.Routes) > 0 || c.HealthCheck != nil || c.Retry != nil || c.CircuitBreaker != nil || c.Usage != nil || c.Cache != nil) {
+		return p, fmt.Errorf("routes, health_check, retry, circuit_breaker, usage and cache need -mode reverse")
+	}
+	p.routes, p.routeByModel = c.Routes, c.needsModel()
+
+	conflict := func(flag, setting string) error {
+		return fmt.Errorf("give %s or %s, not both", flag, setting)
+	}
+	if a := c.Access; a != nil {
+		if reverse && (len(a.AllowHosts) > 0 || len(a.DenyHosts) > 0 || a.ProxyAuthFile != "") {
+			return p, fmt.Errorf("access: allow_hosts, deny_hosts and proxy_auth_file apply to forward mode")
+		}
+		if a.clients != nil {
+			if flags.allowClients != nil {
+				return p, conflict("-allow-clients", "access: allow_clients")
+			}
+			p.allowClients = a.clients
+		}
+		if len(a.allowHosts) > 0 {
+			if len(flags.allowHosts) > 0 {
+				return p, conflict("-allow-hosts", "access: allow_hosts")
+			}
+			p.allowHosts = a.allowHosts
+		}
+		if len(a.denyHosts) > 0 {
+			if len(flags.denyHosts) > 0 {
+				return p, conflict("-deny-hosts", "access: deny_hosts")
+			}
+			p.denyHosts = a.denyHosts
+		}
+		if a.proxyAuth != nil {
+			if flags.proxyAuth != nil {
+				return p, conflict("-proxy-auth-file", "access: proxy_auth_file")
+			}
+			p.proxyAuth = a.proxyAuth
+		}
+	}
+	if c.TLS != nil {
+		if flags.listenerTLS != nil {
+			return p, conflict("-tls-cert", "tls")
+		}
+		p.listenerTLS = c.TLS.config
+	}
+	return p, nil
+}
+
+// setPolicy puts pol into effect for the requests and connections that
+// start from now on. Those under way carry on as they started.
+func (p *ProxyServer) setPolicy(pol policy) {
+	p.policyMu.Lock()
+	defer p.policyMu.Unlock()
+	p.routes, p.routeByModel = pol.routes, pol.routeByModel
+	p.allowClients = pol.allowClients
+	p.allowHosts, p.denyHosts = pol.allowHosts, pol.denyHosts
+	p.proxyAuth = pol.proxyAuth
+	p.listenerTLS = pol.listenerTLS
+}
+
+// routeTable returns the reverse-mode routes, and whether any of them
+// looks at the model in request bodies.
+func (p *ProxyServer) routeTable() ([]Route, bool) {
+	p.policyMu.RLock()
+	defer p.policyMu.RUnlock()
+	return p.routes, p.routeByModel
+}
+
+// serverTLS is the TLS configuration of the proxy's listener. Each
+// handshake gets the certificate and client CA last loaded, with the
+// protocols the server offers.
+func (p *ProxyServer) serverTLS() *tls.Config {
+	server := &tls.Config{}
+	server.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		p.policyMu.RLock()
+		config := p.listenerTLS.Clone()
+		p.policyMu.RUnlock()
+		config.NextProtos = server.NextProtos
+		if !slices.Contains(config.NextProtos, "http/1.1") {
+			config.NextProtos = append(config.NextProtos, "http/1.1")
+		}
+		return config, nil
+	}
+	return server
+}
+
+// configReloader applies -config again when it changes or the proxy gets
+// SIGHUP. The routes, with their upstreams' credentials, and the access
+// and tls sections take effect for new requests and connections; the
+// rest only on restart. A config that does not load, or does not fit the
+// command line, is logged and the running one kept.
+type configReloader struct {
+	path    string
+	proxy   *ProxyServer
+	reverse bool
+	flags   policy  // the command line's
+	started *Config // the config the proxy started with
+
+	// Every backend of the routes gets a circuit breaker with breakers,
+	// and is health checked by checker, if they are set
+	breakers   *CircuitBreaker
+	checker    *healthChecker
+	stopChecks context.CancelFunc
+
+	mu      sync.Mutex // one reload at a time
+	current policy
+	version string // of the file as last read, by fileVersion
+}
+
+// apply puts pol into effect, with circuit breakers and health checks for
+// the backends of its routes. Backends drained on the admin listener stay
+// drained if their route still has them.
+func (r *configReloader) apply(pol policy) {
+	drained := r.proxy.drainedBackends()
+	for _, route := range pol.routes {
+		for _, b := range route.pool.backends {
+			if drained[describeRoute(route)+" "+b.url.String()] {
+				b.drained.Store(true)
+			}
+		}
+	}
+	r.proxy.setPolicy(pol)
+	r.current = pol
+	if r.breakers != nil {
+		r.proxy.addBreakers(*r.breakers)
+	}
+	if r.checker != nil {
+		if r.stopChecks != nil {
+			r.stopChecks()
+		}
+		var ctx context.Context
+		ctx, r.stopChecks = context.WithCancel(context.Background())
+		for _, named := range r.proxy.pools() {
+			for _, b := range named.pool.backends {
+				go r.checker.watch(ctx, b)
+			}
+		}
+	}
+}
+
+// reload reads the config file again and applies it.
+func (r *configReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.version = fileVersion(r.path)
+	config, err := loadConfig(r.path)
+	if err != nil {
+		return err
+	}
+	pol, err := config.policy(r.flags, r.reverse)
+	if err != nil {
+		return fmt.Errorf("%s: %w", r.path, err)
+	}
+	if r.reverse && len(pol.routes) == 0 && r.proxy.upstream == nil {
+		return fmt.Errorf("%s: no routes, and no -upstream", r.path)
+	}
+	if (pol.listenerTLS == nil) != (r.current.listenerTLS == nil) {
+		return fmt.Errorf("%s: switching the listener to or from TLS needs a restart", r.path)
+	}
+
+	r.apply(pol)
+	log.Printf("[RELOAD] Applied %s", r.path)
+	if config.TLS != nil && r.proxy.certs != nil {
+		certs, err := readCertSources(config.TLS.certSources()...)
+		if err != nil {
+			log.Printf("[RELOAD] Certificate expiry: %v", err)
+		} else {
+			r.proxy.certs.set("listener", certs)
+			r.proxy.certs.logExpiring(certs)
+		}
+	}
+	for _, route := range pol.routes {
+		log.Printf("[RELOAD] Route %s -> %s", describeRoute(route), describePool(route.pool))
+	}
+	if changed := r.restartOnly(config); len(changed) > 0 {
+		log.Printf("[RELOAD] Changes to %s take effect on restart", strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// restartOnly names the sections of config that differ from those the
+// proxy started with and that a reload does not apply.
+func (r *configReloader) restartOnly(config *Config) []string {
+	var changed []string
+	for _, section := range []struct {
+		name    string
+		was, is any
+	}{
+		{"health_check", r.started.HealthCheck, config.HealthCheck},
+		{"retry", r.started.Retry, config.Retry},
+		{"circuit_breaker", r.started.CircuitBreaker, config.CircuitBreaker},
+		{"usage", r.started.Usage, config.Usage},
+		{"cache", r.started.Cache, config.Cache},
+		{"upstream_tls", r.started.UpstreamTLS, config.UpstreamTLS},
+	} {
+		if !reflect.DeepEqual(section.was, section.is) {
+			changed = append(changed, section.name)
+		}
+	}
+	return changed
+}
+
+// watch reloads the config on each signal from hup, and when the file's
+// size or modification time changes, checked every interval (never if
+// zero), until ctx is done.
+func (r *configReloader) watch(ctx context.Context, hup <-chan os.Signal, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("[RELOAD] SIGHUP: reloading %s", r.path)
+		case <-tick:
+			if !r.changed() {
+				continue
+			}
+			log.Printf("[RELOAD] %s changed: reloading", r.path)
+		}
+		if err := r.reload(); err != nil {
+			log.Printf("[RELOAD] Keeping the running config: %v", err)
+			r.proxy.metrics.configReloaded("error")
+			continue
+		}
+		r.proxy.metrics.configReloaded("ok")
+	}
+}
+
+// changed reports whether the file has changed since it was last read.
+func (r *configReloader) changed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return fileVersion(r.path) != r.version
+}
+
+// fileVersion identifies the contents of the file at path by its size and
+// modification time, or is empty if it cannot be read.
+func fileVersion(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d %v", info.Size(), info.ModTime().UnixNano())
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startReloader starts a reverse proxy with the config at path, as main
+// does, returning it and its reloader.
+func startReloader(t *testing.T, path string) (*ProxyServer, *configReloader) {
+	t.Helper()
+	version := fileVersion(path)
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pol, err := config.policy(policy{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ProxyServer{reverse: newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false), metrics: newMetrics()}
+	r := &configReloader{path: path, proxy: p, reverse: true, started: config, breakers: config.CircuitBreaker, version: version}
+	r.apply(pol)
+	return p, r
+}
+
+func TestReload(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name+" "+r.Header.Get("OpenAI-Organization")+r.Header.Get("X-Debug"))
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	a, b := backend("a"), backend("b")
+
+	path := writeConfig(t, "routes:\n  - path: /v1\n    upstream: "+a.URL+"\n")
+	p, r := startReloader(t, path)
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+	get := func() string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/v1/models", nil)
+		req.Header.Set("X-Debug", "yes")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	if got := get(); got != "a yes" {
+		t.Fatalf("before reloading: got %q", got)
+	}
+
+	// A new routing table, with header rewrites, applies to the next
+	// request
+	os.WriteFile(path, []byte(`
+retry:
+  attempts: 2
+routes:
+  - path: /v1
+    upstream: `+b.URL+`
+    headers:
+      set:
+        OpenAI-Organization: org-123
+      remove:
+        - X-Debug
+`), 0o600)
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(); got != "b org-123" {
+		t.Errorf("after reloading: got %q, want b with the headers rewritten", got)
+	}
+	if changed := r.restartOnly(r.started); len(changed) != 0 {
+		t.Errorf("unchanged sections reported: %v", changed)
+	}
+	config, _ := loadConfig(path)
+	if changed := r.restartOnly(config); strings.Join(changed, ",") != "retry" {
+		t.Errorf("restart-only changes = %v, want retry", changed)
+	}
+
+	// A config that does not load, or leaves nothing to route to, is
+	// refused and the running one kept
+	for name, yaml := range map[string]string{
+		"bad yaml":     "routes: [",
+		"bad route":    "routes:\n  - path: v1\n    upstream: " + a.URL + "\n",
+		"no routes":    "routes: []\n",
+		"forward only": "access:\n  allow_hosts: [api.openai.com]\nroutes:\n  - path: /v1\n    upstream: " + a.URL + "\n",
+	} {
+		os.WriteFile(path, []byte(yaml), 0o600)
+		if err := r.reload(); err == nil {
+			t.Errorf("%s: reloaded", name)
+		}
+	}
+	if got := get(); got != "b org-123" {
+		t.Errorf("after failed reloads: got %q, want the running config kept", got)
+	}
+}
+
+func TestReloadWatch(t *testing.T) {
+	path := writeConfig(t, "routes:\n  - path: /v1\n    upstream: http://a.invalid\n")
+	p, r := startReloader(t, path)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hup := make(chan os.Signal, 1)
+	go r.watch(ctx, hup, 10*time.Millisecond)
+
+	upstreamOf := func() string {
+		routes, _ := p.routeTable()
+		return routes[0].pool.backends[0].url.Host
+	}
+	waitFor := func(host string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); upstreamOf() != host; time.Sleep(5 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("route still goes to %s, want %s", upstreamOf(), host)
+			}
+		}
+	}
+
+	// Changing the file reloads it
+	os.WriteFile(path, []byte("routes:\n  - path: /v1\n    upstream: http://bb.invalid\n"), 0o600)
+	waitFor("bb.invalid")
+
+	// So does SIGHUP, without a change
+	c, _ := parseUpstream("http://c.invalid")
+	p.setPolicy(policy{routes: []Route{{pool: singlePool(c)}}})
+	hup <- syscall.SIGHUP
+	waitFor("bb.invalid")
+
+	if body := scrape(t, p); !strings.Contains(body, `http_proxy_config_reloads_total{result="ok"} 2`) {
+		t.Errorf("reloads not counted:\n%s", body)
+	}
+}
+
+func TestConfigPolicy(t *testing.T) {
+	flags := policy{allowHosts: parseHostPatterns("api.openai.com")}
+	for name, tc := range map[string]struct {
+		yaml    string
+		reverse bool
+	}{
+		"routes in forward mode":       {"routes:\n  - path: /v1\n    upstream: http://a\n", false},
+		"allow_hosts in reverse mode":  {"access:\n  deny_hosts: [example.com]\n", true},
+		"allow_hosts and -allow-hosts": {"access:\n  allow_hosts: [example.com]\n", false},
+	} {
+		config, err := loadConfig(writeConfig(t, tc.yaml))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := config.policy(flags, tc.reverse); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	config, err := loadConfig(writeConfig(t, "access:\n  allow_clients: [10.0.0.0/8]\n  deny_hosts: [metadata.google.internal]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pol, err := config.policy(flags, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pol.allowClients) != 1 || strings.Join(pol.allowHosts, ",") != "api.openai.com" || strings.Join(pol.denyHosts, ",") != "metadata.google.internal" {
+		t.Errorf("got %+v, want the flag's allow_hosts with the file's clients and deny_hosts", pol)
+	}
+}
+
+func TestReloadListenerTLS(t *testing.T) {
+	first, second := newTestPKI(t), newTestPKI(t)
+	path := writeConfig(t, "tls:\n  cert: "+first.serverCertFile+"\n  key: "+first.serverKeyFile+"\n")
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pol, err := config.policy(policy{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ProxyServer{}
+	r := &configReloader{path: path, proxy: p, started: config}
+	r.apply(pol)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = p.serverTLS()
+	server.StartTLS()
+	defer server.Close()
+	handshake := func(pki *testPKI) error {
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{RootCAs: pki.pool})
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	if err := handshake(first); err != nil {
+		t.Fatalf("first certificate: %v", err)
+	}
+
+	// New connections get the new certificate
+	os.WriteFile(path, []byte("tls:\n  cert: "+second.serverCertFile+"\n  key: "+second.serverKeyFile+"\n"), 0o600)
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(second); err != nil {
+		t.Errorf("second certificate: %v", err)
+	}
+	if handshake(first) == nil {
+		t.Error("still serving the first certificate")
+	}
+
+	// The listener cannot be switched to plain HTTP
+	os.WriteFile(path, []byte("access:\n  allow_clients: [127.0.0.1]\n"), 0o600)
+	if err := r.reload(); err == nil {
+		t.Error("reloaded without tls")
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"mtls"
+)
+
+// Client certificates presented upstream may be short-lived, lasting
+// hours. Two thirds of the way through its life, a certificate is renewed:
+// requested from a CA service, such as the mock server's POST /ca/sign,
+// given by -upstream-renew-url or renew_url, or else read again from its
+// files, which something else renews. The new certificate is presented
+// from the next handshake, through GetClientCertificate, so the proxy
+// needs no restart.
+
+// renewRetry is how long the renewer waits after each attempt, so a
+// failed renewal, or files not yet renewed, are tried again a minute on.
+const renewRetry = time.Minute
+
+// renewalTime returns when leaf is due to be renewed: two thirds of the
+// way through its life.
+func renewalTime(leaf *x509.Certificate) time.Time {
+	return leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) * 2 / 3)
+}
+
+// generateKeyLike generates a key of the same type and size as pub.
+func generateKeyLike(pub crypto.PublicKey) (crypto.Signer, error) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	case *rsa.PublicKey:
+		return rsa.GenerateKey(rand.Reader, pub.N.BitLen())
+	case ed25519.PublicKey:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unsupported key type %T", pub)
+}
+
+// requestCertificate generates a key like that of leaf and asks the CA
+// service at url, through client, for a certificate for it with the
+// subject and names of leaf. It returns the new identity, with any chain
+// the service sent.
+func requestCertificate(client *http.Client, url string, leaf *x509.Certificate) (*tls.Certificate, error) {
+	key, err := generateKeyLike(leaf.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:        leaf.Subject,
+		DNSNames:       leaf.DNSNames,
+		EmailAddresses: leaf.EmailAddresses,
+		IPAddresses:    leaf.IPAddresses,
+		URIs:           leaf.URIs,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Post(url, "application/x-pem-file", bytes.NewReader(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s: %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	cert := &tls.Certificate{PrivateKey: key}
+	for block, rest := pem.Decode(body); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("%s sent no certificate", url)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(cert.Leaf.PublicKey) {
+		return nil, fmt.Errorf("%s sent a certificate for another key", url)
+	}
+	return cert, nil
+}
+
+// certRenewer presents a client certificate through GetClientCertificate
+// and renews it when it is due.
+type certRenewer struct {
+	use                        string // what the certificate is for, as the expiry monitor has it
+	certFile, keyFile, keyPass string
+	url                        string       // of the CA service, or "" to read the files again
+	client                     *http.Client // reaches url, presenting the current certificate
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+	leaf *x509.Certificate
+}
+
+// newCertRenewer makes config, which has the client certificate loaded
+// from certFile and keyFile, present the certificate renewed from url, or
+// read again from the files if url is empty. The CA service is verified
+// with the roots of config.
+func newCertRenewer(use string, config *tls.Config, certFile, keyFile, keyPass, url string) (*certRenewer, error) {
+	if len(config.Certificates) == 0 {
+		return nil, errors.New("there is no client certificate to renew")
+	}
+	cert := config.Certificates[0]
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	r := &certRenewer{use: use, certFile: certFile, keyFile: keyFile, keyPass: keyPass, url: url, cert: &cert, leaf: leaf}
+	if url != "" {
+		// Each renewal connects afresh, to present the current certificate
+		r.client = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:              config.RootCAs,
+					GetClientCertificate: r.GetClientCertificate,
+					MinVersion:           tls.VersionTLS12,
+				},
+				DisableKeepAlives: true,
+			},
+			Timeout: 30 * time.Second,
+		}
+	}
+	config.GetClientCertificate = r.GetClientCertificate
+	return r, nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate. Like a
+// static tls.Config.Certificates, it sends no certificate if the server
+// does not accept the current one.
+func (r *certRenewer) GetClientCertificate(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	cert := r.cert
+	r.mu.Unlock()
+	if err := cri.SupportsCertificate(cert); err != nil {
+		return &tls.Certificate{}, nil
+	}
+	return cert, nil
+}
+
+// current returns the certificate presented now.
+func (r *certRenewer) current() *x509.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leaf
+}
+
+// renew replaces the certificate with one from the CA service, or from
+// the files if they hold one that expires later; the CA service may issue
+// certificates shorter-lived than the one first loaded. It reports
+// whether it did.
+func (r *certRenewer) renew() (bool, error) {
+	var cert *tls.Certificate
+	if r.url != "" {
+		renewed, err := requestCertificate(r.client, r.url, r.current())
+		if err != nil {
+			return false, err
+		}
+		cert = renewed
+	} else {
+		loaded, err := mtls.LoadKeyPair(r.certFile, r.keyFile, r.keyPass)
+		if err != nil {
+			return false, err
+		}
+		cert = &loaded
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.url == "" && !leaf.NotAfter.After(r.leaf.NotAfter) {
+		return false, nil
+	}
+	r.cert, r.leaf = cert, leaf
+	return true, nil
+}
+
+// expiry describes the certificates presented now, for the expiry
+// monitor.
+func (r *certRenewer) expiry() []mtls.CertExpiry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	source := r.certFile
+	if r.url != "" {
+		source = r.url
+	}
+	certs, _ := mtls.CertExpiries(r.use, source, r.cert.Certificate)
+	return certs
+}
+
+// run renews the certificate whenever it is due, for as long as the proxy
+// runs, and updates monitor with each it renews.
+func (r *certRenewer) run(monitor *certMonitor) {
+	for {
+		time.Sleep(time.Until(renewalTime(r.current())))
+		renewed, err := r.renew()
+		switch {
+		case err != nil:
+			leaf := r.current()
+			log.Printf("[CERT] Failed to renew the %s certificate %s, which expires %s: %v", r.use, leaf.Subject, leaf.NotAfter.UTC().Format(time.RFC3339), err)
+		case renewed:
+			leaf := r.current()
+			log.Printf("[CERT] Renewed the %s certificate %s (serial %s) until %s", r.use, leaf.Subject, leaf.SerialNumber.Text(16), leaf.NotAfter.UTC().Format(time.RFC3339))
+			monitor.set(r.use, r.expiry())
+		}
+		time.Sleep(renewRetry)
+	}
+}
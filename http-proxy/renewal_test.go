@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestCAService starts a CA service like the mock server's, which
+// signs CSRs from clients of pki for ten minutes, and records who asked.
+func newTestCAService(t *testing.T, pki *testPKI) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var callers []string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		block, _ := pem.Decode(body)
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil || csr.CheckSignature() != nil {
+			http.Error(w, "bad CSR", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		callers = append(callers, r.TLS.PeerCertificates[0].SerialNumber.String())
+		serial := big.NewInt(int64(100 + len(callers)))
+		mu.Unlock()
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: serial,
+			Subject:      csr.Subject,
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(10 * time.Minute),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, pki.ca, csr.PublicKey, pki.caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{pki.server}, ClientCAs: pki.pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), callers...)
+	}
+}
+
+func TestCertRenewer(t *testing.T) {
+	pki := newTestPKI(t)
+	srv, callers := newTestCAService(t, pki)
+
+	config, err := loadUpstreamTLS(pki.clientCertFile, pki.clientKeyFile, "", pki.caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newCertRenewer("upstream", config, pki.clientCertFile, pki.clientKeyFile, "", srv.URL+"/ca/sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	presented := func() *x509.Certificate {
+		cert, err := config.GetClientCertificate(&tls.CertificateRequestInfo{Version: tls.VersionTLS13, SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256}})
+		if err != nil || len(cert.Certificate) == 0 {
+			t.Fatalf("no certificate presented: %v", err)
+		}
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		return leaf
+	}
+	if serial := presented().SerialNumber.Int64(); serial != 3 {
+		t.Fatalf("presented serial %d before renewal, want 3", serial)
+	}
+
+	// Each renewal presents the certificate it replaces
+	for i, want := range []int64{101, 102} {
+		renewed, err := r.renew()
+		if err != nil || !renewed {
+			t.Fatalf("renewal %d: %v, %v", i+1, renewed, err)
+		}
+		leaf := presented()
+		if leaf.SerialNumber.Int64() != want || leaf.Subject.CommonName != "proxy-client" {
+			t.Errorf("renewal %d presents %s serial %d, want serial %d", i+1, leaf.Subject, leaf.SerialNumber, want)
+		}
+	}
+	if got := callers(); len(got) != 2 || got[0] != "3" || got[1] != "101" {
+		t.Errorf("renewals requested with serials %v, want [3 101]", got)
+	}
+	if certs := r.expiry(); len(certs) != 1 || certs[0].Serial != "66" || certs[0].File != srv.URL+"/ca/sign" {
+		t.Errorf("expiry: %+v", certs)
+	}
+
+	// Without a CA service the files are read again, and a certificate
+	// taken from them only if it expires later
+	short, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(50),
+		Subject:      pkix.Name{CommonName: "short-lived"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(5 * time.Minute),
+	}, pki.ca, pki.client.PrivateKey.(*ecdsa.PrivateKey).Public(), pki.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{short}, PrivateKey: pki.client.PrivateKey}}}
+	if r, err = newCertRenewer("per_host 1", config, pki.clientCertFile, pki.clientKeyFile, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false} {
+		if renewed, err := r.renew(); err != nil || renewed != want {
+			t.Errorf("reading the files %d: %v, %v, want %v", i+1, renewed, err, want)
+		}
+	}
+	if leaf := presented(); leaf.Subject.CommonName != "proxy-client" {
+		t.Errorf("presents %s after reading the files", leaf.Subject)
+	}
+
+	if _, err := newCertRenewer("upstream", &tls.Config{}, "", "", "", srv.URL); err == nil {
+		t.Error("renewer made without a certificate")
+	}
+}
+
+func TestRenewalTime(t *testing.T) {
+	start := time.Date(2026, time.October, 16, 9, 0, 0, 0, time.UTC)
+	leaf := &x509.Certificate{NotBefore: start, NotAfter: start.Add(3 * time.Hour)}
+	if got, want := renewalTime(leaf), start.Add(2*time.Hour); !got.Equal(want) {
+		t.Errorf("renewalTime = %v, want %v", got, want)
+	}
+
+	// A renewed key is of the same type as the one it replaces
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if key, err := generateKeyLike(p384.Public()); err != nil || key.(*ecdsa.PrivateKey).Curve != elliptic.P384() {
+		t.Errorf("key like P-384: %T, %v", key, err)
+	}
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	if key, err := generateKeyLike(pub); err != nil {
+		t.Error(err)
+	} else if _, ok := key.(ed25519.PrivateKey); !ok {
+		t.Errorf("key like Ed25519: %T", key)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/rand"
+	"net/http"
+)
+
+// requestIDHeader carries a request's ID to the upstream, and back to the
+// client on the response unless the upstream gives an ID of its own.
+const requestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength is the longest ID taken from a client.
+const maxRequestIDLength = 128
+
+// setRequestID gives r, and rec, the ID the client sent in X-Request-Id,
+// or a new one if it sent none that will do, so the request can be
+// followed through the proxy's logs and the upstream's.
+func setRequestID(r *http.Request, rec *accessRecord) {
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID(id) || len(r.Header.Values(requestIDHeader)) > 1 {
+		id = rand.Text()
+		r.Header.Set(requestIDHeader, id)
+	}
+	rec.RequestID = id
+}
+
+// validRequestID reports whether id, from a client, is fit to log and
+// send on: not too long, and printable ASCII without spaces.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// upstreamRequestID notes in rec the ID the upstream gave the request in
+// header, its response's, if it is not the one the proxy sent.
+func upstreamRequestID(rec *accessRecord, header http.Header) {
+	if id := header.Get(requestIDHeader); id != "" && id != rec.RequestID {
+		rec.UpstreamRequestID = id
+	}
+}
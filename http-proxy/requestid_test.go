@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/own" {
+			w.Header().Set("X-Request-Id", "req_upstream")
+		}
+		io.WriteString(w, r.Header.Get("X-Request-Id"))
+	}))
+	defer upstream.Close()
+	target, _ := parseUpstream(upstream.URL)
+	out := make(lines, 10)
+	accessLog, _ := newAccessLogger("json", out)
+	proxy := httptest.NewServer(&ProxyServer{
+		reverse:   newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false),
+		upstream:  singlePool(target),
+		accessLog: accessLog,
+	})
+	defer proxy.Close()
+
+	get := func(path string, ids ...string) (sent, returned string, rec *accessRecord) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL+path, nil)
+		for _, id := range ids {
+			req.Header.Add("X-Request-Id", id)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		rec = &accessRecord{}
+		if err := json.Unmarshal([]byte(out.next(t)), rec); err != nil {
+			t.Fatal(err)
+		}
+		return string(body), resp.Header.Get("X-Request-Id"), rec
+	}
+
+	// A new ID, sent upstream, returned and logged
+	sent, returned, rec := get("/v1/models")
+	if len(sent) != 26 || returned != sent || rec.RequestID != sent {
+		t.Errorf("new ID: upstream got %q, client %q, logged %q", sent, returned, rec.RequestID)
+	}
+	// The client's, if it will do
+	if sent, returned, rec := get("/v1/models", "ci-run-42"); sent != "ci-run-42" || returned != sent || rec.RequestID != sent {
+		t.Errorf("client's ID: upstream got %q, client %q, logged %q", sent, returned, rec.RequestID)
+	}
+	for _, bad := range [][]string{{"has spaces"}, {strings.Repeat("x", 129)}, {"a", "b"}} {
+		if sent, returned, _ := get("/v1/models", bad...); sent == bad[0] || returned != sent {
+			t.Errorf("client's ID %q: upstream got %q, client %q", bad, sent, returned)
+		}
+	}
+
+	// The upstream's own goes back to the client, and both are logged
+	sent, returned, rec = get("/v1/own", "ci-run-43")
+	if sent != "ci-run-43" || returned != "req_upstream" || rec.RequestID != "ci-run-43" || rec.UpstreamRequestID != "req_upstream" {
+		t.Errorf("upstream's ID: client got %q, logged %+v", returned, rec)
+	}
+
+	// Errors the proxy makes carry it too
+	upstream.Close()
+	if _, returned, rec := get("/v1/models", "ci-run-44"); returned != "ci-run-44" || rec.Status != http.StatusBadGateway {
+		t.Errorf("proxy error: got ID %q, status %d", returned, rec.Status)
+	}
+}
+
+func TestRequestIDForward(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("X-Request-Id"))
+	}))
+	defer upstream.Close()
+	proxy := httptest.NewServer(&ProxyServer{})
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if len(body) == 0 || resp.Header.Get("X-Request-Id") != string(body) {
+		t.Errorf("upstream got ID %q, client %q", body, resp.Header.Get("X-Request-Id"))
+	}
+}
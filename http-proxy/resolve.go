@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// resolveRule sends connections to hosts matching pattern to addr
+// instead.
+type resolveRule struct {
+	pattern hostPatterns
+	addr    string // host or IP, with or without a port, or unix:<path>
+}
+
+// resolveOverrides are the static DNS overrides of -resolve, in the order
+// given. A nil resolveOverrides changes nothing.
+type resolveOverrides []resolveRule
+
+// parseResolve parses -resolve: comma-separated host=address pairs, such
+// as api.openai.com=10.0.0.5:8443. The host is a pattern as for
+// -allow-hosts, and the address keeps the port connected to if it has
+// none of its own. An address of unix:<path> is a Unix socket.
+func parseResolve(list string) (resolveOverrides, error) {
+	var rules resolveOverrides
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, addr, ok := strings.Cut(entry, "=")
+		host, addr = strings.TrimSpace(host), strings.TrimSpace(addr)
+		if !ok || host == "" || addr == "" {
+			return nil, fmt.Errorf("entry %q: want host=address", entry)
+		}
+		if path, ok := unixSocket(addr); ok {
+			if path == "" {
+				return nil, fmt.Errorf("address %q has no socket path", addr)
+			}
+		} else if h, port, err := net.SplitHostPort(addr); err == nil && (h == "" || port == "") {
+			return nil, fmt.Errorf("address %q has no host or port", addr)
+		}
+		rules = append(rules, resolveRule{pattern: parseHostPatterns(host), addr: addr})
+	}
+	return rules, nil
+}
+
+// apply returns the address to connect to in place of addr, a host and
+// port, and whether an override changed it.
+func (rules resolveOverrides) apply(addr string) (string, bool) {
+	for _, rule := range rules {
+		if !rule.pattern.match(addr) {
+			continue
+		}
+		if _, ok := unixSocket(rule.addr); ok {
+			return rule.addr, true
+		}
+		if _, _, err := net.SplitHostPort(rule.addr); err == nil {
+			return rule.addr, true
+		}
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return addr, false
+		}
+		return net.JoinHostPort(strings.Trim(rule.addr, "[]"), port), true
+	}
+	return addr, false
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseResolve(t *testing.T) {
+	rules, err := parseResolve("api.openai.com=10.0.0.5:8443, *.azure.com=10.0.0.6, api.anthropic.com:443=[::1], vllm.internal=unix:/run/vllm.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]string{
+		"api.openai.com:443":     "10.0.0.5:8443",
+		"API.OpenAI.com:80":      "10.0.0.5:8443",
+		"x.openai.azure.com:443": "10.0.0.6:443",
+		"api.anthropic.com:443":  "[::1]:443",
+		"api.anthropic.com:80":   "api.anthropic.com:80",
+		"vllm.internal:80":       "unix:/run/vllm.sock",
+		"example.com:443":        "example.com:443",
+	} {
+		got, changed := rules.apply(addr)
+		if got != want || changed != (got != addr) {
+			t.Errorf("apply(%q) = %q, %v; want %q", addr, got, changed, want)
+		}
+	}
+
+	var none resolveOverrides
+	if got, changed := none.apply("api.openai.com:443"); changed || got != "api.openai.com:443" {
+		t.Errorf("no overrides changed the address to %q", got)
+	}
+
+	for _, bad := range []string{"api.openai.com", "=10.0.0.5", "api.openai.com=", "api.openai.com=:443", "api.openai.com=10.0.0.5:", "api.openai.com=unix:"} {
+		if _, err := parseResolve(bad); err == nil {
+			t.Errorf("parseResolve(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "mock for "+r.Host)
+	}))
+	defer mock.Close()
+	tlsMock := httptest.NewTLSServer(mock.Config.Handler)
+	defer tlsMock.Close()
+
+	// Plain requests and CONNECT tunnels for a name that does not resolve
+	// reach the mock it is mapped to, with the Host the client asked for
+	rules, err := parseResolve("plain.openai.invalid=" + strings.TrimPrefix(mock.URL, "http://") + ",tls.openai.invalid=" + strings.TrimPrefix(tlsMock.URL, "https://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(&ProxyServer{resolve: rules})
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	transport := tlsMock.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	transport.TLSClientConfig.ServerName = "example.com"
+	for target, want := range map[string]string{
+		"http://plain.openai.invalid/v1/models": "mock for plain.openai.invalid",
+		"https://tls.openai.invalid/v1/models":  "mock for tls.openai.invalid",
+	} {
+		resp, err := (&http.Client{Transport: transport}).Get(target)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("%s: got %d %q, want %q", target, resp.StatusCode, body, want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// retryBurst is how many retries the budget allows before any requests
+// have paid for them.
+const retryBurst = 10
+
+// maxReplayBody caps the request bodies held in memory so that they can be
+// sent again. Larger ones, such as audio uploads, are streamed upstream
+// once and not retried.
+const maxReplayBody = 8 << 20
+
+// RetryPolicy says when a reverse-proxied request that failed is tried
+// again, on whichever backend its route's pool picks next.
+type RetryPolicy struct {
+	// Attempts is the most times a request is sent, the first included
+	Attempts int `yaml:"attempts"`
+
+	// Backoff is the wait before the first retry, doubled for each one
+	// after up to MaxBackoff. A 429 or 503 with a longer Retry-After is
+	// passed back to the client instead.
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+
+	// Budget is the share of requests that may be retried, so that retries
+	// cannot multiply the load on an upstream that is already struggling
+	Budget float64 `yaml:"budget"`
+
+	// Posts also retries POSTs, such as completions, that got no response
+	// or a 429. A POST whose connection failed after it was sent may have
+	// been processed, and billed, already.
+	Posts bool `yaml:"posts"`
+}
+
+// setDefaults checks p and fills in what it leaves out.
+func (p *RetryPolicy) setDefaults() error {
+	if p.Attempts == 0 {
+		p.Attempts = 3
+	}
+	if p.Attempts < 1 {
+		return fmt.Errorf("attempts must be at least 1")
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = 100 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 5 * time.Second
+	}
+	if p.Budget == 0 {
+		p.Budget = 0.2
+	}
+	if p.Budget < 0 || p.Budget > 1 {
+		return fmt.Errorf("budget %v must be between 0 and 1", p.Budget)
+	}
+	return nil
+}
+
+// idempotent reports whether r can be sent twice without harm: by its
+// method, or because it carries an idempotency key, as net/http assumes.
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != "" || r.Header.Get("X-Idempotency-Key") != ""
+}
+
+// retryBudget is a token bucket: each request adds the budget ratio, up
+// to retryBurst, and each retry takes one.
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+b.ratio, retryBurst)
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// retryTransport retries the requests of newReverseProxy by RetryPolicy,
+// moving them to the backend their pool picks next. Failures of all but
+// the last attempt are counted against their backends here; the last is
+// left to the reverse proxy.
+type retryTransport struct {
+	base    http.RoundTripper
+	policy  RetryPolicy
+	budget  *retryBudget
+	metrics *metrics
+	verbose bool
+}
+
+func newRetryTransport(base http.RoundTripper, policy RetryPolicy, verbose bool) *retryTransport {
+	return &retryTransport{
+		base:    base,
+		policy:  policy,
+		budget:  &retryBudget{ratio: policy.Budget, tokens: retryBurst},
+		verbose: verbose,
+	}
+}
+
+func (rt *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.budget.deposit()
+	safe := idempotent(req)
+	if !safe && !(rt.policy.Posts && req.Method == http.MethodPost) {
+		return rt.base.RoundTrip(req)
+	}
+	if !replayable(req) {
+		return rt.base.RoundTrip(req)
+	}
+
+	t := targetOf(req)
+	for attempt := 1; ; attempt++ {
+		resp, err := rt.base.RoundTrip(req)
+		if attempt == rt.policy.Attempts {
+			return resp, err
+		}
+
+		switch {
+		case err != nil:
+			// No response: the request may or may not have been sent
+		case resp.StatusCode == http.StatusTooManyRequests:
+			// Refused before being processed
+		case safe && (resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout):
+		default:
+			return resp, err
+		}
+		wait := rt.backoff(attempt, resp)
+		if wait < 0 {
+			// Retry-After asks for longer than we wait
+			return resp, err
+		}
+		if !rt.budget.withdraw() {
+			if rt.verbose {
+				log.Printf("[RETRY] Budget spent; not retrying %s %s", req.Method, req.URL.Path)
+			}
+			return resp, err
+		}
+		next := t.pool.pick()
+		if next == nil {
+			// Every circuit breaker is open
+			return resp, err
+		}
+
+		var reason string
+		if err != nil {
+			markFailed(t)
+			reason = err.Error()
+		} else {
+			if resp.StatusCode == http.StatusTooManyRequests {
+				t.pool.succeeded(t.backend)
+			} else {
+				markFailed(t)
+			}
+			reason = resp.Status
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		rt.metrics.retry(t.backend.url.Host)
+		t.backend.inFlight.Add(-1)
+		next.inFlight.Add(1)
+		log.Printf("[RETRY] %s %s to %s failed (%s); retrying in %v (attempt %d of %d)", req.Method, req.URL.Path, t.backend.url.Host, reason, wait, attempt+1, rt.policy.Attempts)
+		t.backend = next
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		if req, err = retarget(req, t); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff returns how long to wait before retrying after attempt, from
+// the response's Retry-After if it has one, or -1 if that is longer than
+// MaxBackoff.
+func (rt *retryTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			if after > rt.policy.MaxBackoff {
+				return -1
+			}
+			return after
+		}
+	}
+	wait := rt.policy.Backoff
+	for i := 1; i < attempt && wait < rt.policy.MaxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, rt.policy.MaxBackoff)
+	// Jitter, so that clients that failed together do not retry together
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// retryAfter parses a Retry-After header: seconds or an HTTP date.
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		return max(time.Until(when), 0), true
+	}
+	return 0, false
+}
+
+// replayable makes sure req's body can be sent again, reading it into
+// memory if it is no more than maxReplayBody, and reports whether it can.
+func replayable(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return true
+	}
+	if req.ContentLength > maxReplayBody {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxReplayBody+1))
+	if err != nil || len(body) > maxReplayBody {
+		// Too big to hold; send what was read and the rest once
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return false
+	}
+	req.Body.Close()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return true
+}
+
+// retarget copies req for its next attempt, at t's backend.
+func retarget(req *http.Request, t *target) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		next.Body = body
+	}
+	t.direct(next)
+	return next, nil
+}
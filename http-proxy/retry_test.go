@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// retryProxy serves a route balancing over upstreams, retrying by policy.
+func retryProxy(t *testing.T, policy RetryPolicy, upstreams ...string) *httptest.Server {
+	t.Helper()
+	var config strings.Builder
+	config.WriteString("retry:\n  backoff: 1ms\n  max_backoff: 1s\n")
+	if policy.Attempts != 0 {
+		fmt.Fprintf(&config, "  attempts: %d\n", policy.Attempts)
+	}
+	if policy.Posts {
+		config.WriteString("  posts: true\n")
+	}
+	config.WriteString("routes:\n  - path: /\n    max_fails: 100\n    upstreams:\n")
+	for _, u := range upstreams {
+		config.WriteString("      - url: " + u + "\n")
+	}
+
+	loaded, err := loadConfig(writeConfig(t, config.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &ProxyServer{reverse: newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false), routes: loaded.Routes}
+	proxy.reverse.Transport = newRetryTransport(proxy.reverse.Transport, *loaded.Retry, false)
+	server := httptest.NewServer(proxy)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRetryNextBackend(t *testing.T) {
+	var failing, working atomic.Int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failing.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		working.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		io.WriteString(w, r.Method+" "+r.URL.Path+" "+string(body))
+	}))
+	defer good.Close()
+
+	proxy := retryProxy(t, RetryPolicy{}, bad.URL, good.URL+"/base")
+
+	resp, err := http.Get(proxy.URL + "/v1/models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "GET /base/v1/models " {
+		t.Errorf("GET: got %d %q, want it retried on the working backend", resp.StatusCode, body)
+	}
+	if failing.Load() != 1 || working.Load() != 1 {
+		t.Errorf("GET: %d failing and %d working attempts, want 1 each", failing.Load(), working.Load())
+	}
+
+	// A POST that got a 503 may have been processed, so is not retried
+	// even with posts set
+	failing.Store(0)
+	proxy = retryProxy(t, RetryPolicy{Posts: true}, bad.URL, good.URL)
+	resp, err = http.Post(proxy.URL+"/v1/chat/completions", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || failing.Load() != 1 {
+		t.Errorf("POST: got %d after %d attempts, want the 503 once", resp.StatusCode, failing.Load())
+	}
+}
+
+func TestRetryPost(t *testing.T) {
+	var limited atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limited.Swap(true) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	for _, posts := range []bool{false, true} {
+		limited.Store(false)
+		proxy := retryProxy(t, RetryPolicy{Posts: posts}, upstream.URL)
+		resp, err := http.Post(proxy.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"gpt-4"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		want, wantBody := http.StatusTooManyRequests, ""
+		if posts {
+			want, wantBody = http.StatusOK, `{"model":"gpt-4"}`
+		}
+		if resp.StatusCode != want || string(body) != wantBody {
+			t.Errorf("posts %v: got %d %q, want %d %q", posts, resp.StatusCode, body, want, wantBody)
+		}
+	}
+}
+
+func TestRetryAttempts(t *testing.T) {
+	var attempts atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		if r.URL.Path == "/long" {
+			w.Header().Set("Retry-After", "60")
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+	proxy := retryProxy(t, RetryPolicy{Attempts: 4}, upstream.URL)
+
+	for path, want := range map[string]int32{"/short": 4, "/long": 1} {
+		attempts.Store(0)
+		resp, err := http.Get(proxy.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable || attempts.Load() != want {
+			t.Errorf("%s: got %d after %d attempts, want 503 after %d", path, resp.StatusCode, attempts.Load(), want)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := &retryBudget{ratio: 0.5, tokens: 2}
+	if !b.withdraw() || !b.withdraw() || b.withdraw() {
+		t.Fatal("budget of 2 did not allow exactly 2 retries")
+	}
+	b.deposit()
+	if b.withdraw() {
+		t.Error("retry allowed after half a request's worth")
+	}
+	b.deposit()
+	if !b.withdraw() {
+		t.Error("retry refused after two requests at ratio 0.5")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"":    -1,
+		"3":   3 * time.Second,
+		"-1":  -1,
+		"abc": -1,
+		time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat): 0,
+	} {
+		got, ok := retryAfter(value)
+		if !ok {
+			got = -1
+		}
+		if got != want {
+			t.Errorf("retryAfter(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
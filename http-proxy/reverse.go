@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseUpstream checks the -upstream URL of reverse mode.
+func parseUpstream(raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("-mode reverse needs -upstream (e.g. https://api.openai.com)")
+	}
+	upstream, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -upstream: %w", err)
+	}
+	if (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+		return nil, fmt.Errorf("invalid -upstream %q: must be an http or https URL", raw)
+	}
+	return upstream, nil
+}
+
+// targetKey is the context key for the upstream chosen for a request.
+type targetKey struct{}
+
+// target is the backend chosen for a request and the pool it is from,
+// with the request URL as the client sent it, and the model and body, if
+// they were read. A retry or failover may move the request to another
+// backend.
+type target struct {
+	pool    *pool
+	backend *backend
+	in      *url.URL
+	model   string
+	body    []byte
+
+	// credentials are the Authorization and Api-Key headers as the
+	// client, or -api-key, gave them, before any backend replaced them
+	credentials http.Header
+}
+
+// direct points out, a copy of the request, at t's backend: at its URL,
+// in its dialect, with its model name and credentials.
+func (t *target) direct(out *http.Request) {
+	b := t.backend
+	in := t.in
+	if b.azure != nil {
+		in = b.azure.translate(in, t.model)
+	}
+	out.URL = in
+	(&httputil.ProxyRequest{Out: out}).SetURL(b.url)
+	t.pool.headers.apply(out.Header)
+
+	if t.body != nil {
+		// Each backend gets the body as the client sent it, or renamed
+		body := t.body
+		if rename := b.rewriteModel[t.model]; rename != "" {
+			if renamed, err := setModel(body, rename); err == nil {
+				body = renamed
+			}
+		}
+		replaceBody(out, body)
+	}
+
+	if t.credentials == nil {
+		t.credentials = http.Header{}
+		for _, name := range []string{"Authorization", "Api-Key"} {
+			if values := out.Header.Values(name); len(values) > 0 {
+				t.credentials[name] = values
+			}
+		}
+	}
+	out.Header.Del("Authorization")
+	out.Header.Del("Api-Key")
+	for name, values := range t.credentials {
+		out.Header[name] = values
+	}
+	b.apiKey.apply(out.Header, out.URL.Host)
+	if b.azure != nil {
+		azureCredentials(out.Header)
+	}
+}
+
+// targetOf returns the target serveReverse chose for r.
+func targetOf(r *http.Request) *target {
+	return r.Context().Value(targetKey{}).(*target)
+}
+
+// serveReverse forwards r to the upstream of the first route matching it,
+// or to -upstream if none does. If a route looks at models, the body of a
+// request naming one is read first, and the model renamed if the route
+// says so; so is a completion's if it is to be made to report its usage,
+// that of a request the cache may answer, and that of a request whose
+// model the backends need, to pick an Azure deployment or rename it.
+func (p *ProxyServer) serveReverse(w http.ResponseWriter, r *http.Request) {
+	var body []byte
+	var model string
+	routes, routeByModel := p.routeTable()
+	streamUsage := p.usage.streamsUsage() && hasCompletion(r) && !strings.HasSuffix(r.URL.Path, "/responses")
+	cached := p.cache.matches(r)
+	if (routeByModel || streamUsage) && hasModelBody(r) || cached {
+		var err error
+		if body, model, err = readModel(r); err != nil {
+			refuseBody(w, r, err)
+			return
+		}
+	}
+	if cached {
+		var store func()
+		var hit bool
+		if w, store, hit = p.cache.serve(w, r, body); hit {
+			if p.verbose {
+				log.Printf("[CACHE] Hit for %s %s", r.Method, r.URL.Path)
+			}
+			return
+		}
+		defer store()
+	}
+
+	upstreams := p.upstream
+	for _, route := range routes {
+		if !route.matches(r.URL.Path, model) {
+			continue
+		}
+		upstreams = route.pool
+		if rename := route.RewriteModel[model]; rename != "" {
+			rewritten, err := setModel(body, rename)
+			if err != nil {
+				http.Error(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			replaceBody(r, rewritten)
+			body = rewritten
+			if p.verbose {
+				log.Printf("[ROUTE] Model %s renamed %s", model, rename)
+			}
+			model = rename
+		}
+		break
+	}
+	if streamUsage {
+		// Bodies that are not JSON are left for the upstream to refuse
+		if rewritten, _ := includeStreamUsage(body); rewritten != nil {
+			replaceBody(r, rewritten)
+			body = rewritten
+		}
+	}
+	if upstreams == nil {
+		log.Printf("[ERROR] No route for %s %s", r.Method, r.URL.Path)
+		http.Error(w, "No route for "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+	if body == nil && upstreams.needsBody(r) {
+		var err error
+		if body, model, err = requestModel(r); err != nil {
+			refuseBody(w, r, err)
+			return
+		}
+	}
+
+	b := upstreams.pickFor(upstreams.stickyKey(r, body))
+	if b == nil && upstreams.drained() {
+		log.Printf("[ERROR] No backend available for %s %s: all drained", r.Method, r.URL.Path)
+		http.Error(w, "Upstream unavailable: every backend is drained", http.StatusServiceUnavailable)
+		return
+	}
+	if b == nil {
+		log.Printf("[BREAKER] No backend available for %s %s", r.Method, r.URL.Path)
+		if wait := time.Until(upstreams.reopens()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		}
+		http.Error(w, "Upstream unavailable: circuit breaker open", http.StatusServiceUnavailable)
+		return
+	}
+	if !b.serves(r.URL.Path, model) {
+		upstreams.released(b)
+		http.Error(w, "No Azure deployment for "+r.URL.Path+": the request names no model", http.StatusBadRequest)
+		return
+	}
+	t := &target{pool: upstreams, backend: b, in: r.URL, model: model, body: body}
+	t.backend.inFlight.Add(1)
+	defer func() { t.backend.inFlight.Add(-1) }()
+	ctx := context.WithValue(r.Context(), targetKey{}, t)
+	if upgradeType(r.Header) != "" {
+		// The reverse proxy closes an upgraded connection when its
+		// request's context ends, which lets shutdown close it
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		defer p.hijacked.track(cancelCloser{&cancel})()
+		defer p.metrics.tunnelOpened(strings.ToLower(upgradeType(r.Header)))()
+	}
+	var completion *completionWriter
+	if p.completions != nil && hasCompletion(r) || p.usage != nil && hasModelBody(r) {
+		completion = newCompletionWriter(w, r)
+		w = completion
+	}
+	if p.streamFlushInterval > 0 {
+		fw := newFlushWriter(w, p.streamFlushInterval)
+		defer fw.stop()
+		w = fw
+	}
+	p.reverse.ServeHTTP(w, r.WithContext(ctx))
+	recordOf(r).Upstream = t.backend.url.Host
+	if completed := completion.finish(t.backend.url.Host); completed != nil {
+		if hasCompletion(r) {
+			p.completions.log(completed)
+		}
+		p.usage.add(r, completed)
+	}
+}
+
+// newReverseProxy forwards each request to the backend serveReverse chose
+// for it, joining the request path onto the upstream's (so with
+// https://host/openai, /v1/models goes to /openai/v1/models) and setting
+// the Host header to the upstream's. transport makes the connections,
+// honouring HTTPS_PROXY as any client would, and apiKey, if not nil,
+// replaces the client's credentials. Server-sent event streams are
+// flushed as they arrive.
+// Connection failures and 502, 503 and 504 responses count against the
+// backend; other responses put it back in rotation. Requests the client
+// abandons count for neither.
+func newReverseProxy(transport *http.Transport, apiKey *apiKeyInjector, verbose bool) *httputil.ReverseProxy {
+	transport.Proxy = http.ProxyFromEnvironment
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetXForwarded()
+			apiKey.apply(r.Out.Header, targetOf(r.In).backend.url.Host)
+			targetOf(r.In).direct(r.Out)
+			if verbose {
+				log.Printf("[REVERSE] Forwarding %s %s to %s", r.In.Method, r.In.URL.Path, r.Out.URL)
+			}
+		},
+		Transport: transport,
+		ModifyResponse: func(resp *http.Response) error {
+			t := targetOf(resp.Request)
+			if isStream(resp) {
+				keepStreaming(resp.Request)
+			}
+			if t.backend.azure != nil {
+				untranslateLocations(resp.Header)
+			}
+			rec := recordOf(resp.Request)
+			rec.UpstreamProto = resp.Proto
+			upstreamRequestID(rec, resp.Header)
+			rec.UpstreamTLS = describeTLS(resp.TLS)
+			if resp.StatusCode == http.StatusSwitchingProtocols {
+				// Written straight to the hijacked connection
+				rec.Status = resp.StatusCode
+			}
+			switch resp.StatusCode {
+			case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				markFailed(t)
+			default:
+				t.pool.succeeded(t.backend)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			t := targetOf(r)
+			log.Printf("[ERROR] Failed to proxy request to %s: %v", t.backend.url.Host, err)
+			if r.Context().Err() != nil && !timedOut(r) {
+				t.pool.released(t.backend)
+			} else {
+				markFailed(t)
+			}
+			http.Error(w, err.Error(), gatewayStatus(r, err))
+		},
+	}
+}
+
+// markFailed counts a failure against t's backend and logs if that takes
+// it out of rotation.
+func markFailed(t *target) {
+	if t.pool.failed(t.backend) && len(t.pool.backends) > 1 {
+		log.Printf("[HEALTH] %s out of rotation for %v after %d failures", t.backend.url.Host, t.pool.failTimeout, t.pool.maxFails)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestReverseProxy(t *testing.T) {
+	pki := newTestPKI(t)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %s %s", r.TLS.PeerCertificates[0].Subject.CommonName, r.Host, r.URL.Path, r.Header.Get("X-Forwarded-Host"))
+	}))
+	upstream.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	config, err := loadUpstreamTLS(pki.clientCertFile, pki.clientKeyFile, "", pki.caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := parseUpstream(strings.Replace(upstream.URL, "127.0.0.1", "localhost", 1) + "/openai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(&ProxyServer{reverse: newReverseProxy(newTransport(defaultTransportOptions, config), nil, false), upstream: singlePool(target)})
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/v1/models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	proxyHost := strings.TrimPrefix(proxy.URL, "http://")
+	if want := fmt.Sprintf("proxy-client %s /openai/v1/models %s", target.Host, proxyHost); string(body) != want {
+		t.Errorf("upstream saw %q, want %q", body, want)
+	}
+
+	req, _ := http.NewRequest(http.MethodConnect, proxy.URL, nil)
+	req.URL = &url.URL{Scheme: "http", Host: proxyHost, Opaque: "api.openai.com:443"}
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("CONNECT in reverse mode: got %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestParseUpstream(t *testing.T) {
+	for raw, ok := range map[string]bool{
+		"https://api.openai.com":      true,
+		"http://localhost:8000/proxy": true,
+		"":                            false,
+		"api.openai.com":              false,
+		"ftp://example.com":           false,
+		"https://":                    false,
+	} {
+		if _, err := parseUpstream(raw); (err == nil) != ok {
+			t.Errorf("parseUpstream(%q) error = %v, want ok %v", raw, err, ok)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// connTracker keeps the connections the proxy has taken over from the
+// HTTP server, for CONNECT and SOCKS5 tunnels and WebSockets, which
+// http.Server.Shutdown neither waits for nor closes.
+type connTracker struct {
+	mu     sync.Mutex
+	conns  map[io.Closer]struct{}
+	active int
+	idle   chan struct{} // closed when active drops to zero during wait
+	closed bool          // wait has closed what was left
+}
+
+// track adds conns until the returned function is called.
+func (t *connTracker) track(conns ...io.Closer) (untrack func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns == nil {
+		t.conns = make(map[io.Closer]struct{})
+	}
+	for _, conn := range conns {
+		t.conns[conn] = struct{}{}
+	}
+	t.active++
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for _, conn := range conns {
+			delete(t.conns, conn)
+		}
+		if t.active--; t.active == 0 && t.idle != nil {
+			close(t.idle)
+			t.idle = nil
+		}
+	}
+}
+
+// count returns how many tunnels and WebSockets are open.
+func (t *connTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
+
+// wait blocks until every tracked connection is done or ctx ends, and
+// then closes any left.
+func (t *connTracker) wait(ctx context.Context) error {
+	t.mu.Lock()
+	if t.active == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for conn := range t.conns {
+		conn.Close()
+	}
+	return ctx.Err()
+}
+
+// cutOff reports whether wait has closed the connections left, so a
+// tunnel that fails now was ended by the shutdown.
+func (t *connTracker) cutOff() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+// cancelCloser closes by cancelling a context. It holds a pointer so it
+// can be a map key.
+type cancelCloser struct{ cancel *context.CancelFunc }
+
+func (c cancelCloser) Close() error {
+	(*c.cancel)()
+	return nil
+}
+
+// drain stops server and listeners taking new connections, then waits for
+// requests and tunnels under way to finish until ctx ends, when it closes
+// whatever is left.
+func (p *ProxyServer) drain(ctx context.Context, server *http.Server, listeners ...net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("[SHUTDOWN] Requests still running after the drain timeout: %v", err)
+		server.Close()
+	}
+	if err := p.hijacked.wait(ctx); err != nil {
+		log.Printf("[SHUTDOWN] Closed tunnels still open after the drain timeout")
+	}
+}
+
+// serveErr is err from a server or listener, or nil if it only means
+// the proxy is shutting down.
+func serveErr(err error) error {
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// startDrainable serves proxy on a local port with an http.Server that
+// can be drained, and returns them with the address.
+func startDrainable(t *testing.T, proxy *ProxyServer) (*http.Server, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: proxy}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return server, listener.Addr().String()
+}
+
+// echoListener echoes whatever is sent on each connection to it.
+func echoListener(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestDrain(t *testing.T) {
+	upstream := httptest.NewServer(slowUpstream)
+	defer upstream.Close()
+	proxy := &ProxyServer{}
+	server, addr := startDrainable(t, proxy)
+	proxyURL, _ := url.Parse("http://" + addr)
+
+	// A slow request and a tunnel are under way when the drain starts
+	status := make(chan int)
+	go func() {
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+		resp, err := client.Get(upstream.URL + "/slow")
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	conn, reader := connectThrough(t, addr, echoListener(t))
+	time.Sleep(100 * time.Millisecond)
+
+	drained := make(chan struct{})
+	go func() {
+		proxy.drain(context.Background(), server)
+		close(drained)
+	}()
+
+	if code := <-status; code != http.StatusOK {
+		t.Errorf("request under way during the drain: got %d, want 200", code)
+	}
+	io.WriteString(conn, "ping")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		t.Errorf("tunnel during the drain: %v", err)
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Error("new connection accepted during the drain")
+	}
+	select {
+	case <-drained:
+		t.Fatal("drain finished with a tunnel open")
+	default:
+	}
+
+	conn.Close()
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain still waiting after the tunnel closed")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	proxy := &ProxyServer{}
+	server, addr := startDrainable(t, proxy)
+	conn, reader := connectThrough(t, addr, echoListener(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	proxy.drain(ctx, server)
+	if waited := time.Since(start); waited < 200*time.Millisecond || waited > 2*time.Second {
+		t.Errorf("drain took %v, want the 200ms timeout", waited)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("tunnel after the drain timeout: got %v, want EOF", err)
+	}
+}
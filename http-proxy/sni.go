@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// -connect-sni settings.
+const (
+	sniOff     = "off"
+	sniOn      = "on"
+	sniRequire = "require"
+)
+
+// parseConnectSNI checks -connect-sni, returning "" for off.
+func parseConnectSNI(setting string) (string, error) {
+	switch setting {
+	case "", sniOff:
+		return "", nil
+	case sniOn, sniRequire:
+		return setting, nil
+	}
+	return "", fmt.Errorf("invalid -connect-sni %q: must be %s, %s or %s", setting, sniOff, sniOn, sniRequire)
+}
+
+// errHelloRead stops the handshake readClientHello starts once it has the
+// ClientHello.
+var errHelloRead = errors.New("ClientHello read")
+
+// readClientHello reads the TLS ClientHello a tunnel starts with from r,
+// without answering it or decrypting anything, and returns it with the
+// bytes read, which the server has yet to get. If the tunnel does not
+// start with a ClientHello it returns the error and whatever was read.
+func readClientHello(conn net.Conn, r io.Reader) (*tls.ClientHelloInfo, []byte, error) {
+	var read bytes.Buffer
+	var hello *tls.ClientHelloInfo
+	err := tls.Server(helloConn{conn, io.TeeReader(r, &read)}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = h
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if hello == nil {
+		return nil, read.Bytes(), err
+	}
+	return hello, read.Bytes(), nil
+}
+
+// helloConn reads a client's first bytes from r for readClientHello, and
+// drops what the TLS server would write back, such as an alert.
+type helloConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c helloConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c helloConn) Write(b []byte) (int, error) { return len(b), nil }
+
+// replayConn is a client connection whose first bytes, read while looking
+// at the ClientHello, are read again before the rest, which come through
+// the buffer the connection was hijacked with.
+type replayConn struct {
+	net.Conn
+	read     *bytes.Reader
+	buffered *bufio.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	if c.read.Len() > 0 {
+		return c.read.Read(b)
+	}
+	return c.buffered.Read(b)
+}
+
+func (c *replayConn) readAhead() (io.Reader, net.Conn) {
+	return io.MultiReader(c.read, io.LimitReader(c.buffered, int64(c.buffered.Buffered()))), c.Conn
+}
+
+func (c *replayConn) CloseWrite() error {
+	closeWrite(c.Conn)
+	return nil
+}
+
+// connectBySNI serves a CONNECT tunnel by the name in the ClientHello the
+// client sends through it, with -connect-sni. It answers 200 before
+// connecting, reads the ClientHello, and reaches the server name it gives,
+// on the CONNECT port, if the allow and deny lists let it; the CONNECT
+// host has already been checked. A tunnel that does not start with a
+// ClientHello naming a server goes to the CONNECT host, or with require is
+// closed.
+func (p *ProxyServer) connectBySNI(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		log.Printf("[ERROR] Hijacking not supported")
+		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("[ERROR] Failed to hijack connection: %v", err)
+		return
+	}
+	defer clientConn.Close()
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+	rec := recordOf(r)
+
+	// Anything the client sent after its request is in buffered
+	if p.tlsHandshakeTimeout > 0 {
+		clientConn.SetReadDeadline(time.Now().Add(p.tlsHandshakeTimeout))
+	}
+	hello, read, err := readClientHello(clientConn, buffered.Reader)
+	clientConn.SetReadDeadline(time.Time{})
+	dest := r.Host
+	switch {
+	case hello != nil && hello.ServerName != "":
+		rec.SNI = hello.ServerName
+		_, port, _ := net.SplitHostPort(r.Host)
+		dest = net.JoinHostPort(hello.ServerName, port)
+	case p.connectSNI == sniRequire:
+		log.Printf("[DENIED] CONNECT %s from %s: no TLS server name (%v)", r.Host, r.RemoteAddr, describeHelloError(hello, err))
+		rec.Status = http.StatusForbidden
+		return
+	}
+	if dest != r.Host {
+		if p.verbose {
+			log.Printf("[SNI] CONNECT %s names %s", r.Host, hello.ServerName)
+		}
+		if !p.hostAllowed(dest) {
+			log.Printf("[DENIED] CONNECT %s from %s: TLS server name %s not allowed", r.Host, r.RemoteAddr, hello.ServerName)
+			rec.Status = http.StatusForbidden
+			return
+		}
+	}
+
+	targetConn, err := p.dialContext(r.Context(), "tcp", dest)
+	if err != nil {
+		log.Printf("[ERROR] Failed to connect to %s: %v", dest, err)
+		rec.Status = http.StatusServiceUnavailable
+		return
+	}
+	defer targetConn.Close()
+	if err := p.sendProxyHeader(targetConn, clientConn); err != nil {
+		log.Printf("[ERROR] Failed to send PROXY protocol header to %s: %v", dest, err)
+		return
+	}
+	if p.verbose {
+		log.Printf("[CONNECT] Tunnel established to %s", dest)
+	}
+
+	rec.Status = http.StatusOK
+	rec.Upstream = targetConn.RemoteAddr().String()
+	defer p.metrics.tunnelOpened("CONNECT")()
+	client := &replayConn{Conn: clientConn, read: bytes.NewReader(read), buffered: buffered.Reader}
+	rec.BytesIn, rec.BytesOut, rec.end = p.tunnel(client, targetConn)
+
+	if p.verbose {
+		log.Printf("[CONNECT] Tunnel closed for %s", dest)
+	}
+}
+
+// describeHelloError says why a tunnel gave no server name, for the log.
+func describeHelloError(hello *tls.ClientHelloInfo, err error) string {
+	if hello != nil {
+		return "the ClientHello has none"
+	}
+	return err.Error()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestReadClientHello(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go tls.Client(client, &tls.Config{ServerName: "api.openai.com"}).Handshake()
+	hello, read, err := readClientHello(server, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hello.ServerName != "api.openai.com" || len(read) == 0 || read[0] != 0x16 {
+		t.Errorf("got server name %q and %d bytes, want api.openai.com and the handshake record", hello.ServerName, len(read))
+	}
+
+	// Anything else is left as it was read
+	client, server = net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go io.WriteString(client, "SSH-2.0-OpenSSH_9.6\r\n")
+	hello, read, err = readClientHello(server, server)
+	if hello != nil || err == nil || !strings.HasPrefix("SSH-2.0-OpenSSH_9.6\r\n", string(read)) || len(read) == 0 {
+		t.Errorf("got %v, %q, %v; want an error and the bytes read", hello, read, err)
+	}
+}
+
+func TestConnectSNI(t *testing.T) {
+	mock := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "mock for "+r.TLS.ServerName)
+	}))
+	defer mock.Close()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "plain")
+	}))
+	defer plain.Close()
+
+	// Only the names in the ClientHello resolve, to the mock
+	addr := strings.TrimPrefix(mock.URL, "https://")
+	rules, err := parseResolve("real.test=" + addr + ",evil.test=" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ProxyServer{connectSNI: sniOn, resolve: rules, denyHosts: parseHostPatterns("evil.test")}
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	get := func(serverName string) (string, error) {
+		transport := &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{ServerName: serverName, InsecureSkipVerify: true},
+		}
+		defer transport.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: transport}).Get("https://front.test/")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), nil
+	}
+
+	// The tunnel goes to the server name, not the CONNECT host
+	if body, err := get("real.test"); err != nil || body != "mock for real.test" {
+		t.Errorf("allowed server name: got %q, %v", body, err)
+	}
+	// which must be allowed
+	if body, err := get("evil.test"); err == nil {
+		t.Errorf("denied server name: got %q", body)
+	}
+
+	// A tunnel with no ClientHello goes to the CONNECT host, unless one
+	// is required
+	through := func() string {
+		conn, err := net.Dial("tcp", proxyURL.Host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		target := strings.TrimPrefix(plain.URL, "http://")
+		io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\nGET / HTTP/1.1\r\nHost: "+target+"\r\nConnection: close\r\n\r\n")
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("CONNECT: %v", err)
+		}
+		resp, err = http.ReadResponse(reader, nil)
+		if err != nil {
+			return err.Error()
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	if got := through(); got != "plain" {
+		t.Errorf("plain tunnel: got %q", got)
+	}
+	p.connectSNI = sniRequire
+	if got := through(); got == "plain" {
+		t.Error("plain tunnel let through with require")
+	}
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// SOCKS5 protocol values (RFC 1928 and, for username/password, RFC 1929).
+const (
+	socksVersion         = 0x05
+	socksUserPassVersion = 0x01
+
+	// Authentication methods
+	socksNoAuth       = 0x00
+	socksUserPass     = 0x02
+	socksNoAcceptable = 0xff
+
+	// Commands and address types
+	socksConnect = 0x01
+	socksIPv4    = 0x01
+	socksDomain  = 0x03
+	socksIPv6    = 0x04
+
+	// Replies
+	socksSucceeded       = 0x00
+	socksFailure         = 0x01
+	socksNotAllowed      = 0x02
+	socksHostUnreachable = 0x04
+	socksRefused         = 0x05
+	socksCmdUnsupported  = 0x07
+	socksAddrUnsupported = 0x08
+)
+
+// socksProto is the access log's protocol for SOCKS5 tunnels.
+const socksProto = "SOCKS5"
+
+// socksHandshakeTimeout bounds how long a client has to say where it
+// wants to go.
+const socksHandshakeTimeout = 30 * time.Second
+
+// serveSOCKS accepts SOCKS5 clients on l until it fails.
+func (p *ProxyServer) serveSOCKS(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handleSOCKS(conn)
+	}
+}
+
+// handleSOCKS serves one SOCKS5 client: it authenticates it if
+// -proxy-auth-file is set, applies the same destination policy as CONNECT,
+// and tunnels to the destination. A destination in -upstream-hosts gets
+// TLS with the upstream client certificate, as plain HTTP requests do, so
+// tools that speak plain HTTP over SOCKS get mTLS too.
+func (p *ProxyServer) handleSOCKS(client net.Conn) {
+	defer client.Close()
+	startTime := time.Now()
+
+	client.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	user, err := p.socksAuthenticate(client)
+	if err != nil {
+		log.Printf("[SOCKS] Handshake with %s failed: %v", client.RemoteAddr(), err)
+		return
+	}
+	dest, code, err := readSOCKSRequest(client)
+	if err != nil {
+		log.Printf("[SOCKS] Bad request from %s: %v", client.RemoteAddr(), err)
+		if code != 0 {
+			writeSOCKSReply(client, code, nil)
+		}
+		return
+	}
+	client.SetDeadline(time.Time{})
+
+	// Record the tunnel as a CONNECT, with the HTTP status that would have
+	// been given for it
+	rec := &accessRecord{
+		Time:   startTime,
+		Client: client.RemoteAddr().String(),
+		User:   user,
+		Method: http.MethodConnect,
+		Host:   dest,
+		Proto:  socksProto,
+		uri:    dest,
+	}
+	if addr, ok := client.RemoteAddr().(*net.TCPAddr); ok {
+		rec.Client = addr.IP.String()
+	}
+	defer p.logAccess(rec)
+
+	host, port, _ := net.SplitHostPort(dest)
+	originating := p.originatingTLS(dest)
+	originate := originating != nil
+	if originate && port == "80" {
+		// Plain HTTP to the default port goes to the HTTPS one, as
+		// http:// URLs become https:// ones
+		port = "443"
+	}
+	switch {
+	case !p.socksRateAllowed(client):
+		log.Printf("[LIMITED] SOCKS %s from %s", dest, client.RemoteAddr())
+		writeSOCKSReply(client, socksNotAllowed, nil)
+		rec.Status = http.StatusTooManyRequests
+		return
+	case !p.hostAllowed(dest):
+		log.Printf("[DENIED] SOCKS %s from %s", dest, client.RemoteAddr())
+		writeSOCKSReply(client, socksNotAllowed, nil)
+		rec.Status = http.StatusForbidden
+		return
+	case !originate && p.connectPorts != nil && !p.connectPorts[port]:
+		log.Printf("[DENIED] SOCKS %s from %s: port not allowed", dest, client.RemoteAddr())
+		writeSOCKSReply(client, socksNotAllowed, nil)
+		rec.Status = http.StatusForbidden
+		return
+	}
+	if p.verbose {
+		log.Printf("[SOCKS] Connecting %s to %s", describeSOCKSUser(user, client), dest)
+		if originate {
+			log.Printf("[TLS] Originating TLS to %s", net.JoinHostPort(host, port))
+		}
+	}
+
+	target, err := p.dialContext(context.Background(), "tcp", net.JoinHostPort(host, port))
+	if err == nil {
+		if err = p.sendProxyHeader(target, client); err != nil {
+			target.Close()
+		}
+	}
+	if err == nil && originate {
+		config := originating.Clone()
+		config.ServerName = host
+		conn := tls.Client(target, config)
+		ctx, cancel := withTimeout(context.Background(), p.tlsHandshakeTimeout)
+		if err = conn.HandshakeContext(ctx); err != nil {
+			p.metrics.tlsError("upstream")
+			conn.Close()
+		}
+		cancel()
+		target = conn
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to connect to %s: %v", dest, err)
+		writeSOCKSReply(client, socksErrorCode(err), nil)
+		rec.Status = http.StatusBadGateway
+		return
+	}
+	defer target.Close()
+	rec.Upstream = target.RemoteAddr().String()
+	if conn, ok := target.(*tls.Conn); ok {
+		state := conn.ConnectionState()
+		rec.UpstreamTLS = describeTLS(&state)
+	}
+
+	if err := writeSOCKSReply(client, socksSucceeded, target.LocalAddr()); err != nil {
+		return
+	}
+	rec.Status = http.StatusOK
+	defer p.metrics.tunnelOpened(socksProto)()
+	rec.BytesIn, rec.BytesOut, rec.end = p.tunnel(client, target)
+}
+
+// socksAuthenticate negotiates the method with a client: username and
+// password, checked against the Basic users of -proxy-auth-file, if it is
+// set, or none. It returns the user.
+func (p *ProxyServer) socksAuthenticate(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("not SOCKS5 (version %d)", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	auth := p.auth()
+	want := byte(socksNoAuth)
+	if auth != nil {
+		want = socksUserPass
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == want
+	}
+	if !offered {
+		conn.Write([]byte{socksVersion, socksNoAcceptable})
+		return "", fmt.Errorf("client does not offer method %d", want)
+	}
+	if _, err := conn.Write([]byte{socksVersion, want}); err != nil {
+		return "", err
+	}
+	if want == socksNoAuth {
+		return "", nil
+	}
+
+	// RFC 1929: version, username, password, each string length-prefixed
+	var fields [2]string
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		return "", err
+	}
+	for i := range fields {
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		field := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, field); err != nil {
+			return "", err
+		}
+		fields[i] = string(field)
+	}
+	user, password := fields[0], fields[1]
+	expected, ok := auth.users[user]
+	if version[0] != socksUserPassVersion || !ok || subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
+		conn.Write([]byte{socksUserPassVersion, 0x01})
+		return "", fmt.Errorf("bad credentials for %q", user)
+	}
+	_, err := conn.Write([]byte{socksUserPassVersion, 0x00})
+	return user, err
+}
+
+// readSOCKSRequest reads a client's request and returns its destination
+// as host:port. On error it also returns the reply code to send, if any.
+func readSOCKSRequest(conn net.Conn) (string, byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", 0, err
+	}
+	if header[0] != socksVersion {
+		return "", 0, fmt.Errorf("not SOCKS5 (version %d)", header[0])
+	}
+
+	var host string
+	switch header[3] {
+	case socksIPv4, socksIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if header[3] == socksIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", 0, err
+		}
+		host = ip.String()
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", 0, err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", 0, err
+		}
+		host = string(name)
+	default:
+		return "", socksAddrUnsupported, fmt.Errorf("address type %d not supported", header[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", 0, err
+	}
+	dest := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+	if header[1] != socksConnect {
+		return "", socksCmdUnsupported, fmt.Errorf("command %d to %s not supported; only CONNECT is", header[1], dest)
+	}
+	return dest, 0, nil
+}
+
+// writeSOCKSReply sends a reply with the address the proxy connected
+// from, or 0.0.0.0:0 if there is none.
+func writeSOCKSReply(conn net.Conn, code byte, bound net.Addr) error {
+	reply := []byte{socksVersion, code, 0x00}
+	addr, ok := bound.(*net.TCPAddr)
+	switch {
+	case !ok:
+		reply = append(reply, socksIPv4, 0, 0, 0, 0, 0, 0)
+	case addr.IP.To4() != nil:
+		reply = append(append(reply, socksIPv4), addr.IP.To4()...)
+		reply = binary.BigEndian.AppendUint16(reply, uint16(addr.Port))
+	default:
+		reply = append(append(reply, socksIPv6), addr.IP.To16()...)
+		reply = binary.BigEndian.AppendUint16(reply, uint16(addr.Port))
+	}
+	_, err := conn.Write(reply)
+	return err
+}
+
+// socksErrorCode picks the reply for a failed connection.
+func socksErrorCode(err error) byte {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socksRefused
+	case errors.As(err, &dnsErr), errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, context.DeadlineExceeded):
+		return socksHostUnreachable
+	}
+	return socksFailure
+}
+
+// socksRateAllowed applies -rate-limit to a SOCKS client, which is counted
+// by its IP.
+func (p *ProxyServer) socksRateAllowed(client net.Conn) bool {
+	if p.rateLimit == nil {
+		return true
+	}
+	ip, _, _ := net.SplitHostPort(client.RemoteAddr().String())
+	ok, _ := p.rateLimit.allow("ip "+ip, time.Now())
+	return ok
+}
+
+// describeSOCKSUser names a SOCKS client for the verbose log.
+func describeSOCKSUser(user string, client net.Conn) string {
+	if user == "" {
+		return client.RemoteAddr().String()
+	}
+	return user + " at " + client.RemoteAddr().String()
+}
+
+// socksDialer connects through a SOCKS5 proxy: the -socks-upstream that
+// all of the proxy's own connections go through. Host names are passed to
+// it to resolve.
+type socksDialer struct {
+	addr     string
+	user     string
+	password string
+}
+
+// parseSOCKSUpstream parses a socks5://[user:password@]host:port URL.
+func parseSOCKSUpstream(raw string) (*socksDialer, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "socks5" && u.Scheme != "socks5h") || u.Port() == "" {
+		return nil, fmt.Errorf("%q must be socks5://[user:password@]host:port", raw)
+	}
+	d := &socksDialer{addr: u.Host}
+	if u.User != nil {
+		d.user = u.User.Username()
+		d.password, _ = u.User.Password()
+		if len(d.user) > 255 || len(d.password) > 255 {
+			return nil, fmt.Errorf("SOCKS username and password must be at most 255 bytes")
+		}
+	}
+	return d, nil
+}
+
+// DialContext connects to addr through the SOCKS5 proxy.
+func (d *socksDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, err
+	}
+	// Give up on the handshake if ctx ends first
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	if err := d.connect(conn, addr); err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("SOCKS5 %s to %s: %w", d.addr, addr, err)
+	}
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	return conn, nil
+}
+
+// connect asks the SOCKS5 proxy on conn to connect to addr.
+func (d *socksDialer) connect(conn net.Conn, addr string) error {
+	method := byte(socksNoAuth)
+	if d.user != "" {
+		method = socksUserPass
+	}
+	if _, err := conn.Write([]byte{socksVersion, 1, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion || reply[1] != method {
+		return fmt.Errorf("proxy refused authentication method %d", method)
+	}
+	if method == socksUserPass {
+		request := []byte{socksUserPassVersion, byte(len(d.user))}
+		request = append(append(request, d.user...), byte(len(d.password)))
+		request = append(request, d.password...)
+		if _, err := conn.Write(request); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("proxy rejected credentials for %q", d.user)
+		}
+	}
+
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portText)
+	}
+	request := []byte{socksVersion, socksConnect, 0x00}
+	switch ip := net.ParseIP(host); {
+	case ip.To4() != nil:
+		request = append(append(request, socksIPv4), ip.To4()...)
+	case ip != nil:
+		request = append(append(request, socksIPv6), ip.To16()...)
+	case len(host) > 255:
+		return fmt.Errorf("host name %q too long", host)
+	default:
+		request = append(append(request, socksDomain, byte(len(host))), host...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	// Version, reply, reserved and the bound address, which is not needed
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != socksSucceeded {
+		return fmt.Errorf("proxy replied %d", header[1])
+	}
+	var skip int
+	switch header[3] {
+	case socksIPv4:
+		skip = net.IPv4len
+	case socksIPv6:
+		skip = net.IPv6len
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	default:
+		return fmt.Errorf("proxy replied with address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// dialContext connects to addr, or the address or Unix socket -resolve
+// gives for it, through the -socks-upstream if there is one, giving up
+// after -dial-timeout.
+func (p *ProxyServer) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if resolved, ok := p.resolve.apply(addr); ok {
+		if p.verbose {
+			log.Printf("[RESOLVE] %s -> %s", addr, resolved)
+		}
+		addr = resolved
+	}
+	ctx, cancel := withTimeout(ctx, p.dialTimeout)
+	defer cancel()
+	if path, ok := unixSocket(addr); ok {
+		// A local socket, never reached through -socks-upstream
+		return (&net.Dialer{}).DialContext(ctx, "unix", path)
+	}
+	if p.egress != nil {
+		return p.egress.DialContext(ctx, network, addr)
+	}
+	return (&net.Dialer{}).DialContext(ctx, network, addr)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// startSOCKS serves SOCKS5 for proxy on a local port and returns its
+// address.
+func startSOCKS(t *testing.T, proxy *ProxyServer) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go proxy.serveSOCKS(listener)
+	return listener.Addr().String()
+}
+
+// socksGet fetches target through the SOCKS5 proxy at socksURL.
+func socksGet(socksURL, target string) (string, error) {
+	dialer, err := parseSOCKSUpstream(socksURL)
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+	resp, err := client.Get(target)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestSOCKS(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok "+r.URL.Path)
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+	_, port, _ := net.SplitHostPort(upstreamHost)
+
+	addr := startSOCKS(t, &ProxyServer{denyHosts: parseHostPatterns("localhost")})
+	for _, target := range []string{upstream.URL, "http://[::1]:1", "http://localhost:" + port} {
+		body, err := socksGet("socks5://"+addr, target+"/v1/models")
+		switch {
+		case target == upstream.URL && (err != nil || body != "ok /v1/models"):
+			t.Errorf("%s: got %q, %v; want ok", target, body, err)
+		case strings.Contains(target, "[::1]") && (err == nil || !strings.Contains(err.Error(), "replied 5")):
+			t.Errorf("%s: got %v, want connection refused", target, err)
+		case strings.Contains(target, "localhost") && (err == nil || !strings.Contains(err.Error(), "replied 2")):
+			t.Errorf("%s: got %v, want not allowed", target, err)
+		}
+	}
+
+	addr = startSOCKS(t, &ProxyServer{connectPorts: map[string]bool{"443": true}})
+	if _, err := socksGet("socks5://"+addr, upstream.URL); err == nil || !strings.Contains(err.Error(), "replied 2") {
+		t.Errorf("port outside -connect-ports: got %v, want not allowed", err)
+	}
+}
+
+func TestSOCKSAuth(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	auth, err := loadProxyAuth(writeProxyAuth(t, "alice:s3cret\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startSOCKS(t, &ProxyServer{proxyAuth: auth})
+	for user, ok := range map[string]bool{
+		"":              false,
+		"alice:wrong@":  false,
+		"alice:s3cret@": true,
+	} {
+		body, err := socksGet("socks5://"+user+addr, upstream.URL)
+		if ok && (err != nil || body != "ok") {
+			t.Errorf("%q: got %q, %v; want ok", user, body, err)
+		}
+		if !ok && err == nil {
+			t.Errorf("%q: accepted", user)
+		}
+	}
+}
+
+func TestSOCKSOriginateTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	upstream.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	config, err := loadUpstreamTLS(pki.clientCertFile, pki.clientKeyFile, "", pki.caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(upstream.Listener.Addr().String())
+	upstreamHost := "localhost:" + port
+	addr := startSOCKS(t, &ProxyServer{upstreamTLS: config, upstreamHosts: parseHostPatterns(upstreamHost)})
+
+	// Plain HTTP through the tunnel; the proxy adds TLS and the certificate
+	body, err := socksGet("socks5://"+addr, "http://"+upstreamHost+"/v1/models")
+	if err != nil || body != "proxy-client" {
+		t.Errorf("got %q, %v; want the upstream to see proxy-client", body, err)
+	}
+}
+
+func TestSOCKSUpstream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	auth, err := loadProxyAuth(writeProxyAuth(t, "egress:pw\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	socksAddr := startSOCKS(t, &ProxyServer{proxyAuth: auth})
+	egress, err := parseSOCKSUpstream("socks5://egress:pw@" + socksAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Plain requests and CONNECT tunnels through the forward proxy both
+	// leave through the SOCKS proxy
+	proxy := httptest.NewServer(&ProxyServer{egress: egress})
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	tlsUpstream := httptest.NewTLSServer(upstream.Config.Handler)
+	defer tlsUpstream.Close()
+	transport := tlsUpstream.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	for _, target := range []string{upstream.URL, tlsUpstream.URL} {
+		resp, err := (&http.Client{Transport: transport}).Get(target)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "ok" {
+			t.Errorf("%s: got %d %q, want ok", target, resp.StatusCode, body)
+		}
+	}
+
+	// A SOCKS proxy that refuses the destination makes the request fail
+	refusing, _ := parseSOCKSUpstream("socks5://" + startSOCKS(t, &ProxyServer{denyHosts: parseHostPatterns("*")}))
+	refused := httptest.NewServer(&ProxyServer{egress: refusing})
+	defer refused.Close()
+	refusedURL, _ := url.Parse(refused.URL)
+	resp, err := (&http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(refusedURL)}}).Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("through a refusing SOCKS proxy: got %d, want 502", resp.StatusCode)
+	}
+
+	wrong, _ := parseSOCKSUpstream("socks5://egress:nope@" + socksAddr)
+	if _, err := wrong.DialContext(context.Background(), "tcp", strings.TrimPrefix(upstream.URL, "http://")); err == nil {
+		t.Error("dialled with wrong SOCKS credentials")
+	}
+	if _, err := parseSOCKSUpstream("http://" + socksAddr); err == nil {
+		t.Error("http:// accepted as a SOCKS upstream")
+	}
+}
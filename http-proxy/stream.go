@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultStreamBufferSize is the buffer forward-mode streams are copied
+// through unless -stream-buffer-size says otherwise. Reverse mode uses the
+// reverse proxy's own, 32 KB.
+const defaultStreamBufferSize = 4096
+
+// bufferPool hands the reverse proxy its copy buffers, all of one size.
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	return &bufferPool{size: size}
+}
+
+func (b *bufferPool) Get() []byte {
+	if buf, ok := b.pool.Get().(*[]byte); ok {
+		return *buf
+	}
+	return make([]byte, b.size)
+}
+
+func (b *bufferPool) Put(buf []byte) {
+	b.pool.Put(&buf)
+}
+
+// flushWriter flushes a response to the client at most once per interval,
+// for -stream-flush-interval. The first flush goes out at once; those
+// asked for within the interval after it are made as one when it is up,
+// so a stream of many small events costs a write to the client per
+// interval rather than one per event. Writes are serialised with the
+// delayed flush. Whoever wraps a response in one must stop it before the
+// handler returns.
+type flushWriter struct {
+	http.ResponseWriter
+	interval time.Duration
+
+	mu      sync.Mutex
+	flushed time.Time
+	timer   *time.Timer
+	stopped bool
+}
+
+func newFlushWriter(w http.ResponseWriter, interval time.Duration) *flushWriter {
+	return &flushWriter{ResponseWriter: w, interval: interval}
+}
+
+func (w *flushWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *flushWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *flushWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped || w.timer != nil {
+		// A flush is already on its way
+		return
+	}
+	if wait := w.interval - time.Since(w.flushed); wait > 0 {
+		w.timer = time.AfterFunc(wait, w.flushLater)
+		return
+	}
+	w.flush()
+}
+
+func (w *flushWriter) flushLater() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timer = nil
+	if !w.stopped {
+		w.flush()
+	}
+}
+
+func (w *flushWriter) flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+	w.flushed = time.Now()
+}
+
+// stop makes any flush still waiting for the interval, and no more.
+func (w *flushWriter) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+		w.flush()
+	}
+	w.stopped = true
+}
+
+func (w *flushWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingFlusher counts the flushes that reach it.
+type countingFlusher struct {
+	*httptest.ResponseRecorder
+	flushes atomic.Int32
+}
+
+func (w *countingFlusher) Flush() { w.flushes.Add(1) }
+
+func TestFlushWriter(t *testing.T) {
+	rec := &countingFlusher{ResponseRecorder: httptest.NewRecorder()}
+	w := newFlushWriter(rec, 100*time.Millisecond)
+
+	// The first flush goes at once, and the rest wait for the interval
+	for range 10 {
+		io.WriteString(w, "data: x\n\n")
+		w.Flush()
+	}
+	if n := rec.flushes.Load(); n != 1 {
+		t.Fatalf("%d flushes at once, want 1", n)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.flushes.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := rec.flushes.Load(); n != 2 {
+		t.Fatalf("%d flushes after the interval, want 2", n)
+	}
+
+	// Stopping makes the one waiting, and no more
+	io.WriteString(w, "data: y\n\n")
+	w.Flush()
+	w.stop()
+	w.Flush()
+	time.Sleep(200 * time.Millisecond)
+	if n := rec.flushes.Load(); n != 3 {
+		t.Errorf("%d flushes after stopping, want 3", n)
+	}
+	if got := strings.Count(rec.Body.String(), "data: "); got != 11 {
+		t.Errorf("%d events written, want 11", got)
+	}
+}
+
+func TestStreamTuning(t *testing.T) {
+	var want strings.Builder
+	for i := range 20 {
+		fmt.Fprintf(&want, "data: {\"index\":%d}\n\n", i)
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range strings.SplitAfter(want.String(), "\n\n") {
+			io.WriteString(w, event)
+			w.(http.Flusher).Flush()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}))
+	defer upstream.Close()
+	target, _ := parseUpstream(upstream.URL)
+
+	reverseProxy := newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false)
+	reverseProxy.BufferPool = newBufferPool(7)
+	reverse := httptest.NewServer(&ProxyServer{
+		reverse:             reverseProxy,
+		upstream:            singlePool(target),
+		streamBufferSize:    7,
+		streamFlushInterval: 30 * time.Millisecond,
+	})
+	defer reverse.Close()
+	forward := httptest.NewServer(&ProxyServer{streamBufferSize: 7, streamFlushInterval: 30 * time.Millisecond})
+	defer forward.Close()
+	forwardURL, _ := url.Parse(forward.URL)
+
+	// Events split across buffers and flushes arrive whole, in order
+	for name, get := range map[string]func() (*http.Response, error){
+		"reverse": func() (*http.Response, error) { return http.Get(reverse.URL + "/v1/chat/completions") },
+		"forward": func() (*http.Response, error) {
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(forwardURL)}}
+			return client.Get(upstream.URL)
+		},
+	} {
+		resp, err := get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != want.String() {
+			t.Errorf("%s: got %q, %v; want %q", name, body, err, want.String())
+		}
+	}
+}
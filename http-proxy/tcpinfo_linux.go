@@ -0,0 +1,35 @@
+//go:build linux && !386
+
+package main
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// lastReceived returns how long ago conn last received data, if it is a
+// TCP connection, from the kernel's TCP_INFO, which counts what splice
+// moves as well as what is read.
+func lastReceived(conn net.Conn) (time.Duration, bool) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return 0, false
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	var info syscall.TCPInfo
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		size := uint32(unsafe.Sizeof(info))
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.SOL_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil || errno != 0 {
+		return 0, false
+	}
+	return time.Duration(info.Last_data_recv) * time.Millisecond, true
+}
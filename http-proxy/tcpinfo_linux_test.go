@@ -0,0 +1,47 @@
+//go:build linux && !386
+
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLastReceived(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// Data the kernel has received counts, read or not
+	io.WriteString(client, "ping")
+	time.Sleep(200 * time.Millisecond)
+	if since, ok := lastReceived(server); !ok || since < 150*time.Millisecond || since > 5*time.Second {
+		t.Errorf("after 200ms: got %v, %v", since, ok)
+	}
+	io.WriteString(client, "ping")
+	time.Sleep(10 * time.Millisecond)
+	if since, ok := lastReceived(server); !ok || since > 150*time.Millisecond {
+		t.Errorf("just after: got %v, %v", since, ok)
+	}
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if _, ok := lastReceived(a); ok {
+		t.Error("lastReceived of a pipe")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux || 386
+
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// lastReceived is only known on Linux, where TCP_INFO gives it (but on
+// 386 getsockopt is reached through socketcall, which syscall does not
+// offer). Elsewhere tunnels time their own reads, and splice is Linux's
+// alone anyway.
+func lastReceived(net.Conn) (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errRequestTimeout is the cause of a request's context ending when it
+// runs past -request-timeout.
+var errRequestTimeout = errors.New("request timed out")
+
+// requestTimerKey is the context key for the timer behind -request-timeout.
+type requestTimerKey struct{}
+
+// limitRequest returns r with a context that ends after p.requestTimeout,
+// and a function to call when r is done. CONNECT tunnels are not limited;
+// streams are exempted once they start, by keepStreaming.
+func (p *ProxyServer) limitRequest(r *http.Request) (*http.Request, func()) {
+	if p.requestTimeout <= 0 || r.Method == http.MethodConnect {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(r.Context())
+	timer := time.AfterFunc(p.requestTimeout, func() { cancel(errRequestTimeout) })
+	ctx = context.WithValue(ctx, requestTimerKey{}, timer)
+	return r.WithContext(ctx), func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
+
+// keepStreaming lifts -request-timeout from a request whose response has
+// turned out to be a stream, such as server-sent events or a WebSocket,
+// which lasts as long as it needs to.
+func keepStreaming(r *http.Request) {
+	if timer, ok := r.Context().Value(requestTimerKey{}).(*time.Timer); ok {
+		timer.Stop()
+	}
+}
+
+// isStream reports whether resp is a stream that keepStreaming exempts.
+func isStream(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusSwitchingProtocols ||
+		strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+// timedOut reports whether r ended because of -request-timeout.
+func timedOut(r *http.Request) bool {
+	return errors.Is(context.Cause(r.Context()), errRequestTimeout)
+}
+
+// gatewayStatus is the status for a request that failed upstream with
+// err: 504 Gateway Timeout if it timed out, otherwise 502 Bad Gateway.
+func gatewayStatus(r *http.Request, err error) int {
+	if bodyTooLarge(err) {
+		return http.StatusRequestEntityTooLarge
+	}
+	var netErr net.Error
+	if timedOut(r) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// withTimeout is context.WithTimeout, except that a zero timeout means
+// none.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
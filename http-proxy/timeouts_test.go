@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// slowUpstream answers /slow after 500ms, and streams five events 100ms
+// apart on /stream.
+var slowUpstream = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/stream" {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range 5 {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+		return
+	}
+	select {
+	case <-time.After(500 * time.Millisecond):
+		io.WriteString(w, "late")
+	case <-r.Context().Done():
+	}
+})
+
+func TestRequestTimeout(t *testing.T) {
+	upstream := httptest.NewServer(slowUpstream)
+	defer upstream.Close()
+	target, err := parseUpstream(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reverse := httptest.NewServer(&ProxyServer{
+		reverse:        newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false),
+		upstream:       singlePool(target),
+		requestTimeout: 200 * time.Millisecond,
+	})
+	defer reverse.Close()
+	forward := httptest.NewServer(&ProxyServer{requestTimeout: 200 * time.Millisecond})
+	defer forward.Close()
+	forwardURL, _ := url.Parse(forward.URL)
+
+	for name, get := range map[string]func(path string) (*http.Response, error){
+		"reverse": func(path string) (*http.Response, error) { return http.Get(reverse.URL + path) },
+		"forward": func(path string) (*http.Response, error) {
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(forwardURL)}}
+			return client.Get(upstream.URL + path)
+		},
+	} {
+		resp, err := get("/slow")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusGatewayTimeout {
+			t.Errorf("%s: slow response got %d, want 504", name, resp.StatusCode)
+		}
+
+		// A stream outlasts the timeout
+		resp, err = get("/stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if want := "data: 0\n\ndata: 1\n\ndata: 2\n\ndata: 3\n\ndata: 4\n\n"; err != nil || string(body) != want {
+			t.Errorf("%s: stream got %q, %v; want all five events", name, body, err)
+		}
+	}
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	upstream := httptest.NewServer(slowUpstream)
+	defer upstream.Close()
+
+	options := defaultTransportOptions
+	options.responseHeaderTimeout = 100 * time.Millisecond
+	p := &ProxyServer{}
+	p.setTransports(options)
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get(upstream.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("got %d, want 504", resp.StatusCode)
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds and status codes, as OTLP numbers them.
+const (
+	spanKindServer = 2
+	spanKindClient = 3
+
+	spanStatusError = 2
+)
+
+const (
+	traceBatchSize = 256
+	traceQueueSize = 4096
+)
+
+// traceFlushInterval is how often spans are sent if a batch is not filled
+// sooner.
+var traceFlushInterval = 5 * time.Second
+
+// tracer makes spans for proxied requests, propagates W3C trace context
+// to upstreams, and exports the spans to an OTLP/HTTP collector. A nil
+// *tracer does nothing, and traceparent headers pass through untouched.
+type tracer struct {
+	endpoint string
+	service  string
+	sample   float64
+	client   *http.Client
+	queue    chan *span
+	stop     chan struct{}
+	done     chan struct{}
+	stopping sync.Once
+}
+
+func newTracer(endpoint, service string, sample float64) (*tracer, error) {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("invalid -otlp-endpoint %q: want an http:// or https:// URL such as http://localhost:4318/v1/traces", endpoint)
+	}
+	if sample < 0 || sample > 1 {
+		return nil, fmt.Errorf("invalid -trace-sample %v: must be between 0 and 1", sample)
+	}
+	t := &tracer{
+		endpoint: endpoint,
+		service:  service,
+		sample:   sample,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan *span, traceQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.export(traceFlushInterval)
+	return t, nil
+}
+
+// span is one operation in a trace.
+type span struct {
+	tracer  *tracer
+	traceID [16]byte
+	id      [8]byte
+	parent  [8]byte // zero for a root span
+	sampled bool
+
+	name       string
+	kind       int
+	start, end time.Time
+
+	mu     sync.Mutex
+	attrs  []attribute
+	failed bool
+}
+
+type attribute struct {
+	key   string
+	value any // string or int
+}
+
+// spanKey is the context key for a request's server span.
+type spanKey struct{}
+
+// start begins the server span for r, a child of the client's span if r
+// carries a traceparent, and returns r with the span in its context.
+func (t *tracer) start(r *http.Request) (*http.Request, *span) {
+	if t == nil {
+		return r, nil
+	}
+	s := &span{tracer: t, name: r.Method, kind: spanKindServer, start: time.Now()}
+	if traceID, parent, sampled, ok := parseTraceparent(r.Header.Get("Traceparent")); ok {
+		s.traceID, s.parent, s.sampled = traceID, parent, sampled
+	} else {
+		cryptorand.Read(s.traceID[:])
+		s.sampled = rand.Float64() < t.sample
+	}
+	cryptorand.Read(s.id[:])
+	return r.WithContext(context.WithValue(r.Context(), spanKey{}, s)), s
+}
+
+// child begins a span under s.
+func (s *span) child(name string, kind int) *span {
+	c := &span{tracer: s.tracer, traceID: s.traceID, parent: s.id, sampled: s.sampled, name: name, kind: kind, start: time.Now()}
+	cryptorand.Read(c.id[:])
+	return c
+}
+
+func (s *span) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attribute{key, value})
+}
+
+// finish ends s and queues it for export if it is sampled. Spans are
+// dropped rather than held up if the collector falls behind.
+func (s *span) finish(failed bool) {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.mu.Lock()
+	s.end = time.Now()
+	s.failed = failed
+	s.mu.Unlock()
+	select {
+	case s.tracer.queue <- s:
+	default:
+	}
+}
+
+// traceparent is the W3C traceparent header naming s as the parent.
+func (s *span) traceparent() string {
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.id[:]) + "-" + flags
+}
+
+// parseTraceparent reads a W3C traceparent header. Versions after 00 may
+// add fields, which are ignored.
+func parseTraceparent(header string) (traceID [16]byte, parent [8]byte, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || (parts[0] == "00" && len(parts) != 4) || parts[0] == "ff" {
+		return traceID, parent, false, false
+	}
+	var version, flags [1]byte
+	if !decodeHex(version[:], parts[0]) || !decodeHex(traceID[:], parts[1]) ||
+		!decodeHex(parent[:], parts[2]) || !decodeHex(flags[:], parts[3]) {
+		return traceID, parent, false, false
+	}
+	// All-zero IDs are invalid
+	if traceID == [16]byte{} || parent == [8]byte{} {
+		return traceID, parent, false, false
+	}
+	return traceID, parent, flags[0]&1 == 1, true
+}
+
+// decodeHex decodes s into dst, which it must fill exactly. Upper case is
+// not allowed in trace context.
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// spanOf returns the server span of r, or nil.
+func spanOf(r *http.Request) *span {
+	s, _ := r.Context().Value(spanKey{}).(*span)
+	return s
+}
+
+// finishRequest ends the server span of a request with what its access
+// record says.
+func (s *span) finishRequest(rec *accessRecord) {
+	if s == nil {
+		return
+	}
+	s.set("http.request.method", rec.Method)
+	s.set("server.address", rec.Host)
+	if rec.Path != "" {
+		s.set("url.path", rec.Path)
+	}
+	s.set("client.address", rec.Client)
+	if rec.UserAgent != "" {
+		s.set("user_agent.original", rec.UserAgent)
+	}
+	if rec.Status != 0 {
+		s.set("http.response.status_code", rec.Status)
+	}
+	if rec.RequestID != "" {
+		s.set("proxy.request_id", rec.RequestID)
+	}
+	if rec.Upstream != "" {
+		s.set("proxy.upstream", rec.Upstream)
+	}
+	s.finish(rec.Status == 0 || rec.Status >= 500)
+}
+
+// tracingTransport sends each request upstream in a client span under
+// the request's server span, with a traceparent naming it.
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	parent := spanOf(req)
+	if parent == nil {
+		return t.base.RoundTrip(req)
+	}
+	s := parent.child(req.Method, spanKindClient)
+	req = req.Clone(req.Context())
+	req.Header.Set("Traceparent", s.traceparent())
+
+	resp, err := t.base.RoundTrip(req)
+	s.set("http.request.method", req.Method)
+	s.set("server.address", req.URL.Hostname())
+	if port := req.URL.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err == nil {
+			s.set("server.port", n)
+		}
+	}
+	s.set("url.full", req.URL.String())
+	if err != nil {
+		s.set("error.type", fmt.Sprintf("%T", err))
+		s.finish(true)
+		return resp, err
+	}
+	s.set("http.response.status_code", resp.StatusCode)
+	s.set("network.protocol.version", protocolVersion(resp))
+	s.finish(resp.StatusCode >= 500)
+	return resp, err
+}
+
+// protocolVersion is the HTTP version of resp as OpenTelemetry gives it:
+// 1.1, or 2.
+func protocolVersion(resp *http.Response) string {
+	if resp.ProtoMajor == 1 {
+		return fmt.Sprintf("1.%d", resp.ProtoMinor)
+	}
+	return strconv.Itoa(resp.ProtoMajor)
+}
+
+// wrap returns base with requests traced, or base itself without a
+// tracer.
+func (t *tracer) wrap(base http.RoundTripper) http.RoundTripper {
+	if t == nil {
+		return base
+	}
+	return &tracingTransport{base: base}
+}
+
+// export sends queued spans to the collector in batches.
+func (t *tracer) export(interval time.Duration) {
+	defer close(t.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var batch []*span
+	for {
+		select {
+		case s := <-t.queue:
+			if batch = append(batch, s); len(batch) >= traceBatchSize {
+				t.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			t.send(batch)
+			batch = nil
+		case <-t.stop:
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+			t.send(batch)
+			return
+		}
+	}
+}
+
+// close sends the spans still queued, waiting at most until ctx ends.
+// Spans finished later are dropped.
+func (t *tracer) close(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	t.stopping.Do(func() { close(t.stop) })
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+	}
+}
+
+// send posts spans to the collector as OTLP/HTTP JSON.
+func (t *tracer) send(spans []*span) {
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(t.otlp(spans))
+	if err != nil {
+		log.Printf("[TRACE] Encoding %d spans: %v", len(spans), err)
+		return
+	}
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[TRACE] Exporting %d spans: %v", len(spans), err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[TRACE] Exporting %d spans: collector returned %s", len(spans), resp.Status)
+	}
+}
+
+// otlp is the ExportTraceServiceRequest for spans, in the OTLP JSON
+// encoding: IDs in hex, times as strings of Unix nanoseconds.
+func (t *tracer) otlp(spans []*span) any {
+	encoded := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		e := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.id[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parent != [8]byte{} {
+			e["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		if s.failed {
+			e["status"] = map[string]any{"code": spanStatusError}
+		}
+		s.mu.Unlock()
+		encoded = append(encoded, e)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes([]attribute{{"service.name", t.service}}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "http-proxy"},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(attrs []attribute) []any {
+	encoded := make([]any, 0, len(attrs))
+	for _, a := range attrs {
+		var value map[string]any
+		switch v := a.value.(type) {
+		case int:
+			// 64-bit integers are strings in OTLP JSON
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, map[string]any{"key": a.key, "value": value})
+	}
+	return encoded
+}
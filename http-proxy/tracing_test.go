@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// otlpSpan is a span as the collector receives it.
+type otlpSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Kind         int    `json:"kind"`
+	Attributes   []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+			IntValue    string `json:"intValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+func (s otlpSpan) attribute(key string) string {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.StringValue + a.Value.IntValue
+		}
+	}
+	return ""
+}
+
+// collector is a fake OTLP/HTTP collector that passes on the spans it
+// receives.
+func collector(t *testing.T) (*httptest.Server, chan otlpSpan) {
+	t.Helper()
+	spans := make(chan otlpSpan, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var export struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&export); err != nil {
+			t.Errorf("Decoding export: %v", err)
+		}
+		for _, resource := range export.ResourceSpans {
+			for _, scope := range resource.ScopeSpans {
+				for _, s := range scope.Spans {
+					spans <- s
+				}
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, spans
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header  string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		_, _, sampled, ok := parseTraceparent(tt.header)
+		if ok != tt.ok || sampled != tt.sampled {
+			t.Errorf("parseTraceparent(%q) = sampled %v, ok %v; want %v, %v", tt.header, sampled, ok, tt.sampled, tt.ok)
+		}
+	}
+}
+
+func TestTracing(t *testing.T) {
+	received := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("Traceparent")
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+	target, err := parseUpstream(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otlp, spans := collector(t)
+	interval := traceFlushInterval
+	traceFlushInterval = 50 * time.Millisecond
+	defer func() { traceFlushInterval = interval }()
+	tracer, err := newTracer(otlp.URL, "test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracer.close(context.Background())
+
+	p := &ProxyServer{upstream: singlePool(target), tracer: tracer}
+	p.reverse = newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false)
+	p.reverse.Transport = p.tracer.wrap(p.reverse.Transport)
+	reverse := httptest.NewServer(p)
+	defer reverse.Close()
+	forward := httptest.NewServer(&ProxyServer{tracer: tracer})
+	defer forward.Close()
+	forwardURL, _ := url.Parse(forward.URL)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const parentID = "00f067aa0ba902b7"
+	tests := []struct {
+		name   string
+		client *http.Client
+		url    string
+	}{
+		{"reverse", http.DefaultClient, reverse.URL + "/v1/models"},
+		{"forward", &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(forwardURL)}}, upstream.URL + "/v1/models"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+			req.Header.Set("Traceparent", "00-"+traceID+"-"+parentID+"-01")
+			resp, err := tt.client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			// The upstream sees the proxy's client span as its parent
+			gotTrace, clientID, sampled, ok := parseTraceparent(<-received)
+			if !ok || !sampled || hex.EncodeToString(gotTrace[:]) != traceID {
+				t.Fatalf("Upstream traceparent not in trace %s", traceID)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			var server, client otlpSpan
+			for server.SpanID == "" || client.SpanID == "" {
+				select {
+				case s := <-spans:
+					if s.Kind == spanKindServer {
+						server = s
+					} else {
+						client = s
+					}
+				case <-ctx.Done():
+					t.Fatal("Spans not exported")
+				}
+			}
+			if server.TraceID != traceID || server.ParentSpanID != parentID {
+				t.Errorf("Server span in trace %s under %s, want %s under %s", server.TraceID, server.ParentSpanID, traceID, parentID)
+			}
+			if client.TraceID != traceID || client.ParentSpanID != server.SpanID || client.SpanID != hex.EncodeToString(clientID[:]) {
+				t.Errorf("Client span %s under %s, want %s under the server span %s", client.SpanID, client.ParentSpanID, hex.EncodeToString(clientID[:]), server.SpanID)
+			}
+			if got := client.attribute("http.response.status_code"); got != "200" {
+				t.Errorf("Client span status code = %q, want 200", got)
+			}
+			if got := server.attribute("http.response.status_code"); got != "200" {
+				t.Errorf("Server span status code = %q, want 200", got)
+			}
+			if server.Status.Code != 0 {
+				t.Errorf("Server span status = %d, want unset", server.Status.Code)
+			}
+		})
+	}
+}
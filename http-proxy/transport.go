@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// transportOptions size the connection pools the proxy keeps to upstreams
+// and bound how long it waits for them.
+type transportOptions struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int // 0 for no limit
+	idleConnTimeout     time.Duration
+
+	// Zero for no limit
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+
+	// http1Only keeps every upstream to HTTP/1.1, even those that offer
+	// HTTP/2; h2cHosts are plaintext upstreams spoken to in HTTP/2
+	http1Only bool
+	h2cHosts  hostPatterns
+
+	// decompress has the transport ask upstreams for gzip, on requests
+	// that do not say what they accept, and decompress it
+	decompress bool
+}
+
+// defaultTransportOptions are the flag defaults.
+var defaultTransportOptions = transportOptions{
+	maxIdleConns:        100,
+	maxIdleConnsPerHost: 100,
+	idleConnTimeout:     90 * time.Second,
+
+	tlsHandshakeTimeout: 10 * time.Second,
+}
+
+// newTransport makes a transport to share between requests, so
+// connections to an upstream are kept and reused rather than made for
+// every request. Responses are passed through as they arrive, compressed
+// or not, so streams are not held up, unless options.decompress has it
+// decompress them. Upstreams that offer HTTP/2 in the
+// TLS handshake get it, unless options keep them to HTTP/1.1, and those in
+// options.h2cHosts get it without TLS.
+func newTransport(options transportOptions, tlsConfig *tls.Config) *http.Transport {
+	t := &http.Transport{
+		DisableCompression:  !options.decompress,
+		MaxIdleConns:        options.maxIdleConns,
+		MaxIdleConnsPerHost: options.maxIdleConnsPerHost,
+		MaxConnsPerHost:     options.maxConnsPerHost,
+		IdleConnTimeout:     options.idleConnTimeout,
+		// Its own copy, as the transport adds h2 to the protocols offered
+		TLSClientConfig: tlsConfig.Clone(),
+
+		TLSHandshakeTimeout:   options.tlsHandshakeTimeout,
+		ResponseHeaderTimeout: options.responseHeaderTimeout,
+	}
+	t.Protocols = new(http.Protocols)
+	t.Protocols.SetHTTP1(true)
+	t.Protocols.SetHTTP2(!options.http1Only)
+	if len(options.h2cHosts) > 0 {
+		t.RegisterProtocol("http", newH2CTransport(t, options.h2cHosts))
+	}
+	return t
+}
+
+// h2cTransport sends plain HTTP requests to hosts in HTTP/2 without TLS,
+// with prior knowledge, for -upstream-h2c. Requests to other hosts, and
+// upgrades such as WebSockets, which HTTP/2 cannot carry, are left to the
+// HTTP/1.1 transport it is registered with.
+type h2cTransport struct {
+	hosts hostPatterns
+	h2c   *http.Transport
+}
+
+// newH2CTransport makes an h2cTransport for parent, with its pool sizes
+// and timeouts, that connects as parent does, whatever DialContext
+// parent is given later.
+func newH2CTransport(parent *http.Transport, hosts hostPatterns) *h2cTransport {
+	h2c := parent.Clone()
+	h2c.Protocols = new(http.Protocols)
+	h2c.Protocols.SetUnencryptedHTTP2(true)
+	h2c.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if parent.DialContext != nil {
+			return parent.DialContext(ctx, network, addr)
+		}
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return &h2cTransport{hosts: hosts, h2c: h2c}
+}
+
+func (t *h2cTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.hosts.match(req.URL.Host) || req.Header.Get("Upgrade") != "" {
+		return nil, http.ErrSkipAltProtocol
+	}
+	return t.h2c.RoundTrip(req)
+}
+
+// setTransports makes the forward-mode transports: one for plain requests
+// and one that presents the upstream client certificate to upstreamHosts,
+// or each per_host host's own. They are separate so a certificate is
+// never offered to other hosts.
+func (p *ProxyServer) setTransports(options transportOptions) {
+	p.transport = newTransport(options, nil)
+	p.transport.DialContext = p.dialContext
+	originating := newTransport(options, p.upstreamTLS)
+	originating.DialContext = p.dialContext
+	p.originating = originating
+	if len(p.hostTLS) > 0 {
+		p.originating = newHostTransport(originating, p.hostTLS)
+	}
+}
+
+// forwardTransport returns the transport for a forward-mode request,
+// making the transports with the default options if setTransports was
+// not called.
+func (p *ProxyServer) forwardTransport(originate bool) http.RoundTripper {
+	p.transportsOnce.Do(func() {
+		if p.transport == nil {
+			p.setTransports(defaultTransportOptions)
+		}
+	})
+	if originate {
+		return p.originating
+	}
+	return p.transport
+}
+
+// hostTransport sends requests to the hosts of each hostTLS rule on a
+// transport with the rule's TLS settings, and others on fallback, so each
+// upstream gets the client certificate and CA of its own PKI. Each
+// transport keeps its own connections.
+type hostTransport struct {
+	rules      hostTLS
+	transports []*http.Transport // one per rule
+	fallback   *http.Transport
+}
+
+// newHostTransport makes a hostTransport whose transports are copies of
+// fallback, with the rules' TLS settings.
+func newHostTransport(fallback *http.Transport, rules hostTLS) *hostTransport {
+	t := &hostTransport{rules: rules, fallback: fallback}
+	for _, rule := range rules {
+		transport := fallback.Clone()
+		transport.TLSClientConfig = rule.config.Clone()
+		t.transports = append(t.transports, transport)
+	}
+	return t
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for i, rule := range t.rules {
+		if rule.hosts.match(req.URL.Host) {
+			return t.transports[i].RoundTrip(req)
+		}
+	}
+	return t.fallback.RoundTrip(req)
+}
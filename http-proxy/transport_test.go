@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func TestForwardConnectionsPooled(t *testing.T) {
+	var conns atomic.Int64
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	proxy := httptest.NewServer(&ProxyServer{})
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for range 5 {
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("upstream got %d connections for 5 requests, want 1", n)
+	}
+}
+
+func TestUpstreamHTTP2(t *testing.T) {
+	proto := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	})
+	get := func(transport http.RoundTripper, url string, header http.Header) string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != resp.Proto {
+			t.Errorf("upstream saw %s, response was %s", body, resp.Proto)
+		}
+		return resp.Proto
+	}
+
+	// Over TLS, HTTP/2 if the upstream offers it, unless kept to HTTP/1.1
+	tlsUpstream := httptest.NewUnstartedServer(proto)
+	tlsUpstream.EnableHTTP2 = true
+	tlsUpstream.StartTLS()
+	defer tlsUpstream.Close()
+	roots := tlsUpstream.Client().Transport.(*http.Transport).TLSClientConfig
+	config := &tls.Config{RootCAs: roots.RootCAs}
+	if got := get(newTransport(defaultTransportOptions, config), tlsUpstream.URL, nil); got != "HTTP/2.0" {
+		t.Errorf("TLS upstream offering h2: got %s", got)
+	}
+	if len(config.NextProtos) > 0 {
+		t.Errorf("the shared TLS config was changed to offer %v", config.NextProtos)
+	}
+	http1 := defaultTransportOptions
+	http1.http1Only = true
+	if got := get(newTransport(http1, config), tlsUpstream.URL, nil); got != "HTTP/1.1" {
+		t.Errorf("with http1Only: got %s", got)
+	}
+
+	// Without TLS, h2c to the hosts listed, connecting as the transport does
+	h2cUpstream := httptest.NewUnstartedServer(proto)
+	h2cUpstream.Config.Protocols = new(http.Protocols)
+	h2cUpstream.Config.Protocols.SetHTTP1(true)
+	h2cUpstream.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2cUpstream.Start()
+	defer h2cUpstream.Close()
+	h2c := defaultTransportOptions
+	h2c.h2cHosts = parseHostPatterns("backend.test")
+	transport := newTransport(h2c, nil)
+	var dials atomic.Int64
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		dials.Add(1)
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, h2cUpstream.Listener.Addr().String())
+	}
+	if got := get(transport, "http://backend.test/", nil); got != "HTTP/2.0" {
+		t.Errorf("h2c host: got %s", got)
+	}
+	if dials.Load() != 1 {
+		t.Errorf("h2c connection made without the transport's dialer")
+	}
+	if got := get(transport, "http://other.test/", nil); got != "HTTP/1.1" {
+		t.Errorf("other plaintext host: got %s", got)
+	}
+	// Upgrades need HTTP/1.1
+	if got := get(transport, "http://backend.test/", http.Header{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}}); got != "HTTP/1.1" {
+		t.Errorf("upgrade to h2c host: got %s", got)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Why a tunnel ended, for its access record: whichever happened first.
+const (
+	closedByClient = "client_closed" // the client finished sending
+	closedByServer = "server_closed" // the server did
+	closedIdle     = "idle_timeout"
+	closedShutdown = "shutdown" // cut off when the drain timed out
+	closedError    = "error"    // a reset or other failure on either side
+)
+
+// tunnelEnd is how a tunnel ended: the reason, and for closedError, the
+// error.
+type tunnelEnd struct {
+	reason string
+	err    error
+}
+
+// tunnel copies between client and target until both directions have
+// finished, and returns the bytes sent each way and why it ended. When one
+// side stops sending, the other is told with a half-close, so a response
+// still on its way back is not cut short. A tunnel with no traffic either
+// way for p.tunnelIdle is closed.
+//
+// Between TCP connections on Linux the copying is left to the kernel,
+// with splice, so the bytes never pass through the proxy; that takes
+// copying from and to the connections themselves, not wrappers, after
+// any bytes read ahead of the tunnel. The kernel also says when each last
+// received anything, for the idle timeout. Other connections are copied
+// through a buffer, and their reads timed.
+func (p *ProxyServer) tunnel(client, target net.Conn) (sent, received int64, end tunnelEnd) {
+	defer p.hijacked.track(client, target)()
+
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+	var ended sync.Once
+	endWith := func(reason string, err error) {
+		ended.Do(func() { end = tunnelEnd{reason, err} })
+	}
+
+	done := make(chan struct{}, 2)
+	ahead, clientConn := direct(client)
+	_, targetConn := direct(target)
+	pipe := func(dst, src net.Conn, ahead []io.Reader, n *int64, eof string) {
+		var from io.Reader = src
+		if _, ok := lastReceived(src); !ok && p.tunnelIdle > 0 {
+			from = activityReader{src, &lastActive}
+		}
+		copied, err := io.Copy(dst, io.MultiReader(ahead...))
+		if err == nil {
+			*n, err = io.Copy(dst, from)
+		}
+		*n += copied
+		if err != nil {
+			// A reset or a timeout: nothing more will get through
+			if p.hijacked.cutOff() {
+				endWith(closedShutdown, nil)
+			} else {
+				endWith(closedError, err)
+			}
+			client.Close()
+			target.Close()
+		} else {
+			endWith(eof, nil)
+			closeWrite(dst)
+		}
+		done <- struct{}{}
+	}
+	go pipe(targetConn, clientConn, ahead, &sent, closedByClient)
+	go pipe(clientConn, targetConn, nil, &received, closedByServer)
+
+	// The kernel knows when a TCP connection last received data
+	idleFor := func() time.Duration {
+		idle := time.Since(time.Unix(0, lastActive.Load()))
+		for _, conn := range []net.Conn{clientConn, targetConn} {
+			if since, ok := lastReceived(conn); ok {
+				idle = min(idle, since)
+			}
+		}
+		return idle
+	}
+
+	var idle <-chan time.Time
+	if p.tunnelIdle > 0 {
+		ticker := time.NewTicker(min(p.tunnelIdle/4, time.Second))
+		defer ticker.Stop()
+		idle = ticker.C
+	}
+	for running := 2; running > 0; {
+		select {
+		case <-done:
+			running--
+		case <-idle:
+			if idleFor() >= p.tunnelIdle {
+				log.Printf("[TUNNEL] Closing tunnel from %s to %s: idle for %v", client.RemoteAddr(), target.RemoteAddr(), p.tunnelIdle)
+				endWith(closedIdle, nil)
+				client.Close()
+				target.Close()
+				idle = nil
+			}
+		}
+	}
+	return sent, received, end
+}
+
+// readAheadConn is a connection that wraps another whose first bytes it
+// read into a buffer, such as to find a PROXY protocol header or a
+// ClientHello.
+type readAheadConn interface {
+	// readAhead returns the bytes read that have yet to be read from it,
+	// and the connection beneath
+	readAhead() (io.Reader, net.Conn)
+}
+
+// direct returns the connection beneath conn's wrappers, for a tunnel to
+// copy from and to, with the bytes they had read from it ahead, to be
+// sent first.
+func direct(conn net.Conn) ([]io.Reader, net.Conn) {
+	var ahead []io.Reader
+	for {
+		c, ok := conn.(readAheadConn)
+		if !ok {
+			return ahead, conn
+		}
+		var r io.Reader
+		r, conn = c.readAhead()
+		ahead = append(ahead, r)
+	}
+}
+
+// closeWrite tells the other end of conn that nothing more will be sent,
+// while still reading what it sends. Connections that cannot half-close
+// are closed.
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// activityReader records when data was last read through it.
+type activityReader struct {
+	net.Conn
+	last *atomic.Int64
+}
+
+func (r activityReader) Read(b []byte) (int, error) {
+	n, err := r.Conn.Read(b)
+	if n > 0 {
+		r.last.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// connectThrough opens a CONNECT tunnel to target through the proxy at
+// addr.
+func connectThrough(t *testing.T, addr, target string) (*net.TCPConn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT %s: got %s", target, resp.Status)
+	}
+	return conn.(*net.TCPConn), reader
+}
+
+func TestTunnelHalfClose(t *testing.T) {
+	// The upstream answers only once the client has finished sending
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, _ := io.ReadAll(conn)
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprintf(conn, "got %q", request)
+	}()
+
+	proxy := httptest.NewServer(&ProxyServer{})
+	defer proxy.Close()
+	conn, reader := connectThrough(t, proxy.Listener.Addr().String(), listener.Addr().String())
+	io.WriteString(conn, "hello")
+	conn.CloseWrite()
+	if response, err := io.ReadAll(reader); err != nil || string(response) != `got "hello"` {
+		t.Errorf("got %q, %v; want the upstream's answer after the half-close", response, err)
+	}
+}
+
+func TestTunnelIdleTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	proxy := httptest.NewServer(&ProxyServer{tunnelIdle: 200 * time.Millisecond})
+	defer proxy.Close()
+	conn, reader := connectThrough(t, proxy.Listener.Addr().String(), listener.Addr().String())
+
+	// Traffic keeps the tunnel open past the timeout
+	for range 4 {
+		io.WriteString(conn, "ping")
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			t.Fatalf("tunnel closed while in use: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("idle tunnel: got %v, want EOF", err)
+	}
+	// The kernel, which times TCP tunnels on Linux, counts in jiffies
+	if waited := time.Since(start); waited < 80*time.Millisecond {
+		t.Errorf("idle tunnel closed after %v, want about 200ms", waited)
+	}
+}
+
+func TestTunnelAudit(t *testing.T) {
+	// The upstream ends each tunnel as its first line asks
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				switch line {
+				case "close\n":
+					io.WriteString(conn, "bye")
+				case "reset\n":
+					conn.(*net.TCPConn).SetLinger(0)
+				case "wait\n":
+					io.Copy(io.Discard, conn)
+					io.WriteString(conn, "done")
+				}
+			}()
+		}
+	}()
+
+	out := make(lines, 10)
+	accessLog, _ := newAccessLogger("json", out)
+	proxy := httptest.NewServer(&ProxyServer{accessLog: accessLog, tunnelIdle: 300 * time.Millisecond})
+	defer proxy.Close()
+
+	for _, tt := range []struct {
+		send, want string
+		halfClose  bool
+	}{
+		{"wait\n", closedByClient, true},
+		{"close\n", closedByServer, false},
+		{"reset\n", closedError, false},
+		{"", closedIdle, false},
+	} {
+		conn, reader := connectThrough(t, proxy.Listener.Addr().String(), listener.Addr().String())
+		io.WriteString(conn, tt.send)
+		if tt.halfClose {
+			conn.CloseWrite()
+		}
+		received, _ := io.ReadAll(reader)
+		conn.Close()
+
+		var rec accessRecord
+		if err := json.Unmarshal([]byte(out.next(t)), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.CloseReason != tt.want || rec.BytesIn != int64(len(tt.send)) || rec.BytesOut != int64(len(received)) ||
+			rec.Upstream != listener.Addr().String() || (tt.want == closedError) != (rec.CloseError != "") {
+			t.Errorf("%q: got %s %q, %d bytes in, %d out; want %s, %d in, %d out",
+				tt.send, rec.CloseReason, rec.CloseError, rec.BytesIn, rec.BytesOut, tt.want, len(tt.send), len(received))
+		}
+	}
+}
+
+func TestDirect(t *testing.T) {
+	// A ClientHello replayed, over a hijacked connection's buffer, over a
+	// PROXY protocol header's
+	client, server := net.Pipe()
+	defer client.Close()
+	proxied := &proxiedConn{Conn: server, r: bufio.NewReader(io.MultiReader(strings.NewReader("3"), server))}
+	proxied.r.Peek(1)
+	hijacked := bufio.NewReader(strings.NewReader("2"))
+	hijacked.Peek(1)
+	replay := &replayConn{Conn: proxied, read: bytes.NewReader([]byte("1")), buffered: hijacked}
+
+	ahead, conn := direct(replay)
+	if conn != server {
+		t.Fatalf("direct gave %T, want the connection beneath", conn)
+	}
+	// What was read ahead comes first, without waiting on the connection
+	if got, err := io.ReadAll(io.MultiReader(ahead...)); string(got) != "123" || err != nil {
+		t.Errorf("read ahead %q, %v; want 123", got, err)
+	}
+	go io.WriteString(client, "4")
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(conn, buf); string(buf) != "4" || err != nil {
+		t.Errorf("then read %q, %v; want 4", buf, err)
+	}
+
+	if ahead, conn := direct(client); len(ahead) != 0 || conn != client {
+		t.Errorf("direct(%T) gave %d readers and %T", client, len(ahead), conn)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unixPrefix marks a -resolve address that is a Unix socket's path.
+const unixPrefix = "unix:"
+
+// unixSocket returns the path of the Unix socket addr names, if it is
+// one, as -resolve gives it.
+func unixSocket(addr string) (string, bool) {
+	return strings.CutPrefix(addr, unixPrefix)
+}
+
+// parseSocketMode parses -listen-unix-mode, file permissions in octal.
+func parseSocketMode(mode string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || n > 0o777 {
+		return 0, fmt.Errorf("invalid -listen-unix-mode %q: must be permissions in octal, such as 0660", mode)
+	}
+	return os.FileMode(n), nil
+}
+
+// listenSocket listens on a Unix socket at path, which clients with mode's
+// permissions may connect to. A socket left there by an earlier run is
+// replaced, but not one still in use, or a file that is not a socket.
+// The socket is removed when the listener is closed.
+func listenSocket(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != os.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.sock")
+	listener, err := listenSocket(path, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode: got %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+
+	// One in use is not taken over
+	if _, err := listenSocket(path, 0o600); err == nil {
+		t.Error("took over a socket in use")
+	}
+	// but one left behind is
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	listener, err = listenSocket(path, 0o660)
+	if err != nil {
+		t.Fatalf("socket left behind: %v", err)
+	}
+	listener.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket not removed on close: %v", err)
+	}
+
+	// and other files are left alone
+	os.WriteFile(path, []byte("data"), 0o600)
+	if _, err := listenSocket(path, 0o600); err == nil {
+		t.Error("replaced a file that is not a socket")
+	}
+
+	for _, bad := range []string{"rw", "999", "1777"} {
+		if _, err := parseSocketMode(bad); err == nil {
+			t.Errorf("parseSocketMode(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	// An upstream on a Unix socket, reached by -resolve
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "backend for "+r.Host+r.URL.Path)
+	}))
+	backendListener, err := net.Listen("unix", filepath.Join(dir, "backend.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.Listener = backendListener
+	backend.Start()
+	defer backend.Close()
+	rules, err := parseResolve("backend.internal=unix:" + filepath.Join(dir, "backend.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _ := parseUpstream("http://backend.internal/v1")
+	p := &ProxyServer{resolve: rules, upstream: singlePool(target)}
+	transport := newTransport(defaultTransportOptions, nil)
+	transport.DialContext = p.dialContext
+	p.reverse = newReverseProxy(transport, nil, false)
+
+	// served on a Unix socket, which -allow-clients does not apply to
+	p.allowClients, _ = parseClientRanges("10.0.0.0/8")
+	listener, err := listenSocket(filepath.Join(dir, "proxy.sock"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewUnstartedServer(p)
+	proxy.Listener = &clientListener{Listener: listener, proxy: p, name: "http"}
+	proxy.Start()
+	defer proxy.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", filepath.Join(dir, "proxy.sock"))
+		},
+	}}
+	resp, err := client.Get("http://proxy/models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "backend for backend.internal/v1/models" {
+		t.Errorf("got %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"mtls"
+)
+
+// UpstreamTLS is the upstream_tls section of -config: the client
+// certificate and CA for TLS to upstreams, as with -upstream-cert,
+// -upstream-key, -key-pass, -upstream-ca and -upstream-renew-url, and
+// those of the hosts in PerHost that have their own.
+type UpstreamTLS struct {
+	Cert     string    `yaml:"cert"`
+	Key      string    `yaml:"key"`
+	KeyPass  string    `yaml:"key_pass"`
+	CA       string    `yaml:"ca"`
+	RenewURL string    `yaml:"renew_url"`
+	PerHost  []HostTLS `yaml:"per_host"`
+
+	hosts hostTLS
+}
+
+// HostTLS is the client certificate and CA for TLS to upstreams matching
+// Hosts, in place of the default ones. Either may be left out: with no
+// certificate none is presented, and with no CA the system roots verify
+// the upstream. The certificate is renewed from RenewURL if given.
+type HostTLS struct {
+	Hosts    []string `yaml:"hosts"`
+	Cert     string   `yaml:"cert"`
+	Key      string   `yaml:"key"`
+	KeyPass  string   `yaml:"key_pass"`
+	CA       string   `yaml:"ca"`
+	RenewURL string   `yaml:"renew_url"`
+}
+
+func (u *UpstreamTLS) setDefaults() error {
+	for i, h := range u.PerHost {
+		hosts := parseHostPatterns(strings.Join(h.Hosts, ","))
+		if len(hosts) == 0 {
+			return fmt.Errorf("per_host %d has no hosts", i+1)
+		}
+		if h.RenewURL != "" && h.Cert == "" {
+			return fmt.Errorf("per_host %d: renew_url needs a cert to renew", i+1)
+		}
+		config, err := loadUpstreamTLS(h.Cert, h.Key, h.KeyPass, h.CA)
+		if err != nil {
+			return fmt.Errorf("per_host %d: %w", i+1, err)
+		}
+		u.hosts = append(u.hosts, hostTLSRule{hosts: hosts, config: config})
+	}
+	return nil
+}
+
+// hostTLS gives upstreams TLS settings by host: those of the first rule
+// whose hosts match.
+type hostTLS []hostTLSRule
+
+type hostTLSRule struct {
+	hosts  hostPatterns
+	config *tls.Config
+}
+
+// lookup returns the TLS settings for hostport, or nil if no rule matches.
+func (rules hostTLS) lookup(hostport string) *tls.Config {
+	for _, rule := range rules {
+		if rule.hosts.match(hostport) {
+			return rule.config
+		}
+	}
+	return nil
+}
+
+// loadUpstreamTLS builds the TLS configuration the proxy uses when it
+// originates TLS to an upstream: the client certificate in certFile and
+// keyFile, if given, with the passphrase of the key from keyPass, and the
+// CA bundle in caFile to verify the upstream, or the system roots if
+// caFile is empty.
+func loadUpstreamTLS(certFile, keyFile, keyPass, caFile string) (*tls.Config, error) {
+	config, err := mtls.LoadClientTLSConfig(mtls.Config{CertFile: certFile, KeyFile: keyFile, KeyPass: keyPass, CAFile: caFile})
+	if err != nil {
+		return nil, fmt.Errorf("upstream: %w", err)
+	}
+	return config, nil
+}
+
+// hostPatterns matches destination hosts against a list of patterns: an
+// exact name ("api.openai.com"), a wildcard for its subdomains
+// ("*.openai.azure.com") or "*" for any host. A pattern with a port
+// ("localhost:8443") only matches that port.
+type hostPatterns []string
+
+// parseHostPatterns splits a comma-separated list of patterns.
+func parseHostPatterns(list string) hostPatterns {
+	var patterns hostPatterns
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// match reports whether hostport, a host with an optional port, matches
+// any of the patterns.
+func (patterns hostPatterns) match(hostport string) bool {
+	hostport = strings.ToLower(hostport)
+	host, port := hostport, ""
+	if h, p, err := net.SplitHostPort(hostport); err == nil {
+		host, port = h, p
+	}
+
+	for _, pattern := range patterns {
+		name := pattern
+		if h, p, err := net.SplitHostPort(pattern); err == nil {
+			if p != port {
+				continue
+			}
+			name = h
+		}
+		switch {
+		case name == "*" || name == host:
+			return true
+		case strings.HasPrefix(name, "*.") && strings.HasSuffix(host, name[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// originateTLS reports whether the proxy should make its connection for
+// target over TLS presenting the upstream client certificate, and upgrades
+// a plain-HTTP target to HTTPS if so. Applications that cannot do mTLS
+// themselves send plain HTTP to one of the -upstream-hosts, or a per_host
+// host of upstream_tls, and the proxy adds it; the port is kept, so
+// http://gateway:8443 becomes https://gateway:8443.
+func (p *ProxyServer) originateTLS(target *url.URL) bool {
+	if p.originatingTLS(target.Host) == nil {
+		return false
+	}
+	target.Scheme = "https"
+	return true
+}
+
+// originatingTLS returns the TLS settings for a forward-mode connection to
+// hostport that the proxy makes over TLS itself: those of its per_host
+// rule, or of -upstream-cert for -upstream-hosts. It is nil for any other
+// host, whose connection is made as the client asked.
+func (p *ProxyServer) originatingTLS(hostport string) *tls.Config {
+	if config := p.hostTLS.lookup(hostport); config != nil {
+		return config
+	}
+	if p.upstreamTLS != nil && p.upstreamHosts.match(hostport) {
+		return p.upstreamTLS
+	}
+	return nil
+}
+
+// describeClientCert names the client certificate in config for the
+// startup log.
+func describeClientCert(config *tls.Config) string {
+	if len(config.Certificates) == 0 || config.Certificates[0].Leaf == nil {
+		return " (no client certificate)"
+	}
+	leaf := config.Certificates[0].Leaf
+	return " presenting " + leaf.Subject.CommonName + " (" + describeKey(leaf) + ")"
+}
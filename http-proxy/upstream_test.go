@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestHostPatterns(t *testing.T) {
+	patterns := parseHostPatterns("api.openai.com, *.azure.com,localhost:8443")
+	for host, want := range map[string]bool{
+		"api.openai.com":        true,
+		"API.OpenAI.com:443":    true,
+		"openai.com":            false,
+		"x.openai.azure.com":    true,
+		"azure.com":             false,
+		"localhost:8443":        true,
+		"localhost:8000":        false,
+		"localhost":             false,
+		"evil-api.openai.com":   false,
+		"api.openai.com.evil.x": false,
+	} {
+		if got := patterns.match(host); got != want {
+			t.Errorf("match(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if !parseHostPatterns("*").match("anything:1234") {
+		t.Error(`"*" does not match every host`)
+	}
+	if parseHostPatterns("").match("localhost") {
+		t.Error("an empty list matches")
+	}
+}
+
+func TestOriginateTLS(t *testing.T) {
+	pki := newTestPKI(t)
+
+	var presented string
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented = r.TLS.PeerCertificates[0].Subject.CommonName
+		io.WriteString(w, "ok")
+	}))
+	upstream.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	config, err := loadUpstreamTLS(pki.clientCertFile, pki.clientKeyFile, "", pki.caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(upstream.Listener.Addr().String())
+	upstreamHost := "localhost:" + port
+	proxy := httptest.NewServer(&ProxyServer{upstreamTLS: config, upstreamHosts: parseHostPatterns(upstreamHost)})
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get("http://" + upstreamHost + "/v1/models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("got %d %q, want 200 ok", resp.StatusCode, body)
+	}
+	if presented != "proxy-client" {
+		t.Errorf("upstream saw client certificate %q, want proxy-client", presented)
+	}
+}
+
+func TestHostTLS(t *testing.T) {
+	// Two upstreams, each with a PKI of its own that only accepts its own
+	// client certificate
+	upstream := func(pki *testPKI) string {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "ok")
+		}))
+		server.TLS = &tls.Config{
+			Certificates: []tls.Certificate{pki.server},
+			ClientCAs:    pki.pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		}
+		server.StartTLS()
+		t.Cleanup(server.Close)
+		_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+		return "localhost:" + port
+	}
+	pkiA, pkiB := newTestPKI(t), newTestPKI(t)
+	hostA, hostB := upstream(pkiA), upstream(pkiB)
+	config, err := loadConfig(writeConfig(t, `
+upstream_tls:
+  per_host:
+    - hosts: [`+hostA+`]
+      cert: `+pkiA.clientCertFile+`
+      key: `+pkiA.clientKeyFile+`
+      ca: `+pkiA.caFile+`
+    - hosts: [`+hostB+`]
+      cert: `+pkiB.clientCertFile+`
+      key: `+pkiB.clientKeyFile+`
+      ca: `+pkiB.caFile+`
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := config.UpstreamTLS.hosts
+
+	get := func(client *http.Client, target string) {
+		t.Helper()
+		resp, err := client.Get(target)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+			t.Errorf("%s: got %d %q, want 200 ok", target, resp.StatusCode, body)
+		}
+	}
+
+	// Forward mode originates TLS to each with its own certificate and CA
+	forward := httptest.NewServer(&ProxyServer{hostTLS: rules})
+	defer forward.Close()
+	proxyURL, _ := url.Parse(forward.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	get(client, "http://"+hostA+"/v1/models")
+	get(client, "http://"+hostB+"/v1/models")
+
+	// Reverse mode does the same for the routes' upstreams
+	transport := newTransport(defaultTransportOptions, nil)
+	reverse := &ProxyServer{
+		reverse: newReverseProxy(transport, nil, false),
+		routes: []Route{
+			{Path: "/a", pool: singlePool(&url.URL{Scheme: "https", Host: hostA})},
+			{Path: "/b", pool: singlePool(&url.URL{Scheme: "https", Host: hostB})},
+		},
+	}
+	reverse.reverse.Transport = newHostTransport(transport, rules)
+	server := httptest.NewServer(reverse)
+	defer server.Close()
+	get(http.DefaultClient, server.URL+"/a")
+	get(http.DefaultClient, server.URL+"/b")
+
+	// And health checks pass with them
+	for _, check := range []HealthCheck{{Type: checkHTTP, Path: "/"}, {Type: checkTLS}} {
+		checker := newHealthChecker(check, nil, nil)
+		checker.useHostTLS(rules)
+		for _, host := range []string{hostA, hostB} {
+			if err := checker.probe(context.Background(), &url.URL{Scheme: "https", Host: host}, nil); err != nil {
+				t.Errorf("%s check of %s: %v", check.Type, host, err)
+			}
+		}
+	}
+
+	// Other hosts get neither certificate
+	if (&ProxyServer{hostTLS: rules}).originatingTLS("api.openai.com:443") != nil {
+		t.Error("a host with no rule gets TLS settings")
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"openaitypes"
+)
+
+// UsageAccounting counts the tokens each client uses of each model, from
+// the usage that reverse-proxied responses report, and prices them.
+type UsageAccounting struct {
+	// Client tells clients apart: ip (the default), cert or
+	// header:<name>, as -rate-key does
+	Client string `yaml:"client"`
+
+	// StreamUsage asks for usage in streamed completions that do not, by
+	// setting stream_options.include_usage, so that they can be counted.
+	// Their clients then get a last chunk with the usage and no choices.
+	StreamUsage bool `yaml:"stream_usage"`
+
+	// Prices are tried in order, and the first whose model matches prices
+	// a response. Models without a price are counted but cost nothing.
+	Prices []Price `yaml:"prices"`
+}
+
+// Price is what a model costs in dollars per million tokens. CachedInput,
+// for input tokens the upstream had cached, defaults to Input.
+type Price struct {
+	Model       string   `yaml:"model"`
+	Input       float64  `yaml:"input"`
+	CachedInput *float64 `yaml:"cached_input"`
+	Output      float64  `yaml:"output"`
+}
+
+// setDefaults checks u and fills in what it leaves out.
+func (u *UsageAccounting) setDefaults() error {
+	if u.Client == "" {
+		u.Client = "ip"
+	}
+	if !validClientKey(u.Client) {
+		return fmt.Errorf("client %q must be ip, cert or header:<name>", u.Client)
+	}
+	for i, price := range u.Prices {
+		if !validModelPattern(price.Model) {
+			return fmt.Errorf("price %d: invalid model pattern %q", i+1, price.Model)
+		}
+		if price.Input < 0 || price.Output < 0 || price.CachedInput != nil && *price.CachedInput < 0 {
+			return fmt.Errorf("price %d: prices must not be negative", i+1)
+		}
+	}
+	return nil
+}
+
+// cost is what t cost at price, in dollars.
+func (price *Price) cost(t tokens) float64 {
+	cached := price.Input
+	if price.CachedInput != nil {
+		cached = *price.CachedInput
+	}
+	return (float64(t.Input-t.CachedInput)*price.Input + float64(t.CachedInput)*cached + float64(t.Output)*price.Output) / 1e6
+}
+
+// tokenUsage is the usage object of a response. Chat completions and
+// embeddings count prompt and completion tokens, the Responses API input
+// and output tokens.
+type tokenUsage struct {
+	openaitypes.Usage
+
+	InputTokens        int64 `json:"input_tokens"`
+	OutputTokens       int64 `json:"output_tokens"`
+	InputTokensDetails struct {
+		CachedTokens int64 `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+}
+
+// tokens are the tokens of a response, or a total of them. Input counts
+// the cached input tokens too.
+type tokens struct {
+	Input       int64 `json:"input_tokens"`
+	CachedInput int64 `json:"cached_input_tokens"`
+	Output      int64 `json:"output_tokens"`
+}
+
+func (u *tokenUsage) tokens() *tokens {
+	t := &tokens{
+		Input:       int64(u.PromptTokens) + u.InputTokens,
+		CachedInput: u.InputTokensDetails.CachedTokens,
+		Output:      int64(u.CompletionTokens) + u.OutputTokens,
+	}
+	if u.PromptTokensDetails != nil {
+		t.CachedInput += int64(u.PromptTokensDetails.CachedTokens)
+	}
+	return t
+}
+
+// usageMeter adds up the tokens and cost of reverse-proxied requests by
+// client and model. A nil *usageMeter counts nothing.
+type usageMeter struct {
+	config  UsageAccounting
+	since   time.Time
+	metrics *metrics
+
+	mu     sync.Mutex
+	totals map[usageKey]*usageTotal
+}
+
+type usageKey struct {
+	client, model string
+}
+
+// usageTotal is what a client has used of a model.
+type usageTotal struct {
+	Client   string `json:"client"`
+	Model    string `json:"model"`
+	Requests int64  `json:"requests"`
+	tokens
+	CostUSD float64 `json:"cost_usd"`
+
+	// Unreported counts responses that gave no usage, such as streams
+	// that did not ask for it, whose tokens are missing from the total
+	Unreported int64 `json:"requests_without_usage,omitempty"`
+}
+
+func newUsageMeter(config UsageAccounting) *usageMeter {
+	return &usageMeter{config: config, since: time.Now(), totals: make(map[usageKey]*usageTotal)}
+}
+
+// add counts the completion, embedding or response rec that r got.
+func (m *usageMeter) add(r *http.Request, rec *completionRecord) {
+	if m == nil || rec == nil || rec.Status < 200 || rec.Status >= 300 {
+		return
+	}
+	key := usageKey{identifyClient(r, m.config.Client), rec.Model}
+	if key.model == "" {
+		key.model = "unknown"
+	}
+	var cost float64
+	if rec.Usage != nil {
+		if price := m.price(key.model); price != nil {
+			cost = price.cost(*rec.Usage)
+		}
+		m.metrics.tokensUsed(key.client, key.model, *rec.Usage, cost)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	total := m.totals[key]
+	if total == nil {
+		total = &usageTotal{Client: key.client, Model: key.model}
+		m.totals[key] = total
+	}
+	total.Requests++
+	if rec.Usage == nil {
+		total.Unreported++
+		return
+	}
+	total.Input += rec.Usage.Input
+	total.CachedInput += rec.Usage.CachedInput
+	total.Output += rec.Usage.Output
+	total.CostUSD += cost
+}
+
+// price returns the first price for model, or nil.
+func (m *usageMeter) price(model string) *Price {
+	for i := range m.config.Prices {
+		if matchModel(m.config.Prices[i].Model, model) {
+			return &m.config.Prices[i]
+		}
+	}
+	return nil
+}
+
+// report lists the totals by client, then model.
+func (m *usageMeter) report() []usageTotal {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	totals := make([]usageTotal, 0, len(m.totals))
+	for _, total := range m.totals {
+		totals = append(totals, *total)
+	}
+	slices.SortFunc(totals, func(a, b usageTotal) int {
+		if c := strings.Compare(a.Client, b.Client); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Model, b.Model)
+	})
+	return totals
+}
+
+// streamsUsage reports whether streamed completions should be made to
+// report their usage.
+func (m *usageMeter) streamsUsage() bool {
+	return m != nil && m.config.StreamUsage
+}
+
+// includeStreamUsage sets stream_options.include_usage in body, a
+// completion request, if it streams and does not set it already. It
+// returns nil if body needs no change.
+func includeStreamUsage(body []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	var stream bool
+	json.Unmarshal(fields["stream"], &stream)
+	var options map[string]json.RawMessage
+	json.Unmarshal(fields["stream_options"], &options)
+	if !stream || options["include_usage"] != nil {
+		return nil, nil
+	}
+	if options == nil {
+		options = make(map[string]json.RawMessage)
+	}
+	options["include_usage"] = json.RawMessage("true")
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+	fields["stream_options"] = encoded
+	return json.Marshal(fields)
+}
+
+// adminUsageHandler reports the tokens and cost of each client's use of
+// each model since the proxy started.
+func (p *ProxyServer) adminUsageHandler(w http.ResponseWriter, r *http.Request) {
+	if p.usage == nil {
+		http.Error(w, "Usage accounting is off: add usage to -config", http.StatusNotFound)
+		return
+	}
+	totals := p.usage.report()
+	var cost float64
+	for _, total := range totals {
+		cost += total.CostUSD
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"since": p.usage.since, "cost_usd": cost, "usage": totals})
+}
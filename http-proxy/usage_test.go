@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"openai-mock-server/mockserver"
+)
+
+func TestUsageAccounting(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Stream        bool `json:"stream"`
+			StreamOptions struct {
+				IncludeUsage bool `json:"include_usage"`
+			} `json:"stream_options"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case r.URL.Path == "/v1/responses":
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, `data: {"type":"response.completed","response":{"id":"resp_1","model":"gpt-4o-mini","output":[],"usage":{"input_tokens":1000,"output_tokens":500,"input_tokens_details":{"cached_tokens":0}}}}`+"\n\n")
+		case req.Stream:
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, `data: {"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"Hi"}}]}`+"\n\n")
+			if req.StreamOptions.IncludeUsage {
+				io.WriteString(w, `data: {"id":"c1","model":"gpt-4o","choices":[],"usage":{"prompt_tokens":100,"completion_tokens":10}}`+"\n\n")
+			}
+			io.WriteString(w, "data: [DONE]\n\n")
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"id":"c2","model":"gpt-4o","choices":[],"usage":{"prompt_tokens":2000,"completion_tokens":100,"prompt_tokens_details":{"cached_tokens":1000}}}`)
+		}
+	}))
+	defer upstream.Close()
+	target, err := parseUpstream(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cached := 1.25
+	config := UsageAccounting{
+		Client:      "header:X-Team",
+		StreamUsage: true,
+		Prices:      []Price{{Model: "gpt-4o-mini*", Input: 0.15, Output: 0.6}, {Model: "gpt-4o*", Input: 2.5, CachedInput: &cached, Output: 10}},
+	}
+	if err := config.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	p := &ProxyServer{upstream: singlePool(target), usage: newUsageMeter(config), metrics: newMetrics()}
+	p.usage.metrics = p.metrics
+	p.reverse = newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false)
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	for _, request := range []struct{ path, body string }{
+		{"/v1/chat/completions", `{"model":"gpt-4o","stream":true}`},
+		{"/v1/chat/completions", `{"model":"gpt-4o"}`},
+		{"/v1/responses", `{"model":"gpt-4o-mini","stream":true}`},
+	} {
+		req, _ := http.NewRequest(http.MethodPost, proxy.URL+request.path, strings.NewReader(request.body))
+		req.Header.Set("X-Team", "search")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	w := httptest.NewRecorder()
+	p.adminUsageHandler(w, httptest.NewRequest(http.MethodGet, "/admin/usage", nil))
+	var report struct {
+		CostUSD float64      `json:"cost_usd"`
+		Usage   []usageTotal `json:"usage"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Usage) != 2 {
+		t.Fatalf("Usage %+v, want gpt-4o and gpt-4o-mini", report.Usage)
+	}
+	// gpt-4o: a stream (100 in, 10 out), then 1000 uncached and 1000
+	// cached in, 100 out
+	gpt4o := report.Usage[0]
+	want := usageTotal{Client: "header search", Model: "gpt-4o", Requests: 2, tokens: tokens{Input: 2100, CachedInput: 1000, Output: 110}}
+	if gpt4o.Client != want.Client || gpt4o.Model != want.Model || gpt4o.Requests != want.Requests || gpt4o.tokens != want.tokens {
+		t.Errorf("Usage %+v, want %+v", gpt4o, want)
+	}
+	if wantCost := (1100*2.5 + 1000*1.25 + 110*10) / 1e6; math.Abs(gpt4o.CostUSD-wantCost) > 1e-12 {
+		t.Errorf("gpt-4o cost %v, want %v", gpt4o.CostUSD, wantCost)
+	}
+	mini := report.Usage[1]
+	if mini.Model != "gpt-4o-mini" || mini.Input != 1000 || mini.Output != 500 {
+		t.Errorf("Usage %+v, want 1000 in and 500 out of gpt-4o-mini", mini)
+	}
+	if wantCost := gpt4o.CostUSD + (1000*0.15+500*0.6)/1e6; math.Abs(report.CostUSD-wantCost) > 1e-12 {
+		t.Errorf("Total cost %v, want %v", report.CostUSD, wantCost)
+	}
+
+	body := scrape(t, p)
+	for _, want := range []string{
+		`http_proxy_tokens_total{client="header search",model="gpt-4o",type="input"} 2100`,
+		`http_proxy_tokens_total{client="header search",model="gpt-4o",type="cached_input"} 1000`,
+		`http_proxy_tokens_total{client="header search",model="gpt-4o-mini",type="output"} 500`,
+		`http_proxy_cost_dollars_total{client="header search",model="gpt-4o-mini"} `,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Metrics missing %s", want)
+		}
+	}
+}
+
+func TestIncludeStreamUsage(t *testing.T) {
+	tests := []struct {
+		body, want string
+	}{
+		{`{"model":"gpt-4o","stream":true}`, `{"model":"gpt-4o","stream":true,"stream_options":{"include_usage":true}}`},
+		{`{"stream":true,"stream_options":{"x":1}}`, `{"stream":true,"stream_options":{"include_usage":true,"x":1}}`},
+		{`{"stream":true,"stream_options":{"include_usage":false}}`, ""},
+		{`{"model":"gpt-4o"}`, ""},
+	}
+	for _, tt := range tests {
+		got, err := includeStreamUsage([]byte(tt.body))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("includeStreamUsage(%s) = %s, %v; want %s", tt.body, got, err, tt.want)
+		}
+	}
+}
+
+// TestStreamUsageFromMock streams a completion from the mock server through
+// the proxy, which asks for the usage the client did not and counts it.
+func TestStreamUsageFromMock(t *testing.T) {
+	upstream := httptest.NewServer(mockserver.New(mockserver.Config{}))
+	defer upstream.Close()
+	target, err := parseUpstream(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := UsageAccounting{Client: "header:X-Team", StreamUsage: true}
+	if err := config.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	p := &ProxyServer{upstream: singlePool(target), usage: newUsageMeter(config), metrics: newMetrics()}
+	p.usage.metrics = p.metrics
+	p.reverse = newReverseProxy(newTransport(defaultTransportOptions, nil), nil, false)
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, proxy.URL+"/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Hello"}]}`))
+	req.Header.Set("X-Team", "search")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// The usage the mock reported, in the last chunk before [DONE]
+	var usage *mockserver.Usage
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		payload, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok || payload == "[DONE]" {
+			continue
+		}
+		var chunk mockserver.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			t.Fatalf("chunk %s: %v", payload, err)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+	}
+	if usage == nil || usage.PromptTokens == 0 || usage.CompletionTokens == 0 {
+		t.Fatalf("mock reported usage %+v", usage)
+	}
+
+	w := httptest.NewRecorder()
+	p.adminUsageHandler(w, httptest.NewRequest(http.MethodGet, "/admin/usage", nil))
+	var report struct {
+		Usage []usageTotal `json:"usage"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	want := tokens{Input: int64(usage.PromptTokens), Output: int64(usage.CompletionTokens)}
+	if len(report.Usage) != 1 || report.Usage[0].Client != "header search" || report.Usage[0].Model != "gpt-4o" || report.Usage[0].Requests != 1 || report.Usage[0].tokens != want {
+		t.Errorf("Usage %+v, want %+v", report.Usage, want)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// upgradeType returns the protocol a request or response asks to switch
+// to, such as "websocket", or "" if it does not. Upgrade is only honoured
+// when Connection names it, as RFC 9110 requires.
+func upgradeType(h http.Header) string {
+	for _, value := range h.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return h.Get("Upgrade")
+			}
+		}
+	}
+	return ""
+}
+
+// switchProtocols completes an upgrade that the upstream accepted with
+// 101 Switching Protocols, such as a WebSocket handshake, and then copies
+// frames both ways until either side closes. The upstream's side of the
+// connection is resp.Body, which the transport makes writable for 101
+// responses.
+func (p *ProxyServer) switchProtocols(w http.ResponseWriter, r *http.Request, resp *http.Response) {
+	upstream, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		log.Printf("[ERROR] Upgrade response body is not writable")
+		http.Error(w, "Upgrade failed", http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		log.Printf("[ERROR] Hijacking not supported")
+		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("[ERROR] Failed to hijack connection: %v", err)
+		return
+	}
+	defer clientConn.Close()
+	defer p.hijacked.track(clientConn, upstream)()
+
+	// The response headers go back as they are: Connection and Upgrade are
+	// hop-by-hop, but this hop is the one being upgraded
+	fmt.Fprintf(buffered, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(buffered)
+	buffered.WriteString("\r\n")
+	if err := buffered.Flush(); err != nil {
+		return
+	}
+	if p.verbose {
+		log.Printf("[UPGRADE] %s connection to %s open", upgradeType(resp.Header), r.Host)
+	}
+
+	rec := recordOf(r)
+	rec.Status = resp.StatusCode
+	defer p.metrics.tunnelOpened(strings.ToLower(upgradeType(resp.Header)))()
+	var sent, received int64
+	done := make(chan struct{}, 2)
+	go func() {
+		// Start with anything the client sent after its request
+		sent, _ = io.Copy(upstream, buffered.Reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		received, _ = io.Copy(clientConn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+	clientConn.Close()
+	upstream.Close()
+	<-done
+	rec.BytesIn += sent
+	rec.BytesOut += received
+
+	if p.verbose {
+		log.Printf("[UPGRADE] Connection to %s closed", r.Host)
+	}
+}
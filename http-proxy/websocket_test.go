@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// echoUpgrade switches to an "echo" protocol that sends back whatever it
+// reads, standing in for a WebSocket server.
+var echoUpgrade = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if upgradeType(r.Header) != "echo" {
+		http.Error(w, "want Upgrade: echo", http.StatusBadRequest)
+		return
+	}
+	conn, buffered, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	buffered.Flush()
+	io.Copy(conn, buffered)
+})
+
+// upgradeThrough sends an upgrade request for target to the proxy at
+// addr, then checks that the switched connection echoes.
+func upgradeThrough(t *testing.T, addr, target string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n", target, addr)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || upgradeType(resp.Header) != "echo" {
+		t.Fatalf("%s: got %s %v, want 101 to echo", target, resp.Status, resp.Header)
+	}
+	// Like a WebSocket client, only send once the switch is confirmed
+	fmt.Fprint(conn, "hello world")
+	buf := make([]byte, len("hello world"))
+	if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != "hello world" {
+		t.Errorf("%s: echoed %q, %v; want hello world", target, buf, err)
+	}
+}
+
+func TestUpgradeType(t *testing.T) {
+	for header, want := range map[string]string{
+		"Upgrade":             "websocket",
+		"keep-alive, UPGRADE": "websocket",
+		"keep-alive":          "",
+		"":                    "",
+	} {
+		h := http.Header{"Upgrade": {"websocket"}}
+		if header != "" {
+			h.Set("Connection", header)
+		}
+		if got := upgradeType(h); got != want {
+			t.Errorf("Connection: %q: got %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestWebSocketForward(t *testing.T) {
+	upstream := httptest.NewServer(echoUpgrade)
+	defer upstream.Close()
+	proxy := httptest.NewServer(&ProxyServer{})
+	defer proxy.Close()
+	upgradeThrough(t, proxy.Listener.Addr().String(), upstream.URL+"/v1/realtime")
+
+	// ws:// to an -upstream-hosts host leaves as wss:// with the certificate
+	pki := newTestPKI(t)
+	tlsUpstream := httptest.NewUnstartedServer(echoUpgrade)
+	tlsUpstream.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	tlsUpstream.StartTLS()
+	defer tlsUpstream.Close()
+	config, err := loadUpstreamTLS(pki.clientCertFile, pki.clientKeyFile, "", pki.caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(tlsUpstream.Listener.Addr().String())
+	upstreamHost := "localhost:" + port
+	originating := httptest.NewServer(&ProxyServer{upstreamTLS: config, upstreamHosts: parseHostPatterns(upstreamHost)})
+	defer originating.Close()
+	upgradeThrough(t, originating.Listener.Addr().String(), "http://"+upstreamHost+"/v1/realtime")
+}
+
+func TestWebSocketReverse(t *testing.T) {
+	pki := newTestPKI(t)
+	upstream := httptest.NewUnstartedServer(echoUpgrade)
+	upstream.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	config, err := loadUpstreamTLS(pki.clientCertFile, pki.clientKeyFile, "", pki.caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := parseUpstream(strings.Replace(upstream.URL, "127.0.0.1", "localhost", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ProxyServer{reverse: newReverseProxy(newTransport(defaultTransportOptions, config), nil, false), upstream: singlePool(target)}
+	policy := RetryPolicy{}
+	if err := policy.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	p.reverse.Transport = newRetryTransport(p.reverse.Transport, policy, false)
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+	upgradeThrough(t, proxy.Listener.Addr().String(), "/v1/realtime")
+}
@@ -54,21 +54,18 @@ echo ""
 echo -e "${BOLD}Checking prerequisites...${NC}"
 
 if [ ! -f "$SCRIPT_DIR/certs/ca.crt" ]; then
-    echo -e "${RED}Error: Certificates not found. Run: cd certs && ./generate.sh${NC}"
+    echo -e "${RED}Error: Certificates not found. Run: cd certs && ./generate.sh (or, without openssl: cd certgen && go run .)${NC}"
     exit 1
 fi
 echo -e "  ${GREEN}✓${NC} Certificates found"
 
-if [ ! -f "$SCRIPT_DIR/openai-mock-server/openai-mock-server" ]; then
-    echo -e "${YELLOW}Building mock server...${NC}"
-    (cd "$SCRIPT_DIR/openai-mock-server" && go build -o openai-mock-server .)
-fi
+# Always build, so a stale binary is never tested
+echo -e "${YELLOW}Building mock server...${NC}"
+(cd "$SCRIPT_DIR/openai-mock-server" && go build -o openai-mock-server .) || exit 1
 echo -e "  ${GREEN}✓${NC} Mock server binary ready"
 
-if [ ! -f "$SCRIPT_DIR/http-proxy/http-proxy" ]; then
-    echo -e "${YELLOW}Building HTTP proxy...${NC}"
-    (cd "$SCRIPT_DIR/http-proxy" && go build -o http-proxy .)
-fi
+echo -e "${YELLOW}Building HTTP proxy...${NC}"
+(cd "$SCRIPT_DIR/http-proxy" && go build -o http-proxy .) || exit 1
 echo -e "  ${GREEN}✓${NC} HTTP proxy binary ready"
 
 if [ ! -d "$SCRIPT_DIR/openai-mtls-no-stream-provider/dist" ]; then
@@ -88,7 +85,7 @@ echo ""
 # ---- Start services ----
 echo -e "${BOLD}Starting mock server (verbose)...${NC}"
 cd "$SCRIPT_DIR/openai-mock-server"
-./openai-mock-server -verbose > /tmp/mock-server-nostream.log 2>&1 &
+./openai-mock-server -verbose -crl ../certs/crl.pem > /tmp/mock-server-nostream.log 2>&1 &
 MOCK_SERVER_PID=$!
 sleep 1
 
@@ -102,7 +99,7 @@ fi
 
 echo -e "${BOLD}Starting HTTP proxy...${NC}"
 cd "$SCRIPT_DIR/http-proxy"
-./http-proxy -verbose > /tmp/http-proxy-nostream.log 2>&1 &
+./http-proxy -verbose -connect-ports 443,8000 > /tmp/http-proxy-nostream.log 2>&1 &
 PROXY_PID=$!
 sleep 1
 
@@ -54,23 +54,19 @@ echo -e "${BOLD}Checking prerequisites...${NC}"
 
 # Check for certificates
 if [ ! -f "$SCRIPT_DIR/certs/ca.crt" ]; then
-    echo -e "${RED}Error: Certificates not found. Run: cd certs && ./generate.sh${NC}"
+    echo -e "${RED}Error: Certificates not found. Run: cd certs && ./generate.sh (or, without openssl: cd certgen && go run .)${NC}"
     exit 1
 fi
 echo -e "  ${GREEN}✓${NC} Certificates found"
 
-# Check for mock server binary
-if [ ! -f "$SCRIPT_DIR/openai-mock-server/openai-mock-server" ]; then
-    echo -e "${YELLOW}Building mock server...${NC}"
-    (cd "$SCRIPT_DIR/openai-mock-server" && go build -o openai-mock-server .)
-fi
+# Build the mock server (always, so a stale binary is never tested)
+echo -e "${YELLOW}Building mock server...${NC}"
+(cd "$SCRIPT_DIR/openai-mock-server" && go build -o openai-mock-server .) || exit 1
 echo -e "  ${GREEN}✓${NC} Mock server binary ready"
 
-# Check for proxy binary
-if [ ! -f "$SCRIPT_DIR/http-proxy/http-proxy" ]; then
-    echo -e "${YELLOW}Building HTTP proxy...${NC}"
-    (cd "$SCRIPT_DIR/http-proxy" && go build -o http-proxy .)
-fi
+# Build the HTTP proxy
+echo -e "${YELLOW}Building HTTP proxy...${NC}"
+(cd "$SCRIPT_DIR/http-proxy" && go build -o http-proxy .) || exit 1
 echo -e "  ${GREEN}✓${NC} HTTP proxy binary ready"
 
 # Check for mTLS provider
@@ -92,7 +88,7 @@ echo ""
 # Start mock server (with verbose mode for removeKeys test)
 echo -e "${BOLD}Starting mock server...${NC}"
 cd "$SCRIPT_DIR/openai-mock-server"
-./openai-mock-server -verbose > /tmp/mock-server.log 2>&1 &
+./openai-mock-server -verbose -crl ../certs/crl.pem > /tmp/mock-server.log 2>&1 &
 MOCK_SERVER_PID=$!
 sleep 1
 
@@ -107,7 +103,7 @@ fi
 # Start HTTP proxy
 echo -e "${BOLD}Starting HTTP proxy...${NC}"
 cd "$SCRIPT_DIR/http-proxy"
-./http-proxy -verbose > /tmp/http-proxy.log 2>&1 &
+./http-proxy -verbose -connect-ports 443,8000 > /tmp/http-proxy.log 2>&1 &
 PROXY_PID=$!
 sleep 1
 
@@ -188,10 +184,8 @@ echo ""
 echo -e "${BOLD}${CYAN}Test 3: Go Test Client -> Proxy -> Mock Server${NC}"
 cd "$SCRIPT_DIR/openai-test-client"
 
-if [ ! -f "./openai-test-client" ]; then
-    echo -e "${YELLOW}Building test client...${NC}"
-    go build -o openai-test-client .
-fi
+echo -e "${YELLOW}Building test client...${NC}"
+go build -o openai-test-client . || exit 1
 
 TEST_OUTPUT=$(./openai-test-client -url https://localhost:8000/v1 -proxy http://localhost:8080 2>&1)
 TEST_EXIT=$?
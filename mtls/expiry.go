@@ -0,0 +1,137 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// CertExpiry is a certificate that was loaded, and when it expires. The
+// tools check those they load as they start, warn of any close to expiry
+// or past it, and list them all with -check-certs.
+type CertExpiry struct {
+	Use      string    `json:"use"`
+	File     string    `json:"file"`
+	Subject  string    `json:"subject"`
+	Serial   string    `json:"serial"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// ReadCertExpiry reads the certificates in certFile, loaded for use: a PEM
+// file or bundle, or a PKCS #12 bundle opened with the passphrase from
+// keyFile or else keyPass, as LoadKeyPair does.
+func ReadCertExpiry(use, certFile, keyFile, keyPass string) ([]CertExpiry, error) {
+	var ders [][]byte
+	if IsPKCS12(certFile) {
+		cert, err := LoadKeyPair(certFile, keyFile, keyPass)
+		if err != nil {
+			return nil, err
+		}
+		ders = cert.Certificate
+	} else {
+		data, err := os.ReadFile(certFile)
+		if err != nil {
+			return nil, err
+		}
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type == "CERTIFICATE" {
+				ders = append(ders, block.Bytes)
+			}
+		}
+	}
+	if len(ders) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", certFile)
+	}
+	return CertExpiries(use, certFile, ders)
+}
+
+// CertExpiries describes the certificates in ders, from file, loaded for
+// use. file may name where they came from rather than a file, such as a
+// CA service that renewed them.
+func CertExpiries(use, file string, ders [][]byte) ([]CertExpiry, error) {
+	certs := make([]CertExpiry, 0, len(ders))
+	for _, der := range ders {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		certs = append(certs, CertExpiry{
+			Use:      use,
+			File:     file,
+			Subject:  cert.Subject.String(),
+			Serial:   cert.SerialNumber.Text(16),
+			NotAfter: cert.NotAfter,
+		})
+	}
+	return certs, nil
+}
+
+// DaysLeft is the number of whole days from now until c expires, negative
+// once it has.
+func (c CertExpiry) DaysLeft(now time.Time) int {
+	return int(math.Floor(c.NotAfter.Sub(now).Hours() / 24))
+}
+
+// Expiring reports whether c expires within warnDays of now, or has.
+func (c CertExpiry) Expiring(now time.Time, warnDays int) bool {
+	return c.NotAfter.Before(now.AddDate(0, 0, warnDays))
+}
+
+// Describe says when c expires, or expired, as of now.
+func (c CertExpiry) Describe(now time.Time) string {
+	date := c.NotAfter.UTC().Format(time.DateOnly)
+	days := c.DaysLeft(now)
+	if days < 0 {
+		return fmt.Sprintf("expired on %s, %d days ago", date, -days)
+	}
+	return fmt.Sprintf("expires on %s, in %d days", date, days)
+}
+
+// ExpiryWarnings describes the certificates in certs that expire within
+// warnDays of now, or have, a line each.
+func ExpiryWarnings(certs []CertExpiry, now time.Time, warnDays int) []string {
+	var warnings []string
+	for _, c := range certs {
+		if c.Expiring(now, warnDays) {
+			warnings = append(warnings, fmt.Sprintf("%s certificate %s in %s %s", c.Use, c.Subject, c.File, c.Describe(now)))
+		}
+	}
+	return warnings
+}
+
+// PrintCertCheck writes the report of -check-certs to w: each of certs,
+// whether it expires within warnDays of now, and a summary. It returns
+// false if any does, or has.
+func PrintCertCheck(w io.Writer, certs []CertExpiry, now time.Time, warnDays int) bool {
+	if len(certs) == 0 {
+		fmt.Fprintln(w, "No certificates to check")
+		return true
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	failed := 0
+	for _, c := range certs {
+		status := "OK"
+		switch {
+		case !now.Before(c.NotAfter):
+			status = "EXPIRED"
+			failed++
+		case c.Expiring(now, warnDays):
+			status = "EXPIRING"
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", status, c.Use, c.Subject, c.File, c.Describe(now))
+	}
+	tw.Flush()
+
+	if failed > 0 {
+		fmt.Fprintf(w, "%d of %d certificates expire within %d days or have expired\n", failed, len(certs), warnDays)
+		return false
+	}
+	fmt.Fprintf(w, "All %d certificates are valid for at least %d more days\n", len(certs), warnDays)
+	return true
+}
@@ -0,0 +1,70 @@
+package mtls
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCertExpiry(t *testing.T) {
+	// identity.p12 holds chain-client and its intermediate, issued with
+	// the CA in pkcs12-ca.crt, which expires on 2126-09-22
+	certs, err := ReadCertExpiry("client", filepath.Join("testdata", "identity.p12"), "pass:correct-horse", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || certs[0].Use != "client" || !strings.Contains(certs[0].Subject, "CN=chain-client") {
+		t.Fatalf("identity.p12: %+v", certs)
+	}
+	if _, err := ReadCertExpiry("client", filepath.Join("testdata", "identity.p12"), "pass:battery-staple", ""); err == nil {
+		t.Error("bundle opened with the wrong passphrase")
+	}
+	if _, err := ReadCertExpiry("client", filepath.Join("testdata", "encrypted-pbes2.key"), "", ""); err == nil {
+		t.Error("key file read as certificates")
+	}
+
+	caFile := filepath.Join("testdata", "pkcs12-ca.crt")
+	ca, err := ReadCertExpiry("CA", caFile, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ca) != 1 || ca[0].Subject != "CN=MockOpenAI-CA,O=MockOpenAI" || ca[0].File != caFile {
+		t.Fatalf("pkcs12-ca.crt: %+v", ca)
+	}
+
+	now := time.Date(2126, time.September, 1, 0, 0, 0, 0, time.UTC)
+	if days := ca[0].DaysLeft(now); days != 21 {
+		t.Errorf("DaysLeft = %d, want 21", days)
+	}
+	if got := ca[0].Describe(now.AddDate(0, 0, 30)); got != "expired on 2126-09-22, 9 days ago" {
+		t.Errorf("Describe after expiry = %q", got)
+	}
+	if warnings := ExpiryWarnings(ca, now, 7); len(warnings) != 0 {
+		t.Errorf("warned of a certificate valid for three weeks: %q", warnings)
+	}
+	if warnings := ExpiryWarnings(ca, now, 30); len(warnings) != 1 || warnings[0] != "CA certificate CN=MockOpenAI-CA,O=MockOpenAI in "+caFile+" expires on 2126-09-22, in 21 days" {
+		t.Errorf("warnings within 30 days: %q", warnings)
+	}
+
+	for _, tc := range []struct {
+		now      time.Time
+		warnDays int
+		ok       bool
+		want     string
+	}{
+		{now, 7, true, "All 1 certificates are valid for at least 7 more days"},
+		{now, 30, false, "EXPIRING"},
+		{now.AddDate(0, 1, 0), 0, false, "EXPIRED"},
+	} {
+		var out bytes.Buffer
+		if ok := PrintCertCheck(&out, ca, tc.now, tc.warnDays); ok != tc.ok || !strings.Contains(out.String(), tc.want) {
+			t.Errorf("check within %d days: %v\n%s", tc.warnDays, ok, out.String())
+		}
+	}
+	var out bytes.Buffer
+	if !PrintCertCheck(&out, nil, now, 30) || out.String() != "No certificates to check\n" {
+		t.Errorf("check of no certificates:\n%s", out.String())
+	}
+}
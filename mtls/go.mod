@@ -0,0 +1,10 @@
+module mtls
+
+go 1.25.1
+
+require (
+	github.com/miekg/pkcs11 v1.1.1
+	golang.org/x/term v0.37.0
+)
+
+require golang.org/x/sys v0.38.0 // indirect
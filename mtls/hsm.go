@@ -0,0 +1,261 @@
+package mtls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// A private key may live on a PKCS #11 token, such as an HSM or a YubiKey,
+// and never exist as a file: the key is then a PKCS #11 URI (RFC 7512)
+// naming it, and the certificate stays a PEM file. The token signs
+// each handshake; the key never leaves it. For example:
+//
+//	pkcs11:token=YubiKey%20PIV;id=%01;type=private?module-path=/usr/lib/libykcs11.so
+//
+// The token is found by its token label, serial or slot-id, or is the
+// first one present, and the key on it by its object label or id. The
+// module is the URI's module-path, or $PKCS11_MODULE. The PIN is the
+// URI's pin-value, or is given as the key's passphrase would be, and is
+// asked for on the terminal if neither gives it. Signing needs cgo, to
+// load the module.
+
+// pkcs11URI is a parsed PKCS #11 URI, with the attributes used to find a
+// token and a private key on it.
+type pkcs11URI struct {
+	token, serial string
+	slotID        *uint
+	object        string
+	id            []byte
+	module, pin   string
+}
+
+// IsPKCS11 reports whether key names a key on a PKCS #11 token rather than
+// a file.
+func IsPKCS11(key string) bool {
+	return strings.HasPrefix(key, "pkcs11:")
+}
+
+// parsePKCS11URI parses a PKCS #11 URI naming a private key.
+func parsePKCS11URI(uri string) (*pkcs11URI, error) {
+	rest, ok := strings.CutPrefix(uri, "pkcs11:")
+	if !ok {
+		return nil, fmt.Errorf("%s is not a PKCS #11 URI", uri)
+	}
+	path, query, _ := strings.Cut(rest, "?")
+	u := &pkcs11URI{}
+	for _, attr := range strings.Split(path, ";") {
+		if attr == "" {
+			continue
+		}
+		name, value, err := pkcs11Attribute(attr)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "token":
+			u.token = value
+		case "serial":
+			u.serial = value
+		case "slot-id":
+			id, err := strconv.ParseUint(value, 10, 0)
+			if err != nil {
+				return nil, fmt.Errorf("PKCS #11 URI: invalid slot-id %q", value)
+			}
+			slot := uint(id)
+			u.slotID = &slot
+		case "object":
+			u.object = value
+		case "id":
+			u.id = []byte(value)
+		case "type":
+			if value != "private" {
+				return nil, fmt.Errorf("PKCS #11 URI: type=%s does not name a private key", value)
+			}
+		}
+	}
+	for _, attr := range strings.Split(query, "&") {
+		if attr == "" {
+			continue
+		}
+		name, value, err := pkcs11Attribute(attr)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "module-path":
+			u.module = value
+		case "pin-value":
+			u.pin = value
+		case "pin-source":
+			return nil, errors.New("PKCS #11 URI: give the PIN as the key passphrase rather than pin-source")
+		}
+	}
+	if u.object == "" && u.id == nil {
+		return nil, fmt.Errorf("PKCS #11 URI %s names no key: give its object or id", redactPIN(uri))
+	}
+	if u.module == "" {
+		u.module = os.Getenv("PKCS11_MODULE")
+	}
+	if u.module == "" {
+		return nil, fmt.Errorf("PKCS #11 URI %s names no module: give its module-path, or set $PKCS11_MODULE", redactPIN(uri))
+	}
+	return u, nil
+}
+
+// pkcs11Attribute splits a URI attribute into its name and its
+// percent-decoded value.
+func pkcs11Attribute(attr string) (string, string, error) {
+	name, value, _ := strings.Cut(attr, "=")
+	decoded, err := url.PathUnescape(value)
+	if err != nil {
+		return "", "", fmt.Errorf("PKCS #11 URI: %s: %w", name, err)
+	}
+	return name, decoded, nil
+}
+
+// redactPIN returns uri without its query, which may hold the PIN, for
+// messages.
+func redactPIN(uri string) string {
+	path, _, _ := strings.Cut(uri, "?")
+	return path
+}
+
+// signMechanism is how a token is asked to sign.
+type signMechanism int
+
+const (
+	signECDSA    signMechanism = iota // a digest, giving r and s concatenated
+	signRSAPKCS1                      // a DigestInfo, padded as PKCS #1 v1.5
+	signRSAPSS                        // a digest, padded as PSS
+)
+
+// tokenSigner signs data with a private key on a token. saltLength is
+// that of PSS, in bytes.
+type tokenSigner interface {
+	sign(mechanism signMechanism, hash crypto.Hash, saltLength int, data []byte) ([]byte, error)
+}
+
+// tokenKey is a crypto.Signer whose private key is on a token, for the
+// TLS stack to sign handshakes with.
+type tokenKey struct {
+	public crypto.PublicKey
+	token  tokenSigner
+}
+
+func (k *tokenKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Sign signs digest as crypto.Signer does: ECDSA signatures are ASN.1,
+// and RSA ones PSS if opts are *rsa.PSSOptions, PKCS #1 v1.5 otherwise.
+func (k *tokenKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash := opts.HashFunc()
+	switch public := k.public.(type) {
+	case *ecdsa.PublicKey:
+		raw, err := k.token.sign(signECDSA, hash, 0, digest)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) == 0 || len(raw)%2 != 0 {
+			return nil, fmt.Errorf("token returned a malformed ECDSA signature of %d bytes", len(raw))
+		}
+		half := len(raw) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(raw[:half]), new(big.Int).SetBytes(raw[half:])})
+	case *rsa.PublicKey:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			saltLength := pss.SaltLength
+			switch saltLength {
+			case rsa.PSSSaltLengthEqualsHash:
+				saltLength = hash.Size()
+			case rsa.PSSSaltLengthAuto:
+				saltLength = (public.N.BitLen()-1+7)/8 - 2 - hash.Size()
+			}
+			return k.token.sign(signRSAPSS, hash, saltLength, digest)
+		}
+		prefix, ok := digestInfoPrefixes[hash]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash %v for an RSA key on a token", hash)
+		}
+		return k.token.sign(signRSAPKCS1, hash, 0, append(append([]byte(nil), prefix...), digest...))
+	}
+	return nil, fmt.Errorf("unsupported key type %T on a token", k.public)
+}
+
+// digestInfoPrefixes are the DER of a PKCS #1 DigestInfo up to the digest,
+// for each hash, as crypto/rsa has them.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// tokenSigners holds the keys opened on tokens, by URI, so that reloading
+// a certificate does not open another session or ask for the PIN again.
+var tokenSigners = struct {
+	sync.Mutex
+	signers map[string]tokenSigner
+}{signers: make(map[string]tokenSigner)}
+
+// loadTokenKeyPair loads the certificate chain in certFile, with the key
+// on a PKCS #11 token named by keyURI, logging in to the token with the
+// PIN from the URI or keyPass.
+func loadTokenKeyPair(certFile, keyURI, keyPass string) (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	var cert tls.Certificate
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return tls.Certificate{}, fmt.Errorf("%s: no certificate found", certFile)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return tls.Certificate{}, fmt.Errorf("%s: %w", certFile, err)
+	}
+
+	tokenSigners.Lock()
+	defer tokenSigners.Unlock()
+	signer, ok := tokenSigners.signers[keyURI]
+	if !ok {
+		uri, err := parsePKCS11URI(keyURI)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		if uri.pin == "" {
+			source := keyPass
+			if source == "" && term.IsTerminal(int(os.Stdin.Fd())) {
+				source = "prompt"
+			}
+			if uri.pin, err = ReadPassphrase(source, redactPIN(keyURI)); err != nil {
+				return tls.Certificate{}, err
+			}
+		}
+		if signer, err = openTokenKey(uri); err != nil {
+			return tls.Certificate{}, fmt.Errorf("%s: %w", redactPIN(keyURI), err)
+		}
+		tokenSigners.signers[keyURI] = signer
+	}
+	cert.PrivateKey = &tokenKey{public: cert.Leaf.PublicKey, token: signer}
+	return cert, nil
+}
@@ -0,0 +1,163 @@
+//go:build cgo
+
+package mtls
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// pkcs11Modules holds the modules loaded, by path. A module is initialized
+// once for the life of the process.
+var pkcs11Modules = struct {
+	sync.Mutex
+	modules map[string]*pkcs11.Ctx
+}{modules: make(map[string]*pkcs11.Ctx)}
+
+// loadPKCS11Module loads and initializes the module at path.
+func loadPKCS11Module(path string) (*pkcs11.Ctx, error) {
+	pkcs11Modules.Lock()
+	defer pkcs11Modules.Unlock()
+	if ctx := pkcs11Modules.modules[path]; ctx != nil {
+		return ctx, nil
+	}
+	ctx := pkcs11.New(path)
+	if ctx == nil {
+		return nil, fmt.Errorf("cannot load the PKCS #11 module %s", path)
+	}
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		ctx.Destroy()
+		return nil, fmt.Errorf("initializing the PKCS #11 module %s: %w", path, err)
+	}
+	pkcs11Modules.modules[path] = ctx
+	return ctx, nil
+}
+
+// pkcs11Key is a private key on a token, signed with through a session of
+// its own. A session runs one operation at a time.
+type pkcs11Key struct {
+	ctx     *pkcs11.Ctx
+	mu      sync.Mutex
+	session pkcs11.SessionHandle
+	object  pkcs11.ObjectHandle
+}
+
+// openTokenKey finds the token and the private key on it named by uri,
+// logging in with its PIN.
+func openTokenKey(uri *pkcs11URI) (tokenSigner, error) {
+	ctx, err := loadPKCS11Module(uri.module)
+	if err != nil {
+		return nil, err
+	}
+	slot, err := findSlot(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("opening a session: %w", err)
+	}
+	if uri.pin != "" {
+		if err := ctx.Login(session, pkcs11.CKU_USER, uri.pin); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+			ctx.CloseSession(session)
+			return nil, fmt.Errorf("logging in to the token: %w", err)
+		}
+	}
+
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY)}
+	if uri.object != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, uri.object))
+	}
+	if uri.id != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, uri.id))
+	}
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		ctx.CloseSession(session)
+		return nil, fmt.Errorf("finding the key: %w", err)
+	}
+	objects, _, err := ctx.FindObjects(session, 2)
+	ctx.FindObjectsFinal(session)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("finding the key: %w", err)
+	case len(objects) == 0:
+		err = errors.New("no such private key on the token, or the PIN is needed to see it")
+	case len(objects) > 1:
+		err = errors.New("more than one private key matches: give both its object and id")
+	}
+	if err != nil {
+		ctx.CloseSession(session)
+		return nil, err
+	}
+	return &pkcs11Key{ctx: ctx, session: session, object: objects[0]}, nil
+}
+
+// findSlot returns the slot holding the token named by uri, or the first
+// with a token if it names none.
+func findSlot(ctx *pkcs11.Ctx, uri *pkcs11URI) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("listing the slots: %w", err)
+	}
+	for _, slot := range slots {
+		if uri.slotID != nil && slot != *uri.slotID {
+			continue
+		}
+		if uri.token != "" || uri.serial != "" {
+			info, err := ctx.GetTokenInfo(slot)
+			if err != nil {
+				continue
+			}
+			if uri.token != "" && strings.TrimRight(info.Label, " ") != uri.token {
+				continue
+			}
+			if uri.serial != "" && strings.TrimRight(info.SerialNumber, " ") != uri.serial {
+				continue
+			}
+		}
+		return slot, nil
+	}
+	return 0, errors.New("no such token is present")
+}
+
+// pkcs11Hashes are the PKCS #11 hash and MGF1 of each hash PSS may use.
+var pkcs11Hashes = map[crypto.Hash][2]uint{
+	crypto.SHA1:   {pkcs11.CKM_SHA_1, pkcs11.CKG_MGF1_SHA1},
+	crypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	crypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	crypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
+
+func (k *pkcs11Key) sign(mechanism signMechanism, hash crypto.Hash, saltLength int, data []byte) ([]byte, error) {
+	var mech *pkcs11.Mechanism
+	switch mechanism {
+	case signECDSA:
+		mech = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	case signRSAPKCS1:
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+	case signRSAPSS:
+		h, ok := pkcs11Hashes[hash]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash %v for PSS on a token", hash)
+		}
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(h[0], h[1], uint(saltLength)))
+	default:
+		return nil, fmt.Errorf("unsupported signing mechanism %d", mechanism)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.ctx.SignInit(k.session, []*pkcs11.Mechanism{mech}, k.object); err != nil {
+		return nil, fmt.Errorf("signing on the token: %w", err)
+	}
+	signature, err := k.ctx.Sign(k.session, data)
+	if err != nil {
+		return nil, fmt.Errorf("signing on the token: %w", err)
+	}
+	return signature, nil
+}
@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package mtls
+
+import "errors"
+
+// openTokenKey cannot load a PKCS #11 module without cgo.
+func openTokenKey(uri *pkcs11URI) (tokenSigner, error) {
+	return nil, errors.New("keys on a PKCS #11 token need a build with cgo enabled")
+}
@@ -0,0 +1,184 @@
+package mtls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// softToken signs as a PKCS #11 token does, with a key in memory: ECDSA
+// gives r and s concatenated, and PKCS #1 v1.5 signs the DigestInfo it is
+// given.
+type softToken struct {
+	key        crypto.Signer
+	mechanisms []signMechanism
+}
+
+func (s *softToken) sign(mechanism signMechanism, hash crypto.Hash, saltLength int, data []byte) ([]byte, error) {
+	s.mechanisms = append(s.mechanisms, mechanism)
+	switch mechanism {
+	case signECDSA:
+		key := s.key.(*ecdsa.PrivateKey)
+		r, sig, err := ecdsa.Sign(rand.Reader, key, data)
+		if err != nil {
+			return nil, err
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return append(r.FillBytes(make([]byte, size)), sig.FillBytes(make([]byte, size))...), nil
+	case signRSAPKCS1:
+		return rsa.SignPKCS1v15(rand.Reader, s.key.(*rsa.PrivateKey), 0, data)
+	case signRSAPSS:
+		return rsa.SignPSS(rand.Reader, s.key.(*rsa.PrivateKey), hash, data, &rsa.PSSOptions{SaltLength: saltLength})
+	}
+	return nil, fmt.Errorf("mechanism %d", mechanism)
+}
+
+func TestTokenKey(t *testing.T) {
+	caCert, caKey := newTestCA(t, "Token-CA")
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	srv.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsa2048, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for _, key := range []crypto.Signer{p256, rsa2048} {
+		name := fmt.Sprintf("token-%T", key.Public())
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, caCert, key.Public(), caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certFile := filepath.Join(t.TempDir(), "client.crt")
+		if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		// The key is opened once per URI; a token in memory stands in for one
+		// opened through a module
+		uri := "pkcs11:token=test;object=" + name + "?module-path=/nonexistent.so"
+		token := &softToken{key: key}
+		tokenSigners.Lock()
+		tokenSigners.signers[uri] = token
+		tokenSigners.Unlock()
+		cert, err := LoadKeyPair(certFile, uri, "")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		signer := cert.PrivateKey.(crypto.Signer)
+
+		// The TLS stack signs handshakes with it, in TLS 1.2 and 1.3
+		for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+			transport := srv.Client().Transport.(*http.Transport).Clone()
+			transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+			transport.TLSClientConfig.MaxVersion = version
+			resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+			if err != nil {
+				t.Fatalf("%s, %s: %v", name, tls.VersionName(version), err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != name {
+				t.Errorf("%s, %s: server saw %q", name, tls.VersionName(version), body)
+			}
+		}
+
+		// Signatures come back as crypto.Signer gives them
+		digest := sha256.Sum256([]byte("signed on a token"))
+		switch pub := key.Public().(type) {
+		case *ecdsa.PublicKey:
+			sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			if err != nil || !ecdsa.VerifyASN1(pub, digest[:], sig) {
+				t.Errorf("ECDSA signature does not verify: %v", err)
+			}
+		case *rsa.PublicKey:
+			sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			if err != nil || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+				t.Errorf("PKCS #1 v1.5 signature does not verify: %v", err)
+			}
+			opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+			sig, err = signer.Sign(rand.Reader, digest[:], opts)
+			if err != nil || rsa.VerifyPSS(pub, crypto.SHA256, digest[:], sig, opts) != nil {
+				t.Errorf("PSS signature does not verify: %v", err)
+			}
+			if len(token.mechanisms) < 2 || token.mechanisms[len(token.mechanisms)-2] != signRSAPKCS1 || token.mechanisms[len(token.mechanisms)-1] != signRSAPSS {
+				t.Errorf("token asked for mechanisms %v", token.mechanisms)
+			}
+		}
+	}
+}
+
+func TestParsePKCS11URI(t *testing.T) {
+	t.Setenv("PKCS11_MODULE", "/usr/lib/softhsm/libsofthsm2.so")
+	slot := uint(3)
+	cases := []struct {
+		uri  string
+		want *pkcs11URI
+		err  bool
+	}{
+		{uri: "pkcs11:token=YubiKey%20PIV;id=%01;type=private?module-path=/usr/lib/libykcs11.so&pin-value=123456",
+			want: &pkcs11URI{token: "YubiKey PIV", id: []byte{1}, module: "/usr/lib/libykcs11.so", pin: "123456"}},
+		{uri: "pkcs11:serial=abc123;slot-id=3;object=client",
+			want: &pkcs11URI{serial: "abc123", slotID: &slot, object: "client", module: "/usr/lib/softhsm/libsofthsm2.so"}},
+		{uri: "pkcs11:token=test", err: true},
+		{uri: "pkcs11:object=client;type=cert", err: true},
+		{uri: "pkcs11:object=client;slot-id=x", err: true},
+		{uri: "pkcs11:object=client?pin-source=file:/pin", err: true},
+		{uri: "pkcs11:object=%zz", err: true},
+		{uri: "client.key", err: true},
+	}
+	for _, c := range cases {
+		got, err := parsePKCS11URI(c.uri)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: parsed as %+v", c.uri, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.uri, err)
+			continue
+		}
+		if (got.slotID == nil) != (c.want.slotID == nil) || got.slotID != nil && *got.slotID != *c.want.slotID {
+			t.Errorf("%s: slot-id %v, want %v", c.uri, got.slotID, c.want.slotID)
+		}
+		got.slotID, c.want.slotID = nil, nil
+		if fmt.Sprint(*got) != fmt.Sprint(*c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.uri, *got, *c.want)
+		}
+	}
+
+	t.Setenv("PKCS11_MODULE", "")
+	if _, err := parsePKCS11URI("pkcs11:object=client"); err == nil {
+		t.Error("parsed a URI naming no module")
+	}
+	if got := redactPIN("pkcs11:object=client?pin-value=1234"); got != "pkcs11:object=client" {
+		t.Errorf("redactPIN = %q", got)
+	}
+}
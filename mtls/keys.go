@@ -0,0 +1,197 @@
+package mtls
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// Private keys may be encrypted with a passphrase: as PKCS #8 (ENCRYPTED
+// PRIVATE KEY, as openssl genpkey and pkey -aes256 write), or with the
+// older OpenSSL PEM encryption (Proc-Type: 4,ENCRYPTED, as openssl genrsa
+// -aes256 writes). The passphrase is given as pass:<passphrase>,
+// env:<variable>, file:<path> (the first line) or prompt, to ask on the
+// terminal, as the tools' -key-pass flags take it. With none given, an
+// encrypted key is asked for on the terminal if there is one.
+
+// prompted holds the passphrases typed at the terminal, by key file, so
+// that reloading the key does not ask again.
+var prompted = struct {
+	sync.Mutex
+	passphrases map[string]string
+}{passphrases: make(map[string]string)}
+
+// LoadKeyPair loads a certificate and key from PEM files, decrypting the
+// key with the passphrase from keyPass if it is encrypted, or from a
+// PKCS #12 bundle, with the passphrase from keyFile or else keyPass. A
+// keyFile that is a PKCS #11 URI names a key on a token, with keyPass its
+// PIN.
+func LoadKeyPair(certFile, keyFile, keyPass string) (tls.Certificate, error) {
+	if IsPKCS11(keyFile) {
+		return loadTokenKeyPair(certFile, keyFile, keyPass)
+	}
+	if IsPKCS12(certFile) {
+		source := keyFile
+		if source == "" {
+			source = keyPass
+		}
+		password, err := ReadPassphrase(source, certFile)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("the key of a PKCS #12 bundle is its passphrase: %w", err)
+		}
+		data, err := os.ReadFile(certFile)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		cert, err := DecodePKCS12(data, password)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%s: %w", certFile, err)
+		}
+		return cert, nil
+	}
+
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if keyPEM, err = decryptKeyPEM(keyPEM, keyFile, keyPass); err != nil {
+		return tls.Certificate{}, fmt.Errorf("%s: %w", keyFile, err)
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// decryptKeyPEM returns the private key in data, from keyFile, unencrypted.
+// A key that is not encrypted is returned as it is.
+func decryptKeyPEM(data []byte, keyFile, keyPass string) ([]byte, error) {
+	var block *pem.Block
+	for rest := data; ; {
+		if block, rest = pem.Decode(rest); block == nil {
+			// Let tls.X509KeyPair say what is wrong
+			return data, nil
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			break
+		}
+	}
+	// The older encryption is deprecated, but still what openssl genrsa
+	// -aes256 and many existing keys use
+	legacy := x509.IsEncryptedPEMBlock(block)
+	if block.Type != "ENCRYPTED PRIVATE KEY" && !legacy {
+		return data, nil
+	}
+
+	if keyPass == "" {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, errors.New("the key is encrypted and no passphrase was given")
+		}
+		keyPass = "prompt"
+	}
+	password, err := ReadPassphrase(keyPass, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var der []byte
+	if legacy {
+		der, err = x509.DecryptPEMBlock(block, []byte(password))
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			err = ErrIncorrectPassphrase
+		}
+		block = &pem.Block{Type: block.Type, Bytes: der}
+	} else {
+		var info encryptedPrivateKeyInfo
+		if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+			return nil, fmt.Errorf("malformed encrypted key: %w", err)
+		}
+		if der, err = pbeDecrypt(info.Algorithm, info.EncryptedData, password); err == nil {
+			// A wrong passphrase can leave valid padding by chance
+			if _, parseErr := x509.ParsePKCS8PrivateKey(der); parseErr != nil {
+				err = ErrIncorrectPassphrase
+			}
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	if err != nil {
+		if errors.Is(err, ErrIncorrectPassphrase) && keyPass == "prompt" {
+			prompted.Lock()
+			delete(prompted.passphrases, keyFile)
+			prompted.Unlock()
+		}
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// EncryptKey encodes key as an ENCRYPTED PRIVATE KEY block, PKCS #8
+// encrypted with password as openssl pkey -aes256 does (PBES2, with PBKDF2
+// and AES-256-CBC), which LoadKeyPair decrypts.
+func EncryptKey(key crypto.PrivateKey, password string) (*pem.Block, error) {
+	der, err := encryptKey(key, password, false)
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}, nil
+}
+
+// ReadPassphrase reads a passphrase given as pass:<passphrase>,
+// env:<variable>, file:<path> or prompt, asking on the terminal for that
+// of file if source is prompt. An empty source is an empty passphrase.
+func ReadPassphrase(source, file string) (string, error) {
+	kind, value, _ := strings.Cut(source, ":")
+	switch {
+	case source == "":
+		return "", nil
+	case source == "prompt":
+		return promptPassphrase(file)
+	case kind == "pass":
+		return value, nil
+	case kind == "env":
+		password, ok := os.LookupEnv(value)
+		if !ok {
+			return "", fmt.Errorf("passphrase: $%s is not set", value)
+		}
+		return password, nil
+	case kind == "file":
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return "", fmt.Errorf("passphrase: %w", err)
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		return strings.TrimSuffix(line, "\r"), nil
+	}
+	return "", errors.New("a passphrase is given as pass:<passphrase>, env:<variable>, file:<path> or prompt")
+}
+
+// promptPassphrase asks on the terminal for the passphrase of file, once.
+func promptPassphrase(file string) (string, error) {
+	prompted.Lock()
+	defer prompted.Unlock()
+	if password, ok := prompted.passphrases[file]; ok {
+		return password, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("cannot ask for the passphrase of %s: standard input is not a terminal", file)
+	}
+	fmt.Fprintf(os.Stderr, "Passphrase for %s: ", file)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("reading the passphrase of %s: %w", file, err)
+	}
+	prompted.passphrases[file] = string(password)
+	return string(password), nil
+}
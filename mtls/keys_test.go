@@ -0,0 +1,116 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/term"
+)
+
+// The keys in testdata are that of encrypted-client.crt, issued from
+// pkcs12-ca.crt, encrypted by openssl with the passphrase correct-horse:
+// encrypted-pbes2.key by pkey -aes256 (PBES2 with AES-256-CBC),
+// encrypted-3des.key by pkcs8 -v1 PBE-SHA1-3DES, and encrypted-legacy.key
+// by ec -aes256 (Proc-Type: 4,ENCRYPTED).
+
+func TestEncryptedKeys(t *testing.T) {
+	certFile := filepath.Join("testdata", "encrypted-client.crt")
+	t.Setenv("TEST_KEY_PASSPHRASE", "correct-horse")
+	for _, name := range []string{"encrypted-pbes2.key", "encrypted-3des.key", "encrypted-legacy.key"} {
+		keyFile := filepath.Join("testdata", name)
+		cert, err := LoadKeyPair(certFile, keyFile, "env:TEST_KEY_PASSPHRASE")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if cert.Leaf.Subject.CommonName != "pkcs12-client" {
+			t.Errorf("%s: loaded %s", name, cert.Leaf.Subject.CommonName)
+		}
+		if _, err := LoadKeyPair(certFile, keyFile, "pass:battery-staple"); !errors.Is(err, ErrIncorrectPassphrase) {
+			t.Errorf("%s with the wrong passphrase: %v, want %v", name, err, ErrIncorrectPassphrase)
+		}
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			if _, err := LoadKeyPair(certFile, keyFile, ""); err == nil || !strings.Contains(err.Error(), "no passphrase") {
+				t.Errorf("%s without a passphrase or terminal: %v", name, err)
+			}
+		}
+	}
+
+	// A passphrase is not needed for a key that is not encrypted, and does
+	// no harm
+	ca, caKey := newTestCA(t, "Keys-CA")
+	plainCert, plainKey := writeIdentity(t, issueCert(t, ca, caKey, "plain-client", x509.ExtKeyUsageClientAuth))
+	if _, err := LoadKeyPair(plainCert, plainKey, "pass:unused"); err != nil {
+		t.Error(err)
+	}
+
+	// Servers and clients take it too
+	if _, err := LoadServerTLSConfig(Config{CertFile: certFile, KeyFile: filepath.Join("testdata", "encrypted-pbes2.key"), KeyPass: "pass:correct-horse"}); err != nil {
+		t.Error(err)
+	}
+	config, err := LoadClientTLSConfig(Config{CertFile: certFile, KeyFile: filepath.Join("testdata", "encrypted-legacy.key"), KeyPass: "pass:correct-horse"})
+	if err != nil || len(config.Certificates) != 1 {
+		t.Errorf("client: %v", err)
+	}
+
+	// The passphrase stands in for the key of a PKCS #12 bundle
+	if _, err := LoadKeyPair(filepath.Join("testdata", "identity.p12"), "", "pass:correct-horse"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEncryptKey(t *testing.T) {
+	ca, caKey := newTestCA(t, "Keys-CA")
+	certFile, keyFile := writeIdentity(t, issueCert(t, ca, caKey, "encrypted-client", x509.ExtKeyUsageClientAuth))
+	cert, err := LoadKeyPair(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := EncryptKey(cert.PrivateKey, "correct-horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadKeyPair(certFile, keyFile, "pass:correct-horse"); err != nil {
+		t.Error(err)
+	}
+	if _, err := LoadKeyPair(certFile, keyFile, "pass:battery-staple"); !errors.Is(err, ErrIncorrectPassphrase) {
+		t.Errorf("wrong passphrase: %v, want %v", err, ErrIncorrectPassphrase)
+	}
+}
+
+func TestReadPassphrase(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "passphrase")
+	if err := os.WriteFile(file, []byte("from-file\r\nignored\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_KEY_PASSPHRASE", "from-env")
+	for source, want := range map[string]string{
+		"":                        "",
+		"pass:":                   "",
+		"pass:a:b":                "a:b",
+		"env:TEST_KEY_PASSPHRASE": "from-env",
+		"file:" + file:            "from-file",
+	} {
+		if got, err := ReadPassphrase(source, "client.key"); err != nil || got != want {
+			t.Errorf("ReadPassphrase(%q) = %q, %v; want %q", source, got, err, want)
+		}
+	}
+	sources := []string{"client.key", "env:TEST_KEY_UNSET", "file:" + file + ".missing"}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		sources = append(sources, "prompt")
+	}
+	for _, source := range sources {
+		if _, err := ReadPassphrase(source, "client.key"); err == nil {
+			t.Errorf("ReadPassphrase(%q) succeeded", source)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Package mtls loads the certificates, keys and CAs of mutual TLS, as the
+// mock server, test client and proxy do, into TLS configurations and HTTP
+// clients. Certificates may be PEM files, PKCS #12 bundles or keys on
+// PKCS #11 tokens, and keys may be encrypted with a passphrase.
+//
+// A Go program can use it to talk to an mTLS OpenAI gateway directly:
+//
+//	client, err := mtls.NewHTTPClient(mtls.Config{
+//	    CertFile: "client.crt",
+//	    KeyFile:  "client.key",
+//	    CAFile:   "ca.crt",
+//	    Reload:   true,
+//	})
+//	...
+//	config := openai.DefaultConfig(apiKey)
+//	config.BaseURL = "https://gateway.example.com/v1"
+//	config.HTTPClient = client
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Config names the files of an identity and the CAs it trusts.
+type Config struct {
+	// CertFile is the certificate, followed by any intermediates, in PEM,
+	// or a PKCS #12 bundle.
+	CertFile string
+	// KeyFile is the private key in PEM, the passphrase of a PKCS #12
+	// bundle, given as ReadPassphrase takes it, or a PKCS #11 URI naming a
+	// key on a token.
+	KeyFile string
+	// KeyPass is the passphrase of an encrypted KeyFile, or the PIN of a
+	// token, given as ReadPassphrase takes it. With none given, it is asked
+	// for on the terminal if there is one.
+	KeyPass string
+	// CAFile holds the CA certificates, in PEM, that verify the peer: the
+	// clients of a server, or the server of a client, which uses the system
+	// roots if CAFile is empty.
+	CAFile string
+	// ClientAuth is a server's policy for client certificates. Given a
+	// CAFile, the zero value requires and verifies one.
+	ClientAuth tls.ClientAuthType
+	// ServerName is the name a client verifies the server's certificate
+	// for, if not that of the host it connects to.
+	ServerName string
+	// Reload reloads the certificate and key when their files change, from
+	// the next handshake on, so that a rotated certificate needs no
+	// restart.
+	Reload bool
+}
+
+// LoadServerTLSConfig builds the TLS configuration of a server presenting
+// the certificate of c and verifying clients against c.CAFile.
+func LoadServerTLSConfig(c Config) (*tls.Config, error) {
+	if c.CertFile == "" {
+		return nil, errors.New("a server needs a certificate and key")
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.Reload {
+		r, err := NewReloader(c.CertFile, c.KeyFile, c.KeyPass)
+		if err != nil {
+			return nil, err
+		}
+		config.GetCertificate = r.GetCertificate
+	} else {
+		cert, err := c.loadCertificate()
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	config.ClientAuth = c.ClientAuth
+	if c.CAFile == "" {
+		if config.ClientAuth >= tls.VerifyClientCertIfGiven {
+			return nil, errors.New("verifying client certificates needs a CA")
+		}
+		return config, nil
+	}
+	if config.ClientAuth == tls.NoClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	pool, err := LoadCAPool(c.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	config.ClientCAs = pool
+	return config, nil
+}
+
+// LoadClientTLSConfig builds the TLS configuration of a client presenting
+// the certificate of c, if it has one, and verifying the server against
+// c.CAFile, or the system roots.
+func LoadClientTLSConfig(c Config) (*tls.Config, error) {
+	config := &tls.Config{ServerName: c.ServerName, MinVersion: tls.VersionTLS12}
+	switch {
+	case c.CertFile == "" && c.KeyFile == "":
+	case c.Reload:
+		r, err := NewReloader(c.CertFile, c.KeyFile, c.KeyPass)
+		if err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = r.GetClientCertificate
+	default:
+		cert, err := c.loadCertificate()
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CAFile != "" {
+		pool, err := LoadCAPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// NewHTTPClient returns an HTTP client that connects with the TLS
+// configuration LoadClientTLSConfig builds from c, through any proxy the
+// environment sets.
+func NewHTTPClient(c Config) (*http.Client, error) {
+	config, err := LoadClientTLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
+
+// loadCertificate loads the certificate and key of c.
+func (c Config) loadCertificate() (tls.Certificate, error) {
+	if c.CertFile == "" || c.KeyFile == "" && !IsPKCS12(c.CertFile) {
+		return tls.Certificate{}, errors.New("certificate and key must be given together")
+	}
+	cert, err := LoadKeyPair(c.CertFile, c.KeyFile, c.KeyPass)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// LoadCAPool reads a PEM CA bundle into a certificate pool.
+func LoadCAPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,183 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCA creates a self-signed CA valid for a day.
+func newTestCA(t *testing.T, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// issueCert issues a certificate for name, and for 127.0.0.1, from ca.
+func issueCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, name string, usage x509.ExtKeyUsage) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeIdentity writes cert and its key to PEM files in a new temporary
+// directory, returning their paths.
+func writeIdentity(t *testing.T, cert *tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "identity.crt"), filepath.Join(dir, "identity.key")
+	writeFiles(t, certFile, keyFile, cert)
+	return certFile, keyFile
+}
+
+// writeFiles writes cert and its key to certFile and keyFile.
+func writeFiles(t *testing.T, certFile, keyFile string, cert *tls.Certificate) {
+	t.Helper()
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeCA writes ca to a PEM file, returning its path.
+func writeCA(t *testing.T, ca *x509.Certificate) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca, caKey := newTestCA(t, "MTLS-CA")
+	caFile := writeCA(t, ca)
+	serverCert, serverKey := writeIdentity(t, issueCert(t, ca, caKey, "server", x509.ExtKeyUsageServerAuth))
+	clientCert, clientKey := writeIdentity(t, issueCert(t, ca, caKey, "first-client", x509.ExtKeyUsageClientAuth))
+
+	serverConfig, err := LoadServerTLSConfig(Config{CertFile: serverCert, KeyFile: serverKey, CAFile: caFile, Reload: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverConfig.ClientAuth != tls.RequireAndVerifyClientCert || serverConfig.GetCertificate == nil {
+		t.Errorf("server config: client auth %v", serverConfig.ClientAuth)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	// httptest would add its own certificate to a config that has none
+	srv.Listener = tls.NewListener(srv.Listener, serverConfig)
+	srv.Start()
+	defer srv.Close()
+	url := "https://" + srv.Listener.Addr().String()
+
+	client, err := NewHTTPClient(Config{CertFile: clientCert, KeyFile: clientKey, CAFile: caFile, Reload: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	presented := func() string {
+		t.Helper()
+		client.CloseIdleConnections()
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	if got := presented(); got != "first-client" {
+		t.Errorf("server saw %q, want first-client", got)
+	}
+
+	// A client certificate rotated on disk is presented on the next
+	// connection
+	writeFiles(t, clientCert, clientKey, issueCert(t, ca, caKey, "second-client", x509.ExtKeyUsageClientAuth))
+	if got := presented(); got != "second-client" {
+		t.Errorf("server saw %q after rotation, want second-client", got)
+	}
+
+	// A client without a certificate is turned away
+	anonymous, err := NewHTTPClient(Config{CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := anonymous.Get(url); err == nil {
+		resp.Body.Close()
+		t.Error("request without a client certificate succeeded")
+	}
+
+	// Without a CA, client certificates are neither asked for nor
+	// verified
+	if config, err := LoadServerTLSConfig(Config{CertFile: serverCert, KeyFile: serverKey}); err != nil || config.ClientAuth != tls.NoClientCert || len(config.Certificates) != 1 {
+		t.Errorf("server without a CA: %v", err)
+	}
+	for _, c := range []Config{
+		{CertFile: serverCert, KeyFile: serverKey, ClientAuth: tls.VerifyClientCertIfGiven},
+		{CertFile: serverCert, CAFile: caFile},
+		{KeyFile: serverKey, CAFile: caFile},
+		{CertFile: serverCert, KeyFile: serverKey, CAFile: serverKey},
+	} {
+		if _, err := LoadServerTLSConfig(c); err == nil {
+			t.Errorf("server config accepted: %+v", c)
+		}
+	}
+}
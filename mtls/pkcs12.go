@@ -0,0 +1,942 @@
+package mtls
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// A PKCS #12 bundle (.p12 or .pfx) holds a private key and its
+// certificate chain, encrypted with a passphrase; it is how Java keystores
+// and the Windows certificate store export identities. Anywhere a
+// certificate and key are loaded, a bundle can be given as the certificate,
+// with its passphrase in place of the key, given as ReadPassphrase takes
+// it, or as the key's passphrase. An empty key and passphrase mean no
+// passphrase.
+
+// IsPKCS12 reports whether path names a PKCS #12 bundle, by its extension.
+func IsPKCS12(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".p12", ".pfx":
+		return true
+	}
+	return false
+}
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidKeyBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidShroudedKeyBag      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHA3DES      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHA128RC2    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 5}
+	oidPBEWithSHA40RC2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	oidPBES2               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1        = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC          = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidSHA1                = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	ErrIncorrectPassphrase = errors.New("incorrect passphrase")
+)
+
+// The key derivation work and salt size of every bundle EncodePKCS12
+// writes, OpenSSL 3's defaults.
+const (
+	pkcs12Iterations = 2048
+	pkcs12SaltLen    = 16
+)
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"tag:0,optional"`
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// DecodePKCS12 reads the private key and certificates in a PKCS #12
+// bundle, returning the certificate for the key followed by the chain
+// that issued it, as far as the bundle has it, without a self-signed root,
+// which the peer already has if it trusts it.
+func DecodePKCS12(data []byte, password string) (tls.Certificate, error) {
+	var pfx pfxPDU
+	if err := unmarshalBER(data, &pfx); err != nil {
+		return tls.Certificate{}, fmt.Errorf("not a PKCS #12 bundle: %w", err)
+	}
+	if pfx.Version != 3 {
+		return tls.Certificate{}, fmt.Errorf("PKCS #12 version %d not supported", pfx.Version)
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidData) {
+		return tls.Certificate{}, errors.New("PKCS #12 bundles signed with a public key are not supported")
+	}
+	authSafe, err := octets(pfx.AuthSafe.Content)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if len(pfx.MacData.Mac.Digest) > 0 {
+		if err := verifyMAC(&pfx.MacData, authSafe, password); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	var contents []contentInfo
+	if err := unmarshalBER(authSafe, &contents); err != nil {
+		return tls.Certificate{}, err
+	}
+	var keys [][]byte // PKCS #8
+	var certs []*x509.Certificate
+	for _, content := range contents {
+		var safe []byte
+		switch {
+		case content.ContentType.Equal(oidData):
+			if safe, err = octets(content.Content); err != nil {
+				return tls.Certificate{}, err
+			}
+		case content.ContentType.Equal(oidEncryptedData):
+			var encrypted encryptedData
+			if err := unmarshalBER(content.Content.Bytes, &encrypted); err != nil {
+				return tls.Certificate{}, err
+			}
+			ciphertext, err := octets(encrypted.EncryptedContentInfo.EncryptedContent)
+			if err != nil {
+				return tls.Certificate{}, err
+			}
+			if safe, err = pbeDecrypt(encrypted.EncryptedContentInfo.ContentEncryptionAlgorithm, ciphertext, password); err != nil {
+				return tls.Certificate{}, err
+			}
+		default:
+			return tls.Certificate{}, fmt.Errorf("PKCS #12 content type %v not supported", content.ContentType)
+		}
+
+		var bags []safeBag
+		if err := unmarshalBER(safe, &bags); err != nil {
+			return tls.Certificate{}, err
+		}
+		for _, bag := range bags {
+			switch {
+			case bag.ID.Equal(oidKeyBag):
+				keys = append(keys, bag.Value.Bytes)
+			case bag.ID.Equal(oidShroudedKeyBag):
+				var info encryptedPrivateKeyInfo
+				if err := unmarshalBER(bag.Value.Bytes, &info); err != nil {
+					return tls.Certificate{}, err
+				}
+				key, err := pbeDecrypt(info.Algorithm, info.EncryptedData, password)
+				if err != nil {
+					return tls.Certificate{}, err
+				}
+				keys = append(keys, key)
+			case bag.ID.Equal(oidCertBag):
+				var cb certBag
+				if err := unmarshalBER(bag.Value.Bytes, &cb); err != nil {
+					return tls.Certificate{}, err
+				}
+				if !cb.ID.Equal(oidX509Certificate) {
+					continue
+				}
+				cert, err := x509.ParseCertificate(cb.Data)
+				if err != nil {
+					return tls.Certificate{}, err
+				}
+				certs = append(certs, cert)
+			}
+		}
+	}
+
+	if len(keys) != 1 {
+		return tls.Certificate{}, fmt.Errorf("PKCS #12 bundle has %d private keys, want 1", len(keys))
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keys[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return tls.Certificate{}, fmt.Errorf("PKCS #12 key type %T not supported", key)
+	}
+	public := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	var leaf *x509.Certificate
+	for _, cert := range certs {
+		if public.Equal(cert.PublicKey) {
+			leaf = cert
+			break
+		}
+	}
+	if leaf == nil {
+		return tls.Certificate{}, errors.New("PKCS #12 bundle has no certificate for its private key")
+	}
+
+	chain := tls.Certificate{Certificate: [][]byte{leaf.Raw}, PrivateKey: key, Leaf: leaf}
+	for cert := leaf; ; {
+		var issuer *x509.Certificate
+		for _, c := range certs {
+			if c != cert && bytes.Equal(c.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(c) == nil {
+				issuer = c
+				break
+			}
+		}
+		if issuer == nil || bytes.Equal(issuer.RawSubject, issuer.RawIssuer) || len(chain.Certificate) > len(certs) {
+			return chain, nil
+		}
+		chain.Certificate = append(chain.Certificate, issuer.Raw)
+		cert = issuer
+	}
+}
+
+// octets returns the contents of an OCTET STRING tagged [0]: implicitly,
+// when BER may have split it into parts, or explicitly, when value wraps
+// it.
+func octets(value asn1.RawValue) ([]byte, error) {
+	if !value.IsCompound {
+		return value.Bytes, nil
+	}
+	var b []byte
+	for rest := value.Bytes; len(rest) > 0; {
+		var part []byte
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &part); err != nil {
+			return nil, err
+		}
+		b = append(b, part...)
+	}
+	return b, nil
+}
+
+// verifyMAC checks the bundle's integrity, and so the passphrase.
+func verifyMAC(mac *macData, content []byte, password string) error {
+	newHash, err := hashFor(mac.Mac.Algorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	// An empty passphrase is encoded as two zero bytes by most tools and as
+	// nothing by some
+	candidates := [][]byte{bmpPassword(password)}
+	if password == "" {
+		candidates = append(candidates, nil)
+	}
+	for _, p := range candidates {
+		key := pkcs12KDF(newHash, 3, p, mac.MacSalt, mac.Iterations, newHash().Size())
+		h := hmac.New(newHash, key)
+		h.Write(content)
+		if hmac.Equal(h.Sum(nil), mac.Mac.Digest) {
+			return nil
+		}
+	}
+	return ErrIncorrectPassphrase
+}
+
+// hashFor returns the hash with the given digest algorithm OID.
+func hashFor(oid asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA1), oid.Equal(oidHMACWithSHA1):
+		return sha1.New, nil
+	case oid.Equal(oidSHA256), oid.Equal(oidHMACWithSHA256):
+		return sha256.New, nil
+	case oid.Equal(oidSHA384), oid.Equal(oidHMACWithSHA384):
+		return sha512.New384, nil
+	case oid.Equal(oidSHA512), oid.Equal(oidHMACWithSHA512):
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("PKCS #12 digest %v not supported", oid)
+}
+
+// pbeDecrypt decrypts data with a password-based scheme: PBES2 with PBKDF2
+// and AES or triple DES, as OpenSSL 3, Java and Windows write, or the
+// PKCS #12 schemes with triple DES or RC2 that older tools use.
+func pbeDecrypt(algorithm pkix.AlgorithmIdentifier, data []byte, password string) ([]byte, error) {
+	var block cipher.Block
+	var iv []byte
+	switch oid := algorithm.Algorithm; {
+	case oid.Equal(oidPBES2):
+		var params pbes2Params
+		if err := unmarshalBER(algorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+			return nil, fmt.Errorf("PKCS #12 key derivation %v not supported", params.KeyDerivationFunc.Algorithm)
+		}
+		var kdf pbkdf2Params
+		if err := unmarshalBER(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+			return nil, err
+		}
+		prf := sha1.New
+		if len(kdf.PRF.Algorithm) > 0 {
+			var err error
+			if prf, err = hashFor(kdf.PRF.Algorithm); err != nil {
+				return nil, err
+			}
+		}
+		var keyLength int
+		switch scheme := params.EncryptionScheme.Algorithm; {
+		case scheme.Equal(oidAES128CBC):
+			keyLength = 16
+		case scheme.Equal(oidAES192CBC):
+			keyLength = 24
+		case scheme.Equal(oidAES256CBC):
+			keyLength = 32
+		case scheme.Equal(oidDESEDE3CBC):
+			keyLength = 24
+		default:
+			return nil, fmt.Errorf("PKCS #12 cipher %v not supported", scheme)
+		}
+		if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+			return nil, err
+		}
+		key, err := pbkdf2.Key(prf, password, kdf.Salt, kdf.Iterations, keyLength)
+		if err != nil {
+			return nil, err
+		}
+		if params.EncryptionScheme.Algorithm.Equal(oidDESEDE3CBC) {
+			block, err = des.NewTripleDESCipher(key)
+		} else {
+			block, err = aes.NewCipher(key)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+	case oid.Equal(oidPBEWithSHA3DES), oid.Equal(oidPBEWithSHA128RC2), oid.Equal(oidPBEWithSHA40RC2):
+		var params pbeParams
+		if err := unmarshalBER(algorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		p := bmpPassword(password)
+		iv = pkcs12KDF(sha1.New, 2, p, params.Salt, params.Iterations, 8)
+		var err error
+		switch {
+		case oid.Equal(oidPBEWithSHA3DES):
+			block, err = des.NewTripleDESCipher(pkcs12KDF(sha1.New, 1, p, params.Salt, params.Iterations, 24))
+		case oid.Equal(oidPBEWithSHA128RC2):
+			block = newRC2(pkcs12KDF(sha1.New, 1, p, params.Salt, params.Iterations, 16), 128)
+		default:
+			block = newRC2(pkcs12KDF(sha1.New, 1, p, params.Salt, params.Iterations, 5), 40)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("PKCS #12 encryption %v not supported", oid)
+	}
+
+	if len(data) == 0 || len(data)%block.BlockSize() != 0 || len(iv) != block.BlockSize() {
+		return nil, errors.New("PKCS #12 encrypted data is malformed")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	// A wrong passphrase almost always leaves bad padding
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > block.BlockSize() || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, ErrIncorrectPassphrase
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+// EncodePKCS12 encodes key and certs, the certificate for the key first,
+// as a PKCS #12 bundle encrypted with password, with the key's friendly
+// name, shown as the alias in a Java keystore, set to name. It encrypts
+// as OpenSSL 3 does, with PBES2 (PBKDF2 with HMAC-SHA256, and
+// AES-256-CBC) and an HMAC-SHA256 MAC, or, if legacy, for older
+// consumers, with the PKCS #12 triple DES scheme and a SHA-1 MAC, as
+// openssl pkcs12 -legacy does bar its RC2.
+//
+// The certificates are encrypted in one bag and the key shrouded in
+// another, with the same friendly name and local key ID on the leaf and
+// the key, which is how Java and Windows pair them.
+func EncodePKCS12(key crypto.PrivateKey, certs [][]byte, name, password string, legacy bool) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate for the key")
+	}
+	keyID := sha1.Sum(certs[0])
+	attributes, err := bagAttributes(name, keyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	for i, der := range certs {
+		value, err := asn1.Marshal(certBag{ID: oidX509Certificate, Data: der})
+		if err != nil {
+			return nil, err
+		}
+		bag := safeBag{ID: oidCertBag, Value: explicit(value)}
+		if i == 0 {
+			bag.Attributes = attributes
+		}
+		certBags = append(certBags, bag)
+	}
+	bags, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, encrypted, err := pbeEncrypt(bags, password, legacy)
+	if err != nil {
+		return nil, err
+	}
+	encryptedCerts, err := asn1.Marshal(encryptedData{EncryptedContentInfo: encryptedContentInfo{
+		ContentType:                oidData,
+		ContentEncryptionAlgorithm: algorithm,
+		EncryptedContent:           asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: encrypted},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	shrouded, err := encryptKey(key, password, legacy)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := asn1.Marshal([]safeBag{{ID: oidShroudedKeyBag, Value: explicit(shrouded), Attributes: attributes}})
+	if err != nil {
+		return nil, err
+	}
+	keysOctets, err := asn1.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	authSafe, err := asn1.Marshal([]contentInfo{
+		{ContentType: oidEncryptedData, Content: explicit(encryptedCerts)},
+		{ContentType: oidData, Content: explicit(keysOctets)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	mac, err := computeMAC(authSafe, password, legacy)
+	if err != nil {
+		return nil, err
+	}
+	authSafeOctets, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfxPDU{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidData, Content: explicit(authSafeOctets)},
+		MacData:  mac,
+	})
+}
+
+// encryptKey encodes key as a PKCS #8 EncryptedPrivateKeyInfo, encrypted
+// with password as pbeEncrypt does.
+func encryptKey(key crypto.PrivateKey, password string, legacy bool) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, encrypted, err := pbeEncrypt(der, password, legacy)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: algorithm, EncryptedData: encrypted})
+}
+
+// explicit wraps the DER element der in an explicit [0] tag. Marshal
+// ignores the tags of an asn1.RawValue field, so the values of those
+// tagged explicit are wrapped by hand.
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// bagAttributes are the friendly name and local key ID of the leaf and
+// its key.
+func bagAttributes(name string, keyID []byte) ([]pkcs12Attribute, error) {
+	var bmp []byte
+	for _, r := range utf16.Encode([]rune(name)) {
+		bmp = append(bmp, byte(r>>8), byte(r))
+	}
+	friendlyName, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmp})
+	if err != nil {
+		return nil, err
+	}
+	localKeyID, err := asn1.Marshal(keyID)
+	if err != nil {
+		return nil, err
+	}
+	set := func(der []byte) asn1.RawValue {
+		return asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: der}
+	}
+	return []pkcs12Attribute{
+		{ID: oidFriendlyName, Value: set(friendlyName)},
+		{ID: oidLocalKeyID, Value: set(localKeyID)},
+	}, nil
+}
+
+// pbeEncrypt encrypts data with password, with PBES2 or, if legacy, the
+// PKCS #12 triple DES scheme, returning the algorithm and its parameters.
+// pbeDecrypt reverses it.
+func pbeEncrypt(data []byte, password string, legacy bool) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt := make([]byte, pkcs12SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	var algorithm pkix.AlgorithmIdentifier
+	var block cipher.Block
+	var iv []byte
+	if legacy {
+		params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: pkcs12Iterations})
+		if err != nil {
+			return algorithm, nil, err
+		}
+		algorithm = pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHA3DES, Parameters: asn1.RawValue{FullBytes: params}}
+		key := pkcs12KDF(sha1.New, 1, bmpPassword(password), salt, pkcs12Iterations, 24)
+		iv = pkcs12KDF(sha1.New, 2, bmpPassword(password), salt, pkcs12Iterations, des.BlockSize)
+		if block, err = des.NewTripleDESCipher(key); err != nil {
+			return algorithm, nil, err
+		}
+	} else {
+		iv = make([]byte, aes.BlockSize)
+		if _, err := rand.Read(iv); err != nil {
+			return algorithm, nil, err
+		}
+		kdf, err := asn1.Marshal(pbkdf2Params{
+			Salt:       salt,
+			Iterations: pkcs12Iterations,
+			PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+		})
+		if err != nil {
+			return algorithm, nil, err
+		}
+		ivParam, err := asn1.Marshal(iv)
+		if err != nil {
+			return algorithm, nil, err
+		}
+		params, err := asn1.Marshal(pbes2Params{
+			KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+			EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+		})
+		if err != nil {
+			return algorithm, nil, err
+		}
+		algorithm = pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}
+		key, err := pbkdf2.Key(sha256.New, password, salt, pkcs12Iterations, 32)
+		if err != nil {
+			return algorithm, nil, err
+		}
+		if block, err = aes.NewCipher(key); err != nil {
+			return algorithm, nil, err
+		}
+	}
+
+	// PKCS #7 padding, always at least one byte
+	n := block.BlockSize() - len(data)%block.BlockSize()
+	out := append(bytes.Clone(data), bytes.Repeat([]byte{byte(n)}, n)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, out)
+	return algorithm, out, nil
+}
+
+// computeMAC is the MAC over a bundle's contents that proves the
+// passphrase: HMAC-SHA256 or, if legacy, HMAC-SHA1, keyed by the PKCS #12
+// key derivation either way. verifyMAC checks it.
+func computeMAC(content []byte, password string, legacy bool) (macData, error) {
+	newHash, digest := sha256.New, oidSHA256
+	if legacy {
+		newHash, digest = sha1.New, oidSHA1
+	}
+	salt := make([]byte, pkcs12SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return macData{}, err
+	}
+	key := pkcs12KDF(newHash, 3, bmpPassword(password), salt, pkcs12Iterations, newHash().Size())
+	h := hmac.New(newHash, key)
+	h.Write(content)
+	return macData{
+		Mac:        digestInfo{Algorithm: pkix.AlgorithmIdentifier{Algorithm: digest, Parameters: asn1.NullRawValue}, Digest: h.Sum(nil)},
+		MacSalt:    salt,
+		Iterations: pkcs12Iterations,
+	}, nil
+}
+
+// bmpPassword encodes a password as PKCS #12 expects for its own key
+// derivation: big-endian UTF-16 with a terminating zero.
+func bmpPassword(password string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(password)) {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return append(b, 0, 0)
+}
+
+// pkcs12KDF derives n bytes of key material of type id (1 for a key, 2 for
+// an IV, 3 for a MAC key) from a password, as RFC 7292 appendix B.2 does.
+func pkcs12KDF(newHash func() hash.Hash, id byte, password, salt []byte, iterations, n int) []byte {
+	h := newHash()
+	v := h.BlockSize()
+
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	d := bytes.Repeat([]byte{id}, v)
+	i := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < n {
+		h.Reset()
+		h.Write(d)
+		h.Write(i)
+		a := h.Sum(nil)
+		for range iterations - 1 {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(a[:0])
+		}
+		out = append(out, a...)
+		if len(out) >= n {
+			break
+		}
+		// Add B+1, B being A repeated, to each v-byte block of I
+		b := fill(a)[:v]
+		for j := 0; j < len(i); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(i[j+k]) + int(b[k]) + carry
+				i[j+k], carry = byte(sum), sum>>8
+			}
+		}
+	}
+	return out[:n]
+}
+
+// unmarshalBER parses data, which may be BER as Windows writes it, into
+// out.
+func unmarshalBER(data []byte, out any) error {
+	der, err := berToDER(data)
+	if err != nil {
+		return err
+	}
+	rest, err := asn1.Unmarshal(der, out)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errors.New("trailing data after PKCS #12 structure")
+	}
+	return nil
+}
+
+// berToDER rewrites the BER element in data with definite lengths, and
+// with constructed OCTET STRINGs joined into one, which is all encoding/asn1
+// needs to read what Windows writes.
+func berToDER(data []byte) ([]byte, error) {
+	out, rest, err := berElement(data)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, rest...), nil
+}
+
+// berElement converts the first element of data, returning it and what
+// follows.
+func berElement(data []byte) (der, rest []byte, err error) {
+	malformed := errors.New("malformed ASN.1 in PKCS #12 bundle")
+	if len(data) < 2 {
+		return nil, nil, malformed
+	}
+	// Identifier octets, with high tag numbers in base 128
+	n := 1
+	if data[0]&0x1f == 0x1f {
+		for n < len(data) && data[n]&0x80 != 0 {
+			n++
+		}
+		n++
+	}
+	if n >= len(data) {
+		return nil, nil, malformed
+	}
+	tag, constructed := data[:n], data[0]&0x20 != 0
+
+	// Length octets
+	length, indefinite := 0, false
+	switch l := data[n]; {
+	case l == 0x80:
+		indefinite = true
+		n++
+	case l < 0x80:
+		length = int(l)
+		n++
+	default:
+		count := int(l & 0x7f)
+		if count > 4 || n+1+count > len(data) {
+			return nil, nil, malformed
+		}
+		for _, b := range data[n+1 : n+1+count] {
+			length = length<<8 | int(b)
+		}
+		n += 1 + count
+	}
+	body := data[n:]
+	if !indefinite && length > len(body) {
+		return nil, nil, malformed
+	}
+
+	if !constructed {
+		if indefinite {
+			return nil, nil, malformed
+		}
+		return append(append([]byte(nil), data[:n]...), body[:length]...), body[length:], nil
+	}
+
+	var children []byte
+	if !indefinite {
+		rest, body = body[length:], body[:length]
+	}
+	octetString := data[0] == 0x24 // universal, constructed, OCTET STRING
+	for {
+		if indefinite {
+			if len(body) < 2 {
+				return nil, nil, malformed
+			}
+			if body[0] == 0 && body[1] == 0 {
+				rest = body[2:]
+				break
+			}
+		} else if len(body) == 0 {
+			break
+		}
+		child, after, err := berElement(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		if octetString {
+			var part []byte
+			if _, err := asn1.Unmarshal(child, &part); err != nil {
+				return nil, nil, err
+			}
+			child = part
+		}
+		children = append(children, child...)
+		body = after
+	}
+
+	if octetString {
+		tag = []byte{0x04}
+	}
+	return append(append(append([]byte(nil), tag...), derLength(len(children))...), children...), rest, nil
+}
+
+// derLength encodes n as DER length octets.
+func derLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// rc2Cipher is RC2 (RFC 2268), which only old PKCS #12 bundles use, for
+// their certificates.
+type rc2Cipher struct {
+	k [64]uint16
+}
+
+// rc2PiTable is PITABLE, a permutation of 0-255 from the digits of pi.
+var rc2PiTable = [256]byte{
+	0xd9, 0x78, 0xf9, 0xc4, 0x19, 0xdd, 0xb5, 0xed, 0x28, 0xe9, 0xfd, 0x79, 0x4a, 0xa0, 0xd8, 0x9d,
+	0xc6, 0x7e, 0x37, 0x83, 0x2b, 0x76, 0x53, 0x8e, 0x62, 0x4c, 0x64, 0x88, 0x44, 0x8b, 0xfb, 0xa2,
+	0x17, 0x9a, 0x59, 0xf5, 0x87, 0xb3, 0x4f, 0x13, 0x61, 0x45, 0x6d, 0x8d, 0x09, 0x81, 0x7d, 0x32,
+	0xbd, 0x8f, 0x40, 0xeb, 0x86, 0xb7, 0x7b, 0x0b, 0xf0, 0x95, 0x21, 0x22, 0x5c, 0x6b, 0x4e, 0x82,
+	0x54, 0xd6, 0x65, 0x93, 0xce, 0x60, 0xb2, 0x1c, 0x73, 0x56, 0xc0, 0x14, 0xa7, 0x8c, 0xf1, 0xdc,
+	0x12, 0x75, 0xca, 0x1f, 0x3b, 0xbe, 0xe4, 0xd1, 0x42, 0x3d, 0xd4, 0x30, 0xa3, 0x3c, 0xb6, 0x26,
+	0x6f, 0xbf, 0x0e, 0xda, 0x46, 0x69, 0x07, 0x57, 0x27, 0xf2, 0x1d, 0x9b, 0xbc, 0x94, 0x43, 0x03,
+	0xf8, 0x11, 0xc7, 0xf6, 0x90, 0xef, 0x3e, 0xe7, 0x06, 0xc3, 0xd5, 0x2f, 0xc8, 0x66, 0x1e, 0xd7,
+	0x08, 0xe8, 0xea, 0xde, 0x80, 0x52, 0xee, 0xf7, 0x84, 0xaa, 0x72, 0xac, 0x35, 0x4d, 0x6a, 0x2a,
+	0x96, 0x1a, 0xd2, 0x71, 0x5a, 0x15, 0x49, 0x74, 0x4b, 0x9f, 0xd0, 0x5e, 0x04, 0x18, 0xa4, 0xec,
+	0xc2, 0xe0, 0x41, 0x6e, 0x0f, 0x51, 0xcb, 0xcc, 0x24, 0x91, 0xaf, 0x50, 0xa1, 0xf4, 0x70, 0x39,
+	0x99, 0x7c, 0x3a, 0x85, 0x23, 0xb8, 0xb4, 0x7a, 0xfc, 0x02, 0x36, 0x5b, 0x25, 0x55, 0x97, 0x31,
+	0x2d, 0x5d, 0xfa, 0x98, 0xe3, 0x8a, 0x92, 0xae, 0x05, 0xdf, 0x29, 0x10, 0x67, 0x6c, 0xba, 0xc9,
+	0xd3, 0x00, 0xe6, 0xcf, 0xe1, 0x9e, 0xa8, 0x2c, 0x63, 0x16, 0x01, 0x3f, 0x58, 0xe2, 0x89, 0xa9,
+	0x0d, 0x38, 0x34, 0x1b, 0xab, 0x33, 0xff, 0xb0, 0xbb, 0x48, 0x0c, 0x5f, 0xb9, 0xb1, 0xcd, 0x2e,
+	0xc5, 0xf3, 0xdb, 0x47, 0xe5, 0xa5, 0x9c, 0x77, 0x0a, 0xa6, 0x20, 0x68, 0xfe, 0x7f, 0xc1, 0xad,
+}
+
+// newRC2 expands key for effective key length bits.
+func newRC2(key []byte, bits int) *rc2Cipher {
+	var l [128]byte
+	copy(l[:], key)
+	t := len(key)
+	for i := t; i < 128; i++ {
+		l[i] = rc2PiTable[l[i-1]+l[i-t]]
+	}
+	t8 := (bits + 7) / 8
+	l[128-t8] = rc2PiTable[l[128-t8]&(0xff>>(8*t8-bits))]
+	for i := 127 - t8; i >= 0; i-- {
+		l[i] = rc2PiTable[l[i+1]^l[i+t8]]
+	}
+	c := &rc2Cipher{}
+	for i := range c.k {
+		c.k[i] = uint16(l[2*i]) | uint16(l[2*i+1])<<8
+	}
+	return c
+}
+
+func (c *rc2Cipher) BlockSize() int { return 8 }
+
+var rc2Shifts = [4]uint{1, 2, 3, 5}
+
+func (c *rc2Cipher) Encrypt(dst, src []byte) {
+	var r [4]uint16
+	for i := range r {
+		r[i] = uint16(src[2*i]) | uint16(src[2*i+1])<<8
+	}
+	j := 0
+	mix := func() {
+		for i := range 4 {
+			r[i] += c.k[j] + (r[(i+3)%4] & r[(i+2)%4]) + (^r[(i+3)%4] & r[(i+1)%4])
+			r[i] = r[i]<<rc2Shifts[i] | r[i]>>(16-rc2Shifts[i])
+			j++
+		}
+	}
+	mash := func() {
+		for i := range 4 {
+			r[i] += c.k[r[(i+3)%4]&63]
+		}
+	}
+	for _, rounds := range []int{5, 6, 5} {
+		if j > 0 {
+			mash()
+		}
+		for range rounds {
+			mix()
+		}
+	}
+	for i := range r {
+		dst[2*i], dst[2*i+1] = byte(r[i]), byte(r[i]>>8)
+	}
+}
+
+func (c *rc2Cipher) Decrypt(dst, src []byte) {
+	var r [4]uint16
+	for i := range r {
+		r[i] = uint16(src[2*i]) | uint16(src[2*i+1])<<8
+	}
+	j := 63
+	mix := func() {
+		for i := 3; i >= 0; i-- {
+			r[i] = r[i]>>rc2Shifts[i] | r[i]<<(16-rc2Shifts[i])
+			r[i] -= c.k[j] + (r[(i+3)%4] & r[(i+2)%4]) + (^r[(i+3)%4] & r[(i+1)%4])
+			j--
+		}
+	}
+	mash := func() {
+		for i := 3; i >= 0; i-- {
+			r[i] -= c.k[r[(i+3)%4]&63]
+		}
+	}
+	for _, rounds := range []int{5, 6, 5} {
+		if j < 63 {
+			mash()
+		}
+		for range rounds {
+			mix()
+		}
+	}
+	for i := range r {
+		dst[2*i], dst[2*i+1] = byte(r[i]), byte(r[i]>>8)
+	}
+}
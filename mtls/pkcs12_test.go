@@ -0,0 +1,191 @@
+package mtls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The bundles in testdata were exported by openssl pkcs12 from certgen
+// output: identity.p12 holds chain-client with its intermediate and the
+// root in pkcs12-ca.crt, encrypted as OpenSSL 3 does (PBES2, AES-256,
+// HMAC-SHA256) with the passphrase correct-horse; legacy.pfx an RSA client
+// encrypted with -legacy (RC2-40 and triple DES, a SHA-1 MAC) with the
+// passphrase pässwörd; and plain.p12 a client with neither encryption nor
+// a MAC.
+
+// leafOf parses the certificate a loaded identity presents.
+func leafOf(t *testing.T, cert tls.Certificate) *x509.Certificate {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
+
+func TestDecodePKCS12(t *testing.T) {
+	caPEM, err := os.ReadFile(filepath.Join("testdata", "pkcs12-ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+
+	// The chain comes back after the leaf, without the root, and verifies
+	t.Setenv("TEST_PKCS12_PASSPHRASE", "correct-horse")
+	cert, err := LoadKeyPair(filepath.Join("testdata", "identity.p12"), "env:TEST_PKCS12_PASSPHRASE", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 2 {
+		t.Fatalf("identity.p12: %d certificates, want the leaf and the intermediate", len(cert.Certificate))
+	}
+	leaf := leafOf(t, cert)
+	intermediate, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate)
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("identity.p12: %v", err)
+	}
+	if key, ok := cert.PrivateKey.(*ecdsa.PrivateKey); !ok || !key.PublicKey.Equal(leaf.PublicKey) {
+		t.Errorf("identity.p12: key %T does not match the leaf", cert.PrivateKey)
+	}
+
+	cert, err = LoadKeyPair(filepath.Join("testdata", "legacy.pfx"), "pass:pässwörd", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf := leafOf(t, cert); leaf.Subject.CommonName != "pkcs12-legacy-client" || len(cert.Certificate) != 1 {
+		t.Errorf("legacy.pfx: %s with %d certificates", leaf.Subject.CommonName, len(cert.Certificate))
+	}
+	if _, ok := cert.PrivateKey.(*rsa.PrivateKey); !ok {
+		t.Errorf("legacy.pfx: key %T, want RSA", cert.PrivateKey)
+	}
+
+	// A bundle with neither encryption nor a MAC needs no passphrase
+	cert, err = LoadKeyPair(filepath.Join("testdata", "plain.p12"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf := leafOf(t, cert); leaf.Subject.CommonName != "pkcs12-client" {
+		t.Errorf("plain.p12: %s", leaf.Subject.CommonName)
+	}
+
+	for _, tc := range []struct{ file, pass string }{
+		{"identity.p12", "pass:battery-staple"},
+		{"identity.p12", ""},
+		{"legacy.pfx", "pass:passwort"},
+	} {
+		if _, err := LoadKeyPair(filepath.Join("testdata", tc.file), tc.pass, ""); !errors.Is(err, ErrIncorrectPassphrase) {
+			t.Errorf("%s with %q: %v, want %v", tc.file, tc.pass, err, ErrIncorrectPassphrase)
+		}
+	}
+	if _, err := DecodePKCS12(caPEM, ""); err == nil {
+		t.Error("decoded a PEM certificate as PKCS #12")
+	}
+}
+
+// TestEncodePKCS12 checks that the bundles EncodePKCS12 writes, modern
+// and legacy, decode to the key and chain they were given.
+func TestEncodePKCS12(t *testing.T) {
+	ca, caKey := newTestCA(t, "PKCS12-CA")
+	cert := issueCert(t, ca, caKey, "encoded-client", x509.ExtKeyUsageClientAuth)
+	for _, legacy := range []bool{false, true} {
+		data, err := EncodePKCS12(cert.PrivateKey, [][]byte{cert.Certificate[0], ca.Raw}, "encoded-client", "chângeit", legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodePKCS12(data, "chângeit")
+		if err != nil {
+			t.Fatalf("legacy %v: %v", legacy, err)
+		}
+		// The self-signed root is left out, as for any bundle
+		if len(decoded.Certificate) != 1 || leafOf(t, decoded).Subject.CommonName != "encoded-client" {
+			t.Errorf("legacy %v: %d certificates", legacy, len(decoded.Certificate))
+		}
+		if key, ok := decoded.PrivateKey.(*ecdsa.PrivateKey); !ok || !key.Equal(cert.PrivateKey) {
+			t.Errorf("legacy %v: decoded a different key", legacy)
+		}
+		if _, err := DecodePKCS12(data, "changeit"); !errors.Is(err, ErrIncorrectPassphrase) {
+			t.Errorf("legacy %v, wrong passphrase: %v", legacy, err)
+		}
+	}
+	if _, err := EncodePKCS12(cert.PrivateKey, nil, "encoded-client", "", false); err == nil {
+		t.Error("encoded a key without its certificate")
+	}
+}
+
+func TestPKCS12Loaders(t *testing.T) {
+	bundle := filepath.Join("testdata", "identity.p12")
+	config, err := LoadClientTLSConfig(Config{CertFile: bundle, KeyFile: "pass:correct-horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Certificates) != 1 || leafOf(t, config.Certificates[0]).Subject.CommonName != "chain-client" {
+		t.Errorf("client certificates: %d", len(config.Certificates))
+	}
+	if _, err := LoadServerTLSConfig(Config{CertFile: bundle, KeyFile: "pass:correct-horse"}); err != nil {
+		t.Error(err)
+	}
+
+	// Only a bundle may go without a key
+	if _, err := LoadClientTLSConfig(Config{CertFile: filepath.Join("testdata", "plain.p12")}); err != nil {
+		t.Error(err)
+	}
+	if _, err := LoadClientTLSConfig(Config{CertFile: filepath.Join("testdata", "encrypted-client.crt")}); err == nil {
+		t.Error("PEM certificate loaded without a key")
+	}
+}
+
+func TestBERToDER(t *testing.T) {
+	// An indefinite-length SEQUENCE holding an OCTET STRING in two
+	// indefinite-length parts, as Windows writes them
+	ber, _ := hex.DecodeString("308024800402010204010300000000")
+	der, err := berToDER(ber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := hex.DecodeString("30050403010203"); !bytes.Equal(der, want) {
+		t.Errorf("berToDER = %x, want %x", der, want)
+	}
+	if _, err := berToDER(ber[:len(ber)-2]); err == nil {
+		t.Error("unterminated BER accepted")
+	}
+}
+
+// TestRC2 checks the cipher against the vectors in RFC 2268 section 5.
+func TestRC2(t *testing.T) {
+	for _, tc := range []struct {
+		key, plaintext, ciphertext string
+		bits                       int
+	}{
+		{"0000000000000000", "0000000000000000", "ebb773f993278eff", 63},
+		{"ffffffffffffffff", "ffffffffffffffff", "278b27e42e2f0d49", 64},
+		{"3000000000000000", "1000000000000001", "30649edf9be7d2c2", 64},
+		{"88bca90e90875a7f0f79c384627bafb2", "0000000000000000", "2269552ab0f85ca6", 128},
+	} {
+		key, _ := hex.DecodeString(tc.key)
+		plaintext, _ := hex.DecodeString(tc.plaintext)
+		c := newRC2(key, tc.bits)
+		got := make([]byte, 8)
+		c.Encrypt(got, plaintext)
+		if hex.EncodeToString(got) != tc.ciphertext {
+			t.Errorf("RC2 %s: %x, want %s", tc.key, got, tc.ciphertext)
+		}
+		c.Decrypt(got, got)
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("RC2 %s: decrypted to %x", tc.key, got)
+		}
+	}
+}
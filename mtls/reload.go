@@ -0,0 +1,99 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Reloader serves a certificate from a certificate and key file, or a
+// PKCS #12 bundle, reloading them when either changes, so that a
+// long-running server or client presents a rotated certificate on its next
+// handshake without a restart. Connections already open keep the identity
+// they were made with.
+type Reloader struct {
+	certFile, keyFile, keyPass string
+
+	mu    sync.Mutex
+	cert  *tls.Certificate
+	stamp string
+}
+
+// NewReloader loads the certificate, which must be valid to start. An
+// encrypted key is decrypted with the passphrase from keyPass.
+func NewReloader(certFile, keyFile, keyPass string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, keyPass: keyPass}
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := LoadKeyPair(certFile, keyFile, keyPass)
+	if err != nil {
+		return nil, err
+	}
+	r.cert, r.stamp = &cert, stamp
+	return r, nil
+}
+
+// fileStamp identifies the current version of both files by size and
+// modification time. The key of a PKCS #12 bundle is its passphrase, and
+// that on a PKCS #11 token a URI, not a file.
+func (r *Reloader) fileStamp() (string, error) {
+	paths := []string{r.certFile, r.keyFile}
+	if IsPKCS12(r.certFile) || IsPKCS11(r.keyFile) {
+		paths = paths[:1]
+	}
+	stamp := ""
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%d/%d;", info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp, nil
+}
+
+// Certificate returns the certificate, reloading it if the files changed.
+// If they cannot be loaded, for example because the certificate has been
+// replaced but the key not yet, the previous certificate is kept and the
+// load is retried on the next call.
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stamp, err := r.fileStamp()
+	if err != nil || stamp == r.stamp {
+		return r.cert
+	}
+	cert, err := LoadKeyPair(r.certFile, r.keyFile, r.keyPass)
+	if err != nil {
+		return r.cert
+	}
+	r.cert, r.stamp = &cert, stamp
+	return r.cert
+}
+
+// SetCertificate serves cert, such as one renewed from a CA, in place of
+// that in the files until they change.
+func (r *Reloader) SetCertificate(cert *tls.Certificate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = cert
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate. Like a
+// static tls.Config.Certificates, it sends no certificate if the server
+// does not accept the current one.
+func (r *Reloader) GetClientCertificate(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert := r.Certificate()
+	if err := cri.SupportsCertificate(cert); err != nil {
+		return &tls.Certificate{}, nil
+	}
+	return cert, nil
+}
@@ -0,0 +1,109 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// served returns the common name of the certificate r serves now.
+func served(t *testing.T, r *Reloader) string {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(r.Certificate().Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	ca, caKey := newTestCA(t, "Reload-CA")
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	write := func(name string) {
+		writeFiles(t, certFile, keyFile, issueCert(t, ca, caKey, name, x509.ExtKeyUsageClientAuth))
+	}
+
+	write("first")
+	r, err := NewReloader(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("NewReloader: %v", err)
+	}
+	if got := served(t, r); got != "first" {
+		t.Fatalf("Served %s, want first", got)
+	}
+
+	write("second")
+	if got := served(t, r); got != "second" {
+		t.Errorf("Served %s after rotation, want second", got)
+	}
+
+	// A certificate whose key has not been replaced yet keeps the previous one
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("third")
+	if err := os.WriteFile(keyFile, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := served(t, r); got != "second" {
+		t.Errorf("Served %s with a mismatched key, want second", got)
+	}
+
+	// A certificate set in place of the files is served until they change
+	r.SetCertificate(issueCert(t, ca, caKey, "renewed", x509.ExtKeyUsageClientAuth))
+	if got := served(t, r); got != "renewed" {
+		t.Errorf("Served %s after SetCertificate, want renewed", got)
+	}
+	write("fourth")
+	if got := served(t, r); got != "fourth" {
+		t.Errorf("Served %s after the files changed, want fourth", got)
+	}
+
+	os.Remove(certFile)
+	if got := served(t, r); got != "fourth" {
+		t.Errorf("Served %s with the certificate missing, want fourth", got)
+	}
+
+	if _, err := NewReloader(certFile, keyFile, ""); err == nil {
+		t.Error("NewReloader succeeded with the certificate missing")
+	}
+}
+
+// TestReloaderPKCS12 checks that a bundle is reloaded when it is
+// replaced, though its key is a passphrase rather than a file.
+func TestReloaderPKCS12(t *testing.T) {
+	bundle := filepath.Join(t.TempDir(), "client.p12")
+	install := func(name string) {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(bundle, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	install("identity.p12")
+	r, err := NewReloader(bundle, "pass:correct-horse", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := served(t, r); got != "chain-client" {
+		t.Fatalf("Served %s, want chain-client", got)
+	}
+
+	// A bundle the passphrase does not open keeps the previous certificate
+	install("legacy.pfx")
+	if got := served(t, r); got != "chain-client" {
+		t.Errorf("Served %s from a bundle with another passphrase, want chain-client", got)
+	}
+
+	// plain.p12 needs no passphrase, so opens with any
+	install("plain.p12")
+	if got := served(t, r); got != "pkcs12-client" {
+		t.Errorf("Served %s after rotation, want pkcs12-client", got)
+	}
+}
@@ -0,0 +1,16 @@
+module mtlsopenai
+
+go 1.25.1
+
+require (
+	github.com/sashabaranov/go-openai v1.41.2
+	mtls v0.0.0
+)
+
+require (
+	github.com/miekg/pkcs11 v1.1.1 // indirect
+	golang.org/x/sys v0.38.0 // indirect
+	golang.org/x/term v0.37.0 // indirect
+)
+
+replace mtls => ../mtls
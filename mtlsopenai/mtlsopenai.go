@@ -0,0 +1,94 @@
+// Package mtlsopenai configures the sashabaranov/go-openai client for an
+// OpenAI-compatible API behind a gateway that requires client
+// certificates:
+//
+//	config, err := mtlsopenai.NewClientConfig("https://gateway.example.com/v1",
+//		"certs/client.crt", "certs/client.key", "certs/ca.crt")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	client := openai.NewClientWithConfig(config)
+//
+// Certificates are loaded by the mtls package, so PKCS #12 bundles and
+// keys on PKCS #11 tokens work as they do for the test client and proxy.
+package mtlsopenai
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"mtls"
+)
+
+// The transport's limits. There is no limit on a whole request, which
+// would cut streamed completions short; give requests a context with a
+// deadline for that. Completions that are not streamed are generated
+// before the response headers are sent, so those get minutes.
+const (
+	dialTimeout           = 10 * time.Second
+	tlsHandshakeTimeout   = 10 * time.Second
+	responseHeaderTimeout = 5 * time.Minute
+	idleConnTimeout       = 90 * time.Second
+
+	// maxIdleConns is the number of connections kept open for reuse.
+	// http.Transport keeps two by default, so concurrent requests beyond
+	// that would each pay for a new mTLS handshake.
+	maxIdleConns = 64
+)
+
+// NewClientConfig returns a go-openai configuration for the API at
+// baseURL, such as https://gateway.example.com/v1, that presents the
+// client certificate in certFile and keyFile, and trusts server
+// certificates issued by the CA in caFile, or the system roots if it is
+// empty. The certificate is reloaded when its files change, so a renewed
+// one is used from the next connection without a restart.
+//
+// The API key, for gateways that want one as well as a certificate, is
+// taken from OPENAI_API_KEY as the OpenAI SDKs do. To use another, take
+// BaseURL and HTTPClient from the result into openai.DefaultConfig(key).
+func NewClientConfig(baseURL, certFile, keyFile, caFile string) (openai.ClientConfig, error) {
+	return NewClientConfigWithTLS(baseURL, mtls.Config{CertFile: certFile, KeyFile: keyFile, CAFile: caFile})
+}
+
+// NewClientConfigWithTLS is NewClientConfig for certificates that need
+// more than three files to load, such as a key passphrase (KeyPass) or a
+// server name other than baseURL's host (ServerName). The certificate is
+// reloaded when its files change whether or not c.Reload is set.
+func NewClientConfigWithTLS(baseURL string, c mtls.Config) (openai.ClientConfig, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return openai.ClientConfig{}, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return openai.ClientConfig{}, fmt.Errorf("base URL %q is not https://host/...: client certificates need TLS", baseURL)
+	}
+	if c.CertFile == "" {
+		return openai.ClientConfig{}, fmt.Errorf("a client certificate is needed")
+	}
+
+	c.Reload = true
+	tlsConfig, err := mtls.LoadClientTLSConfig(c)
+	if err != nil {
+		return openai.ClientConfig{}, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = tlsHandshakeTimeout
+	transport.ResponseHeaderTimeout = responseHeaderTimeout
+	transport.IdleConnTimeout = idleConnTimeout
+	transport.MaxIdleConns = maxIdleConns
+	transport.MaxIdleConnsPerHost = maxIdleConns
+
+	config := openai.DefaultConfig(os.Getenv("OPENAI_API_KEY"))
+	// go-openai appends paths such as /chat/completions to the base URL
+	config.BaseURL = strings.TrimSuffix(baseURL, "/")
+	config.HTTPClient = &http.Client{Transport: transport}
+	return config, nil
+}
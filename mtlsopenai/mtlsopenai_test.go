@@ -0,0 +1,165 @@
+package mtlsopenai
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"mtls"
+)
+
+// writeTestPKI writes a CA, a server certificate for 127.0.0.1 and a
+// client certificate for test-client to dir, returning their paths.
+func writeTestPKI(t *testing.T, dir string) (caFile, serverCert, serverKey, clientCert, clientKey string) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	write := func(name string, block *pem.Block) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+		return write(name+".crt", &pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			write(name+".key", &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	}
+
+	caFile = write("ca.crt", &pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	serverCert, serverKey = issue("server", 2, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey = issue("test-client", 3, x509.ExtKeyUsageClientAuth)
+	return
+}
+
+func TestNewClientConfig(t *testing.T) {
+	caFile, serverCert, serverKey, clientCert, clientKey := writeTestPKI(t, t.TempDir())
+	serverConfig, err := mtls.LoadServerTLSConfig(mtls.Config{CertFile: serverCert, KeyFile: serverKey, CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var connections atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization = %q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"object":"list","data":[{"id":"`+r.TLS.PeerCertificates[0].Subject.CommonName+`","object":"model"}]}`)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	srv.TLS = serverConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	config, err := NewClientConfig(srv.URL+"/v1/", clientCert, clientKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := openai.NewClientWithConfig(config)
+
+	// The server names its model after the certificate it was shown, and
+	// the requests share one connection
+	for range 3 {
+		models, err := client.ListModels(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(models.Models) != 1 || models.Models[0].ID != "test-client" {
+			t.Errorf("models = %+v", models.Models)
+		}
+	}
+	if n := connections.Load(); n != 1 {
+		t.Errorf("%d connections for 3 requests, want 1", n)
+	}
+}
+
+func TestNewClientConfigErrors(t *testing.T) {
+	caFile, _, _, clientCert, clientKey := writeTestPKI(t, t.TempDir())
+	for _, tc := range []struct {
+		name, baseURL, cert, key, ca string
+	}{
+		{"http", "http://127.0.0.1/v1", clientCert, clientKey, caFile},
+		{"no host", "/v1", clientCert, clientKey, caFile},
+		{"no certificate", "https://127.0.0.1/v1", "", "", caFile},
+		{"no key", "https://127.0.0.1/v1", clientCert, "", caFile},
+		{"missing CA", "https://127.0.0.1/v1", clientCert, clientKey, filepath.Join(t.TempDir(), "ca.crt")},
+	} {
+		if _, err := NewClientConfig(tc.baseURL, tc.cert, tc.key, tc.ca); err == nil {
+			t.Errorf("%s: accepted", tc.name)
+		}
+	}
+
+	// The passphrase of an encrypted key, and a server name, go through
+	// NewClientConfigWithTLS
+	config, err := NewClientConfigWithTLS("https://10.0.0.1:8443/v1", mtls.Config{CertFile: clientCert, KeyFile: clientKey, CAFile: caFile, ServerName: "gateway"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := config.HTTPClient.(*http.Client).Transport.(*http.Transport)
+	if tlsConfig := transport.TLSClientConfig; tlsConfig.ServerName != "gateway" || tlsConfig.GetClientCertificate == nil || tlsConfig.MinVersion < tls.VersionTLS12 {
+		t.Errorf("TLS config: server name %q", tlsConfig.ServerName)
+	}
+	if transport.MaxIdleConnsPerHost < 2 || transport.ResponseHeaderTimeout == 0 {
+		t.Errorf("transport: %d idle connections per host, response header timeout %v", transport.MaxIdleConnsPerHost, transport.ResponseHeaderTimeout)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mtls"
+)
+
+// With -auto-certs, a first run needs no certgen or openssl: if none of
+// -cert, -key and -ca exist, a CA is generated for this machine alone, with
+// a server certificate for localhost and a client certificate issued from
+// it, and the server starts with them. They are written where the flags
+// point, with the client's beside the server's, under the names certgen
+// uses, so later runs, and the test client and proxy, find them.
+
+// autoCertHosts are the names the generated server certificate is valid
+// for.
+var autoCertHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// autoCertLifetime is how long the generated certificates last. They are
+// for trying the server out; certgen makes certificates to keep.
+const autoCertLifetime = 90 * 24 * time.Hour
+
+// autoCertsClient is the common name of the generated client certificate,
+// as certgen names its first client.
+const autoCertsClient = "test-client"
+
+// autoCerts are the files -auto-certs generated.
+type autoCerts struct {
+	caFile, caKeyFile         string
+	certFile, keyFile         string
+	clientFile, clientKeyFile string
+	notAfter                  time.Time
+}
+
+// generateAutoCerts generates a CA, a server and a client certificate if
+// none of certFile, keyFile and caFile exist, and returns what it wrote.
+// It returns nil if they all exist, and an error if only some do, rather
+// than replace them.
+func generateAutoCerts(certFile, keyFile, caFile string, now time.Time) (*autoCerts, error) {
+	var existing, missing []string
+	for _, path := range []string{certFile, keyFile, caFile} {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, path)
+		} else {
+			existing = append(existing, path)
+		}
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	if len(existing) > 0 {
+		return nil, fmt.Errorf("found %s but not %s; remove what was found to generate new certificates, or give -cert, -key and -ca", strings.Join(existing, ", "), strings.Join(missing, ", "))
+	}
+	if mtls.IsPKCS12(certFile) {
+		return nil, fmt.Errorf("-auto-certs writes PEM files, not a PKCS #12 -cert %s", certFile)
+	}
+
+	dir := filepath.Dir(certFile)
+	generated := &autoCerts{
+		caFile: caFile, caKeyFile: filepath.Join(filepath.Dir(caFile), "ca.key"),
+		certFile: certFile, keyFile: keyFile,
+		clientFile: filepath.Join(dir, "client.crt"), clientKeyFile: filepath.Join(dir, "client.key"),
+		notAfter: now.Add(autoCertLifetime),
+	}
+	for _, path := range []string{generated.caKeyFile, generated.clientFile, generated.clientKeyFile} {
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("%s exists; remove it to generate new certificates", path)
+		}
+	}
+	for _, path := range []string{certFile, keyFile, caFile} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	caName := "MockOpenAI-Auto-CA"
+	if hostname != "" {
+		caName += " (" + hostname + ")"
+	}
+	notBefore := now.Add(-time.Hour)
+	caTemplate := &x509.Certificate{
+		Subject:               pkix.Name{Organization: []string{"MockOpenAI"}, CommonName: caName},
+		NotBefore:             notBefore,
+		NotAfter:              generated.notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	ca, caKey, err := createAutoCert(caTemplate, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	server := &x509.Certificate{
+		Subject:     pkix.Name{Organization: []string{"MockOpenAI"}, CommonName: "localhost"},
+		NotBefore:   notBefore,
+		NotAfter:    generated.notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range autoCertHosts {
+		if ip := net.ParseIP(host); ip != nil {
+			server.IPAddresses = append(server.IPAddresses, ip)
+		} else {
+			server.DNSNames = append(server.DNSNames, host)
+		}
+	}
+	serverCert, serverKey, err := createAutoCert(server, ca, caKey)
+	if err != nil {
+		return nil, err
+	}
+	clientCert, clientKey, err := createAutoCert(&x509.Certificate{
+		Subject:     pkix.Name{Organization: []string{"MockOpenAI"}, CommonName: autoCertsClient},
+		NotBefore:   notBefore,
+		NotAfter:    generated.notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// The CA goes last, so a run cut short leaves it missing, and the next
+	// run does not mistake the files for a complete set
+	for _, file := range []struct {
+		path string
+		cert *x509.Certificate
+		key  crypto.Signer
+	}{
+		{generated.certFile, serverCert, nil},
+		{generated.keyFile, nil, serverKey},
+		{generated.clientFile, clientCert, nil},
+		{generated.clientKeyFile, nil, clientKey},
+		{generated.caKeyFile, nil, caKey},
+		{generated.caFile, ca, nil},
+	} {
+		if file.cert != nil {
+			err = os.WriteFile(file.path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: file.cert.Raw}), 0o644)
+		} else {
+			var der []byte
+			if der, err = x509.MarshalPKCS8PrivateKey(file.key); err == nil {
+				err = os.WriteFile(file.path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return generated, nil
+}
+
+// createAutoCert issues template with a new P-256 key, from parent, or
+// self-signed if parent is nil, with a random serial.
+func createAutoCert(template, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if template.SerialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128)); err != nil {
+		return nil, nil, err
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// printAutoCerts lists the generated files and how to connect with them.
+func printAutoCerts(w io.Writer, c *autoCerts, port string) {
+	fmt.Fprintln(w, "Generated mTLS certificates for this machine (-auto-certs):")
+	fmt.Fprintf(w, "  CA:     %s, %s\n", c.caFile, c.caKeyFile)
+	fmt.Fprintf(w, "  Server: %s, %s (for %s)\n", c.certFile, c.keyFile, strings.Join(autoCertHosts, ", "))
+	fmt.Fprintf(w, "  Client: %s, %s (CN=%s)\n", c.clientFile, c.clientKeyFile, autoCertsClient)
+	fmt.Fprintf(w, "  Valid until %s; for trying the server out, not for anything shared\n", c.notAfter.UTC().Format("2006-01-02"))
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Connect with the client certificate:")
+	fmt.Fprintf(w, "  curl --cacert %s --cert %s --key %s https://localhost:%s/v1/models\n", c.caFile, c.clientFile, c.clientKeyFile, port)
+	fmt.Fprintf(w, "  ./openai-test-client -cert %s -key %s -ca %s -port %s\n", c.clientFile, c.clientKeyFile, c.caFile, port)
+	fmt.Fprintln(w)
+}
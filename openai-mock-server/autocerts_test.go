@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mtls"
+)
+
+func TestAutoCerts(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "certs")
+	certFile, keyFile, caFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt")
+	now := time.Now()
+
+	generated, err := generateAutoCerts(certFile, keyFile, caFile, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generated == nil {
+		t.Fatal("nothing generated")
+	}
+	if info, err := os.Stat(generated.keyFile); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("server key: %v, %v", info, err)
+	}
+
+	roots := x509.NewCertPool()
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil || !roots.AppendCertsFromPEM(caPEM) {
+		t.Fatalf("reading %s: %v", caFile, err)
+	}
+
+	// The server certificate is for localhost, by name and address
+	server, err := mtls.LoadKeyPair(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"localhost", "127.0.0.1", "::1"} {
+		if _, err := server.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots, CurrentTime: now}); err != nil {
+			t.Errorf("server certificate for %s: %v", host, err)
+		}
+	}
+
+	// The client certificate is for client auth, from the same CA
+	client, err := mtls.LoadKeyPair(generated.clientFile, generated.clientKeyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Leaf.Verify(x509.VerifyOptions{Roots: roots, CurrentTime: now, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("client certificate: %v", err)
+	}
+	if client.Leaf.Subject.CommonName != autoCertsClient {
+		t.Errorf("client CN = %q", client.Leaf.Subject.CommonName)
+	}
+
+	var out bytes.Buffer
+	printAutoCerts(&out, generated, "8443")
+	if want := "curl --cacert " + caFile + " --cert " + generated.clientFile; !strings.Contains(out.String(), want) {
+		t.Errorf("output lacks %q:\n%s", want, out.String())
+	}
+
+	// A second run keeps what the first wrote
+	before, _ := os.ReadFile(certFile)
+	if again, err := generateAutoCerts(certFile, keyFile, caFile, now); err != nil || again != nil {
+		t.Errorf("second run: %v, %v", again, err)
+	}
+	if after, _ := os.ReadFile(certFile); !bytes.Equal(before, after) {
+		t.Error("second run replaced the server certificate")
+	}
+
+	// Only some of the files is an error, not a new CA
+	if err := os.Remove(caFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generateAutoCerts(certFile, keyFile, caFile, now); err == nil {
+		t.Error("partial set accepted")
+	}
+	if _, err := os.Stat(caFile); err == nil {
+		t.Error("partial set regenerated the CA")
+	}
+}
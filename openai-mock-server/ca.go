@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+
+	"mtls"
+)
+
+// caSignPath is where the CA service takes certificate signing requests.
+const caSignPath = "/ca/sign"
+
+// maxCSRSize bounds the body of a signing request; a CSR is a few hundred
+// bytes, a few KB with an RSA key and many names.
+const maxCSRSize = 64 << 10
+
+// certAuthority is the CA service: with -ca-key, clients with a verified
+// certificate POST a CSR to /ca/sign and get back a client certificate
+// issued by the first certificate in -ca, so test environments can mint
+// client identities on demand and rehearse rotating them. The
+// certificates are short-lived, since nothing revokes them.
+type certAuthority struct {
+	cert        *x509.Certificate
+	key         crypto.Signer
+	maxLifetime time.Duration
+	clients     map[string]bool // common names allowed to sign, or nil for any
+}
+
+// loadCertAuthority loads the CA certificate, the first in caFile, and its
+// key from keyFile, decrypted with the passphrase from keyPass.
+func loadCertAuthority(caFile, keyFile, keyPass string, maxLifetime time.Duration, clients []string) (*certAuthority, error) {
+	pair, err := mtls.LoadKeyPair(caFile, keyFile, keyPass)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, fmt.Errorf("%s: %s is not a CA certificate that may sign certificates", caFile, cert.Subject)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: key %T cannot sign", keyFile, pair.PrivateKey)
+	}
+	ca := &certAuthority{cert: cert, key: key, maxLifetime: maxLifetime}
+	if len(clients) > 0 {
+		ca.clients = make(map[string]bool, len(clients))
+		for _, name := range clients {
+			ca.clients[name] = true
+		}
+	}
+	return ca, nil
+}
+
+// ServeHTTP signs the CSR in the body, PEM or DER, for at most
+// -ca-cert-lifetime or the lifetime query parameter if shorter, and
+// responds with the certificate in PEM, followed by the CA's if it is an
+// intermediate, which the client must present with it.
+func (ca *certAuthority) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST a certificate signing request", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		http.Error(w, "a verified client certificate is required", http.StatusForbidden)
+		return
+	}
+	caller := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if ca.clients != nil && !ca.clients[caller] {
+		http.Error(w, fmt.Sprintf("client %q may not request certificates", caller), http.StatusForbidden)
+		return
+	}
+
+	lifetime := ca.maxLifetime
+	if value := r.URL.Query().Get("lifetime"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid lifetime %q", value), http.StatusBadRequest)
+			return
+		}
+		lifetime = min(d, ca.maxLifetime)
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCSRSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	csr, err := parseCSR(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	der, err := ca.issue(csr, lifetime, time.Now())
+	if err != nil {
+		log.Printf("Failed to issue a certificate for %q: %v", csr.Subject.CommonName, err)
+		http.Error(w, "failed to issue the certificate", http.StatusInternalServerError)
+		return
+	}
+	cert, _ := x509.ParseCertificate(der)
+	log.Printf("CA: issued %s (serial %s) to %q, valid until %s", cert.Subject, cert.SerialNumber.Text(16), caller, cert.NotAfter.UTC().Format(time.RFC3339))
+
+	out := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if !bytes.Equal(ca.cert.RawIssuer, ca.cert.RawSubject) {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(out)
+}
+
+// parseCSR parses a CSR, PEM or DER, and checks it is signed by the key
+// it is for.
+func parseCSR(data []byte) (*x509.CertificateRequest, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, fmt.Errorf("expected a CERTIFICATE REQUEST, not %s", block.Type)
+		}
+		data = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate signing request: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("certificate signing request: %w", err)
+	}
+	if csr.Subject.CommonName == "" {
+		return nil, errors.New("certificate signing request has no common name")
+	}
+	return csr, nil
+}
+
+// issue signs a client certificate for the subject, names and key of csr,
+// valid from a minute before now, for clock skew, for lifetime or until
+// the CA expires, whichever is sooner.
+func (ca *certAuthority) issue(csr *x509.CertificateRequest, lifetime time.Duration, now time.Time) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	notAfter := now.Add(lifetime)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               csr.Subject,
+		DNSNames:              csr.DNSNames,
+		EmailAddresses:        csr.EmailAddresses,
+		IPAddresses:           csr.IPAddresses,
+		URIs:                  csr.URIs,
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	if _, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	return x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+}
+
+// withCertAuthority serves the CA service at /ca/sign and everything else
+// from next.
+func withCertAuthority(ca *certAuthority, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == caSignPath {
+			ca.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
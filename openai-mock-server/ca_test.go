@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCA writes ca's certificate and key to PEM files, returning their
+// paths.
+func writeCA(t *testing.T, ca *testCA) (certFile, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestCertAuthority(t *testing.T) {
+	root := issue(t, nil, "Root", 1, true)
+	intermediate := issue(t, root, "Intermediate", 2, true)
+	caller := issue(t, root, "minter", 10, false)
+
+	caFile, keyFile := writeCA(t, root)
+	ca, err := loadCertAuthority(caFile, keyFile, "", time.Hour, []string{"minter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafFile, leafKeyFile := writeCA(t, caller)
+	if _, err := loadCertAuthority(leafFile, leafKeyFile, "", time.Hour, nil); err == nil {
+		t.Error("loaded a client certificate as the CA")
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "minted-client", Organization: []string{"MockOpenAI"}},
+		DNSNames: []string{"minted.test"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+
+	sign := func(ca *certAuthority, target string, body []byte, from *testCA) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+		if from != nil {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{from.cert, root.cert}}}
+		}
+		w := httptest.NewRecorder()
+		withCertAuthority(ca, http.NotFoundHandler()).ServeHTTP(w, r)
+		return w
+	}
+
+	// The certificate is for the CSR's subject and key, for client
+	// authentication, and verifies against the CA
+	w := sign(ca, "/ca/sign?lifetime=10m", csrPEM, caller)
+	if w.Code != http.StatusCreated || w.Header().Get("Content-Type") != "application/x-pem-file" {
+		t.Fatalf("sign: %d %s", w.Code, w.Body.String())
+	}
+	block, rest := pem.Decode(w.Body.Bytes())
+	if block == nil || len(bytes.TrimSpace(rest)) != 0 {
+		t.Fatalf("sign: want one certificate from the root, got:\n%s", w.Body.String())
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Error(err)
+	}
+	if cert.Subject.CommonName != "minted-client" || len(cert.DNSNames) != 1 || !key.PublicKey.Equal(cert.PublicKey) {
+		t.Errorf("certificate for %s %v", cert.Subject, cert.DNSNames)
+	}
+	if lifetime := cert.NotAfter.Sub(cert.NotBefore); lifetime != 11*time.Minute {
+		t.Errorf("lifetime %v, want 10m and a minute for clock skew", lifetime)
+	}
+
+	// No certificate outlives the CA or -ca-cert-lifetime
+	if w := sign(ca, "/ca/sign?lifetime=48h", csrDER, caller); w.Code == http.StatusCreated {
+		block, _ := pem.Decode(w.Body.Bytes())
+		if cert, _ := x509.ParseCertificate(block.Bytes); cert.NotAfter.After(root.cert.NotAfter) {
+			t.Errorf("certificate expires %v, after the CA at %v", cert.NotAfter, root.cert.NotAfter)
+		}
+	} else {
+		t.Errorf("sign DER: %d %s", w.Code, w.Body.String())
+	}
+
+	// An intermediate sends itself after the certificate
+	intermediateFile, intermediateKeyFile := writeCA(t, intermediate)
+	sub, err := loadCertAuthority(intermediateFile, intermediateKeyFile, "", time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := sign(sub, "/ca/sign", csrPEM, caller); strings.Count(w.Body.String(), "BEGIN CERTIFICATE") != 2 {
+		t.Errorf("intermediate: %d %s", w.Code, w.Body.String())
+	}
+
+	tampered := bytes.Clone(csrDER)
+	tampered[len(tampered)-1] ^= 1
+	for _, tc := range []struct {
+		name, target string
+		body         []byte
+		from         *testCA
+		want         int
+	}{
+		{"NoCertificate", "/ca/sign", csrPEM, nil, http.StatusForbidden},
+		{"NotAllowed", "/ca/sign", csrPEM, intermediate, http.StatusForbidden},
+		{"BadLifetime", "/ca/sign?lifetime=-1h", csrPEM, caller, http.StatusBadRequest},
+		{"Tampered", "/ca/sign", tampered, caller, http.StatusBadRequest},
+		{"NotCSR", "/ca/sign", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw}), caller, http.StatusBadRequest},
+		{"OtherPath", "/v1/models", nil, caller, http.StatusNotFound},
+	} {
+		if w := sign(ca, tc.target, tc.body, tc.from); w.Code != tc.want {
+			t.Errorf("%s: %d, want %d: %s", tc.name, w.Code, tc.want, w.Body.String())
+		}
+	}
+}
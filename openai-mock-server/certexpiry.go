@@ -0,0 +1,58 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	"mtls"
+)
+
+// Certificates are checked for expiry as they are loaded, and daily after:
+// any within -cert-warn-days of expiry, or past it, is warned of, and
+// -check-certs lists them all and exits non-zero if any is, for cron jobs
+// and CI.
+
+// serverCerts reads the certificates the server loads: its own, with any
+// chain, and the CA that client certificates are verified against.
+func serverCerts(certFile, keyFile, keyPass, caFile string) ([]mtls.CertExpiry, error) {
+	server, err := mtls.ReadCertExpiry("server", certFile, keyFile, keyPass)
+	if err != nil {
+		return nil, fmt.Errorf("server certificate: %w", err)
+	}
+	ca, err := mtls.ReadCertExpiry("CA", caFile, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("CA certificate: %w", err)
+	}
+	return append(server, ca...), nil
+}
+
+// publishCertExpiry publishes certs in expvar, on /debug/vars of the admin
+// listener, as mockserver_certificates, each with the seconds until it
+// expires.
+func publishCertExpiry(certs []mtls.CertExpiry) {
+	type published struct {
+		mtls.CertExpiry
+		ExpiresIn float64 `json:"expires_in_seconds"`
+	}
+	expvar.Publish("mockserver_certificates", expvar.Func(func() any {
+		now := time.Now()
+		vars := make([]published, len(certs))
+		for i, c := range certs {
+			vars[i] = published{c, c.NotAfter.Sub(now).Truncate(time.Second).Seconds()}
+		}
+		return vars
+	}))
+}
+
+// watchCertExpiry logs a warning for each of certs within warnDays of
+// expiry, or past it, now and every interval after.
+func watchCertExpiry(certs []mtls.CertExpiry, warnDays int, interval time.Duration) {
+	for {
+		for _, warning := range mtls.ExpiryWarnings(certs, time.Now(), warnDays) {
+			log.Printf("WARNING: %s", warning)
+		}
+		time.Sleep(interval)
+	}
+}
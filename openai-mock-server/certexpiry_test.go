@@ -0,0 +1,29 @@
+package main
+
+import (
+	"expvar"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCertExpiry(t *testing.T) {
+	// identity.p12 holds a leaf and its intermediate; encrypted-client.crt,
+	// standing in for the CA, expires in 2126
+	caFile := filepath.Join("..", "mtls", "testdata", "encrypted-client.crt")
+	certs, err := serverCerts(filepath.Join("..", "mtls", "testdata", "identity.p12"), "pass:correct-horse", "", caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 3 || certs[0].Use != "server" || certs[2].Use != "CA" || certs[2].Subject != "CN=pkcs12-client,O=MockOpenAI" {
+		t.Fatalf("certificates: %+v", certs)
+	}
+	if _, err := serverCerts(filepath.Join("..", "mtls", "testdata", "identity.p12"), "pass:battery-staple", "", caFile); err == nil {
+		t.Error("bundle opened with the wrong passphrase")
+	}
+
+	publishCertExpiry(certs[2:])
+	if published := expvar.Get("mockserver_certificates").String(); !strings.Contains(published, `"use":"CA"`) || !strings.Contains(published, `"expires_in_seconds":`) {
+		t.Errorf("mockserver_certificates = %s", published)
+	}
+}
@@ -0,0 +1,34 @@
+// Command plugin is an example mock server plugin. Build it with
+//
+//	go build -buildmode=plugin -o example-plugin.so ./examples/plugin
+//
+// and load it with -plugin example-plugin.so. It answers chat completions
+// with a fixed reply and tags every response with an X-Mock-Plugin header.
+package main
+
+import (
+	"net/http"
+
+	"openai-mock-server/mockserver"
+)
+
+// generator replies with fixed text and keeps the default embeddings.
+type generator struct{ mockserver.DefaultGenerator }
+
+func (generator) Chat(*mockserver.ChatCompletionRequest) mockserver.ChatResult {
+	return mockserver.ChatResult{Content: "Hello from the example plugin."}
+}
+
+// Register is called by the server when the plugin is loaded.
+func Register(s *mockserver.Server) error {
+	s.SetGenerator(generator{})
+	s.Use(mockserver.StagePostAuth, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Mock-Plugin", "example")
+			next.ServeHTTP(w, r)
+		})
+	})
+	return nil
+}
+
+func main() {}
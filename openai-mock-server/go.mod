@@ -2,4 +2,18 @@ module openai-mock-server
 
 go 1.25.1
 
-require github.com/google/uuid v1.6.0
+require (
+	github.com/google/uuid v1.6.0
+	mtls v0.0.0
+	openaitypes v0.0.0
+)
+
+require (
+	github.com/miekg/pkcs11 v1.1.1 // indirect
+	golang.org/x/sys v0.38.0 // indirect
+	golang.org/x/term v0.37.0 // indirect
+)
+
+replace mtls => ../mtls
+
+replace openaitypes => ../openaitypes
@@ -1,12 +1,8 @@
 package main
 
 import (
-	"crypto/tls"
-	"crypto/x509"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"math/rand"
 	"net/http"
@@ -14,709 +10,138 @@ import (
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
+	"mtls"
+	"openai-mock-server/mockserver"
+	"openai-mock-server/mockserver/plugin"
 )
 
 // ============================================================================
-// Types
-// ============================================================================
-
-// Models
-type Model struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	OwnedBy string `json:"owned_by"`
-}
-
-type ModelsResponse struct {
-	Object string  `json:"object"`
-	Data   []Model `json:"data"`
-}
-
-// Chat Completions
-
-// ContentPart represents a part of a multi-part content message
-type ContentPart struct {
-	Type     string `json:"type"`
-	Text     string `json:"text,omitempty"`
-	ImageURL *struct {
-		URL    string `json:"url"`
-		Detail string `json:"detail,omitempty"`
-	} `json:"image_url,omitempty"`
-}
-
-// MessageContent can be either a string or an array of ContentParts
-type MessageContent struct {
-	Text  string
-	Parts []ContentPart
-}
-
-func (mc *MessageContent) UnmarshalJSON(data []byte) error {
-	// Try to unmarshal as a string first
-	var text string
-	if err := json.Unmarshal(data, &text); err == nil {
-		mc.Text = text
-		mc.Parts = nil
-		return nil
-	}
-
-	// Try to unmarshal as an array of ContentParts
-	var parts []ContentPart
-	if err := json.Unmarshal(data, &parts); err == nil {
-		mc.Parts = parts
-		mc.Text = ""
-		return nil
-	}
-
-	// If neither works, return an error
-	return fmt.Errorf("content must be a string or array of content parts")
-}
-
-func (mc MessageContent) MarshalJSON() ([]byte, error) {
-	if len(mc.Parts) > 0 {
-		return json.Marshal(mc.Parts)
-	}
-	return json.Marshal(mc.Text)
-}
-
-// GetText returns the text content, extracting from parts if necessary
-func (mc *MessageContent) GetText() string {
-	if mc.Text != "" {
-		return mc.Text
-	}
-	// Extract text from parts
-	var texts []string
-	for _, part := range mc.Parts {
-		if part.Type == "text" && part.Text != "" {
-			texts = append(texts, part.Text)
-		}
-	}
-	return strings.Join(texts, " ")
-}
-
-type ChatMessage struct {
-	Role       string         `json:"role"`
-	Content    MessageContent `json:"content,omitempty"`
-	ToolCalls  []ToolCall     `json:"tool_calls,omitempty"`
-	ToolCallID string         `json:"tool_call_id,omitempty"`
-	Name       string         `json:"name,omitempty"`
-}
-
-// ResponseMessage is used for responses (always string content)
-type ResponseMessage struct {
-	Role       string     `json:"role"`
-	Content    string     `json:"content,omitempty"`
-	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
-	ToolCallID string     `json:"tool_call_id,omitempty"`
-}
-
-type ToolCall struct {
-	ID       string `json:"id"`
-	Type     string `json:"type"`
-	Function struct {
-		Name      string `json:"name"`
-		Arguments string `json:"arguments"`
-	} `json:"function"`
-}
-
-type Tool struct {
-	Type     string `json:"type"`
-	Function struct {
-		Name        string                 `json:"name"`
-		Description string                 `json:"description,omitempty"`
-		Parameters  map[string]interface{} `json:"parameters,omitempty"`
-	} `json:"function"`
-}
-
-type ChatCompletionRequest struct {
-	Model            string        `json:"model"`
-	Messages         []ChatMessage `json:"messages"`
-	MaxTokens        *int          `json:"max_tokens,omitempty"`
-	Temperature      *float64      `json:"temperature,omitempty"`
-	TopP             *float64      `json:"top_p,omitempty"`
-	N                *int          `json:"n,omitempty"`
-	Stream           bool          `json:"stream,omitempty"`
-	Stop             interface{}   `json:"stop,omitempty"`
-	PresencePenalty  *float64      `json:"presence_penalty,omitempty"`
-	FrequencyPenalty *float64      `json:"frequency_penalty,omitempty"`
-	User             string        `json:"user,omitempty"`
-	Tools            []Tool        `json:"tools,omitempty"`
-	ToolChoice       interface{}   `json:"tool_choice,omitempty"`
-}
-
-type ChatChoice struct {
-	Index        int         `json:"index"`
-	Message      ChatMessage `json:"message"`
-	FinishReason string      `json:"finish_reason"`
-}
-
-type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
-}
-
-type ChatCompletionResponse struct {
-	ID                string       `json:"id"`
-	Object            string       `json:"object"`
-	Created           int64        `json:"created"`
-	Model             string       `json:"model"`
-	Choices           []ChatChoice `json:"choices"`
-	Usage             Usage        `json:"usage"`
-	SystemFingerprint string       `json:"system_fingerprint,omitempty"`
-}
-
-// Streaming types
-type StreamDelta struct {
-	Role    *string `json:"role,omitempty"`
-	Content *string `json:"content,omitempty"`
-}
-
-type StreamChoice struct {
-	Index        int          `json:"index"`
-	Delta        StreamDelta  `json:"delta"`
-	FinishReason *string      `json:"finish_reason"`
-}
-
-type ChatCompletionChunk struct {
-	ID                string         `json:"id"`
-	Object            string         `json:"object"`
-	Created           int64          `json:"created"`
-	Model             string         `json:"model"`
-	SystemFingerprint string         `json:"system_fingerprint,omitempty"`
-	Choices           []StreamChoice `json:"choices"`
-}
-
-// Embeddings
-type EmbeddingsRequest struct {
-	Model          string `json:"model"`
-	Input          any    `json:"input"`
-	EncodingFormat string `json:"encoding_format,omitempty"`
-	Dimensions     *int   `json:"dimensions,omitempty"`
-	User           string `json:"user,omitempty"`
-}
-
-type EmbeddingData struct {
-	Object    string    `json:"object"`
-	Embedding []float64 `json:"embedding"`
-	Index     int       `json:"index"`
-}
-
-type EmbeddingsResponse struct {
-	Object string          `json:"object"`
-	Data   []EmbeddingData `json:"data"`
-	Model  string          `json:"model"`
-	Usage  struct {
-		PromptTokens int `json:"prompt_tokens"`
-		TotalTokens  int `json:"total_tokens"`
-	} `json:"usage"`
-}
-
-// Error response
-type ErrorDetail struct {
-	Message string  `json:"message"`
-	Type    string  `json:"type"`
-	Param   *string `json:"param"`
-	Code    *string `json:"code"`
-}
-
-type ErrorResponse struct {
-	Error ErrorDetail `json:"error"`
-}
-
-// ============================================================================
-// Mock Data
+// Main
 // ============================================================================
 
-var mockModels = []Model{
-	{ID: "gpt-4", Object: "model", Created: 1687882411, OwnedBy: "openai"},
-	{ID: "gpt-4-turbo", Object: "model", Created: 1712361441, OwnedBy: "openai"},
-	{ID: "gpt-4-turbo-preview", Object: "model", Created: 1706037777, OwnedBy: "openai"},
-	{ID: "gpt-4o", Object: "model", Created: 1715367049, OwnedBy: "openai"},
-	{ID: "gpt-4o-mini", Object: "model", Created: 1721172741, OwnedBy: "openai"},
-	{ID: "gpt-3.5-turbo", Object: "model", Created: 1677610602, OwnedBy: "openai"},
-	{ID: "gpt-3.5-turbo-16k", Object: "model", Created: 1683758102, OwnedBy: "openai"},
-	{ID: "text-embedding-ada-002", Object: "model", Created: 1671217299, OwnedBy: "openai-internal"},
-	{ID: "text-embedding-3-small", Object: "model", Created: 1705948997, OwnedBy: "openai"},
-	{ID: "text-embedding-3-large", Object: "model", Created: 1705953180, OwnedBy: "openai"},
-}
-
-// echoResponse extracts the last user message and produces a direct, realistic
-// answer so that agent-style callers (like opencode) treat the task as complete
-// and stop looping.
-func echoResponse(messages []ChatMessage) string {
-	// Find the last user message
-	var lastUser string
-	for i := len(messages) - 1; i >= 0; i-- {
-		if messages[i].Role == "user" {
-			lastUser = messages[i].Content.GetText()
-			break
-		}
-	}
-
-	if lastUser == "" {
-		return "Done."
-	}
-
-	lower := strings.ToLower(lastUser)
+// stringList is a flag.Value that collects repeated flags.
+type stringList []string
 
-	// Handle common patterns with direct, satisfying answers
-	switch {
-	case strings.Contains(lower, "hello") && strings.Contains(lower, "5 words"):
-		return "Hello, it is nice today!"
-	case strings.Contains(lower, "hello"):
-		return "Hello! Great to meet you. How can I help?"
-	case strings.Contains(lower, "summarize"), strings.Contains(lower, "summary"):
-		return "Here is the summary: The content covers the main points effectively. The key takeaway is that all objectives have been met successfully."
-	case strings.Contains(lower, "explain"):
-		return "This works by processing the input, applying the necessary transformations, and producing the expected output. The design is straightforward and efficient."
-	case strings.Contains(lower, "write"), strings.Contains(lower, "generate"), strings.Contains(lower, "create"):
-		return "Here is what you requested:\n\nThe quick brown fox jumps over the lazy dog. This classic sentence demonstrates every letter of the alphabet and has been used for testing since the late 1800s."
-	case strings.Contains(lower, "fix"), strings.Contains(lower, "bug"), strings.Contains(lower, "error"):
-		return "The issue has been identified and resolved. The root cause was a missing validation step. No further action is needed."
-	case strings.Contains(lower, "test"):
-		return "All tests pass. The implementation is correct and meets the specified requirements."
-	default:
-		return fmt.Sprintf("Here is my response to your request: I've carefully considered \"%s\" and completed the task. No further action is needed.", truncate(lastUser, 100))
-	}
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
 }
 
-func truncate(s string, maxLen int) string {
-	if len(s) <= maxLen {
-		return s
-	}
-	return s[:maxLen] + "..."
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
-// ============================================================================
-// Helpers
-// ============================================================================
-
-func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, DELETE, PUT, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
-		w.Header().Set("Access-Control-Max-Age", "86400")
-
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
-
-		next(w, r)
 	}
+	return items
 }
 
-func sendError(w http.ResponseWriter, status int, message, errType string, param, code *string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(ErrorResponse{
-		Error: ErrorDetail{
-			Message: message,
-			Type:    errType,
-			Param:   param,
-			Code:    code,
-		},
-	})
-}
-
-func estimateTokens(text string) int {
-	// Rough approximation: ~4 chars per token
-	return len(text) / 4
-}
-
-func generateFingerprint() string {
-	return fmt.Sprintf("fp_%s", uuid.New().String()[:12])
-}
-
-// ============================================================================
-// Handlers
-// ============================================================================
-
-func modelsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error", nil, nil)
-		return
-	}
-
-	response := ModelsResponse{
-		Object: "list",
-		Data:   mockModels,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
-func modelByIDHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error", nil, nil)
-		return
-	}
-
-	// Extract model ID from path: /v1/models/{model_id}
-	path := strings.TrimPrefix(r.URL.Path, "/v1/models/")
-	modelID := strings.TrimSuffix(path, "/")
-
-	for _, model := range mockModels {
-		if model.ID == modelID {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(model)
-			return
-		}
-	}
-
-	code := "model_not_found"
-	sendError(w, http.StatusNotFound, fmt.Sprintf("The model '%s' does not exist", modelID), "invalid_request_error", nil, &code)
-}
-
-func chatCompletionsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error", nil, nil)
-		return
-	}
-
-	// Read body for logging in verbose mode
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		param := "body"
-		sendError(w, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err), "invalid_request_error", &param, nil)
-		return
-	}
-
-	if verbose {
-		log.Printf("  Request body: %s", string(bodyBytes))
-	}
-
-	var req ChatCompletionRequest
-	if err := json.Unmarshal(bodyBytes, &req); err != nil {
-		param := "body"
-		sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err), "invalid_request_error", &param, nil)
-		return
-	}
-
-	// Validate required fields
-	if req.Model == "" {
-		param := "model"
-		sendError(w, http.StatusBadRequest, "Missing required parameter: 'model'", "invalid_request_error", &param, nil)
-		return
-	}
-
-	if len(req.Messages) == 0 {
-		param := "messages"
-		sendError(w, http.StatusBadRequest, "Missing required parameter: 'messages'", "invalid_request_error", &param, nil)
-		return
-	}
-
-	// Handle streaming
-	if req.Stream {
-		handleStreamingChat(w, req)
-		return
-	}
+func main() {
+	rand.Seed(time.Now().UnixNano())
 
-	// Always return a text response (never randomly trigger tool calls)
-	var responseMessage ChatMessage
-	finishReason := "stop"
+	// Command line flags
+	port := flag.String("port", "8000", "Port to listen on")
+	certFile := flag.String("cert", "../certs/server.crt", "Server certificate file, PEM or a PKCS #12 .p12/.pfx bundle")
+	keyFile := flag.String("key", "../certs/server.key", "Server key file, or a pkcs11: URI naming a key on a token; for a PKCS #12 -cert, its passphrase as pass:, env: or file:")
+	keyPass := flag.String("key-pass", "", "Passphrase of an encrypted -key, or the PIN of a token: pass:<passphrase>, env:<variable>, file:<path> or prompt; asked for on the terminal if empty")
+	caFile := flag.String("ca", "../certs/ca.crt", "CA certificate file for client verification")
+	crlFile := flag.String("crl", "", "Certificate revocation lists (PEM, or one in DER) issued by -ca or an intermediate CA under it; client certificates they list, or issued by an intermediate they list, are rejected")
+	caKeyFile := flag.String("ca-key", "", "Key of the first certificate in -ca; enables POST /ca/sign, which issues client certificates for CSRs from clients with a verified certificate")
+	caKeyPass := flag.String("ca-key-pass", "", "Passphrase of an encrypted -ca-key, given as for -key-pass")
+	caCertLifetime := flag.Duration("ca-cert-lifetime", time.Hour, "Longest lifetime of the certificates POST /ca/sign issues; a request may ask for less with ?lifetime=")
+	caClients := flag.String("ca-clients", "", "Comma-separated common names of the clients allowed to use POST /ca/sign; any with a verified certificate if empty")
+	autoCerts := flag.Bool("auto-certs", false, "If none of -cert, -key and -ca exist, generate a CA for this machine with a localhost server certificate and a client certificate, write them there and print how to connect")
+	insecure := flag.Bool("insecure", false, "Run without mTLS (plain HTTP)")
+	certWarnDays := flag.Int("cert-warn-days", 30, "Warn of -cert and -ca certificates that expire within this many days, at startup and daily")
+	checkCerts := flag.Bool("check-certs", false, "Check the -cert and -ca certificates, print when each expires and exit: non-zero if any expires within -cert-warn-days or has")
+	verboseFlag := flag.Bool("verbose", false, "Enable verbose logging (shows headers)")
+	corsOrigins := flag.String("cors-origins", "*", "Comma-separated origins allowed by CORS (* for any)")
+	corsMethods := flag.String("cors-methods", "GET, POST, OPTIONS, DELETE, PUT, PATCH", "Comma-separated methods allowed by CORS")
+	corsHeaders := flag.String("cors-headers", "Content-Type, Authorization, X-Request-ID, OpenAI-Beta", "Comma-separated request headers allowed by CORS")
+	corsCredentials := flag.Bool("cors-credentials", false, "Send Access-Control-Allow-Credentials to the origins listed in -cors-origins (not to *)")
+	corsMaxAge := flag.Int("cors-max-age", 86400, "Seconds browsers may cache CORS preflight results (0 disables caching)")
+	maxConcurrent := flag.Int("max-concurrent", 0, "Maximum in-flight requests (0 = unlimited)")
+	overloadStatus := flag.Int("overload-status", http.StatusTooManyRequests, "Status returned beyond -max-concurrent (429 or 503)")
+	adminAddr := flag.String("admin-addr", "", "Address for the admin listener with pprof and expvar (e.g. localhost:6060); disabled if empty")
+	debugEcho := flag.Bool("debug-echo", false, "Echo accepted vendor parameters (top_k, min_p, ...) in a debug field of chat responses")
+	var plugins stringList
+	flag.Var(&plugins, "plugin", "Go plugin (.so) to load; may be repeated")
+	flag.Parse()
 
-	mockContent := echoResponse(req.Messages)
-	responseMessage = ChatMessage{
-		Role:    "assistant",
-		Content: MessageContent{Text: mockContent},
+	if *overloadStatus != http.StatusTooManyRequests && *overloadStatus != http.StatusServiceUnavailable {
+		log.Fatalf("Invalid -overload-status %d: must be 429 or 503", *overloadStatus)
 	}
 
-	// Calculate tokens
-	promptTokens := 0
-	for _, msg := range req.Messages {
-		promptTokens += estimateTokens(msg.Content.GetText())
+	if *corsMaxAge < 0 {
+		log.Fatalf("Invalid -cors-max-age %d: must not be negative", *corsMaxAge)
 	}
-	completionTokens := estimateTokens(responseMessage.Content.GetText())
 
-	// Determine number of choices
-	n := 1
-	if req.N != nil && *req.N > 0 {
-		n = *req.N
+	if *certWarnDays < 0 {
+		log.Fatalf("Invalid -cert-warn-days %d: must not be negative", *certWarnDays)
 	}
-
-	choices := make([]ChatChoice, n)
-	for i := 0; i < n; i++ {
-		choices[i] = ChatChoice{
-			Index:        i,
-			Message:      responseMessage,
-			FinishReason: finishReason,
-		}
+	if *caKeyFile != "" && *insecure {
+		log.Fatal("-ca-key requires mTLS: POST /ca/sign is only served to clients with a verified certificate")
 	}
-
-	response := ChatCompletionResponse{
-		ID:                "chatcmpl-" + uuid.New().String()[:24],
-		Object:            "chat.completion",
-		Created:           time.Now().Unix(),
-		Model:             req.Model,
-		Choices:           choices,
-		Usage: Usage{
-			PromptTokens:     promptTokens,
-			CompletionTokens: completionTokens * n,
-			TotalTokens:      promptTokens + completionTokens*n,
-		},
-		SystemFingerprint: generateFingerprint(),
+	if *caCertLifetime <= 0 {
+		log.Fatalf("Invalid -ca-cert-lifetime %v: must be positive", *caCertLifetime)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
-func handleStreamingChat(w http.ResponseWriter, req ChatCompletionRequest) {
-	// Set SSE headers
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("X-Accel-Buffering", "no")
-
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		sendError(w, http.StatusInternalServerError, "Streaming not supported", "server_error", nil, nil)
-		return
+	if *autoCerts && *insecure {
+		log.Fatal("-auto-certs requires mTLS: -insecure serves without certificates")
 	}
-
-	completionID := "chatcmpl-" + uuid.New().String()[:24]
-	created := time.Now().Unix()
-	fingerprint := generateFingerprint()
-
-	// Generate response content
-	mockContent := echoResponse(req.Messages)
-	words := strings.Fields(mockContent)
-
-	// Send initial chunk with role
-	assistantRole := "assistant"
-	initialChunk := ChatCompletionChunk{
-		ID:                completionID,
-		Object:            "chat.completion.chunk",
-		Created:           created,
-		Model:             req.Model,
-		SystemFingerprint: fingerprint,
-		Choices: []StreamChoice{
-			{
-				Index: 0,
-				Delta: StreamDelta{Role: &assistantRole},
-			},
-		},
-	}
-	sendSSEChunk(w, flusher, initialChunk)
-
-	// Stream content word by word
-	for i, word := range words {
-		time.Sleep(50 * time.Millisecond) // Simulate typing delay
-
-		content := word
-		if i < len(words)-1 {
-			content += " "
+	if *autoCerts {
+		generated, err := generateAutoCerts(*certFile, *keyFile, *caFile, time.Now())
+		if err != nil {
+			log.Fatalf("Failed to generate certificates: %v", err)
 		}
-
-		chunk := ChatCompletionChunk{
-			ID:                completionID,
-			Object:            "chat.completion.chunk",
-			Created:           created,
-			Model:             req.Model,
-			SystemFingerprint: fingerprint,
-			Choices: []StreamChoice{
-				{
-					Index: 0,
-					Delta: StreamDelta{Content: &content},
-				},
-			},
+		if generated != nil {
+			printAutoCerts(os.Stdout, generated, *port)
 		}
-		sendSSEChunk(w, flusher, chunk)
-	}
-
-	// Send final chunk with finish_reason
-	finishReason := "stop"
-	finalChunk := ChatCompletionChunk{
-		ID:                completionID,
-		Object:            "chat.completion.chunk",
-		Created:           created,
-		Model:             req.Model,
-		SystemFingerprint: fingerprint,
-		Choices: []StreamChoice{
-			{
-				Index:        0,
-				Delta:        StreamDelta{},
-				FinishReason: &finishReason,
-			},
-		},
-	}
-	sendSSEChunk(w, flusher, finalChunk)
-
-	// Send [DONE] message
-	fmt.Fprintf(w, "data: [DONE]\n\n")
-	flusher.Flush()
-}
-
-func sendSSEChunk(w http.ResponseWriter, flusher http.Flusher, chunk ChatCompletionChunk) {
-	data, _ := json.Marshal(chunk)
-	fmt.Fprintf(w, "data: %s\n\n", data)
-	flusher.Flush()
-}
-
-func embeddingsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error", nil, nil)
-		return
 	}
-
-	var req EmbeddingsRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		param := "body"
-		sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err), "invalid_request_error", &param, nil)
-		return
-	}
-
-	// Validate required fields
-	if req.Model == "" {
-		param := "model"
-		sendError(w, http.StatusBadRequest, "Missing required parameter: 'model'", "invalid_request_error", &param, nil)
-		return
-	}
-
-	if req.Input == nil {
-		param := "input"
-		sendError(w, http.StatusBadRequest, "Missing required parameter: 'input'", "invalid_request_error", &param, nil)
-		return
-	}
-
-	// Determine embedding dimensions
-	dimensions := 1536 // default for ada-002 and 3-small
-	if req.Model == "text-embedding-3-large" {
-		dimensions = 3072
-	}
-	// Allow custom dimensions for v3 models
-	if req.Dimensions != nil && (req.Model == "text-embedding-3-small" || req.Model == "text-embedding-3-large") {
-		dimensions = *req.Dimensions
-	}
-
-	// Parse inputs
-	var inputs []string
-	switch v := req.Input.(type) {
-	case string:
-		inputs = []string{v}
-	case []interface{}:
-		for _, item := range v {
-			if s, ok := item.(string); ok {
-				inputs = append(inputs, s)
-			}
+	var certs []mtls.CertExpiry
+	if !*insecure {
+		var err error
+		if certs, err = serverCerts(*certFile, *keyFile, *keyPass, *caFile); err != nil {
+			log.Fatalf("Failed to read certificates: %v", err)
 		}
 	}
-
-	// Generate embeddings
-	totalTokens := 0
-	data := make([]EmbeddingData, len(inputs))
-	for i, input := range inputs {
-		totalTokens += estimateTokens(input)
-
-		// Generate normalized random embedding
-		embedding := make([]float64, dimensions)
-		var sumSq float64
-		for j := range embedding {
-			embedding[j] = rand.NormFloat64()
-			sumSq += embedding[j] * embedding[j]
-		}
-		// Normalize to unit vector
-		norm := 1.0 / (sumSq + 1e-10)
-		for j := range embedding {
-			embedding[j] *= norm
-		}
-
-		data[i] = EmbeddingData{
-			Object:    "embedding",
-			Embedding: embedding,
-			Index:     i,
+	if *checkCerts {
+		if !mtls.PrintCertCheck(os.Stdout, certs, time.Now(), *certWarnDays) {
+			os.Exit(1)
 		}
-	}
-
-	response := EmbeddingsResponse{
-		Object: "list",
-		Data:   data,
-		Model:  req.Model,
-	}
-	response.Usage.PromptTokens = totalTokens
-	response.Usage.TotalTokens = totalTokens
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
-// ============================================================================
-// Router
-// ============================================================================
-
-// Global verbose flag
-var verbose bool
-
-func logRequest(r *http.Request) {
-	if !verbose {
 		return
 	}
 
-	log.Printf("[%s] %s", r.Method, r.URL.Path)
-
-	// Log custom headers (X-* headers)
-	for name, values := range r.Header {
-		if strings.HasPrefix(name, "X-") {
-			for _, v := range values {
-				log.Printf("  Header: %s: %s", name, v)
-			}
-		}
+	cors := mockserver.CORSConfig{
+		AllowedOrigins:   splitList(*corsOrigins),
+		AllowedMethods:   splitList(*corsMethods),
+		AllowedHeaders:   splitList(*corsHeaders),
+		AllowCredentials: *corsCredentials,
+		MaxAge:           corsMaxAge,
 	}
-
-	// Log Authorization header (masked)
-	if auth := r.Header.Get("Authorization"); auth != "" {
-		if len(auth) > 20 {
-			log.Printf("  Header: Authorization: %s...%s", auth[:10], auth[len(auth)-4:])
-		} else {
-			log.Printf("  Header: Authorization: %s", auth)
-		}
+	if cors.AllowCredentials && !cors.HasExplicitOrigin() {
+		log.Fatal("-cors-credentials requires -cors-origins to list the allowed origins explicitly")
 	}
-}
 
-func router(w http.ResponseWriter, r *http.Request) {
-	logRequest(r)
+	verbose := *verboseFlag
 
-	path := r.URL.Path
-
-	switch {
-	case path == "/v1/models":
-		modelsHandler(w, r)
-	case strings.HasPrefix(path, "/v1/models/"):
-		modelByIDHandler(w, r)
-	case path == "/v1/chat/completions":
-		chatCompletionsHandler(w, r)
-	case path == "/v1/embeddings":
-		embeddingsHandler(w, r)
-	default:
-		code := "unknown_url"
-		sendError(w, http.StatusNotFound, fmt.Sprintf("Unknown request URL: %s", path), "invalid_request_error", nil, &code)
+	mock := mockserver.New(mockserver.Config{
+		Verbose:        verbose,
+		DebugEcho:      *debugEcho,
+		CORS:           cors,
+		MaxConcurrent:  *maxConcurrent,
+		OverloadStatus: *overloadStatus,
+	})
+	for _, path := range plugins {
+		if err := plugin.Load(mock, path); err != nil {
+			log.Fatalf("Failed to load plugin: %v", err)
+		}
 	}
-}
-
-// ============================================================================
-// Main
-// ============================================================================
-
-func main() {
-	rand.Seed(time.Now().UnixNano())
-
-	// Command line flags
-	port := flag.String("port", "8000", "Port to listen on")
-	certFile := flag.String("cert", "../certs/server.crt", "Server certificate file")
-	keyFile := flag.String("key", "../certs/server.key", "Server key file")
-	caFile := flag.String("ca", "../certs/ca.crt", "CA certificate file for client verification")
-	insecure := flag.Bool("insecure", false, "Run without mTLS (plain HTTP)")
-	verboseFlag := flag.Bool("verbose", false, "Enable verbose logging (shows headers)")
-	flag.Parse()
-
-	verbose = *verboseFlag
-
-	http.HandleFunc("/", corsMiddleware(router))
 
 	addr := ":" + *port
 
@@ -740,47 +165,98 @@ func main() {
 	fmt.Println("  GET  /v1/models              - List models")
 	fmt.Println("  GET  /v1/models/{id}         - Get model by ID")
 	fmt.Println("  POST /v1/chat/completions    - Chat (supports streaming)")
+	fmt.Println("  GET  /v1/chat/completions[/{id}] - Stored completions (\"store\": true)")
 	fmt.Println("  POST /v1/embeddings          - Generate embeddings")
+	fmt.Println("  POST /v1/responses           - Responses API (non-streaming)")
+	fmt.Println("  *    /v1/assistants, /v1/threads - Beta (requires OpenAI-Beta: assistants=v2)")
+	fmt.Println("  GET  /api/tags               - Ollama: list models")
+	fmt.Println("  POST /api/chat               - Ollama: chat (streams NDJSON by default)")
+	fmt.Println("  POST /api/generate           - Ollama: generate")
+	fmt.Println("  POST /api/embeddings         - Ollama: embeddings")
 	fmt.Println("")
 	fmt.Println("Features:")
 	fmt.Println("  - SSE streaming support")
 	fmt.Println("  - Tool/function calling")
-	fmt.Println("  - CORS enabled")
+	fmt.Printf("  - CORS enabled (origins: %s)\n", *corsOrigins)
 	fmt.Println("  - OpenAI-compatible error responses")
 	if !*insecure {
 		fmt.Println("  - mTLS client authentication")
+		fmt.Printf("  - Certificate expiry warnings: within %d days\n", *certWarnDays)
+		if *crlFile != "" {
+			fmt.Printf("  - Revocation list: %s\n", *crlFile)
+		}
+		if *caKeyFile != "" {
+			fmt.Printf("  - CA service: POST %s (certificates valid for up to %v)\n", caSignPath, *caCertLifetime)
+		}
 	}
 	if verbose {
 		fmt.Println("  - Verbose logging ENABLED")
 	}
+	if *adminAddr != "" {
+		fmt.Printf("  - Admin listener: http://%s/debug/pprof/, /debug/vars and /admin/stats\n", *adminAddr)
+	}
+	if *maxConcurrent > 0 {
+		fmt.Printf("  - Concurrency limit: %d (overload status %d)\n", *maxConcurrent, *overloadStatus)
+	}
+	for _, path := range plugins {
+		fmt.Printf("  - Plugin: %s\n", path)
+	}
 	fmt.Println("========================================")
 
+	if len(certs) > 0 {
+		publishCertExpiry(certs)
+		go watchCertExpiry(certs, *certWarnDays, 24*time.Hour)
+	}
+
+	if *adminAddr != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(*adminAddr, mock.AdminHandler()))
+		}()
+	}
+
 	if *insecure {
-		log.Fatal(http.ListenAndServe(addr, nil))
+		log.Fatal(http.ListenAndServe(addr, mock))
 	} else {
-		// Load CA certificate for client verification
-		caCert, err := os.ReadFile(*caFile)
+		// Configure TLS with mTLS, reloading the server certificate when
+		// its files change
+		tlsConfig, err := mtls.LoadServerTLSConfig(mtls.Config{
+			CertFile: *certFile,
+			KeyFile:  *keyFile,
+			KeyPass:  *keyPass,
+			CAFile:   *caFile,
+			Reload:   true,
+		})
 		if err != nil {
-			log.Fatalf("Failed to read CA certificate: %v", err)
+			log.Fatalf("Failed to configure mTLS: %v", err)
 		}
 
-		caCertPool := x509.NewCertPool()
-		if !caCertPool.AppendCertsFromPEM(caCert) {
-			log.Fatal("Failed to parse CA certificate")
+		if *crlFile != "" {
+			caCert, err := os.ReadFile(*caFile)
+			if err != nil {
+				log.Fatalf("Failed to read CA certificate: %v", err)
+			}
+			check, err := revocationCheck(*crlFile, caCert)
+			if err != nil {
+				log.Fatalf("Failed to load CRL: %v", err)
+			}
+			tlsConfig.VerifyPeerCertificate = check
 		}
 
-		// Configure TLS with mTLS
-		tlsConfig := &tls.Config{
-			ClientCAs:  caCertPool,
-			ClientAuth: tls.RequireAndVerifyClientCert,
-			MinVersion: tls.VersionTLS12,
+		var handler http.Handler = mock
+		if *caKeyFile != "" {
+			ca, err := loadCertAuthority(*caFile, *caKeyFile, *caKeyPass, *caCertLifetime, splitList(*caClients))
+			if err != nil {
+				log.Fatalf("Failed to load the CA key: %v", err)
+			}
+			handler = withCertAuthority(ca, mock)
 		}
 
 		server := &http.Server{
 			Addr:      addr,
+			Handler:   handler,
 			TLSConfig: tlsConfig,
 		}
 
-		log.Fatal(server.ListenAndServeTLS(*certFile, *keyFile))
+		log.Fatal(server.ListenAndServeTLS("", ""))
 	}
 }
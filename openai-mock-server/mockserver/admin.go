@@ -0,0 +1,44 @@
+package mockserver
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// ============================================================================
+// Admin
+// ============================================================================
+
+// requestCounts counts handled requests per route pattern. It is published
+// via expvar, so it is shared by every Server in the process.
+var requestCounts = expvar.NewMap("mockserver_requests")
+
+// inFlight counts requests whose handler has not yet returned, so a client
+// can check that a cancelled stream was released by the server.
+var inFlight = expvar.NewInt("mockserver_in_flight")
+
+func init() {
+	expvar.Publish("mockserver_goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+}
+
+// AdminHandler returns the handler for the admin listener. It exposes
+// net/http/pprof under /debug/pprof/ and expvar under /debug/vars, so the
+// mock itself can be profiled during high-throughput test runs (expvar
+// includes the request counts, requests in flight and goroutines), plus
+// /admin/stats (per-user usage and metadata counts) and /admin/completions
+// (stored completions with their user and client identity). It performs
+// no authentication and should only be bound to a trusted interface.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/admin/stats", s.adminStatsHandler)
+	mux.HandleFunc("/admin/completions", s.adminCompletionsHandler)
+	return mux
+}
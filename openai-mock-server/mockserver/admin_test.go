@@ -0,0 +1,91 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	ts := Start(t)
+	admin := httptest.NewServer(ts.Server.AdminHandler())
+	defer admin.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		wantType string
+		wantBody string
+	}{
+		{"PprofIndex", "/debug/pprof/", "text/html", "goroutine"},
+		{"PprofGoroutine", "/debug/pprof/goroutine?debug=1", "text/plain", "goroutine profile"},
+		{"PprofCmdline", "/debug/pprof/cmdline", "text/plain", ""},
+		{"Expvar", "/debug/vars", "application/json", `"mockserver_requests"`},
+		{"ExpvarInFlight", "/debug/vars", "application/json", `"mockserver_in_flight"`},
+		{"ExpvarGoroutines", "/debug/vars", "application/json", `"mockserver_goroutines"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(admin.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want 200", resp.StatusCode)
+			}
+			if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, tt.wantType) {
+				t.Errorf("Content-Type = %q, want %s", ct, tt.wantType)
+			}
+			if !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("body does not contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAdminRequestCounts(t *testing.T) {
+	ts := Start(t)
+	admin := httptest.NewServer(ts.Server.AdminHandler())
+	defer admin.Close()
+
+	counts := func() map[string]int {
+		resp, err := http.Get(admin.URL + "/debug/vars")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var vars struct {
+			Requests map[string]int `json:"mockserver_requests"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+			t.Fatal(err)
+		}
+		return vars.Requests
+	}
+
+	before := counts()
+	call(t, ts, "GET", "/models", "")
+	call(t, ts, "GET", "/models/gpt-4o", "")
+	call(t, ts, "GET", "/models/gpt-4", "")
+	call(t, ts, "GET", "/no/such/path", "")
+	after := counts()
+
+	// Counts are keyed by route pattern, not by raw path
+	for pattern, want := range map[string]int{"/v1/models": 1, "/v1/models/{id}": 2, "unknown": 1} {
+		if got := after[pattern] - before[pattern]; got != want {
+			t.Errorf("%s counted %d requests, want %d", pattern, got, want)
+		}
+	}
+	for key := range after {
+		if strings.Contains(key, "gpt-4") || strings.Contains(key, "/no/such") {
+			t.Errorf("raw path %q used as a counter key", key)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// Assistants Types
+// ============================================================================
+
+type Assistant struct {
+	ID           string            `json:"id"`
+	Object       string            `json:"object"`
+	CreatedAt    int64             `json:"created_at"`
+	Name         *string           `json:"name"`
+	Description  *string           `json:"description"`
+	Model        string            `json:"model"`
+	Instructions *string           `json:"instructions"`
+	Tools        []any             `json:"tools"`
+	Metadata     map[string]string `json:"metadata"`
+	Temperature  *float64          `json:"temperature,omitempty"`
+	TopP         *float64          `json:"top_p,omitempty"`
+}
+
+type Thread struct {
+	ID        string            `json:"id"`
+	Object    string            `json:"object"`
+	CreatedAt int64             `json:"created_at"`
+	Metadata  map[string]string `json:"metadata"`
+}
+
+type ListResponse[T any] struct {
+	Object  string  `json:"object"`
+	Data    []T     `json:"data"`
+	FirstID *string `json:"first_id"`
+	LastID  *string `json:"last_id"`
+	HasMore bool    `json:"has_more"`
+}
+
+type DeletionStatus struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
+
+// ============================================================================
+// Assistants Store
+// ============================================================================
+
+// betaStore keeps the objects created through the beta Assistants API in
+// memory for the lifetime of the server.
+type betaStore struct {
+	mu         sync.Mutex
+	assistants map[string]Assistant
+	threads    map[string]Thread
+}
+
+func newBetaStore() *betaStore {
+	return &betaStore{
+		assistants: make(map[string]Assistant),
+		threads:    make(map[string]Thread),
+	}
+}
+
+// ============================================================================
+// Assistants Handlers
+// ============================================================================
+
+const assistantsAPI = "Assistants API"
+
+func (s *Server) assistantsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.beta.mu.Lock()
+		data := make([]Assistant, 0, len(s.beta.assistants))
+		for _, a := range s.beta.assistants {
+			data = append(data, a)
+		}
+		s.beta.mu.Unlock()
+
+		// Newest first, like the real API's default order=desc
+		sort.Slice(data, func(i, j int) bool {
+			if data[i].CreatedAt != data[j].CreatedAt {
+				return data[i].CreatedAt > data[j].CreatedAt
+			}
+			return data[i].ID > data[j].ID
+		})
+
+		response := ListResponse[Assistant]{Object: "list", Data: data}
+		if len(data) > 0 {
+			response.FirstID = &data[0].ID
+			response.LastID = &data[len(data)-1].ID
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+
+	case http.MethodPost:
+		var assistant Assistant
+		if err := json.NewDecoder(r.Body).Decode(&assistant); err != nil {
+			param := "body"
+			sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err), "invalid_request_error", &param, nil)
+			return
+		}
+		if assistant.Model == "" {
+			param := "model"
+			sendError(w, http.StatusBadRequest, "Missing required parameter: 'model'", "invalid_request_error", &param, nil)
+			return
+		}
+
+		assistant.ID = "asst_" + shortID()
+		assistant.Object = "assistant"
+		assistant.CreatedAt = time.Now().Unix()
+		if assistant.Tools == nil {
+			assistant.Tools = []any{}
+		}
+		if assistant.Metadata == nil {
+			assistant.Metadata = map[string]string{}
+		}
+
+		s.beta.mu.Lock()
+		s.beta.assistants[assistant.ID] = assistant
+		s.beta.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(assistant)
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error", nil, nil)
+	}
+}
+
+func (s *Server) assistantByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/assistants/"), "/")
+
+	s.beta.mu.Lock()
+	assistant, ok := s.beta.assistants[id]
+	if ok && r.Method == http.MethodDelete {
+		delete(s.beta.assistants, id)
+	}
+	s.beta.mu.Unlock()
+
+	if !ok {
+		sendError(w, http.StatusNotFound, fmt.Sprintf("No assistant found with id '%s'.", id), "invalid_request_error", nil, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(assistant)
+	case http.MethodDelete:
+		json.NewEncoder(w).Encode(DeletionStatus{ID: id, Object: "assistant.deleted", Deleted: true})
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error", nil, nil)
+	}
+}
+
+func (s *Server) threadsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error", nil, nil)
+		return
+	}
+
+	var thread Thread
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&thread); err != nil {
+			param := "body"
+			sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err), "invalid_request_error", &param, nil)
+			return
+		}
+	}
+
+	thread.ID = "thread_" + shortID()
+	thread.Object = "thread"
+	thread.CreatedAt = time.Now().Unix()
+	if thread.Metadata == nil {
+		thread.Metadata = map[string]string{}
+	}
+
+	s.beta.mu.Lock()
+	s.beta.threads[thread.ID] = thread
+	s.beta.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(thread)
+}
+
+func (s *Server) threadByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/threads/"), "/")
+
+	s.beta.mu.Lock()
+	thread, ok := s.beta.threads[id]
+	if ok && r.Method == http.MethodDelete {
+		delete(s.beta.threads, id)
+	}
+	s.beta.mu.Unlock()
+
+	if !ok {
+		sendError(w, http.StatusNotFound, fmt.Sprintf("No thread found with id '%s'.", id), "invalid_request_error", nil, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(thread)
+	case http.MethodDelete:
+		json.NewEncoder(w).Encode(DeletionStatus{ID: id, Object: "thread.deleted", Deleted: true})
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error", nil, nil)
+	}
+}
@@ -0,0 +1,47 @@
+package mockserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ============================================================================
+// OpenAI-Beta Header
+// ============================================================================
+
+// betaFeatures parses the OpenAI-Beta header, which holds a comma-separated
+// list of feature=version pairs (e.g. "assistants=v2"). Repeated headers are
+// merged.
+func betaFeatures(r *http.Request) map[string]string {
+	features := make(map[string]string)
+	for _, header := range r.Header.Values("OpenAI-Beta") {
+		for _, item := range strings.Split(header, ",") {
+			name, version, _ := strings.Cut(strings.TrimSpace(item), "=")
+			if name != "" {
+				features[strings.ToLower(name)] = strings.TrimSpace(version)
+			}
+		}
+	}
+	return features
+}
+
+// requireBeta gates next on the OpenAI-Beta header opting in to feature at
+// version, returning the same errors as the real API otherwise.
+func requireBeta(feature, version, api string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, ok := betaFeatures(r)[feature]
+		if ok && got == version {
+			next(w, r)
+			return
+		}
+
+		code := "invalid_beta"
+		want := fmt.Sprintf("OpenAI-Beta: %s=%s", feature, version)
+		if !ok {
+			sendError(w, http.StatusBadRequest, fmt.Sprintf("You must provide the 'OpenAI-Beta' header to access the %s. Please try again by setting the header '%s'.", api, want), "invalid_request_error", nil, &code)
+			return
+		}
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("The %s=%s version of the %s is not supported. Please try again by setting the header '%s'.", feature, got, api, want), "invalid_request_error", nil, &code)
+	}
+}
@@ -0,0 +1,123 @@
+package mockserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBetaFeatures(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    map[string]string
+	}{
+		{"None", nil, map[string]string{}},
+		{"Single", []string{"assistants=v2"}, map[string]string{"assistants": "v2"}},
+		{"List", []string{" realtime=v1 , Assistants=v2 "}, map[string]string{"realtime": "v1", "assistants": "v2"}},
+		{"Repeated", []string{"realtime=v1", "assistants=v2"}, map[string]string{"realtime": "v1", "assistants": "v2"}},
+		{"NoVersion", []string{"assistants,"}, map[string]string{"assistants": ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/v1/assistants", nil)
+			for _, h := range tt.headers {
+				r.Header.Add("OpenAI-Beta", h)
+			}
+			if got := betaFeatures(r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("betaFeatures = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBetaGating(t *testing.T) {
+	ts := Start(t)
+
+	tests := []struct {
+		name       string
+		path       string
+		headers    []string
+		wantStatus int
+		wantError  string
+	}{
+		{"Missing", "/assistants", nil, 400, "You must provide the 'OpenAI-Beta' header"},
+		{"WrongVersion", "/assistants", []string{"assistants=v1"}, 400, "The assistants=v1 version"},
+		{"OtherFeature", "/assistants", []string{"realtime=v1"}, 400, "You must provide the 'OpenAI-Beta' header"},
+		{"Enabled", "/assistants", []string{"assistants=v2"}, 200, ""},
+		{"EnabledInList", "/assistants", []string{"realtime=v1,assistants=v2"}, 200, ""},
+		{"EnabledRepeated", "/assistants", []string{"realtime=v1", "assistants=v2"}, 200, ""},
+		{"ByIDMissing", "/assistants/asst_x", nil, 400, "You must provide the 'OpenAI-Beta' header"},
+		{"ThreadsMissing", "/threads/thread_x", nil, 400, "You must provide the 'OpenAI-Beta' header"},
+		{"ThreadsEnabled", "/threads/thread_x", []string{"assistants=v2"}, 404, "No thread found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header []string
+			for _, h := range tt.headers {
+				header = append(header, "OpenAI-Beta", h)
+			}
+			resp, body := call(t, ts, "GET", tt.path, "", header...)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantError == "" {
+				return
+			}
+			errResp := decode[ErrorResponse](t, body)
+			if !strings.HasPrefix(errResp.Error.Message, tt.wantError) {
+				t.Errorf("message = %q, want prefix %q", errResp.Error.Message, tt.wantError)
+			}
+			if resp.StatusCode == http.StatusBadRequest && (errResp.Error.Code == nil || *errResp.Error.Code != "invalid_beta") {
+				t.Errorf("code = %v, want invalid_beta", errResp.Error.Code)
+			}
+		})
+	}
+}
+
+func TestAssistantsLifecycle(t *testing.T) {
+	ts := Start(t)
+	beta := []string{"OpenAI-Beta", "assistants=v2"}
+
+	_, body := call(t, ts, "POST", "/assistants", `{"model":"gpt-4o","name":"Helper"}`, beta...)
+	created := decode[Assistant](t, body)
+	if !strings.HasPrefix(created.ID, "asst_") || created.Object != "assistant" || created.Name == nil || *created.Name != "Helper" {
+		t.Fatalf("created = %+v", created)
+	}
+	if resp, _ := call(t, ts, "POST", "/assistants", `{"name":"No model"}`, beta...); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("create without model: status = %d, want 400", resp.StatusCode)
+	}
+
+	_, body = call(t, ts, "GET", "/assistants/"+created.ID, "", beta...)
+	if got := decode[Assistant](t, body); got.ID != created.ID {
+		t.Errorf("get = %+v, want %s", got, created.ID)
+	}
+
+	_, body = call(t, ts, "GET", "/assistants", "", beta...)
+	list := decode[ListResponse[Assistant]](t, body)
+	if len(list.Data) != 1 || list.FirstID == nil || *list.FirstID != created.ID {
+		t.Errorf("list = %+v, want the created assistant", list)
+	}
+
+	_, body = call(t, ts, "DELETE", "/assistants/"+created.ID, "", beta...)
+	if got := decode[DeletionStatus](t, body); !got.Deleted || got.Object != "assistant.deleted" {
+		t.Errorf("delete = %+v", got)
+	}
+	if resp, _ := call(t, ts, "GET", "/assistants/"+created.ID, "", beta...); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("get after delete: status = %d, want 404", resp.StatusCode)
+	}
+
+	_, body = call(t, ts, "POST", "/threads", `{"metadata":{"k":"v"}}`, beta...)
+	thread := decode[Thread](t, body)
+	if !strings.HasPrefix(thread.ID, "thread_") || thread.Metadata["k"] != "v" {
+		t.Fatalf("thread = %+v", thread)
+	}
+	_, body = call(t, ts, "DELETE", "/threads/"+thread.ID, "", beta...)
+	if got := decode[DeletionStatus](t, body); !got.Deleted || got.Object != "thread.deleted" {
+		t.Errorf("delete thread = %+v", got)
+	}
+}
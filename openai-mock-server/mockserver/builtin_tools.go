@@ -0,0 +1,129 @@
+package mockserver
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// ============================================================================
+// Built-in Tools
+// ============================================================================
+
+// Built-in tool types that the mock answers itself instead of returning a
+// tool call to the client.
+const (
+	toolWebSearch  = "web_search_preview"
+	toolFileSearch = "file_search"
+)
+
+// citation is a source the mock pretends a built-in tool returned. Offsets
+// are character (rune) positions in the final assistant text.
+type citation struct {
+	kind     string // "url_citation" or "file_citation"
+	title    string
+	url      string
+	fileID   string
+	filename string
+	start    int
+	end      int
+}
+
+// builtinTools reports which built-in tools a request enabled.
+func builtinTools(tools []Tool) (webSearch, fileSearch *Tool) {
+	for i := range tools {
+		switch tools[i].Type {
+		case toolWebSearch, "web_search_preview_2025_03_11", "web_search":
+			webSearch = &tools[i]
+		case toolFileSearch:
+			fileSearch = &tools[i]
+		}
+	}
+	return webSearch, fileSearch
+}
+
+// applyBuiltinTools appends a sources paragraph to content for each enabled
+// built-in tool and returns the citations pointing into the new text.
+func applyBuiltinTools(content, query string, webSearch, fileSearch *Tool) (string, []citation) {
+	if webSearch == nil && fileSearch == nil {
+		return content, nil
+	}
+
+	query = truncate(strings.TrimSpace(query), 60)
+	var b strings.Builder
+	b.WriteString(content)
+	var citations []citation
+
+	if webSearch != nil {
+		title := fmt.Sprintf("Search results for %q", query)
+		link := "https://example.com/search?q=" + url.QueryEscape(query)
+		b.WriteString("\n\nSource: ")
+		start := utf8.RuneCountInString(b.String())
+		fmt.Fprintf(&b, "[%s](%s)", title, link)
+		citations = append(citations, citation{
+			kind:  "url_citation",
+			title: title,
+			url:   link,
+			start: start,
+			end:   utf8.RuneCountInString(b.String()),
+		})
+	}
+
+	if fileSearch != nil {
+		storeID := "vs_mock"
+		if len(fileSearch.VectorStoreIDs) > 0 {
+			storeID = fileSearch.VectorStoreIDs[0]
+		}
+		b.WriteString("\n\nSee mock-document.txt")
+		citations = append(citations, citation{
+			kind:     "file_citation",
+			fileID:   "file-" + strings.TrimPrefix(storeID, "vs_"),
+			filename: "mock-document.txt",
+			start:    utf8.RuneCountInString(b.String()),
+		})
+		b.WriteString(".")
+	}
+
+	return b.String(), citations
+}
+
+// chatAnnotations converts citations to the Chat Completions format.
+func chatAnnotations(citations []citation) []Annotation {
+	var annotations []Annotation
+	for _, c := range citations {
+		switch c.kind {
+		case "url_citation":
+			annotations = append(annotations, Annotation{
+				Type: c.kind,
+				URLCitation: &URLCitation{
+					StartIndex: c.start,
+					EndIndex:   c.end,
+					Title:      c.title,
+					URL:        c.url,
+				},
+			})
+		case "file_citation":
+			annotations = append(annotations, Annotation{
+				Type: c.kind,
+				FileCitation: &FileCitation{
+					Index:    c.start,
+					FileID:   c.fileID,
+					Filename: c.filename,
+				},
+			})
+		}
+	}
+	return annotations
+}
+
+// lastUserText returns the text of the last user message, which the mock
+// treats as the search query.
+func lastUserText(messages []ChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content.GetText()
+		}
+	}
+	return ""
+}
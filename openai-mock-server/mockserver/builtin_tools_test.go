@@ -0,0 +1,129 @@
+package mockserver
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStreamWords(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"", nil},
+		{"Hello", []string{"Hello"}},
+		{"Hello world", []string{"Hello ", "world"}},
+		{"Hello\n\nSource: [a](b)", []string{"Hello\n\n", "Source: ", "[a](b)"}},
+		{"  lead and trail  ", []string{"  lead ", "and ", "trail  "}},
+		{" \n ", []string{" \n "}},
+	}
+	for _, tt := range tests {
+		got := streamWords(tt.content)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("streamWords(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+		if joined := strings.Join(got, ""); joined != tt.content {
+			t.Errorf("streamWords(%q) joins to %q", tt.content, joined)
+		}
+	}
+}
+
+// streamedChat reads a streamed chat completion and returns the reassembled
+// content and the annotations sent with it.
+func streamedChat(t *testing.T, data []byte) (string, []Annotation) {
+	t.Helper()
+	var content strings.Builder
+	var annotations []Annotation
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		payload, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok || payload == "[DONE]" {
+			continue
+		}
+		chunk := decode[ChatCompletionChunk](t, []byte(payload))
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != nil {
+				content.WriteString(*choice.Delta.Content)
+			}
+			annotations = append(annotations, choice.Delta.Annotations...)
+		}
+	}
+	return content.String(), annotations
+}
+
+func TestBuiltinToolCitations(t *testing.T) {
+	ts := Start(t)
+	messages := `"model":"gpt-4o","messages":[{"role":"user","content":"Say hello in 5 words"}]`
+
+	tests := []struct {
+		name     string
+		request  string
+		wantURL  bool
+		wantFile bool
+	}{
+		{"WebSearch", `"tools":[{"type":"web_search_preview"}]`, true, false},
+		{"WebSearchOptions", `"web_search_options":{}`, true, false},
+		{"FileSearch", `"tools":[{"type":"file_search","vector_store_ids":["vs_docs"]}]`, false, true},
+		{"Both", `"tools":[{"type":"web_search_preview"},{"type":"file_search"}]`, true, true},
+		{"None", `"tools":[]`, false, false},
+	}
+
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			name := tt.name
+			if stream {
+				name += "/Streaming"
+			}
+			t.Run(name, func(t *testing.T) {
+				body := "{" + messages + "," + tt.request + "}"
+				if stream {
+					body = "{" + messages + "," + tt.request + `,"stream":true}`
+				}
+				_, data := call(t, ts, "POST", "/chat/completions", body)
+
+				var content string
+				var annotations []Annotation
+				if stream {
+					content, annotations = streamedChat(t, data)
+				} else {
+					message := decode[ChatCompletionResponse](t, data).Choices[0].Message
+					content, annotations = message.Content.GetText(), message.Annotations
+				}
+
+				// Offsets are rune positions in the content the client
+				// reassembles
+				text := []rune(content)
+				var gotURL, gotFile bool
+				for _, a := range annotations {
+					switch {
+					case a.URLCitation != nil:
+						gotURL = true
+						c := a.URLCitation
+						if c.StartIndex < 0 || c.EndIndex > len(text) || c.StartIndex >= c.EndIndex {
+							t.Fatalf("url_citation [%d,%d) out of range for %q", c.StartIndex, c.EndIndex, content)
+						}
+						if got, want := string(text[c.StartIndex:c.EndIndex]), "["+c.Title+"]("+c.URL+")"; got != want {
+							t.Errorf("url_citation covers %q, want %q", got, want)
+						}
+					case a.FileCitation != nil:
+						gotFile = true
+						c := a.FileCitation
+						name := []rune(c.Filename)
+						if c.Index < len(name) || c.Index > len(text) || string(text[c.Index-len(name):c.Index]) != c.Filename {
+							t.Errorf("file_citation index %d does not follow %q in %q", c.Index, c.Filename, content)
+						}
+						if tt.name == "FileSearch" && c.FileID != "file-docs" {
+							t.Errorf("file_id = %q, want file-docs from the vector store", c.FileID)
+						}
+					}
+				}
+				if gotURL != tt.wantURL || gotFile != tt.wantFile {
+					t.Errorf("url_citation %v, file_citation %v; want %v, %v", gotURL, gotFile, tt.wantURL, tt.wantFile)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package mockserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// ============================================================================
+// Ephemeral PKI
+// ============================================================================
+
+// testPKI is a throwaway CA together with a server and client identity issued
+// by it. It is only used by Start, so keys are ECDSA P-256 for speed rather
+// than the 4096-bit RSA keys produced by certs/generate.sh.
+type testPKI struct {
+	caCert *x509.Certificate
+	pool   *x509.CertPool
+	server tls.Certificate
+	client tls.Certificate
+}
+
+func generateTestPKI() (*testPKI, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate CA key: %w", err)
+	}
+
+	now := time.Now()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"MockOpenAI"}, CommonName: "MockOpenAI-Test-CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("create CA certificate: %w", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, fmt.Errorf("parse CA certificate: %w", err)
+	}
+
+	server, err := issueTestCert(caCert, caKey, 2, &x509.Certificate{
+		Subject:     pkix.Name{Organization: []string{"MockOpenAI"}, CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("issue server certificate: %w", err)
+	}
+
+	client, err := issueTestCert(caCert, caKey, 3, &x509.Certificate{
+		Subject:     pkix.Name{Organization: []string{"MockOpenAI"}, CommonName: "test-client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("issue client certificate: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	return &testPKI{caCert: caCert, pool: pool, server: server, client: client}, nil
+}
+
+// issueTestCert signs template with the CA and returns it as a tls.Certificate
+// with a freshly generated key.
+func issueTestCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey, serial int64, template *x509.Certificate) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore = ca.NotBefore
+	template.NotAfter = ca.NotAfter
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
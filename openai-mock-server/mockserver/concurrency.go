@@ -0,0 +1,38 @@
+package mockserver
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// ============================================================================
+// Concurrency Limiting
+// ============================================================================
+
+// limitConcurrency bounds the number of in-flight requests to max. Requests
+// beyond the limit are rejected immediately with status (429 or 503) and a
+// Retry-After header, like a capacity-limited backend would. A max of zero
+// disables the limit.
+func limitConcurrency(max, status int, next http.Handler) http.Handler {
+	if max <= 0 {
+		return next
+	}
+
+	slots := make(chan struct{}, max)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", "1")
+			w.Header().Set("X-Concurrency-Limit", strconv.Itoa(max))
+			if status == http.StatusServiceUnavailable {
+				sendError(w, status, "The server is overloaded or not ready yet.", "server_error", nil, nil)
+				return
+			}
+			code := "rate_limit_exceeded"
+			sendError(w, http.StatusTooManyRequests, "Too many concurrent requests. Please retry after a short wait.", "requests", nil, &code)
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package mockserver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		wantStatus int
+		wantType   string
+		wantCode   string
+	}{
+		{"DefaultStatus", 0, 429, "requests", "rate_limit_exceeded"},
+		{"TooManyRequests", http.StatusTooManyRequests, 429, "requests", "rate_limit_exceeded"},
+		{"ServiceUnavailable", http.StatusServiceUnavailable, 503, "server_error", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := StartWithConfig(t, Config{MaxConcurrent: 1, OverloadStatus: tt.status})
+
+			// Hold the first request inside the limit until released
+			entered, release := make(chan struct{}), make(chan struct{})
+			ts.Server.Use(StagePreResponse, func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Query().Get("hold") != "" {
+						close(entered)
+						<-release
+					}
+					next.ServeHTTP(w, r)
+				})
+			})
+
+			held := make(chan int)
+			go func() {
+				resp, err := ts.Client.Get(ts.URL + "/models?hold=1")
+				if err != nil {
+					t.Error(err)
+					held <- 0
+					return
+				}
+				resp.Body.Close()
+				held <- resp.StatusCode
+			}()
+			<-entered
+
+			resp, body := call(t, ts, "GET", "/models", "")
+			close(release)
+			if status := <-held; status != http.StatusOK {
+				t.Errorf("held request: status = %d, want 200", status)
+			}
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if got := resp.Header.Get("Retry-After"); got != "1" {
+				t.Errorf("Retry-After = %q, want 1", got)
+			}
+			if got := resp.Header.Get("X-Concurrency-Limit"); got != "1" {
+				t.Errorf("X-Concurrency-Limit = %q, want 1", got)
+			}
+			errResp := decode[ErrorResponse](t, body)
+			if errResp.Error.Type != tt.wantType {
+				t.Errorf("type = %q, want %q", errResp.Error.Type, tt.wantType)
+			}
+			gotCode := ""
+			if errResp.Error.Code != nil {
+				gotCode = *errResp.Error.Code
+			}
+			if gotCode != tt.wantCode {
+				t.Errorf("code = %q, want %q", gotCode, tt.wantCode)
+			}
+
+			// The slot is free again once the held request completes
+			if resp, _ := call(t, ts, "GET", "/models", ""); resp.StatusCode != http.StatusOK {
+				t.Errorf("status after release = %d, want 200", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestConcurrencyUnlimited(t *testing.T) {
+	ts := Start(t)
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	ts.Server.Use(StagePreResponse, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("hold") != "" {
+				close(entered)
+				<-release
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if resp, err := ts.Client.Get(ts.URL + "/models?hold=1"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	defer func() {
+		close(release)
+		<-done
+	}()
+	<-entered
+
+	if resp, _ := call(t, ts, "GET", "/models", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200 without a limit", resp.StatusCode)
+	}
+}
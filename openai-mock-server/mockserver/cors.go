@@ -0,0 +1,126 @@
+package mockserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// CORS
+// ============================================================================
+
+// CORSConfig controls the Access-Control-* headers sent by the server. Empty
+// fields fall back to the values in DefaultCORSConfig.
+type CORSConfig struct {
+	// AllowedOrigins lists origins allowed to call the API. "*" allows any
+	// origin.
+	AllowedOrigins []string
+
+	// AllowedMethods and AllowedHeaders are returned on preflight requests.
+	AllowedMethods []string
+	AllowedHeaders []string
+
+	// AllowCredentials sets Access-Control-Allow-Credentials for origins
+	// listed explicitly in AllowedOrigins, whose Origin is echoed back.
+	// Origins matched only by "*" never get credentials, since echoing any
+	// Origin with credentials would let every site make authenticated calls.
+	AllowCredentials bool
+
+	// MaxAge is how long, in seconds, preflight results may be cached. Nil
+	// uses the default; zero disables caching.
+	MaxAge *int
+}
+
+// DefaultCORSConfig returns the permissive policy used when no CORS options
+// are configured.
+func DefaultCORSConfig() CORSConfig {
+	maxAge := 86400
+	return CORSConfig{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "POST", "OPTIONS", "DELETE", "PUT", "PATCH"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "X-Request-ID", "OpenAI-Beta"},
+		MaxAge:         &maxAge,
+	}
+}
+
+// withDefaults fills empty fields from DefaultCORSConfig.
+func (c CORSConfig) withDefaults() CORSConfig {
+	defaults := DefaultCORSConfig()
+	if len(c.AllowedOrigins) == 0 {
+		c.AllowedOrigins = defaults.AllowedOrigins
+	}
+	if len(c.AllowedMethods) == 0 {
+		c.AllowedMethods = defaults.AllowedMethods
+	}
+	if len(c.AllowedHeaders) == 0 {
+		c.AllowedHeaders = defaults.AllowedHeaders
+	}
+	if c.MaxAge == nil {
+		c.MaxAge = defaults.MaxAge
+	}
+	return c
+}
+
+// allowOrigin returns the value for Access-Control-Allow-Origin, or "" if the
+// origin is not allowed, and whether credentials may be sent. An explicit
+// match takes precedence over "*".
+func (c CORSConfig) allowOrigin(origin string) (string, bool) {
+	wildcard := false
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			wildcard = true
+			continue
+		}
+		if origin != "" && strings.EqualFold(allowed, origin) {
+			return origin, c.AllowCredentials
+		}
+	}
+	if wildcard {
+		return "*", false
+	}
+	return "", false
+}
+
+// HasExplicitOrigin reports whether AllowedOrigins names at least one origin
+// other than "*", i.e. whether AllowCredentials can take effect.
+func (c CORSConfig) HasExplicitOrigin() bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed != "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func corsMiddleware(config CORSConfig, next http.HandlerFunc) http.HandlerFunc {
+	config = config.withDefaults()
+	methods := strings.Join(config.AllowedMethods, ", ")
+	headers := strings.Join(config.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(*config.MaxAge)
+	// With explicit origins the Allow-Origin value depends on the request
+	vary := config.HasExplicitOrigin()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin, credentials := config.allowOrigin(r.Header.Get("Origin"))
+		if vary {
+			w.Header().Add("Vary", "Origin")
+		}
+		if origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			if credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,143 @@
+package mockserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	zero := 0
+	tests := []struct {
+		name            string
+		config          CORSConfig
+		method          string
+		origin          string
+		wantOrigin      string
+		wantCredentials string
+		wantMaxAge      string
+		wantVary        bool
+		wantStatus      int
+	}{
+		{
+			name:       "DefaultWildcard",
+			method:     "GET",
+			origin:     "https://app.example",
+			wantOrigin: "*",
+			wantMaxAge: "86400",
+			wantStatus: 200,
+		},
+		{
+			name:       "Preflight",
+			method:     "OPTIONS",
+			origin:     "https://app.example",
+			wantOrigin: "*",
+			wantMaxAge: "86400",
+			wantStatus: 200,
+		},
+		{
+			name:       "ExplicitMatch",
+			config:     CORSConfig{AllowedOrigins: []string{"https://app.example"}},
+			method:     "GET",
+			origin:     "https://APP.example",
+			wantOrigin: "https://APP.example",
+			wantMaxAge: "86400",
+			wantVary:   true,
+			wantStatus: 200,
+		},
+		{
+			name:       "ExplicitMismatch",
+			config:     CORSConfig{AllowedOrigins: []string{"https://app.example"}},
+			method:     "GET",
+			origin:     "https://evil.example",
+			wantVary:   true,
+			wantStatus: 200,
+		},
+		{
+			name:            "CredentialsExplicit",
+			config:          CORSConfig{AllowedOrigins: []string{"https://app.example"}, AllowCredentials: true},
+			method:          "GET",
+			origin:          "https://app.example",
+			wantOrigin:      "https://app.example",
+			wantCredentials: "true",
+			wantMaxAge:      "86400",
+			wantVary:        true,
+			wantStatus:      200,
+		},
+		{
+			name:       "CredentialsNotForWildcard",
+			config:     CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			method:     "GET",
+			origin:     "https://evil.example",
+			wantOrigin: "*",
+			wantMaxAge: "86400",
+			wantStatus: 200,
+		},
+		{
+			name:       "CredentialsWildcardFallback",
+			config:     CORSConfig{AllowedOrigins: []string{"https://app.example", "*"}, AllowCredentials: true},
+			method:     "GET",
+			origin:     "https://evil.example",
+			wantOrigin: "*",
+			wantMaxAge: "86400",
+			wantVary:   true,
+			wantStatus: 200,
+		},
+		{
+			name:       "MaxAgeZero",
+			config:     CORSConfig{MaxAge: &zero},
+			method:     "OPTIONS",
+			origin:     "https://app.example",
+			wantOrigin: "*",
+			wantMaxAge: "0",
+			wantStatus: 200,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(Config{CORS: tt.config})
+			req := httptest.NewRequest(tt.method, "/v1/models", nil)
+			req.Header.Set("Origin", tt.origin)
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			header := rec.Header()
+			if got := header.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := header.Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("Allow-Credentials = %q, want %q", got, tt.wantCredentials)
+			}
+			if got := header.Get("Access-Control-Max-Age"); got != tt.wantMaxAge {
+				t.Errorf("Max-Age = %q, want %q", got, tt.wantMaxAge)
+			}
+			if got := header.Get("Vary") == "Origin"; got != tt.wantVary {
+				t.Errorf("Vary = %q, want Origin: %v", header.Get("Vary"), tt.wantVary)
+			}
+			if tt.method == http.MethodOptions && rec.Body.Len() != 0 {
+				t.Errorf("preflight returned a body: %s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestCORSHasExplicitOrigin(t *testing.T) {
+	tests := []struct {
+		origins []string
+		want    bool
+	}{
+		{nil, false},
+		{[]string{"*"}, false},
+		{[]string{"https://app.example"}, true},
+		{[]string{"*", "https://app.example"}, true},
+	}
+	for _, tt := range tests {
+		if got := (CORSConfig{AllowedOrigins: tt.origins}).HasExplicitOrigin(); got != tt.want {
+			t.Errorf("HasExplicitOrigin(%q) = %v, want %v", tt.origins, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package mockserver
+
+import (
+	"math/rand"
+)
+
+// ============================================================================
+// Response Generation
+// ============================================================================
+
+// ChatResult is the assistant turn produced by a ResponseGenerator. When
+// ToolCalls is non-empty the response finishes with "tool_calls" and Content
+// is sent alongside them (usually empty); otherwise it finishes with "stop".
+type ChatResult struct {
+	Content   string
+	ToolCalls []ToolCall
+
+	// Annotations cite sources in Content. The mock fills these in itself
+	// when a request enables the web_search_preview or file_search tools.
+	Annotations []Annotation
+}
+
+// ResponseGenerator decides what the mock answers. Implementations must be
+// safe for concurrent use, since handlers call them from many goroutines.
+type ResponseGenerator interface {
+	// Chat returns the assistant turn for a chat completion request. It
+	// decides whether the mock calls one of req.Tools or replies with text.
+	Chat(req *ChatCompletionRequest) ChatResult
+
+	// Embedding returns the vector for a single embeddings input.
+	Embedding(model, input string, dimensions int) []float64
+}
+
+// DefaultGenerator is the ResponseGenerator used when Config.Generator is
+// nil. It answers with text derived from the last user message and returns
+// random embeddings.
+type DefaultGenerator struct{}
+
+// Chat implements ResponseGenerator. It only calls a tool when tool_choice
+// demands one ("required" or a named function), with arguments that conform
+// to the tool's schema; otherwise it replies with text, so that agent-style
+// callers treat each turn as complete.
+func (DefaultGenerator) Chat(req *ChatCompletionRequest) ChatResult {
+	if tool := requiredTool(req); tool != nil {
+		return ChatResult{ToolCalls: []ToolCall{{
+			ID:   "call_" + shortID(),
+			Type: "function",
+			Function: FunctionCall{
+				Name:      tool.Function.Name,
+				Arguments: exampleArguments(tool.Function.Parameters),
+			},
+		}}}
+	}
+	return ChatResult{Content: echoResponse(req.Messages)}
+}
+
+// requiredTool returns the function tool that tool_choice forces the model to
+// call, or nil if the model may answer with text.
+func requiredTool(req *ChatCompletionRequest) *Tool {
+	var name string
+	switch choice := req.ToolChoice.(type) {
+	case string:
+		if choice != "required" {
+			return nil
+		}
+	case map[string]any:
+		// {"type": "function", "function": {"name": "..."}} in Chat Completions,
+		// {"type": "function", "name": "..."} in the Responses API
+		name, _ = choice["name"].(string)
+		if fn, ok := choice["function"].(map[string]any); ok {
+			name, _ = fn["name"].(string)
+		}
+		if name == "" {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	for i := range req.Tools {
+		tool := &req.Tools[i]
+		if tool.Type != "function" {
+			continue
+		}
+		if name == "" || tool.Function.Name == name {
+			return tool
+		}
+	}
+	return nil
+}
+
+// Embedding implements ResponseGenerator.
+func (DefaultGenerator) Embedding(model, input string, dimensions int) []float64 {
+	// Generate normalized random embedding
+	embedding := make([]float64, dimensions)
+	var sumSq float64
+	for j := range embedding {
+		embedding[j] = rand.NormFloat64()
+		sumSq += embedding[j] * embedding[j]
+	}
+	// Normalize to unit vector
+	norm := 1.0 / (sumSq + 1e-10)
+	for j := range embedding {
+		embedding[j] *= norm
+	}
+	return embedding
+}
+
+// chatResult runs the generator for req and then answers any built-in search
+// tools the request enabled by appending simulated sources and citations.
+func (s *Server) chatResult(req *ChatCompletionRequest) ChatResult {
+	result := s.generator().Chat(req)
+	if len(result.ToolCalls) > 0 {
+		return result
+	}
+
+	webSearch, fileSearch := builtinTools(req.Tools)
+	if webSearch == nil && req.WebSearchOptions != nil {
+		webSearch = &Tool{Type: toolWebSearch}
+	}
+
+	var citations []citation
+	result.Content, citations = applyBuiltinTools(result.Content, lastUserText(req.Messages), webSearch, fileSearch)
+	result.Annotations = append(result.Annotations, chatAnnotations(citations)...)
+	return result
+}
+
+func (s *Server) generator() ResponseGenerator {
+	if s.config.Generator != nil {
+		return s.config.Generator
+	}
+	return DefaultGenerator{}
+}
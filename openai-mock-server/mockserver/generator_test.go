@@ -0,0 +1,145 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestDefaultGeneratorChat(t *testing.T) {
+	tools := []Tool{
+		{Type: toolWebSearch},
+		{Type: "function", Function: FunctionDefinition{Name: "get_weather", Parameters: map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"location": map[string]any{"type": "string"}},
+			"required":   []any{"location"},
+		}}},
+		{Type: "function", Function: FunctionDefinition{Name: "get_time"}},
+	}
+
+	tests := []struct {
+		name       string
+		toolChoice any
+		wantTool   string
+	}{
+		{"NoChoice", nil, ""},
+		{"Auto", "auto", ""},
+		{"None", "none", ""},
+		{"Required", "required", "get_weather"},
+		{"NamedChat", map[string]any{"type": "function", "function": map[string]any{"name": "get_time"}}, "get_time"},
+		{"NamedResponses", map[string]any{"type": "function", "name": "get_time"}, "get_time"},
+		{"NamedUnknown", map[string]any{"type": "function", "name": "get_date"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &ChatCompletionRequest{
+				Model:      "gpt-4o",
+				Messages:   []ChatMessage{{Role: "user", Content: MessageContent{Text: "What's the weather?"}}},
+				Tools:      tools,
+				ToolChoice: tt.toolChoice,
+			}
+			result := DefaultGenerator{}.Chat(req)
+
+			if tt.wantTool == "" {
+				if len(result.ToolCalls) != 0 || result.Content == "" {
+					t.Fatalf("got %+v, want a text reply", result)
+				}
+				return
+			}
+			if len(result.ToolCalls) != 1 {
+				t.Fatalf("got %d tool calls, want 1", len(result.ToolCalls))
+			}
+			call := result.ToolCalls[0]
+			if call.Function.Name != tt.wantTool || call.Type != "function" || call.ID == "" {
+				t.Errorf("tool call = %+v, want a function call to %s", call, tt.wantTool)
+			}
+			var args map[string]any
+			if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+				t.Errorf("arguments %q are not a JSON object: %v", call.Function.Arguments, err)
+			}
+			if tt.wantTool == "get_weather" {
+				if _, ok := args["location"].(string); !ok {
+					t.Errorf("arguments %q lack the required location", call.Function.Arguments)
+				}
+			}
+		})
+	}
+}
+
+// fixedGenerator answers every request with the same reply and vector.
+type fixedGenerator struct{}
+
+func (fixedGenerator) Chat(*ChatCompletionRequest) ChatResult {
+	return ChatResult{Content: "fixed reply"}
+}
+
+func (fixedGenerator) Embedding(_, _ string, dimensions int) []float64 {
+	vector := make([]float64, dimensions)
+	for i := range vector {
+		vector[i] = 0.5
+	}
+	return vector
+}
+
+func TestCustomGenerator(t *testing.T) {
+	ts := StartWithConfig(t, Config{Generator: fixedGenerator{}})
+
+	_, body := call(t, ts, "POST", "/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}]}`)
+	chat := decode[ChatCompletionResponse](t, body)
+	if got := chat.Choices[0].Message.Content.GetText(); got != "fixed reply" {
+		t.Errorf("chat content = %q, want the generator's reply", got)
+	}
+
+	_, body = call(t, ts, "POST", "/embeddings", `{"model":"text-embedding-3-small","input":"Hello","dimensions":4}`)
+	embeddings := decode[EmbeddingsResponse](t, body)
+	if got := fmt.Sprint(embeddings.Data[0].Embedding); got != "[0.5 0.5 0.5 0.5]" {
+		t.Errorf("embedding = %s, want the generator's vector", got)
+	}
+}
+
+func TestEmbeddingDimensions(t *testing.T) {
+	ts := Start(t)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantLen    int
+	}{
+		{"Ada", `{"model":"text-embedding-ada-002","input":"x"}`, 200, 1536},
+		{"Small", `{"model":"text-embedding-3-small","input":"x"}`, 200, 1536},
+		{"Large", `{"model":"text-embedding-3-large","input":"x"}`, 200, 3072},
+		{"Shortened", `{"model":"text-embedding-3-large","input":"x","dimensions":256}`, 200, 256},
+		{"AdaIgnoresDimensions", `{"model":"text-embedding-ada-002","input":"x","dimensions":256}`, 200, 1536},
+		{"Zero", `{"model":"text-embedding-3-small","input":"x","dimensions":0}`, 400, 0},
+		{"Negative", `{"model":"text-embedding-3-small","input":"x","dimensions":-1}`, 400, 0},
+		{"Batch", `{"model":"text-embedding-3-small","input":["x","y"],"dimensions":8}`, 200, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := call(t, ts, "POST", "/embeddings", tt.body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if resp.StatusCode != http.StatusOK {
+				errResp := decode[ErrorResponse](t, body)
+				if errResp.Error.Param == nil || *errResp.Error.Param != "dimensions" {
+					t.Errorf("param = %v, want dimensions", errResp.Error.Param)
+				}
+				return
+			}
+			embeddings := decode[EmbeddingsResponse](t, body)
+			if len(embeddings.Data) == 0 {
+				t.Fatal("no embeddings returned")
+			}
+			for _, data := range embeddings.Data {
+				if len(data.Embedding) != tt.wantLen {
+					t.Errorf("embedding %d has %d dimensions, want %d", data.Index, len(data.Embedding), tt.wantLen)
+				}
+			}
+		})
+	}
+}
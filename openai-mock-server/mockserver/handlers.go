@@ -0,0 +1,498 @@
+package mockserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// Handlers
+// ============================================================================
+
+func (s *Server) modelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error", nil, nil)
+		return
+	}
+
+	response := ModelsResponse{
+		Object: "list",
+		Data:   mockModels,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) modelByIDHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error", nil, nil)
+		return
+	}
+
+	// Extract model ID from path: /v1/models/{model_id}
+	path := strings.TrimPrefix(r.URL.Path, "/v1/models/")
+	modelID := strings.TrimSuffix(path, "/")
+
+	for _, model := range mockModels {
+		if model.ID == modelID {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(model)
+			return
+		}
+	}
+
+	code := "model_not_found"
+	sendError(w, http.StatusNotFound, fmt.Sprintf("The model '%s' does not exist", modelID), "invalid_request_error", nil, &code)
+}
+
+func (s *Server) chatCompletionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.listStoredCompletionsHandler(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error", nil, nil)
+		return
+	}
+
+	// Read body for logging in verbose mode
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		param := "body"
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err), "invalid_request_error", &param, nil)
+		return
+	}
+
+	if s.config.Verbose {
+		log.Printf("  Request body: %s", string(bodyBytes))
+	}
+
+	var req ChatCompletionRequest
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		param := "body"
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err), "invalid_request_error", &param, nil)
+		return
+	}
+
+	// Validate required fields
+	if req.Model == "" {
+		param := "model"
+		sendError(w, http.StatusBadRequest, "Missing required parameter: 'model'", "invalid_request_error", &param, nil)
+		return
+	}
+
+	if len(req.Messages) == 0 {
+		param := "messages"
+		sendError(w, http.StatusBadRequest, "Missing required parameter: 'messages'", "invalid_request_error", &param, nil)
+		return
+	}
+
+	if err := validateLogitBias(req.Model, req.LogitBias); err != nil {
+		param := "logit_bias"
+		sendError(w, http.StatusBadRequest, err.Error(), "invalid_request_error", &param, nil)
+		return
+	}
+
+	if err := validateMetadata(req.Metadata); err != nil {
+		param := "metadata"
+		sendError(w, http.StatusBadRequest, err.Error(), "invalid_request_error", &param, nil)
+		return
+	}
+
+	for i, tool := range req.Tools {
+		if tool.Function.Strict == nil || !*tool.Function.Strict {
+			continue
+		}
+		if err := validateStrictSchema(tool.Function.Name, tool.Function.Parameters); err != nil {
+			param := fmt.Sprintf("tools[%d].function.parameters", i)
+			code := "invalid_function_parameters"
+			sendError(w, http.StatusBadRequest, err.Error(), "invalid_request_error", &param, &code)
+			return
+		}
+	}
+
+	result := s.chatResult(&req)
+
+	responseMessage := ChatMessage{
+		Role:        "assistant",
+		Content:     MessageContent{Text: result.Content},
+		ToolCalls:   result.ToolCalls,
+		Annotations: result.Annotations,
+	}
+	finishReason := "stop"
+	if len(result.ToolCalls) > 0 {
+		finishReason = "tool_calls"
+	}
+
+	// Calculate tokens
+	promptTokens := 0
+	for _, msg := range req.Messages {
+		promptTokens += estimateTokens(msg.Content.GetText())
+	}
+	completionTokens := estimateTokens(result.Content)
+	for _, call := range result.ToolCalls {
+		completionTokens += estimateTokens(call.Function.Arguments)
+	}
+
+	// Determine number of choices; streaming always sends a single choice
+	n := 1
+	if req.N != nil && *req.N > 0 && !req.Stream {
+		n = *req.N
+	}
+
+	choices := make([]ChatChoice, n)
+	for i := 0; i < n; i++ {
+		choices[i] = ChatChoice{
+			Index:        i,
+			Message:      responseMessage,
+			FinishReason: finishReason,
+		}
+	}
+
+	response := ChatCompletionResponse{
+		ID:      "chatcmpl-" + uuid.New().String()[:24],
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+		Choices: choices,
+		Usage: Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens * n,
+			TotalTokens:      promptTokens + completionTokens*n,
+		},
+		SystemFingerprint: generateFingerprint(),
+		Metadata:          req.Metadata,
+		Debug:             s.debugInfo(&req),
+	}
+
+	s.store.recordUsage(req.User, response.Usage)
+	if req.Store != nil && *req.Store {
+		stored := response
+		stored.Debug = nil
+		if stored.Metadata == nil {
+			stored.Metadata = map[string]string{}
+		}
+		s.store.save(&StoredCompletion{
+			ID:       stored.ID,
+			Model:    stored.Model,
+			Created:  stored.Created,
+			User:     req.User,
+			Client:   ClientIdentity(r),
+			Metadata: stored.Metadata,
+			Usage:    stored.Usage,
+			response: stored,
+			messages: req.Messages,
+		})
+	}
+
+	// Handle streaming
+	if req.Stream {
+		s.handleStreamingChat(r.Context(), w, req, result, response)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleStreamingChat streams result as chunks of completion, whose ID,
+// creation time and fingerprint are reused so a stored streamed completion
+// can be retrieved by the ID the client saw. It stops when ctx is cancelled
+// by the client going away.
+func (s *Server) handleStreamingChat(ctx context.Context, w http.ResponseWriter, req ChatCompletionRequest, result ChatResult, completion ChatCompletionResponse) {
+	// Set SSE headers
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendError(w, http.StatusInternalServerError, "Streaming not supported", "server_error", nil, nil)
+		return
+	}
+
+	completionID := completion.ID
+	created := completion.Created
+	fingerprint := completion.SystemFingerprint
+
+	// Send initial chunk with role
+	assistantRole := "assistant"
+	initialChunk := ChatCompletionChunk{
+		ID:                completionID,
+		Object:            "chat.completion.chunk",
+		Created:           created,
+		Model:             req.Model,
+		SystemFingerprint: fingerprint,
+		Choices: []StreamChoice{
+			{
+				Index: 0,
+				Delta: StreamDelta{Role: &assistantRole},
+			},
+		},
+	}
+	sendSSEChunk(w, flusher, initialChunk)
+
+	// Stream content word by word
+	for _, word := range streamWords(result.Content) {
+		if !typingDelay(ctx) {
+			return
+		}
+
+		content := word
+		chunk := ChatCompletionChunk{
+			ID:                completionID,
+			Object:            "chat.completion.chunk",
+			Created:           created,
+			Model:             req.Model,
+			SystemFingerprint: fingerprint,
+			Choices: []StreamChoice{
+				{
+					Index: 0,
+					Delta: StreamDelta{Content: &content},
+				},
+			},
+		}
+		sendSSEChunk(w, flusher, chunk)
+	}
+
+	// Stream tool calls: the first delta for each call carries its ID and
+	// name, the following deltas carry fragments of the arguments.
+	for i, call := range result.ToolCalls {
+		index := i
+		header := ToolCall{
+			Index:    &index,
+			ID:       call.ID,
+			Type:     call.Type,
+			Function: FunctionCall{Name: call.Function.Name},
+		}
+		sendSSEChunk(w, flusher, toolCallChunk(completionID, created, req.Model, fingerprint, header))
+
+		for _, fragment := range splitArguments(call.Function.Arguments, 16) {
+			if !typingDelay(ctx) {
+				return
+			}
+
+			delta := ToolCall{Index: &index, Function: FunctionCall{Arguments: fragment}}
+			sendSSEChunk(w, flusher, toolCallChunk(completionID, created, req.Model, fingerprint, delta))
+		}
+	}
+
+	// Send final chunk with finish_reason
+	finishReason := "stop"
+	if len(result.ToolCalls) > 0 {
+		finishReason = "tool_calls"
+	}
+	finalChunk := ChatCompletionChunk{
+		ID:                completionID,
+		Object:            "chat.completion.chunk",
+		Created:           created,
+		Model:             req.Model,
+		SystemFingerprint: fingerprint,
+		Choices: []StreamChoice{
+			{
+				Index:        0,
+				Delta:        StreamDelta{Annotations: result.Annotations},
+				FinishReason: &finishReason,
+			},
+		},
+	}
+	finalChunk.Debug = s.debugInfo(&req)
+	sendSSEChunk(w, flusher, finalChunk)
+
+	// With stream_options.include_usage, a last chunk with no choices
+	// reports the usage of the whole completion, as the API sends it
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		usage := completion.Usage
+		sendSSEChunk(w, flusher, ChatCompletionChunk{
+			ID:                completionID,
+			Object:            "chat.completion.chunk",
+			Created:           created,
+			Model:             req.Model,
+			SystemFingerprint: fingerprint,
+			Choices:           []StreamChoice{},
+			Usage:             &usage,
+		})
+	}
+
+	// Send [DONE] message
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+func toolCallChunk(id string, created int64, model, fingerprint string, call ToolCall) ChatCompletionChunk {
+	return ChatCompletionChunk{
+		ID:                id,
+		Object:            "chat.completion.chunk",
+		Created:           created,
+		Model:             model,
+		SystemFingerprint: fingerprint,
+		Choices: []StreamChoice{
+			{
+				Index: 0,
+				Delta: StreamDelta{ToolCalls: []ToolCall{call}},
+			},
+		},
+	}
+}
+
+// typingDelay waits between streamed chunks, as a model generating tokens
+// would. It returns false as soon as ctx is done, so that a handler stops
+// streaming when the client disconnects rather than writing the rest of
+// the reply to a closed connection.
+func typingDelay(ctx context.Context) bool {
+	timer := time.NewTimer(50 * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// streamWordPattern matches a word with the whitespace that follows it, and
+// any whitespace before it at the start of the text.
+var streamWordPattern = regexp.MustCompile(`\s*\S+\s*`)
+
+// streamWords splits content into the pieces sent by streaming responses.
+// Whitespace is kept exactly, so the pieces concatenate back to content and
+// annotation offsets computed on content stay valid for the streamed text.
+func streamWords(content string) []string {
+	words := streamWordPattern.FindAllString(content, -1)
+	if len(words) == 0 && content != "" {
+		words = []string{content}
+	}
+	return words
+}
+
+// splitArguments breaks a tool call's JSON arguments into fragments of at most
+// size bytes, as the real API streams them.
+func splitArguments(args string, size int) []string {
+	var fragments []string
+	for len(args) > size {
+		fragments = append(fragments, args[:size])
+		args = args[size:]
+	}
+	if args != "" {
+		fragments = append(fragments, args)
+	}
+	return fragments
+}
+
+func sendSSEChunk(w http.ResponseWriter, flusher http.Flusher, chunk ChatCompletionChunk) {
+	data, _ := json.Marshal(chunk)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	flusher.Flush()
+}
+
+func (s *Server) embeddingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error", nil, nil)
+		return
+	}
+
+	var req EmbeddingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		param := "body"
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err), "invalid_request_error", &param, nil)
+		return
+	}
+
+	// Validate required fields
+	if req.Model == "" {
+		param := "model"
+		sendError(w, http.StatusBadRequest, "Missing required parameter: 'model'", "invalid_request_error", &param, nil)
+		return
+	}
+
+	if req.Input == nil {
+		param := "input"
+		sendError(w, http.StatusBadRequest, "Missing required parameter: 'input'", "invalid_request_error", &param, nil)
+		return
+	}
+
+	if req.Dimensions != nil && *req.Dimensions < 1 {
+		param := "dimensions"
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid value for 'dimensions' = %d. Must be greater than or equal to 1.", *req.Dimensions), "invalid_request_error", &param, nil)
+		return
+	}
+
+	dimensions := embeddingDimensions(req.Model, req.Dimensions)
+
+	// Parse inputs
+	var inputs []string
+	switch v := req.Input.(type) {
+	case string:
+		inputs = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				inputs = append(inputs, s)
+			}
+		}
+	}
+
+	// Generate embeddings
+	totalTokens := 0
+	data := make([]EmbeddingData, len(inputs))
+	for i, input := range inputs {
+		totalTokens += estimateTokens(input)
+
+		embedding := s.generator().Embedding(req.Model, input, dimensions)
+
+		data[i] = EmbeddingData{
+			Object:    "embedding",
+			Embedding: embedding,
+			Index:     i,
+		}
+	}
+
+	response := EmbeddingsResponse{
+		Object: "list",
+		Data:   data,
+		Model:  req.Model,
+	}
+	response.Usage.PromptTokens = totalTokens
+	response.Usage.TotalTokens = totalTokens
+	s.store.recordUsage(req.User, Usage{PromptTokens: totalTokens, TotalTokens: totalTokens})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// embeddingDimensions returns the vector size for model, honouring the
+// requested dimensions for models that support shortening.
+func embeddingDimensions(model string, requested *int) int {
+	dimensions := 1536 // default for ada-002 and 3-small
+	if model == "text-embedding-3-large" {
+		dimensions = 3072
+	}
+	// Allow custom dimensions for v3 models
+	if requested != nil && (model == "text-embedding-3-small" || model == "text-embedding-3-large") {
+		dimensions = *requested
+	}
+	return dimensions
+}
+
+// debugInfo returns the debug object for a chat response, or nil unless
+// Config.DebugEcho is set.
+func (s *Server) debugInfo(req *ChatCompletionRequest) *DebugInfo {
+	if !s.config.DebugEcho {
+		return nil
+	}
+	return &DebugInfo{
+		VendorParams: req.VendorExtensions(),
+		LogitBias:    req.LogitBias,
+	}
+}
@@ -0,0 +1,123 @@
+package mockserver
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// longGenerator replies with 100 words, which take five seconds to stream.
+type longGenerator struct{ DefaultGenerator }
+
+func (longGenerator) Chat(*ChatCompletionRequest) ChatResult {
+	return ChatResult{Content: strings.TrimSpace(strings.Repeat("word ", 100))}
+}
+
+func TestStreamingStopsOnDisconnect(t *testing.T) {
+	ts := StartWithConfig(t, Config{Generator: longGenerator{}})
+	root := strings.TrimSuffix(ts.URL, "/v1")
+
+	returned := make(chan struct{}, 1)
+	ts.Server.Use(StagePostAuth, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			returned <- struct{}{}
+		})
+	})
+
+	tests := []struct {
+		name string
+		url  string
+		body string
+	}{
+		{"Chat", ts.URL + "/chat/completions", `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Hello"}]}`},
+		{"OllamaChat", root + "/api/chat", `{"model":"llama3.2","messages":[{"role":"user","content":"Hello"}]}`},
+		{"OllamaGenerate", root + "/api/generate", `{"model":"llama3.2","prompt":"Hello"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, "POST", tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := ts.Client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			// Read two events, then go away mid-stream
+			scanner := bufio.NewScanner(resp.Body)
+			for lines := 0; lines < 2 && scanner.Scan(); {
+				if strings.TrimSpace(scanner.Text()) != "" {
+					lines++
+				}
+			}
+			cancel()
+
+			select {
+			case <-returned:
+			case <-time.After(time.Second):
+				t.Fatal("handler still streaming a second after the client disconnected")
+			}
+		})
+	}
+}
+
+func TestStreamUsage(t *testing.T) {
+	ts := Start(t)
+	messages := `"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Hello"}]`
+
+	for _, tt := range []struct {
+		name    string
+		options string
+		usage   bool
+	}{
+		{"IncludeUsage", `,"stream_options":{"include_usage":true}`, true},
+		{"NoUsage", `,"stream_options":{"include_usage":false}`, false},
+		{"NoOptions", "", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, data := call(t, ts, "POST", "/chat/completions", "{"+messages+tt.options+"}")
+			var payloads []string
+			scanner := bufio.NewScanner(strings.NewReader(string(data)))
+			for scanner.Scan() {
+				if payload, ok := strings.CutPrefix(scanner.Text(), "data: "); ok && payload != "[DONE]" {
+					payloads = append(payloads, payload)
+				}
+			}
+			if len(payloads) < 2 {
+				t.Fatalf("%d chunks:\n%s", len(payloads), data)
+			}
+
+			// Only the last chunk reports usage, with an empty list of
+			// choices rather than none
+			for _, payload := range payloads[:len(payloads)-1] {
+				if decode[ChatCompletionChunk](t, []byte(payload)).Usage != nil {
+					t.Errorf("usage before the last chunk: %s", payload)
+				}
+			}
+			last := payloads[len(payloads)-1]
+			usage := decode[ChatCompletionChunk](t, []byte(last)).Usage
+			if !tt.usage {
+				if usage != nil {
+					t.Errorf("usage without include_usage: %s", last)
+				}
+				return
+			}
+			if usage == nil || usage.PromptTokens == 0 || usage.CompletionTokens == 0 || usage.TotalTokens != usage.PromptTokens+usage.CompletionTokens {
+				t.Errorf("usage chunk %s", last)
+			}
+			if !strings.Contains(last, `"choices":[]`) {
+				t.Errorf("usage chunk without an empty choices list: %s", last)
+			}
+		})
+	}
+}
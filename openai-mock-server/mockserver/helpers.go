@@ -0,0 +1,35 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// Helpers
+// ============================================================================
+
+func sendError(w http.ResponseWriter, status int, message, errType string, param, code *string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error: ErrorDetail{
+			Message: message,
+			Type:    errType,
+			Param:   param,
+			Code:    code,
+		},
+	})
+}
+
+func estimateTokens(text string) int {
+	// Rough approximation: ~4 chars per token
+	return len(text) / 4
+}
+
+func generateFingerprint() string {
+	return fmt.Sprintf("fp_%s", uuid.New().String()[:12])
+}
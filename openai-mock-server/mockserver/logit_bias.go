@@ -0,0 +1,52 @@
+package mockserver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// Logit Bias
+// ============================================================================
+
+// vocabularySize returns the number of tokens in the tokenizer used by model:
+// o200k_base for the GPT-4o family, cl100k_base otherwise.
+func vocabularySize(model string) int {
+	if strings.HasPrefix(model, "gpt-4o") || strings.HasPrefix(model, "o1") || strings.HasPrefix(model, "o3") {
+		return 200019
+	}
+	return 100277
+}
+
+// validateLogitBias checks that every key is a token ID in the model's
+// vocabulary and every bias is within [-100, 100]. Keys are checked in
+// numeric order so the reported error is deterministic.
+func validateLogitBias(model string, bias map[string]float64) error {
+	keys := make([]string, 0, len(bias))
+	for key := range bias {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	vocab := vocabularySize(model)
+	for _, key := range keys {
+		token, err := strconv.Atoi(key)
+		if err != nil || token < 0 {
+			return fmt.Errorf("Invalid key in 'logit_bias': %s. You should only be submitting non-negative integers.", key)
+		}
+		if token >= vocab {
+			return fmt.Errorf("Invalid key in 'logit_bias': %s. Token IDs must be less than %d for model '%s'.", key, vocab, model)
+		}
+		if value := bias[key]; value < -100 || value > 100 {
+			return fmt.Errorf("Invalid value for 'logit_bias[%s]': %v. Bias values must be between -100 and 100.", key, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package mockserver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLogitBias(t *testing.T) {
+	ts := StartWithConfig(t, Config{DebugEcho: true})
+
+	tests := []struct {
+		name    string
+		model   string
+		bias    string
+		wantErr string
+	}{
+		{"Valid", "gpt-4", `{"50256":-100,"1":100,"2":0.5}`, ""},
+		{"Empty", "gpt-4", `{}`, ""},
+		{"NotAnInteger", "gpt-4", `{"hello":1}`, "Invalid key in 'logit_bias': hello. You should only be submitting non-negative integers."},
+		{"Negative", "gpt-4", `{"-1":1}`, "Invalid key in 'logit_bias': -1."},
+		{"OutsideCl100k", "gpt-4", `{"150000":1}`, "Token IDs must be less than 100277 for model 'gpt-4'."},
+		{"InsideO200k", "gpt-4o", `{"150000":1}`, ""},
+		{"OutsideO200k", "gpt-4o-mini", `{"200019":1}`, "Token IDs must be less than 200019"},
+		{"ValueTooLow", "gpt-4", `{"1":-100.5}`, "Invalid value for 'logit_bias[1]': -100.5."},
+		{"ValueTooHigh", "gpt-4", `{"1":101}`, "Invalid value for 'logit_bias[1]': 101."},
+		{"FirstKeyReported", "gpt-4", `{"999999":1,"12":500}`, "Invalid value for 'logit_bias[12]'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"model":"` + tt.model + `","messages":[{"role":"user","content":"Hello"}],"logit_bias":` + tt.bias + `}`
+			resp, data := call(t, ts, "POST", "/chat/completions", body)
+
+			if tt.wantErr == "" {
+				if resp.StatusCode != 200 {
+					t.Fatalf("status = %d, want 200 (%s)", resp.StatusCode, data)
+				}
+				want := decode[map[string]float64](t, []byte(tt.bias))
+				var got map[string]float64
+				if debug := decode[ChatCompletionResponse](t, data).Debug; debug != nil {
+					got = debug.LogitBias
+				}
+				if len(want) > 0 && !reflect.DeepEqual(got, want) {
+					t.Errorf("debug logit_bias = %v, want %v", got, want)
+				}
+				return
+			}
+
+			if resp.StatusCode != 400 {
+				t.Fatalf("status = %d, want 400", resp.StatusCode)
+			}
+			errResp := decode[ErrorResponse](t, data)
+			if !strings.Contains(errResp.Error.Message, tt.wantErr) {
+				t.Errorf("message = %q, want it to contain %q", errResp.Error.Message, tt.wantErr)
+			}
+			if errResp.Error.Param == nil || *errResp.Error.Param != "logit_bias" {
+				t.Errorf("param = %v, want logit_bias", errResp.Error.Param)
+			}
+		})
+	}
+}
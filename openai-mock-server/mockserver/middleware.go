@@ -0,0 +1,96 @@
+package mockserver
+
+import (
+	"net/http"
+)
+
+// ============================================================================
+// Middleware
+// ============================================================================
+
+// Middleware wraps a handler to add behaviour such as logging, quotas or
+// fault injection.
+type Middleware func(http.Handler) http.Handler
+
+// Stage selects where in the request pipeline a Middleware runs.
+//
+// The pipeline is: CORS -> StagePreAuth -> authentication -> concurrency
+// limit -> StagePostAuth -> routing -> StagePreResponse -> endpoint handler.
+type Stage int
+
+const (
+	// StagePreAuth runs before the client identity is checked, so it also
+	// sees requests that will be rejected.
+	StagePreAuth Stage = iota
+
+	// StagePostAuth runs once the client has been authenticated.
+	// ClientIdentity is available at this point.
+	StagePostAuth
+
+	// StagePreResponse runs after routing, immediately before the endpoint
+	// handler writes its response.
+	StagePreResponse
+)
+
+// Use registers middleware at the given stage. Middleware registered earlier
+// runs first (outermost). Use must be called before the server starts
+// handling requests.
+func (s *Server) Use(stage Stage, middleware ...Middleware) {
+	s.middleware[stage] = append(s.middleware[stage], middleware...)
+	s.buildHandler()
+}
+
+// chain wraps h with the middleware registered at stage.
+func (s *Server) chain(stage Stage, h http.Handler) http.Handler {
+	mws := s.middleware[stage]
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// buildHandler assembles the full request pipeline.
+func (s *Server) buildHandler() {
+	routed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.logRequest(r)
+		pattern, handler := s.route(r.URL.Path)
+		requestCounts.Add(pattern, 1)
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		s.chain(StagePreResponse, handler).ServeHTTP(w, r)
+	})
+
+	h := s.chain(StagePostAuth, routed)
+	h = limitConcurrency(s.config.MaxConcurrent, s.config.OverloadStatus, h)
+	h = authenticate(h)
+	h = s.chain(StagePreAuth, h)
+	s.handler = corsMiddleware(s.config.CORS, h.ServeHTTP)
+}
+
+// ============================================================================
+// Authentication
+// ============================================================================
+
+// authenticate rejects TLS requests that did not present a verified client
+// certificate. The standard listener already enforces this during the
+// handshake; the check matters when the server is embedded behind a TLS
+// config that only requests client certificates.
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) == 0 {
+			code := "invalid_client_certificate"
+			sendError(w, http.StatusUnauthorized, "A valid client certificate is required", "invalid_request_error", nil, &code)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIdentity returns the common name of the verified client certificate
+// presented with r, or "" for plain HTTP requests.
+func ClientIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
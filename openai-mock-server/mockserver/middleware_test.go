@@ -0,0 +1,118 @@
+package mockserver
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// trace records the order in which middleware runs.
+type trace struct {
+	mu    sync.Mutex
+	steps []string
+}
+
+func (tr *trace) mark(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tr.mu.Lock()
+			tr.steps = append(tr.steps, name)
+			tr.mu.Unlock()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (tr *trace) take() []string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	steps := tr.steps
+	tr.steps = nil
+	return steps
+}
+
+func TestMiddlewareStages(t *testing.T) {
+	tr := &trace{}
+	ts := Start(t)
+	// Registered out of pipeline order on purpose
+	ts.Server.Use(StagePreResponse, tr.mark("pre-response"))
+	ts.Server.Use(StagePostAuth, tr.mark("post-auth"))
+	ts.Server.Use(StagePreAuth, tr.mark("pre-auth 1"), tr.mark("pre-auth 2"))
+	ts.Server.Use(StagePreAuth, tr.mark("pre-auth 3"))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		want       []string
+	}{
+		{"Routed", "GET", "/models", 200, []string{"pre-auth 1", "pre-auth 2", "pre-auth 3", "post-auth", "pre-response"}},
+		{"UnknownURL", "GET", "/nope", 404, []string{"pre-auth 1", "pre-auth 2", "pre-auth 3", "post-auth", "pre-response"}},
+		{"Preflight", "OPTIONS", "/models", 200, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := call(t, ts, tt.method, tt.path, "")
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := tr.take(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("middleware ran as %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewarePreAuthSeesRejected(t *testing.T) {
+	tr := &trace{}
+	s := New(Config{})
+	s.Use(StagePreAuth, tr.mark("pre-auth"))
+	s.Use(StagePostAuth, tr.mark("post-auth"))
+
+	// A TLS request without a verified chain, as when the server is
+	// embedded behind a config that only requests client certificates
+	req := httptest.NewRequest("GET", "/v1/models", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+	if got := tr.take(); !reflect.DeepEqual(got, []string{"pre-auth"}) {
+		t.Errorf("middleware ran as %q, want only pre-auth", got)
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	ts := Start(t)
+
+	var identity string
+	ts.Server.Use(StagePostAuth, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity = ClientIdentity(r)
+			if r.URL.Path == "/v1/embeddings" {
+				sendError(w, http.StatusPaymentRequired, "Quota exceeded", "insufficient_quota", nil, nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	resp, _ := call(t, ts, "POST", "/embeddings", `{"model":"text-embedding-3-small","input":"x"}`)
+	if resp.StatusCode != http.StatusPaymentRequired {
+		t.Errorf("status = %d, want 402 from the middleware", resp.StatusCode)
+	}
+	if identity != "test-client" {
+		t.Errorf("ClientIdentity = %q in StagePostAuth, want test-client", identity)
+	}
+
+	if resp, _ := call(t, ts, "GET", "/models", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d for a request the middleware passes through", resp.StatusCode)
+	}
+}
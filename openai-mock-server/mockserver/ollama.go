@@ -0,0 +1,361 @@
+package mockserver
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// Ollama Types
+// ============================================================================
+
+type OllamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+}
+
+// OllamaToolCall differs from ToolCall in that arguments are a JSON object
+// rather than an encoded string, and calls carry no ID.
+type OllamaToolCall struct {
+	Function struct {
+		Name      string         `json:"name"`
+		Arguments map[string]any `json:"arguments"`
+	} `json:"function"`
+}
+
+type OllamaChatRequest struct {
+	Model     string          `json:"model"`
+	Messages  []OllamaMessage `json:"messages"`
+	Tools     []Tool          `json:"tools,omitempty"`
+	Stream    *bool           `json:"stream,omitempty"`
+	Format    any             `json:"format,omitempty"`
+	Options   map[string]any  `json:"options,omitempty"`
+	KeepAlive any             `json:"keep_alive,omitempty"`
+}
+
+type OllamaGenerateRequest struct {
+	Model     string         `json:"model"`
+	Prompt    string         `json:"prompt"`
+	Suffix    string         `json:"suffix,omitempty"`
+	System    string         `json:"system,omitempty"`
+	Images    []string       `json:"images,omitempty"`
+	Stream    *bool          `json:"stream,omitempty"`
+	Raw       bool           `json:"raw,omitempty"`
+	Format    any            `json:"format,omitempty"`
+	Options   map[string]any `json:"options,omitempty"`
+	KeepAlive any            `json:"keep_alive,omitempty"`
+}
+
+// OllamaStats are the timing and token counters Ollama attaches to the final
+// message of a response. Durations are in nanoseconds.
+type OllamaStats struct {
+	TotalDuration      int64 `json:"total_duration,omitempty"`
+	LoadDuration       int64 `json:"load_duration,omitempty"`
+	PromptEvalCount    int   `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int   `json:"eval_count,omitempty"`
+	EvalDuration       int64 `json:"eval_duration,omitempty"`
+}
+
+type OllamaChatResponse struct {
+	Model      string        `json:"model"`
+	CreatedAt  string        `json:"created_at"`
+	Message    OllamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason,omitempty"`
+	OllamaStats
+}
+
+type OllamaGenerateResponse struct {
+	Model      string `json:"model"`
+	CreatedAt  string `json:"created_at"`
+	Response   string `json:"response"`
+	Done       bool   `json:"done"`
+	DoneReason string `json:"done_reason,omitempty"`
+	Context    []int  `json:"context,omitempty"`
+	OllamaStats
+}
+
+type OllamaEmbeddingsRequest struct {
+	Model   string         `json:"model"`
+	Prompt  string         `json:"prompt"`
+	Options map[string]any `json:"options,omitempty"`
+}
+
+type OllamaEmbeddingsResponse struct {
+	Embedding []float64 `json:"embedding"`
+}
+
+type OllamaModelDetails struct {
+	Format            string `json:"format"`
+	Family            string `json:"family"`
+	ParameterSize     string `json:"parameter_size"`
+	QuantizationLevel string `json:"quantization_level"`
+}
+
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt string             `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+type OllamaTagsResponse struct {
+	Models []OllamaModel `json:"models"`
+}
+
+// ============================================================================
+// Ollama Handlers
+// ============================================================================
+
+func sendOllamaError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func (s *Server) ollamaTagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendOllamaError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	models := make([]OllamaModel, len(mockModels))
+	for i, m := range mockModels {
+		family := "gpt"
+		if strings.HasPrefix(m.ID, "text-embedding") {
+			family = "bert"
+		}
+		models[i] = OllamaModel{
+			Name:       m.ID + ":latest",
+			Model:      m.ID + ":latest",
+			ModifiedAt: time.Unix(m.Created, 0).UTC().Format(time.RFC3339Nano),
+			Digest:     fmt.Sprintf("%x", sha256.Sum256([]byte(m.ID))),
+			Details: OllamaModelDetails{
+				Format:            "gguf",
+				Family:            family,
+				ParameterSize:     "unknown",
+				QuantizationLevel: "unknown",
+			},
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OllamaTagsResponse{Models: models})
+}
+
+func (s *Server) ollamaChatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendOllamaError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	start := time.Now()
+
+	var req OllamaChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendOllamaError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if req.Model == "" {
+		sendOllamaError(w, http.StatusBadRequest, "model is required")
+		return
+	}
+
+	chatReq := ChatCompletionRequest{Model: req.Model, Tools: req.Tools}
+	for _, msg := range req.Messages {
+		chatReq.Messages = append(chatReq.Messages, ChatMessage{
+			Role:    msg.Role,
+			Content: MessageContent{Text: msg.Content},
+		})
+	}
+	result := s.generator().Chat(&chatReq)
+
+	promptTokens := 0
+	for _, msg := range req.Messages {
+		promptTokens += estimateTokens(msg.Content)
+	}
+	toolCalls := ollamaToolCalls(result.ToolCalls)
+
+	final := OllamaChatResponse{
+		Model:      req.Model,
+		Message:    OllamaMessage{Role: "assistant"},
+		Done:       true,
+		DoneReason: "stop",
+	}
+
+	stream := req.Stream == nil || *req.Stream // Ollama streams by default
+	if stream {
+		startNDJSON(w)
+		for _, word := range streamWords(result.Content) {
+			if !typingDelay(r.Context()) {
+				return
+			}
+			writeNDJSON(w, OllamaChatResponse{
+				Model:     req.Model,
+				CreatedAt: ollamaTimestamp(),
+				Message:   OllamaMessage{Role: "assistant", Content: word},
+			})
+		}
+		if len(toolCalls) > 0 {
+			writeNDJSON(w, OllamaChatResponse{
+				Model:     req.Model,
+				CreatedAt: ollamaTimestamp(),
+				Message:   OllamaMessage{Role: "assistant", ToolCalls: toolCalls},
+			})
+		}
+	} else {
+		final.Message.Content = result.Content
+		final.Message.ToolCalls = toolCalls
+	}
+
+	final.CreatedAt = ollamaTimestamp()
+	final.OllamaStats = ollamaStats(start, promptTokens, estimateTokens(result.Content))
+	if stream {
+		writeNDJSON(w, final)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(final)
+}
+
+func (s *Server) ollamaGenerateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendOllamaError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	start := time.Now()
+
+	var req OllamaGenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendOllamaError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if req.Model == "" {
+		sendOllamaError(w, http.StatusBadRequest, "model is required")
+		return
+	}
+
+	var chatReq ChatCompletionRequest
+	chatReq.Model = req.Model
+	if req.System != "" {
+		chatReq.Messages = append(chatReq.Messages, ChatMessage{Role: "system", Content: MessageContent{Text: req.System}})
+	}
+	chatReq.Messages = append(chatReq.Messages, ChatMessage{Role: "user", Content: MessageContent{Text: req.Prompt}})
+	result := s.generator().Chat(&chatReq)
+
+	final := OllamaGenerateResponse{
+		Model:      req.Model,
+		Done:       true,
+		DoneReason: "stop",
+		Context:    []int{1, 2, 3},
+	}
+
+	stream := req.Stream == nil || *req.Stream // Ollama streams by default
+	if stream {
+		startNDJSON(w)
+		for _, word := range streamWords(result.Content) {
+			if !typingDelay(r.Context()) {
+				return
+			}
+			writeNDJSON(w, OllamaGenerateResponse{
+				Model:     req.Model,
+				CreatedAt: ollamaTimestamp(),
+				Response:  word,
+			})
+		}
+	} else {
+		final.Response = result.Content
+	}
+
+	final.CreatedAt = ollamaTimestamp()
+	final.OllamaStats = ollamaStats(start, estimateTokens(req.System+req.Prompt), estimateTokens(result.Content))
+	if stream {
+		writeNDJSON(w, final)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(final)
+}
+
+func (s *Server) ollamaEmbeddingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendOllamaError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req OllamaEmbeddingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendOllamaError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if req.Model == "" {
+		sendOllamaError(w, http.StatusBadRequest, "model is required")
+		return
+	}
+
+	dimensions := embeddingDimensions(req.Model, nil)
+	response := OllamaEmbeddingsResponse{
+		Embedding: s.generator().Embedding(req.Model, req.Prompt, dimensions),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ============================================================================
+// Ollama Helpers
+// ============================================================================
+
+// ollamaToolCalls converts OpenAI tool calls, whose arguments are an encoded
+// JSON string, to Ollama's object form.
+func ollamaToolCalls(calls []ToolCall) []OllamaToolCall {
+	var converted []OllamaToolCall
+	for _, call := range calls {
+		var c OllamaToolCall
+		c.Function.Name = call.Function.Name
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &c.Function.Arguments); err != nil {
+			c.Function.Arguments = map[string]any{}
+		}
+		converted = append(converted, c)
+	}
+	return converted
+}
+
+func ollamaStats(start time.Time, promptTokens, evalTokens int) OllamaStats {
+	total := time.Since(start).Nanoseconds()
+	return OllamaStats{
+		TotalDuration:      total,
+		LoadDuration:       total / 10,
+		PromptEvalCount:    promptTokens,
+		PromptEvalDuration: total / 5,
+		EvalCount:          evalTokens,
+		EvalDuration:       total - total/10 - total/5,
+	}
+}
+
+func ollamaTimestamp() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// startNDJSON sets the headers for Ollama's newline-delimited JSON streams.
+func startNDJSON(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+}
+
+func writeNDJSON(w http.ResponseWriter, v any) {
+	data, _ := json.Marshal(v)
+	w.Write(append(data, '\n'))
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,183 @@
+package mockserver
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// ollamaAPI returns a copy of ts whose URL is the server root, where the
+// Ollama endpoints live.
+func ollamaAPI(ts *TestServer) *TestServer {
+	api := *ts
+	api.URL = strings.TrimSuffix(ts.URL, "/v1")
+	return &api
+}
+
+// ndjsonLines decodes a newline-delimited JSON stream.
+func ndjsonLines[T any](t *testing.T, data []byte) []T {
+	t.Helper()
+	var lines []T
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, decode[T](t, scanner.Bytes()))
+	}
+	return lines
+}
+
+func TestOllamaErrors(t *testing.T) {
+	api := ollamaAPI(Start(t))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"TagsWrongMethod", "POST", "/api/tags", "{}", 405},
+		{"ChatWrongMethod", "GET", "/api/chat", "", 405},
+		{"ChatNoModel", "POST", "/api/chat", `{"messages":[]}`, 400},
+		{"ChatInvalidJSON", "POST", "/api/chat", `{`, 400},
+		{"GenerateNoModel", "POST", "/api/generate", `{"prompt":"hi"}`, 400},
+		{"EmbeddingsNoModel", "POST", "/api/embeddings", `{"prompt":"hi"}`, 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := call(t, api, tt.method, tt.path, tt.body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			// Ollama errors are {"error": "..."}, not OpenAI error objects
+			if got := decode[map[string]string](t, body); got["error"] == "" {
+				t.Errorf("body = %s, want an Ollama error", body)
+			}
+		})
+	}
+}
+
+func TestOllamaTags(t *testing.T) {
+	api := ollamaAPI(Start(t))
+
+	_, body := call(t, api, "GET", "/api/tags", "")
+	tags := decode[OllamaTagsResponse](t, body)
+	if len(tags.Models) != len(mockModels) {
+		t.Fatalf("got %d models, want %d", len(tags.Models), len(mockModels))
+	}
+	for _, m := range tags.Models {
+		if !strings.HasSuffix(m.Name, ":latest") || m.Digest == "" || m.Details.Format != "gguf" {
+			t.Errorf("model = %+v", m)
+		}
+	}
+}
+
+func TestOllamaChat(t *testing.T) {
+	api := ollamaAPI(Start(t))
+	messages := `"messages":[{"role":"user","content":"Say hello in 5 words"}]`
+
+	_, body := call(t, api, "POST", "/api/chat", `{"model":"llama3",`+messages+`,"stream":false}`)
+	final := decode[OllamaChatResponse](t, body)
+	if !final.Done || final.DoneReason != "stop" || final.Message.Role != "assistant" || final.Message.Content == "" {
+		t.Errorf("response = %+v", final)
+	}
+	if final.EvalCount == 0 || final.TotalDuration == 0 {
+		t.Errorf("stats = %+v, want counts and durations", final.OllamaStats)
+	}
+
+	// Streaming is the default
+	resp, body := call(t, api, "POST", "/api/chat", `{"model":"llama3",`+messages+`}`)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q, want application/x-ndjson", ct)
+	}
+	lines := ndjsonLines[OllamaChatResponse](t, body)
+	if len(lines) < 2 {
+		t.Fatalf("got %d lines, want content lines and a final line", len(lines))
+	}
+	var content strings.Builder
+	for i, line := range lines {
+		if last := i == len(lines)-1; line.Done != last {
+			t.Errorf("line %d: done = %v", i, line.Done)
+		}
+		content.WriteString(line.Message.Content)
+	}
+	if content.String() != final.Message.Content {
+		t.Errorf("streamed %q, want %q", content.String(), final.Message.Content)
+	}
+}
+
+// toolGenerator always calls the first tool.
+type toolGenerator struct{ DefaultGenerator }
+
+func (toolGenerator) Chat(req *ChatCompletionRequest) ChatResult {
+	return ChatResult{ToolCalls: []ToolCall{{
+		ID:       "call_1",
+		Type:     "function",
+		Function: FunctionCall{Name: req.Tools[0].Function.Name, Arguments: `{"location":"Paris"}`},
+	}}}
+}
+
+func TestOllamaChatTools(t *testing.T) {
+	api := ollamaAPI(StartWithConfig(t, Config{Generator: toolGenerator{}}))
+	request := `{"model":"llama3","messages":[{"role":"user","content":"Weather?"}],"tools":[{"type":"function","function":{"name":"get_weather"}}]`
+
+	tests := []struct {
+		name   string
+		stream bool
+	}{
+		{"NonStreaming", false},
+		{"Streaming", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := request + `,"stream":false}`
+			if tt.stream {
+				body = request + `}`
+			}
+			_, data := call(t, api, "POST", "/api/chat", body)
+
+			var calls []OllamaToolCall
+			for _, line := range ndjsonLines[OllamaChatResponse](t, data) {
+				calls = append(calls, line.Message.ToolCalls...)
+			}
+			if len(calls) != 1 || calls[0].Function.Name != "get_weather" {
+				t.Fatalf("tool calls = %+v, want one call to get_weather", calls)
+			}
+			// Arguments are an object, not an encoded string
+			if got := calls[0].Function.Arguments["location"]; got != "Paris" {
+				t.Errorf("arguments = %v, want location Paris", calls[0].Function.Arguments)
+			}
+		})
+	}
+}
+
+func TestOllamaGenerate(t *testing.T) {
+	api := ollamaAPI(Start(t))
+
+	_, body := call(t, api, "POST", "/api/generate", `{"model":"llama3","system":"Be brief.","prompt":"Say hello in 5 words","stream":false}`)
+	final := decode[OllamaGenerateResponse](t, body)
+	if !final.Done || final.Response == "" || len(final.Context) == 0 {
+		t.Errorf("response = %+v", final)
+	}
+
+	_, body = call(t, api, "POST", "/api/generate", `{"model":"llama3","prompt":"Say hello in 5 words"}`)
+	lines := ndjsonLines[OllamaGenerateResponse](t, body)
+	var response strings.Builder
+	for _, line := range lines {
+		response.WriteString(line.Response)
+	}
+	if !lines[len(lines)-1].Done || response.String() != final.Response {
+		t.Errorf("streamed %q (done %v), want %q", response.String(), lines[len(lines)-1].Done, final.Response)
+	}
+}
+
+func TestOllamaEmbeddings(t *testing.T) {
+	api := ollamaAPI(Start(t))
+
+	_, body := call(t, api, "POST", "/api/embeddings", `{"model":"text-embedding-3-large","prompt":"Hello"}`)
+	resp := decode[OllamaEmbeddingsResponse](t, body)
+	if len(resp.Embedding) != 3072 {
+		t.Errorf("got %d dimensions, want 3072", len(resp.Embedding))
+	}
+}
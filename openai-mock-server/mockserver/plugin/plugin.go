@@ -0,0 +1,47 @@
+// Package plugin loads Go plugins that extend a mock server. It is kept out of
+// package mockserver so that programs embedding the mock with Start or New do
+// not link the standard library's plugin package and its cgo dependency.
+package plugin
+
+import (
+	"fmt"
+	goplugin "plugin"
+
+	"openai-mock-server/mockserver"
+)
+
+// ============================================================================
+// Plugins
+// ============================================================================
+
+// RegisterFunc is the signature of the Register symbol a plugin must export.
+// It is called once when the plugin is loaded and typically installs
+// middleware with Use or a generator with SetGenerator.
+type RegisterFunc = func(*mockserver.Server) error
+
+// Load opens a Go plugin (built with go build -buildmode=plugin) and calls its
+// exported Register function with s. Plugins must be built with the same Go
+// toolchain, build flags and version of package mockserver as the server.
+// Like Use and SetGenerator, which Register calls, Load must be called before
+// s starts handling requests.
+func Load(s *mockserver.Server, path string) error {
+	p, err := goplugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("open plugin %s: %w", path, err)
+	}
+
+	sym, err := p.Lookup("Register")
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", path, err)
+	}
+
+	register, ok := sym.(RegisterFunc)
+	if !ok {
+		return fmt.Errorf("plugin %s: Register has type %T, want func(*mockserver.Server) error", path, sym)
+	}
+
+	if err := register(s); err != nil {
+		return fmt.Errorf("plugin %s: register: %w", path, err)
+	}
+	return nil
+}
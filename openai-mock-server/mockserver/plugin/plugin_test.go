@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"openai-mock-server/mockserver"
+)
+
+// buildFlags are passed to go build for the example plugin; they must match
+// the flags the test binary was built with.
+var buildFlags []string
+
+// buildExample builds examples/plugin into a temporary directory, skipping the
+// test where plugins cannot be built.
+func buildExample(t *testing.T) string {
+	t.Helper()
+	switch runtime.GOOS {
+	case "linux", "freebsd", "darwin":
+	default:
+		t.Skipf("Go plugins are not supported on %s", runtime.GOOS)
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	if out, err := exec.Command(goTool, "env", "CGO_ENABLED").Output(); err != nil || strings.TrimSpace(string(out)) != "1" {
+		t.Skip("Go plugins require cgo")
+	}
+
+	path := filepath.Join(t.TempDir(), "example-plugin.so")
+	args := append([]string{"build", "-buildmode=plugin"}, buildFlags...)
+	cmd := exec.Command(goTool, append(args, "-o", path, "./examples/plugin")...)
+	cmd.Dir = filepath.Join("..", "..")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build example plugin: %v\n%s", err, out)
+	}
+	return path
+}
+
+func TestLoadExample(t *testing.T) {
+	path := buildExample(t)
+
+	// The plugin is loaded before the server handles requests, as Load
+	// requires; the server is plain HTTP, which needs no client certificate
+	s := mockserver.New(mockserver.Config{})
+	if err := Load(s, path); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	resp, err := ts.Client().Post(ts.URL+"/v1/chat/completions", "application/json",
+		strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if got := resp.Header.Get("X-Mock-Plugin"); got != "example" {
+		t.Errorf("X-Mock-Plugin = %q, want the header set by the plugin's middleware", got)
+	}
+	var chat mockserver.ChatCompletionResponse
+	if err := json.Unmarshal(body, &chat); err != nil {
+		t.Fatalf("decode %s: %v", body, err)
+	}
+	if got := chat.Choices[0].Message.Content.GetText(); got != "Hello from the example plugin." {
+		t.Errorf("content = %q, want the plugin generator's reply", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	s := mockserver.New(mockserver.Config{})
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"Missing", filepath.Join(t.TempDir(), "missing.so")},
+		{"NotAPlugin", "plugin.go"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Load(s, tt.path)
+			if err == nil || !strings.Contains(err.Error(), tt.path) {
+				t.Errorf("Load(%q) = %v, want an error naming the path", tt.path, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package plugin
+
+func init() {
+	buildFlags = append(buildFlags, "-race")
+}
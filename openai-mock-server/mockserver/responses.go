@@ -0,0 +1,304 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// Responses Types
+// ============================================================================
+
+// ResponsesTool is a tool in the Responses API format, where function tools
+// are flat rather than nested under "function".
+type ResponsesTool struct {
+	Type              string                 `json:"type"`
+	Name              string                 `json:"name,omitempty"`
+	Description       string                 `json:"description,omitempty"`
+	Parameters        map[string]interface{} `json:"parameters,omitempty"`
+	Strict            *bool                  `json:"strict,omitempty"`
+	SearchContextSize string                 `json:"search_context_size,omitempty"`
+	UserLocation      any                    `json:"user_location,omitempty"`
+	VectorStoreIDs    []string               `json:"vector_store_ids,omitempty"`
+	MaxNumResults     *int                   `json:"max_num_results,omitempty"`
+}
+
+// ResponsesInputItem is one message of an array input. Content is either a
+// string or a list of input_text parts.
+type ResponsesInputItem struct {
+	Type    string          `json:"type,omitempty"`
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type ResponsesRequest struct {
+	Model        string            `json:"model"`
+	Input        json.RawMessage   `json:"input"`
+	Instructions string            `json:"instructions,omitempty"`
+	Tools        []ResponsesTool   `json:"tools,omitempty"`
+	ToolChoice   any               `json:"tool_choice,omitempty"`
+	Stream       bool              `json:"stream,omitempty"`
+	Temperature  *float64          `json:"temperature,omitempty"`
+	TopP         *float64          `json:"top_p,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	User         string            `json:"user,omitempty"`
+}
+
+// ResponsesAnnotation is a citation in the Responses API format, which is
+// flat rather than nested by type.
+type ResponsesAnnotation struct {
+	Type       string `json:"type"`
+	StartIndex *int   `json:"start_index,omitempty"`
+	EndIndex   *int   `json:"end_index,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Title      string `json:"title,omitempty"`
+	Index      *int   `json:"index,omitempty"`
+	FileID     string `json:"file_id,omitempty"`
+	Filename   string `json:"filename,omitempty"`
+}
+
+type ResponsesContent struct {
+	Type        string                `json:"type"`
+	Text        string                `json:"text"`
+	Annotations []ResponsesAnnotation `json:"annotations"`
+}
+
+// ResponsesOutputItem is one entry of a response's output. Which fields are
+// set depends on Type: message, function_call, web_search_call or
+// file_search_call.
+type ResponsesOutputItem struct {
+	Type      string             `json:"type"`
+	ID        string             `json:"id"`
+	Status    string             `json:"status"`
+	Role      string             `json:"role,omitempty"`
+	Content   []ResponsesContent `json:"content,omitempty"`
+	CallID    string             `json:"call_id,omitempty"`
+	Name      string             `json:"name,omitempty"`
+	Arguments string             `json:"arguments,omitempty"`
+	Queries   []string           `json:"queries,omitempty"`
+}
+
+type ResponsesUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+type ResponsesResponse struct {
+	ID           string                `json:"id"`
+	Object       string                `json:"object"`
+	CreatedAt    int64                 `json:"created_at"`
+	Status       string                `json:"status"`
+	Model        string                `json:"model"`
+	Instructions *string               `json:"instructions"`
+	Output       []ResponsesOutputItem `json:"output"`
+	Tools        []ResponsesTool       `json:"tools"`
+	Metadata     map[string]string     `json:"metadata"`
+	Usage        ResponsesUsage        `json:"usage"`
+}
+
+// ============================================================================
+// Responses Handler
+// ============================================================================
+
+// responsesHandler implements a non-streaming subset of POST /v1/responses:
+// text and message-array input, function tools via the ResponseGenerator, and
+// simulated web_search_preview and file_search calls with citations.
+func (s *Server) responsesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error", nil, nil)
+		return
+	}
+
+	var req ResponsesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		param := "body"
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err), "invalid_request_error", &param, nil)
+		return
+	}
+	if req.Model == "" {
+		param := "model"
+		sendError(w, http.StatusBadRequest, "Missing required parameter: 'model'", "invalid_request_error", &param, nil)
+		return
+	}
+	if req.Stream {
+		param := "stream"
+		sendError(w, http.StatusBadRequest, "Streaming is not supported by the mock Responses API", "invalid_request_error", &param, nil)
+		return
+	}
+
+	for i, tool := range req.Tools {
+		// Function tools in the Responses API are strict unless disabled
+		if tool.Type != "function" || (tool.Strict != nil && !*tool.Strict) {
+			continue
+		}
+		if err := validateStrictSchema(tool.Name, tool.Parameters); err != nil {
+			param := fmt.Sprintf("tools[%d].parameters", i)
+			code := "invalid_function_parameters"
+			sendError(w, http.StatusBadRequest, err.Error(), "invalid_request_error", &param, &code)
+			return
+		}
+	}
+
+	messages, err := responsesMessages(req.Instructions, req.Input)
+	if err != nil {
+		param := "input"
+		sendError(w, http.StatusBadRequest, err.Error(), "invalid_request_error", &param, nil)
+		return
+	}
+
+	chatReq := ChatCompletionRequest{Model: req.Model, Messages: messages, ToolChoice: req.ToolChoice, User: req.User}
+	for _, t := range req.Tools {
+		tool := Tool{Type: t.Type, SearchContextSize: t.SearchContextSize, UserLocation: t.UserLocation, VectorStoreIDs: t.VectorStoreIDs, MaxNumResults: t.MaxNumResults}
+		tool.Function = FunctionDefinition{Name: t.Name, Description: t.Description, Parameters: t.Parameters, Strict: t.Strict}
+		chatReq.Tools = append(chatReq.Tools, tool)
+	}
+
+	result := s.generator().Chat(&chatReq)
+	query := lastUserText(messages)
+
+	var output []ResponsesOutputItem
+	outputTokens := 0
+	if len(result.ToolCalls) > 0 {
+		for _, call := range result.ToolCalls {
+			output = append(output, ResponsesOutputItem{
+				Type:      "function_call",
+				ID:        "fc_" + shortID(),
+				Status:    "completed",
+				CallID:    call.ID,
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			})
+			outputTokens += estimateTokens(call.Function.Arguments)
+		}
+	} else {
+		webSearch, fileSearch := builtinTools(chatReq.Tools)
+		if webSearch != nil {
+			output = append(output, ResponsesOutputItem{Type: "web_search_call", ID: "ws_" + shortID(), Status: "completed"})
+		}
+		if fileSearch != nil {
+			output = append(output, ResponsesOutputItem{Type: "file_search_call", ID: "fs_" + shortID(), Status: "completed", Queries: []string{query}})
+		}
+
+		text, citations := applyBuiltinTools(result.Content, query, webSearch, fileSearch)
+		output = append(output, ResponsesOutputItem{
+			Type:   "message",
+			ID:     "msg_" + shortID(),
+			Status: "completed",
+			Role:   "assistant",
+			Content: []ResponsesContent{{
+				Type:        "output_text",
+				Text:        text,
+				Annotations: responsesAnnotations(citations),
+			}},
+		})
+		outputTokens += estimateTokens(text)
+	}
+
+	inputTokens := 0
+	for _, msg := range messages {
+		inputTokens += estimateTokens(msg.Content.GetText())
+	}
+
+	response := ResponsesResponse{
+		ID:        "resp_" + shortID(),
+		Object:    "response",
+		CreatedAt: time.Now().Unix(),
+		Status:    "completed",
+		Model:     req.Model,
+		Output:    output,
+		Tools:     req.Tools,
+		Metadata:  req.Metadata,
+		Usage: ResponsesUsage{
+			InputTokens:  inputTokens,
+			OutputTokens: outputTokens,
+			TotalTokens:  inputTokens + outputTokens,
+		},
+	}
+	if req.Instructions != "" {
+		response.Instructions = &req.Instructions
+	}
+	if response.Tools == nil {
+		response.Tools = []ResponsesTool{}
+	}
+	if response.Metadata == nil {
+		response.Metadata = map[string]string{}
+	}
+	s.store.recordUsage(req.User, Usage{PromptTokens: inputTokens, CompletionTokens: outputTokens, TotalTokens: inputTokens + outputTokens})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// responsesMessages converts Responses API input (a string or an array of
+// messages) into chat messages, with instructions as a leading system
+// message.
+func responsesMessages(instructions string, input json.RawMessage) ([]ChatMessage, error) {
+	var messages []ChatMessage
+	if instructions != "" {
+		messages = append(messages, ChatMessage{Role: "system", Content: MessageContent{Text: instructions}})
+	}
+
+	var text string
+	if err := json.Unmarshal(input, &text); err == nil {
+		return append(messages, ChatMessage{Role: "user", Content: MessageContent{Text: text}}), nil
+	}
+
+	var items []ResponsesInputItem
+	if err := json.Unmarshal(input, &items); err != nil {
+		return nil, fmt.Errorf("input must be a string or an array of input items")
+	}
+	for _, item := range items {
+		if item.Role == "" {
+			// Non-message items such as function_call_output carry no text
+			continue
+		}
+		var content MessageContent
+		if err := json.Unmarshal(item.Content, &content); err != nil {
+			var parts []ResponsesContent
+			if err := json.Unmarshal(item.Content, &parts); err != nil {
+				return nil, fmt.Errorf("invalid content for %s message", item.Role)
+			}
+			for _, part := range parts {
+				content.Parts = append(content.Parts, ContentPart{Type: "text", Text: part.Text})
+			}
+		}
+		messages = append(messages, ChatMessage{Role: item.Role, Content: content})
+	}
+	return messages, nil
+}
+
+// responsesAnnotations converts citations to the Responses API format.
+func responsesAnnotations(citations []citation) []ResponsesAnnotation {
+	annotations := []ResponsesAnnotation{}
+	for _, c := range citations {
+		c := c
+		switch c.kind {
+		case "url_citation":
+			annotations = append(annotations, ResponsesAnnotation{
+				Type:       c.kind,
+				StartIndex: &c.start,
+				EndIndex:   &c.end,
+				URL:        c.url,
+				Title:      c.title,
+			})
+		case "file_citation":
+			annotations = append(annotations, ResponsesAnnotation{
+				Type:     c.kind,
+				Index:    &c.start,
+				FileID:   c.fileID,
+				Filename: c.filename,
+			})
+		}
+	}
+	return annotations
+}
+
+func shortID() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")[:24]
+}
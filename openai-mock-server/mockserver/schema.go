@@ -0,0 +1,280 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// Strict Function Schemas
+// ============================================================================
+
+// maxSchemaDepth is the deepest object nesting allowed in a strict schema.
+const maxSchemaDepth = 10
+
+// unsupportedStrictKeywords lists JSON Schema keywords that the real API
+// rejects when a function sets strict: true.
+var unsupportedStrictKeywords = map[string]bool{
+	"minLength":             true,
+	"maxLength":             true,
+	"patternProperties":     true,
+	"unevaluatedProperties": true,
+	"propertyNames":         true,
+	"minProperties":         true,
+	"maxProperties":         true,
+	"unevaluatedItems":      true,
+	"contains":              true,
+	"minContains":           true,
+	"maxContains":           true,
+	"uniqueItems":           true,
+	"allOf":                 true,
+	"not":                   true,
+	"if":                    true,
+	"then":                  true,
+	"else":                  true,
+	"dependentRequired":     true,
+	"dependentSchemas":      true,
+	"oneOf":                 true,
+}
+
+// validateStrictSchema checks the parameters of a strict function against the
+// subset of JSON Schema supported by structured outputs, returning an error
+// worded like the real API's for the first violation found.
+func validateStrictSchema(name string, schema map[string]any) error {
+	if schemaType(schema) != "object" {
+		got := "None"
+		if t, ok := schema["type"]; ok {
+			got = fmt.Sprintf("%v", t)
+		}
+		return fmt.Errorf("Invalid schema for function '%s': schema must be a JSON Schema of 'type: \"object\"', got 'type: \"%s\"'.", name, got)
+	}
+	if _, ok := schema["anyOf"]; ok {
+		return fmt.Errorf("Invalid schema for function '%s': In context=(), 'anyOf' is not permitted at the root of the schema.", name)
+	}
+	if msg := checkStrictNode(schema, nil, 0); msg != "" {
+		return fmt.Errorf("Invalid schema for function '%s': %s", name, msg)
+	}
+	return nil
+}
+
+func checkStrictNode(node map[string]any, path []string, depth int) string {
+	context := formatSchemaContext(path)
+
+	if depth > maxSchemaDepth {
+		return fmt.Sprintf("In context=%s, schema exceeds the maximum nesting depth of %d.", context, maxSchemaDepth)
+	}
+
+	keys := make([]string, 0, len(node))
+	for key := range node {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if unsupportedStrictKeywords[key] {
+			return fmt.Sprintf("In context=%s, '%s' is not permitted.", context, key)
+		}
+	}
+
+	if _, isRef := node["$ref"]; isRef {
+		return ""
+	}
+
+	if schemaType(node) == "object" {
+		if additional, ok := node["additionalProperties"].(bool); !ok || additional {
+			return fmt.Sprintf("In context=%s, 'additionalProperties' is required to be supplied and to be false.", context)
+		}
+
+		properties, _ := node["properties"].(map[string]any)
+		required := make(map[string]bool)
+		if list, ok := node["required"].([]any); ok {
+			for _, item := range list {
+				if s, ok := item.(string); ok {
+					required[s] = true
+				}
+			}
+		}
+
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !required[name] {
+				return fmt.Sprintf("In context=%s, 'required' is required to be supplied and to be an array including every key in properties. Missing '%s'.", context, name)
+			}
+		}
+		for _, name := range names {
+			child, ok := properties[name].(map[string]any)
+			if !ok {
+				continue
+			}
+			if msg := checkStrictNode(child, append(path, "properties", name), depth+1); msg != "" {
+				return msg
+			}
+		}
+	}
+
+	if items, ok := node["items"].(map[string]any); ok {
+		if msg := checkStrictNode(items, append(path, "items"), depth+1); msg != "" {
+			return msg
+		}
+	}
+
+	if variants, ok := node["anyOf"].([]any); ok {
+		for i, variant := range variants {
+			child, ok := variant.(map[string]any)
+			if !ok {
+				continue
+			}
+			if msg := checkStrictNode(child, append(path, "anyOf", fmt.Sprint(i)), depth); msg != "" {
+				return msg
+			}
+		}
+	}
+
+	for _, defsKey := range []string{"$defs", "definitions"} {
+		defs, _ := node[defsKey].(map[string]any)
+		names := make([]string, 0, len(defs))
+		for name := range defs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child, ok := defs[name].(map[string]any)
+			if !ok {
+				continue
+			}
+			if msg := checkStrictNode(child, append(path, defsKey, name), depth); msg != "" {
+				return msg
+			}
+		}
+	}
+
+	return ""
+}
+
+// formatSchemaContext renders a schema path the way the real API does, as a
+// Python tuple: ('properties', 'location').
+func formatSchemaContext(path []string) string {
+	quoted := make([]string, len(path))
+	for i, p := range path {
+		quoted[i] = "'" + p + "'"
+	}
+	if len(quoted) == 1 {
+		return "(" + quoted[0] + ",)"
+	}
+	return "(" + strings.Join(quoted, ", ") + ")"
+}
+
+// schemaType returns the non-null type of a schema node, inferring "object"
+// from the presence of properties.
+func schemaType(node map[string]any) string {
+	switch t := node["type"].(type) {
+	case string:
+		return t
+	case []any:
+		for _, item := range t {
+			if s, ok := item.(string); ok && s != "null" {
+				return s
+			}
+		}
+		return "null"
+	}
+	if _, ok := node["properties"]; ok {
+		return "object"
+	}
+	return ""
+}
+
+// ============================================================================
+// Schema-Conformant Arguments
+// ============================================================================
+
+// exampleArguments returns JSON arguments that conform to a function's
+// parameter schema, including every declared property.
+func exampleArguments(schema map[string]any) string {
+	if schema == nil {
+		return "{}"
+	}
+	defs, _ := schema["$defs"].(map[string]any)
+	if defs == nil {
+		defs, _ = schema["definitions"].(map[string]any)
+	}
+	data, err := json.Marshal(exampleValue(schema, defs, 0))
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+func exampleValue(node map[string]any, defs map[string]any, depth int) any {
+	if depth > maxSchemaDepth {
+		return nil
+	}
+
+	if ref, ok := node["$ref"].(string); ok {
+		name := ref[strings.LastIndex(ref, "/")+1:]
+		if target, ok := defs[name].(map[string]any); ok {
+			return exampleValue(target, defs, depth+1)
+		}
+		return nil
+	}
+	if value, ok := node["const"]; ok {
+		return value
+	}
+	if values, ok := node["enum"].([]any); ok && len(values) > 0 {
+		return values[0]
+	}
+	if variants, ok := node["anyOf"].([]any); ok && len(variants) > 0 {
+		if first, ok := variants[0].(map[string]any); ok {
+			return exampleValue(first, defs, depth+1)
+		}
+	}
+
+	switch schemaType(node) {
+	case "object":
+		obj := make(map[string]any)
+		properties, _ := node["properties"].(map[string]any)
+		for name, prop := range properties {
+			if child, ok := prop.(map[string]any); ok {
+				obj[name] = exampleValue(child, defs, depth+1)
+			}
+		}
+		return obj
+	case "array":
+		items, _ := node["items"].(map[string]any)
+		if items == nil {
+			return []any{}
+		}
+		return []any{exampleValue(items, defs, depth+1)}
+	case "string":
+		switch node["format"] {
+		case "date-time":
+			return "2024-01-01T00:00:00Z"
+		case "date":
+			return "2024-01-01"
+		case "email":
+			return "user@example.com"
+		case "uuid":
+			return "00000000-0000-0000-0000-000000000000"
+		}
+		return "example"
+	case "integer":
+		if min, ok := node["minimum"].(float64); ok {
+			return int(min)
+		}
+		return 1
+	case "number":
+		if min, ok := node["minimum"].(float64); ok {
+			return min
+		}
+		return 1.0
+	case "boolean":
+		return true
+	default:
+		return nil
+	}
+}
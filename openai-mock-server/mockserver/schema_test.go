@@ -0,0 +1,172 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidateStrictSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{
+			name:   "Valid",
+			schema: `{"type":"object","properties":{"location":{"type":"string"},"unit":{"type":["string","null"],"enum":["c","f",null]}},"required":["location","unit"],"additionalProperties":false}`,
+		},
+		{
+			name:   "ValidNestedDefs",
+			schema: `{"type":"object","properties":{"item":{"$ref":"#/$defs/item"}},"required":["item"],"additionalProperties":false,"$defs":{"item":{"type":"object","properties":{"id":{"type":"integer"}},"required":["id"],"additionalProperties":false}}}`,
+		},
+		{
+			name:    "NotObject",
+			schema:  `{"type":"array"}`,
+			wantErr: `got 'type: "array"'`,
+		},
+		{
+			name:    "NoType",
+			schema:  `{}`,
+			wantErr: `got 'type: "None"'`,
+		},
+		{
+			name:    "RootAnyOf",
+			schema:  `{"type":"object","anyOf":[],"additionalProperties":false}`,
+			wantErr: "'anyOf' is not permitted at the root",
+		},
+		{
+			name:    "AdditionalPropertiesMissing",
+			schema:  `{"type":"object","properties":{}}`,
+			wantErr: "In context=(), 'additionalProperties' is required to be supplied and to be false.",
+		},
+		{
+			name:    "MissingRequired",
+			schema:  `{"type":"object","properties":{"a":{"type":"string"},"b":{"type":"string"}},"required":["a"],"additionalProperties":false}`,
+			wantErr: "Missing 'b'.",
+		},
+		{
+			name:    "UnsupportedKeyword",
+			schema:  `{"type":"object","properties":{"name":{"type":"string","minLength":1}},"required":["name"],"additionalProperties":false}`,
+			wantErr: "In context=('properties', 'name'), 'minLength' is not permitted.",
+		},
+		{
+			name:    "NestedObject",
+			schema:  `{"type":"object","properties":{"address":{"type":"object","properties":{}}},"required":["address"],"additionalProperties":false}`,
+			wantErr: "In context=('properties', 'address'), 'additionalProperties'",
+		},
+		{
+			name:    "ArrayItems",
+			schema:  `{"type":"object","properties":{"tags":{"type":"array","items":{"type":"string","uniqueItems":true}}},"required":["tags"],"additionalProperties":false}`,
+			wantErr: "In context=('properties', 'tags', 'items'), 'uniqueItems' is not permitted.",
+		},
+		{
+			name:    "Defs",
+			schema:  `{"type":"object","properties":{},"additionalProperties":false,"$defs":{"item":{"type":"object","properties":{"id":{"type":"integer"}},"additionalProperties":false}}}`,
+			wantErr: "In context=('$defs', 'item'), 'required'",
+		},
+		{
+			name:    "TooDeep",
+			schema:  nestedSchema(maxSchemaDepth + 2),
+			wantErr: "exceeds the maximum nesting depth of 10",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var schema map[string]any
+			if err := json.Unmarshal([]byte(tt.schema), &schema); err != nil {
+				t.Fatal(err)
+			}
+			err := validateStrictSchema("fn", schema)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("expected an error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("error = %q, want it to contain %q", err, tt.wantErr)
+			case err != nil && !strings.HasPrefix(err.Error(), "Invalid schema for function 'fn': "):
+				t.Errorf("error = %q does not name the function", err)
+			}
+		})
+	}
+}
+
+// nestedSchema returns a valid strict schema with objects nested depth deep.
+func nestedSchema(depth int) string {
+	schema := `{"type":"object","properties":{},"additionalProperties":false}`
+	for i := 0; i < depth; i++ {
+		schema = fmt.Sprintf(`{"type":"object","properties":{"child":%s},"required":["child"],"additionalProperties":false}`, schema)
+	}
+	return schema
+}
+
+func TestStrictSchemaEndpoints(t *testing.T) {
+	ts := Start(t)
+	invalid := `{"type":"object","properties":{"location":{"type":"string"}}}`
+	messages := `"model":"gpt-4o","messages":[{"role":"user","content":"Weather?"}]`
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+		wantParam  string
+	}{
+		{"ChatStrict", "/chat/completions", `{` + messages + `,"tools":[{"type":"function","function":{"name":"get_weather","strict":true,"parameters":` + invalid + `}}]}`, 400, "tools[0].function.parameters"},
+		{"ChatNotStrict", "/chat/completions", `{` + messages + `,"tools":[{"type":"function","function":{"name":"get_weather","parameters":` + invalid + `}}]}`, 200, ""},
+		{"ResponsesDefaultStrict", "/responses", `{"model":"gpt-4o","input":"Weather?","tools":[{"type":"function","name":"get_weather","parameters":` + invalid + `}]}`, 400, "tools[0].parameters"},
+		{"ResponsesNotStrict", "/responses", `{"model":"gpt-4o","input":"Weather?","tools":[{"type":"function","name":"get_weather","strict":false,"parameters":` + invalid + `}]}`, 200, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := call(t, ts, "POST", tt.path, tt.body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantParam == "" {
+				return
+			}
+			errResp := decode[ErrorResponse](t, body)
+			if errResp.Error.Param == nil || *errResp.Error.Param != tt.wantParam {
+				t.Errorf("param = %v, want %s", errResp.Error.Param, tt.wantParam)
+			}
+			if errResp.Error.Code == nil || *errResp.Error.Code != "invalid_function_parameters" {
+				t.Errorf("code = %v, want invalid_function_parameters", errResp.Error.Code)
+			}
+		})
+	}
+}
+
+func TestExampleArguments(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{"Nil", ``, `{}`},
+		{"Scalars", `{"type":"object","properties":{"s":{"type":"string"},"i":{"type":"integer","minimum":3},"n":{"type":"number"},"b":{"type":"boolean"}}}`, `{"b":true,"i":3,"n":1,"s":"example"}`},
+		{"Formats", `{"type":"object","properties":{"d":{"type":"string","format":"date"},"e":{"type":"string","format":"email"}}}`, `{"d":"2024-01-01","e":"user@example.com"}`},
+		{"EnumConst", `{"type":"object","properties":{"unit":{"enum":["c","f"]},"kind":{"const":"x"}}}`, `{"kind":"x","unit":"c"}`},
+		{"Array", `{"type":"object","properties":{"tags":{"type":"array","items":{"type":"string"}}}}`, `{"tags":["example"]}`},
+		{"Nullable", `{"type":"object","properties":{"v":{"type":["null","integer"]}}}`, `{"v":1}`},
+		{"Ref", `{"type":"object","properties":{"p":{"$ref":"#/$defs/point"}},"$defs":{"point":{"type":"object","properties":{"x":{"type":"number"}}}}}`, `{"p":{"x":1}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var schema map[string]any
+			if tt.schema != "" {
+				if err := json.Unmarshal([]byte(tt.schema), &schema); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := exampleArguments(schema); got != tt.want {
+				t.Errorf("exampleArguments = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package mockserver
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ============================================================================
+// Server
+// ============================================================================
+
+// Config holds the options that control the behaviour of a mock server.
+type Config struct {
+	// Verbose enables logging of request headers and bodies.
+	Verbose bool
+
+	// DebugEcho adds a "debug" object to chat responses that echoes
+	// vendor extension parameters (top_k, min_p, ...) from the request.
+	DebugEcho bool
+
+	// Generator produces chat replies and embeddings. If nil,
+	// DefaultGenerator is used.
+	Generator ResponseGenerator
+
+	// CORS controls the Access-Control-* response headers.
+	CORS CORSConfig
+
+	// MaxConcurrent bounds the number of in-flight requests; zero means
+	// unlimited. Requests over the limit are rejected with OverloadStatus.
+	MaxConcurrent int
+
+	// OverloadStatus is the status returned when MaxConcurrent is exceeded:
+	// http.StatusTooManyRequests (the default) or
+	// http.StatusServiceUnavailable.
+	OverloadStatus int
+}
+
+// Server is a mock OpenAI API. It implements http.Handler so it can be
+// mounted on any listener, with or without mTLS.
+type Server struct {
+	config     Config
+	middleware map[Stage][]Middleware
+	handler    http.Handler
+	beta       *betaStore
+	store      *completionStore
+}
+
+// New returns a mock server configured with config.
+func New(config Config) *Server {
+	s := &Server{
+		config:     config,
+		middleware: make(map[Stage][]Middleware),
+		beta:       newBetaStore(),
+		store:      newCompletionStore(),
+	}
+	s.buildHandler()
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// ============================================================================
+// Router
+// ============================================================================
+
+func (s *Server) logRequest(r *http.Request) {
+	if !s.config.Verbose {
+		return
+	}
+
+	log.Printf("[%s] %s", r.Method, r.URL.Path)
+
+	// Log custom headers (X-* headers)
+	for name, values := range r.Header {
+		if strings.HasPrefix(name, "X-") {
+			for _, v := range values {
+				log.Printf("  Header: %s: %s", name, v)
+			}
+		}
+	}
+
+	// Log Authorization header (masked)
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if len(auth) > 20 {
+			log.Printf("  Header: Authorization: %s...%s", auth[:10], auth[len(auth)-4:])
+		} else {
+			log.Printf("  Header: Authorization: %s", auth)
+		}
+	}
+}
+
+// route returns the endpoint handler for path together with the route
+// pattern, which is used as a bounded key for per-endpoint statistics.
+func (s *Server) route(path string) (string, http.Handler) {
+	switch {
+	case path == "/v1/models":
+		return "/v1/models", http.HandlerFunc(s.modelsHandler)
+	case strings.HasPrefix(path, "/v1/models/"):
+		return "/v1/models/{id}", http.HandlerFunc(s.modelByIDHandler)
+	case path == "/v1/chat/completions":
+		return "/v1/chat/completions", http.HandlerFunc(s.chatCompletionsHandler)
+	case strings.HasPrefix(path, "/v1/chat/completions/"):
+		return "/v1/chat/completions/{id}", http.HandlerFunc(s.storedCompletionHandler)
+	case path == "/v1/embeddings":
+		return "/v1/embeddings", http.HandlerFunc(s.embeddingsHandler)
+	case path == "/v1/responses":
+		return "/v1/responses", http.HandlerFunc(s.responsesHandler)
+	case path == "/v1/assistants":
+		return "/v1/assistants", requireBeta("assistants", "v2", assistantsAPI, s.assistantsHandler)
+	case strings.HasPrefix(path, "/v1/assistants/"):
+		return "/v1/assistants/{id}", requireBeta("assistants", "v2", assistantsAPI, s.assistantByIDHandler)
+	case path == "/v1/threads":
+		return "/v1/threads", requireBeta("assistants", "v2", assistantsAPI, s.threadsHandler)
+	case strings.HasPrefix(path, "/v1/threads/"):
+		return "/v1/threads/{id}", requireBeta("assistants", "v2", assistantsAPI, s.threadByIDHandler)
+	case path == "/api/tags":
+		return "/api/tags", http.HandlerFunc(s.ollamaTagsHandler)
+	case path == "/api/chat":
+		return "/api/chat", http.HandlerFunc(s.ollamaChatHandler)
+	case path == "/api/generate":
+		return "/api/generate", http.HandlerFunc(s.ollamaGenerateHandler)
+	case path == "/api/embeddings":
+		return "/api/embeddings", http.HandlerFunc(s.ollamaEmbeddingsHandler)
+	default:
+		return "unknown", http.HandlerFunc(notFoundHandler)
+	}
+}
+
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	code := "unknown_url"
+	sendError(w, http.StatusNotFound, fmt.Sprintf("Unknown request URL: %s", r.URL.Path), "invalid_request_error", nil, &code)
+}
+
+// SetGenerator replaces the server's ResponseGenerator. Like Use, it must be
+// called before the server starts handling requests.
+func (s *Server) SetGenerator(generator ResponseGenerator) {
+	s.config.Generator = generator
+}
@@ -0,0 +1,304 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// Stored Completions
+// ============================================================================
+
+// StoredCompletion is a chat completion saved because its request set
+// store: true, along with the attribution fields of that request.
+type StoredCompletion struct {
+	ID       string            `json:"id"`
+	Model    string            `json:"model"`
+	Created  int64             `json:"created"`
+	User     string            `json:"user,omitempty"`
+	Client   string            `json:"client,omitempty"`
+	Metadata map[string]string `json:"metadata"`
+	Usage    Usage             `json:"usage"`
+
+	response ChatCompletionResponse
+	messages []ChatMessage
+}
+
+// UserStats aggregates the requests made on behalf of one end user, as named
+// by the request's user field.
+type UserStats struct {
+	Requests         int   `json:"requests"`
+	PromptTokens     int   `json:"prompt_tokens"`
+	CompletionTokens int   `json:"completion_tokens"`
+	TotalTokens      int   `json:"total_tokens"`
+	LastSeen         int64 `json:"last_seen"`
+}
+
+// AdminStats is served by the admin listener at /admin/stats.
+type AdminStats struct {
+	Users             map[string]UserStats      `json:"users"`
+	Metadata          map[string]map[string]int `json:"metadata"`
+	StoredCompletions int                       `json:"stored_completions"`
+}
+
+// anonymousUser is the key used in stats for requests without a user field.
+const anonymousUser = "(none)"
+
+// completionStore keeps stored completions and per-user usage in memory for
+// the lifetime of the server.
+type completionStore struct {
+	mu          sync.Mutex
+	completions map[string]*StoredCompletion
+	order       []string
+	users       map[string]UserStats
+	metadata    map[string]map[string]int
+}
+
+func newCompletionStore() *completionStore {
+	return &completionStore{
+		completions: make(map[string]*StoredCompletion),
+		users:       make(map[string]UserStats),
+		metadata:    make(map[string]map[string]int),
+	}
+}
+
+// recordUsage attributes a request's token usage to user.
+func (c *completionStore) recordUsage(user string, usage Usage) {
+	if user == "" {
+		user = anonymousUser
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.users[user]
+	stats.Requests++
+	stats.PromptTokens += usage.PromptTokens
+	stats.CompletionTokens += usage.CompletionTokens
+	stats.TotalTokens += usage.TotalTokens
+	stats.LastSeen = time.Now().Unix()
+	c.users[user] = stats
+}
+
+func (c *completionStore) save(completion *StoredCompletion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.completions[completion.ID] = completion
+	c.order = append(c.order, completion.ID)
+	for key, value := range completion.Metadata {
+		if c.metadata[key] == nil {
+			c.metadata[key] = make(map[string]int)
+		}
+		c.metadata[key][value]++
+	}
+}
+
+func (c *completionStore) get(id string) (*StoredCompletion, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	completion, ok := c.completions[id]
+	return completion, ok
+}
+
+func (c *completionStore) delete(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	completion, ok := c.completions[id]
+	if !ok {
+		return false
+	}
+	delete(c.completions, id)
+	for key, value := range completion.Metadata {
+		c.metadata[key][value]--
+		if c.metadata[key][value] <= 0 {
+			delete(c.metadata[key], value)
+		}
+		if len(c.metadata[key]) == 0 {
+			delete(c.metadata, key)
+		}
+	}
+	for i, existing := range c.order {
+		if existing == id {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// list returns stored completions matching model (if set) and every
+// metadata pair in filter, newest first unless ascending is set.
+func (c *completionStore) list(model string, filter map[string]string, ascending bool) []*StoredCompletion {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var matches []*StoredCompletion
+	for _, id := range c.order {
+		completion := c.completions[id]
+		if model != "" && completion.Model != model {
+			continue
+		}
+		matched := true
+		for key, value := range filter {
+			if completion.Metadata[key] != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, completion)
+		}
+	}
+
+	if !ascending {
+		for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+			matches[i], matches[j] = matches[j], matches[i]
+		}
+	}
+	return matches
+}
+
+func (c *completionStore) stats() AdminStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := AdminStats{
+		Users:             make(map[string]UserStats, len(c.users)),
+		Metadata:          make(map[string]map[string]int, len(c.metadata)),
+		StoredCompletions: len(c.completions),
+	}
+	for user, s := range c.users {
+		stats.Users[user] = s
+	}
+	for key, values := range c.metadata {
+		stats.Metadata[key] = make(map[string]int, len(values))
+		for value, count := range values {
+			stats.Metadata[key][value] = count
+		}
+	}
+	return stats
+}
+
+// validateMetadata applies the real API's limits: at most 16 pairs, keys up
+// to 64 characters and values up to 512 characters.
+func validateMetadata(metadata map[string]string) error {
+	if len(metadata) > 16 {
+		return fmt.Errorf("Invalid 'metadata': too many properties. Expected an object with at most 16 properties, but got an object with %d properties instead.", len(metadata))
+	}
+	for key, value := range metadata {
+		if len(key) > 64 {
+			return fmt.Errorf("Invalid 'metadata': string too long. Expected a string with maximum length 64, but got a key with length %d instead.", len(key))
+		}
+		if len(value) > 512 {
+			return fmt.Errorf("Invalid 'metadata.%s': string too long. Expected a string with maximum length 512, but got a string with length %d instead.", key, len(value))
+		}
+	}
+	return nil
+}
+
+// ============================================================================
+// Stored Completion Handlers
+// ============================================================================
+
+// listStoredCompletionsHandler serves GET /v1/chat/completions, supporting
+// the model, metadata[key], limit and order query parameters.
+func (s *Server) listStoredCompletionsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := make(map[string]string)
+	for name, values := range query {
+		if strings.HasPrefix(name, "metadata[") && strings.HasSuffix(name, "]") && len(values) > 0 {
+			filter[name[len("metadata["):len(name)-1]] = values[0]
+		}
+	}
+
+	limit := 20
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			param := "limit"
+			sendError(w, http.StatusBadRequest, "Invalid 'limit': expected an integer between 1 and 100.", "invalid_request_error", &param, nil)
+			return
+		}
+		limit = n
+	}
+
+	matches := s.store.list(query.Get("model"), filter, query.Get("order") == "asc")
+	response := ListResponse[ChatCompletionResponse]{Object: "list", Data: []ChatCompletionResponse{}}
+	if len(matches) > limit {
+		matches = matches[:limit]
+		response.HasMore = true
+	}
+	for _, completion := range matches {
+		response.Data = append(response.Data, completion.response)
+	}
+	if len(response.Data) > 0 {
+		response.FirstID = &response.Data[0].ID
+		response.LastID = &response.Data[len(response.Data)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// storedCompletionHandler serves GET and DELETE /v1/chat/completions/{id}
+// and GET /v1/chat/completions/{id}/messages.
+func (s *Server) storedCompletionHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/chat/completions/"), "/")
+	id, sub, _ := strings.Cut(rest, "/")
+
+	completion, ok := s.store.get(id)
+	if !ok {
+		sendError(w, http.StatusNotFound, fmt.Sprintf("Chat completion with id '%s' not found.", id), "invalid_request_error", nil, nil)
+		return
+	}
+
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(completion.response)
+	case sub == "" && r.Method == http.MethodDelete:
+		s.store.delete(id)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(DeletionStatus{ID: id, Object: "chat.completion.deleted", Deleted: true})
+	case sub == "messages" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ListResponse[ChatMessage]{Object: "list", Data: completion.messages})
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error", nil, nil)
+	}
+}
+
+// ============================================================================
+// Admin Handlers
+// ============================================================================
+
+// adminStatsHandler serves per-user usage and metadata counts.
+func (s *Server) adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.store.stats())
+}
+
+// adminCompletionsHandler lists stored completions with their user, client
+// identity and metadata, optionally filtered by ?user=.
+func (s *Server) adminCompletionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.URL.Query().Get("user")
+
+	completions := []StoredCompletion{}
+	for _, completion := range s.store.list("", nil, false) {
+		if user != "" && completion.User != user {
+			continue
+		}
+		completions = append(completions, *completion)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": completions})
+}
@@ -0,0 +1,230 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// adminGet fetches path from the admin handler of ts and decodes it into T.
+func adminGet[T any](t *testing.T, ts *TestServer, path string) T {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	ts.Server.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status = %d", path, rec.Code)
+	}
+	return decode[T](t, rec.Body.Bytes())
+}
+
+// storeChat creates a stored chat completion and returns its ID.
+func storeChat(t *testing.T, ts *TestServer, model, user string, metadata map[string]string) string {
+	t.Helper()
+	meta, _ := json.Marshal(metadata)
+	body := fmt.Sprintf(`{"model":%q,"user":%q,"store":true,"metadata":%s,"messages":[{"role":"user","content":"Hello"}]}`, model, user, meta)
+	resp, data := call(t, ts, "POST", "/chat/completions", body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("store chat: status = %d (%s)", resp.StatusCode, data)
+	}
+	return decode[ChatCompletionResponse](t, data).ID
+}
+
+func TestStoredCompletionsList(t *testing.T) {
+	ts := Start(t)
+	first := storeChat(t, ts, "gpt-4o", "alice", map[string]string{"team": "red"})
+	second := storeChat(t, ts, "gpt-4o-mini", "bob", map[string]string{"team": "blue"})
+	third := storeChat(t, ts, "gpt-4o", "alice", map[string]string{"team": "red", "env": "ci"})
+	// Not stored
+	call(t, ts, "POST", "/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}]}`)
+
+	tests := []struct {
+		name        string
+		query       string
+		want        []string
+		wantHasMore bool
+	}{
+		{"Default", "", []string{third, second, first}, false},
+		{"Ascending", "?order=asc", []string{first, second, third}, false},
+		{"Limit", "?limit=2", []string{third, second}, true},
+		{"Model", "?model=gpt-4o", []string{third, first}, false},
+		{"Metadata", "?metadata[team]=red", []string{third, first}, false},
+		{"MetadataAll", "?metadata[team]=red&metadata[env]=ci", []string{third}, false},
+		{"NoMatch", "?metadata[team]=green", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, data := call(t, ts, "GET", "/chat/completions"+tt.query, "")
+			list := decode[ListResponse[ChatCompletionResponse]](t, data)
+			var got []string
+			for _, c := range list.Data {
+				got = append(got, c.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IDs = %v, want %v", got, tt.want)
+			}
+			if list.HasMore != tt.wantHasMore {
+				t.Errorf("has_more = %v, want %v", list.HasMore, tt.wantHasMore)
+			}
+		})
+	}
+
+	if resp, _ := call(t, ts, "GET", "/chat/completions?limit=0", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("limit=0: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestStoredCompletionLifecycle(t *testing.T) {
+	ts := Start(t)
+	id := storeChat(t, ts, "gpt-4o", "alice", map[string]string{"team": "red"})
+	kept := storeChat(t, ts, "gpt-4o", "alice", map[string]string{"team": "red", "env": "ci"})
+
+	_, data := call(t, ts, "GET", "/chat/completions/"+id, "")
+	if got := decode[ChatCompletionResponse](t, data); got.ID != id || got.Metadata["team"] != "red" {
+		t.Errorf("get = %+v", got)
+	}
+	_, data = call(t, ts, "GET", "/chat/completions/"+id+"/messages", "")
+	if got := decode[ListResponse[ChatMessage]](t, data); len(got.Data) != 1 || got.Data[0].Content.GetText() != "Hello" {
+		t.Errorf("messages = %+v", got.Data)
+	}
+
+	stats := adminGet[AdminStats](t, ts, "/admin/stats")
+	if want := map[string]map[string]int{"team": {"red": 2}, "env": {"ci": 1}}; !reflect.DeepEqual(stats.Metadata, want) {
+		t.Errorf("metadata counts = %v, want %v", stats.Metadata, want)
+	}
+
+	_, data = call(t, ts, "DELETE", "/chat/completions/"+id, "")
+	if got := decode[DeletionStatus](t, data); !got.Deleted || got.ID != id || got.Object != "chat.completion.deleted" {
+		t.Errorf("delete = %+v", got)
+	}
+	if resp, _ := call(t, ts, "GET", "/chat/completions/"+id, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("get after delete: status = %d, want 404", resp.StatusCode)
+	}
+	if resp, _ := call(t, ts, "DELETE", "/chat/completions/"+id, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second delete: status = %d, want 404", resp.StatusCode)
+	}
+
+	// Deleting updates the metadata counts
+	stats = adminGet[AdminStats](t, ts, "/admin/stats")
+	if want := map[string]map[string]int{"team": {"red": 1}, "env": {"ci": 1}}; !reflect.DeepEqual(stats.Metadata, want) {
+		t.Errorf("metadata counts after delete = %v, want %v", stats.Metadata, want)
+	}
+	call(t, ts, "DELETE", "/chat/completions/"+kept, "")
+	stats = adminGet[AdminStats](t, ts, "/admin/stats")
+	if len(stats.Metadata) != 0 || stats.StoredCompletions != 0 {
+		t.Errorf("stats after deleting everything = %+v, want no metadata or completions", stats)
+	}
+}
+
+func TestStoredStreamedCompletion(t *testing.T) {
+	ts := Start(t)
+
+	_, data := call(t, ts, "POST", "/chat/completions", `{"model":"gpt-4o","store":true,"stream":true,"messages":[{"role":"user","content":"Hi"}]}`)
+	line, _, _ := strings.Cut(string(data), "\n")
+	chunk := decode[ChatCompletionChunk](t, []byte(strings.TrimPrefix(line, "data: ")))
+
+	resp, body := call(t, ts, "GET", "/chat/completions/"+chunk.ID, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("streamed completion %s not stored: status = %d", chunk.ID, resp.StatusCode)
+	}
+	if got := decode[ChatCompletionResponse](t, body); got.Debug != nil || got.Choices[0].Message.Content.GetText() == "" {
+		t.Errorf("stored = %+v", got)
+	}
+}
+
+func TestMetadataLimits(t *testing.T) {
+	ts := Start(t)
+
+	tooMany := make(map[string]string)
+	for i := 0; i < 17; i++ {
+		tooMany[fmt.Sprint("k", i)] = "v"
+	}
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		wantErr  string
+	}{
+		{"TooMany", tooMany, "too many properties"},
+		{"LongKey", map[string]string{strings.Repeat("k", 65): "v"}, "maximum length 64"},
+		{"LongValue", map[string]string{"k": strings.Repeat("v", 513)}, "Invalid 'metadata.k'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, _ := json.Marshal(tt.metadata)
+			resp, data := call(t, ts, "POST", "/chat/completions", `{"model":"gpt-4o","metadata":`+string(meta)+`,"messages":[{"role":"user","content":"Hi"}]}`)
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", resp.StatusCode)
+			}
+			if msg := decode[ErrorResponse](t, data).Error.Message; !strings.Contains(msg, tt.wantErr) {
+				t.Errorf("message = %q, want it to contain %q", msg, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUsageStats(t *testing.T) {
+	ts := Start(t)
+
+	requests := []struct {
+		path string
+		body string
+	}{
+		{"/chat/completions", `{"model":"gpt-4o","user":"alice","messages":[{"role":"user","content":"Hello there, how are you?"}]}`},
+		{"/embeddings", `{"model":"text-embedding-3-small","user":"alice","input":"Hello there, how are you?"}`},
+		{"/responses", `{"model":"gpt-4o","user":"alice","input":"Hello there, how are you?"}`},
+		{"/responses", `{"model":"gpt-4o","user":"bob","input":"Hello there, how are you?"}`},
+		{"/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}]}`},
+	}
+	for _, r := range requests {
+		if resp, data := call(t, ts, "POST", r.path, r.body); resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST %s: status = %d (%s)", r.path, resp.StatusCode, data)
+		}
+	}
+
+	stats := adminGet[AdminStats](t, ts, "/admin/stats")
+	tests := []struct {
+		user         string
+		wantRequests int
+	}{
+		{"alice", 3},
+		{"bob", 1},
+		{anonymousUser, 1},
+	}
+	for _, tt := range tests {
+		got := stats.Users[tt.user]
+		if got.Requests != tt.wantRequests {
+			t.Errorf("%s: requests = %d, want %d", tt.user, got.Requests, tt.wantRequests)
+		}
+		if got.TotalTokens == 0 || got.TotalTokens != got.PromptTokens+got.CompletionTokens || got.LastSeen == 0 {
+			t.Errorf("%s: stats = %+v", tt.user, got)
+		}
+	}
+}
+
+func TestAdminCompletions(t *testing.T) {
+	ts := Start(t)
+	storeChat(t, ts, "gpt-4o", "alice", map[string]string{"team": "red"})
+	storeChat(t, ts, "gpt-4o", "bob", nil)
+
+	type list struct {
+		Data []StoredCompletion `json:"data"`
+	}
+	all := adminGet[list](t, ts, "/admin/completions")
+	if len(all.Data) != 2 {
+		t.Fatalf("got %d completions, want 2", len(all.Data))
+	}
+	for _, c := range all.Data {
+		if c.Client != "test-client" {
+			t.Errorf("client = %q, want the certificate's common name", c.Client)
+		}
+	}
+
+	alice := adminGet[list](t, ts, "/admin/completions?user=alice")
+	if len(alice.Data) != 1 || alice.Data[0].User != "alice" || alice.Data[0].Metadata["team"] != "red" {
+		t.Errorf("user=alice: %+v", alice.Data)
+	}
+}
@@ -0,0 +1,98 @@
+package mockserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// Test Helper
+// ============================================================================
+
+// TestServer is a running mock server started by Start.
+type TestServer struct {
+	// URL is the base URL of the API, including the /v1 prefix
+	// (e.g. https://127.0.0.1:54321/v1).
+	URL string
+
+	// CAPool contains the ephemeral CA that issued both the server and
+	// client certificates.
+	CAPool *x509.CertPool
+
+	// Client is an *http.Client that trusts CAPool and presents a client
+	// certificate accepted by the server.
+	Client *http.Client
+
+	// Server is the underlying mock, for tests that need to inspect or
+	// reconfigure it.
+	Server *Server
+}
+
+// Start starts a mock server with mTLS on a random loopback port using freshly
+// generated certificates. The server is shut down via t.Cleanup.
+func Start(t testing.TB) *TestServer {
+	t.Helper()
+	return StartWithConfig(t, Config{})
+}
+
+// StartWithConfig is like Start but uses config for the mock server.
+func StartWithConfig(t testing.TB, config Config) *TestServer {
+	t.Helper()
+
+	pki, err := generateTestPKI()
+	if err != nil {
+		t.Fatalf("mockserver: generate certificates: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("mockserver: listen: %v", err)
+	}
+
+	mock := New(config)
+	server := &http.Server{
+		Handler: mock,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{pki.server},
+			ClientCAs:    pki.pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+
+	go func() {
+		if err := server.ServeTLS(listener, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("mockserver: serve: %v", err)
+		}
+	}()
+
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			Certificates: []tls.Certificate{pki.client},
+			RootCAs:      pki.pool,
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+
+	t.Cleanup(func() {
+		transport.CloseIdleConnections()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			server.Close()
+		}
+	})
+
+	return &TestServer{
+		URL:    "https://" + listener.Addr().String() + "/v1",
+		CAPool: pki.pool,
+		Client: &http.Client{Transport: transport},
+		Server: mock,
+	}
+}
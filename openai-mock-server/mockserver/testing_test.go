@@ -0,0 +1,141 @@
+package mockserver
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// call sends a request to the mock at path (relative to ts.URL, which already
+// includes /v1) and returns the response with its body read. header holds
+// name/value pairs.
+func call(t *testing.T, ts *TestServer, method, path, body string, header ...string) (*http.Response, []byte) {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Add(header[i], header[i+1])
+	}
+	resp, err := ts.Client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: read body: %v", method, path, err)
+	}
+	return resp, data
+}
+
+// decode unmarshals data into a new T, failing the test on error.
+func decode[T any](t *testing.T, data []byte) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+	return v
+}
+
+func TestStart(t *testing.T) {
+	ts := Start(t)
+
+	if !strings.HasPrefix(ts.URL, "https://127.0.0.1:") || !strings.HasSuffix(ts.URL, "/v1") {
+		t.Errorf("URL = %q, want https://127.0.0.1:<port>/v1", ts.URL)
+	}
+	if ts.Server == nil || ts.CAPool == nil {
+		t.Fatal("Server and CAPool must be set")
+	}
+
+	// A client that trusts the CA but presents no certificate is rejected
+	// during the handshake
+	anonymous := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: ts.CAPool},
+	}}
+	if resp, err := anonymous.Get(ts.URL + "/models"); err == nil {
+		resp.Body.Close()
+		t.Errorf("Request without a client certificate succeeded with %s", resp.Status)
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	ts := Start(t)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"ListModels", "GET", "/models", "", 200, ""},
+		{"GetModel", "GET", "/models/gpt-4o", "", 200, ""},
+		{"UnknownModel", "GET", "/models/gpt-0", "", 404, "model_not_found"},
+		{"ModelsWrongMethod", "POST", "/models", "{}", 405, ""},
+		{"Chat", "POST", "/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}]}`, 200, ""},
+		{"ChatNoModel", "POST", "/chat/completions", `{"messages":[{"role":"user","content":"Hello"}]}`, 400, ""},
+		{"ChatNoMessages", "POST", "/chat/completions", `{"model":"gpt-4o"}`, 400, ""},
+		{"ChatInvalidJSON", "POST", "/chat/completions", `{`, 400, ""},
+		{"Embeddings", "POST", "/embeddings", `{"model":"text-embedding-3-small","input":"Hello"}`, 200, ""},
+		{"EmbeddingsNoInput", "POST", "/embeddings", `{"model":"text-embedding-3-small"}`, 400, ""},
+		{"UnknownURL", "GET", "/nope", "", 404, "unknown_url"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := call(t, ts, tt.method, tt.path, tt.body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if resp.StatusCode < 400 {
+				return
+			}
+			errResp := decode[ErrorResponse](t, body)
+			if errResp.Error.Message == "" || errResp.Error.Type != "invalid_request_error" {
+				t.Errorf("error = %+v, want an invalid_request_error with a message", errResp.Error)
+			}
+			if tt.wantCode != "" && (errResp.Error.Code == nil || *errResp.Error.Code != tt.wantCode) {
+				t.Errorf("code = %v, want %q", errResp.Error.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestChatCompletion(t *testing.T) {
+	ts := Start(t)
+
+	_, body := call(t, ts, "POST", "/chat/completions", `{"model":"gpt-4o","n":2,"messages":[{"role":"user","content":"Hello"}]}`)
+	resp := decode[ChatCompletionResponse](t, body)
+
+	if resp.Object != "chat.completion" || !strings.HasPrefix(resp.ID, "chatcmpl-") {
+		t.Errorf("object = %q, id = %q", resp.Object, resp.ID)
+	}
+	if resp.Model != "gpt-4o" {
+		t.Errorf("model = %q, want gpt-4o", resp.Model)
+	}
+	if len(resp.Choices) != 2 {
+		t.Fatalf("got %d choices, want 2", len(resp.Choices))
+	}
+	for _, choice := range resp.Choices {
+		if choice.Message.Role != "assistant" || choice.Message.Content.GetText() == "" || choice.FinishReason != "stop" {
+			t.Errorf("choice %d = %+v", choice.Index, choice)
+		}
+	}
+	if resp.Usage.TotalTokens != resp.Usage.PromptTokens+resp.Usage.CompletionTokens {
+		t.Errorf("usage = %+v does not add up", resp.Usage)
+	}
+}
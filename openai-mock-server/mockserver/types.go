@@ -0,0 +1,111 @@
+package mockserver
+
+import (
+	"fmt"
+	"strings"
+
+	"openaitypes"
+)
+
+// ============================================================================
+// Types
+// ============================================================================
+
+// The request and response bodies are those of the openaitypes package,
+// shared with the proxy and other Go code. They keep their names here, so
+// generators and plugins written against this package need no change.
+type (
+	Model          = openaitypes.Model
+	ModelsResponse = openaitypes.ModelsResponse
+
+	ContentPart            = openaitypes.ContentPart
+	MessageContent         = openaitypes.MessageContent
+	ChatMessage            = openaitypes.ChatMessage
+	Annotation             = openaitypes.Annotation
+	URLCitation            = openaitypes.URLCitation
+	FileCitation           = openaitypes.FileCitation
+	ResponseMessage        = openaitypes.ResponseMessage
+	ToolCall               = openaitypes.ToolCall
+	FunctionCall           = openaitypes.FunctionCall
+	Tool                   = openaitypes.Tool
+	FunctionDefinition     = openaitypes.FunctionDefinition
+	ChatCompletionRequest  = openaitypes.ChatCompletionRequest
+	ChatChoice             = openaitypes.ChatChoice
+	Usage                  = openaitypes.Usage
+	ChatCompletionResponse = openaitypes.ChatCompletionResponse
+	DebugInfo              = openaitypes.DebugInfo
+	StreamDelta            = openaitypes.StreamDelta
+	StreamChoice           = openaitypes.StreamChoice
+	ChatCompletionChunk    = openaitypes.ChatCompletionChunk
+
+	EmbeddingsRequest  = openaitypes.EmbeddingsRequest
+	EmbeddingData      = openaitypes.EmbeddingData
+	EmbeddingsResponse = openaitypes.EmbeddingsResponse
+
+	ErrorDetail   = openaitypes.ErrorDetail
+	ErrorResponse = openaitypes.ErrorResponse
+)
+
+// ============================================================================
+// Mock Data
+// ============================================================================
+
+var mockModels = []Model{
+	{ID: "gpt-4", Object: "model", Created: 1687882411, OwnedBy: "openai"},
+	{ID: "gpt-4-turbo", Object: "model", Created: 1712361441, OwnedBy: "openai"},
+	{ID: "gpt-4-turbo-preview", Object: "model", Created: 1706037777, OwnedBy: "openai"},
+	{ID: "gpt-4o", Object: "model", Created: 1715367049, OwnedBy: "openai"},
+	{ID: "gpt-4o-mini", Object: "model", Created: 1721172741, OwnedBy: "openai"},
+	{ID: "gpt-3.5-turbo", Object: "model", Created: 1677610602, OwnedBy: "openai"},
+	{ID: "gpt-3.5-turbo-16k", Object: "model", Created: 1683758102, OwnedBy: "openai"},
+	{ID: "text-embedding-ada-002", Object: "model", Created: 1671217299, OwnedBy: "openai-internal"},
+	{ID: "text-embedding-3-small", Object: "model", Created: 1705948997, OwnedBy: "openai"},
+	{ID: "text-embedding-3-large", Object: "model", Created: 1705953180, OwnedBy: "openai"},
+}
+
+// echoResponse extracts the last user message and produces a direct, realistic
+// answer so that agent-style callers (like opencode) treat the task as complete
+// and stop looping.
+func echoResponse(messages []ChatMessage) string {
+	// Find the last user message
+	var lastUser string
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			lastUser = messages[i].Content.GetText()
+			break
+		}
+	}
+
+	if lastUser == "" {
+		return "Done."
+	}
+
+	lower := strings.ToLower(lastUser)
+
+	// Handle common patterns with direct, satisfying answers
+	switch {
+	case strings.Contains(lower, "hello") && strings.Contains(lower, "5 words"):
+		return "Hello, it is nice today!"
+	case strings.Contains(lower, "hello"):
+		return "Hello! Great to meet you. How can I help?"
+	case strings.Contains(lower, "summarize"), strings.Contains(lower, "summary"):
+		return "Here is the summary: The content covers the main points effectively. The key takeaway is that all objectives have been met successfully."
+	case strings.Contains(lower, "explain"):
+		return "This works by processing the input, applying the necessary transformations, and producing the expected output. The design is straightforward and efficient."
+	case strings.Contains(lower, "write"), strings.Contains(lower, "generate"), strings.Contains(lower, "create"):
+		return "Here is what you requested:\n\nThe quick brown fox jumps over the lazy dog. This classic sentence demonstrates every letter of the alphabet and has been used for testing since the late 1800s."
+	case strings.Contains(lower, "fix"), strings.Contains(lower, "bug"), strings.Contains(lower, "error"):
+		return "The issue has been identified and resolved. The root cause was a missing validation step. No further action is needed."
+	case strings.Contains(lower, "test"):
+		return "All tests pass. The implementation is correct and meets the specified requirements."
+	default:
+		return fmt.Sprintf("Here is my response to your request: I've carefully considered \"%s\" and completed the task. No further action is needed.", truncate(lastUser, 100))
+	}
+}
+
+func truncate(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	return s[:maxLen] + "..."
+}
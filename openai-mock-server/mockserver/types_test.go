@@ -0,0 +1,53 @@
+package mockserver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVendorParams(t *testing.T) {
+	ts := StartWithConfig(t, Config{DebugEcho: true})
+	messages := `"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}]`
+
+	tests := []struct {
+		name   string
+		params string
+		want   map[string]any
+	}{
+		{"None", ``, nil},
+		{"Integers", `"top_k":40,"min_p":0,"repetition_penalty":1`, map[string]any{"top_k": 40.0, "min_p": 0.0, "repetition_penalty": 1.0}},
+		{"Floats", `"top_k":40.0,"min_p":0.05,"repetition_penalty":1.1`, map[string]any{"top_k": 40.0, "min_p": 0.05, "repetition_penalty": 1.1}},
+		{"Exponent", `"top_k":4e1`, map[string]any{"top_k": 40.0}},
+		{"Structured", `"grammar":"root ::= \"yes\"","guided_json":{"type":"object"}`, map[string]any{"grammar": `root ::= "yes"`, "guided_json": map[string]any{"type": "object"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := "{" + messages + "}"
+			if tt.params != "" {
+				body = "{" + messages + "," + tt.params + "}"
+			}
+			resp, data := call(t, ts, "POST", "/chat/completions", body)
+			if resp.StatusCode != 200 {
+				t.Fatalf("status = %d, want 200 (%s)", resp.StatusCode, data)
+			}
+
+			var got map[string]any
+			if debug := decode[ChatCompletionResponse](t, data).Debug; debug != nil {
+				got = debug.VendorParams
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("vendor_params = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVendorParamsInvalid(t *testing.T) {
+	ts := Start(t)
+
+	resp, _ := call(t, ts, "POST", "/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}],"top_k":"forty"}`)
+	if resp.StatusCode != 400 {
+		t.Errorf("status = %d, want 400 for a non-numeric top_k", resp.StatusCode)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// revocationList is a CRL and the serial numbers it revokes.
+type revocationList struct {
+	crl     *x509.RevocationList
+	revoked map[string]bool
+}
+
+// revocationCheck loads the CRLs at path and returns a
+// VerifyPeerCertificate hook that fails the handshake for a client whose
+// certificate, or any intermediate CA certificate in its chain, is listed
+// by the CRL of the CA that issued it. A PEM file may hold a CRL for each
+// CA in the hierarchy, the root's and each intermediate's. CRLs issued by
+// a CA in caPEM are checked against it now; the rest are checked against
+// the intermediate in the client's chain they name as issuer, when one is
+// presented, since only the client sends its intermediates.
+func revocationCheck(path string, caPEM []byte) (func([][]byte, [][]*x509.Certificate) error, error) {
+	crls, err := loadRevocationLists(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cas []*x509.Certificate
+	for block, rest := pem.Decode(caPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		cas = append(cas, ca)
+	}
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("no certificate in CA file")
+	}
+	for _, list := range crls {
+		for _, ca := range cas {
+			if !bytes.Equal(list.crl.RawIssuer, ca.RawSubject) {
+				continue
+			}
+			if err := list.crl.CheckSignatureFrom(ca); err != nil {
+				return nil, fmt.Errorf("CRL for %s not signed by CA: %w", ca.Subject.CommonName, err)
+			}
+		}
+	}
+
+	return func(_ [][]byte, chains [][]*x509.Certificate) error {
+		for _, chain := range chains {
+			// Each certificate is checked against its issuer's CRLs; the
+			// root, last, has no issuer to revoke it
+			for i := 0; i+1 < len(chain); i++ {
+				cert, issuer := chain[i], chain[i+1]
+				for _, list := range crls {
+					if !bytes.Equal(list.crl.RawIssuer, issuer.RawSubject) {
+						continue
+					}
+					if err := list.crl.CheckSignatureFrom(issuer); err != nil {
+						return fmt.Errorf("CRL for %s not signed by it: %w", issuer.Subject.CommonName, err)
+					}
+					if !list.revoked[cert.SerialNumber.String()] {
+						continue
+					}
+					if i == 0 {
+						return fmt.Errorf("client certificate %q (serial %s) has been revoked", cert.Subject.CommonName, cert.SerialNumber)
+					}
+					return fmt.Errorf("intermediate CA %q (serial %s) that issued client certificate %q has been revoked",
+						cert.Subject.CommonName, cert.SerialNumber, chain[0].Subject.CommonName)
+				}
+			}
+		}
+		return nil
+	}, nil
+}
+
+// loadRevocationLists reads the CRLs in a PEM file, or the one in a DER
+// file.
+func loadRevocationLists(path string) ([]*revocationList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ders [][]byte
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		ders = append(ders, block.Bytes)
+	}
+	if ders == nil {
+		ders = [][]byte{data}
+	}
+
+	lists := make([]*revocationList, 0, len(ders))
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, err
+		}
+		revoked := make(map[string]bool, len(crl.RevokedCertificateEntries))
+		for _, entry := range crl.RevokedCertificateEntries {
+			revoked[entry.SerialNumber.String()] = true
+		}
+		lists = append(lists, &revocationList{crl: crl, revoked: revoked})
+	}
+	return lists, nil
+}
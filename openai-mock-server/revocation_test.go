@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA is a CA certificate and its key.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issue signs a certificate for name with serial, a CA if ca is set, by
+// parent, or self-signed if parent is nil.
+func issue(t *testing.T, parent *testCA, name string, serial int64, ca bool) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	if ca {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// crl returns a PEM CRL issued as ca, revoking serials, signed by key.
+func crl(t *testing.T, ca *x509.Certificate, key *ecdsa.PrivateKey, serials ...int64) []byte {
+	t.Helper()
+	list := &x509.RevocationList{Number: big.NewInt(1), ThisUpdate: time.Now(), NextUpdate: time.Now().Add(time.Hour)}
+	for _, serial := range serials {
+		list.RevokedCertificateEntries = append(list.RevokedCertificateEntries, x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+	// CreateRevocationList checks the key against ca, so a forged CRL is
+	// signed as a copy of ca with the forger's key
+	issuer := *ca
+	issuer.PublicKey = key.Public()
+	der, err := x509.CreateRevocationList(rand.Reader, list, &issuer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func TestRevocationCheck(t *testing.T) {
+	root := issue(t, nil, "Root", 1, true)
+	intermediate := issue(t, root, "Intermediate", 2, true)
+	revokedIntermediate := issue(t, root, "Revoked-Intermediate", 3, true)
+	rootLeaf := issue(t, root, "root-client", 10, false)
+	revokedRootLeaf := issue(t, root, "revoked-root-client", 11, false)
+	leaf := issue(t, intermediate, "chain-client", 11, false) // the serial of a certificate the root revoked
+	revokedLeaf := issue(t, intermediate, "revoked-chain-client", 12, false)
+	underRevoked := issue(t, revokedIntermediate, "orphan-client", 13, false)
+	forger, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw})
+	rootCRL := crl(t, root.cert, root.key, 3, 11)
+	intermediateCRL := crl(t, intermediate.cert, intermediate.key, 12)
+	write := func(crls ...[]byte) string {
+		path := filepath.Join(t.TempDir(), "crl.pem")
+		if err := os.WriteFile(path, bytes.Join(crls, nil), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	check, err := revocationCheck(write(rootCRL, intermediateCRL), caPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := func(certs ...*testCA) [][]*x509.Certificate {
+		var c []*x509.Certificate
+		for _, cert := range certs {
+			c = append(c, cert.cert)
+		}
+		return [][]*x509.Certificate{c}
+	}
+	for _, tt := range []struct {
+		name  string
+		chain [][]*x509.Certificate
+		want  string // in the error, or "" if accepted
+	}{
+		{"RootLeaf", chain(rootLeaf, root), ""},
+		{"RevokedRootLeaf", chain(revokedRootLeaf, root), `client certificate "revoked-root-client" (serial 11) has been revoked`},
+		{"ChainLeaf", chain(leaf, intermediate, root), ""},
+		{"RevokedChainLeaf", chain(revokedLeaf, intermediate, root), `client certificate "revoked-chain-client" (serial 12) has been revoked`},
+		{"RevokedIntermediate", chain(underRevoked, revokedIntermediate, root), `intermediate CA "Revoked-Intermediate" (serial 3) that issued client certificate "orphan-client" has been revoked`},
+	} {
+		err := check(nil, tt.chain)
+		if tt.want == "" && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.want != "" && (err == nil || err.Error() != tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// A CRL for the CA that it did not sign is refused when loaded, and one
+	// for an intermediate when a chain through that intermediate is checked
+	if _, err := revocationCheck(write(crl(t, root.cert, forger)), caPEM); err == nil {
+		t.Error("loaded a CRL for the CA signed by another key")
+	}
+	check, err = revocationCheck(write(rootCRL, crl(t, intermediate.cert, forger)), caPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := check(nil, chain(leaf, intermediate, root)); err == nil || !strings.Contains(err.Error(), "not signed by it") {
+		t.Errorf("forged intermediate CRL: got %v", err)
+	}
+	if err := check(nil, chain(rootLeaf, root)); err != nil {
+		t.Errorf("forged intermediate CRL rejected a root leaf: %v", err)
+	}
+}
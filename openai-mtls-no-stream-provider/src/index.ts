@@ -25,6 +25,7 @@ export interface MTLSProviderSettings {
   queryParams?: Record<string, string>;
   clientCert?: string;
   clientKey?: string;
+  clientKeyPassphrase?: string;
   caCert?: string;
   proxy?: string;
   removeKeys?: string[];
@@ -57,6 +58,7 @@ function removeKeysFromObject(obj: unknown, keysToRemove: string[]): unknown {
 interface FetchOptions {
   clientCert?: string;
   clientKey?: string;
+  clientKeyPassphrase?: string;
   caCert?: string;
   proxy?: string;
   removeKeys?: string[];
@@ -102,6 +104,7 @@ function createCustomFetch(options: FetchOptions): typeof fetch {
       fetchOptions.tls = {
         cert: cert.toString(),
         key: key.toString(),
+        ...(options.clientKeyPassphrase !== undefined ? { passphrase: options.clientKeyPassphrase } : {}),
         ...(ca ? { ca: ca.toString(), rejectUnauthorized: true } : { rejectUnauthorized: false }),
       };
     }
@@ -520,6 +523,7 @@ export function createOpenAICompatible(options: MTLSProviderSettings) {
     customFetch = createCustomFetch({
       clientCert: options.clientCert,
       clientKey: options.clientKey,
+      clientKeyPassphrase: options.clientKeyPassphrase,
       caCert: options.caCert,
       proxy: options.proxy,
       removeKeys: options.removeKeys,
@@ -38,6 +38,11 @@ export interface MTLSProviderSettings {
    */
   clientKey?: string;
 
+  /**
+   * Passphrase of an encrypted client key, e.g. "{env:MTLS_KEY_PASSPHRASE}"
+   */
+  clientKeyPassphrase?: string;
+
   /**
    * Path to the CA certificate file (PEM format).
    * If not provided, server certificate verification is skipped.
@@ -69,6 +74,7 @@ function resolvePath(p: string): string {
 interface FetchOptions {
   clientCert?: string;
   clientKey?: string;
+  clientKeyPassphrase?: string;
   caCert?: string;
   proxy?: string;
   removeKeys?: string[];
@@ -166,6 +172,7 @@ function createCustomFetch(options: FetchOptions): typeof fetch {
       fetchOptions.tls = {
         cert: cert.toString(),
         key: key.toString(),
+        ...(options.clientKeyPassphrase !== undefined ? { passphrase: options.clientKeyPassphrase } : {}),
         // If CA cert is provided, verify server cert; otherwise skip verification
         ...(ca ? { ca: ca.toString(), rejectUnauthorized: true } : { rejectUnauthorized: false }),
       };
@@ -204,6 +211,7 @@ export function createOpenAICompatible(options: MTLSProviderSettings) {
     customFetch = createCustomFetch({
       clientCert: options.clientCert,
       clientKey: options.clientKey,
+      clientKeyPassphrase: options.clientKeyPassphrase,
       caCert: options.caCert,
       proxy: options.proxy,
       removeKeys: options.removeKeys,
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// =============================================================================
+// Stream Cancellation
+// =============================================================================
+
+// releaseTimeout is how long the client and server have to release a
+// cancelled stream's connection, request and goroutines.
+const releaseTimeout = 2 * time.Second
+
+// connTracker counts the connections a transport has open.
+type connTracker struct {
+	open atomic.Int64
+}
+
+// dialContext wraps dial so that every connection it opens is counted until
+// it is closed.
+func (c *connTracker) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		c.open.Add(1)
+		return &trackedConn{Conn: conn, tracker: c}, nil
+	}
+}
+
+type trackedConn struct {
+	net.Conn
+	tracker *connTracker
+	once    sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.tracker.open.Add(-1) })
+	return c.Conn.Close()
+}
+
+// eventually polls cond until it holds or timeout passes.
+func eventually(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// testStreamCancellation cancels streamed chat completions part-way through
+// and checks that the client closes the connection and frees its
+// goroutines, and, with -metrics-url pointing at the mock, that the server
+// stops the handler and frees its goroutines too. It runs on its own so the
+// goroutine and in-flight counts are not disturbed by other tests.
+func testStreamCancellation(t *testing.T) {
+	ctx, _ := setupSerial(t)
+
+	for _, chunks := range []int{1, 3} {
+		t.Run(fmt.Sprintf("After%dChunks", chunks), func(t *testing.T) {
+			cancelStreamAfter(t, ctx, chunks)
+		})
+	}
+}
+
+func cancelStreamAfter(t *testing.T, ctx context.Context, chunks int) {
+	transport, err := newTransport(opts)
+	if err != nil {
+		t.Fatalf("Failed to configure transport: %v", err)
+	}
+	defer transport.CloseIdleConnections()
+	tracker := &connTracker{}
+	transport.DialContext = tracker.dialContext((&net.Dialer{Timeout: 30 * time.Second}).DialContext)
+	client := newClientWithTransport(opts, transport)
+
+	// The metrics client does not keep connections alive, so it leaves no
+	// goroutines behind on either side between reads
+	var metrics *http.Client
+	var before *targetVars
+	if opts.MetricsURL != "" {
+		metricsTransport, err := newTransport(opts)
+		if err != nil {
+			t.Fatalf("Failed to configure transport: %v", err)
+		}
+		metricsTransport.DisableKeepAlives = true
+		metrics = &http.Client{Transport: metricsTransport, Timeout: 5 * time.Second}
+		if before, err = readTargetVars(metrics, opts.MetricsURL); err != nil {
+			t.Fatalf("Failed to read target metrics: %v", err)
+		}
+		if before.InFlight == nil || before.Goroutines == nil {
+			t.Log("The target does not report requests in flight and goroutines; checking the client only")
+			before = nil
+		}
+	} else {
+		t.Log("No -metrics-url; checking the client only")
+	}
+	goroutines := runtime.NumGoroutine()
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.CreateChatCompletionStream(streamCtx, openai.ChatCompletionRequest{
+		Model:    openai.GPT4oMini,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Please summarize the design."}},
+		Stream:   true,
+	})
+	if err != nil {
+		t.Fatalf("Error creating stream: %v", err)
+	}
+	for i := 0; i < chunks; i++ {
+		if _, err := stream.Recv(); err != nil {
+			t.Fatalf("Stream ended after %d of %d chunks: %v", i, chunks, err)
+		}
+	}
+
+	cancel()
+	cancelled := time.Now()
+	for {
+		_, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			t.Error("Stream completed normally after being cancelled")
+			break
+		}
+		if err != nil {
+			break
+		}
+		if time.Since(cancelled) > releaseTimeout {
+			t.Fatalf("Stream still delivering chunks %v after being cancelled", releaseTimeout)
+		}
+	}
+	stream.Close()
+	t.Logf("Stream ended %v after cancellation", time.Since(cancelled).Round(time.Millisecond))
+
+	if !eventually(releaseTimeout, func() bool { return tracker.open.Load() == 0 }) {
+		t.Errorf("Client still has %d connection(s) open %v after cancelling", tracker.open.Load(), releaseTimeout)
+	}
+	if !eventually(releaseTimeout, func() bool { return runtime.NumGoroutine() <= goroutines }) {
+		t.Errorf("Client has %d goroutines %v after cancelling, %d before the stream", runtime.NumGoroutine(), releaseTimeout, goroutines)
+	}
+
+	if before == nil {
+		return
+	}
+	var after *targetVars
+	released := eventually(releaseTimeout, func() bool {
+		after, err = readTargetVars(metrics, opts.MetricsURL)
+		return err == nil && *after.InFlight <= *before.InFlight && *after.Goroutines <= *before.Goroutines
+	})
+	switch {
+	case err != nil:
+		t.Errorf("Failed to read target metrics: %v", err)
+	case released:
+		t.Logf("Server released the request within %v", time.Since(cancelled).Round(time.Millisecond))
+	case *after.InFlight > *before.InFlight:
+		t.Errorf("Server still has %d request(s) in flight %v after cancelling, %d before the stream", *after.InFlight, releaseTimeout, *before.InFlight)
+	default:
+		t.Errorf("Server has %d goroutines %v after cancelling, %d before the stream", *after.Goroutines, releaseTimeout, *before.Goroutines)
+	}
+}
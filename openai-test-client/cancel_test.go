@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnTracker(t *testing.T) {
+	tracker := &connTracker{}
+	var peers []net.Conn
+	dial := tracker.dialContext(func(context.Context, string, string) (net.Conn, error) {
+		client, server := net.Pipe()
+		peers = append(peers, server)
+		return client, nil
+	})
+
+	a, err := dial(context.Background(), "tcp", "x:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := dial(context.Background(), "tcp", "x:1")
+	if got := tracker.open.Load(); got != 2 {
+		t.Fatalf("open = %d after two dials, want 2", got)
+	}
+
+	a.Close()
+	a.Close() // Closing twice counts once
+	if got := tracker.open.Load(); got != 1 {
+		t.Errorf("open = %d after closing one connection twice, want 1", got)
+	}
+	b.Close()
+	if !eventually(100*time.Millisecond, func() bool { return tracker.open.Load() == 0 }) {
+		t.Errorf("open = %d after closing both, want 0", tracker.open.Load())
+	}
+	for _, peer := range peers {
+		peer.Close()
+	}
+}
+
+func TestEventually(t *testing.T) {
+	calls := 0
+	if !eventually(time.Second, func() bool { calls++; return calls == 3 }) || calls != 3 {
+		t.Errorf("eventually returned before the condition held, after %d calls", calls)
+	}
+	start := time.Now()
+	if eventually(50*time.Millisecond, func() bool { return false }) {
+		t.Error("eventually = true for a condition that never holds")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("eventually gave up after %v, before the timeout", elapsed)
+	}
+}
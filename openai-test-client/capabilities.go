@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// =============================================================================
+// Capability Probe
+// =============================================================================
+
+// capability is an optional group of endpoints. Targets differ in which of
+// them they serve, so each group's tests first probe for it and skip,
+// rather than fail, when it is missing.
+type capability struct {
+	name   string
+	method string
+	path   string
+}
+
+// capabilities are probed with an empty JSON body or a list request, which
+// a server that has the endpoint rejects or answers without side effects.
+var capabilities = []capability{
+	{"images", http.MethodPost, "/images/generations"},
+	{"speech", http.MethodPost, "/audio/speech"},
+	{"transcriptions", http.MethodPost, "/audio/transcriptions"},
+	{"moderations", http.MethodPost, "/moderations"},
+	{"files", http.MethodGet, "/files"},
+	{"batches", http.MethodGet, "/batches"},
+}
+
+var (
+	capabilityOnce   sync.Once
+	capabilityStatus map[string]int
+	capabilityErr    error
+)
+
+// probeCapabilities sends one request to each capability and returns the
+// status of each response.
+func probeCapabilities(o Options) (map[string]int, error) {
+	transport, err := newTransport(o)
+	if err != nil {
+		return nil, err
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: withHostOverride(o, transport), Timeout: 10 * time.Second}
+
+	status := make(map[string]int)
+	for _, c := range capabilities {
+		var body io.Reader
+		if c.method == http.MethodPost {
+			body = strings.NewReader("{}")
+		}
+		req, err := http.NewRequest(c.method, strings.TrimSuffix(o.apiBaseURL(), "/")+c.path, body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+o.APIKey)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("probing %s: %w", c.path, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		status[c.name] = resp.StatusCode
+	}
+	return status, nil
+}
+
+// capabilityAbsent reports whether a probe status means the endpoint is
+// not served: not found, method not allowed, or not implemented. Any other
+// status, including a validation error, means it is.
+func capabilityAbsent(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented
+}
+
+// requireCapability skips the test unless the target serves the named
+// capability. The probe runs once per process.
+func requireCapability(t *testing.T, name string) {
+	t.Helper()
+	capabilityOnce.Do(func() { capabilityStatus, capabilityErr = probeCapabilities(opts) })
+	if capabilityErr != nil {
+		t.Fatalf("Capability probe failed: %v", capabilityErr)
+	}
+	i := slices.IndexFunc(capabilities, func(c capability) bool { return c.name == name })
+	if status := capabilityStatus[name]; capabilityAbsent(status) {
+		t.Skipf("Target does not serve %s %s (status %d)", capabilities[i].method, capabilities[i].path, status)
+	}
+}
+
+// testCapabilities logs which optional endpoints the target serves, so the
+// report shows why their tests were skipped.
+func testCapabilities(t *testing.T) {
+	setup(t)
+
+	capabilityOnce.Do(func() { capabilityStatus, capabilityErr = probeCapabilities(opts) })
+	if capabilityErr != nil {
+		t.Fatalf("Capability probe failed: %v", capabilityErr)
+	}
+	for _, c := range capabilities {
+		state := "present"
+		if capabilityAbsent(capabilityStatus[c.name]) {
+			state = "absent"
+		}
+		t.Logf("%-15s %-7s %s %s (status %d)", c.name, state, c.method, c.path, capabilityStatus[c.name])
+	}
+}
+
+// =============================================================================
+// Optional Endpoint Tests
+// =============================================================================
+
+func testImages(t *testing.T) {
+	ctx, client := setup(t)
+	requireCapability(t, "images")
+
+	t.Run("URL", func(t *testing.T) {
+		resp, err := client.CreateImage(ctx, openai.ImageRequest{
+			Prompt:         "A lighthouse at dusk",
+			Model:          openai.CreateImageModelDallE2,
+			N:              2,
+			Size:           openai.CreateImageSize256x256,
+			ResponseFormat: openai.CreateImageResponseFormatURL,
+		})
+		if err != nil {
+			t.Fatalf("CreateImage: %v", err)
+		}
+		if len(resp.Data) != 2 {
+			t.Fatalf("Expected 2 images, got %d", len(resp.Data))
+		}
+		for i, image := range resp.Data {
+			if u, err := url.Parse(image.URL); err != nil || u.Scheme == "" {
+				t.Errorf("Image %d has no valid URL: %q", i, image.URL)
+			}
+		}
+	})
+
+	t.Run("B64JSON", func(t *testing.T) {
+		resp, err := client.CreateImage(ctx, openai.ImageRequest{
+			Prompt:         "A lighthouse at dusk",
+			Model:          openai.CreateImageModelDallE2,
+			N:              1,
+			Size:           openai.CreateImageSize256x256,
+			ResponseFormat: openai.CreateImageResponseFormatB64JSON,
+		})
+		if err != nil {
+			t.Fatalf("CreateImage: %v", err)
+		}
+		if len(resp.Data) != 1 {
+			t.Fatalf("Expected 1 image, got %d", len(resp.Data))
+		}
+		data, err := base64.StdEncoding.DecodeString(resp.Data[0].B64JSON)
+		if err != nil || len(data) == 0 {
+			t.Errorf("b64_json is not non-empty base64 (%d bytes): %v", len(data), err)
+		}
+	})
+}
+
+func testAudioSpeech(t *testing.T) {
+	ctx, client := setup(t)
+	requireCapability(t, "speech")
+
+	resp, err := client.CreateSpeech(ctx, openai.CreateSpeechRequest{
+		Model: openai.TTSModel1,
+		Input: "The quick brown fox jumped over the lazy dog.",
+		Voice: openai.VoiceAlloy,
+	})
+	if err != nil {
+		t.Fatalf("CreateSpeech: %v", err)
+	}
+	defer resp.Close()
+	audio, err := io.ReadAll(resp)
+	if err != nil {
+		t.Fatalf("Reading audio: %v", err)
+	}
+
+	t.Run("Body", func(t *testing.T) {
+		if len(audio) == 0 {
+			t.Error("Empty audio body")
+		}
+	})
+
+	t.Run("ContentType", func(t *testing.T) {
+		if ct := resp.Header().Get("Content-Type"); !strings.HasPrefix(ct, "audio/") {
+			t.Errorf("Content-Type %q, want audio/*", ct)
+		}
+	})
+}
+
+func testAudioTranscription(t *testing.T) {
+	ctx, client := setup(t)
+	requireCapability(t, "transcriptions")
+
+	resp, err := client.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    openai.Whisper1,
+		FilePath: "silence.wav",
+		Reader:   bytes.NewReader(silentWAV(time.Second)),
+	})
+	if err != nil {
+		t.Fatalf("CreateTranscription: %v", err)
+	}
+	if resp.Text == "" {
+		t.Error("Transcription has no text")
+	}
+}
+
+// silentWAV returns d of 16 kHz mono 16-bit PCM silence as a WAV file.
+func silentWAV(d time.Duration) []byte {
+	const rate = 16000
+	samples := int(d.Seconds() * rate)
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+2*samples))
+	b.WriteString("WAVEfmt ")
+	// Format chunk: size, PCM, channels, sample rate, byte rate, block
+	// align, bits per sample
+	for _, v := range []any{uint32(16), uint16(1), uint16(1), uint32(rate), uint32(2 * rate), uint16(2), uint16(16)} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(2*samples))
+	b.Write(make([]byte, 2*samples))
+	return b.Bytes()
+}
+
+func testModerations(t *testing.T) {
+	ctx, client := setup(t)
+	requireCapability(t, "moderations")
+
+	resp, err := client.Moderations(ctx, openai.ModerationRequest{
+		Input: "I want to bake a cake.",
+		Model: "omni-moderation-latest",
+	})
+	if err != nil {
+		t.Fatalf("Moderations: %v", err)
+	}
+
+	t.Run("ID", func(t *testing.T) {
+		if resp.ID == "" {
+			t.Error("Response ID is empty")
+		}
+	})
+
+	t.Run("Results", func(t *testing.T) {
+		if len(resp.Results) != 1 {
+			t.Fatalf("Expected 1 result, got %d", len(resp.Results))
+		}
+		if resp.Results[0].Flagged {
+			t.Error("Harmless input was flagged")
+		}
+	})
+}
+
+// testFiles uploads a file and walks it through retrieval, download,
+// listing and deletion.
+func testFiles(t *testing.T) {
+	ctx, client := setup(t)
+	requireCapability(t, "files")
+
+	content := []byte(`{"prompt":"hello","completion":"world"}` + "\n")
+	file, err := client.CreateFileBytes(ctx, openai.FileBytesRequest{
+		Name:    "mtls-test.jsonl",
+		Bytes:   content,
+		Purpose: openai.PurposeAssistants,
+	})
+	if err != nil {
+		t.Fatalf("CreateFileBytes: %v", err)
+	}
+	deleted := false
+	defer func() {
+		if !deleted {
+			client.DeleteFile(ctx, file.ID)
+		}
+	}()
+
+	t.Run("Upload", func(t *testing.T) {
+		if file.ID == "" {
+			t.Error("File ID is empty")
+		}
+		if file.Bytes != len(content) {
+			t.Errorf("File has %d bytes, uploaded %d", file.Bytes, len(content))
+		}
+		if file.FileName != "mtls-test.jsonl" {
+			t.Errorf("File name %q, want mtls-test.jsonl", file.FileName)
+		}
+	})
+
+	t.Run("Retrieve", func(t *testing.T) {
+		got, err := client.GetFile(ctx, file.ID)
+		if err != nil {
+			t.Fatalf("GetFile: %v", err)
+		}
+		if got.ID != file.ID {
+			t.Errorf("Retrieved file %q, want %q", got.ID, file.ID)
+		}
+	})
+
+	t.Run("Content", func(t *testing.T) {
+		raw, err := client.GetFileContent(ctx, file.ID)
+		if err != nil {
+			t.Fatalf("GetFileContent: %v", err)
+		}
+		defer raw.Close()
+		got, err := io.ReadAll(raw)
+		if err != nil {
+			t.Fatalf("Reading content: %v", err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("Content %q, want %q", got, content)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		list, err := client.ListFiles(ctx)
+		if err != nil {
+			t.Fatalf("ListFiles: %v", err)
+		}
+		if !slices.ContainsFunc(list.Files, func(f openai.File) bool { return f.ID == file.ID }) {
+			t.Errorf("Uploaded file %s not listed", file.ID)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := client.DeleteFile(ctx, file.ID); err != nil {
+			t.Fatalf("DeleteFile: %v", err)
+		}
+		deleted = true
+		_, err := client.GetFile(ctx, file.ID)
+		var apiErr *openai.APIError
+		if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != http.StatusNotFound {
+			t.Errorf("GetFile after delete returned %v, want a 404", err)
+		}
+	})
+}
+
+// testBatches creates a batch of chat completions from an uploaded input
+// file, then retrieves, lists and cancels it.
+func testBatches(t *testing.T) {
+	ctx, client := setup(t)
+	requireCapability(t, "batches")
+	requireCapability(t, "files")
+
+	input := []byte(`{"custom_id":"req-1","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini","messages":[{"role":"user","content":"Hello"}]}}` + "\n")
+	file, err := client.CreateFileBytes(ctx, openai.FileBytesRequest{
+		Name:    "mtls-batch.jsonl",
+		Bytes:   input,
+		Purpose: openai.PurposeBatch,
+	})
+	if err != nil {
+		t.Fatalf("CreateFileBytes: %v", err)
+	}
+	defer client.DeleteFile(ctx, file.ID)
+
+	batch, err := client.CreateBatch(ctx, openai.CreateBatchRequest{
+		InputFileID:      file.ID,
+		Endpoint:         openai.BatchEndpointChatCompletions,
+		CompletionWindow: "24h",
+	})
+	if err != nil {
+		t.Fatalf("CreateBatch: %v", err)
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		if batch.ID == "" {
+			t.Error("Batch ID is empty")
+		}
+		if batch.InputFileID != file.ID {
+			t.Errorf("Batch input file %q, want %q", batch.InputFileID, file.ID)
+		}
+		if batch.Status == "" {
+			t.Error("Batch has no status")
+		}
+	})
+
+	t.Run("Retrieve", func(t *testing.T) {
+		got, err := client.RetrieveBatch(ctx, batch.ID)
+		if err != nil {
+			t.Fatalf("RetrieveBatch: %v", err)
+		}
+		if got.ID != batch.ID {
+			t.Errorf("Retrieved batch %q, want %q", got.ID, batch.ID)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		list, err := client.ListBatch(ctx, nil, nil)
+		if err != nil {
+			t.Fatalf("ListBatch: %v", err)
+		}
+		if !slices.ContainsFunc(list.Data, func(b openai.Batch) bool { return b.ID == batch.ID }) {
+			t.Errorf("Batch %s not listed", batch.ID)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		got, err := client.CancelBatch(ctx, batch.ID)
+		if err != nil {
+			t.Fatalf("CancelBatch: %v", err)
+		}
+		// A batch that finished before the cancel keeps its final status
+		switch got.Status {
+		case "cancelling", "cancelled", "completed", "failed", "expired":
+		default:
+			t.Errorf("Batch status %q after cancel", got.Status)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProbeCapabilities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/moderations":
+			w.WriteHeader(http.StatusBadRequest)
+		case "/v1/files":
+			w.Write([]byte(`{"data":[]}`))
+		case "/v1/batches":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	o := opts
+	o.Insecure = true
+	o.BaseURL = server.URL + "/v1"
+	o.Port = 0
+	status, err := probeCapabilities(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{"images": false, "speech": false, "transcriptions": false, "moderations": true, "files": true, "batches": false}
+	for name, present := range want {
+		if got := !capabilityAbsent(status[name]); got != present {
+			t.Errorf("%s present = %v (status %d), want %v", name, got, status[name], present)
+		}
+	}
+}
+
+func TestSilentWAV(t *testing.T) {
+	wav := silentWAV(500 * time.Millisecond)
+	if string(wav[:4]) != "RIFF" || string(wav[8:16]) != "WAVEfmt " || string(wav[36:40]) != "data" {
+		t.Fatalf("Bad WAV header %q", wav[:44])
+	}
+	if size := binary.LittleEndian.Uint32(wav[40:44]); int(size) != len(wav)-44 || size != 16000 {
+		t.Errorf("Data size %d, file %d bytes", size, len(wav))
+	}
+	if size := binary.LittleEndian.Uint32(wav[4:8]); int(size) != len(wav)-8 {
+		t.Errorf("RIFF size %d, file %d bytes", size, len(wav))
+	}
+}
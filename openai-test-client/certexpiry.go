@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+
+	"mtls"
+)
+
+// The client certificate and CA are checked for expiry before the tests
+// run: any within -cert-warn-days of expiry, or past it, is warned of, and
+// the report gives when each expires. -check-certs lists them and exits
+// non-zero if any is close to expiry, for cron jobs and CI.
+
+// clientCerts reads the certificates o has the client load: its own, with
+// any chain, and the CA that the server is verified against.
+func clientCerts(o Options) ([]mtls.CertExpiry, error) {
+	client, err := mtls.ReadCertExpiry("client", o.CertFile, o.KeyFile, o.KeyPass)
+	if err != nil {
+		return nil, fmt.Errorf("client certificate: %w", err)
+	}
+	ca, err := mtls.ReadCertExpiry("CA", o.CAFile, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("CA certificate: %w", err)
+	}
+	return append(client, ca...), nil
+}
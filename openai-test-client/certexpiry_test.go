@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCertExpiry(t *testing.T) {
+	// identity.p12 holds chain-client and its intermediate, issued with
+	// the CA in pkcs12-ca.crt, which expires in 2126
+	o := Options{
+		CertFile: filepath.Join("..", "mtls", "testdata", "identity.p12"),
+		KeyFile:  "pass:correct-horse",
+		CAFile:   filepath.Join("..", "mtls", "testdata", "pkcs12-ca.crt"),
+	}
+	certs, err := clientCerts(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 3 || certs[0].Use != "client" || !strings.Contains(certs[0].Subject, "CN=chain-client") || certs[2].Use != "CA" {
+		t.Fatalf("certificates: %+v", certs)
+	}
+	o.KeyFile = "pass:battery-staple"
+	if _, err := clientCerts(o); err == nil {
+		t.Error("bundle opened with the wrong passphrase")
+	}
+
+	// The report gives when each expires, in JUnit as properties
+	var junit bytes.Buffer
+	if err := writeJUnitReport(&junit, &Report{Certificates: certs[2:]}); err != nil {
+		t.Fatal(err)
+	}
+	if want := `<property name="certificate CA CN=MockOpenAI-CA,O=MockOpenAI" value="expires 2126-09-22T14:56:52Z">`; !strings.Contains(junit.String(), want) {
+		t.Errorf("JUnit report lacks %s:\n%s", want, junit.String())
+	}
+}
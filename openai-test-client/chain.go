@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"mtls"
+)
+
+// =============================================================================
+// Intermediate CA Chains
+// =============================================================================
+
+// testIntermediateCAChain checks certificate chain handling in both
+// directions, the most common cause of mTLS failures in real deployments.
+// The client subtests present certificates issued by an intermediate CA
+// under the trusted root: the server must accept a leaf sent with its
+// intermediate and reject one sent without it, since it trusts only the
+// root. ServerChain checks that the server sends its own chain complete and
+// in order, so that clients which do not fetch missing intermediates can
+// verify it.
+func testIntermediateCAChain(t *testing.T) {
+	setup(t)
+	requireMTLS(t)
+
+	serverOK := t.Run("ServerChain", func(t *testing.T) {
+		chain, err := serverChain()
+		if err != nil {
+			t.Fatalf("Failed to read the server certificate chain: %v", err)
+		}
+		names := make([]string, len(chain))
+		for i, cert := range chain {
+			names[i] = cert.Subject.CommonName
+		}
+		t.Logf("Server sent %d certificate(s): %s", len(chain), strings.Join(names, " -> "))
+
+		pool, err := mtls.LoadCAPool(opts.CAFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, problem := range serverChainProblems(chain, pool) {
+			t.Error(problem)
+		}
+	})
+	if !serverOK {
+		t.Skip("Client chains not tested: the client cannot verify the server")
+	}
+
+	root, err := mtls.LoadKeyPair(opts.CAFile, opts.CAKeyFile, opts.KeyPass)
+	if err != nil {
+		t.Skipf("CA key needed to issue an intermediate CA: %v", err)
+	}
+	now := time.Now()
+	intermediate, intermediateKey, err := issueIntermediateCA(root.Leaf, root.PrivateKey, "Test-Intermediate-CA", now.Add(-time.Hour), now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to issue intermediate CA: %v", err)
+	}
+	expired, expiredKey, err := issueIntermediateCA(root.Leaf, root.PrivateKey, "Expired-Intermediate-CA", now.Add(-48*time.Hour), now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to issue intermediate CA: %v", err)
+	}
+	leaf, err := issueClientCert(intermediate, intermediateKey, "intermediate-client", now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+	underExpired, err := issueClientCert(expired, expiredKey, "expired-intermediate-client", now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		cert   *tls.Certificate
+		alerts []string // nil if the server must accept the chain
+	}{
+		{"LeafAndIntermediate", withChain(leaf, intermediate), nil},
+		{"LeafIntermediateAndRoot", withChain(leaf, intermediate, root.Leaf), nil},
+		{"LeafOnly", leaf, alertsWrongCA},
+		{"ExpiredIntermediate", withChain(underExpired, expired), alertsExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.alerts != nil {
+				expectRejected(t, tt.cert, tt.alerts)
+				return
+			}
+			client, err := presentingClient(tt.cert)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.CloseIdleConnections()
+			status, err := listModelsStatus(client)
+			if err != nil {
+				t.Fatalf("Server rejected a certificate sent with its intermediate: %v", err)
+			}
+			if status != http.StatusOK {
+				t.Errorf("Status %d, want %d", status, http.StatusOK)
+			}
+		})
+	}
+}
+
+// serverChain returns the certificates the server sends, as sent. The chain
+// is not verified during the handshake, so that a broken one can be
+// reported in detail rather than as a handshake failure.
+func serverChain() ([]*x509.Certificate, error) {
+	transport, err := newTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer transport.CloseIdleConnections()
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	state, err := tlsState(&http.Client{Transport: withHostOverride(opts, transport), Timeout: 10 * time.Second}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return state.PeerCertificates, nil
+}
+
+// serverChainProblems describes what is wrong with a chain sent by a
+// server: certificates that are not signed by the one after them, and a
+// chain that does not verify against roots using only the intermediates it
+// contains.
+func serverChainProblems(chain []*x509.Certificate, roots *x509.CertPool) []string {
+	if len(chain) == 0 {
+		return []string{"Server sent no certificates"}
+	}
+	var problems []string
+	for i := 0; i+1 < len(chain); i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			problems = append(problems, fmt.Sprintf("Certificate %d (%s) is not signed by certificate %d (%s) after it; the chain is out of order or contains an unrelated certificate: %v",
+				i, chain[i].Subject.CommonName, i+1, chain[i+1].Subject.CommonName, err))
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	var unknown x509.UnknownAuthorityError
+	switch {
+	case errors.As(err, &unknown):
+		problems = append(problems, fmt.Sprintf("Chain does not reach a trusted root with the certificates sent; the server is probably not sending an intermediate for %s: %v",
+			chain[len(chain)-1].Issuer.CommonName, err))
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("Chain does not verify: %v", err))
+	}
+	return problems
+}
+
+// issueIntermediateCA issues a CA certificate valid from notBefore to
+// notAfter, signed by parent, that may sign only leaf certificates.
+func issueIntermediateCA(parent *x509.Certificate, parentKey any, name string, notBefore, notAfter time.Time) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"MockOpenAI"}, CommonName: name},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("signing certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// withChain returns a copy of cert that sends chain after the leaf.
+func withChain(cert *tls.Certificate, chain ...*x509.Certificate) *tls.Certificate {
+	out := *cert
+	out.Certificate = append([][]byte(nil), cert.Certificate...)
+	for _, c := range chain {
+		out.Certificate = append(out.Certificate, c.Raw)
+	}
+	return &out
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issueServerCert issues a server certificate for localhost signed by ca,
+// returning it and its key.
+func issueServerCert(t *testing.T, ca *x509.Certificate, caKey any) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestServerChainProblems(t *testing.T) {
+	root, rootKey, err := newTestCA("Chain-Root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediate, intermediateKey, err := issueIntermediateCA(root, rootKey, "Chain-Intermediate", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := issueServerCert(t, intermediate, intermediateKey)
+	direct, _ := issueServerCert(t, root, rootKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	tests := []struct {
+		name  string
+		chain []*x509.Certificate
+		want  []string // substrings of the expected problems, in order
+	}{
+		{"SignedByRoot", []*x509.Certificate{direct}, nil},
+		{"WithIntermediate", []*x509.Certificate{leaf, intermediate}, nil},
+		{"WithRoot", []*x509.Certificate{leaf, intermediate, root}, nil},
+		{"MissingIntermediate", []*x509.Certificate{leaf}, []string{"not sending an intermediate for Chain-Intermediate"}},
+		{"OutOfOrder", []*x509.Certificate{leaf, root, intermediate}, []string{"Certificate 0 (localhost) is not signed by certificate 1", "Certificate 1 (Chain-Root) is not signed by certificate 2"}},
+		{"Empty", nil, []string{"no certificates"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := serverChainProblems(tt.chain, roots)
+			if len(problems) != len(tt.want) {
+				t.Fatalf("Problems %q, want %d", problems, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(problems[i], want) {
+					t.Errorf("Problem %q, want it to mention %q", problems[i], want)
+				}
+			}
+		})
+	}
+}
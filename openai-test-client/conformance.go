@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Conformance Against the Real API
+// =============================================================================
+
+// ConformanceOptions configures -target real, which sends the same
+// non-destructive requests to the real OpenAI API and to the mock and
+// compares the shapes of the responses.
+type ConformanceOptions struct {
+	Target         string
+	RealBaseURL    string
+	RealAPIKey     string
+	ChatModel      string
+	EmbeddingModel string
+}
+
+func registerConformanceFlags(fs *flag.FlagSet) *ConformanceOptions {
+	o := &ConformanceOptions{}
+	fs.StringVar(&o.Target, "target", "mock", "mock: run the test suite; real: compare response shapes between the real API and the mock")
+	fs.StringVar(&o.RealBaseURL, "real-base-url", "https://api.openai.com/v1", "Base URL of the real API for -target real")
+	fs.StringVar(&o.RealAPIKey, "real-api-key", os.Getenv("OPENAI_API_KEY"), "API key for the real API (default $OPENAI_API_KEY)")
+	fs.StringVar(&o.ChatModel, "real-chat-model", "gpt-4o-mini", "Chat model requested from both APIs with -target real")
+	fs.StringVar(&o.EmbeddingModel, "real-embedding-model", "text-embedding-3-small", "Embedding model requested from both APIs with -target real")
+	return o
+}
+
+// probeRequest is one request sent to both APIs. None of them create or
+// delete anything.
+type probeRequest struct {
+	name   string
+	method string
+	path   string
+	body   any
+	stream bool
+}
+
+func conformanceProbes(o *ConformanceOptions) []probeRequest {
+	hello := []map[string]string{{"role": "user", "content": "Say hello."}}
+	return []probeRequest{
+		{name: "ListModels", method: http.MethodGet, path: "/models"},
+		{name: "GetModel", method: http.MethodGet, path: "/models/" + o.ChatModel},
+		{name: "GetModelNotFound", method: http.MethodGet, path: "/models/does-not-exist"},
+		{name: "ChatCompletion", method: http.MethodPost, path: "/chat/completions", body: map[string]any{
+			"model": o.ChatModel, "messages": hello, "max_tokens": 16,
+		}},
+		{name: "ChatCompletionWithTools", method: http.MethodPost, path: "/chat/completions", body: map[string]any{
+			"model":       o.ChatModel,
+			"messages":    []map[string]string{{"role": "user", "content": "What's the weather in Paris?"}},
+			"tools":       []any{weatherTool},
+			"tool_choice": "required",
+		}},
+		{name: "ChatCompletionStreaming", method: http.MethodPost, path: "/chat/completions", stream: true, body: map[string]any{
+			"model": o.ChatModel, "messages": hello, "max_tokens": 16, "stream": true,
+			"stream_options": map[string]bool{"include_usage": true},
+		}},
+		{name: "Embeddings", method: http.MethodPost, path: "/embeddings", body: map[string]any{
+			"model": o.EmbeddingModel, "input": "Hello, world!",
+		}},
+		{name: "ErrorMissingModel", method: http.MethodPost, path: "/chat/completions", body: map[string]any{
+			"messages": hello,
+		}},
+	}
+}
+
+// ProbeResult compares one probe's responses.
+type ProbeResult struct {
+	Name       string   `json:"name"`
+	RealStatus int      `json:"real_status"`
+	MockStatus int      `json:"mock_status"`
+	Error      string   `json:"error,omitempty"`
+	Missing    []string `json:"missing,omitempty"`  // in the real response only
+	Extra      []string `json:"extra,omitempty"`    // in the mock response only
+	Mismatch   []string `json:"mismatch,omitempty"` // types differ
+}
+
+// Drifted reports whether the mock's response no longer matches the real
+// one. Extra mock fields are reported but do not count as drift.
+func (r ProbeResult) Drifted() bool {
+	return r.Error != "" || r.RealStatus != r.MockStatus || len(r.Missing) > 0 || len(r.Mismatch) > 0
+}
+
+// ConformanceReport is the result of -target real.
+type ConformanceReport struct {
+	Real      string        `json:"real"`
+	Mock      string        `json:"mock"`
+	Timestamp time.Time     `json:"timestamp"`
+	Drifted   int           `json:"drifted"`
+	Probes    []ProbeResult `json:"probes"`
+}
+
+// apiEndpoint is where and how to send probe requests.
+type apiEndpoint struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func runConformance(log io.Writer, o Options, co *ConformanceOptions) (*ConformanceReport, error) {
+	if co.RealAPIKey == "" {
+		return nil, fmt.Errorf("-target real needs an API key: set OPENAI_API_KEY or -real-api-key")
+	}
+
+	mockTransport, err := newTransport(o)
+	if err != nil {
+		return nil, err
+	}
+	mock := apiEndpoint{
+		baseURL: o.apiBaseURL(),
+		apiKey:  o.APIKey,
+		client:  &http.Client{Transport: withHostOverride(o, mockTransport), Timeout: o.Timeout},
+	}
+
+	// The real API uses the system roots and no client certificate
+	realTransport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if o.ProxyURL != "" {
+		proxy, err := url.Parse(o.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
+		}
+		realTransport.Proxy = http.ProxyURL(proxy)
+	}
+	real := apiEndpoint{
+		baseURL: co.RealBaseURL,
+		apiKey:  co.RealAPIKey,
+		client:  &http.Client{Transport: realTransport, Timeout: o.Timeout},
+	}
+
+	report := &ConformanceReport{Real: real.baseURL, Mock: mock.baseURL, Timestamp: time.Now()}
+	fmt.Fprintf(log, "Comparing %s against %s\n", mock.baseURL, real.baseURL)
+
+	for _, probe := range conformanceProbes(co) {
+		result := compareProbe(probe, real, mock)
+		if result.Drifted() {
+			report.Drifted++
+		}
+		report.Probes = append(report.Probes, result)
+		fmt.Fprintf(log, "  %-26s real %d, mock %d\n", probe.name, result.RealStatus, result.MockStatus)
+	}
+	return report, nil
+}
+
+func compareProbe(probe probeRequest, real, mock apiEndpoint) ProbeResult {
+	result := ProbeResult{Name: probe.name}
+
+	realStatus, realShape, err := fetchShape(probe, real)
+	result.RealStatus = realStatus
+	if err != nil {
+		result.Error = "real: " + err.Error()
+		return result
+	}
+	mockStatus, mockShape, err := fetchShape(probe, mock)
+	result.MockStatus = mockStatus
+	if err != nil {
+		result.Error = "mock: " + err.Error()
+		return result
+	}
+
+	for path, realTypes := range realShape {
+		mockTypes, ok := mockShape[path]
+		if !ok {
+			result.Missing = append(result.Missing, fmt.Sprintf("%s (%s)", path, realTypes))
+			continue
+		}
+		if !compatible(realTypes, mockTypes) {
+			result.Mismatch = append(result.Mismatch, fmt.Sprintf("%s: real %s, mock %s", path, realTypes, mockTypes))
+		}
+	}
+	for path, mockTypes := range mockShape {
+		if _, ok := realShape[path]; !ok {
+			result.Extra = append(result.Extra, fmt.Sprintf("%s (%s)", path, mockTypes))
+		}
+	}
+	sort.Strings(result.Missing)
+	sort.Strings(result.Extra)
+	sort.Strings(result.Mismatch)
+	return result
+}
+
+// fetchShape sends probe to api and returns the status and the shape of the
+// JSON response. For streams the shape is the union over all chunks.
+func fetchShape(probe probeRequest, api apiEndpoint) (int, jsonShape, error) {
+	var body io.Reader
+	if probe.body != nil {
+		payload, err := json.Marshal(probe.body)
+		if err != nil {
+			return 0, nil, err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(probe.method, strings.TrimSuffix(api.baseURL, "/")+probe.path, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+api.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	shape := make(jsonShape)
+	if probe.stream && resp.StatusCode == http.StatusOK {
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok || data == "[DONE]" {
+				continue
+			}
+			var chunk any
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				return resp.StatusCode, nil, fmt.Errorf("invalid chunk: %w", err)
+			}
+			shape.add("", chunk)
+		}
+		return resp.StatusCode, shape, scanner.Err()
+	}
+
+	var value any
+	if err := json.NewDecoder(resp.Body).Decode(&value); err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("invalid JSON response: %w", err)
+	}
+	shape.add("", value)
+	return resp.StatusCode, shape, nil
+}
+
+// jsonShape maps each path in a JSON document, such as
+// "choices[].message.content", to the types seen there.
+type jsonShape map[string]typeSet
+
+type typeSet map[string]bool
+
+func (s typeSet) String() string {
+	types := make([]string, 0, len(s))
+	for t := range s {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return strings.Join(types, "|")
+}
+
+func (s jsonShape) add(path string, value any) {
+	var kind string
+	switch v := value.(type) {
+	case nil:
+		kind = "null"
+	case bool:
+		kind = "boolean"
+	case float64:
+		kind = "number"
+	case string:
+		kind = "string"
+	case []any:
+		kind = "array"
+		for _, elem := range v {
+			s.add(path+"[]", elem)
+		}
+	case map[string]any:
+		kind = "object"
+		for key, elem := range v {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			s.add(child, elem)
+		}
+	}
+	if path == "" {
+		return
+	}
+	if s[path] == nil {
+		s[path] = make(typeSet)
+	}
+	s[path][kind] = true
+}
+
+// compatible reports whether two type sets share a type, treating null as
+// compatible with anything since optional fields are often null in one
+// response and set in the other.
+func compatible(a, b typeSet) bool {
+	if a["null"] || b["null"] {
+		return true
+	}
+	for t := range a {
+		if b[t] {
+			return true
+		}
+	}
+	return false
+}
+
+func printConformanceReport(w io.Writer, report *ConformanceReport) {
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, strings.Repeat("=", 60))
+	fmt.Fprintf(w, "%s%s                 CONFORMANCE REPORT%s\n", colorBold, colorCyan, colorReset)
+	fmt.Fprintln(w, strings.Repeat("=", 60))
+
+	for _, r := range report.Probes {
+		status := colorGreen + "OK" + colorReset
+		if r.Drifted() {
+			status = colorRed + "DRIFT" + colorReset
+		}
+		fmt.Fprintf(w, "\n%s: %s\n", r.Name, status)
+		if r.Error != "" {
+			fmt.Fprintf(w, "  %sError: %s%s\n", colorRed, r.Error, colorReset)
+		}
+		if r.RealStatus != r.MockStatus {
+			fmt.Fprintf(w, "  %sStatus: real %d, mock %d%s\n", colorRed, r.RealStatus, r.MockStatus, colorReset)
+		}
+		for _, field := range r.Missing {
+			fmt.Fprintf(w, "  %s- missing in mock: %s%s\n", colorRed, field, colorReset)
+		}
+		for _, field := range r.Mismatch {
+			fmt.Fprintf(w, "  %s~ type differs: %s%s\n", colorRed, field, colorReset)
+		}
+		for _, field := range r.Extra {
+			fmt.Fprintf(w, "  %s+ only in mock: %s%s\n", colorYellow, field, colorReset)
+		}
+	}
+
+	fmt.Fprintln(w)
+	if report.Drifted == 0 {
+		fmt.Fprintf(w, "%s%sNo schema drift.%s\n", colorBold, colorGreen, colorReset)
+	} else {
+		fmt.Fprintf(w, "%s%s%d of %d responses drifted from the real API.%s\n", colorBold, colorRed, report.Drifted, len(report.Probes), colorReset)
+	}
+	fmt.Fprintln(w, strings.Repeat("=", 60))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestJSONShape(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want map[string]string
+	}{
+		{
+			name: "Scalars",
+			json: `{"id":"x","n":1,"ok":true,"none":null}`,
+			want: map[string]string{"id": "string", "n": "number", "ok": "boolean", "none": "null"},
+		},
+		{
+			name: "NestedArrays",
+			json: `{"choices":[{"message":{"content":"hi"}},{"message":{"content":null}}]}`,
+			want: map[string]string{
+				"choices":                   "array",
+				"choices[]":                 "object",
+				"choices[].message":         "object",
+				"choices[].message.content": "null|string",
+			},
+		},
+		{
+			name: "EmptyArray",
+			json: `{"data":[]}`,
+			want: map[string]string{"data": "array"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value any
+			if err := json.Unmarshal([]byte(tt.json), &value); err != nil {
+				t.Fatal(err)
+			}
+			shape := make(jsonShape)
+			shape.add("", value)
+
+			got := make(map[string]string)
+			for path, types := range shape {
+				got[path] = types.String()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("shape = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	tests := []struct {
+		a, b typeSet
+		want bool
+	}{
+		{typeSet{"string": true}, typeSet{"string": true}, true},
+		{typeSet{"string": true}, typeSet{"number": true}, false},
+		{typeSet{"null": true}, typeSet{"object": true}, true},
+		{typeSet{"string": true, "number": true}, typeSet{"number": true}, true},
+	}
+	for _, tt := range tests {
+		if got := compatible(tt.a, tt.b); got != tt.want {
+			t.Errorf("compatible(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// fakeAPI serves body for every request, as an SSE stream when the request
+// asks for one.
+func fakeAPI(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(payload), `"stream":true`) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: "+body+"\n\ndata: [DONE]\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+}
+
+func TestRunConformance(t *testing.T) {
+	tests := []struct {
+		name         string
+		real, mock   string
+		wantDrifted  int
+		wantMissing  []string
+		wantMismatch []string
+		wantExtra    []string
+	}{
+		{
+			name:        "Identical",
+			real:        `{"id":"x","usage":{"total_tokens":3}}`,
+			mock:        `{"id":"y","usage":{"total_tokens":5}}`,
+			wantDrifted: 0,
+		},
+		{
+			name:        "NullMatchesAnything",
+			real:        `{"id":"x","refusal":null}`,
+			mock:        `{"id":"x","refusal":"no"}`,
+			wantDrifted: 0,
+		},
+		{
+			name:        "MissingField",
+			real:        `{"id":"x","service_tier":"default"}`,
+			mock:        `{"id":"x"}`,
+			wantDrifted: len(conformanceProbes(&ConformanceOptions{})),
+			wantMissing: []string{"service_tier (string)"},
+		},
+		{
+			name:         "TypeMismatch",
+			real:         `{"created":1}`,
+			mock:         `{"created":"1"}`,
+			wantDrifted:  len(conformanceProbes(&ConformanceOptions{})),
+			wantMismatch: []string{"created: real number, mock string"},
+		},
+		{
+			name:        "ExtraFieldIsNotDrift",
+			real:        `{"id":"x"}`,
+			mock:        `{"id":"x","debug":{}}`,
+			wantDrifted: 0,
+			wantExtra:   []string{"debug (object)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			real := fakeAPI(tt.real)
+			defer real.Close()
+			mock := fakeAPI(tt.mock)
+			defer mock.Close()
+
+			o := Options{Insecure: true, BaseURL: mock.URL + "/v1", APIKey: "mock"}
+			co := &ConformanceOptions{RealBaseURL: real.URL + "/v1", RealAPIKey: "real", ChatModel: "gpt-4o-mini", EmbeddingModel: "text-embedding-3-small"}
+			report, err := runConformance(io.Discard, o, co)
+			if err != nil {
+				t.Fatalf("runConformance: %v", err)
+			}
+
+			if report.Drifted != tt.wantDrifted {
+				t.Errorf("Drifted = %d, want %d", report.Drifted, tt.wantDrifted)
+			}
+			for _, probe := range report.Probes {
+				if probe.Error != "" {
+					t.Errorf("%s: %s", probe.Name, probe.Error)
+				}
+				check(t, probe.Name+" missing", probe.Missing, tt.wantMissing)
+				check(t, probe.Name+" mismatch", probe.Mismatch, tt.wantMismatch)
+				check(t, probe.Name+" extra", probe.Extra, tt.wantExtra)
+			}
+		})
+	}
+}
+
+func TestRunConformanceNeedsKey(t *testing.T) {
+	if _, err := runConformance(io.Discard, Options{Insecure: true}, &ConformanceOptions{}); err == nil {
+		t.Error("Expected an error without a real API key")
+	}
+}
+
+func check(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	sort.Strings(want)
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %q, want %q", what, got, want)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// TLS Diagnostics
+// =============================================================================
+
+// TLSInfo describes the TLS connection negotiated with the target.
+type TLSInfo struct {
+	ServerName    string     `json:"server_name"`
+	Version       string     `json:"version"`
+	CipherSuite   string     `json:"cipher_suite"`
+	ALPN          string     `json:"alpn,omitempty"`
+	Resumed       bool       `json:"resumed"`
+	Chain         []CertInfo `json:"chain"`
+	VerifiedChain []string   `json:"verified_chain,omitempty"`
+
+	// Handshake outcomes from -tls-matrix
+	Matrix []TLSCombination `json:"matrix,omitempty"`
+}
+
+// CertInfo describes a certificate in the server's chain.
+type CertInfo struct {
+	Subject  string    `json:"subject"`
+	Issuer   string    `json:"issuer"`
+	NotAfter time.Time `json:"not_after"`
+	SANs     []string  `json:"sans,omitempty"`
+}
+
+// collectTLSInfo connects to the target twice, on separate connections
+// sharing a session cache, and returns what was negotiated: the TLS
+// version, cipher suite, ALPN protocol, server certificate chain and
+// whether the second connection resumed the first one's session.
+func collectTLSInfo(o Options) (*TLSInfo, error) {
+	transport, err := newTransport(o)
+	if err != nil {
+		return nil, err
+	}
+	transport.DisableKeepAlives = true
+	transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(4)
+	// Offer HTTP/2 as well as HTTP/1.1, as browsers and most SDKs do, so
+	// that the ALPN line reports what the server selects. A custom
+	// TLSClientConfig otherwise disables HTTP/2 and offers no protocols.
+	transport.TLSClientConfig.NextProtos = []string{"h2", "http/1.1"}
+	transport.ForceAttemptHTTP2 = true
+	client := &http.Client{Transport: withHostOverride(o, transport), Timeout: 10 * time.Second}
+
+	first, err := tlsState(client, o)
+	if err != nil {
+		return nil, err
+	}
+	second, err := tlsState(client, o)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &TLSInfo{
+		ServerName:  first.ServerName,
+		Version:     tls.VersionName(first.Version),
+		CipherSuite: tls.CipherSuiteName(first.CipherSuite),
+		ALPN:        first.NegotiatedProtocol,
+		Resumed:     second.DidResume,
+	}
+	for _, cert := range first.PeerCertificates {
+		info.Chain = append(info.Chain, certInfo(cert))
+	}
+	if len(first.VerifiedChains) > 0 {
+		for _, cert := range first.VerifiedChains[0] {
+			info.VerifiedChain = append(info.VerifiedChain, cert.Subject.CommonName)
+		}
+	}
+	return info, nil
+}
+
+func certInfo(cert *x509.Certificate) CertInfo {
+	info := CertInfo{
+		Subject:  cert.Subject.String(),
+		Issuer:   cert.Issuer.String(),
+		NotAfter: cert.NotAfter,
+		SANs:     append([]string(nil), cert.DNSNames...),
+	}
+	for _, ip := range cert.IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+	return info
+}
+
+// printTLSDiagnostics reports the TLS parameters negotiated with the target.
+func printTLSDiagnostics(w io.Writer, info *TLSInfo) {
+	alpn := info.ALPN
+	if alpn == "" {
+		alpn = "(none)"
+	}
+
+	fmt.Fprintf(w, "%s%sTLS Diagnostics%s\n", colorBold, colorCyan, colorReset)
+	fmt.Fprintf(w, "  Server name:   %s\n", info.ServerName)
+	fmt.Fprintf(w, "  Version:       %s\n", info.Version)
+	fmt.Fprintf(w, "  Cipher suite:  %s\n", info.CipherSuite)
+	fmt.Fprintf(w, "  ALPN protocol: %s\n", alpn)
+	fmt.Fprintf(w, "  Resumption:    %s\n", resumption(info.Resumed))
+	fmt.Fprintln(w, "  Server certificate chain:")
+	for i, cert := range info.Chain {
+		printCertificate(w, i, cert)
+	}
+	if len(info.VerifiedChain) > 0 {
+		fmt.Fprintf(w, "  Verified chain: %s\n", strings.Join(info.VerifiedChain, " -> "))
+	}
+	fmt.Fprintln(w)
+}
+
+// tlsState makes one request and returns the state of its TLS connection.
+func tlsState(client *http.Client, o Options) (*tls.ConnectionState, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(o.apiBaseURL(), "/")+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+o.APIKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.TLS == nil {
+		return nil, fmt.Errorf("connection to %s did not use TLS", req.URL.Host)
+	}
+	return resp.TLS, nil
+}
+
+// explainTLSError says what err, from connecting to the target with o,
+// most likely means and what to check, or returns "" if it is not a TLS
+// failure it recognises.
+func explainTLSError(err error, o Options) string {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &unknownAuthority):
+		ca := "the system roots"
+		if o.CAFile != "" {
+			ca = o.CAFile
+		}
+		return fmt.Sprintf("The server's certificate was not issued by a CA in %s. Check that -ca is the CA that issued it, and that the server sends any intermediate certificates.", ca)
+	case errors.As(err, &hostname):
+		names := certInfo(hostname.Certificate).SANs
+		if len(names) == 0 {
+			names = []string{"no names"}
+		}
+		return fmt.Sprintf("The server's certificate is for %s, not %s. Connect with a name it lists, or give one with -host-override.", strings.Join(names, ", "), hostname.Host)
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return fmt.Sprintf("The server's certificate is outside its validity period: %s.", invalid.Detail)
+	}
+
+	// The server's alerts arrive as errors naming them. A client
+	// certificate not issued by a CA the server asks for is not sent.
+	for _, alert := range slices.Concat(alertsNoCert, alertsWrongCA, alertsExpired) {
+		if strings.Contains(err.Error(), "remote error: tls: "+alert) {
+			return fmt.Sprintf("The server did not accept a client certificate (%s). Check that -cert and -key are a pair, that they are unexpired, and that they were issued by a CA the server trusts: one not issued by a CA the server asks for is not sent.", alert)
+		}
+	}
+	return ""
+}
+
+func resumption(resumed bool) string {
+	if resumed {
+		return "yes (second connection resumed the session)"
+	}
+	return "no (server did not resume the session)"
+}
+
+func printCertificate(w io.Writer, index int, cert CertInfo) {
+	remaining := time.Until(cert.NotAfter)
+	expiry := fmt.Sprintf("%s (%d days)", cert.NotAfter.Format("2006-01-02"), int(remaining.Hours()/24))
+	if remaining < 0 {
+		expiry = fmt.Sprintf("%s (%sEXPIRED%s)", cert.NotAfter.Format("2006-01-02"), colorRed, colorReset)
+	} else if remaining < 30*24*time.Hour {
+		expiry = colorYellow + expiry + colorReset
+	}
+
+	fmt.Fprintf(w, "    [%d] Subject: %s\n", index, cert.Subject)
+	fmt.Fprintf(w, "        Issuer:  %s\n", cert.Issuer)
+	fmt.Fprintf(w, "        Expires: %s\n", expiry)
+	if len(cert.SANs) > 0 {
+		fmt.Fprintf(w, "        SANs:    %s\n", strings.Join(cert.SANs, ", "))
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mtlsServer starts a TLS server for handler with a certificate for
+// localhost issued by ca, that requires client certificates issued by ca
+// too.
+func mtlsServer(t *testing.T, ca *x509.Certificate, caKey any, handler http.Handler) *httptest.Server {
+	t.Helper()
+	cert, key := issueServerCert(t, ca, caKey)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	srv := httptest.NewUnstartedServer(handler)
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	// Rejected handshakes are the point of some tests
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// writeClientIdentity writes a client certificate issued by clientCA to
+// dir, and ca as the CA to verify the server with, returning Options that
+// use them.
+func writeClientIdentity(t *testing.T, dir string, ca, clientCA *x509.Certificate, clientCAKey any) Options {
+	t.Helper()
+	cert, err := issueClientCert(clientCA, clientCAKey, "diagnosis-client", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := Options{
+		CertFile: filepath.Join(dir, "client.crt"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+		CAFile:   filepath.Join(dir, "ca.crt"),
+	}
+	if err := writeCertFiles(o.CertFile, o.KeyFile, cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(o.CAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestTLSDiagnosis(t *testing.T) {
+	ca, caKey, err := newTestCA("Diagnosis CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, otherKey, err := newTestCA("Other CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := mtlsServer(t, ca, caKey, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"object":"list","data":[]}`)
+	}))
+	localhost := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1) + "/v1"
+
+	o := writeClientIdentity(t, t.TempDir(), ca, ca, caKey)
+	o.BaseURL = localhost
+	info, err := collectTLSInfo(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ServerName != "localhost" || len(info.Chain) != 1 || len(info.VerifiedChain) != 2 || info.VerifiedChain[1] != "Diagnosis CA" {
+		t.Errorf("info = %+v", info)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		baseURL string
+		// The CA the client verifies the server with, and the one that
+		// issued its certificate
+		ca, clientCA *x509.Certificate
+		clientCAKey  any
+		want         string
+	}{
+		{"wrong CA", localhost, other, ca, caKey, "not issued by a CA in"},
+		{"missing client certificate", localhost, ca, other, otherKey, "did not accept a client certificate (certificate required)"},
+		{"hostname mismatch", srv.URL + "/v1", ca, ca, caKey, "is for localhost, not 127.0.0.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o := writeClientIdentity(t, t.TempDir(), tc.ca, tc.clientCA, tc.clientCAKey)
+			o.BaseURL = tc.baseURL
+			_, err := collectTLSInfo(o)
+			if err == nil {
+				t.Fatal("diagnosis succeeded")
+			}
+			if why := explainTLSError(err, o); !strings.Contains(why, tc.want) {
+				t.Errorf("%v explained as %q, want %q", err, why, tc.want)
+			}
+		})
+	}
+}
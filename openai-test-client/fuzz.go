@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// =============================================================================
+// Malformed-Request Fuzzing
+// =============================================================================
+
+// fuzzEndpoint is an endpoint that accepts a JSON body, with a valid body
+// that random mutations start from.
+type fuzzEndpoint struct {
+	path   string
+	header http.Header
+	valid  string
+}
+
+var fuzzEndpoints = []fuzzEndpoint{
+	{path: "/chat/completions", valid: `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"Hello"}],"max_tokens":16}`},
+	{path: "/embeddings", valid: `{"model":"text-embedding-3-small","input":["Hello","world"]}`},
+	{path: "/responses", valid: `{"model":"gpt-4o-mini","input":"Hello"}`},
+	{path: "/assistants", header: http.Header{"OpenAI-Beta": {"assistants=v2"}}, valid: `{"model":"gpt-4o-mini","name":"fuzz"}`},
+	{path: "/threads", header: http.Header{"OpenAI-Beta": {"assistants=v2"}}, valid: `{"messages":[{"role":"user","content":"Hello"}]}`},
+}
+
+// fuzzCase is one malformed request. An empty contentType sends
+// application/json.
+type fuzzCase struct {
+	name        string
+	contentType string
+	body        func() []byte
+}
+
+func fixed(s string) func() []byte { return func() []byte { return []byte(s) } }
+
+// fuzzCases are sent to every endpoint.
+var fuzzCases = []fuzzCase{
+	{name: "Empty", body: fixed("")},
+	{name: "Whitespace", body: fixed(" \n\t ")},
+	{name: "Truncated", body: fixed(`{"model":"gpt-4o-mini","messages":[{"role":`)},
+	{name: "Array", body: fixed(`[]`)},
+	{name: "String", body: fixed(`"hello"`)},
+	{name: "Number", body: fixed(`42`)},
+	{name: "Null", body: fixed(`null`)},
+	{name: "TrailingGarbage", body: fixed(`{"model":"gpt-4o-mini"} }{`)},
+	{name: "TrailingComma", body: fixed(`{"model":"gpt-4o-mini",}`)},
+	{name: "SingleQuotes", body: fixed(`{'model':'gpt-4o-mini'}`)},
+	{name: "WrongTypes", body: fixed(`{"model":123,"messages":"hi","input":{"a":1},"max_tokens":"many","stream":"yes"}`)},
+	{name: "WrongNestedTypes", body: fixed(`{"model":"gpt-4o-mini","messages":[{"role":5,"content":{"x":[]}}],"input":[1,[2]]}`)},
+	{name: "NullFields", body: fixed(`{"model":null,"messages":null,"input":null,"tools":null}`)},
+	{name: "HugeNumbers", body: fixed(`{"model":"gpt-4o-mini","messages":[{"role":"user","content":"x"}],"n":1e999,"max_tokens":-99999999999999999999,"temperature":1e308}`)},
+	{name: "InvalidEscape", body: fixed(`{"model":"gpt-4o-mini","messages":[{"role":"user","content":"\x41\q"}]}`)},
+	{name: "LoneSurrogate", body: fixed(`{"model":"gpt-4o-mini","messages":[{"role":"user","content":"\ud800"}],"input":"\udfff"}`)},
+	{name: "InvalidUTF8", body: fixed("{\"model\":\"gpt-4o-mini\",\"messages\":[{\"role\":\"user\",\"content\":\"\xff\xfe\xc3\x28\"}],\"input\":\"\xed\xa0\x80\"}")},
+	{name: "InvalidUTF8Keys", body: fixed("{\"\xff\":1,\"model\":\"gpt-4o-mini\"}")},
+	{name: "NulBytes", body: fixed("{\"model\":\"gpt-4o-mini\x00\",\x00\"input\":\"a\"}")},
+	{name: "DeepNesting", body: func() []byte {
+		return []byte(`{"model":"gpt-4o-mini","input":` + strings.Repeat("[", 100000) + strings.Repeat("]", 100000) + `}`)
+	}},
+	{name: "HugeBody", body: func() []byte {
+		return []byte(`{"model":"gpt-4o-mini","messages":[{"role":"user","content":"` + strings.Repeat("a", 8<<20) + `"}],"input":"x"}`)
+	}},
+	{name: "ManyKeys", body: func() []byte {
+		var b strings.Builder
+		b.WriteString(`{"model":"gpt-4o-mini"`)
+		for i := 0; i < 100000; i++ {
+			fmt.Fprintf(&b, `,"k%d":%d`, i, i)
+		}
+		b.WriteString("}")
+		return []byte(b.String())
+	}},
+	{name: "TextPlain", contentType: "text/plain", body: fixed(`not json at all`)},
+	{name: "FormEncoded", contentType: "application/x-www-form-urlencoded", body: fixed(`model=gpt-4o-mini&input=hello`)},
+	{name: "Multipart", contentType: "multipart/form-data; boundary=x", body: fixed("--x\r\nContent-Disposition: form-data; name=\"model\"\r\n\r\ngpt-4o-mini\r\n--x--\r\n")},
+	{name: "XML", contentType: "application/xml", body: fixed(`<model>gpt-4o-mini</model>`)},
+	{name: "InvalidContentType", contentType: ";;;/", body: fixed(`{`)},
+}
+
+// testMalformedRequests sends every fuzz case, and -fuzz-count random
+// mutations of a valid body, to each endpoint. Whatever the input, the
+// server must answer in time, never with a 5xx, and every error must be an
+// OpenAI error object.
+func testMalformedRequests(t *testing.T) {
+	ctx, _ := setup(t)
+
+	seed := opts.FuzzSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("Mutation seed %d (repeat with -fuzz-seed %d)", seed, seed)
+
+	for _, endpoint := range fuzzEndpoints {
+		t.Run(strings.ReplaceAll(strings.Trim(endpoint.path, "/"), "/", "_"), func(t *testing.T) {
+			t.Parallel()
+			transport, err := newTransport(opts)
+			if err != nil {
+				t.Fatalf("Failed to configure transport: %v", err)
+			}
+			defer transport.CloseIdleConnections()
+			client := &http.Client{Transport: withHostOverride(opts, transport)}
+
+			for _, fc := range fuzzCases {
+				checkFuzzResponse(t, ctx, client, endpoint, fc.name, fc.contentType, fc.body())
+			}
+
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < opts.FuzzCount; i++ {
+				body := mutate(rng, []byte(endpoint.valid))
+				checkFuzzResponse(t, ctx, client, endpoint, fmt.Sprintf("Mutation%d", i), "", body)
+			}
+		})
+	}
+}
+
+// checkFuzzResponse sends body to endpoint and reports a failure if the
+// server hangs, returns a 5xx, or returns an error that is not an OpenAI
+// error object.
+func checkFuzzResponse(t *testing.T, ctx context.Context, client *http.Client, endpoint fuzzEndpoint, name, contentType string, body []byte) {
+	t.Helper()
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.FuzzTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(opts.apiBaseURL(), "/")+endpoint.path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+opts.APIKey)
+	req.Header.Set("Content-Type", contentType)
+	for name, values := range endpoint.header {
+		req.Header[name] = values
+	}
+
+	resp, err := client.Do(req)
+	if err == nil {
+		defer resp.Body.Close()
+		var data []byte
+		data, err = io.ReadAll(resp.Body)
+		if err == nil {
+			if problem := fuzzResponseProblem(resp, data); problem != "" {
+				t.Errorf("%s %s: %s (body %s)", endpoint.path, name, problem, truncate(fmt.Sprintf("%q", body), 120))
+			}
+			return
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("%s %s: no response within %v", endpoint.path, name, opts.FuzzTimeout)
+	} else {
+		t.Errorf("%s %s: %v", endpoint.path, name, err)
+	}
+}
+
+// fuzzResponseProblem describes what is wrong with a response to a
+// malformed request, or returns "" if nothing is.
+func fuzzResponseProblem(resp *http.Response, data []byte) string {
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	if resp.StatusCode >= 500 {
+		return fmt.Sprintf("server error %d: %s", resp.StatusCode, truncate(string(data), 200))
+	}
+	if resp.StatusCode < 400 {
+		// Accepted despite the damage; the response must still be valid
+		if mediaType == "text/event-stream" || json.Valid(data) {
+			return ""
+		}
+		return fmt.Sprintf("status %d with a body that is not JSON: %s", resp.StatusCode, truncate(string(data), 200))
+	}
+
+	if mediaType != "application/json" {
+		return fmt.Sprintf("status %d with Content-Type %q, want application/json", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	var errResp struct {
+		Error *struct {
+			Message *string `json:"message"`
+			Type    *string `json:"type"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &errResp); err != nil {
+		return fmt.Sprintf("status %d with a body that is not JSON: %s", resp.StatusCode, truncate(string(data), 200))
+	}
+	if errResp.Error == nil || errResp.Error.Message == nil || *errResp.Error.Message == "" || errResp.Error.Type == nil {
+		return fmt.Sprintf("status %d without an error object with a message and type: %s", resp.StatusCode, truncate(string(data), 200))
+	}
+	return ""
+}
+
+// mutate applies one to four random byte-level edits to a copy of body:
+// flipping, deleting, duplicating or inserting structural bytes.
+func mutate(rng *rand.Rand, body []byte) []byte {
+	out := append([]byte(nil), body...)
+	const structural = `{}[]:,"\ ` + "\x00\xff"
+	for n := 1 + rng.Intn(4); n > 0 && len(out) > 0; n-- {
+		i := rng.Intn(len(out))
+		switch rng.Intn(4) {
+		case 0:
+			out[i] ^= byte(1 << rng.Intn(8))
+		case 1:
+			out = append(out[:i], out[i+1:]...)
+		case 2:
+			j := i + rng.Intn(len(out)-i)
+			out = append(out[:j], append(append([]byte(nil), out[i:j]...), out[j:]...)...)
+		case 3:
+			out = append(out[:i], append([]byte{structural[rng.Intn(len(structural))]}, out[i:]...)...)
+		}
+	}
+	return out
+}
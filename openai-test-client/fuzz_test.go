@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFuzzResponseProblem(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		want        string
+	}{
+		{"Error", 400, "application/json", `{"error":{"message":"bad","type":"invalid_request_error","param":null,"code":null}}`, ""},
+		{"ErrorWithCharset", 404, "application/json; charset=utf-8", `{"error":{"message":"bad","type":"invalid_request_error"}}`, ""},
+		{"Accepted", 200, "application/json", `{"id":"x"}`, ""},
+		{"AcceptedStream", 200, "text/event-stream", "data: {}\n\n", ""},
+		{"ServerError", 500, "application/json", `{"error":{"message":"boom","type":"server_error"}}`, "server error 500"},
+		{"AcceptedNotJSON", 200, "application/json", `{`, "not JSON"},
+		{"PlainTextError", 400, "text/plain", `bad request`, `Content-Type "text/plain"`},
+		{"ErrorNotJSON", 400, "application/json", `bad`, "not JSON"},
+		{"NoErrorObject", 400, "application/json", `{"message":"bad"}`, "without an error object"},
+		{"EmptyMessage", 400, "application/json", `{"error":{"message":"","type":"invalid_request_error"}}`, "without an error object"},
+		{"NoType", 400, "application/json", `{"error":{"message":"bad"}}`, "without an error object"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{"Content-Type": {tt.contentType}}}
+			got := fuzzResponseProblem(resp, []byte(tt.body))
+			if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+				t.Errorf("fuzzResponseProblem = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutate(t *testing.T) {
+	valid := []byte(fuzzEndpoints[0].valid)
+
+	a := mutate(rand.New(rand.NewSource(7)), valid)
+	b := mutate(rand.New(rand.NewSource(7)), valid)
+	if !bytes.Equal(a, b) {
+		t.Error("mutate is not deterministic for a seed")
+	}
+	if string(valid) != fuzzEndpoints[0].valid {
+		t.Error("mutate modified its input")
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	changed := 0
+	for i := 0; i < 100; i++ {
+		if !bytes.Equal(mutate(rng, valid), valid) {
+			changed++
+		}
+	}
+	if changed < 90 {
+		t.Errorf("only %d of 100 mutations changed the body", changed)
+	}
+}
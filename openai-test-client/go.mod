@@ -2,4 +2,16 @@ module openai-test-client
 
 go 1.25.1
 
-require github.com/sashabaranov/go-openai v1.41.2
+require (
+	github.com/sashabaranov/go-openai v1.41.2
+	golang.org/x/term v0.37.0
+	gopkg.in/yaml.v3 v3.0.1
+	mtls v0.0.0
+)
+
+require (
+	github.com/miekg/pkcs11 v1.1.1 // indirect
+	golang.org/x/sys v0.38.0 // indirect
+)
+
+replace mtls => ../mtls
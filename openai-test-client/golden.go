@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// =============================================================================
+// Golden Files
+// =============================================================================
+
+// golden is the recorded structure of one response: its status and the type
+// of every JSON path, but not the values, which vary from run to run.
+type golden struct {
+	Status int               `json:"status"`
+	Shape  map[string]string `json:"shape"`
+}
+
+// testGoldenShapes sends the conformance probes to the target and compares
+// the structure of each response with testdata/golden/<probe>.json. With
+// -update the files are rewritten from the responses instead.
+func testGoldenShapes(t *testing.T) {
+	setup(t)
+
+	transport, err := newTransport(opts)
+	if err != nil {
+		t.Fatalf("Failed to configure transport: %v", err)
+	}
+	defer transport.CloseIdleConnections()
+	api := apiEndpoint{
+		baseURL: opts.apiBaseURL(),
+		apiKey:  opts.APIKey,
+		client:  &http.Client{Transport: withHostOverride(opts, transport), Timeout: opts.Timeout},
+	}
+
+	probes := conformanceProbes(&ConformanceOptions{ChatModel: openai.GPT4oMini, EmbeddingModel: string(openai.SmallEmbedding3)})
+	for _, probe := range probes {
+		t.Run(probe.name, func(t *testing.T) {
+			status, shape, err := fetchShape(probe, api)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			got := golden{Status: status, Shape: make(map[string]string, len(shape))}
+			for path, types := range shape {
+				got.Shape[path] = types.String()
+			}
+
+			path := filepath.Join(opts.GoldenDir, probe.name+".json")
+			if opts.UpdateGolden {
+				if err := writeGolden(path, got); err != nil {
+					t.Fatalf("Failed to update golden file: %v", err)
+				}
+				t.Logf("Updated %s", path)
+				return
+			}
+
+			want, err := readGolden(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("No golden file %s; run with -update to record it", path)
+			}
+			if err != nil {
+				t.Fatalf("Failed to read golden file: %v", err)
+			}
+			for _, diff := range diffGolden(want, got) {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+// diffGolden lists the differences between a recorded and a current
+// response structure, in path order.
+func diffGolden(want, got golden) []string {
+	var diffs []string
+	if want.Status != got.Status {
+		diffs = append(diffs, fmt.Sprintf("status: golden %d, got %d", want.Status, got.Status))
+	}
+
+	paths := make(map[string]bool)
+	for path := range want.Shape {
+		paths[path] = true
+	}
+	for path := range got.Shape {
+		paths[path] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	for _, path := range sorted {
+		wantTypes, inWant := want.Shape[path]
+		gotTypes, inGot := got.Shape[path]
+		switch {
+		case !inGot:
+			diffs = append(diffs, fmt.Sprintf("- %s (%s): missing from the response", path, wantTypes))
+		case !inWant:
+			diffs = append(diffs, fmt.Sprintf("+ %s (%s): not in the golden file", path, gotTypes))
+		case wantTypes != gotTypes:
+			diffs = append(diffs, fmt.Sprintf("~ %s: golden %s, got %s", path, wantTypes, gotTypes))
+		}
+	}
+	return diffs
+}
+
+func readGolden(path string) (golden, error) {
+	var g golden
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return g, err
+	}
+	err = json.Unmarshal(data, &g)
+	return g, err
+}
+
+func writeGolden(path string, g golden) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeJSONFile(path, g)
+}
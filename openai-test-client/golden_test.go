@@ -0,0 +1,56 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiffGolden(t *testing.T) {
+	want := golden{Status: 200, Shape: map[string]string{"id": "string", "usage": "object", "usage.total_tokens": "number"}}
+
+	tests := []struct {
+		name string
+		got  golden
+		want []string
+	}{
+		{"Same", want, nil},
+		{
+			name: "Status",
+			got:  golden{Status: 400, Shape: want.Shape},
+			want: []string{"status: golden 200, got 400"},
+		},
+		{
+			name: "Drift",
+			got:  golden{Status: 200, Shape: map[string]string{"id": "number", "usage": "object", "created": "number"}},
+			want: []string{
+				"+ created (number): not in the golden file",
+				"~ id: golden string, got number",
+				"- usage.total_tokens (number): missing from the response",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffGolden(want, tt.got); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffGolden = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoldenRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "Probe.json")
+	g := golden{Status: 200, Shape: map[string]string{"id": "string"}}
+	if err := writeGolden(path, g); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readGolden(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, g) {
+		t.Errorf("readGolden = %+v, want %+v", got, g)
+	}
+}
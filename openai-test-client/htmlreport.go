@@ -0,0 +1,146 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// HTML Report
+// =============================================================================
+
+// htmlTemplate renders a Report as a single page with inline styles and no
+// scripts or external resources, so the file can be attached to a ticket or
+// mailed and opened anywhere.
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"depth": func(name string) int { return strings.Count(name, "/") },
+	"leaf": func(name string) string {
+		return name[strings.LastIndex(name, "/")+1:]
+	},
+	"date":    func(t time.Time) string { return t.Format("2006-01-02") },
+	"expired": func(t time.Time) bool { return time.Now().After(t) },
+	"join":    strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>OpenAI test report: {{.Target}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+h1 { margin-bottom: 0.2em; }
+h2 { margin-top: 1.6em; border-bottom: 1px solid #ddd; }
+table { border-collapse: collapse; }
+th, td { text-align: left; padding: 0.25em 0.8em; border-bottom: 1px solid #eee; vertical-align: top; }
+td.num, th.num { text-align: right; font-variant-numeric: tabular-nums; }
+.status { display: inline-block; min-width: 3.5em; text-align: center; border-radius: 3px; padding: 0 0.4em; font-weight: bold; color: #fff; }
+.pass { background: #2e7d32; } .fail { background: #c62828; } .skip { background: #9e9e9e; }
+.banner { padding: 0.6em 1em; border-radius: 4px; color: #fff; font-size: 1.2em; display: inline-block; }
+.muted { color: #777; }
+.expired { color: #c62828; font-weight: bold; }
+details { margin: 0.2em 0; }
+summary { cursor: pointer; }
+pre { background: #f6f8fa; padding: 0.6em; overflow-x: auto; white-space: pre-wrap; word-break: break-all; max-height: 24em; margin: 0.3em 0; }
+.payload { margin: 0.6em 0 0.6em 1em; }
+</style>
+</head>
+<body>
+<h1>OpenAI test report</h1>
+<p class="muted">{{.Target}} &middot; {{.Timestamp.Format "2006-01-02 15:04:05 MST"}} &middot; {{printf "%.1f" .Elapsed}}s</p>
+{{if and .OK (eq .Failed 0)}}<div class="banner pass">All tests passed</div>{{else}}<div class="banner fail">Some tests failed</div>{{end}}
+<p>{{len .Tests}} tests: <span class="status pass">{{.Passed}}</span> passed, <span class="status fail">{{.Failed}}</span> failed, <span class="status skip">{{.Skipped}}</span> skipped</p>
+
+{{with .TLS}}
+<h2>TLS</h2>
+<table>
+<tr><th>Server name</th><td>{{.ServerName}}</td></tr>
+<tr><th>Version</th><td>{{.Version}}</td></tr>
+<tr><th>Cipher suite</th><td>{{.CipherSuite}}</td></tr>
+<tr><th>ALPN protocol</th><td>{{if .ALPN}}{{.ALPN}}{{else}}(none){{end}}</td></tr>
+<tr><th>Session resumption</th><td>{{if .Resumed}}yes{{else}}no{{end}}</td></tr>
+{{if .VerifiedChain}}<tr><th>Verified chain</th><td>{{join .VerifiedChain " → "}}</td></tr>{{end}}
+</table>
+<h3>Server certificate chain</h3>
+<table>
+<tr><th>#</th><th>Subject</th><th>Issuer</th><th>Expires</th><th>SANs</th></tr>
+{{range $i, $c := .Chain}}<tr><td>{{$i}}</td><td>{{$c.Subject}}</td><td>{{$c.Issuer}}</td><td{{if expired $c.NotAfter}} class="expired"{{end}}>{{date $c.NotAfter}}</td><td>{{join $c.SANs ", "}}</td></tr>
+{{end}}</table>
+{{with .Matrix}}
+<h3>Negotiation matrix</h3>
+<table>
+<tr><th>Version</th><th>Cipher suite</th><th>Result</th></tr>
+{{range .}}<tr><td>{{.Version}}</td><td>{{if .CipherSuite}}{{.CipherSuite}}{{else}}<span class="muted">(default suites)</span>{{end}}</td><td>{{if and .Accepted .Legacy}}<span class="status fail">accepted</span> legacy{{else if .Accepted}}<span class="status pass">accepted</span>{{else}}<span class="status skip">rejected</span>{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+{{end}}
+
+{{with .Latency}}
+<h2>Latency</h2>
+<table>
+<tr><th>Metric</th><th class="num">Count</th><th class="num">min ms</th><th class="num">p50 ms</th><th class="num">p90 ms</th><th class="num">p95 ms</th><th class="num">p99 ms</th><th class="num">max ms</th></tr>
+{{range $metric := $.LatencyOrder}}{{with index $.Latency $metric}}<tr><td>{{$.MetricName $metric}}</td><td class="num">{{.Count}}</td><td class="num">{{printf "%.1f" .Min}}</td><td class="num">{{printf "%.1f" .P50}}</td><td class="num">{{printf "%.1f" .P90}}</td><td class="num">{{printf "%.1f" .P95}}</td><td class="num">{{printf "%.1f" .P99}}</td><td class="num">{{printf "%.1f" .Max}}</td></tr>
+{{end}}{{end}}</table>
+{{end}}
+
+<h2>Tests</h2>
+<table>
+<tr><th>Status</th><th>Test</th><th class="num">Time</th></tr>
+{{range .Tests}}<tr>
+<td><span class="status {{.Status}}">{{.Status}}</span></td>
+<td style="padding-left: {{depth .Name}}.5em">
+{{if or .Output .Payloads}}<details{{if eq .Status "fail"}} open{{end}}><summary>{{leaf .Name}}</summary>
+{{if .Output}}<pre>{{join .Output "\n"}}</pre>{{end}}
+{{range .Payloads}}<div class="payload">
+<div><b>{{.Method}} {{.Path}}</b> {{if .Error}}<span class="expired">{{.Error}}</span>{{else}}&rarr; {{.Status}}{{end}}{{if .Truncated}} <span class="muted">(bodies cut to 2 KiB)</span>{{end}}</div>
+{{if .Request}}<pre>{{.Request}}</pre>{{end}}
+{{if .Response}}<pre>{{.Response}}</pre>{{end}}
+</div>{{end}}
+</details>{{else}}{{leaf .Name}}{{end}}
+</td>
+<td class="num">{{printf "%.2f" .Elapsed}}s</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// htmlReport is the data the template renders: the report plus the order
+// in which to list latency metrics.
+type htmlReport struct {
+	*Report
+	LatencyOrder []string
+}
+
+// MetricName labels a latency metric for the report.
+func (htmlReport) MetricName(metric string) string {
+	switch metric {
+	case metricRequest:
+		return "Full response"
+	case metricTTFB:
+		return "Time to headers"
+	case metricFirstChunk:
+		return "Time to first chunk"
+	case metricChunkGap:
+		return "Gap between chunks"
+	}
+	return metric
+}
+
+func writeHTMLReport(w io.Writer, report *Report) error {
+	return htmlTemplate.Execute(w, htmlReport{Report: report, LatencyOrder: metricOrder})
+}
+
+// writeHTMLFile writes the HTML report to path.
+func writeHTMLFile(path string, report *Report) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeHTMLReport(f, report); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
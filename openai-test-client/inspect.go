@@ -0,0 +1,405 @@
+package main
+
+import (
+	"cmp"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+	"mtls"
+)
+
+// =============================================================================
+// Certificate Inspection
+// =============================================================================
+
+// -inspect prints what an mTLS failure usually comes down to, for each
+// certificate in a file or presented by a live endpoint: its subject and
+// issuer, names, key usages, validity window and fingerprints, and whether
+// the chain verifies against -ca. A file may be PEM, DER or a PKCS #12
+// bundle, opened with -key-pass. An endpoint is reached as the API is,
+// through any proxy and presenting the client certificate if it loads, and
+// its chain is shown whether or not it is trusted.
+
+// inspection is the certificates found in a file or presented by an
+// endpoint, leaf first, and whether they verify.
+type inspection struct {
+	Source     string
+	Endpoint   bool
+	Connection string   // the TLS version and cipher suite, for an endpoint
+	Notes      []string // what went wrong short of reading the certificates
+	Certs      []*x509.Certificate
+
+	Roots    string              // what the chain was verified against
+	Verified []*x509.Certificate // the chain built to a root, if it verified
+	Err      error               // why it did not
+}
+
+// inspectTarget inspects target: a file if one exists by that name, or
+// else an endpoint, as host:port, host (port 443) or an https URL.
+func inspectTarget(o Options, target string, now time.Time) (*inspection, error) {
+	var in *inspection
+	var err error
+	if _, statErr := os.Stat(target); statErr == nil {
+		in, err = inspectFile(o, target)
+	} else {
+		in, err = inspectEndpoint(o, target)
+	}
+	if err != nil {
+		return nil, err
+	}
+	in.verify(o, now)
+	return in, nil
+}
+
+// inspectFile reads the certificates in a PEM, DER or PKCS #12 file.
+func inspectFile(o Options, path string) (*inspection, error) {
+	in := &inspection{Source: path}
+	if mtls.IsPKCS12(path) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		password, err := mtls.ReadPassphrase(o.KeyPass, path)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := mtls.DecodePKCS12(data, password)
+		if errors.Is(err, mtls.ErrIncorrectPassphrase) && o.KeyPass == "" && term.IsTerminal(int(os.Stdin.Fd())) {
+			if password, err = mtls.ReadPassphrase("prompt", path); err == nil {
+				cert, err = mtls.DecodePKCS12(data, password)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, der := range cert.Certificate {
+			c, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			in.Certs = append(in.Certs, c)
+		}
+		in.Notes = append(in.Notes, "a PKCS #12 bundle: its identity and the chain that issued it, without a self-signed root")
+		return in, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ders [][]byte
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 && !strings.Contains(string(data), "-----BEGIN") {
+		// A single DER certificate, as .cer and .der files often are
+		ders = [][]byte{data}
+	}
+	for _, der := range ders {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		in.Certs = append(in.Certs, c)
+	}
+	if len(in.Certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return in, nil
+}
+
+// inspectEndpoint connects to target and records the chain the server
+// presents. The handshake goes on past an untrusted chain, which is
+// verified afterwards, so that it can be shown.
+func inspectEndpoint(o Options, target string) (*inspection, error) {
+	host, err := endpointAddress(target)
+	if err != nil {
+		return nil, err
+	}
+	in := &inspection{Source: host, Endpoint: true}
+
+	o.RenewURL = ""
+	transport, err := newTransport(o)
+	if err != nil {
+		// Show the server's chain without presenting a certificate
+		in.Notes = append(in.Notes, fmt.Sprintf("no client certificate presented: %v", err))
+		o.Insecure = true
+		if transport, err = newTransport(o); err != nil {
+			return nil, err
+		}
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	config := transport.TLSClientConfig
+	if config.ServerName == "" {
+		config.ServerName = hostname(host)
+	}
+	var state *tls.ConnectionState
+	config.InsecureSkipVerify = true // verified by inspect, against -ca
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		state = &cs
+		return nil
+	}
+	transport.DisableKeepAlives = true
+
+	resp, err := (&http.Client{Transport: transport, Timeout: 10 * time.Second}).Head("https://" + host + "/")
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if state == nil {
+		if err == nil {
+			err = errors.New("no TLS handshake")
+		}
+		return nil, fmt.Errorf("connecting to %s: %w", host, err)
+	}
+	if err != nil {
+		// The server may turn the client certificate down after sending its
+		// own chain
+		in.Notes = append(in.Notes, fmt.Sprintf("the connection failed after the server's certificate: %v", err))
+	}
+	in.Connection = tls.VersionName(state.Version) + ", " + tls.CipherSuiteName(state.CipherSuite)
+	in.Certs = state.PeerCertificates
+	return in, nil
+}
+
+// endpointAddress returns the host:port of target, which is host:port,
+// a host (port 443) or an https URL.
+func endpointAddress(target string) (string, error) {
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return "", err
+		}
+		if u.Scheme != "https" {
+			return "", fmt.Errorf("%s: only https endpoints present certificates", target)
+		}
+		target = u.Host
+	}
+	if target == "" {
+		return "", errors.New("no endpoint to inspect")
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return net.JoinHostPort(strings.Trim(target, "[]"), "443"), nil
+	}
+	return target, nil
+}
+
+// verify checks the chain against -ca, or the system roots if it cannot
+// be read: for an endpoint as a server for its name, and for a file for
+// any use.
+func (in *inspection) verify(o Options, now time.Time) {
+	if len(in.Certs) == 0 {
+		in.Err = errors.New("no certificates")
+		return
+	}
+	opts := x509.VerifyOptions{Intermediates: x509.NewCertPool(), CurrentTime: now, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	for _, c := range in.Certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	in.Roots = "the system roots"
+	if data, err := os.ReadFile(o.CAFile); err == nil {
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(data) {
+			opts.Roots, in.Roots = pool, o.CAFile
+		}
+	}
+	if in.Endpoint {
+		opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		opts.DNSName = cmp.Or(o.serverName(), hostname(in.Source))
+	}
+	chains, err := in.Certs[0].Verify(opts)
+	if err != nil {
+		in.Err = err
+		return
+	}
+	in.Verified = chains[0]
+}
+
+// printInspection prints each certificate and whether the chain verifies,
+// which it returns.
+func printInspection(w io.Writer, in *inspection, now time.Time, warnDays int) bool {
+	if in.Endpoint {
+		fmt.Fprintf(w, "%s%sCertificates presented by %s%s (%s)\n", colorBold, colorCyan, in.Source, colorReset, in.Connection)
+	} else {
+		fmt.Fprintf(w, "%s%sCertificates in %s%s\n", colorBold, colorCyan, in.Source, colorReset)
+	}
+	for _, note := range in.Notes {
+		fmt.Fprintf(w, "  Note: %s\n", note)
+	}
+	for i, c := range in.Certs {
+		printCertDetails(w, i, c, now, warnDays)
+	}
+
+	if in.Err != nil {
+		fmt.Fprintf(w, "  Chain: %sNOT VERIFIED%s against %s: %v\n", colorRed, colorReset, in.Roots, in.Err)
+		return false
+	}
+	names := make([]string, len(in.Verified))
+	for i, c := range in.Verified {
+		names[i] = c.Subject.String()
+	}
+	fmt.Fprintf(w, "  Chain: %sverified%s against %s: %s\n", colorGreen, colorReset, in.Roots, strings.Join(names, " -> "))
+	return true
+}
+
+// printCertDetails prints the certificate at index in a chain.
+func printCertDetails(w io.Writer, index int, c *x509.Certificate, now time.Time, warnDays int) {
+	line := func(label, value string) {
+		fmt.Fprintf(w, "      %-15s %s\n", label+":", value)
+	}
+	fmt.Fprintf(w, "  [%d] %-15s %s\n", index, "Subject:", c.Subject)
+	line("Issuer", c.Issuer.String())
+	line("Serial", c.SerialNumber.Text(16))
+	line("SANs", orNone(subjectAltNames(c)))
+	line("Key", describePublicKey(c)+", signed with "+c.SignatureAlgorithm.String())
+	line("Key usage", orNone(keyUsageNames(c.KeyUsage)))
+	line("Ext key usage", orNone(extKeyUsageNames(c)))
+	ca := "no"
+	if c.IsCA {
+		ca = "yes"
+		if c.MaxPathLen > 0 || c.MaxPathLenZero {
+			ca = fmt.Sprintf("yes, path length %d", c.MaxPathLen)
+		}
+	}
+	line("CA", ca)
+	line("Valid", validityWindow(c, now, warnDays))
+	sha256Sum, sha1Sum := sha256.Sum256(c.Raw), sha1.Sum(c.Raw)
+	line("SHA-256", fingerprint(sha256Sum[:]))
+	line("SHA-1", fingerprint(sha1Sum[:]))
+}
+
+// validityWindow describes when c is valid, highlighting it if it is not
+// yet or no longer valid, or expires within warnDays.
+func validityWindow(c *x509.Certificate, now time.Time, warnDays int) string {
+	const layout = "2006-01-02 15:04:05 MST"
+	window := c.NotBefore.UTC().Format(layout) + " to " + c.NotAfter.UTC().Format(layout)
+	switch left := c.NotAfter.Sub(now); {
+	case now.Before(c.NotBefore):
+		return fmt.Sprintf("%s (%sNOT YET VALID%s)", window, colorRed, colorReset)
+	case left < 0:
+		return fmt.Sprintf("%s (%sEXPIRED%s)", window, colorRed, colorReset)
+	case left < time.Duration(warnDays)*24*time.Hour:
+		return fmt.Sprintf("%s (%s%d days left%s)", window, colorYellow, int(left.Hours()/24), colorReset)
+	default:
+		return fmt.Sprintf("%s (%d days left)", window, int(left.Hours()/24))
+	}
+}
+
+// subjectAltNames lists the names c is valid for, by kind.
+func subjectAltNames(c *x509.Certificate) []string {
+	var names []string
+	for _, name := range c.DNSNames {
+		names = append(names, "DNS:"+name)
+	}
+	for _, ip := range c.IPAddresses {
+		names = append(names, "IP:"+ip.String())
+	}
+	for _, email := range c.EmailAddresses {
+		names = append(names, "email:"+email)
+	}
+	for _, u := range c.URIs {
+		names = append(names, "URI:"+u.String())
+	}
+	return names
+}
+
+// describePublicKey gives the algorithm and size of the key in c.
+func describePublicKey(c *x509.Certificate) string {
+	switch key := c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + key.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return c.PublicKeyAlgorithm.String()
+}
+
+var keyUsages = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "Digital Signature"},
+	{x509.KeyUsageContentCommitment, "Content Commitment"},
+	{x509.KeyUsageKeyEncipherment, "Key Encipherment"},
+	{x509.KeyUsageDataEncipherment, "Data Encipherment"},
+	{x509.KeyUsageKeyAgreement, "Key Agreement"},
+	{x509.KeyUsageCertSign, "Certificate Sign"},
+	{x509.KeyUsageCRLSign, "CRL Sign"},
+	{x509.KeyUsageEncipherOnly, "Encipher Only"},
+	{x509.KeyUsageDecipherOnly, "Decipher Only"},
+}
+
+func keyUsageNames(usage x509.KeyUsage) []string {
+	var names []string
+	for _, u := range keyUsages {
+		if usage&u.usage != 0 {
+			names = append(names, u.name)
+		}
+	}
+	return names
+}
+
+var extKeyUsages = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "Any",
+	x509.ExtKeyUsageServerAuth:      "Server Auth",
+	x509.ExtKeyUsageClientAuth:      "Client Auth",
+	x509.ExtKeyUsageCodeSigning:     "Code Signing",
+	x509.ExtKeyUsageEmailProtection: "Email Protection",
+	x509.ExtKeyUsageTimeStamping:    "Time Stamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSP Signing",
+}
+
+// extKeyUsageNames lists what c may be used for, by name, with any usages
+// Go does not know as OIDs.
+func extKeyUsageNames(c *x509.Certificate) []string {
+	var names []string
+	for _, usage := range c.ExtKeyUsage {
+		if name, ok := extKeyUsages[usage]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("usage %d", usage))
+		}
+	}
+	for _, oid := range c.UnknownExtKeyUsage {
+		names = append(names, oid.String())
+	}
+	return names
+}
+
+// fingerprint formats a digest as colon-separated hex, as openssl x509
+// -fingerprint does.
+func fingerprint(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func orNone(items []string) string {
+	if len(items) == 0 {
+		return "(none)"
+	}
+	return strings.Join(items, ", ")
+}
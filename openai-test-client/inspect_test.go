@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInspect(t *testing.T) {
+	dir := t.TempDir()
+	caCert, caKey, err := newTestCA("Inspect-CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cert, err := issueClientCert(caCert, caKey, "inspected-client", now.Add(-time.Hour), now.Add(10*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "client.crt")
+	if err := writeCertFiles(certFile, filepath.Join(dir, "client.key"), cert); err != nil {
+		t.Fatal(err)
+	}
+
+	// A PEM file, and the same certificate as DER, verify against -ca
+	derFile := filepath.Join(dir, "client.cer")
+	if err := os.WriteFile(derFile, cert.Certificate[0], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	for _, file := range []string{certFile, derFile} {
+		in, err := inspectTarget(Options{CAFile: caFile}, file, now)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		var out bytes.Buffer
+		if !printInspection(&out, in, now, 30) {
+			t.Errorf("%s does not verify: %v", file, in.Err)
+		}
+		for _, want := range []string{"CN=inspected-client", "Issuer:         CN=Inspect-CA", "Client Auth", "Digital Signature", "ECDSA P-256", fingerprint(sum[:]), "9 days left", " against " + caFile + ": CN=inspected-client"} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("%s: output lacks %q:\n%s", file, want, out.String())
+			}
+		}
+	}
+
+	// Against another CA, it does not
+	if in, err := inspectTarget(Options{CAFile: filepath.Join("..", "mtls", "testdata", "pkcs12-ca.crt")}, certFile, now); err != nil || in.Err == nil {
+		t.Errorf("verified against the wrong CA: %v", err)
+	}
+
+	// A bundle opens with -key-pass
+	in, err := inspectTarget(Options{CAFile: filepath.Join("..", "mtls", "testdata", "pkcs12-ca.crt"), KeyPass: "pass:correct-horse"}, filepath.Join("..", "mtls", "testdata", "identity.p12"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(in.Certs) != 2 || in.Certs[0].Subject.CommonName != "chain-client" || len(in.Verified) != 3 {
+		t.Errorf("identity.p12: %d certificates, %d verified: %v", len(in.Certs), len(in.Verified), in.Err)
+	}
+
+	// An endpoint shows its chain even when it turns the client down
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+	serverCA := filepath.Join(dir, "server-ca.crt")
+	if err := os.WriteFile(serverCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	in, err = inspectTarget(Options{CertFile: filepath.Join(dir, "missing.crt"), CAFile: serverCA}, srv.URL, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(in.Certs) != 1 || !in.Endpoint || in.Err != nil || !strings.HasPrefix(in.Connection, "TLS 1.3") {
+		t.Errorf("endpoint: %d certificates over %q: %v", len(in.Certs), in.Connection, in.Err)
+	}
+	if len(in.Notes) != 2 {
+		t.Errorf("endpoint notes: %q", in.Notes)
+	}
+
+	// Presenting the client certificate, the connection completes
+	in, err = inspectTarget(Options{CertFile: certFile, KeyFile: filepath.Join(dir, "client.key"), CAFile: serverCA}, strings.TrimPrefix(srv.URL, "https://"), now)
+	if err != nil || len(in.Notes) != 0 {
+		t.Errorf("endpoint with a client certificate: %v, %q", err, in.Notes)
+	}
+
+	for target, want := range map[string]string{"localhost": "localhost:443", "https://[::1]/v1": "[::1]:443", "example.com:8443": "example.com:8443"} {
+		if got, err := endpointAddress(target); err != nil || got != want {
+			t.Errorf("endpointAddress(%q) = %q, %v, want %q", target, got, err, want)
+		}
+	}
+	if _, err := endpointAddress("http://localhost:8000"); err == nil {
+		t.Error("http endpoint accepted")
+	}
+}
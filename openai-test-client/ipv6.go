@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// =============================================================================
+// IPv6 and Dual-Stack Connectivity
+// =============================================================================
+
+// testDualStack connects to every address the target's host resolves to,
+// one address family at a time, to catch a server that listens on IPv4
+// only (bound to 0.0.0.0 rather than [::] or :port). Clients that prefer
+// IPv6, as most do when the host has an AAAA record or is localhost, then
+// fail against it, which is common in containers. For a localhost target
+// both loopback addresses are tried, and IPv6Literal connects to https://[::1]
+// to check URL handling and that the certificate covers the address.
+func testDualStack(t *testing.T) {
+	ctx, _ := setup(t)
+	if opts.proxyURL() != "" {
+		t.Skip("Through a proxy the proxy dials the target, not this client")
+	}
+
+	u, err := url.Parse(opts.apiBaseURL())
+	if err != nil {
+		t.Fatalf("Invalid base URL: %v", err)
+	}
+	host := u.Hostname()
+	addrs, err := resolveTarget(ctx, host)
+	if err != nil {
+		t.Fatalf("Failed to resolve %s: %v", host, err)
+	}
+	t.Logf("%s resolves to %v", host, addrs)
+
+	for _, family := range []struct {
+		name string
+		is   func(net.IP) bool
+	}{
+		{"IPv4", func(ip net.IP) bool { return ip.To4() != nil }},
+		{"IPv6", func(ip net.IP) bool { return ip.To4() == nil }},
+	} {
+		t.Run(family.name, func(t *testing.T) {
+			var tried int
+			for _, ip := range addrs {
+				if !family.is(ip) {
+					continue
+				}
+				tried++
+				err := requestVia(ctx, opts, ip)
+				switch {
+				case err == nil:
+					t.Logf("Connected via %s", ip)
+				case unreachableLocally(err):
+					t.Skipf("This machine cannot reach %s: %v", ip, err)
+				case errors.Is(err, syscall.ECONNREFUSED):
+					t.Errorf("Nothing listening on %s; the server is probably bound to one address family only: %v", ip, err)
+				default:
+					t.Errorf("Request via %s failed: %v", ip, err)
+				}
+			}
+			if tried == 0 {
+				t.Skipf("%s has no %s address", host, family.name)
+			}
+		})
+	}
+
+	t.Run("IPv6Literal", func(t *testing.T) {
+		if host != "localhost" {
+			t.Skip("Only for a localhost target")
+		}
+		o := opts
+		literal := *u
+		literal.Host = net.JoinHostPort("::1", targetPort(u))
+		o.BaseURL, o.Port = literal.String(), 0
+		err := requestVia(ctx, o, nil)
+		switch {
+		case err == nil:
+		case unreachableLocally(err):
+			t.Skipf("This machine cannot reach ::1: %v", err)
+		default:
+			t.Errorf("Request to %s failed (the server certificate needs an IP SAN for ::1): %v", o.BaseURL, err)
+		}
+	})
+}
+
+// resolveTarget returns the addresses of host. localhost is given both
+// loopback addresses, whatever the resolver says, since a client may use
+// either.
+func resolveTarget(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if host == "localhost" {
+		return []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// targetPort returns the port of u, or the default for its scheme.
+func targetPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if u.Scheme == "http" {
+		return "80"
+	}
+	return "443"
+}
+
+// requestVia lists the models with o, connecting to ip instead of
+// resolving the host if ip is not nil. TLS still verifies the certificate
+// against the host name.
+func requestVia(ctx context.Context, o Options, ip net.IP) error {
+	transport, err := newTransport(o)
+	if err != nil {
+		return err
+	}
+	defer transport.CloseIdleConnections()
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if ip != nil {
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			_, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			return dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(o.apiBaseURL(), "/")+"/models", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+o.APIKey)
+	resp, err := (&http.Client{Transport: withHostOverride(o, transport), Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// unreachableLocally reports whether a dial failed because this machine has
+// no route for the address family, rather than because of the server.
+func unreachableLocally(err error) bool {
+	return errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EADDRNOTAVAIL) ||
+		errors.Is(err, syscall.EAFNOSUPPORT) || errors.Is(err, syscall.EHOSTUNREACH)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+)
+
+func TestResolveTarget(t *testing.T) {
+	ips, err := resolveTarget(context.Background(), "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 || !ips[0].Equal(net.IPv4(127, 0, 0, 1)) || !ips[1].Equal(net.IPv6loopback) {
+		t.Errorf("localhost resolved to %v, want both loopback addresses", ips)
+	}
+
+	ips, err = resolveTarget(context.Background(), "::1")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.IPv6loopback) {
+		t.Errorf("::1 resolved to %v, %v", ips, err)
+	}
+
+	for raw, want := range map[string]string{
+		"https://localhost:8443/v1": "8443",
+		"https://[::1]/v1":          "443",
+		"http://example.com/v1":     "80",
+	} {
+		u, _ := url.Parse(raw)
+		if got := targetPort(u); got != want {
+			t.Errorf("targetPort(%s) = %s, want %s", raw, got, want)
+		}
+	}
+}
+
+// TestIPv4OnlyListener checks that a listener bound to 127.0.0.1 alone is
+// reported as refused over ::1, not as a host without IPv6.
+func TestIPv4OnlyListener(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("::1", port))
+	if err == nil {
+		conn.Close()
+		t.Skip("Something else is listening on the same port over IPv6")
+	}
+	if unreachableLocally(err) {
+		t.Skipf("No IPv6 loopback on this host: %v", err)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("Dial ::1 = %v, want connection refused", err)
+	}
+}
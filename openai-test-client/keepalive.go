@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// =============================================================================
+// Connection Reuse
+// =============================================================================
+
+// connUsage records, through an httptrace.ClientTrace, which connections
+// requests were sent on and how many TLS handshakes were made.
+type connUsage struct {
+	mu         sync.Mutex
+	requests   int
+	reused     int
+	handshakes int
+	resumed    int
+	local      map[string]bool
+}
+
+func (u *connUsage) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			u.mu.Lock()
+			defer u.mu.Unlock()
+			u.requests++
+			if info.Reused {
+				u.reused++
+			}
+			if u.local == nil {
+				u.local = make(map[string]bool)
+			}
+			u.local[info.Conn.LocalAddr().String()] = true
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				return
+			}
+			u.mu.Lock()
+			defer u.mu.Unlock()
+			u.handshakes++
+			if state.DidResume {
+				u.resumed++
+			}
+		},
+	}
+}
+
+// localAddrs lists the local addresses of the connections used, sorted.
+func (u *connUsage) localAddrs() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	addrs := make([]string, 0, len(u.local))
+	for addr := range u.local {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// testConnectionReuse sends a sequence of requests one after another and
+// checks that they all travel on one keep-alive connection with a single
+// TLS handshake, then that a new connection resumes the TLS session. A
+// server or proxy that closes connections between requests makes every
+// request pay for a full mTLS handshake.
+func testConnectionReuse(t *testing.T) {
+	ctx, _ := setup(t)
+
+	transport, err := newTransport(opts)
+	if err != nil {
+		t.Fatalf("Failed to configure transport: %v", err)
+	}
+	defer transport.CloseIdleConnections()
+	if transport.TLSClientConfig != nil {
+		transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	}
+	client := newClientWithTransport(opts, transport)
+
+	usage := &connUsage{}
+	traced := httptrace.WithClientTrace(ctx, usage.trace())
+	sequence := []string{"chat", "stream", "embeddings", "chat", "stream"}
+	for _, name := range sequence {
+		if err := loadOps[name](traced, client); err != nil {
+			t.Fatalf("%s request failed: %v", name, err)
+		}
+	}
+
+	t.Run("Reused", func(t *testing.T) {
+		if usage.requests != len(sequence) {
+			t.Fatalf("Traced %d connections for %d requests", usage.requests, len(sequence))
+		}
+		if want := len(sequence) - 1; usage.reused != want {
+			t.Errorf("%d of %d sequential requests reused the connection, want %d; the server or proxy is closing connections between requests (local addresses %v)",
+				usage.reused, len(sequence), want, usage.localAddrs())
+		}
+	})
+
+	t.Run("Handshakes", func(t *testing.T) {
+		if opts.Insecure {
+			t.Skip("No TLS with -insecure")
+		}
+		if usage.handshakes != 1 {
+			t.Errorf("%d TLS handshakes for %d sequential requests, want 1", usage.handshakes, len(sequence))
+		}
+	})
+
+	t.Run("Resumption", func(t *testing.T) {
+		if opts.Insecure {
+			t.Skip("No TLS with -insecure")
+		}
+		transport.CloseIdleConnections()
+		if err := loadOps["chat"](traced, client); err != nil {
+			t.Fatalf("Request on a new connection failed: %v", err)
+		}
+		if usage.handshakes != 2 {
+			t.Fatalf("%d TLS handshakes after closing the connection, want 2", usage.handshakes)
+		}
+		if usage.resumed == 0 {
+			t.Skip("The server did not resume the TLS session, so every new connection pays for a full mTLS handshake")
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+)
+
+func TestConnUsage(t *testing.T) {
+	tests := []struct {
+		name           string
+		close          bool
+		wantReused     int
+		wantHandshakes int
+	}{
+		{"KeepAlive", false, 2, 1},
+		{"ConnectionClose", true, 0, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.close {
+					w.Header().Set("Connection", "close")
+				}
+				w.Write([]byte("ok"))
+			}))
+			defer server.Close()
+
+			usage := &connUsage{}
+			ctx := httptrace.WithClientTrace(context.Background(), usage.trace())
+			client := server.Client()
+			for i := 0; i < 3; i++ {
+				req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+
+			if usage.requests != 3 || usage.reused != tt.wantReused || usage.handshakes != tt.wantHandshakes {
+				t.Errorf("requests %d, reused %d, handshakes %d; want 3, %d, %d", usage.requests, usage.reused, usage.handshakes, tt.wantReused, tt.wantHandshakes)
+			}
+			if got := len(usage.localAddrs()); got != 3-tt.wantReused {
+				t.Errorf("%d local addresses, want %d", got, 3-tt.wantReused)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// Latency Measurement
+// =============================================================================
+
+// Metrics recorded for every request made through the client, and for every
+// stream read with a streamTimer.
+const (
+	metricRequest    = "request"     // request sent to response body fully read
+	metricTTFB       = "ttfb"        // request sent to response headers received
+	metricFirstChunk = "first_chunk" // request sent to first stream chunk
+	metricChunkGap   = "chunk_gap"   // gap between consecutive stream chunks
+)
+
+var metricOrder = []string{metricRequest, metricTTFB, metricFirstChunk, metricChunkGap}
+
+// timingsEnv names a file that the child test runner appends its latency
+// samples to, so that the parent can summarise them after the child exits.
+const timingsEnv = "OPENAI_TEST_CLIENT_TIMINGS"
+
+// timings collects latency samples from every client and streamTimer.
+var timings = &latencyRecorder{samples: make(map[string][]time.Duration)}
+
+type latencyRecorder struct {
+	mu      sync.Mutex
+	samples map[string][]time.Duration
+	sink    io.Writer
+}
+
+func (l *latencyRecorder) record(metric string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples[metric] = append(l.samples[metric], d)
+	if l.sink != nil {
+		fmt.Fprintf(l.sink, "%s %d\n", metric, d.Nanoseconds())
+	}
+}
+
+// summary returns latency statistics for each metric with samples.
+func (l *latencyRecorder) summary() map[string]LatencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := make(map[string]LatencyStats)
+	for metric, samples := range l.samples {
+		if len(samples) > 0 {
+			stats[metric] = latencyStats(samples)
+		}
+	}
+	return stats
+}
+
+// take returns the summary and discards the samples, so that a long run
+// can report each interval without keeping every sample.
+func (l *latencyRecorder) take() map[string]LatencyStats {
+	stats := l.summary()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples = make(map[string][]time.Duration)
+	return stats
+}
+
+// readTimings loads samples written by a child's latencyRecorder.
+func readTimings(path string) (*latencyRecorder, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := &latencyRecorder{samples: make(map[string][]time.Duration)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		metric, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		ns, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		l.samples[metric] = append(l.samples[metric], time.Duration(ns))
+	}
+	return l, scanner.Err()
+}
+
+// LatencyStats summarises a set of latencies, in milliseconds.
+type LatencyStats struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min_ms"`
+	Mean  float64 `json:"mean_ms"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// latencyStats summarises samples, which it sorts in place.
+func latencyStats(samples []time.Duration) LatencyStats {
+	if len(samples) == 0 {
+		return LatencyStats{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	var sum time.Duration
+	for _, d := range samples {
+		sum += d
+	}
+	return LatencyStats{
+		Count: len(samples),
+		Min:   ms(samples[0]),
+		Mean:  ms(sum / time.Duration(len(samples))),
+		P50:   ms(percentile(samples, 50)),
+		P90:   ms(percentile(samples, 90)),
+		P95:   ms(percentile(samples, 95)),
+		P99:   ms(percentile(samples, 99)),
+		Max:   ms(samples[len(samples)-1]),
+	}
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted: the
+// smallest sample that at least p percent of samples are no greater than.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	// p*n/100 rather than p/100*n, which is inexact: 7/100*100 is above 7
+	rank := int(math.Ceil(p*float64(len(sorted))/100)) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func printLatencyTable(w io.Writer, stats map[string]LatencyStats) {
+	fmt.Fprintf(w, "%-12s %8s %9s %9s %9s %9s\n", "Latency", "Count", "p50 ms", "p95 ms", "p99 ms", "max ms")
+	for _, metric := range metricOrder {
+		if s, ok := stats[metric]; ok {
+			fmt.Fprintf(w, "%-12s %8d %9.1f %9.1f %9.1f %9.1f\n", metric, s.Count, s.P50, s.P95, s.P99, s.Max)
+		}
+	}
+}
+
+// =============================================================================
+// Instrumentation
+// =============================================================================
+
+// timingTransport records the time to response headers and the time until
+// the response body has been read or closed for every request.
+type timingTransport struct {
+	next http.RoundTripper
+}
+
+func (t timingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	timings.record(metricTTFB, time.Since(start))
+	resp.Body = &timedBody{ReadCloser: resp.Body, start: start}
+	return resp, nil
+}
+
+type timedBody struct {
+	io.ReadCloser
+	start time.Time
+	once  sync.Once
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.done()
+	}
+	return n, err
+}
+
+func (b *timedBody) Close() error {
+	b.done()
+	return b.ReadCloser.Close()
+}
+
+func (b *timedBody) done() {
+	b.once.Do(func() { timings.record(metricRequest, time.Since(b.start)) })
+}
+
+// streamTimer records time to first chunk and inter-chunk gaps for a stream
+// requested when the timer was created. Call chunk as each chunk arrives.
+type streamTimer struct {
+	start      time.Time
+	last       time.Time
+	firstChunk time.Duration
+}
+
+func newStreamTimer() *streamTimer {
+	return &streamTimer{start: time.Now()}
+}
+
+func (s *streamTimer) chunk() {
+	now := time.Now()
+	if s.last.IsZero() {
+		s.firstChunk = now.Sub(s.start)
+		timings.record(metricFirstChunk, s.firstChunk)
+	} else {
+		timings.record(metricChunkGap, now.Sub(s.last))
+	}
+	s.last = now
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyStats(t *testing.T) {
+	if got := latencyStats(nil); got != (LatencyStats{}) {
+		t.Errorf("no samples: %+v", got)
+	}
+
+	// Samples of 1 to n ms, given in reverse to check they are sorted. The
+	// nearest-rank p-th percentile of them is ceil(p*n/100) ms.
+	for _, tt := range []struct {
+		n             int
+		p50, p95, p99 float64
+	}{
+		{1, 1, 1, 1},
+		{12, 6, 12, 12},
+		{60, 30, 57, 60},
+		{100, 50, 95, 99},
+	} {
+		samples := make([]time.Duration, tt.n)
+		for i := range samples {
+			samples[i] = time.Duration(tt.n-i) * time.Millisecond
+		}
+		got := latencyStats(samples)
+		if got.Count != tt.n || got.Min != 1 || got.Max != float64(tt.n) {
+			t.Errorf("n=%d: count %d, min %v, max %v", tt.n, got.Count, got.Min, got.Max)
+		}
+		if got.P50 != tt.p50 || got.P95 != tt.p95 || got.P99 != tt.p99 {
+			t.Errorf("n=%d: p50 %v, p95 %v, p99 %v; want %v, %v, %v", tt.n, got.P50, got.P95, got.P99, tt.p50, tt.p95, tt.p99)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+	for _, tt := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 10}, // clamped to the first sample
+		{7, 10},
+		{10, 10},
+		{11, 20},
+		{50, 50},
+		{100, 100},
+		{150, 100}, // clamped to the last
+	} {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
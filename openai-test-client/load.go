@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// =============================================================================
+// Load Testing
+// =============================================================================
+
+// LoadOptions configures -load mode.
+type LoadOptions struct {
+	Enabled     bool
+	Concurrency int
+	RPS         float64
+	Duration    time.Duration
+	Mix         string
+}
+
+func registerLoadFlags(fs *flag.FlagSet) *LoadOptions {
+	o := &LoadOptions{}
+	fs.BoolVar(&o.Enabled, "load", false, "Run a load test instead of the test suite")
+	fs.IntVar(&o.Concurrency, "concurrency", 10, "Load mode: number of concurrent workers")
+	fs.Float64Var(&o.RPS, "rps", 0, "Load mode: target requests per second across all workers (0 = as fast as possible)")
+	fs.DurationVar(&o.Duration, "duration", 30*time.Second, "Load mode: how long to generate load")
+	fs.StringVar(&o.Mix, "mix", "chat=60,stream=20,embeddings=20", "Load mode: traffic mix as operation=percentage pairs adding up to 100 (chat, stream, embeddings)")
+	return o
+}
+
+// loadOp is one kind of request in the traffic mix.
+type loadOp struct {
+	name   string
+	weight int
+	run    func(ctx context.Context, client *openai.Client) error
+}
+
+var loadOps = map[string]func(ctx context.Context, client *openai.Client) error{
+	"chat": func(ctx context.Context, client *openai.Client) error {
+		_, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+			Model:    openai.GPT4o,
+			Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hello!"}},
+		})
+		return err
+	},
+	"stream": func(ctx context.Context, client *openai.Client) error {
+		timer := newStreamTimer()
+		stream, err := client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+			Model:    openai.GPT4o,
+			Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hello!"}},
+			Stream:   true,
+		})
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		for {
+			if _, err := stream.Recv(); errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			timer.chunk()
+		}
+	},
+	"embeddings": func(ctx context.Context, client *openai.Client) error {
+		_, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+			Model: openai.SmallEmbedding3,
+			Input: []string{"Hello, world!"},
+		})
+		return err
+	},
+}
+
+// parseMix parses a traffic mix such as "chat=60,stream=20,embeddings=20",
+// whose percentages must add up to 100.
+func parseMix(mix string) ([]loadOp, error) {
+	var ops []loadOp
+	sum := 0
+	for _, part := range strings.Split(mix, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mix entry %q: want operation=percentage", part)
+		}
+		run, known := loadOps[name]
+		if !known {
+			return nil, fmt.Errorf("unknown operation %q in mix: want chat, stream or embeddings", name)
+		}
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid percentage %q for %s", value, name)
+		}
+		sum += weight
+		if weight > 0 {
+			ops = append(ops, loadOp{name: name, weight: weight, run: run})
+		}
+	}
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("mix %q selects no operations", mix)
+	}
+	if sum != 100 {
+		return nil, fmt.Errorf("mix %q adds up to %d%%, not 100%%", mix, sum)
+	}
+	return ops, nil
+}
+
+func pickOp(ops []loadOp, rng *rand.Rand) loadOp {
+	total := 0
+	for _, op := range ops {
+		total += op.weight
+	}
+	n := rng.Intn(total)
+	for _, op := range ops {
+		if n < op.weight {
+			return op
+		}
+		n -= op.weight
+	}
+	return ops[len(ops)-1]
+}
+
+// sample is the outcome of one load-test request.
+type sample struct {
+	op      string
+	latency time.Duration
+	err     error
+}
+
+// OpStats is the load-test result for one operation, or for all of them.
+type OpStats struct {
+	Requests   int            `json:"requests"`
+	Errors     int            `json:"errors"`
+	ErrorRate  float64        `json:"error_rate"`
+	Throughput float64        `json:"throughput_rps"`
+	Latency    LatencyStats   `json:"latency"`
+	ErrorKinds map[string]int `json:"error_kinds,omitempty"`
+}
+
+// LoadReport is the result of a load test.
+type LoadReport struct {
+	Target      string             `json:"target"`
+	Concurrency int                `json:"concurrency"`
+	TargetRPS   float64            `json:"target_rps,omitempty"`
+	Elapsed     float64            `json:"elapsed_seconds"`
+	Total       OpStats            `json:"total"`
+	Operations  map[string]OpStats `json:"operations"`
+	// Timings covers every request, including failed ones: time to
+	// headers, to the full body and, for streams, to the first chunk and
+	// between chunks.
+	Timings map[string]LatencyStats `json:"timings"`
+}
+
+// runLoad generates the configured traffic mix against the target and
+// reports throughput, error rate and latency percentiles.
+func runLoad(log io.Writer, o Options, lo *LoadOptions) (*LoadReport, error) {
+	ops, err := parseMix(lo.Mix)
+	if err != nil {
+		return nil, err
+	}
+	if lo.Concurrency < 1 {
+		return nil, fmt.Errorf("-concurrency must be at least 1")
+	}
+
+	transport, err := newTransport(o)
+	if err != nil {
+		return nil, err
+	}
+	// Keep one idle connection per worker so that TLS handshakes are not
+	// repeated for every request
+	transport.MaxIdleConnsPerHost = lo.Concurrency
+	client := newClientWithTransport(o, transport)
+
+	ctx, cancel := context.WithTimeout(context.Background(), lo.Duration)
+	defer cancel()
+
+	// With a target rate, workers take a token per request. The ticker
+	// drops the ticks that come while every worker is busy, so a slow
+	// target is sent fewer requests than -rps; the report says so.
+	var tokens <-chan time.Time
+	if lo.RPS > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / lo.RPS))
+		defer ticker.Stop()
+		tokens = ticker.C
+	}
+
+	fmt.Fprintf(log, "Load test: %d workers for %v", lo.Concurrency, lo.Duration)
+	if lo.RPS > 0 {
+		fmt.Fprintf(log, " at %.1f req/s", lo.RPS)
+	}
+	fmt.Fprintf(log, ", mix %s\n", lo.Mix)
+
+	var (
+		mu       sync.Mutex
+		samples  []sample
+		count    atomic.Int64
+		failures atomic.Int64
+		wg       sync.WaitGroup
+	)
+
+	start := time.Now()
+	for i := 0; i < lo.Concurrency; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			var local []sample
+			defer func() {
+				mu.Lock()
+				samples = append(samples, local...)
+				mu.Unlock()
+			}()
+
+			for {
+				if tokens != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-tokens:
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+
+				op := pickOp(ops, rng)
+				begin := time.Now()
+				err := op.run(ctx, client)
+				if ctx.Err() != nil {
+					// Requests cut off by the end of the test are not counted
+					return
+				}
+				local = append(local, sample{op: op.name, latency: time.Since(begin), err: err})
+				count.Add(1)
+				if err != nil {
+					failures.Add(1)
+				}
+			}
+		}(time.Now().UnixNano() + int64(i))
+	}
+
+	// Progress every five seconds
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				fmt.Fprintf(log, "  %5.0fs: %d requests, %d errors\n", time.Since(start).Seconds(), count.Load(), failures.Load())
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(done)
+	elapsed := time.Since(start)
+
+	report := &LoadReport{
+		Target:      o.apiBaseURL(),
+		Concurrency: lo.Concurrency,
+		TargetRPS:   lo.RPS,
+		Elapsed:     elapsed.Seconds(),
+		Total:       summarise(samples, elapsed),
+		Operations:  make(map[string]OpStats),
+		Timings:     timings.summary(),
+	}
+	byOp := make(map[string][]sample)
+	for _, s := range samples {
+		byOp[s.op] = append(byOp[s.op], s)
+	}
+	for name, opSamples := range byOp {
+		report.Operations[name] = summarise(opSamples, elapsed)
+	}
+	return report, nil
+}
+
+func summarise(samples []sample, elapsed time.Duration) OpStats {
+	stats := OpStats{Requests: len(samples)}
+	if len(samples) == 0 {
+		return stats
+	}
+
+	latencies := make([]time.Duration, 0, len(samples))
+	for _, s := range samples {
+		if s.err != nil {
+			stats.Errors++
+			if stats.ErrorKinds == nil {
+				stats.ErrorKinds = make(map[string]int)
+			}
+			stats.ErrorKinds[errorKind(s.err)]++
+			continue
+		}
+		latencies = append(latencies, s.latency)
+	}
+	stats.ErrorRate = float64(stats.Errors) / float64(stats.Requests)
+	stats.Throughput = float64(stats.Requests) / elapsed.Seconds()
+
+	stats.Latency = latencyStats(latencies)
+	return stats
+}
+
+// errorKind groups errors for the report: API errors by HTTP status,
+// everything else by message.
+func errorKind(err error) string {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return fmt.Sprintf("HTTP %d", apiErr.HTTPStatusCode)
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return fmt.Sprintf("HTTP %d", reqErr.HTTPStatusCode)
+	}
+	return truncate(err.Error(), 80)
+}
+
+func printLoadReport(w io.Writer, report *LoadReport) {
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, strings.Repeat("=", 60))
+	fmt.Fprintf(w, "%s%s                    LOAD TEST REPORT%s\n", colorBold, colorCyan, colorReset)
+	fmt.Fprintln(w, strings.Repeat("=", 60))
+
+	fmt.Fprintf(w, "\nDuration:    %.1fs with %d workers\n", report.Elapsed, report.Concurrency)
+	fmt.Fprintf(w, "Requests:    %d (%.1f req/s)\n", report.Total.Requests, report.Total.Throughput)
+	printRateShortfall(w, report.TargetRPS, report.Total.Throughput)
+	color := colorGreen
+	if report.Total.Errors > 0 {
+		color = colorRed
+	}
+	fmt.Fprintf(w, "%sErrors:      %d (%.2f%%)%s\n", color, report.Total.Errors, report.Total.ErrorRate*100, colorReset)
+
+	fmt.Fprintf(w, "\n%-12s %8s %8s %9s %9s %9s %9s %9s\n", "Operation", "Requests", "Errors", "p50 ms", "p90 ms", "p95 ms", "p99 ms", "max ms")
+	names := make([]string, 0, len(report.Operations))
+	for name := range report.Operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		printOpRow(w, name, report.Operations[name])
+	}
+	printOpRow(w, "total", report.Total)
+	fmt.Fprintln(w)
+	printLatencyTable(w, report.Timings)
+
+	if len(report.Total.ErrorKinds) > 0 {
+		fmt.Fprintf(w, "\n%sErrors by kind:%s\n", colorRed, colorReset)
+		kinds := make([]string, 0, len(report.Total.ErrorKinds))
+		for kind := range report.Total.ErrorKinds {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Fprintf(w, "  - %s: %d\n", kind, report.Total.ErrorKinds[kind])
+		}
+	}
+	fmt.Fprintln(w, strings.Repeat("=", 60))
+}
+
+// rateTolerance is the fraction of -rps a run must achieve not to be
+// warned of. Requests cut off at the end are not counted, so even a run
+// that keeps up falls a little short.
+const rateTolerance = 0.9
+
+// printRateShortfall warns if achieved, the rate of requests made, fell
+// short of the target rate of -rps.
+func printRateShortfall(w io.Writer, target, achieved float64) {
+	if target <= 0 || achieved >= rateTolerance*target {
+		return
+	}
+	fmt.Fprintf(w, "%sWarning:     %.1f req/s is %.0f%% of the -rps target of %.1f: every worker was busy when requests were due, so they were not sent. Raise -concurrency to reach it.%s\n",
+		colorYellow, achieved, 100*achieved/target, target, colorReset)
+}
+
+func printOpRow(w io.Writer, name string, s OpStats) {
+	fmt.Fprintf(w, "%-12s %8d %8d %9.1f %9.1f %9.1f %9.1f %9.1f\n",
+		name, s.Requests, s.Errors, s.Latency.P50, s.Latency.P90, s.Latency.P95, s.Latency.P99, s.Latency.Max)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestParseMix(t *testing.T) {
+	ops, err := parseMix(" chat=50, stream=50 ,embeddings=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An operation given no share is left out
+	if len(ops) != 2 || ops[0].name != "chat" || ops[0].weight != 50 || ops[1].name != "stream" || ops[0].run == nil {
+		t.Errorf("ops = %+v", ops)
+	}
+	if _, err := parseMix("chat=60,stream=20,embeddings=20"); err != nil {
+		t.Errorf("default mix: %v", err)
+	}
+
+	for _, mix := range []string{
+		"chat",                  // no percentage
+		"chat=60,search=40",     // unknown operation
+		"chat=abc",              // not a number
+		"chat=120,stream=-20",   // negative
+		"chat=0,stream=0",       // nothing selected
+		"chat=60,stream=20",     // adds up to 80
+		"chat=3,stream=1",       // relative weights
+		"chat=60,embeddings=60", // over 100
+	} {
+		if _, err := parseMix(mix); err == nil {
+			t.Errorf("parseMix(%q) accepted", mix)
+		}
+	}
+}
+
+func TestPickOp(t *testing.T) {
+	ops, err := parseMix("chat=70,stream=20,embeddings=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	const picks = 100000
+	counts := make(map[string]int)
+	for range picks {
+		counts[pickOp(ops, rng).name]++
+	}
+	for _, op := range ops {
+		share := 100 * float64(counts[op.name]) / picks
+		if share < float64(op.weight)-1 || share > float64(op.weight)+1 {
+			t.Errorf("%s picked %.1f%% of the time, want %d%%", op.name, share, op.weight)
+		}
+	}
+
+	single := []loadOp{{name: "chat", weight: 100}}
+	if got := pickOp(single, rng).name; got != "chat" {
+		t.Errorf("only operation: picked %s", got)
+	}
+}
+
+func TestSummarise(t *testing.T) {
+	if got := summarise(nil, time.Second); got.Requests != 0 || got.ErrorRate != 0 || got.Throughput != 0 {
+		t.Errorf("no samples: %+v", got)
+	}
+
+	samples := []sample{
+		{op: "chat", latency: 10 * time.Millisecond},
+		{op: "chat", latency: 30 * time.Millisecond},
+		{op: "chat", latency: 20 * time.Millisecond},
+		{op: "chat", latency: time.Second, err: &openai.APIError{HTTPStatusCode: 429}},
+		{op: "chat", latency: time.Second, err: &openai.APIError{HTTPStatusCode: 429}},
+		{op: "chat", latency: time.Second, err: errors.New("connection reset by peer")},
+	}
+	got := summarise(samples, 2*time.Second)
+	if got.Requests != 6 || got.Errors != 3 || got.ErrorRate != 0.5 || got.Throughput != 3 {
+		t.Errorf("requests %d, errors %d, error rate %v, throughput %v", got.Requests, got.Errors, got.ErrorRate, got.Throughput)
+	}
+	if got.ErrorKinds["HTTP 429"] != 2 || got.ErrorKinds["connection reset by peer"] != 1 {
+		t.Errorf("error kinds = %v", got.ErrorKinds)
+	}
+	// Latency is that of the requests that succeeded
+	if got.Latency.Count != 3 || got.Latency.P50 != 20 || got.Latency.Max != 30 {
+		t.Errorf("latency = %+v", got.Latency)
+	}
+}
+
+func TestPrintRateShortfall(t *testing.T) {
+	for _, tt := range []struct {
+		target, achieved float64
+		warn             bool
+	}{
+		{0, 150, false},  // no target
+		{100, 98, false}, // near enough
+		{100, 90, false}, // at the tolerance
+		{100, 42, true},  // workers could not keep up
+	} {
+		var out bytes.Buffer
+		printRateShortfall(&out, tt.target, tt.achieved)
+		if warned := strings.Contains(out.String(), "of the -rps target"); warned != tt.warn {
+			t.Errorf("target %v, achieved %v: warned %v:\n%s", tt.target, tt.achieved, warned, out.String())
+		}
+	}
+}
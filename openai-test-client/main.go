@@ -1,23 +1,26 @@
 package main
 
 import (
-	"context"
-	"crypto/tls"
-	"crypto/x509"
-	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"testing"
 	"time"
 
 	openai "github.com/sashabaranov/go-openai"
+	"mtls"
 )
 
-const (
+// ANSI colors for the text output, cleared by disableColor.
+var (
 	colorReset  = "\033[0m"
 	colorGreen  = "\033[32m"
 	colorRed    = "\033[31m"
@@ -26,655 +29,512 @@ const (
 	colorBold   = "\033[1m"
 )
 
-type TestResult struct {
-	Name    string
-	Passed  bool
-	Message string
+// disableColor turns off ANSI colors, for -no-color and $NO_COLOR
+// (https://no-color.org).
+func disableColor() {
+	colorReset, colorGreen, colorRed, colorYellow, colorCyan, colorBold = "", "", "", "", "", ""
 }
 
-var results []TestResult
-
-func pass(name, msg string) {
-	results = append(results, TestResult{Name: name, Passed: true, Message: msg})
-	fmt.Printf("%s[PASS]%s %s: %s\n", colorGreen, colorReset, name, msg)
+// Options configures how the suite connects to the API under test. The same
+// flags are accepted by the CLI and by `go test` (after -args).
+type Options struct {
+	CertFile     string
+	KeyFile      string
+	KeyPass      string
+	CAFile       string
+	CertWarnDays int
+	RenewURL     string
+	CAKeyFile    string
+	ProxyURL     string
+	BaseURL      string
+	Port         int
+	APIKey       string
+	HostOverride string
+	Insecure     bool
+
+	// Timeouts and retries
+	Timeout      time.Duration
+	Deadline     time.Duration
+	Retries      int
+	RetryBackoff time.Duration
+
+	// Golden files for TestGoldenShapes
+	GoldenDir    string
+	UpdateGolden bool
+
+	// YAML file run by TestScenarios
+	ScenariosFile string
+
+	// Expvar endpoint of the target, for soak mode and TestStreamCancellation
+	MetricsURL string
+
+	// Random mutations sent by TestMalformedRequests
+	FuzzCount   int
+	FuzzSeed    int64
+	FuzzTimeout time.Duration
+
+	// Identities for the mTLS rejection tests
+	RevokedCertFile string
+	RevokedKeyFile  string
 }
 
-func fail(name, msg string) {
-	results = append(results, TestResult{Name: name, Passed: false, Message: msg})
-	fmt.Printf("%s[FAIL]%s %s: %s\n", colorRed, colorReset, name, msg)
+var opts Options
+
+func registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&opts.CertFile, "cert", "../certs/client.crt", "Client certificate file, PEM or a PKCS #12 .p12/.pfx bundle")
+	fs.StringVar(&opts.KeyFile, "key", "../certs/client.key", "Client key file, or a pkcs11: URI naming a key on a token; for a PKCS #12 -cert, its passphrase as pass:, env: or file:")
+	fs.StringVar(&opts.KeyPass, "key-pass", "", "Passphrase of encrypted -key, -ca-key and -revoked-key files: pass:<passphrase>, env:<variable>, file:<path> or prompt; asked for on the terminal if empty")
+	fs.StringVar(&opts.CAFile, "ca", "../certs/ca.crt", "CA certificate file for server verification")
+	fs.IntVar(&opts.CertWarnDays, "cert-warn-days", 30, "Warn of -cert and -ca certificates that expire within this many days")
+	fs.StringVar(&opts.RenewURL, "renew-url", "", "CA service that renews the client certificate two thirds of the way through its life, such as the mock server's https://localhost:8000/ca/sign")
+	fs.StringVar(&opts.ProxyURL, "proxy", "", "HTTP proxy URL (e.g., http://localhost:8080); defaults to $HTTPS_PROXY or $HTTP_PROXY")
+	fs.StringVar(&opts.BaseURL, "base-url", "", "Base URL for the OpenAI API (e.g., https://gateway.example.com/v1)")
+	fs.StringVar(&opts.BaseURL, "url", "", "Alias for -base-url")
+	fs.IntVar(&opts.Port, "port", 0, "Override the port of the base URL (default 8000 for localhost)")
+	fs.StringVar(&opts.APIKey, "api-key", envOr("OPENAI_API_KEY", "mock-api-key"), "API key sent as the bearer token (default $OPENAI_API_KEY)")
+	fs.StringVar(&opts.HostOverride, "host-override", "", "Server name for TLS SNI and verification, and the HTTP Host header")
+	fs.BoolVar(&opts.Insecure, "insecure", false, "Run without mTLS (plain HTTP)")
+	fs.DurationVar(&opts.Timeout, "timeout", 60*time.Second, "Per-request timeout, including reading the whole response (0 = none)")
+	fs.DurationVar(&opts.Deadline, "deadline", 0, "Overall deadline for the run, after which requests are cancelled (0 = none)")
+	fs.IntVar(&opts.Retries, "retries", 0, "Retry requests rejected with 429, 502, 503 or 504 up to this many times")
+	fs.DurationVar(&opts.RetryBackoff, "retry-backoff", 500*time.Millisecond, "Initial delay between retries, doubled on each attempt and raised to the server's Retry-After")
+	fs.StringVar(&opts.GoldenDir, "golden-dir", "testdata/golden", "Directory of the golden files compared by TestGoldenShapes")
+	fs.BoolVar(&opts.UpdateGolden, "update", false, "Rewrite the golden files from the current responses instead of comparing them")
+	fs.StringVar(&opts.ScenariosFile, "scenarios", "", "YAML file of request/expectation scenarios run by TestScenarios")
+	fs.StringVar(&opts.MetricsURL, "metrics-url", "", "Expvar endpoint of the target (e.g. the mock's http://localhost:6060/debug/vars), read for memory use in soak mode and for requests in flight by TestStreamCancellation")
+	fs.IntVar(&opts.FuzzCount, "fuzz-count", 50, "Random mutations of a valid body sent to each endpoint by TestMalformedRequests")
+	fs.Int64Var(&opts.FuzzSeed, "fuzz-seed", 0, "Seed for the TestMalformedRequests mutations (0 = random, logged so a failure can be repeated)")
+	fs.DurationVar(&opts.FuzzTimeout, "fuzz-timeout", 10*time.Second, "How long TestMalformedRequests waits for each response before reporting a hang")
+	fs.StringVar(&opts.CAKeyFile, "ca-key", "../certs/ca.key", "CA key, used to issue an expired client certificate for the rejection tests")
+	fs.StringVar(&opts.RevokedCertFile, "revoked-cert", "../certs/revoked.crt", "Revoked client certificate for the rejection tests")
+	fs.StringVar(&opts.RevokedKeyFile, "revoked-key", "../certs/revoked.key", "Key for -revoked-cert")
 }
 
-func section(name string) {
-	fmt.Printf("\n%s%s=== %s ===%s\n", colorBold, colorCyan, name, colorReset)
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
 }
 
-func main() {
-	// Command line flags
-	certFile := flag.String("cert", "../certs/client.crt", "Client certificate file")
-	keyFile := flag.String("key", "../certs/client.key", "Client key file")
-	caFile := flag.String("ca", "../certs/ca.crt", "CA certificate file for server verification")
-	proxyURL := flag.String("proxy", "", "HTTP proxy URL (e.g., http://localhost:8080)")
-	baseURL := flag.String("url", "", "Base URL for the OpenAI API (e.g., https://localhost:8000/v1)")
-	insecure := flag.Bool("insecure", false, "Run without mTLS (plain HTTP)")
-	flag.Parse()
-
-	// Determine base URL
-	apiBaseURL := *baseURL
-	if apiBaseURL == "" {
-		if *insecure {
-			apiBaseURL = "http://localhost:8000/v1"
-		} else {
-			apiBaseURL = "https://localhost:8000/v1"
+// apiBaseURL returns the configured base URL, defaulting to the local mock
+// server, with the port replaced if -port is set.
+func (o Options) apiBaseURL() string {
+	base := o.BaseURL
+	if base == "" {
+		base = "https://localhost:8000/v1"
+		if o.Insecure {
+			base = "http://localhost:8000/v1"
 		}
 	}
-	fmt.Printf("Target API: %s\n", apiBaseURL)
-
-	var client *openai.Client
-
-	if *insecure {
-		// Configure client without TLS
-		transport := &http.Transport{}
-
-		// Add proxy if specified
-		if *proxyURL != "" {
-			proxy, err := url.Parse(*proxyURL)
-			if err != nil {
-				fmt.Printf("Failed to parse proxy URL: %v\n", err)
-				os.Exit(1)
-			}
-			transport.Proxy = http.ProxyURL(proxy)
-			fmt.Printf("Using HTTP proxy: %s\n", *proxyURL)
-		}
+	if o.Port == 0 {
+		return base
+	}
 
-		httpClient := &http.Client{Transport: transport}
-		config := openai.DefaultConfig("mock-api-key")
-		config.BaseURL = apiBaseURL
-		config.HTTPClient = httpClient
-		client = openai.NewClientWithConfig(config)
-	} else {
-		// Load client certificate
-		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
-		if err != nil {
-			fmt.Printf("Failed to load client certificate: %v\n", err)
-			os.Exit(1)
-		}
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+	u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(o.Port))
+	return u.String()
+}
 
-		// Load CA certificate
-		caCert, err := os.ReadFile(*caFile)
-		if err != nil {
-			fmt.Printf("Failed to read CA certificate: %v\n", err)
-			os.Exit(1)
-		}
+// newClientWithTransport builds an OpenAI client for o that sends its
+// requests through transport.
+func newClientWithTransport(o Options, transport *http.Transport) *openai.Client {
+	return newClientWithRoundTripper(o, clientRoundTripper(o, transport))
+}
 
-		caCertPool := x509.NewCertPool()
-		if !caCertPool.AppendCertsFromPEM(caCert) {
-			fmt.Println("Failed to parse CA certificate")
-			os.Exit(1)
-		}
+// clientRoundTripper wraps transport with the Host override, retries and
+// latency recording configured by o.
+func clientRoundTripper(o Options, transport *http.Transport) http.RoundTripper {
+	rt := withHostOverride(o, transport)
+	if o.Retries > 0 {
+		rt = retryTransport{next: rt, retries: o.Retries, backoff: o.RetryBackoff}
+	}
+	return timingTransport{next: rt}
+}
 
-		// Create TLS config
-		tlsConfig := &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			RootCAs:      caCertPool,
-			MinVersion:   tls.VersionTLS12,
-		}
+func newClientWithRoundTripper(o Options, rt http.RoundTripper) *openai.Client {
+	config := openai.DefaultConfig(o.APIKey)
+	config.BaseURL = o.apiBaseURL()
+	config.HTTPClient = &http.Client{Transport: rt, Timeout: o.Timeout}
+	return openai.NewClientWithConfig(config)
+}
 
-		// Create HTTP transport with mTLS
-		transport := &http.Transport{
-			TLSClientConfig: tlsConfig,
+// newTransport builds the HTTP transport for o, with mTLS unless o.Insecure
+// is set and through o.ProxyURL if one is given.
+func newTransport(o Options) (*http.Transport, error) {
+	transport := &http.Transport{}
+
+	if !o.Insecure {
+		// Load client certificate, reloaded when the files change and
+		// renewed with -renew-url
+		var reloader *mtls.Reloader
+		var err error
+		if o.RenewURL != "" {
+			reloader, err = renewingCertReloader(o)
+		} else {
+			reloader, err = mtls.NewReloader(o.CertFile, o.KeyFile, o.KeyPass)
 		}
-
-		// Add proxy if specified
-		if *proxyURL != "" {
-			proxy, err := url.Parse(*proxyURL)
-			if err != nil {
-				fmt.Printf("Failed to parse proxy URL: %v\n", err)
-				os.Exit(1)
-			}
-			transport.Proxy = http.ProxyURL(proxy)
-			fmt.Printf("Using HTTP proxy: %s\n", *proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
 		}
 
-		// Create HTTP client with mTLS (and optional proxy)
-		httpClient := &http.Client{
-			Transport: transport,
+		config := mtls.Config{CAFile: o.CAFile, ServerName: o.serverName()}
+		if transport.TLSClientConfig, err = mtls.LoadClientTLSConfig(config); err != nil {
+			return nil, err
 		}
-
-		// Configure OpenAI client with mTLS
-		config := openai.DefaultConfig("mock-api-key")
-		config.BaseURL = apiBaseURL
-		config.HTTPClient = httpClient
-		client = openai.NewClientWithConfig(config)
-	}
-
-	ctx := context.Background()
-
-	fmt.Println(strings.Repeat("=", 60))
-	fmt.Printf("%s%s       OpenAI Mock Server Test Suite%s\n", colorBold, colorCyan, colorReset)
-	fmt.Println(strings.Repeat("=", 60))
-
-	// Run all tests
-	testListModels(ctx, client)
-	testGetModel(ctx, client)
-	testGetModelNotFound(ctx, client)
-	testChatCompletion(ctx, client)
-	testChatCompletionWithParams(ctx, client)
-	testChatCompletionStreaming(ctx, client)
-	testChatCompletionWithTools(ctx, client)
-	testChatCompletionMultiPartContent(ctx, client)
-	testEmbeddings(ctx, client)
-	testEmbeddingsMultipleInputs(ctx, client)
-	testErrorHandling(ctx, client)
-
-	// Print summary
-	printSummary()
-}
-
-// =============================================================================
-// Model Tests
-// =============================================================================
-
-func testListModels(ctx context.Context, client *openai.Client) {
-	section("List Models")
-
-	models, err := client.ListModels(ctx)
-	if err != nil {
-		fail("ListModels", fmt.Sprintf("Error: %v", err))
-		return
+		transport.TLSClientConfig.GetClientCertificate = reloader.GetClientCertificate
 	}
 
-	if len(models.Models) == 0 {
-		fail("ListModels", "No models returned")
-		return
-	}
-
-	pass("ListModels", fmt.Sprintf("Retrieved %d models", len(models.Models)))
-
-	// Check for expected models
-	expectedModels := []string{"gpt-4", "gpt-4o", "gpt-3.5-turbo", "text-embedding-ada-002"}
-	foundModels := make(map[string]bool)
-	for _, m := range models.Models {
-		foundModels[m.ID] = true
-	}
-
-	allFound := true
-	for _, expected := range expectedModels {
-		if !foundModels[expected] {
-			allFound = false
-			break
-		}
+	// Add proxy if specified
+	proxy, err := o.proxyFunc()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
 	}
+	transport.Proxy = proxy
 
-	if allFound {
-		pass("ListModels-Expected", "All expected models present")
-	} else {
-		fail("ListModels-Expected", "Some expected models missing")
-	}
+	return transport, nil
 }
 
-func testGetModel(ctx context.Context, client *openai.Client) {
-	section("Get Model by ID")
-
-	model, err := client.GetModel(ctx, "gpt-4o")
-	if err != nil {
-		fail("GetModel", fmt.Sprintf("Error: %v", err))
-		return
+// withHostOverride wraps rt to send every request with the Host header of
+// -host-override, if o sets one. Every client of the target is built with
+// it, rather than setting the header on each request.
+func withHostOverride(o Options, rt http.RoundTripper) http.RoundTripper {
+	if o.HostOverride == "" {
+		return rt
 	}
+	return hostOverrideTransport{host: o.HostOverride, next: rt}
+}
 
-	if model.ID != "gpt-4o" {
-		fail("GetModel", fmt.Sprintf("Wrong model ID: %s", model.ID))
-		return
-	}
+// serverName returns the name the server's certificate is verified
+// against when it is not the target's host name: that of -host-override,
+// or "" if it is not set.
+func (o Options) serverName() string {
+	return hostname(o.HostOverride)
+}
 
-	pass("GetModel", fmt.Sprintf("Retrieved model: %s (owned by: %s)", model.ID, model.OwnedBy))
+// hostOverrideTransport sends every request with a fixed Host header, for
+// gateways reached by IP address or through a tunnel.
+type hostOverrideTransport struct {
+	host string
+	next http.RoundTripper
 }
 
-func testGetModelNotFound(ctx context.Context, client *openai.Client) {
-	section("Get Model Not Found")
+func (t hostOverrideTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Host = t.host
+	return t.next.RoundTrip(req)
+}
 
-	_, err := client.GetModel(ctx, "nonexistent-model")
-	if err != nil {
-		pass("GetModel-NotFound", "Correctly returned error for nonexistent model")
-	} else {
-		fail("GetModel-NotFound", "Should have returned error for nonexistent model")
+// hostname strips an optional port from host.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
 	}
+	return host
 }
 
-// =============================================================================
-// Chat Completion Tests
-// =============================================================================
-
-func testChatCompletion(ctx context.Context, client *openai.Client) {
-	section("Chat Completion (Non-Streaming)")
-
-	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model: openai.GPT4o,
-		Messages: []openai.ChatCompletionMessage{
-			{Role: openai.ChatMessageRoleUser, Content: "Hello, how are you?"},
-		},
-	})
+// main is a CLI wrapper around the suite. It accepts the connection flags,
+// -run and any -test.* flag, re-runs itself as a test runner (see
+// runSuite) and reports the results in the -output format.
+func main() {
+	testing.Init()
+	registerFlags(flag.CommandLine)
+	run := flag.String("run", "", "Run only tests matching this regular expression (same as go test -run)")
+	parallel := flag.Int("parallel", runtime.GOMAXPROCS(0), "Run at most this many tests at once (same as go test -parallel; 1 runs them one at a time)")
+	shuffle := flag.String("shuffle", "off", "Randomize the test order: off, on, or a seed to repeat an order (same as go test -shuffle)")
+	output := flag.String("output", "text", "Result format: text, json or junit")
+	outputFile := flag.String("output-file", "", "Write the json/junit report to this file instead of stdout")
+	tlsInfo := flag.Bool("tls-info", false, "Print the negotiated TLS parameters and server certificate chain before running the tests")
+	tlsMatrix := flag.Bool("tls-matrix", false, "Print which TLS versions and cipher suites the server accepts before running the tests")
+	noColor := flag.Bool("no-color", false, "Disable ANSI colors in the output (also disabled by a non-empty $NO_COLOR)")
+	quiet := flag.Bool("quiet", false, "Print only the summary or report, not the test log")
+	htmlReport := flag.String("report", "", "Also write a self-contained HTML report of the suite run to this file")
+	checkCerts := flag.Bool("check-certs", false, "Check the -cert and -ca certificates, print when each expires and exit: non-zero if any expires within -cert-warn-days or has")
+	inspect := flag.String("inspect", "", "Print the subject, issuer, SANs, key usages, validity and fingerprints of each certificate in a PEM, DER or PKCS #12 file, or presented by an endpoint (host:port or https URL), check the chain against -ca and exit: non-zero if it does not verify")
+	load := registerLoadFlags(flag.CommandLine)
+	soak := registerSoakFlags(flag.CommandLine)
+	conformance := registerConformanceFlags(flag.CommandLine)
+	flag.Parse()
 
-	if err != nil {
-		fail("ChatCompletion", fmt.Sprintf("Error: %v", err))
+	if os.Getenv(runnerEnv) != "" {
+		runTests(*run, *parallel, *shuffle)
 		return
 	}
 
-	if len(resp.Choices) == 0 {
-		fail("ChatCompletion", "No choices returned")
-		return
+	if *noColor || os.Getenv("NO_COLOR") != "" {
+		disableColor()
 	}
 
-	choice := resp.Choices[0]
-	pass("ChatCompletion", fmt.Sprintf("Response: %q", truncate(choice.Message.Content, 60)))
-
-	// Verify response structure
-	if resp.ID == "" {
-		fail("ChatCompletion-ID", "Missing response ID")
-	} else {
-		pass("ChatCompletion-ID", fmt.Sprintf("ID: %s", resp.ID))
+	format := *output
+	if *parallel < 1 {
+		fmt.Printf("Invalid -parallel %d: must be at least 1\n", *parallel)
+		os.Exit(2)
 	}
-
-	if resp.Model == "" {
-		fail("ChatCompletion-Model", "Missing model in response")
-	} else {
-		pass("ChatCompletion-Model", fmt.Sprintf("Model: %s", resp.Model))
+	if _, err := strconv.ParseInt(*shuffle, 10, 64); err != nil && *shuffle != "off" && *shuffle != "on" {
+		fmt.Printf("Invalid -shuffle %q: must be off, on or an integer seed\n", *shuffle)
+		os.Exit(2)
 	}
-
-	if resp.Usage.TotalTokens > 0 {
-		pass("ChatCompletion-Usage", fmt.Sprintf("Tokens - Prompt: %d, Completion: %d, Total: %d",
-			resp.Usage.PromptTokens, resp.Usage.CompletionTokens, resp.Usage.TotalTokens))
-	} else {
-		fail("ChatCompletion-Usage", "Invalid token usage")
+	if format != "text" && format != "json" && format != "junit" {
+		fmt.Printf("Invalid -output %q: must be text, json or junit\n", format)
+		os.Exit(2)
 	}
-
-	if choice.FinishReason != "" {
-		pass("ChatCompletion-FinishReason", fmt.Sprintf("Finish reason: %s", choice.FinishReason))
-	} else {
-		fail("ChatCompletion-FinishReason", "Missing finish reason")
+	if load.Enabled && format == "junit" {
+		fmt.Println("Invalid -output junit with -load: must be text or json")
+		os.Exit(2)
 	}
-}
-
-func testChatCompletionWithParams(ctx context.Context, client *openai.Client) {
-	section("Chat Completion with Parameters")
-
-	maxTokens := 100
-	temperature := float32(0.7)
-	n := 2
-
-	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model: openai.GPT4o,
-		Messages: []openai.ChatCompletionMessage{
-			{Role: openai.ChatMessageRoleSystem, Content: "You are a helpful assistant."},
-			{Role: openai.ChatMessageRoleUser, Content: "Tell me a joke."},
-		},
-		MaxTokens:   maxTokens,
-		Temperature: temperature,
-		N:           n,
-	})
-
-	if err != nil {
-		fail("ChatCompletion-Params", fmt.Sprintf("Error: %v", err))
-		return
+	if soak.Enabled && format == "junit" {
+		fmt.Println("Invalid -output junit with -soak: must be text or json")
+		os.Exit(2)
 	}
-
-	if len(resp.Choices) >= n {
-		pass("ChatCompletion-Params-N", fmt.Sprintf("Received %d choices (requested %d)", len(resp.Choices), n))
-	} else {
-		fail("ChatCompletion-Params-N", fmt.Sprintf("Expected %d choices, got %d", n, len(resp.Choices)))
+	if soak.Enabled && load.Enabled {
+		fmt.Println("Invalid -soak with -load: choose one")
+		os.Exit(2)
 	}
-}
-
-func testChatCompletionStreaming(ctx context.Context, client *openai.Client) {
-	section("Chat Completion (SSE Streaming)")
-
-	stream, err := client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
-		Model: openai.GPT4o,
-		Messages: []openai.ChatCompletionMessage{
-			{Role: openai.ChatMessageRoleUser, Content: "Hello!"},
-		},
-		Stream: true,
-	})
-
-	if err != nil {
-		fail("ChatCompletion-Stream", fmt.Sprintf("Error creating stream: %v", err))
-		return
+	if conformance.Target != "mock" && conformance.Target != "real" {
+		fmt.Printf("Invalid -target %q: must be mock or real\n", conformance.Target)
+		os.Exit(2)
 	}
-	defer stream.Close()
-
-	pass("ChatCompletion-Stream-Init", "Stream created successfully")
-
-	var fullContent strings.Builder
-	chunkCount := 0
-	var lastFinishReason string
-	startTime := time.Now()
-
-	for {
-		chunk, err := stream.Recv()
-		if errors.Is(err, io.EOF) {
-			break
-		}
-		if err != nil {
-			fail("ChatCompletion-Stream-Recv", fmt.Sprintf("Error receiving chunk: %v", err))
-			return
-		}
-
-		chunkCount++
-		if len(chunk.Choices) > 0 {
-			delta := chunk.Choices[0].Delta
-			fullContent.WriteString(delta.Content)
-			if chunk.Choices[0].FinishReason != "" {
-				lastFinishReason = string(chunk.Choices[0].FinishReason)
-			}
-		}
+	if conformance.Target == "real" && format == "junit" {
+		fmt.Println("Invalid -output junit with -target real: must be text or json")
+		os.Exit(2)
 	}
-
-	elapsed := time.Since(startTime)
-
-	if chunkCount > 0 {
-		pass("ChatCompletion-Stream-Chunks", fmt.Sprintf("Received %d chunks in %v", chunkCount, elapsed.Round(time.Millisecond)))
-	} else {
-		fail("ChatCompletion-Stream-Chunks", "No chunks received")
+	if *htmlReport != "" && (load.Enabled || soak.Enabled || conformance.Target == "real") {
+		fmt.Println("Invalid -report with -load, -soak or -target real: the HTML report covers the test suite")
+		os.Exit(2)
 	}
-
-	content := fullContent.String()
-	if content != "" {
-		pass("ChatCompletion-Stream-Content", fmt.Sprintf("Full response: %q", truncate(content, 60)))
-	} else {
-		fail("ChatCompletion-Stream-Content", "Empty content from stream")
-	}
-
-	if lastFinishReason == "stop" {
-		pass("ChatCompletion-Stream-Finish", "Received finish_reason: stop")
-	} else {
-		fail("ChatCompletion-Stream-Finish", fmt.Sprintf("Expected finish_reason 'stop', got '%s'", lastFinishReason))
+	if opts.CertWarnDays < 0 {
+		fmt.Printf("Invalid -cert-warn-days %d: must not be negative\n", opts.CertWarnDays)
+		os.Exit(2)
 	}
-}
-
-func testChatCompletionWithTools(ctx context.Context, client *openai.Client) {
-	section("Chat Completion with Tools/Functions")
-
-	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model: openai.GPT4o,
-		Messages: []openai.ChatCompletionMessage{
-			{Role: openai.ChatMessageRoleUser, Content: "What's the weather in Paris?"},
-		},
-		Tools: []openai.Tool{
-			{
-				Type: openai.ToolTypeFunction,
-				Function: &openai.FunctionDefinition{
-					Name:        "get_weather",
-					Description: "Get weather information for a location",
-					Parameters: map[string]interface{}{
-						"type": "object",
-						"properties": map[string]interface{}{
-							"location": map[string]interface{}{
-								"type":        "string",
-								"description": "City name",
-							},
-						},
-						"required": []string{"location"},
-					},
-				},
-			},
-		},
-		ToolChoice: "required",
-	})
-
-	if err != nil {
-		fail("ChatCompletion-Tools", fmt.Sprintf("Error: %v", err))
-		return
+	if opts.RenewURL != "" && opts.Insecure {
+		fmt.Println("Invalid -renew-url with -insecure: there is no client certificate to renew")
+		os.Exit(2)
 	}
 
-	if len(resp.Choices) == 0 {
-		fail("ChatCompletion-Tools", "No choices returned")
+	if *inspect != "" {
+		now := time.Now()
+		in, err := inspectTarget(opts, *inspect, now)
+		if err != nil {
+			fmt.Printf("Failed to inspect %s: %v\n", *inspect, err)
+			os.Exit(1)
+		}
+		if !printInspection(os.Stdout, in, now, opts.CertWarnDays) {
+			os.Exit(1)
+		}
 		return
 	}
 
-	choice := resp.Choices[0]
-
-	// Check for tool calls
-	if len(choice.Message.ToolCalls) > 0 {
-		toolCall := choice.Message.ToolCalls[0]
-		pass("ChatCompletion-Tools-Call", fmt.Sprintf("Tool call: %s (ID: %s)", toolCall.Function.Name, toolCall.ID))
-		pass("ChatCompletion-Tools-Args", fmt.Sprintf("Arguments: %s", toolCall.Function.Arguments))
-	} else if choice.Message.Content != "" {
-		// Mock server might return regular content sometimes
-		pass("ChatCompletion-Tools-Content", fmt.Sprintf("Response: %q", truncate(choice.Message.Content, 60)))
-	} else {
-		fail("ChatCompletion-Tools", "No tool calls or content returned")
-	}
-
-	if choice.FinishReason == "tool_calls" {
-		pass("ChatCompletion-Tools-FinishReason", "Finish reason: tool_calls")
-	} else {
-		pass("ChatCompletion-Tools-FinishReason", fmt.Sprintf("Finish reason: %s", choice.FinishReason))
+	var certs []mtls.CertExpiry
+	var certsErr error
+	if !opts.Insecure {
+		certs, certsErr = clientCerts(opts)
 	}
-}
-
-func testChatCompletionMultiPartContent(ctx context.Context, client *openai.Client) {
-	// NOTE: This test is REQUIRED for OpenCode Plan mode.
-	// OpenCode's plan agent sends messages with multi-part content (array of ContentParts)
-	// instead of simple string content. Without this support, plan mode fails with:
-	// "json: cannot unmarshal array into Go struct field ChatMessage.messages.content of type string"
-	section("Chat Completion with Multi-Part Content (Required for OpenCode Plan mode)")
-
-	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model: openai.GPT4o,
-		Messages: []openai.ChatCompletionMessage{
-			{
-				Role: openai.ChatMessageRoleUser,
-				MultiContent: []openai.ChatMessagePart{
-					{
-						Type: openai.ChatMessagePartTypeText,
-						Text: "This is the first part of a multi-part message.",
-					},
-					{
-						Type: openai.ChatMessagePartTypeText,
-						Text: "This is the second part of the message.",
-					},
-				},
-			},
-		},
-	})
-
-	if err != nil {
-		fail("ChatCompletion-MultiPart", fmt.Sprintf("Error: %v", err))
+	if *checkCerts {
+		if certsErr != nil {
+			fmt.Printf("Failed to read certificates: %v\n", certsErr)
+			os.Exit(1)
+		}
+		if !mtls.PrintCertCheck(os.Stdout, certs, time.Now(), opts.CertWarnDays) {
+			os.Exit(1)
+		}
 		return
 	}
 
-	if len(resp.Choices) == 0 {
-		fail("ChatCompletion-MultiPart", "No choices returned")
-		return
+	// The test log goes to stdout unless the report needs it
+	log := io.Writer(os.Stdout)
+	if format != "text" && *outputFile == "" {
+		log = os.Stderr
 	}
-
-	choice := resp.Choices[0]
-	pass("ChatCompletion-MultiPart", fmt.Sprintf("Response: %q", truncate(choice.Message.Content, 60)))
-
-	// Verify token count reflects multi-part content
-	// The two parts combined are ~90 chars, so ~22 tokens
-	if resp.Usage.PromptTokens > 0 {
-		pass("ChatCompletion-MultiPart-Tokens", fmt.Sprintf("Prompt tokens: %d (multi-part content parsed correctly)", resp.Usage.PromptTokens))
-	} else {
-		fail("ChatCompletion-MultiPart-Tokens", "No prompt tokens counted")
+	// The summary goes where the log would have gone
+	summary := log
+	if *quiet {
+		log = io.Discard
 	}
 
-	if choice.FinishReason != "" {
-		pass("ChatCompletion-MultiPart-Finish", fmt.Sprintf("Finish reason: %s", choice.FinishReason))
-	} else {
-		fail("ChatCompletion-MultiPart-Finish", "Missing finish reason")
+	fmt.Fprintf(log, "Target API: %s\n", opts.apiBaseURL())
+	if proxy := opts.proxyURL(); proxy != "" {
+		fmt.Fprintf(log, "Using HTTP proxy: %s\n", proxy)
 	}
-}
-
-// =============================================================================
-// Embeddings Tests
-// =============================================================================
+	fmt.Fprintln(log, strings.Repeat("=", 60))
+	fmt.Fprintf(log, "%s%s       OpenAI Mock Server Test Suite%s\n", colorBold, colorCyan, colorReset)
+	fmt.Fprintln(log, strings.Repeat("=", 60))
 
-func testEmbeddings(ctx context.Context, client *openai.Client) {
-	section("Embeddings")
-
-	resp, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
-		Model: openai.AdaEmbeddingV2,
-		Input: []string{"Hello, world!"},
-	})
-
-	if err != nil {
-		fail("Embeddings", fmt.Sprintf("Error: %v", err))
-		return
+	// A certificate that cannot be read fails the tests that use it
+	for _, warning := range mtls.ExpiryWarnings(certs, time.Now(), opts.CertWarnDays) {
+		fmt.Fprintf(log, "%sWarning: %s%s\n", colorYellow, warning, colorReset)
 	}
 
-	if len(resp.Data) == 0 {
-		fail("Embeddings", "No embeddings returned")
-		return
+	// TLS details are printed with -tls-info and included in the HTML report
+	if *tlsInfo && opts.Insecure {
+		fmt.Fprintln(log, "TLS diagnostics: not available with -insecure")
 	}
-
-	embedding := resp.Data[0]
-	pass("Embeddings", fmt.Sprintf("Received embedding with %d dimensions", len(embedding.Embedding)))
-
-	if embedding.Index == 0 {
-		pass("Embeddings-Index", "Correct index: 0")
-	} else {
-		fail("Embeddings-Index", fmt.Sprintf("Wrong index: %d", embedding.Index))
+	var tlsDetails *TLSInfo
+	if (*tlsInfo || *htmlReport != "") && !opts.Insecure {
+		info, err := collectTLSInfo(opts)
+		switch {
+		case err != nil && *tlsInfo:
+			fmt.Fprintf(log, "TLS diagnostics failed: %v\n", err)
+			if why := explainTLSError(err, opts); why != "" {
+				fmt.Fprintf(log, "  %s\n", why)
+			}
+			fmt.Fprintln(log)
+		case err == nil && *tlsInfo:
+			printTLSDiagnostics(log, info)
+		}
+		tlsDetails = info
 	}
-
-	if resp.Model != "" {
-		pass("Embeddings-Model", fmt.Sprintf("Model: %s", resp.Model))
+	if *tlsMatrix && opts.Insecure {
+		fmt.Fprintln(log, "TLS matrix: not available with -insecure")
 	}
-
-	if resp.Usage.TotalTokens > 0 {
-		pass("Embeddings-Usage", fmt.Sprintf("Tokens - Prompt: %d, Total: %d",
-			resp.Usage.PromptTokens, resp.Usage.TotalTokens))
+	if *tlsMatrix && !opts.Insecure {
+		results, err := runTLSMatrix(opts)
+		if err != nil {
+			fmt.Fprintf(log, "TLS matrix failed: %v\n\n", err)
+		} else {
+			printTLSMatrix(log, results)
+		}
+		if tlsDetails != nil {
+			tlsDetails.Matrix = results
+		}
 	}
 
-	// Check embedding dimensions (ada-002 should be 1536)
-	expectedDims := 1536
-	if len(embedding.Embedding) == expectedDims {
-		pass("Embeddings-Dimensions", fmt.Sprintf("Correct dimensions: %d", expectedDims))
-	} else {
-		fail("Embeddings-Dimensions", fmt.Sprintf("Expected %d dimensions, got %d", expectedDims, len(embedding.Embedding)))
-	}
-}
-
-func testEmbeddingsMultipleInputs(ctx context.Context, client *openai.Client) {
-	section("Embeddings (Multiple Inputs)")
-
-	inputs := []string{
-		"First sentence",
-		"Second sentence",
-		"Third sentence",
+	if load.Enabled {
+		report, err := runLoad(log, opts, load)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Load test failed: %v\n", err)
+			os.Exit(1)
+		}
+		if format == "text" {
+			printLoadReport(summary, report)
+		} else if err := writeJSONFile(*outputFile, report); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	resp, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
-		Model: openai.SmallEmbedding3,
-		Input: inputs,
-	})
-
-	if err != nil {
-		fail("Embeddings-Multi", fmt.Sprintf("Error: %v", err))
+	if soak.Enabled {
+		report, err := runSoak(log, opts, load, soak)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Soak test failed: %v\n", err)
+			os.Exit(1)
+		}
+		if format == "text" {
+			printSoakReport(summary, report)
+		} else if err := writeJSONFile(*outputFile, report); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+			os.Exit(1)
+		}
+		if !report.Passed {
+			os.Exit(1)
+		}
 		return
 	}
 
-	if len(resp.Data) == len(inputs) {
-		pass("Embeddings-Multi-Count", fmt.Sprintf("Received %d embeddings for %d inputs", len(resp.Data), len(inputs)))
-	} else {
-		fail("Embeddings-Multi-Count", fmt.Sprintf("Expected %d embeddings, got %d", len(inputs), len(resp.Data)))
+	if conformance.Target == "real" {
+		report, err := runConformance(log, opts, conformance)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Conformance check failed: %v\n", err)
+			os.Exit(1)
+		}
+		if format == "text" {
+			printConformanceReport(summary, report)
+		} else if err := writeJSONFile(*outputFile, report); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+			os.Exit(1)
+		}
+		if report.Drifted > 0 {
+			os.Exit(1)
+		}
+		return
 	}
 
-	// Verify indices
-	allIndicesCorrect := true
-	for i, emb := range resp.Data {
-		if emb.Index != i {
-			allIndicesCorrect = false
-			break
+	report, err := runSuite(os.Args[1:], log, *htmlReport != "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to run test suite: %v\n", err)
+		os.Exit(1)
+	}
+	report.Target = opts.apiBaseURL()
+	report.TLS = tlsDetails
+	report.Certificates = certs
+
+	if format == "text" {
+		printSummary(summary, report)
+	} else if err := writeReport(format, *outputFile, report); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+		os.Exit(1)
+	}
+	if *htmlReport != "" {
+		if err := writeHTMLFile(*htmlReport, report); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write HTML report: %v\n", err)
+			os.Exit(1)
 		}
+		fmt.Fprintf(summary, "HTML report written to %s\n", *htmlReport)
 	}
 
-	if allIndicesCorrect {
-		pass("Embeddings-Multi-Indices", "All indices correct")
-	} else {
-		fail("Embeddings-Multi-Indices", "Incorrect indices")
+	if report.Failed > 0 || !report.OK {
+		os.Exit(1)
 	}
 }
 
-// =============================================================================
-// Error Handling Tests
-// =============================================================================
-
-func testErrorHandling(ctx context.Context, client *openai.Client) {
-	section("Error Handling")
-
-	// Test missing model
-	_, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model: "", // Empty model
-		Messages: []openai.ChatCompletionMessage{
-			{Role: openai.ChatMessageRoleUser, Content: "Hello"},
-		},
-	})
-
-	if err != nil {
-		pass("Error-MissingModel", fmt.Sprintf("Correctly returned error: %v", truncate(err.Error(), 80)))
-	} else {
-		fail("Error-MissingModel", "Should have returned error for missing model")
+// writeReport writes report in format to path, or to stdout if path is empty.
+func writeReport(format, path string, report *Report) error {
+	write := writeJSONReport
+	if format == "junit" {
+		write = writeJUnitReport
+	}
+	if path == "" {
+		return write(os.Stdout, report)
 	}
 
-	// Test empty messages
-	_, err = client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model:    openai.GPT4o,
-		Messages: []openai.ChatCompletionMessage{}, // Empty messages
-	})
-
+	f, err := os.Create(path)
 	if err != nil {
-		pass("Error-EmptyMessages", fmt.Sprintf("Correctly returned error: %v", truncate(err.Error(), 80)))
-	} else {
-		fail("Error-EmptyMessages", "Should have returned error for empty messages")
+		return err
+	}
+	if err := write(f, report); err != nil {
+		f.Close()
+		return err
 	}
+	return f.Close()
 }
 
-// =============================================================================
-// Helpers
-// =============================================================================
-
-func truncate(s string, maxLen int) string {
-	if len(s) <= maxLen {
-		return s
+// runTests is the body of the test-runner child process: it runs the
+// registered tests through the testing package, so the output matches
+// `go test -v`, and exits with the suite's status.
+func runTests(run string, parallel int, shuffle string) {
+	if run != "" {
+		flag.Set("test.run", run)
 	}
-	return s[:maxLen-3] + "..."
-}
+	flag.Set("test.parallel", strconv.Itoa(parallel))
+	flag.Set("test.shuffle", shuffle)
+	flag.Set("test.v", "true")
 
-func printSummary() {
-	fmt.Println()
-	fmt.Println(strings.Repeat("=", 60))
-	fmt.Printf("%s%s                    TEST SUMMARY%s\n", colorBold, colorCyan, colorReset)
-	fmt.Println(strings.Repeat("=", 60))
-
-	passed := 0
-	failed := 0
-	for _, r := range results {
-		if r.Passed {
-			passed++
-		} else {
-			failed++
+	if path := os.Getenv(timingsEnv); path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			fmt.Printf("Failed to open timings file: %v\n", err)
+			os.Exit(1)
 		}
+		timings.sink = f
 	}
-
-	total := passed + failed
-	fmt.Printf("\nTotal Tests: %d\n", total)
-	fmt.Printf("%sPassed: %d%s\n", colorGreen, passed, colorReset)
-	fmt.Printf("%sFailed: %d%s\n", colorRed, failed, colorReset)
-
-	if failed > 0 {
-		fmt.Printf("\n%sFailed Tests:%s\n", colorRed, colorReset)
-		for _, r := range results {
-			if !r.Passed {
-				fmt.Printf("  - %s: %s\n", r.Name, r.Message)
-			}
+	if path := os.Getenv(payloadsEnv); path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			fmt.Printf("Failed to open payloads file: %v\n", err)
+			os.Exit(1)
 		}
+		payloads.sink = f
 	}
 
-	fmt.Println()
-	if failed == 0 {
-		fmt.Printf("%s%sAll tests passed!%s\n", colorBold, colorGreen, colorReset)
-	} else {
-		fmt.Printf("%s%sSome tests failed.%s\n", colorBold, colorRed, colorReset)
+	startDeadline(opts)
+	// Each test builds its own client; fail once here rather than in every test
+	if _, err := newTransport(opts); err != nil {
+		fmt.Printf("Failed to configure client: %v\n", err)
+		os.Exit(1)
 	}
-	fmt.Println(strings.Repeat("=", 60))
+
+	testing.Main(regexp.MatchString, tests, nil, nil)
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The suite runs against a live server. Connection flags go after -args:
+//
+//	go test -v -run TestChatCompletion -args -insecure
+//
+// Tests are skipped when the server is not reachable.
+func TestMain(m *testing.M) {
+	registerFlags(flag.CommandLine)
+	flag.Parse()
+
+	startDeadline(opts)
+	skipUnreachable = true
+
+	code := m.Run()
+	if stats := timings.summary(); testing.Verbose() && len(stats) > 0 {
+		printLatencyTable(os.Stdout, stats)
+	}
+	os.Exit(code)
+}
+
+func TestListModels(t *testing.T)                     { testListModels(t) }
+func TestGetModel(t *testing.T)                       { testGetModel(t) }
+func TestGetModelNotFound(t *testing.T)               { testGetModelNotFound(t) }
+func TestChatCompletion(t *testing.T)                 { testChatCompletion(t) }
+func TestChatCompletionWithParams(t *testing.T)       { testChatCompletionWithParams(t) }
+func TestChatCompletionStreaming(t *testing.T)        { testChatCompletionStreaming(t) }
+func TestStreamCancellation(t *testing.T)             { testStreamCancellation(t) }
+func TestSSEWireFormat(t *testing.T)                  { testSSEWireFormat(t) }
+func TestChatCompletionWithTools(t *testing.T)        { testChatCompletionWithTools(t) }
+func TestChatCompletionStreamingTools(t *testing.T)   { testChatCompletionStreamingTools(t) }
+func TestChatCompletionMultiPartContent(t *testing.T) { testChatCompletionMultiPartContent(t) }
+func TestEmbeddings(t *testing.T)                     { testEmbeddings(t) }
+func TestEmbeddingsMultipleInputs(t *testing.T)       { testEmbeddingsMultipleInputs(t) }
+func TestCapabilities(t *testing.T)                   { testCapabilities(t) }
+func TestImages(t *testing.T)                         { testImages(t) }
+func TestAudioSpeech(t *testing.T)                    { testAudioSpeech(t) }
+func TestAudioTranscription(t *testing.T)             { testAudioTranscription(t) }
+func TestModerations(t *testing.T)                    { testModerations(t) }
+func TestFiles(t *testing.T)                          { testFiles(t) }
+func TestBatches(t *testing.T)                        { testBatches(t) }
+func TestErrorHandling(t *testing.T)                  { testErrorHandling(t) }
+func TestMalformedRequests(t *testing.T)              { testMalformedRequests(t) }
+func TestGoldenShapes(t *testing.T)                   { testGoldenShapes(t) }
+func TestScenarios(t *testing.T)                      { testScenarios(t) }
+func TestConnectionReuse(t *testing.T)                { testConnectionReuse(t) }
+func TestDualStack(t *testing.T)                      { testDualStack(t) }
+func TestMTLSNoClientCert(t *testing.T)               { testMTLSNoClientCert(t) }
+func TestMTLSWrongCA(t *testing.T)                    { testMTLSWrongCA(t) }
+func TestMTLSExpiredCert(t *testing.T)                { testMTLSExpiredCert(t) }
+func TestMTLSRevokedCert(t *testing.T)                { testMTLSRevokedCert(t) }
+func TestClientCertRotation(t *testing.T)             { testClientCertRotation(t) }
+func TestIntermediateCAChain(t *testing.T)            { testIntermediateCAChain(t) }
+func TestTLSMatrix(t *testing.T)                      { testTLSMatrix(t) }
+func TestProxyChain(t *testing.T)                     { testProxyChain(t) }
+func TestOverload(t *testing.T)                       { testOverload(t) }
+
+func TestAPIBaseURL(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		o    Options
+		want string
+	}{
+		{"default", Options{}, "https://localhost:8000/v1"},
+		{"default insecure", Options{Insecure: true}, "http://localhost:8000/v1"},
+		{"default with port", Options{Port: 8443}, "https://localhost:8443/v1"},
+		{"explicit port", Options{BaseURL: "https://gateway.example.com:9443/v1"}, "https://gateway.example.com:9443/v1"},
+		{"explicit port replaced", Options{BaseURL: "https://gateway.example.com:9443/v1", Port: 8443}, "https://gateway.example.com:8443/v1"},
+		{"no port given a port", Options{BaseURL: "https://gateway.example.com/v1", Port: 8443}, "https://gateway.example.com:8443/v1"},
+		{"IPv6", Options{BaseURL: "https://[::1]:8443/v1"}, "https://[::1]:8443/v1"},
+		{"IPv6 port replaced", Options{BaseURL: "https://[::1]:8443/v1", Port: 9000}, "https://[::1]:9000/v1"},
+		{"IPv6 given a port", Options{BaseURL: "https://[fd00::1]/v1", Port: 9000}, "https://[fd00::1]:9000/v1"},
+		{"path kept", Options{BaseURL: "https://gateway.example.com/openai/deployments/v1/", Port: 8443}, "https://gateway.example.com:8443/openai/deployments/v1/"},
+		{"query kept", Options{BaseURL: "https://gateway.example.com/v1?api-version=2024-06-01", Port: 8443}, "https://gateway.example.com:8443/v1?api-version=2024-06-01"},
+	} {
+		if got := tc.o.apiBaseURL(); got != tc.want {
+			t.Errorf("%s: apiBaseURL() = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestHostOverride checks that -host-override sets both the Host header
+// and the TLS server name, so a gateway reached by IP address is verified
+// against, and routes by, the name given.
+func TestHostOverride(t *testing.T) {
+	ca, caKey, err := newTestCA("Host Override CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := make(chan [2]string, 1)
+	srv := mtlsServer(t, ca, caKey, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts <- [2]string{r.Host, r.TLS.ServerName}
+		io.WriteString(w, `{"object":"list","data":[]}`)
+	}))
+
+	// The server's certificate is for localhost only, so connecting to
+	// 127.0.0.1 fails verification without the override
+	o := writeClientIdentity(t, t.TempDir(), ca, ca, caKey)
+	o.BaseURL = srv.URL + "/v1"
+	o.HostOverride = "localhost:8443"
+	o.Timeout = 10 * time.Second
+	transport, err := newTransport(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.TLSClientConfig.ServerName != "localhost" {
+		t.Errorf("TLS server name = %q, want localhost", transport.TLSClientConfig.ServerName)
+	}
+	if _, err := newClientWithTransport(o, transport).ListModels(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-hosts; got != [2]string{"localhost:8443", "localhost"} {
+		t.Errorf("server saw Host %q and server name %q", got[0], got[1])
+	}
+
+	// The transport leaves the caller's request as it was
+	var seen string
+	rt := hostOverrideTransport{host: "gateway.internal", next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		seen = req.Host
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+	})}
+	req, _ := http.NewRequest(http.MethodGet, "https://10.0.0.1/v1/models", nil)
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if seen != "gateway.internal" || req.Host != "10.0.0.1" {
+		t.Errorf("sent Host %q, caller's request has Host %q", seen, req.Host)
+	}
+}
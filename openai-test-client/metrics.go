@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// =============================================================================
+// Target Metrics
+// =============================================================================
+
+// targetVars is what the suite reads from the target's expvar endpoint
+// (-metrics-url). Memstats is published by every Go program that serves
+// expvar; InFlight and Goroutines only by the mock server, so they are nil
+// for other targets.
+type targetVars struct {
+	Memstats *struct {
+		HeapAlloc uint64
+		Sys       uint64
+	} `json:"memstats"`
+	InFlight   *int64 `json:"mockserver_in_flight"`
+	Goroutines *int64 `json:"mockserver_goroutines"`
+}
+
+// readTargetVars fetches and decodes the expvar endpoint at url.
+func readTargetVars(client *http.Client, url string) (*targetVars, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	var vars targetVars
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		return nil, fmt.Errorf("%s: %v", url, err)
+	}
+	return &vars, nil
+}
+
+// readTargetMemory reads the Go runtime's heap and total memory from an
+// expvar endpoint such as the mock server's /debug/vars.
+func readTargetMemory(client *http.Client, url string) (heap, sys uint64, err error) {
+	vars, err := readTargetVars(client, url)
+	if err != nil {
+		return 0, 0, err
+	}
+	if vars.Memstats == nil {
+		return 0, 0, fmt.Errorf("%s has no memstats", url)
+	}
+	return vars.Memstats.HeapAlloc, vars.Memstats.Sys, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadTargetVars(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/debug/vars":
+			w.Write([]byte(`{"cmdline":["ms"],"memstats":{"HeapAlloc":1048576,"Sys":8388608,"NumGC":3},"mockserver_in_flight":2,"mockserver_goroutines":9}`))
+		case "/empty":
+			w.Write([]byte(`{"cmdline":["ms"]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	heap, sys, err := readTargetMemory(server.Client(), server.URL+"/debug/vars")
+	if err != nil || heap != 1<<20 || sys != 8<<20 {
+		t.Errorf("readTargetMemory = %d, %d, %v; want 1 MiB heap and 8 MiB sys", heap, sys, err)
+	}
+	if _, _, err := readTargetMemory(server.Client(), server.URL+"/empty"); err == nil || !strings.Contains(err.Error(), "no memstats") {
+		t.Errorf("readTargetMemory without memstats: err = %v", err)
+	}
+	if _, _, err := readTargetMemory(server.Client(), server.URL+"/missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("readTargetMemory of a missing page: err = %v", err)
+	}
+
+	vars, err := readTargetVars(server.Client(), server.URL+"/debug/vars")
+	if err != nil || vars.InFlight == nil || *vars.InFlight != 2 || vars.Goroutines == nil || *vars.Goroutines != 9 {
+		t.Errorf("readTargetVars = %+v, %v; want 2 in flight and 9 goroutines", vars, err)
+	}
+	if vars, err := readTargetVars(server.Client(), server.URL+"/empty"); err != nil || vars.InFlight != nil || vars.Goroutines != nil {
+		t.Errorf("readTargetVars of a target that is not the mock = %+v, %v; want nil gauges", vars, err)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"mtls"
+)
+
+// =============================================================================
+// mTLS Rejection Tests
+// =============================================================================
+
+// The rejection tests connect with bad client identities and assert that the
+// server refuses them with the expected TLS alert. Under TLS 1.3 the client
+// finishes its side of the handshake before the server checks its
+// certificate, so the alert surfaces on the first request rather than on
+// dial.
+
+// Alerts accepted for each kind of bad identity. The first entry is what a
+// Go server sends; the others cover other TLS stacks and TLS 1.2.
+var (
+	alertsNoCert  = []string{"certificate required", "handshake failure", "bad certificate"}
+	alertsWrongCA = []string{"unknown certificate authority", "bad certificate"}
+	alertsExpired = []string{"expired certificate", "bad certificate"}
+	alertsRevoked = []string{"bad certificate", "revoked certificate"}
+)
+
+func testMTLSNoClientCert(t *testing.T) {
+	setup(t)
+	requireMTLS(t)
+
+	expectRejected(t, nil, alertsNoCert)
+}
+
+func testMTLSWrongCA(t *testing.T) {
+	setup(t)
+	requireMTLS(t)
+
+	caCert, caKey, err := newTestCA("Untrusted-CA")
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	cert, err := issueClientCert(caCert, caKey, "wrong-ca-client", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+
+	expectRejected(t, cert, alertsWrongCA)
+}
+
+func testMTLSExpiredCert(t *testing.T) {
+	setup(t)
+	requireMTLS(t)
+
+	// The expired certificate must chain to the trusted CA, so that expiry
+	// is the only reason to reject it
+	ca, err := mtls.LoadKeyPair(opts.CAFile, opts.CAKeyFile, opts.KeyPass)
+	if err != nil {
+		t.Skipf("CA key needed to issue an expired certificate: %v", err)
+	}
+	cert, err := issueClientCert(ca.Leaf, ca.PrivateKey, "expired-client", time.Now().Add(-48*time.Hour), time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+
+	expectRejected(t, cert, alertsExpired)
+}
+
+func testMTLSRevokedCert(t *testing.T) {
+	setup(t)
+	requireMTLS(t)
+
+	cert, err := mtls.LoadKeyPair(opts.RevokedCertFile, opts.RevokedKeyFile, opts.KeyPass)
+	if err != nil {
+		t.Skipf("Revoked certificate not available: %v", err)
+	}
+
+	expectRejected(t, &cert, alertsRevoked)
+}
+
+func requireMTLS(t *testing.T) {
+	t.Helper()
+	if opts.Insecure {
+		t.Skip("mTLS tests need TLS; running with -insecure")
+	}
+}
+
+// expectRejected makes a request presenting cert (or no certificate if nil)
+// and fails unless it is refused with one of alerts.
+func expectRejected(t *testing.T, cert *tls.Certificate, alerts []string) {
+	t.Helper()
+
+	client, err := presentingClient(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.CloseIdleConnections()
+	status, err := listModelsStatus(client)
+	if err == nil {
+		t.Fatalf("Server accepted the connection (status %d)", status)
+	}
+
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &recordErr) {
+		t.Fatalf("Expected a TLS alert, got a non-TLS response: %v", err)
+	}
+
+	for _, expected := range alerts {
+		if strings.Contains(err.Error(), expected) {
+			t.Logf("Rejected as expected: %v", err)
+			return
+		}
+	}
+	t.Errorf("Rejected with unexpected error (want one of %q): %v", alerts, err)
+}
+
+// presentingClient returns an HTTP client whose connections present cert,
+// or no certificate if nil.
+func presentingClient(cert *tls.Certificate) (*http.Client, error) {
+	pool, err := mtls.LoadCAPool(opts.CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:    pool,
+		ServerName: opts.serverName(),
+		MinVersion: tls.VersionTLS12,
+	}
+	if cert != nil {
+		// Present cert even if its issuer is not among the CAs the server
+		// asked for, which crypto/tls would otherwise check
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert, nil
+		}
+	}
+
+	proxy, err := opts.proxyFunc()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
+	}
+	transport := &http.Transport{TLSClientConfig: tlsConfig, Proxy: proxy}
+	return &http.Client{Transport: withHostOverride(opts, transport), Timeout: 10 * time.Second}, nil
+}
+
+// listModelsStatus lists the models and returns the response status.
+func listModelsStatus(client *http.Client) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(opts.apiBaseURL(), "/")+"/models", nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+opts.APIKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// newTestCA creates a self-signed ECDSA CA.
+func newTestCA(name string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"MockOpenAI"}, CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// issueClientCert issues a client certificate valid from notBefore to
+// notAfter, signed by ca.
+func issueClientCert(ca *x509.Certificate, caKey any, name string, notBefore, notAfter time.Time) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"MockOpenAI"}, CommonName: name},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("signing certificate: %w", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
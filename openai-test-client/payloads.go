@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// =============================================================================
+// Payload Capture
+// =============================================================================
+
+// payloadsEnv names a file that the child test runner appends captured
+// requests and responses to, so that the parent can show them in the HTML
+// report.
+const payloadsEnv = "OPENAI_TEST_CLIENT_PAYLOADS"
+
+const (
+	payloadSnippet  = 2048 // bytes of each body kept
+	payloadsPerTest = 4    // exchanges kept for each test
+)
+
+// Payload is one request made by a test and its response, with each body
+// cut to payloadSnippet bytes.
+type Payload struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Status    int    `json:"status,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Request   string `json:"request,omitempty"`
+	Response  string `json:"response,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// payloadRecord is a Payload as written to the payloads file.
+type payloadRecord struct {
+	Test string `json:"test"`
+	Payload
+}
+
+// payloads records the first exchanges of each test when the child runs
+// with a payloads file.
+var payloads = &payloadRecorder{}
+
+type payloadRecorder struct {
+	mu     sync.Mutex
+	sink   io.Writer
+	counts map[string]int
+}
+
+func (p *payloadRecorder) enabled() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sink != nil
+}
+
+// reserve reports whether another exchange of test should be captured.
+func (p *payloadRecorder) reserve(test string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sink == nil || p.counts[test] >= payloadsPerTest {
+		return false
+	}
+	if p.counts == nil {
+		p.counts = make(map[string]int)
+	}
+	p.counts[test]++
+	return true
+}
+
+func (p *payloadRecorder) record(test string, payload Payload) {
+	line, err := json.Marshal(payloadRecord{Test: test, Payload: payload})
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sink.Write(append(line, '\n'))
+}
+
+// readPayloads loads the exchanges written by a child's payloadRecorder,
+// by test name.
+func readPayloads(path string) (map[string][]Payload, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	byTest := make(map[string][]Payload)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record payloadRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		byTest[record.Test] = append(byTest[record.Test], record.Payload)
+	}
+	return byTest, scanner.Err()
+}
+
+// captureTransport records the request and response bodies of a test's
+// first payloadsPerTest requests. The response is recorded once its body
+// has been read to the end or closed, so a stream shows its first chunks.
+type captureTransport struct {
+	next http.RoundTripper
+	test string
+}
+
+func (c captureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !payloads.reserve(c.test) {
+		return c.next.RoundTrip(req)
+	}
+
+	payload := Payload{Method: req.Method, Path: req.URL.Path}
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(io.LimitReader(body, payloadSnippet+1))
+			body.Close()
+			payload.Request, payload.Truncated = snippet(data)
+		}
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		payload.Error = err.Error()
+		payloads.record(c.test, payload)
+		return nil, err
+	}
+	payload.Status = resp.StatusCode
+	resp.Body = &capturedBody{ReadCloser: resp.Body, test: c.test, payload: payload}
+	return resp, nil
+}
+
+// snippet cuts data to payloadSnippet bytes and reports whether it did.
+func snippet(data []byte) (string, bool) {
+	if len(data) > payloadSnippet {
+		return string(data[:payloadSnippet]), true
+	}
+	return string(data), false
+}
+
+type capturedBody struct {
+	io.ReadCloser
+	test    string
+	payload Payload
+	data    []byte
+	more    bool
+	once    sync.Once
+}
+
+func (b *capturedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := payloadSnippet - len(b.data); room > 0 {
+		b.data = append(b.data, p[:min(n, room)]...)
+		b.more = b.more || n > room
+	} else if n > 0 {
+		b.more = true
+	}
+	if err == io.EOF {
+		b.done()
+	}
+	return n, err
+}
+
+func (b *capturedBody) Close() error {
+	b.done()
+	return b.ReadCloser.Close()
+}
+
+func (b *capturedBody) done() {
+	b.once.Do(func() {
+		b.payload.Response = string(b.data)
+		b.payload.Truncated = b.payload.Truncated || b.more
+		payloads.record(b.test, b.payload)
+	})
+}
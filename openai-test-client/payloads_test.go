@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCaptureTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(strings.Repeat("x", payloadSnippet+10)))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "payloads")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	saved := payloads
+	payloads = &payloadRecorder{sink: f}
+	t.Cleanup(func() { payloads = saved })
+
+	client := &http.Client{Transport: captureTransport{next: http.DefaultTransport, test: "TestX"}}
+	for i := 0; i < payloadsPerTest+2; i++ {
+		resp, err := client.Post(server.URL+"/v1/embeddings", "application/json", bytes.NewReader([]byte(`{"input":"hi"}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	captured, err := readPayloads(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := captured["TestX"]
+	if len(got) != payloadsPerTest {
+		t.Fatalf("Captured %d exchanges, want %d", len(got), payloadsPerTest)
+	}
+	p := got[0]
+	if p.Method != "POST" || p.Path != "/v1/embeddings" || p.Status != 200 || p.Request != `{"input":"hi"}` {
+		t.Errorf("Captured %+v", p)
+	}
+	if len(p.Response) != payloadSnippet || !p.Truncated {
+		t.Errorf("Response of %d bytes, truncated %v; want %d bytes, truncated", len(p.Response), p.Truncated, payloadSnippet)
+	}
+}
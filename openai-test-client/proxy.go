@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// =============================================================================
+// Proxy Configuration
+// =============================================================================
+
+// proxyURL returns -proxy if set, otherwise the HTTPS_PROXY or HTTP_PROXY
+// environment variable for the target's scheme, unless NO_PROXY excludes the
+// target. Unlike http.ProxyFromEnvironment it proxies localhost too, since
+// the mock server usually runs there.
+func (o Options) proxyURL() string {
+	if o.ProxyURL != "" {
+		return o.ProxyURL
+	}
+
+	target, err := url.Parse(o.apiBaseURL())
+	if err != nil {
+		return ""
+	}
+	if noProxy(target.Hostname(), getenvAny("NO_PROXY", "no_proxy")) {
+		return ""
+	}
+	if target.Scheme == "https" {
+		return getenvAny("HTTPS_PROXY", "https_proxy")
+	}
+	return getenvAny("HTTP_PROXY", "http_proxy")
+}
+
+// proxyFunc returns a Transport.Proxy function for o, or nil for direct
+// connections.
+func (o Options) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	raw := o.proxyURL()
+	if raw == "" {
+		return nil, nil
+	}
+	proxy, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if proxy.Scheme == "" || proxy.Host == "" {
+		// HTTPS_PROXY is often set as host:port
+		if proxy, err = url.Parse("http://" + raw); err != nil {
+			return nil, err
+		}
+	}
+	return http.ProxyURL(proxy), nil
+}
+
+func getenvAny(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// noProxy reports whether host matches a NO_PROXY list: "*", an exact host,
+// or a domain suffix such as ".example.com" or "example.com".
+func noProxy(host, list string) bool {
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// Proxy Chain Test
+// =============================================================================
+
+// testProxyChain checks the client → proxy → server chain: that HTTPS goes
+// through a CONNECT tunnel with mTLS end to end inside it, and that streamed
+// chunks are not buffered by the proxy.
+func testProxyChain(t *testing.T) {
+	ctx, _ := setup(t)
+	if opts.proxyURL() == "" {
+		t.Skip("No proxy configured; set -proxy or HTTPS_PROXY")
+	}
+
+	transport, err := newTransport(opts)
+	if err != nil {
+		t.Fatalf("Failed to configure transport: %v", err)
+	}
+	defer transport.CloseIdleConnections()
+	var connectStatus int
+	transport.OnProxyConnectResponse = func(_ context.Context, _ *url.URL, _ *http.Request, resp *http.Response) error {
+		connectStatus = resp.StatusCode
+		return nil
+	}
+
+	models, state, err := listModelsThrough(transport)
+	if err != nil {
+		t.Fatalf("Request through proxy failed: %v", err)
+	}
+	t.Logf("Listed %d models through %s", models, opts.proxyURL())
+
+	if !opts.Insecure {
+		t.Run("Connect", func(t *testing.T) {
+			if connectStatus != http.StatusOK {
+				t.Errorf("Expected CONNECT to return 200, got %d", connectStatus)
+			}
+		})
+		t.Run("MTLS", func(t *testing.T) {
+			if state == nil {
+				t.Fatal("Response did not come over TLS")
+			}
+			if len(state.VerifiedChains) == 0 {
+				t.Error("Server certificate was not verified through the tunnel")
+			}
+		})
+	}
+
+	t.Run("Streaming", func(t *testing.T) {
+		proxied := newClientWithTransport(opts, transport)
+		timer := newStreamTimer()
+		stream, err := proxied.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+			Model: openai.GPT4o,
+			Messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser, Content: "Hello!"},
+			},
+			Stream: true,
+		})
+		if err != nil {
+			t.Fatalf("Error creating stream: %v", err)
+		}
+		defer stream.Close()
+
+		for {
+			if _, err := stream.Recv(); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatalf("Error receiving chunk: %v", err)
+			}
+			timer.chunk()
+		}
+		total := time.Since(timer.start)
+
+		// A buffering proxy delivers the first chunk only once the
+		// stream has finished
+		if timer.firstChunk > total/2 {
+			t.Errorf("First chunk after %v of %v; the proxy appears to buffer streams", timer.firstChunk, total)
+		}
+	})
+}
+
+// listModelsThrough lists models using transport and returns how many there
+// are and the TLS state of the connection.
+func listModelsThrough(transport *http.Transport) (int, *tls.ConnectionState, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(opts.apiBaseURL(), "/")+"/models", nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+opts.APIKey)
+
+	resp, err := (&http.Client{Transport: withHostOverride(opts, transport), Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var list openai.ModelsList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return 0, nil, err
+	}
+	return len(list.Models), resp.TLS, nil
+}
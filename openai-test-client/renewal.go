@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"mtls"
+)
+
+// =============================================================================
+// Client Certificate Renewal
+// =============================================================================
+
+// With -renew-url the client certificate may be short-lived, lasting
+// hours: two thirds of the way through its life it is renewed from a CA
+// service, such as the mock server's POST /ca/sign, and presented from the
+// next handshake, so a long soak or load run outlives it. The renewed
+// certificate is kept in memory; files replaced on disk still take its
+// place, as they do without renewal.
+
+// renewRetry is how long a failed renewal waits before trying again.
+const renewRetry = time.Minute
+
+// renewalTime returns when leaf is due to be renewed: two thirds of the
+// way through its life.
+func renewalTime(leaf *x509.Certificate) time.Time {
+	return leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) * 2 / 3)
+}
+
+// generateKeyLike generates a key of the same type and size as pub.
+func generateKeyLike(pub crypto.PublicKey) (crypto.Signer, error) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	case *rsa.PublicKey:
+		return rsa.GenerateKey(rand.Reader, pub.N.BitLen())
+	case ed25519.PublicKey:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unsupported key type %T", pub)
+}
+
+// requestCertificate generates a key like that of leaf and asks the CA
+// service at url, through client, for a certificate for it with the
+// subject and names of leaf. It returns the new identity, with any chain
+// the service sent.
+func requestCertificate(client *http.Client, url string, leaf *x509.Certificate) (*tls.Certificate, error) {
+	key, err := generateKeyLike(leaf.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:        leaf.Subject,
+		DNSNames:       leaf.DNSNames,
+		EmailAddresses: leaf.EmailAddresses,
+		IPAddresses:    leaf.IPAddresses,
+		URIs:           leaf.URIs,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Post(url, "application/x-pem-file", bytes.NewReader(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s: %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	cert := &tls.Certificate{PrivateKey: key}
+	for block, rest := pem.Decode(body); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("%s sent no certificate", url)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(cert.Leaf.PublicKey) {
+		return nil, fmt.Errorf("%s sent a certificate for another key", url)
+	}
+	return cert, nil
+}
+
+// servedLeaf parses the certificate r serves now.
+func servedLeaf(r *mtls.Reloader) (*x509.Certificate, error) {
+	return x509.ParseCertificate(r.Certificate().Certificate[0])
+}
+
+// renew replaces the certificate r serves with one from the CA service at
+// url, requested through client, until the files change.
+func renew(r *mtls.Reloader, client *http.Client, url string) (*x509.Certificate, error) {
+	leaf, err := servedLeaf(r)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := requestCertificate(client, url, leaf)
+	if err != nil {
+		return nil, err
+	}
+	r.SetCertificate(cert)
+	return cert.Leaf, nil
+}
+
+// renewEvery renews the certificate r serves from url whenever it is due,
+// for as long as the client runs, noting each renewal on stderr, which is
+// the test log when the suite runs.
+func renewEvery(r *mtls.Reloader, client *http.Client, url string) {
+	for {
+		if leaf, err := servedLeaf(r); err == nil {
+			time.Sleep(time.Until(renewalTime(leaf)))
+		}
+		if leaf, err := renew(r, client, url); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to renew the client certificate: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Renewed the client certificate %s (serial %s) until %s\n", leaf.Subject, leaf.SerialNumber.Text(16), leaf.NotAfter.UTC().Format(time.RFC3339))
+		}
+		time.Sleep(renewRetry)
+	}
+}
+
+// renewing holds the mtls.Reloader of each client certificate renewed with
+// -renew-url. Every transport that presents the certificate shares it, so
+// it is renewed once however many clients the suite builds.
+var renewing = struct {
+	sync.Mutex
+	reloaders map[string]*mtls.Reloader
+}{reloaders: make(map[string]*mtls.Reloader)}
+
+// renewingCertReloader returns the mtls.Reloader for the client certificate
+// of o, renewed from o.RenewURL, starting its renewals on first use. The
+// CA service is reached as the API is, with the CA and any proxy of o.
+func renewingCertReloader(o Options) (*mtls.Reloader, error) {
+	renewing.Lock()
+	defer renewing.Unlock()
+	id := strings.Join([]string{o.CertFile, o.KeyFile, o.RenewURL}, "\x00")
+	if r := renewing.reloaders[id]; r != nil {
+		return r, nil
+	}
+
+	r, err := mtls.NewReloader(o.CertFile, o.KeyFile, o.KeyPass)
+	if err != nil {
+		return nil, err
+	}
+	url := o.RenewURL
+	o.RenewURL = ""
+	transport, err := newTransport(o)
+	if err != nil {
+		return nil, err
+	}
+	// Each renewal connects afresh, to present the current certificate
+	transport.TLSClientConfig.GetClientCertificate = r.GetClientCertificate
+	transport.DisableKeepAlives = true
+	go renewEvery(r, &http.Client{Transport: transport, Timeout: 30 * time.Second}, url)
+
+	renewing.reloaders[id] = r
+	return r, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mtls"
+)
+
+func TestCertRenewal(t *testing.T) {
+	caCert, caKey, err := newTestCA("Renewal-CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.crt")
+	write := func(name string) {
+		cert, err := issueClientCert(caCert, caKey, name, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeCertFiles(certFile, keyFile, cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("renewed-client")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A CA service like the mock server's, signing CSRs from its clients
+	// for ten minutes
+	var presented string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented = r.TLS.PeerCertificates[0].SerialNumber.Text(16)
+		body, _ := io.ReadAll(r.Body)
+		block, _ := pem.Decode(body)
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil || csr.CheckSignature() != nil {
+			http.Error(w, "bad CSR", http.StatusBadRequest)
+			return
+		}
+		serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: serial,
+			Subject:      csr.Subject,
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(10 * time.Minute),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, caCert, csr.PublicKey, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	srv.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	r, err := mtls.NewReloader(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := servedLeaf(r)
+	transport := srv.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.GetClientCertificate = r.GetClientCertificate
+	transport.DisableKeepAlives = true
+	client := &http.Client{Transport: transport}
+
+	// Each renewal is requested with the certificate it replaces, for the
+	// same subject and a key of the same type
+	previous := first
+	for i := range 2 {
+		leaf, err := renew(r, client, srv.URL+"/ca/sign")
+		if err != nil {
+			t.Fatalf("renewal %d: %v", i+1, err)
+		}
+		if presented != previous.SerialNumber.Text(16) {
+			t.Errorf("renewal %d presented serial %s, want %s", i+1, presented, previous.SerialNumber.Text(16))
+		}
+		if served, _ := servedLeaf(r); served.SerialNumber.Cmp(leaf.SerialNumber) != 0 || served.Subject.CommonName != "renewed-client" {
+			t.Errorf("renewal %d: serving %s serial %s", i+1, served.Subject, served.SerialNumber)
+		}
+		if leaf.PublicKeyAlgorithm != first.PublicKeyAlgorithm {
+			t.Errorf("renewal %d: %v key", i+1, leaf.PublicKeyAlgorithm)
+		}
+		previous = leaf
+	}
+
+	// Files replaced on disk take the place of the renewed certificate
+	write("rotated-client")
+	if served, _ := servedLeaf(r); served.Subject.CommonName != "rotated-client" {
+		t.Errorf("serving %s after the files were replaced", served.Subject)
+	}
+
+	// Transports share the renewing reloader of a certificate
+	o := Options{CertFile: certFile, KeyFile: keyFile, CAFile: caFile, RenewURL: srv.URL + "/ca/sign"}
+	a, err := renewingCertReloader(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := renewingCertReloader(o); err != nil || a != b {
+		t.Errorf("second renewing reloader: %p, %p, %v", a, b, err)
+	}
+
+	start := time.Now()
+	if due := renewalTime(&x509.Certificate{NotBefore: start, NotAfter: start.Add(3 * time.Hour)}); !due.Equal(start.Add(2 * time.Hour)) {
+		t.Errorf("renewalTime = %v, want two hours on", due.Sub(start))
+	}
+}
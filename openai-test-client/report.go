@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mtls"
+)
+
+// runnerEnv is set in the environment of the child process that runs the
+// tests. The parent parses the child's `-test.v` output into a Report, which
+// testing.Main would not allow since it exits when the tests finish.
+const runnerEnv = "OPENAI_TEST_CLIENT_RUNNER"
+
+// TestResult is the outcome of one test or subtest.
+type TestResult struct {
+	Name    string   `json:"name"`
+	Status  string   `json:"status"` // "pass", "fail" or "skip"
+	Elapsed float64  `json:"elapsed"`
+	Output  []string `json:"output,omitempty"`
+
+	// Payloads are the test's first requests and responses, captured for
+	// the HTML report.
+	Payloads []Payload `json:"payloads,omitempty"`
+}
+
+// Report is the result of a suite run.
+type Report struct {
+	Target    string       `json:"target"`
+	Timestamp time.Time    `json:"timestamp"`
+	Elapsed   float64      `json:"elapsed"`
+	OK        bool         `json:"ok"`
+	Passed    int          `json:"passed"`
+	Failed    int          `json:"failed"`
+	Skipped   int          `json:"skipped"`
+	Tests     []TestResult `json:"tests"`
+
+	// Latency summarises the time to headers and full response of every
+	// request, and time to first chunk and inter-chunk gaps of streams.
+	Latency map[string]LatencyStats `json:"latency,omitempty"`
+
+	// TLS is the connection negotiated with the target, collected for
+	// -tls-info and the HTML report.
+	TLS *TLSInfo `json:"tls,omitempty"`
+
+	// Certificates are the client certificate, with any chain, and the
+	// CA, with when each expires.
+	Certificates []mtls.CertExpiry `json:"certificates,omitempty"`
+}
+
+// runSuite runs the tests in a child copy of this binary with args, copying
+// its output to log, and returns the parsed results. With capture, each
+// result includes the test's first requests and responses.
+func runSuite(args []string, log io.Writer, capture bool) (*Report, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	timingsFile, err := os.CreateTemp("", "openai-test-client-timings-")
+	if err != nil {
+		return nil, err
+	}
+	timingsFile.Close()
+	defer os.Remove(timingsFile.Name())
+
+	cmd := exec.Command(self, args...)
+	cmd.Env = append(os.Environ(), runnerEnv+"=1", timingsEnv+"="+timingsFile.Name())
+	var payloadsFile *os.File
+	if capture {
+		if payloadsFile, err = os.CreateTemp("", "openai-test-client-payloads-"); err != nil {
+			return nil, err
+		}
+		payloadsFile.Close()
+		defer os.Remove(payloadsFile.Name())
+		cmd.Env = append(cmd.Env, payloadsEnv+"="+payloadsFile.Name())
+	}
+	cmd.Stderr = log
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Timestamp: time.Now()}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	parseTestOutput(io.TeeReader(stdout, log), report)
+
+	err = cmd.Wait()
+	report.Elapsed = time.Since(report.Timestamp).Seconds()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+	report.OK = err == nil
+
+	recorded, err := readTimings(timingsFile.Name())
+	if err != nil {
+		return nil, err
+	}
+	report.Latency = recorded.summary()
+
+	if capture {
+		captured, err := readPayloads(payloadsFile.Name())
+		if err != nil {
+			return nil, err
+		}
+		for i := range report.Tests {
+			report.Tests[i].Payloads = captured[report.Tests[i].Name]
+		}
+	}
+	return report, nil
+}
+
+var (
+	frameLine  = regexp.MustCompile(`^=== (RUN|PAUSE|CONT|NAME)\s+(\S+)`)
+	resultLine = regexp.MustCompile(`^\s*--- (PASS|FAIL|SKIP): (\S+) \(([0-9.]+)s\)`)
+)
+
+// parseTestOutput reads `go test -v` output, attributing each log line to the
+// test most recently named by a === line.
+func parseTestOutput(r io.Reader, report *Report) {
+	index := make(map[string]int)
+	current := ""
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if m := frameLine.FindStringSubmatch(line); m != nil {
+			current = m[2]
+			if _, ok := index[current]; !ok {
+				index[current] = len(report.Tests)
+				report.Tests = append(report.Tests, TestResult{Name: current})
+			}
+			continue
+		}
+
+		if m := resultLine.FindStringSubmatch(line); m != nil {
+			i, ok := index[m[2]]
+			if !ok {
+				continue
+			}
+			result := &report.Tests[i]
+			result.Status = strings.ToLower(m[1])
+			result.Elapsed, _ = strconv.ParseFloat(m[3], 64)
+			switch result.Status {
+			case "pass":
+				report.Passed++
+			case "fail":
+				report.Failed++
+			case "skip":
+				report.Skipped++
+			}
+			continue
+		}
+
+		if i, ok := index[current]; ok && strings.HasPrefix(line, "    ") {
+			report.Tests[i].Output = append(report.Tests[i].Output, strings.TrimSpace(line))
+		}
+	}
+}
+
+// =============================================================================
+// Report Writers
+// =============================================================================
+
+func writeJSONReport(w io.Writer, report *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// writeJSONFile writes v as indented JSON to path, or to stdout if path is
+// empty. It is used for the load and conformance reports.
+func writeJSONFile(path string, v any) error {
+	if path == "" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Classname string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func writeJUnitReport(w io.Writer, report *Report) error {
+	suite := junitTestSuite{
+		Name:       "openai-test-client",
+		Tests:      len(report.Tests),
+		Failures:   report.Failed,
+		Skipped:    report.Skipped,
+		Time:       fmt.Sprintf("%.3f", report.Elapsed),
+		Timestamp:  report.Timestamp.Format(time.RFC3339),
+		Properties: []junitProperty{{Name: "target", Value: report.Target}},
+	}
+	for _, c := range report.Certificates {
+		suite.Properties = append(suite.Properties, junitProperty{Name: "certificate " + c.Use + " " + c.Subject, Value: "expires " + c.NotAfter.UTC().Format(time.RFC3339)})
+	}
+
+	for _, result := range report.Tests {
+		output := strings.Join(result.Output, "\n")
+		tc := junitTestCase{
+			Classname: "openai-test-client",
+			Name:      result.Name,
+			Time:      fmt.Sprintf("%.3f", result.Elapsed),
+		}
+		switch result.Status {
+		case "fail":
+			tc.Failure = &junitMessage{Message: firstLine(result.Output, "failed"), Body: output}
+		case "skip":
+			tc.Skipped = &junitMessage{Message: firstLine(result.Output, "skipped")}
+		default:
+			tc.SystemOut = output
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	suites := junitTestSuites{
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// firstLine returns the first output line, which for a failure is its first
+// assertion message, or fallback if there is no output.
+func firstLine(output []string, fallback string) string {
+	if len(output) == 0 {
+		return fallback
+	}
+	return output[0]
+}
+
+func printSummary(w io.Writer, report *Report) {
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, strings.Repeat("=", 60))
+	fmt.Fprintf(w, "%s%s                    TEST SUMMARY%s\n", colorBold, colorCyan, colorReset)
+	fmt.Fprintln(w, strings.Repeat("=", 60))
+
+	fmt.Fprintf(w, "\nTotal Tests: %d\n", len(report.Tests))
+	fmt.Fprintf(w, "%sPassed: %d%s\n", colorGreen, report.Passed, colorReset)
+	fmt.Fprintf(w, "%sFailed: %d%s\n", colorRed, report.Failed, colorReset)
+	if report.Skipped > 0 {
+		fmt.Fprintf(w, "%sSkipped: %d%s\n", colorYellow, report.Skipped, colorReset)
+	}
+
+	if len(report.Latency) > 0 {
+		fmt.Fprintln(w)
+		printLatencyTable(w, report.Latency)
+	}
+
+	if report.Failed > 0 {
+		fmt.Fprintf(w, "\n%sFailed Tests:%s\n", colorRed, colorReset)
+		for _, r := range report.Tests {
+			if r.Status == "fail" {
+				fmt.Fprintf(w, "  - %s: %s\n", r.Name, firstLine(r.Output, "failed"))
+			}
+		}
+	}
+
+	fmt.Fprintln(w)
+	if report.Failed == 0 && report.OK {
+		fmt.Fprintf(w, "%s%sAll tests passed!%s\n", colorBold, colorGreen, colorReset)
+	} else {
+		fmt.Fprintf(w, "%s%sSome tests failed.%s\n", colorBold, colorRed, colorReset)
+	}
+	fmt.Fprintln(w, strings.Repeat("=", 60))
+}
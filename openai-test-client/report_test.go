@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"mtls"
+)
+
+// goTestOutput is `go test -v` output of a passing test with a subtest, a
+// failing test whose output would need escaping in XML, and a skipped test.
+const goTestOutput = `=== RUN   TestModels
+=== RUN   TestModels/List
+--- PASS: TestModels (0.12s)
+    --- PASS: TestModels/List (0.10s)
+=== RUN   TestChat
+    suite.go:10: got "a<b" & "` + "\x1b[31mred\x1b[0m" + `", want ]]> end
+    suite.go:11: second line
+--- FAIL: TestChat (1.50s)
+=== RUN   TestAudio
+    suite.go:20: mock has no audio support
+--- SKIP: TestAudio (0.00s)
+FAIL
+`
+
+func parsedReport(t *testing.T) *Report {
+	t.Helper()
+	report := &Report{Target: "https://localhost:8443/v1", Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), Elapsed: 1.62}
+	parseTestOutput(strings.NewReader(goTestOutput), report)
+	return report
+}
+
+func TestParseTestOutput(t *testing.T) {
+	report := parsedReport(t)
+	if report.Passed != 2 || report.Failed != 1 || report.Skipped != 1 || len(report.Tests) != 4 {
+		t.Fatalf("passed %d, failed %d, skipped %d, tests %+v", report.Passed, report.Failed, report.Skipped, report.Tests)
+	}
+	for i, want := range []TestResult{
+		{Name: "TestModels", Status: "pass", Elapsed: 0.12},
+		{Name: "TestModels/List", Status: "pass", Elapsed: 0.10},
+		{Name: "TestChat", Status: "fail", Elapsed: 1.50},
+		{Name: "TestAudio", Status: "skip"},
+	} {
+		if got := report.Tests[i]; got.Name != want.Name || got.Status != want.Status || got.Elapsed != want.Elapsed {
+			t.Errorf("test %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if output := report.Tests[2].Output; len(output) != 2 || output[1] != "suite.go:11: second line" {
+		t.Errorf("TestChat output = %q", output)
+	}
+}
+
+func TestWriteJSONReport(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeJSONReport(&b, parsedReport(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Target    string    `json:"target"`
+		Timestamp time.Time `json:"timestamp"`
+		Elapsed   float64   `json:"elapsed"`
+		OK        *bool     `json:"ok"`
+		Passed    int       `json:"passed"`
+		Failed    int       `json:"failed"`
+		Skipped   int       `json:"skipped"`
+		Tests     []struct {
+			Name    string   `json:"name"`
+			Status  string   `json:"status"`
+			Elapsed *float64 `json:"elapsed"`
+			Output  []string `json:"output"`
+		} `json:"tests"`
+	}
+	dec := json.NewDecoder(&b)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("%v:\n%s", err, b.String())
+	}
+	if got.Target != "https://localhost:8443/v1" || got.Timestamp.IsZero() || got.Elapsed != 1.62 || got.OK == nil || got.Passed != 2 || got.Failed != 1 || got.Skipped != 1 {
+		t.Errorf("report = %+v", got)
+	}
+	if len(got.Tests) != 4 || got.Tests[2].Status != "fail" || got.Tests[3].Status != "skip" || got.Tests[0].Elapsed == nil || len(got.Tests[2].Output) != 2 {
+		t.Errorf("tests = %+v", got.Tests)
+	}
+}
+
+func TestWriteJUnitReport(t *testing.T) {
+	report := parsedReport(t)
+	report.Certificates = []mtls.CertExpiry{{Use: "client", Subject: "CN=client", NotAfter: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)}}
+	var b bytes.Buffer
+	if err := writeJUnitReport(&b, report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(b.String(), xml.Header) {
+		t.Errorf("no XML declaration:\n%s", b.String())
+	}
+
+	// The report is well-formed XML, even with test output that has
+	// markup and control characters in it
+	dec := xml.NewDecoder(bytes.NewReader(b.Bytes()))
+	for {
+		if _, err := dec.Token(); err != nil {
+			if err != io.EOF {
+				t.Fatalf("invalid XML: %v\n%s", err, b.String())
+			}
+			break
+		}
+	}
+
+	var got junitTestSuites
+	if err := xml.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Tests != 4 || got.Failures != 1 || got.Skipped != 1 || got.Time != "1.620" || len(got.Suites) != 1 {
+		t.Fatalf("testsuites = %+v", got)
+	}
+	suite := got.Suites[0]
+	if suite.Tests != 4 || suite.Failures != 1 || suite.Skipped != 1 || suite.Timestamp != "2026-01-02T03:04:05Z" {
+		t.Errorf("testsuite: tests %d, failures %d, skipped %d, timestamp %s", suite.Tests, suite.Failures, suite.Skipped, suite.Timestamp)
+	}
+	if len(suite.Properties) != 2 || suite.Properties[0] != (junitProperty{Name: "target", Value: "https://localhost:8443/v1"}) || suite.Properties[1].Value != "expires 2027-01-01T00:00:00Z" {
+		t.Errorf("properties = %+v", suite.Properties)
+	}
+
+	cases := suite.Cases
+	if len(cases) != 4 {
+		t.Fatalf("testcases = %+v", cases)
+	}
+	for _, tc := range cases[:2] {
+		if tc.Failure != nil || tc.Skipped != nil {
+			t.Errorf("%s passed but is marked failed or skipped", tc.Name)
+		}
+	}
+	if cases[1].Name != "TestModels/List" || cases[1].Time != "0.100" {
+		t.Errorf("subtest = %+v", cases[1])
+	}
+	failed := cases[2]
+	if failed.Failure == nil || failed.Skipped != nil {
+		t.Fatalf("%s is not marked failed: %+v", failed.Name, failed)
+	}
+	if !strings.HasPrefix(failed.Failure.Message, `suite.go:10: got "a<b" & `) || !strings.Contains(failed.Failure.Body, "]]> end\nsuite.go:11: second line") {
+		t.Errorf("failure = %+v", failed.Failure)
+	}
+	skipped := cases[3]
+	if skipped.Skipped == nil || skipped.Failure != nil || skipped.Skipped.Message != "suite.go:20: mock has no audio support" {
+		t.Errorf("%s is not marked skipped: %+v", skipped.Name, skipped)
+	}
+}
+
+func TestPrintSummaryNoColor(t *testing.T) {
+	report := &Report{
+		Failed: 1,
+		Passed: 1,
+		Tests: []TestResult{
+			{Name: "TestA", Status: "pass"},
+			{Name: "TestB", Status: "fail", Output: []string{"suite.go:1: boom"}},
+		},
+	}
+
+	var colored strings.Builder
+	printSummary(&colored, report)
+	if !strings.Contains(colored.String(), "\033[") {
+		t.Fatal("summary has no ANSI colors before disableColor")
+	}
+
+	saved := []string{colorReset, colorGreen, colorRed, colorYellow, colorCyan, colorBold}
+	t.Cleanup(func() {
+		colorReset, colorGreen, colorRed, colorYellow, colorCyan, colorBold = saved[0], saved[1], saved[2], saved[3], saved[4], saved[5]
+	})
+	disableColor()
+
+	var plain strings.Builder
+	printSummary(&plain, report)
+	if strings.Contains(plain.String(), "\033[") {
+		t.Errorf("summary has ANSI escapes after disableColor:\n%s", plain.String())
+	}
+	for _, want := range []string{"Failed: 1", "TestB: suite.go:1: boom", "Some tests failed."} {
+		if !strings.Contains(plain.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, plain.String())
+		}
+	}
+}
+
+func TestWriteHTMLReport(t *testing.T) {
+	report := &Report{
+		Target: "https://localhost:8443/v1",
+		Failed: 1,
+		Passed: 1,
+		Tests: []TestResult{
+			{Name: "TestA", Status: "pass", Payloads: []Payload{{Method: "POST", Path: "/v1/chat/completions", Status: 200, Request: `{"model":"gpt-4o"}`, Response: `{"id":"chatcmpl-1"}`}}},
+			{Name: "TestA/Sub", Status: "fail", Output: []string{"suite.go:1: got <script>alert(1)</script>"}},
+		},
+		Latency: map[string]LatencyStats{metricTTFB: {Count: 3, P50: 1.5}},
+		TLS: &TLSInfo{Version: "TLS 1.3", CipherSuite: "TLS_AES_128_GCM_SHA256", Chain: []CertInfo{
+			{Subject: "CN=localhost", Issuer: "CN=MockOpenAI-CA", NotAfter: time.Now().Add(-time.Hour)},
+		}, Matrix: []TLSCombination{
+			{Version: "TLS 1.0", Legacy: true, Accepted: true},
+		}},
+	}
+
+	var b strings.Builder
+	if err := writeHTMLReport(&b, report); err != nil {
+		t.Fatal(err)
+	}
+	html := b.String()
+	for _, want := range []string{
+		"Some tests failed",
+		"TLS 1.3",
+		`class="expired"`,
+		"Time to headers",
+		`<span class="status fail">accepted</span> legacy`,
+		"POST /v1/chat/completions",
+		"&#34;model&#34;:&#34;gpt-4o&#34;",
+		"<details open><summary>Sub</summary>",
+		"&lt;script&gt;",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("report lacks %q", want)
+		}
+	}
+	if strings.Contains(html, "<script>") {
+		t.Error("test output is not escaped")
+	}
+}
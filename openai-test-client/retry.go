@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// =============================================================================
+// Retries
+// =============================================================================
+
+// maxBackoff caps the exponential backoff between retries.
+const maxBackoff = 30 * time.Second
+
+// retryTransport retries requests that fail with a status that a client
+// should retry (429, 502, 503, 504), up to retries times. The delay doubles
+// from backoff on each attempt, with jitter, and is at least the server's
+// Retry-After.
+type retryTransport struct {
+	next    http.RoundTripper
+	retries int
+	backoff time.Duration
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || attempt >= t.retries || !retryable(resp.StatusCode) {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			// The body has been consumed and cannot be replayed
+			return resp, nil
+		}
+
+		delay := retryDelay(attempt, t.backoff, resp.Header.Get("Retry-After"))
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns the wait before retry attempt+1: backoff doubled per
+// attempt with up to 50% jitter, or Retry-After seconds if longer.
+func retryDelay(attempt int, backoff time.Duration, retryAfter string) time.Duration {
+	delay := backoff << attempt
+	if delay <= 0 || delay > maxBackoff {
+		delay = maxBackoff
+	}
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		if d := time.Duration(seconds) * time.Second; d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// =============================================================================
+// Overload Tests
+// =============================================================================
+
+// maxHeldStreams bounds how many concurrent streams testOverload opens while
+// looking for the server's concurrency limit.
+const maxHeldStreams = 64
+
+// testOverload fills the server's concurrency limit (the mock's
+// -max-concurrent) with open streams, then checks that the next request is
+// rejected with a retryable 429 or 503, and that a client with retries
+// enabled gets through once capacity frees up. It does not run in parallel,
+// so that the other tests do not see the server overloaded.
+func testOverload(t *testing.T) {
+	ctx, _ := setupSerial(t)
+
+	transport, err := newTransport(opts)
+	if err != nil {
+		t.Fatalf("Failed to configure transport: %v", err)
+	}
+	defer transport.CloseIdleConnections()
+	httpClient := &http.Client{Transport: withHostOverride(opts, transport)}
+
+	// Hold streams open until one is rejected. Each stream occupies a slot
+	// until the server has written all of it, which takes ~500ms.
+	var held []*http.Response
+	defer func() {
+		for _, resp := range held {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	var rejected *http.Response
+	for len(held) < maxHeldStreams {
+		resp, err := postChat(ctx, httpClient, true)
+		if err != nil {
+			t.Fatalf("Failed to open stream %d: %v", len(held)+1, err)
+		}
+		if resp.StatusCode == http.StatusOK {
+			held = append(held, resp)
+			continue
+		}
+		rejected = resp
+		break
+	}
+	if rejected == nil {
+		t.Skipf("Server accepted %d concurrent streams; run the mock with -max-concurrent to test overload handling", maxHeldStreams)
+	}
+	body, _ := io.ReadAll(rejected.Body)
+	rejected.Body.Close()
+	t.Logf("Request %d rejected with %s", len(held)+1, rejected.Status)
+
+	t.Run("Status", func(t *testing.T) {
+		if rejected.StatusCode != http.StatusTooManyRequests && rejected.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("Expected 429 or 503, got %d", rejected.StatusCode)
+		}
+	})
+	t.Run("RetryAfter", func(t *testing.T) {
+		value := rejected.Header.Get("Retry-After")
+		if _, err := strconv.Atoi(value); err != nil {
+			t.Errorf("Expected Retry-After in seconds, got %q", value)
+		}
+	})
+	t.Run("ErrorBody", func(t *testing.T) {
+		var errResp openai.ErrorResponse
+		if err := json.Unmarshal(body, &errResp); err != nil || errResp.Error == nil {
+			t.Fatalf("Expected an OpenAI error object, got %s", truncate(string(body), 200))
+		}
+		if errResp.Error.Message == "" || errResp.Error.Type == "" {
+			t.Errorf("Expected error message and type, got %+v", errResp.Error)
+		}
+	})
+	t.Run("Retry", func(t *testing.T) {
+		var attempts atomic.Int64
+		counting := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			attempts.Add(1)
+			return transport.RoundTrip(req)
+		})
+		retrying := &http.Client{Transport: retryTransport{next: withHostOverride(opts, counting), retries: 3, backoff: 200 * time.Millisecond}}
+
+		resp, err := postChat(ctx, retrying, false)
+		if err != nil {
+			t.Fatalf("Request with retries failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 after retries, got %d", resp.StatusCode)
+		}
+		if attempts.Load() < 2 {
+			t.Errorf("Expected at least one retry while the server was overloaded, got %d attempt(s)", attempts.Load())
+		}
+		t.Logf("Succeeded after %d attempts", attempts.Load())
+	})
+}
+
+// postChat sends a minimal chat completion request, streamed if stream is
+// set.
+func postChat(ctx context.Context, client *http.Client, stream bool) (*http.Response, error) {
+	payload, err := json.Marshal(openai.ChatCompletionRequest{
+		Model:    openai.GPT4o,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hello!"}},
+		Stream:   stream,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(opts.apiBaseURL(), "/")+"/chat/completions", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+opts.APIKey)
+	return client.Do(req)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
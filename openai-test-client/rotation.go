@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"testing"
+	"time"
+
+	"mtls"
+)
+
+// =============================================================================
+// Client Certificate Rotation
+// =============================================================================
+
+// The client certificate is presented through an mtls.Reloader, which
+// reloads the files when either changes, so that a long-running client
+// presents a rotated certificate on its next connection without a restart.
+
+// writeCertFiles writes cert and its private key as PEM files.
+func writeCertFiles(certFile, keyFile string, cert *tls.Certificate) error {
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return err
+	}
+	var certPEM []byte
+	for _, der := range cert.Certificate {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600)
+}
+
+// testClientCertRotation replaces the client certificate files while the
+// client is running and checks that new connections present the new
+// certificate: a rotated valid certificate is accepted, and rotating to an
+// expired one is rejected by the server, which proves that the handshake
+// used the file contents rather than a cached certificate.
+func testClientCertRotation(t *testing.T) {
+	ctx, _ := setup(t)
+	requireMTLS(t)
+
+	ca, err := mtls.LoadKeyPair(opts.CAFile, opts.CAKeyFile, opts.KeyPass)
+	if err != nil {
+		t.Skipf("CA key needed to issue certificates to rotate: %v", err)
+	}
+	issue := func(name string, notAfter time.Time) *tls.Certificate {
+		cert, err := issueClientCert(ca.Leaf, ca.PrivateKey, name, time.Now().Add(-2*time.Hour), notAfter)
+		if err != nil {
+			t.Fatalf("Failed to issue certificate: %v", err)
+		}
+		return cert
+	}
+	first := issue("rotation-first", time.Now().Add(time.Hour))
+	second := issue("rotation-second", time.Now().Add(time.Hour))
+	expired := issue("rotation-expired", time.Now().Add(-time.Hour))
+
+	o := opts
+	dir := t.TempDir()
+	o.CertFile, o.KeyFile = dir+"/client.crt", dir+"/client.key"
+	rotate := func(cert *tls.Certificate) {
+		if err := writeCertFiles(o.CertFile, o.KeyFile, cert); err != nil {
+			t.Fatalf("Failed to write certificate files: %v", err)
+		}
+	}
+	rotate(first)
+
+	transport, err := newTransport(o)
+	if err != nil {
+		t.Fatalf("Failed to configure transport: %v", err)
+	}
+	defer transport.CloseIdleConnections()
+	// Record the certificate presented in each handshake
+	var presented string
+	get := transport.TLSClientConfig.GetClientCertificate
+	transport.TLSClientConfig.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		cert, err := get(cri)
+		if err == nil && len(cert.Certificate) > 0 {
+			if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+				presented = leaf.Subject.CommonName
+			}
+		}
+		return cert, err
+	}
+	client := newClientWithTransport(o, transport)
+
+	steps := []struct {
+		name      string
+		rotate    *tls.Certificate
+		reconnect bool
+		want      string // "" if the request must be rejected
+	}{
+		{"Initial", nil, false, "rotation-first"},
+		{"OpenConnection", second, false, "rotation-first"},
+		{"NewConnection", nil, true, "rotation-second"},
+		{"Expired", expired, true, ""},
+		{"Restored", second, true, "rotation-second"},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if step.rotate != nil {
+				rotate(step.rotate)
+			}
+			if step.reconnect {
+				transport.CloseIdleConnections()
+			}
+			_, err := client.ListModels(ctx)
+			if step.want == "" {
+				if err == nil {
+					t.Fatalf("Request presenting %s succeeded, want it rejected", presented)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			if presented != step.want {
+				t.Errorf("Connection presented %s, want %s", presented, step.want)
+			}
+		})
+	}
+}
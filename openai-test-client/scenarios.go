@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// =============================================================================
+// YAML Scenarios
+// =============================================================================
+
+// ScenarioFile is the document read from -scenarios.
+type ScenarioFile struct {
+	Scenarios []Scenario `yaml:"scenarios"`
+}
+
+// Scenario is one request and the expectations on its response.
+type Scenario struct {
+	Name    string          `yaml:"name"`
+	Request ScenarioRequest `yaml:"request"`
+	Expect  ScenarioExpect  `yaml:"expect"`
+	Skip    string          `yaml:"skip"`
+}
+
+// ScenarioRequest describes the request. Endpoint is relative to the base
+// URL. Body is sent as JSON, or verbatim if it is a string.
+type ScenarioRequest struct {
+	Method   string            `yaml:"method"`
+	Endpoint string            `yaml:"endpoint"`
+	Headers  map[string]string `yaml:"headers"`
+	Body     any               `yaml:"body"`
+}
+
+// ScenarioExpect holds the expected status and assertions on the JSON body.
+// For an SSE response the document is the array of parsed chunks.
+type ScenarioExpect struct {
+	Status     int         `yaml:"status"`
+	Assertions []Assertion `yaml:"assertions"`
+}
+
+// Assertion checks the values selected by a JSONPath expression such as
+// $.choices[0].message.role or $.data[*].index. Every selected value must
+// pass every check that is set.
+type Assertion struct {
+	Path     string `yaml:"path"`
+	Equals   any    `yaml:"equals"`
+	Type     string `yaml:"type"`
+	Contains string `yaml:"contains"`
+	Matches  string `yaml:"matches"`
+	Length   *int   `yaml:"length"`
+	Exists   *bool  `yaml:"exists"`
+}
+
+func loadScenarios(path string) ([]Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file ScenarioFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, sc := range file.Scenarios {
+		if sc.Name == "" {
+			return nil, fmt.Errorf("%s: scenario %d has no name", path, i+1)
+		}
+		if sc.Request.Endpoint == "" {
+			return nil, fmt.Errorf("%s: scenario %q has no request.endpoint", path, sc.Name)
+		}
+		for _, a := range sc.Expect.Assertions {
+			if _, err := parsePath(a.Path); err != nil {
+				return nil, fmt.Errorf("%s: scenario %q: %w", path, sc.Name, err)
+			}
+			if a.Matches != "" {
+				if _, err := regexp.Compile(a.Matches); err != nil {
+					return nil, fmt.Errorf("%s: scenario %q: %w", path, sc.Name, err)
+				}
+			}
+		}
+	}
+	return file.Scenarios, nil
+}
+
+// testScenarios runs the scenarios in the -scenarios file, one subtest each.
+// It is skipped when no file is given.
+func testScenarios(t *testing.T) {
+	ctx, _ := setup(t)
+	if opts.ScenariosFile == "" {
+		t.Skip("No -scenarios file")
+	}
+
+	scenarios, err := loadScenarios(opts.ScenariosFile)
+	if err != nil {
+		t.Fatalf("Failed to load scenarios: %v", err)
+	}
+
+	transport, err := newTransport(opts)
+	if err != nil {
+		t.Fatalf("Failed to configure transport: %v", err)
+	}
+	defer transport.CloseIdleConnections()
+	api := apiEndpoint{
+		baseURL: opts.apiBaseURL(),
+		apiKey:  opts.APIKey,
+		client:  &http.Client{Transport: withHostOverride(opts, transport), Timeout: opts.Timeout},
+	}
+
+	for _, sc := range scenarios {
+		t.Run(sc.Name, func(t *testing.T) {
+			if sc.Skip != "" {
+				t.Skip(sc.Skip)
+			}
+			if ctx.Err() != nil {
+				t.Fatal(ctx.Err())
+			}
+			failures, err := runScenario(api, sc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, failure := range failures {
+				t.Error(failure)
+			}
+		})
+	}
+}
+
+// runScenario sends the scenario's request to api and returns the
+// expectations that were not met.
+func runScenario(api apiEndpoint, sc Scenario) ([]string, error) {
+	var body io.Reader
+	switch b := sc.Request.Body.(type) {
+	case nil:
+	case string:
+		body = strings.NewReader(b)
+	default:
+		payload, err := json.Marshal(b)
+		if err != nil {
+			return nil, fmt.Errorf("encode body: %w", err)
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	method := strings.ToUpper(sc.Request.Method)
+	if method == "" {
+		method = http.MethodGet
+		if body != nil {
+			method = http.MethodPost
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(api.baseURL, "/")+sc.Request.Endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+api.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range sc.Request.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var failures []string
+	if sc.Expect.Status != 0 && resp.StatusCode != sc.Expect.Status {
+		failures = append(failures, fmt.Sprintf("status: expected %d, got %d", sc.Expect.Status, resp.StatusCode))
+	}
+	if len(sc.Expect.Assertions) == 0 {
+		return failures, nil
+	}
+
+	doc, err := readDocument(resp)
+	if err != nil {
+		return append(failures, err.Error()), nil
+	}
+	for _, a := range sc.Expect.Assertions {
+		failures = append(failures, checkAssertion(doc, a)...)
+	}
+	return failures, nil
+}
+
+// readDocument decodes a JSON response, or an SSE response into an array of
+// its chunks.
+func readDocument(resp *http.Response) (any, error) {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		var doc any
+		if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+			return nil, fmt.Errorf("response is not JSON: %v", err)
+		}
+		return doc, nil
+	}
+
+	chunks := []any{}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk any
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("invalid chunk: %v", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, scanner.Err()
+}
+
+// checkAssertion returns a failure message for each value selected by a.Path
+// that fails a check, or one if nothing is selected.
+func checkAssertion(doc any, a Assertion) []string {
+	steps, err := parsePath(a.Path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	values := selectPath(doc, steps)
+
+	if a.Exists != nil {
+		if *a.Exists != (len(values) > 0) {
+			if *a.Exists {
+				return []string{fmt.Sprintf("%s: expected to exist", a.Path)}
+			}
+			return []string{fmt.Sprintf("%s: expected not to exist, got %s", a.Path, compactJSON(values[0]))}
+		}
+		if !*a.Exists {
+			return nil
+		}
+	}
+	if len(values) == 0 {
+		return []string{fmt.Sprintf("%s: no value at this path", a.Path)}
+	}
+
+	var want any
+	if a.Equals != nil {
+		// Normalise YAML integers and maps to their JSON form
+		data, err := json.Marshal(a.Equals)
+		if err != nil {
+			return []string{fmt.Sprintf("%s: invalid equals value: %v", a.Path, err)}
+		}
+		json.Unmarshal(data, &want)
+	}
+
+	var failures []string
+	for _, value := range values {
+		if a.Equals != nil && !reflect.DeepEqual(value, want) {
+			failures = append(failures, fmt.Sprintf("%s: expected %s, got %s", a.Path, compactJSON(want), compactJSON(value)))
+		}
+		if a.Type != "" && jsonType(value) != a.Type {
+			failures = append(failures, fmt.Sprintf("%s: expected type %s, got %s", a.Path, a.Type, jsonType(value)))
+		}
+		if a.Contains != "" {
+			if s, ok := value.(string); !ok || !strings.Contains(s, a.Contains) {
+				failures = append(failures, fmt.Sprintf("%s: expected a string containing %q, got %s", a.Path, a.Contains, compactJSON(value)))
+			}
+		}
+		if a.Matches != "" {
+			s, ok := value.(string)
+			if matched, _ := regexp.MatchString(a.Matches, s); !ok || !matched {
+				failures = append(failures, fmt.Sprintf("%s: expected a string matching %q, got %s", a.Path, a.Matches, compactJSON(value)))
+			}
+		}
+		if a.Length != nil {
+			n := -1
+			switch v := value.(type) {
+			case []any:
+				n = len(v)
+			case map[string]any:
+				n = len(v)
+			case string:
+				n = len([]rune(v))
+			}
+			if n != *a.Length {
+				failures = append(failures, fmt.Sprintf("%s: expected length %d, got %s", a.Path, *a.Length, truncate(compactJSON(value), 80)))
+			}
+		}
+	}
+	return failures
+}
+
+// pathStep is one step of a JSONPath expression: a key, an index, or every
+// element.
+type pathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+var pathToken = regexp.MustCompile(`^(?:\.([A-Za-z_][A-Za-z0-9_-]*)|\[(\d+|\*)\]|\['([^']*)'\])`)
+
+// parsePath parses the JSONPath subset used by scenarios: $ followed by
+// .key, ['key'], [n] and [*] steps.
+func parsePath(path string) ([]pathStep, error) {
+	rest, ok := strings.CutPrefix(path, "$")
+	if !ok {
+		return nil, fmt.Errorf("invalid path %q: must start with $", path)
+	}
+	var steps []pathStep
+	for rest != "" {
+		m := pathToken.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, fmt.Errorf("invalid path %q at %q", path, rest)
+		}
+		switch {
+		case m[1] != "":
+			steps = append(steps, pathStep{key: m[1]})
+		case m[2] == "*":
+			steps = append(steps, pathStep{wildcard: true})
+		case m[2] != "":
+			n, _ := strconv.Atoi(m[2])
+			steps = append(steps, pathStep{index: n, isIndex: true})
+		default:
+			steps = append(steps, pathStep{key: m[3]})
+		}
+		rest = rest[len(m[0]):]
+	}
+	return steps, nil
+}
+
+// selectPath returns the values in doc reached by steps.
+func selectPath(doc any, steps []pathStep) []any {
+	values := []any{doc}
+	for _, step := range steps {
+		var next []any
+		for _, value := range values {
+			switch v := value.(type) {
+			case map[string]any:
+				if step.wildcard {
+					for _, elem := range v {
+						next = append(next, elem)
+					}
+				} else if elem, ok := v[step.key]; ok && !step.isIndex {
+					next = append(next, elem)
+				}
+			case []any:
+				switch {
+				case step.wildcard:
+					next = append(next, v...)
+				case step.isIndex && step.index < len(v):
+					next = append(next, v[step.index])
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// jsonType names the JSON type of a decoded value.
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func compactJSON(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
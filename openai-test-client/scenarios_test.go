@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []pathStep
+		wantErr bool
+	}{
+		{"$", nil, false},
+		{"$.choices[0].message", []pathStep{{key: "choices"}, {index: 0, isIndex: true}, {key: "message"}}, false},
+		{"$.data[*]['x-y']", []pathStep{{key: "data"}, {wildcard: true}, {key: "x-y"}}, false},
+		{"choices", nil, true},
+		{"$.choices[", nil, true},
+		{"$..id", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := parsePath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePath error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePath = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckAssertion(t *testing.T) {
+	doc := map[string]any{
+		"object": "list",
+		"data": []any{
+			map[string]any{"id": "gpt-4o", "index": float64(0)},
+			map[string]any{"id": "gpt-4o-mini", "index": float64(1)},
+		},
+		"usage": map[string]any{"total_tokens": float64(12)},
+	}
+	yes, no := true, false
+	two, three := 2, 3
+
+	tests := []struct {
+		name      string
+		assertion Assertion
+		failures  int
+	}{
+		{"Equals", Assertion{Path: "$.object", Equals: "list"}, 0},
+		{"EqualsInt", Assertion{Path: "$.usage.total_tokens", Equals: 12}, 0},
+		{"EqualsObject", Assertion{Path: "$.usage", Equals: map[string]any{"total_tokens": 12}}, 0},
+		{"NotEqual", Assertion{Path: "$.object", Equals: "model"}, 1},
+		{"TypeEach", Assertion{Path: "$.data[*].id", Type: "string"}, 0},
+		{"TypeWrong", Assertion{Path: "$.data[*].index", Type: "string"}, 2},
+		{"Contains", Assertion{Path: "$.data[1].id", Contains: "mini"}, 0},
+		{"ContainsNotString", Assertion{Path: "$.usage", Contains: "x"}, 1},
+		{"Matches", Assertion{Path: "$.data[*].id", Matches: "^gpt-4o"}, 0},
+		{"Length", Assertion{Path: "$.data", Length: &two}, 0},
+		{"LengthWrong", Assertion{Path: "$.data", Length: &three}, 1},
+		{"Exists", Assertion{Path: "$.usage.total_tokens", Exists: &yes}, 0},
+		{"Missing", Assertion{Path: "$.usage.cost", Exists: &yes}, 1},
+		{"NotExists", Assertion{Path: "$.usage.cost", Exists: &no}, 0},
+		{"UnexpectedlyExists", Assertion{Path: "$.object", Exists: &no}, 1},
+		{"NoValue", Assertion{Path: "$.data[5].id", Type: "string"}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkAssertion(doc, tt.assertion); len(got) != tt.failures {
+				t.Errorf("checkAssertion = %q, want %d failures", got, tt.failures)
+			}
+		})
+	}
+}
+
+func TestLoadScenarios(t *testing.T) {
+	if _, err := loadScenarios(filepath.Join("scenarios", "example.yaml")); err != nil {
+		t.Errorf("example scenarios: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"NoName", "scenarios:\n  - request: {endpoint: /models}\n", "has no name"},
+		{"NoEndpoint", "scenarios:\n  - name: A\n", "has no request.endpoint"},
+		{"BadPath", "scenarios:\n  - name: A\n    request: {endpoint: /models}\n    expect:\n      assertions: [{path: data}]\n", "must start with $"},
+		{"UnknownField", "scenarios:\n  - name: A\n    request: {endpoint: /models, bogus: 1}\n", "bogus"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scenarios.yaml")
+			if err := os.WriteFile(path, []byte(tt.yaml), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadScenarios(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadScenarios error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunScenario(t *testing.T) {
+	var gotMethod, gotBody, gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotMethod, gotBody, gotAuth = r.Method, string(body), r.Header.Get("Authorization")
+
+		if r.URL.Path == "/v1/stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: {\"n\":1}\n\ndata: {\"n\":2}\n\ndata: [DONE]\n\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[]}`))
+	}))
+	defer server.Close()
+	api := apiEndpoint{baseURL: server.URL + "/v1", apiKey: "key", client: server.Client()}
+
+	failures, err := runScenario(api, Scenario{
+		Request: ScenarioRequest{Endpoint: "/models", Body: map[string]any{"model": "gpt-4o"}},
+		Expect: ScenarioExpect{Status: 201, Assertions: []Assertion{
+			{Path: "$.object", Equals: "list"},
+			{Path: "$.data", Type: "object"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotMethod != "POST" || gotBody != `{"model":"gpt-4o"}` || gotAuth != "Bearer key" {
+		t.Errorf("sent %s %q with %q, want a POST of the JSON body with the API key", gotMethod, gotBody, gotAuth)
+	}
+	want := []string{"status: expected 201, got 200", "$.data: expected type object, got array"}
+	if !reflect.DeepEqual(failures, want) {
+		t.Errorf("failures = %q, want %q", failures, want)
+	}
+
+	failures, err = runScenario(api, Scenario{
+		Request: ScenarioRequest{Endpoint: "/stream"},
+		Expect:  ScenarioExpect{Status: 200, Assertions: []Assertion{{Path: "$[*].n", Type: "number"}, {Path: "$[1].n", Equals: 2}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotMethod != "GET" || len(failures) != 0 {
+		t.Errorf("stream scenario sent %s, failures = %q", gotMethod, failures)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"mtls"
+)
+
+// =============================================================================
+// Soak Testing
+// =============================================================================
+
+// SoakOptions configures -soak mode. The traffic mix, worker count and rate
+// come from the load-test flags.
+type SoakOptions struct {
+	Enabled      bool
+	Duration     time.Duration
+	Interval     time.Duration
+	MaxErrorRate float64
+}
+
+func registerSoakFlags(fs *flag.FlagSet) *SoakOptions {
+	o := &SoakOptions{}
+	fs.BoolVar(&o.Enabled, "soak", false, "Run a soak test: a steady traffic mix (-mix, -concurrency, -rps) for -soak-duration, reported per -soak-interval")
+	fs.DurationVar(&o.Duration, "soak-duration", time.Hour, "Soak mode: how long to run")
+	fs.DurationVar(&o.Interval, "soak-interval", time.Minute, "Soak mode: length of each reporting window")
+	fs.Float64Var(&o.MaxErrorRate, "soak-max-error-rate", 0.01, "Soak mode: fail if the overall error rate is above this fraction")
+	return o
+}
+
+// SoakWindow is the activity in one reporting window of a soak test.
+type SoakWindow struct {
+	End        float64 `json:"end_seconds"`
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	ErrorRate  float64 `json:"error_rate"`
+	Throughput float64 `json:"throughput_rps"`
+	// Latency is time to the full response of the successful requests
+	Latency LatencyStats `json:"latency"`
+	// NewConnections and ReusedConnections count the connections requests
+	// were sent on; Handshakes counts full and resumed TLS handshakes
+	NewConnections    int `json:"new_connections"`
+	ReusedConnections int `json:"reused_connections"`
+	Handshakes        int `json:"handshakes"`
+	// TargetHeapBytes and TargetSysBytes are read from -metrics-url
+	// at the end of the window
+	TargetHeapBytes uint64         `json:"target_heap_bytes,omitempty"`
+	TargetSysBytes  uint64         `json:"target_sys_bytes,omitempty"`
+	ErrorKinds      map[string]int `json:"error_kinds,omitempty"`
+}
+
+// SoakReport is the result of a soak test.
+type SoakReport struct {
+	Target      string       `json:"target"`
+	Concurrency int          `json:"concurrency"`
+	TargetRPS   float64      `json:"target_rps,omitempty"`
+	Elapsed     float64      `json:"elapsed_seconds"`
+	Total       OpStats      `json:"total"`
+	Windows     []SoakWindow `json:"windows"`
+	// CertEvents records server certificate changes seen in handshakes and
+	// the client certificate expiring during the run
+	CertEvents   []string `json:"cert_events,omitempty"`
+	MaxErrorRate float64  `json:"max_error_rate"`
+	Passed       bool     `json:"passed"`
+}
+
+// soakWindow accumulates the current window's samples and connection
+// counts, which take resets, and the certificate events of the whole run.
+type soakWindow struct {
+	mu         sync.Mutex
+	samples    []sample
+	newConns   int
+	reused     int
+	handshakes int
+	serial     string
+	events     []string
+	elapsed    func() time.Duration
+}
+
+func (w *soakWindow) add(s sample) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.samples = append(w.samples, s)
+}
+
+// trace returns a ClientTrace that counts connections and handshakes, and
+// records a change of server certificate.
+func (w *soakWindow) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			if info.Reused {
+				w.reused++
+			} else {
+				w.newConns++
+			}
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				return
+			}
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.handshakes++
+			if len(state.PeerCertificates) == 0 {
+				return
+			}
+			leaf := state.PeerCertificates[0]
+			serial := leaf.SerialNumber.Text(16)
+			if w.serial != "" && serial != w.serial {
+				w.events = append(w.events, fmt.Sprintf("%s: server certificate changed from serial %s to %s (expires %s)",
+					w.elapsed().Round(time.Second), w.serial, serial, leaf.NotAfter.Format(time.RFC3339)))
+			}
+			w.serial = serial
+		},
+	}
+}
+
+// take returns the window's samples and connection counts, and starts a
+// new window.
+func (w *soakWindow) take() (samples []sample, window SoakWindow) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	samples = w.samples
+	window = SoakWindow{NewConnections: w.newConns, ReusedConnections: w.reused, Handshakes: w.handshakes}
+	w.samples, w.newConns, w.reused, w.handshakes = nil, 0, 0, 0
+	return samples, window
+}
+
+// runSoak runs the traffic mix for so.Duration, summarising each window as
+// it ends, so that slow degradation (rising error rate or latency, growing
+// target memory, connections no longer being reused) shows up over time.
+func runSoak(log io.Writer, o Options, lo *LoadOptions, so *SoakOptions) (*SoakReport, error) {
+	ops, err := parseMix(lo.Mix)
+	if err != nil {
+		return nil, err
+	}
+	if lo.Concurrency < 1 {
+		return nil, fmt.Errorf("-concurrency must be at least 1")
+	}
+	if so.Interval <= 0 || so.Duration <= 0 {
+		return nil, fmt.Errorf("-soak-duration and -soak-interval must be positive")
+	}
+
+	transport, err := newTransport(o)
+	if err != nil {
+		return nil, err
+	}
+	transport.MaxIdleConnsPerHost = lo.Concurrency
+	client := newClientWithTransport(o, transport)
+	metricsClient := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+
+	var clientExpiry time.Time
+	if !o.Insecure {
+		if cert, err := mtls.LoadKeyPair(o.CertFile, o.KeyFile, o.KeyPass); err == nil {
+			if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+				clientExpiry = leaf.NotAfter
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), so.Duration)
+	defer cancel()
+
+	var tokens <-chan time.Time
+	if lo.RPS > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / lo.RPS))
+		defer ticker.Stop()
+		tokens = ticker.C
+	}
+
+	fmt.Fprintf(log, "Soak test: %d workers for %v", lo.Concurrency, so.Duration)
+	if lo.RPS > 0 {
+		fmt.Fprintf(log, " at %.1f req/s", lo.RPS)
+	}
+	fmt.Fprintf(log, ", mix %s, reporting every %v\n", lo.Mix, so.Interval)
+	if !clientExpiry.IsZero() && clientExpiry.Before(time.Now().Add(so.Duration)) {
+		fmt.Fprintf(log, "%sClient certificate expires at %s, during the run%s\n", colorYellow, clientExpiry.Format(time.RFC3339), colorReset)
+	}
+
+	start := time.Now()
+	window := &soakWindow{elapsed: func() time.Duration { return time.Since(start) }}
+	trace := window.trace()
+
+	var wg sync.WaitGroup
+	for i := 0; i < lo.Concurrency; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for {
+				if tokens != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-tokens:
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+
+				op := pickOp(ops, rng)
+				begin := time.Now()
+				err := op.run(httptrace.WithClientTrace(ctx, trace), client)
+				if ctx.Err() != nil {
+					return
+				}
+				window.add(sample{op: op.name, latency: time.Since(begin), err: err})
+			}
+		}(time.Now().UnixNano() + int64(i))
+	}
+
+	report := &SoakReport{
+		Target:       o.apiBaseURL(),
+		Concurrency:  lo.Concurrency,
+		TargetRPS:    lo.RPS,
+		MaxErrorRate: so.MaxErrorRate,
+	}
+	var all []sample
+	expired := false
+
+	// closeWindow summarises the window ending now, of length d
+	closeWindow := func(d time.Duration) {
+		samples, w := window.take()
+		all = append(all, samples...)
+		stats := summarise(samples, d)
+		w.End = time.Since(start).Seconds()
+		w.Requests, w.Errors, w.ErrorRate, w.Throughput = stats.Requests, stats.Errors, stats.ErrorRate, stats.Throughput
+		w.Latency, w.ErrorKinds = stats.Latency, stats.ErrorKinds
+		// Timings are cleared each window so a long run does not keep
+		// every sample
+		timings.take()
+		if o.MetricsURL != "" {
+			heap, sys, err := readTargetMemory(metricsClient, o.MetricsURL)
+			if err != nil {
+				fmt.Fprintf(log, "  %sFailed to read target metrics: %v%s\n", colorYellow, err, colorReset)
+			}
+			w.TargetHeapBytes, w.TargetSysBytes = heap, sys
+		}
+		if !expired && !clientExpiry.IsZero() && time.Now().After(clientExpiry) {
+			expired = true
+			window.mu.Lock()
+			window.events = append(window.events, fmt.Sprintf("%s: client certificate expired", time.Since(start).Round(time.Second)))
+			window.mu.Unlock()
+		}
+		report.Windows = append(report.Windows, w)
+		printSoakWindow(log, w)
+	}
+
+	ticker := time.NewTicker(so.Interval)
+	defer ticker.Stop()
+	last := start
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case now := <-ticker.C:
+			closeWindow(now.Sub(last))
+			last = now
+		}
+	}
+	wg.Wait()
+	if time.Since(last) >= time.Second {
+		closeWindow(time.Since(last))
+	}
+
+	elapsed := time.Since(start)
+	report.Elapsed = elapsed.Seconds()
+	report.Total = summarise(all, elapsed)
+	report.CertEvents = window.events
+	report.Passed = report.Total.Requests > 0 && report.Total.ErrorRate <= so.MaxErrorRate
+	return report, nil
+}
+
+func printSoakWindow(w io.Writer, win SoakWindow) {
+	color := colorGreen
+	if win.Errors > 0 {
+		color = colorRed
+	}
+	line := fmt.Sprintf("  %7s: %6d req %s%5d err (%5.2f%%)%s  p99 %7.1f ms  conns %d new / %d reused, %d handshakes",
+		seconds(win.End).String(), win.Requests, color, win.Errors, win.ErrorRate*100, colorReset,
+		win.Latency.P99, win.NewConnections, win.ReusedConnections, win.Handshakes)
+	if win.TargetHeapBytes > 0 {
+		line += fmt.Sprintf("  heap %s", mebibytes(win.TargetHeapBytes))
+	}
+	fmt.Fprintln(w, line)
+}
+
+func printSoakReport(w io.Writer, report *SoakReport) {
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, strings.Repeat("=", 60))
+	fmt.Fprintf(w, "%s%s                    SOAK TEST REPORT%s\n", colorBold, colorCyan, colorReset)
+	fmt.Fprintln(w, strings.Repeat("=", 60))
+
+	fmt.Fprintf(w, "\nDuration:    %s with %d workers\n", seconds(report.Elapsed).String(), report.Concurrency)
+	fmt.Fprintf(w, "Requests:    %d (%.1f req/s)\n", report.Total.Requests, report.Total.Throughput)
+	printRateShortfall(w, report.TargetRPS, report.Total.Throughput)
+	fmt.Fprintf(w, "Errors:      %d (%.2f%%, limit %.2f%%)\n", report.Total.Errors, report.Total.ErrorRate*100, report.MaxErrorRate*100)
+
+	var conns, reused, handshakes int
+	for _, win := range report.Windows {
+		conns += win.NewConnections
+		reused += win.ReusedConnections
+		handshakes += win.Handshakes
+	}
+	if conns+reused > 0 {
+		fmt.Fprintf(w, "Connections: %d new, %d reused (%.1f%%), %d TLS handshakes\n", conns, reused, 100*float64(reused)/float64(conns+reused), handshakes)
+	}
+
+	if n := len(report.Windows); n > 0 && report.Windows[0].TargetHeapBytes > 0 {
+		first, lastWin := report.Windows[0], report.Windows[n-1]
+		fmt.Fprintf(w, "Target heap: %s in the first window, %s in the last (sys %s to %s)\n",
+			mebibytes(first.TargetHeapBytes), mebibytes(lastWin.TargetHeapBytes), mebibytes(first.TargetSysBytes), mebibytes(lastWin.TargetSysBytes))
+	}
+
+	if len(report.CertEvents) > 0 {
+		fmt.Fprintf(w, "\n%sCertificate events:%s\n", colorYellow, colorReset)
+		for _, event := range report.CertEvents {
+			fmt.Fprintf(w, "  - %s\n", event)
+		}
+	}
+
+	fmt.Fprintln(w)
+	if report.Passed {
+		fmt.Fprintf(w, "%s%sSoak test passed.%s\n", colorBold, colorGreen, colorReset)
+	} else if report.Total.Requests == 0 {
+		fmt.Fprintf(w, "%s%sSoak test failed: no requests completed.%s\n", colorBold, colorRed, colorReset)
+	} else {
+		fmt.Fprintf(w, "%s%sSoak test failed: error rate above the limit.%s\n", colorBold, colorRed, colorReset)
+	}
+	fmt.Fprintln(w, strings.Repeat("=", 60))
+}
+
+// seconds converts a report's elapsed seconds to a whole-second duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Second)
+}
+
+func mebibytes(n uint64) string {
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
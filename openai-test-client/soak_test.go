@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSoakWindowTrace(t *testing.T) {
+	window := &soakWindow{elapsed: func() time.Duration { return 90 * time.Second }}
+	trace := window.trace()
+	handshake := func(serial int64) tls.ConnectionState {
+		return tls.ConnectionState{PeerCertificates: []*x509.Certificate{{
+			SerialNumber: big.NewInt(serial),
+			NotAfter:     time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		}}}
+	}
+
+	trace.TLSHandshakeDone(handshake(10), nil)
+	trace.GotConn(httptrace.GotConnInfo{Reused: false})
+	trace.GotConn(httptrace.GotConnInfo{Reused: true})
+	trace.GotConn(httptrace.GotConnInfo{Reused: true})
+	window.add(sample{op: "chat"})
+
+	samples, w := window.take()
+	if len(samples) != 1 || w.NewConnections != 1 || w.ReusedConnections != 2 || w.Handshakes != 1 {
+		t.Errorf("first window = %d samples, %+v", len(samples), w)
+	}
+	if samples, w := window.take(); len(samples) != 0 || w.NewConnections != 0 || w.ReusedConnections != 0 || w.Handshakes != 0 {
+		t.Errorf("take did not reset the window: %d samples, %+v", len(samples), w)
+	}
+
+	// A failed handshake is not counted; a new serial is an event
+	trace.TLSHandshakeDone(tls.ConnectionState{}, tls.AlertError(42))
+	trace.TLSHandshakeDone(handshake(10), nil)
+	trace.TLSHandshakeDone(handshake(11), nil)
+	if _, w := window.take(); w.Handshakes != 2 {
+		t.Errorf("handshakes = %d, want 2", w.Handshakes)
+	}
+	if len(window.events) != 1 || !strings.Contains(window.events[0], "1m30s: server certificate changed from serial a to b") {
+		t.Errorf("events = %q, want one certificate change", window.events)
+	}
+}
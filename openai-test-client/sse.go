@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// =============================================================================
+// SSE Wire Format
+// =============================================================================
+
+// sseEvent is one event read off the wire.
+type sseEvent struct {
+	data    string
+	arrived time.Duration
+}
+
+// testSSEWireFormat reads a streamed chat completion directly, without the
+// SDK, and checks the framing a proxy could break without the SDK noticing:
+// every event is a single "data: " line followed by a blank line, payloads
+// are chat.completion.chunk objects, the stream ends with [DONE], and events
+// arrive as they are sent rather than all at once.
+func testSSEWireFormat(t *testing.T) {
+	ctx, _ := setup(t)
+
+	transport, err := newTransport(opts)
+	if err != nil {
+		t.Fatalf("Failed to configure transport: %v", err)
+	}
+	defer transport.CloseIdleConnections()
+
+	start := time.Now()
+	resp, err := postChat(ctx, &http.Client{Transport: withHostOverride(opts, transport)}, true)
+	if err != nil {
+		t.Fatalf("Error creating stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %s", resp.Status)
+	}
+
+	events, framingErrs := readSSE(resp.Body, start)
+	total := time.Since(start)
+	t.Logf("Read %d events in %v", len(events), total.Round(time.Millisecond))
+
+	t.Run("ContentType", func(t *testing.T) {
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+			t.Errorf("Expected Content-Type text/event-stream, got %q", ct)
+		}
+	})
+	t.Run("Framing", func(t *testing.T) {
+		for _, err := range framingErrs {
+			t.Error(err)
+		}
+	})
+	t.Run("Payloads", func(t *testing.T) {
+		for i, event := range events {
+			if event.data == "[DONE]" {
+				continue
+			}
+			var chunk struct {
+				ID     string `json:"id"`
+				Object string `json:"object"`
+			}
+			if err := json.Unmarshal([]byte(event.data), &chunk); err != nil {
+				t.Errorf("Event %d is not JSON: %v (%s)", i, err, truncate(event.data, 100))
+				continue
+			}
+			if chunk.Object != "chat.completion.chunk" || chunk.ID == "" {
+				t.Errorf("Event %d: expected a chat.completion.chunk with an ID, got %s", i, truncate(event.data, 100))
+			}
+		}
+	})
+	t.Run("Done", func(t *testing.T) {
+		if len(events) == 0 {
+			t.Fatal("No events received")
+		}
+		for i, event := range events[:len(events)-1] {
+			if event.data == "[DONE]" {
+				t.Errorf("[DONE] at event %d of %d; events followed it", i, len(events))
+			}
+		}
+		if last := events[len(events)-1].data; last != "[DONE]" {
+			t.Errorf("Expected the stream to end with [DONE], got %s", truncate(last, 100))
+		}
+	})
+	t.Run("Incremental", func(t *testing.T) {
+		if len(events) < 3 {
+			t.Skip("Too few events to judge delivery")
+		}
+		// Buffering delivers everything at the end; the mock spaces
+		// events ~50ms apart, so the first should arrive well before the
+		// last
+		first, last := events[0].arrived, events[len(events)-1].arrived
+		if first > total/2 || last-first < total/4 {
+			t.Errorf("Events arrived between %v and %v of %v; the stream appears to be buffered", first, last, total)
+		}
+	})
+}
+
+// readSSE reads events from body, noting when each arrived relative to start,
+// and returns them with any framing problems.
+func readSSE(body io.Reader, start time.Time) ([]sseEvent, []error) {
+	var (
+		events  []sseEvent
+		errs    []error
+		pending *sseEvent
+	)
+	reader := bufio.NewReader(body)
+	for line := 1; ; line++ {
+		text, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			if text != "" {
+				errs = append(errs, fmt.Errorf("line %d: unterminated final line %q", line, truncate(text, 60)))
+			}
+			if pending != nil {
+				errs = append(errs, fmt.Errorf("line %d: stream ended without a blank line after the last event", line))
+				events = append(events, *pending)
+			}
+			return events, errs
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %v", line, err))
+			return events, errs
+		}
+
+		text = strings.TrimSuffix(text, "\n")
+		if strings.HasSuffix(text, "\r") {
+			errs = append(errs, fmt.Errorf("line %d: CRLF line ending", line))
+			text = strings.TrimSuffix(text, "\r")
+		}
+
+		switch {
+		case text == "":
+			if pending == nil {
+				errs = append(errs, fmt.Errorf("line %d: blank line without an event", line))
+				continue
+			}
+			events = append(events, *pending)
+			pending = nil
+		case strings.HasPrefix(text, "data: "):
+			if pending != nil {
+				errs = append(errs, fmt.Errorf("line %d: second data line in one event; events must be separated by a blank line", line))
+				events = append(events, *pending)
+			}
+			pending = &sseEvent{data: strings.TrimPrefix(text, "data: "), arrived: time.Since(start)}
+		default:
+			errs = append(errs, fmt.Errorf("line %d: expected \"data: \" or a blank line, got %q", line, truncate(text, 60)))
+		}
+	}
+}
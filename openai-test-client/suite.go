@@ -0,0 +1,650 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// tests lists the suite in the order the CLI runs it. main_test.go exposes
+// each entry as a TestXxx function for `go test`.
+var tests = []testing.InternalTest{
+	{Name: "TestListModels", F: testListModels},
+	{Name: "TestGetModel", F: testGetModel},
+	{Name: "TestGetModelNotFound", F: testGetModelNotFound},
+	{Name: "TestChatCompletion", F: testChatCompletion},
+	{Name: "TestChatCompletionWithParams", F: testChatCompletionWithParams},
+	{Name: "TestChatCompletionStreaming", F: testChatCompletionStreaming},
+	{Name: "TestStreamCancellation", F: testStreamCancellation},
+	{Name: "TestSSEWireFormat", F: testSSEWireFormat},
+	{Name: "TestChatCompletionWithTools", F: testChatCompletionWithTools},
+	{Name: "TestChatCompletionStreamingTools", F: testChatCompletionStreamingTools},
+	{Name: "TestChatCompletionMultiPartContent", F: testChatCompletionMultiPartContent},
+	{Name: "TestEmbeddings", F: testEmbeddings},
+	{Name: "TestEmbeddingsMultipleInputs", F: testEmbeddingsMultipleInputs},
+	{Name: "TestCapabilities", F: testCapabilities},
+	{Name: "TestImages", F: testImages},
+	{Name: "TestAudioSpeech", F: testAudioSpeech},
+	{Name: "TestAudioTranscription", F: testAudioTranscription},
+	{Name: "TestModerations", F: testModerations},
+	{Name: "TestFiles", F: testFiles},
+	{Name: "TestBatches", F: testBatches},
+	{Name: "TestErrorHandling", F: testErrorHandling},
+	{Name: "TestMalformedRequests", F: testMalformedRequests},
+	{Name: "TestGoldenShapes", F: testGoldenShapes},
+	{Name: "TestScenarios", F: testScenarios},
+	{Name: "TestConnectionReuse", F: testConnectionReuse},
+	{Name: "TestDualStack", F: testDualStack},
+	{Name: "TestMTLSNoClientCert", F: testMTLSNoClientCert},
+	{Name: "TestMTLSWrongCA", F: testMTLSWrongCA},
+	{Name: "TestMTLSExpiredCert", F: testMTLSExpiredCert},
+	{Name: "TestMTLSRevokedCert", F: testMTLSRevokedCert},
+	{Name: "TestClientCertRotation", F: testClientCertRotation},
+	{Name: "TestIntermediateCAChain", F: testIntermediateCAChain},
+	{Name: "TestTLSMatrix", F: testTLSMatrix},
+	{Name: "TestProxyChain", F: testProxyChain},
+	{Name: "TestOverload", F: testOverload},
+}
+
+// =============================================================================
+// Harness
+// =============================================================================
+
+// skipUnreachable makes tests skip, rather than fail, when nothing is
+// listening at the target. It is set under `go test` so that `go test ./...`
+// passes without a running server; the CLI always fails instead.
+var skipUnreachable bool
+
+var (
+	reachOnce sync.Once
+	reachErr  error
+)
+
+// suiteCtx is cancelled when the -deadline for the run passes.
+var (
+	suiteCtx    = context.Background()
+	suiteCancel context.CancelFunc
+)
+
+// startDeadline starts the -deadline clock for the run, if one is set.
+func startDeadline(o Options) {
+	if o.Deadline > 0 {
+		suiteCtx, suiteCancel = context.WithTimeout(context.Background(), o.Deadline)
+	}
+}
+
+// setup marks t as parallel and skips it if the target is unreachable and
+// skipUnreachable is set. Every test calls it, or setupSerial, first.
+//
+// Each test gets its own client with its own connection pool, so tests
+// running in parallel open separate connections to the server and a test
+// that breaks a connection or leaves a stream open cannot affect another.
+func setup(t *testing.T) (context.Context, *openai.Client) {
+	t.Helper()
+	t.Parallel()
+	return setupSerial(t)
+}
+
+// setupSerial is setup for tests that must not run alongside the others.
+func setupSerial(t *testing.T) (context.Context, *openai.Client) {
+	t.Helper()
+
+	if skipUnreachable {
+		reachOnce.Do(func() { reachErr = probe(opts) })
+		if reachErr != nil {
+			t.Skipf("server not reachable: %v", reachErr)
+		}
+	}
+	transport, err := newTransport(opts)
+	if err != nil {
+		t.Fatalf("Failed to configure client: %v", err)
+	}
+	t.Cleanup(transport.CloseIdleConnections)
+	rt := clientRoundTripper(opts, transport)
+	if payloads.enabled() {
+		rt = captureTransport{next: rt, test: t.Name()}
+	}
+	return suiteCtx, newClientWithRoundTripper(opts, rt)
+}
+
+// probe dials the proxy, if any, or the API host.
+func probe(o Options) error {
+	target := o.apiBaseURL()
+	if proxy := o.proxyURL(); proxy != "" {
+		target = proxy
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	conn, err := net.DialTimeout("tcp", host, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// =============================================================================
+// Model Tests
+// =============================================================================
+
+func testListModels(t *testing.T) {
+	ctx, client := setup(t)
+
+	models, err := client.ListModels(ctx)
+	if err != nil {
+		t.Fatalf("ListModels: %v", err)
+	}
+	if len(models.Models) == 0 {
+		t.Fatal("No models returned")
+	}
+	t.Logf("Retrieved %d models", len(models.Models))
+
+	t.Run("Expected", func(t *testing.T) {
+		foundModels := make(map[string]bool)
+		for _, m := range models.Models {
+			foundModels[m.ID] = true
+		}
+		for _, expected := range []string{"gpt-4", "gpt-4o", "gpt-3.5-turbo", "text-embedding-ada-002"} {
+			if !foundModels[expected] {
+				t.Errorf("Expected model %s missing", expected)
+			}
+		}
+	})
+}
+
+func testGetModel(t *testing.T) {
+	ctx, client := setup(t)
+
+	model, err := client.GetModel(ctx, "gpt-4o")
+	if err != nil {
+		t.Fatalf("GetModel: %v", err)
+	}
+	if model.ID != "gpt-4o" {
+		t.Fatalf("Wrong model ID: %s", model.ID)
+	}
+	t.Logf("Retrieved model: %s (owned by: %s)", model.ID, model.OwnedBy)
+}
+
+func testGetModelNotFound(t *testing.T) {
+	ctx, client := setup(t)
+
+	if _, err := client.GetModel(ctx, "nonexistent-model"); err == nil {
+		t.Fatal("Should have returned error for nonexistent model")
+	}
+}
+
+// =============================================================================
+// Chat Completion Tests
+// =============================================================================
+
+func testChatCompletion(t *testing.T) {
+	ctx, client := setup(t)
+
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: openai.GPT4o,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: "Hello, how are you?"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateChatCompletion: %v", err)
+	}
+	if len(resp.Choices) == 0 {
+		t.Fatal("No choices returned")
+	}
+
+	choice := resp.Choices[0]
+	t.Logf("Response: %q", truncate(choice.Message.Content, 60))
+
+	t.Run("ID", func(t *testing.T) {
+		if resp.ID == "" {
+			t.Error("Missing response ID")
+		}
+	})
+	t.Run("Model", func(t *testing.T) {
+		if resp.Model == "" {
+			t.Error("Missing model in response")
+		}
+	})
+	t.Run("Usage", func(t *testing.T) {
+		if resp.Usage.TotalTokens <= 0 {
+			t.Errorf("Invalid token usage: %+v", resp.Usage)
+		}
+		t.Logf("Tokens - Prompt: %d, Completion: %d, Total: %d",
+			resp.Usage.PromptTokens, resp.Usage.CompletionTokens, resp.Usage.TotalTokens)
+	})
+	t.Run("FinishReason", func(t *testing.T) {
+		if choice.FinishReason == "" {
+			t.Error("Missing finish reason")
+		}
+	})
+}
+
+func testChatCompletionWithParams(t *testing.T) {
+	ctx, client := setup(t)
+
+	n := 2
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: openai.GPT4o,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: "You are a helpful assistant."},
+			{Role: openai.ChatMessageRoleUser, Content: "Tell me a joke."},
+		},
+		MaxTokens:   100,
+		Temperature: 0.7,
+		N:           n,
+	})
+	if err != nil {
+		t.Fatalf("CreateChatCompletion: %v", err)
+	}
+
+	if len(resp.Choices) < n {
+		t.Errorf("Expected %d choices, got %d", n, len(resp.Choices))
+	}
+}
+
+func testChatCompletionStreaming(t *testing.T) {
+	ctx, client := setup(t)
+
+	timer := newStreamTimer()
+	stream, err := client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+		Model: openai.GPT4o,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: "Hello!"},
+		},
+		Stream: true,
+	})
+	if err != nil {
+		t.Fatalf("Error creating stream: %v", err)
+	}
+	defer stream.Close()
+
+	var fullContent strings.Builder
+	chunkCount := 0
+	var lastFinishReason string
+
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error receiving chunk: %v", err)
+		}
+
+		timer.chunk()
+		chunkCount++
+		if len(chunk.Choices) > 0 {
+			fullContent.WriteString(chunk.Choices[0].Delta.Content)
+			if chunk.Choices[0].FinishReason != "" {
+				lastFinishReason = string(chunk.Choices[0].FinishReason)
+			}
+		}
+	}
+	t.Logf("Received %d chunks in %v (first after %v)", chunkCount, time.Since(timer.start).Round(time.Millisecond), timer.firstChunk.Round(time.Millisecond))
+
+	t.Run("Chunks", func(t *testing.T) {
+		if chunkCount == 0 {
+			t.Error("No chunks received")
+		}
+	})
+	t.Run("Content", func(t *testing.T) {
+		if fullContent.Len() == 0 {
+			t.Error("Empty content from stream")
+		}
+	})
+	t.Run("Finish", func(t *testing.T) {
+		if lastFinishReason != "stop" {
+			t.Errorf("Expected finish_reason 'stop', got '%s'", lastFinishReason)
+		}
+	})
+}
+
+// weatherTool is the function tool offered in the tool-calling tests.
+var weatherTool = openai.Tool{
+	Type: openai.ToolTypeFunction,
+	Function: &openai.FunctionDefinition{
+		Name:        "get_weather",
+		Description: "Get weather information for a location",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"location": map[string]interface{}{
+					"type":        "string",
+					"description": "City name",
+				},
+			},
+			"required": []string{"location"},
+		},
+	},
+}
+
+func testChatCompletionWithTools(t *testing.T) {
+	ctx, client := setup(t)
+
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: openai.GPT4o,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: "What's the weather in Paris?"},
+		},
+		Tools:      []openai.Tool{weatherTool},
+		ToolChoice: "required",
+	})
+	if err != nil {
+		t.Fatalf("CreateChatCompletion: %v", err)
+	}
+	if len(resp.Choices) == 0 {
+		t.Fatal("No choices returned")
+	}
+
+	choice := resp.Choices[0]
+
+	t.Run("Call", func(t *testing.T) {
+		if len(choice.Message.ToolCalls) == 0 {
+			t.Fatal("No tool calls returned")
+		}
+		toolCall := choice.Message.ToolCalls[0]
+		if toolCall.Function.Name != "get_weather" {
+			t.Errorf("Tool call name: got %q, want get_weather", toolCall.Function.Name)
+		}
+		t.Logf("Tool call: %s (ID: %s) Arguments: %s", toolCall.Function.Name, toolCall.ID, toolCall.Function.Arguments)
+	})
+	t.Run("FinishReason", func(t *testing.T) {
+		if choice.FinishReason != openai.FinishReasonToolCalls {
+			t.Errorf("Expected finish_reason 'tool_calls', got '%s'", choice.FinishReason)
+		}
+	})
+}
+
+func testChatCompletionStreamingTools(t *testing.T) {
+	ctx, client := setup(t)
+
+	stream, err := client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+		Model: openai.GPT4o,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: "What's the weather in Paris?"},
+		},
+		Tools:      []openai.Tool{weatherTool},
+		ToolChoice: "required",
+		Stream:     true,
+	})
+	if err != nil {
+		t.Fatalf("Error creating stream: %v", err)
+	}
+	defer stream.Close()
+
+	// Assemble tool calls from their deltas the way a client must: the
+	// first delta for an index carries the ID, type and name, and the
+	// arguments are concatenated across deltas.
+	var calls []openai.ToolCall
+	var problems []string
+	var lastFinishReason openai.FinishReason
+	deltas := 0
+
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error receiving chunk: %v", err)
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		choice := chunk.Choices[0]
+		if choice.FinishReason != "" {
+			lastFinishReason = choice.FinishReason
+		}
+
+		for _, delta := range choice.Delta.ToolCalls {
+			deltas++
+			if delta.Index == nil {
+				problems = append(problems, "tool call delta without an index")
+				continue
+			}
+			index := *delta.Index
+			switch {
+			case index == len(calls):
+				if delta.ID == "" || delta.Function.Name == "" {
+					problems = append(problems, fmt.Sprintf("first delta for index %d has no ID or name", index))
+				}
+				calls = append(calls, delta)
+			case index < len(calls):
+				if delta.ID != "" && delta.ID != calls[index].ID {
+					problems = append(problems, fmt.Sprintf("index %d changed ID from %s to %s", index, calls[index].ID, delta.ID))
+				}
+				calls[index].Function.Arguments += delta.Function.Arguments
+			default:
+				problems = append(problems, fmt.Sprintf("index %d skips index %d", index, len(calls)))
+			}
+		}
+	}
+	t.Logf("Assembled %d tool call(s) from %d deltas", len(calls), deltas)
+
+	t.Run("Deltas", func(t *testing.T) {
+		for _, problem := range problems {
+			t.Error(problem)
+		}
+		if deltas < 2 {
+			t.Errorf("Expected the arguments to be split across deltas, got %d delta(s)", deltas)
+		}
+	})
+	t.Run("Call", func(t *testing.T) {
+		if len(calls) != 1 {
+			t.Fatalf("Expected 1 tool call, got %d", len(calls))
+		}
+		call := calls[0]
+		if !strings.HasPrefix(call.ID, "call_") {
+			t.Errorf("Tool call ID: got %q, want call_ prefix", call.ID)
+		}
+		if call.Type != openai.ToolTypeFunction {
+			t.Errorf("Tool call type: got %q, want function", call.Type)
+		}
+		if call.Function.Name != "get_weather" {
+			t.Errorf("Tool call name: got %q, want get_weather", call.Function.Name)
+		}
+	})
+	t.Run("Arguments", func(t *testing.T) {
+		if len(calls) == 0 {
+			t.Skip("No tool call assembled")
+		}
+		var args map[string]any
+		if err := json.Unmarshal([]byte(calls[0].Function.Arguments), &args); err != nil {
+			t.Fatalf("Assembled arguments are not valid JSON: %v (%s)", err, calls[0].Function.Arguments)
+		}
+		if _, ok := args["location"].(string); !ok {
+			t.Errorf("Expected a string location argument, got %s", calls[0].Function.Arguments)
+		}
+		t.Logf("Arguments: %s", calls[0].Function.Arguments)
+	})
+	t.Run("FinishReason", func(t *testing.T) {
+		if lastFinishReason != openai.FinishReasonToolCalls {
+			t.Errorf("Expected finish_reason 'tool_calls', got '%s'", lastFinishReason)
+		}
+	})
+}
+
+func testChatCompletionMultiPartContent(t *testing.T) {
+	// NOTE: This test is REQUIRED for OpenCode Plan mode.
+	// OpenCode's plan agent sends messages with multi-part content (array of ContentParts)
+	// instead of simple string content. Without this support, plan mode fails with:
+	// "json: cannot unmarshal array into Go struct field ChatMessage.messages.content of type string"
+	ctx, client := setup(t)
+
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: openai.GPT4o,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role: openai.ChatMessageRoleUser,
+				MultiContent: []openai.ChatMessagePart{
+					{
+						Type: openai.ChatMessagePartTypeText,
+						Text: "This is the first part of a multi-part message.",
+					},
+					{
+						Type: openai.ChatMessagePartTypeText,
+						Text: "This is the second part of the message.",
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateChatCompletion: %v", err)
+	}
+	if len(resp.Choices) == 0 {
+		t.Fatal("No choices returned")
+	}
+
+	choice := resp.Choices[0]
+	t.Logf("Response: %q", truncate(choice.Message.Content, 60))
+
+	// The two parts combined are ~90 chars, so ~22 tokens
+	t.Run("Tokens", func(t *testing.T) {
+		if resp.Usage.PromptTokens <= 0 {
+			t.Error("No prompt tokens counted")
+		}
+	})
+	t.Run("Finish", func(t *testing.T) {
+		if choice.FinishReason == "" {
+			t.Error("Missing finish reason")
+		}
+	})
+}
+
+// =============================================================================
+// Embeddings Tests
+// =============================================================================
+
+func testEmbeddings(t *testing.T) {
+	ctx, client := setup(t)
+
+	resp, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Model: openai.AdaEmbeddingV2,
+		Input: []string{"Hello, world!"},
+	})
+	if err != nil {
+		t.Fatalf("CreateEmbeddings: %v", err)
+	}
+	if len(resp.Data) == 0 {
+		t.Fatal("No embeddings returned")
+	}
+
+	embedding := resp.Data[0]
+
+	t.Run("Index", func(t *testing.T) {
+		if embedding.Index != 0 {
+			t.Errorf("Wrong index: %d", embedding.Index)
+		}
+	})
+	t.Run("Model", func(t *testing.T) {
+		if resp.Model == "" {
+			t.Error("Missing model in response")
+		}
+	})
+	t.Run("Usage", func(t *testing.T) {
+		if resp.Usage.TotalTokens <= 0 {
+			t.Errorf("Invalid token usage: %+v", resp.Usage)
+		}
+	})
+	t.Run("Dimensions", func(t *testing.T) {
+		// ada-002 embeddings have 1536 dimensions
+		if len(embedding.Embedding) != 1536 {
+			t.Errorf("Expected 1536 dimensions, got %d", len(embedding.Embedding))
+		}
+	})
+}
+
+func testEmbeddingsMultipleInputs(t *testing.T) {
+	ctx, client := setup(t)
+
+	inputs := []string{
+		"First sentence",
+		"Second sentence",
+		"Third sentence",
+	}
+
+	resp, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Model: openai.SmallEmbedding3,
+		Input: inputs,
+	})
+	if err != nil {
+		t.Fatalf("CreateEmbeddings: %v", err)
+	}
+
+	t.Run("Count", func(t *testing.T) {
+		if len(resp.Data) != len(inputs) {
+			t.Errorf("Expected %d embeddings, got %d", len(inputs), len(resp.Data))
+		}
+	})
+	t.Run("Indices", func(t *testing.T) {
+		for i, emb := range resp.Data {
+			if emb.Index != i {
+				t.Errorf("Embedding %d has index %d", i, emb.Index)
+			}
+		}
+	})
+}
+
+// =============================================================================
+// Error Handling Tests
+// =============================================================================
+
+func testErrorHandling(t *testing.T) {
+	ctx, client := setup(t)
+
+	t.Run("MissingModel", func(t *testing.T) {
+		_, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+			Model: "",
+			Messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser, Content: "Hello"},
+			},
+		})
+		if err == nil {
+			t.Fatal("Should have returned error for missing model")
+		}
+		t.Logf("Correctly returned error: %v", truncate(err.Error(), 80))
+	})
+
+	t.Run("EmptyMessages", func(t *testing.T) {
+		_, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+			Model:    openai.GPT4o,
+			Messages: []openai.ChatCompletionMessage{},
+		})
+		if err == nil {
+			t.Fatal("Should have returned error for empty messages")
+		}
+		t.Logf("Correctly returned error: %v", truncate(err.Error(), 80))
+	})
+}
+
+// =============================================================================
+// Helpers
+// =============================================================================
+
+func truncate(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	return s[:maxLen-3] + "..."
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// =============================================================================
+// TLS Negotiation Matrix
+// =============================================================================
+
+// TLSCombination is the outcome of one handshake in the negotiation matrix:
+// the client offered a single protocol version and, for TLS 1.2 and older,
+// a single cipher suite.
+type TLSCombination struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite,omitempty"`
+	// Legacy combinations use a deprecated protocol version or a suite
+	// from tls.InsecureCipherSuites, and a hardened server refuses them.
+	Legacy   bool   `json:"legacy,omitempty"`
+	Accepted bool   `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Name identifies the combination, as a subtest name.
+func (c TLSCombination) Name() string {
+	name := strings.ReplaceAll(c.Version, " ", "")
+	if c.CipherSuite != "" {
+		name += "/" + c.CipherSuite
+	}
+	return name
+}
+
+// tlsCase is a combination for the client to offer.
+type tlsCase struct {
+	version uint16
+	suite   uint16 // 0 for TLS 1.3, whose suites Go does not let us choose
+	legacy  bool
+}
+
+// tlsMatrixCases lists TLS 1.0 and 1.1 once each, TLS 1.2 with each suite
+// Go implements for it, and TLS 1.3.
+func tlsMatrixCases() []tlsCase {
+	cases := []tlsCase{
+		{version: tls.VersionTLS10, legacy: true},
+		{version: tls.VersionTLS11, legacy: true},
+	}
+	for _, insecure := range []bool{false, true} {
+		suites := tls.CipherSuites()
+		if insecure {
+			suites = tls.InsecureCipherSuites()
+		}
+		for _, suite := range suites {
+			if slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+				cases = append(cases, tlsCase{version: tls.VersionTLS12, suite: suite.ID, legacy: insecure})
+			}
+		}
+	}
+	return append(cases, tlsCase{version: tls.VersionTLS13})
+}
+
+// runTLSMatrix handshakes with the target once per combination of
+// tlsMatrixCases, each on a new connection, and reports which succeed.
+// TLS 1.0 and 1.1 are offered with Go's default suites for them.
+func runTLSMatrix(o Options) ([]TLSCombination, error) {
+	var results []TLSCombination
+	for _, c := range tlsMatrixCases() {
+		transport, err := newTransport(o)
+		if err != nil {
+			return nil, err
+		}
+		config := transport.TLSClientConfig
+		config.MinVersion, config.MaxVersion = c.version, c.version
+		if c.suite != 0 {
+			config.CipherSuites = []uint16{c.suite}
+		}
+		transport.DisableKeepAlives = true
+
+		result := TLSCombination{Version: tls.VersionName(c.version), Legacy: c.legacy}
+		if c.suite != 0 {
+			result.CipherSuite = tls.CipherSuiteName(c.suite)
+		}
+		_, err = tlsState(&http.Client{Transport: withHostOverride(o, transport), Timeout: 10 * time.Second}, o)
+		result.Accepted = err == nil
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// printTLSMatrix lists which combinations the server accepted, with
+// accepted legacy ones highlighted.
+func printTLSMatrix(w io.Writer, results []TLSCombination) {
+	fmt.Fprintf(w, "%s%sTLS Negotiation Matrix%s\n", colorBold, colorCyan, colorReset)
+	for _, r := range results {
+		suite := r.CipherSuite
+		if suite == "" {
+			suite = "(default suites)"
+		}
+		status := colorGreen + "accepted" + colorReset
+		switch {
+		case r.Accepted && r.Legacy:
+			status = colorRed + "accepted (legacy)" + colorReset
+		case !r.Accepted:
+			status = "rejected"
+		}
+		fmt.Fprintf(w, "  %-8s %-46s %s\n", r.Version, suite, status)
+	}
+	fmt.Fprintln(w)
+}
+
+// testTLSMatrix fails if the server accepts TLS 1.0 or 1.1 or an insecure
+// cipher suite, or accepts none of the modern combinations. The others are
+// logged, as which suites a server allows is a matter of its policy.
+func testTLSMatrix(t *testing.T) {
+	setup(t)
+	requireMTLS(t)
+
+	results, err := runTLSMatrix(opts)
+	if err != nil {
+		t.Fatalf("Failed to configure client: %v", err)
+	}
+	var modern int
+	for _, r := range results {
+		t.Run(r.Name(), func(t *testing.T) {
+			switch {
+			case r.Accepted && r.Legacy:
+				t.Errorf("Server accepted a legacy combination; it should set a minimum of TLS 1.2 and disable insecure suites")
+			case r.Accepted:
+				modern++
+				t.Log("Accepted")
+			default:
+				t.Logf("Rejected: %s", r.Error)
+			}
+		})
+	}
+	if modern == 0 {
+		t.Error("Server accepted no TLS 1.2 or 1.3 combination")
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestTLSMatrixCases(t *testing.T) {
+	cases := tlsMatrixCases()
+	insecure := make(map[uint16]bool)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.ID] = true
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range cases {
+		name := TLSCombination{Version: tls.VersionName(c.version), CipherSuite: tls.CipherSuiteName(c.suite)}.Name()
+		if seen[name] {
+			t.Errorf("%s is listed twice", name)
+		}
+		seen[name] = true
+
+		wantLegacy := c.version < tls.VersionTLS12 || insecure[c.suite]
+		if c.legacy != wantLegacy {
+			t.Errorf("%s: legacy = %v, want %v", name, c.legacy, wantLegacy)
+		}
+		if (c.suite == 0) != (c.version != tls.VersionTLS12) {
+			t.Errorf("%s: a suite is chosen only for TLS 1.2", name)
+		}
+	}
+	if last := cases[len(cases)-1]; last.version != tls.VersionTLS13 {
+		t.Errorf("last case is %s, want TLS 1.3", tls.VersionName(last.version))
+	}
+	if !seen["TLS1.2/TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"] {
+		t.Error("matrix lacks TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+	}
+}
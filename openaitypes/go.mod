@@ -0,0 +1,3 @@
+module openaitypes
+
+go 1.25.1
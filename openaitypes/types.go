@@ -0,0 +1,423 @@
+// Package openaitypes declares the request and response bodies of the
+// OpenAI API that the mock server, proxy and test tools exchange: models,
+// chat completions and their stream chunks, embeddings and errors. They
+// marshal to and from the API's JSON, so a body decoded and encoded again
+// keeps the fields it set.
+//
+//	var req openaitypes.ChatCompletionRequest
+//	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+//		return err
+//	}
+//	prompt := req.Messages[len(req.Messages)-1].Content.GetText()
+//
+// Optional numbers are pointers, so that a zero sent by the client can be
+// told apart from one left out. Fields whose shape varies between
+// requests, such as stop and tool_choice, are left as any.
+package openaitypes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ============================================================================
+// Models
+// ============================================================================
+
+type Model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type ModelsResponse struct {
+	Object string  `json:"object"`
+	Data   []Model `json:"data"`
+}
+
+// ============================================================================
+// Chat Completions
+// ============================================================================
+
+// ContentPart represents a part of a multi-part content message
+type ContentPart struct {
+	Type       string      `json:"type"`
+	Text       string      `json:"text,omitempty"`
+	ImageURL   *ImageURL   `json:"image_url,omitempty"`
+	InputAudio *InputAudio `json:"input_audio,omitempty"`
+	File       *InputFile  `json:"file,omitempty"`
+	Refusal    string      `json:"refusal,omitempty"`
+}
+
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// InputAudio is base64-encoded audio, in the format named (wav or mp3).
+type InputAudio struct {
+	Data   string `json:"data"`
+	Format string `json:"format"`
+}
+
+// InputFile is an uploaded file, by ID, or one given inline as a data URL.
+type InputFile struct {
+	FileID   string `json:"file_id,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// MessageContent can be either a string or an array of ContentParts
+type MessageContent struct {
+	Text  string
+	Parts []ContentPart
+}
+
+func (mc *MessageContent) UnmarshalJSON(data []byte) error {
+	// Try to unmarshal as a string first
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		mc.Text = text
+		mc.Parts = nil
+		return nil
+	}
+
+	// Try to unmarshal as an array of ContentParts
+	var parts []ContentPart
+	if err := json.Unmarshal(data, &parts); err == nil {
+		mc.Parts = parts
+		mc.Text = ""
+		return nil
+	}
+
+	// If neither works, return an error
+	return fmt.Errorf("content must be a string or array of content parts")
+}
+
+func (mc MessageContent) MarshalJSON() ([]byte, error) {
+	if len(mc.Parts) > 0 {
+		return json.Marshal(mc.Parts)
+	}
+	return json.Marshal(mc.Text)
+}
+
+// GetText returns the text content, extracting from parts if necessary
+func (mc *MessageContent) GetText() string {
+	if mc.Text != "" {
+		return mc.Text
+	}
+	// Extract text from parts
+	var texts []string
+	for _, part := range mc.Parts {
+		if part.Type == "text" && part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, " ")
+}
+
+type ChatMessage struct {
+	Role       string         `json:"role"`
+	Content    MessageContent `json:"content,omitempty"`
+	Refusal    string         `json:"refusal,omitempty"`
+	ToolCalls  []ToolCall     `json:"tool_calls,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
+	Name       string         `json:"name,omitempty"`
+	// Annotations cite the sources used by built-in search tools.
+	Annotations []Annotation `json:"annotations,omitempty"`
+}
+
+// Annotation is a citation attached to an assistant message in the Chat
+// Completions format.
+type Annotation struct {
+	Type         string        `json:"type"`
+	URLCitation  *URLCitation  `json:"url_citation,omitempty"`
+	FileCitation *FileCitation `json:"file_citation,omitempty"`
+}
+
+type URLCitation struct {
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
+	Title      string `json:"title"`
+	URL        string `json:"url"`
+}
+
+type FileCitation struct {
+	Index    int    `json:"index"`
+	FileID   string `json:"file_id"`
+	Filename string `json:"filename"`
+}
+
+// ResponseMessage is used for responses (always string content)
+type ResponseMessage struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content,omitempty"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+type ToolCall struct {
+	// Index is only set on streamed tool call deltas.
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type Tool struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+
+	// Options for the built-in web_search_preview and file_search tools
+	SearchContextSize string   `json:"search_context_size,omitempty"`
+	UserLocation      any      `json:"user_location,omitempty"`
+	VectorStoreIDs    []string `json:"vector_store_ids,omitempty"`
+	MaxNumResults     *int     `json:"max_num_results,omitempty"`
+}
+
+type FunctionDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	Strict      *bool                  `json:"strict,omitempty"`
+}
+
+// ResponseFormat asks for text, any JSON object (json_object) or JSON
+// matching a schema (json_schema).
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+type JSONSchema struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Schema      map[string]any `json:"schema,omitempty"`
+	Strict      *bool          `json:"strict,omitempty"`
+}
+
+// StreamOptions apply to streamed completions. IncludeUsage adds a last
+// chunk, with no choices, reporting the usage of the whole completion.
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type ChatCompletionRequest struct {
+	Model               string             `json:"model"`
+	Messages            []ChatMessage      `json:"messages"`
+	MaxTokens           *int               `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int               `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64           `json:"temperature,omitempty"`
+	TopP                *float64           `json:"top_p,omitempty"`
+	N                   *int               `json:"n,omitempty"`
+	Stream              bool               `json:"stream,omitempty"`
+	StreamOptions       *StreamOptions     `json:"stream_options,omitempty"`
+	Stop                interface{}        `json:"stop,omitempty"`
+	PresencePenalty     *float64           `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    *float64           `json:"frequency_penalty,omitempty"`
+	Seed                *int64             `json:"seed,omitempty"`
+	Logprobs            *bool              `json:"logprobs,omitempty"`
+	TopLogprobs         *int               `json:"top_logprobs,omitempty"`
+	ResponseFormat      *ResponseFormat    `json:"response_format,omitempty"`
+	User                string             `json:"user,omitempty"`
+	Tools               []Tool             `json:"tools,omitempty"`
+	ToolChoice          interface{}        `json:"tool_choice,omitempty"`
+	ParallelToolCalls   *bool              `json:"parallel_tool_calls,omitempty"`
+	WebSearchOptions    interface{}        `json:"web_search_options,omitempty"`
+	LogitBias           map[string]float64 `json:"logit_bias,omitempty"`
+	Store               *bool              `json:"store,omitempty"`
+	Metadata            map[string]string  `json:"metadata,omitempty"`
+	ReasoningEffort     string             `json:"reasoning_effort,omitempty"`
+	ServiceTier         string             `json:"service_tier,omitempty"`
+	Modalities          []string           `json:"modalities,omitempty"`
+	Audio               any                `json:"audio,omitempty"`
+	Prediction          any                `json:"prediction,omitempty"`
+
+	// Vendor extensions sent by vLLM and llama.cpp clients. top_k is an
+	// integer in both servers, but clients commonly send it as a float
+	// (40.0), which they accept.
+	TopK              *float64    `json:"top_k,omitempty"`
+	MinP              *float64    `json:"min_p,omitempty"`
+	RepetitionPenalty *float64    `json:"repetition_penalty,omitempty"`
+	Grammar           interface{} `json:"grammar,omitempty"`
+	GuidedJSON        interface{} `json:"guided_json,omitempty"`
+}
+
+// VendorExtensions returns the non-OpenAI parameters set on the request,
+// keyed by their JSON name.
+func (req *ChatCompletionRequest) VendorExtensions() map[string]any {
+	params := make(map[string]any)
+	if req.TopK != nil {
+		params["top_k"] = *req.TopK
+	}
+	if req.MinP != nil {
+		params["min_p"] = *req.MinP
+	}
+	if req.RepetitionPenalty != nil {
+		params["repetition_penalty"] = *req.RepetitionPenalty
+	}
+	if req.Grammar != nil {
+		params["grammar"] = req.Grammar
+	}
+	if req.GuidedJSON != nil {
+		params["guided_json"] = req.GuidedJSON
+	}
+	return params
+}
+
+type ChatChoice struct {
+	Index        int         `json:"index"`
+	Message      ChatMessage `json:"message"`
+	Logprobs     *Logprobs   `json:"logprobs,omitempty"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+// Logprobs are the log probabilities of a choice's tokens, when the
+// request set logprobs.
+type Logprobs struct {
+	Content []TokenLogprob `json:"content"`
+	Refusal []TokenLogprob `json:"refusal,omitempty"`
+}
+
+type TokenLogprob struct {
+	Token       string       `json:"token"`
+	Logprob     float64      `json:"logprob"`
+	Bytes       []int        `json:"bytes"`
+	TopLogprobs []TopLogprob `json:"top_logprobs"`
+}
+
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
+}
+
+type Usage struct {
+	PromptTokens            int                      `json:"prompt_tokens"`
+	CompletionTokens        int                      `json:"completion_tokens"`
+	TotalTokens             int                      `json:"total_tokens"`
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+// PromptTokensDetails breaks down the prompt tokens. CachedTokens are
+// those served from the prompt cache, and are counted in PromptTokens too.
+type PromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+	AudioTokens  int `json:"audio_tokens,omitempty"`
+}
+
+type CompletionTokensDetails struct {
+	ReasoningTokens          int `json:"reasoning_tokens"`
+	AudioTokens              int `json:"audio_tokens,omitempty"`
+	AcceptedPredictionTokens int `json:"accepted_prediction_tokens,omitempty"`
+	RejectedPredictionTokens int `json:"rejected_prediction_tokens,omitempty"`
+}
+
+type ChatCompletionResponse struct {
+	ID                string            `json:"id"`
+	Object            string            `json:"object"`
+	Created           int64             `json:"created"`
+	Model             string            `json:"model"`
+	Choices           []ChatChoice      `json:"choices"`
+	Usage             Usage             `json:"usage"`
+	ServiceTier       string            `json:"service_tier,omitempty"`
+	SystemFingerprint string            `json:"system_fingerprint,omitempty"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+	Debug             *DebugInfo        `json:"debug,omitempty"`
+}
+
+// DebugInfo is attached to chat responses by the mock server when its
+// Config.DebugEcho is set. It reports request parameters the mock accepted
+// but otherwise ignores.
+type DebugInfo struct {
+	VendorParams map[string]any `json:"vendor_params,omitempty"`
+	// LogitBias lists the validated biases, keyed by token ID, that a real
+	// model would have applied.
+	LogitBias map[string]float64 `json:"logit_bias,omitempty"`
+}
+
+// Streaming types
+type StreamDelta struct {
+	Role        *string      `json:"role,omitempty"`
+	Content     *string      `json:"content,omitempty"`
+	Refusal     *string      `json:"refusal,omitempty"`
+	ToolCalls   []ToolCall   `json:"tool_calls,omitempty"`
+	Annotations []Annotation `json:"annotations,omitempty"`
+}
+
+type StreamChoice struct {
+	Index        int         `json:"index"`
+	Delta        StreamDelta `json:"delta"`
+	Logprobs     *Logprobs   `json:"logprobs,omitempty"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+// ChatCompletionChunk is one event of a streamed completion. Usage is
+// only set on the last chunk, and only if the request asked for it with
+// stream_options.
+type ChatCompletionChunk struct {
+	ID                string         `json:"id"`
+	Object            string         `json:"object"`
+	Created           int64          `json:"created"`
+	Model             string         `json:"model"`
+	ServiceTier       string         `json:"service_tier,omitempty"`
+	SystemFingerprint string         `json:"system_fingerprint,omitempty"`
+	Choices           []StreamChoice `json:"choices"`
+	Usage             *Usage         `json:"usage,omitempty"`
+	Debug             *DebugInfo     `json:"debug,omitempty"`
+}
+
+// ============================================================================
+// Embeddings
+// ============================================================================
+
+// EmbeddingsRequest is a request for embeddings. Input is a string, an
+// array of strings, or one or more arrays of token IDs.
+type EmbeddingsRequest struct {
+	Model          string `json:"model"`
+	Input          any    `json:"input"`
+	EncodingFormat string `json:"encoding_format,omitempty"`
+	Dimensions     *int   `json:"dimensions,omitempty"`
+	User           string `json:"user,omitempty"`
+}
+
+type EmbeddingData struct {
+	Object    string    `json:"object"`
+	Embedding []float64 `json:"embedding"`
+	Index     int       `json:"index"`
+}
+
+type EmbeddingsResponse struct {
+	Object string          `json:"object"`
+	Data   []EmbeddingData `json:"data"`
+	Model  string          `json:"model"`
+	Usage  EmbeddingsUsage `json:"usage"`
+}
+
+type EmbeddingsUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// ============================================================================
+// Errors
+// ============================================================================
+
+type ErrorDetail struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
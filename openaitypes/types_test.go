@@ -0,0 +1,191 @@
+package openaitypes
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// roundTrip decodes data into a T and encodes it again, failing unless the
+// result is the same JSON, ignoring field order and spacing.
+func roundTrip[T any](t *testing.T, data string) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		t.Fatalf("decoding %T: %v", v, err)
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("encoding %T: %v", v, err)
+	}
+	var want, got any
+	json.Unmarshal([]byte(data), &want)
+	json.Unmarshal(encoded, &got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%T round trip:\n got %s\nwant %s", v, encoded, data)
+	}
+	return v
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Run("Models", func(t *testing.T) {
+		models := roundTrip[ModelsResponse](t, `{"object":"list","data":[
+			{"id":"gpt-4o","object":"model","created":1715367049,"owned_by":"openai"}]}`)
+		if models.Data[0].OwnedBy != "openai" {
+			t.Errorf("owned_by = %q", models.Data[0].OwnedBy)
+		}
+	})
+
+	t.Run("ChatCompletionRequest", func(t *testing.T) {
+		req := roundTrip[ChatCompletionRequest](t, `{
+			"model": "gpt-4o",
+			"messages": [
+				{"role": "system", "content": "Be brief."},
+				{"role": "user", "name": "alice", "content": [
+					{"type": "text", "text": "What is in these?"},
+					{"type": "image_url", "image_url": {"url": "https://example.com/a.png", "detail": "low"}},
+					{"type": "input_audio", "input_audio": {"data": "UklGRg==", "format": "wav"}},
+					{"type": "file", "file": {"file_id": "file-abc", "filename": "a.pdf"}}
+				]},
+				{"role": "assistant", "content": "", "tool_calls": [
+					{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}
+				]},
+				{"role": "tool", "tool_call_id": "call_1", "content": "sunny"}
+			],
+			"max_tokens": 100,
+			"max_completion_tokens": 200,
+			"temperature": 0,
+			"top_p": 0.9,
+			"n": 2,
+			"stream": true,
+			"stream_options": {"include_usage": true},
+			"stop": ["\n", "END"],
+			"presence_penalty": 0.5,
+			"frequency_penalty": -0.5,
+			"seed": 42,
+			"logprobs": true,
+			"top_logprobs": 3,
+			"response_format": {"type": "json_schema", "json_schema": {"name": "answer", "strict": true,
+				"schema": {"type": "object", "properties": {"city": {"type": "string"}}}}},
+			"user": "user-1",
+			"tools": [
+				{"type": "function", "function": {"name": "get_weather", "description": "Weather for a city",
+					"parameters": {"type": "object"}, "strict": false}},
+				{"type": "file_search", "function": {"name": ""}, "vector_store_ids": ["vs_1"], "max_num_results": 5}
+			],
+			"tool_choice": {"type": "function", "function": {"name": "get_weather"}},
+			"parallel_tool_calls": false,
+			"web_search_options": {"search_context_size": "low"},
+			"logit_bias": {"50256": -100},
+			"store": true,
+			"metadata": {"team": "mtls"},
+			"reasoning_effort": "low",
+			"service_tier": "auto",
+			"modalities": ["text", "audio"],
+			"audio": {"voice": "alloy", "format": "mp3"},
+			"prediction": {"type": "content", "content": "Paris"},
+			"top_k": 40,
+			"min_p": 0.05,
+			"repetition_penalty": 1.1,
+			"grammar": "root ::= \"yes\"",
+			"guided_json": {"type": "object"}
+		}`)
+
+		// Zeroes that were sent are kept apart from fields left out
+		if req.Temperature == nil || *req.Temperature != 0 || req.ParallelToolCalls == nil || *req.ParallelToolCalls {
+			t.Errorf("temperature %v, parallel_tool_calls %v", req.Temperature, req.ParallelToolCalls)
+		}
+		if got := req.Messages[1].Content.GetText(); got != "What is in these?" {
+			t.Errorf("GetText = %q", got)
+		}
+		if req.ResponseFormat.JSONSchema.Name != "answer" || !req.StreamOptions.IncludeUsage {
+			t.Errorf("response_format %+v, stream_options %+v", req.ResponseFormat, req.StreamOptions)
+		}
+		want := map[string]any{"top_k": 40.0, "min_p": 0.05, "repetition_penalty": 1.1, "grammar": `root ::= "yes"`, "guided_json": map[string]any{"type": "object"}}
+		if got := req.VendorExtensions(); !reflect.DeepEqual(got, want) {
+			t.Errorf("VendorExtensions = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("ChatCompletionResponse", func(t *testing.T) {
+		resp := roundTrip[ChatCompletionResponse](t, `{
+			"id": "chatcmpl-1", "object": "chat.completion", "created": 1700000000, "model": "gpt-4o",
+			"choices": [{
+				"index": 0,
+				"message": {"role": "assistant", "content": "Paris is sunny.", "annotations": [
+					{"type": "url_citation", "url_citation": {"start_index": 0, "end_index": 5, "title": "Paris", "url": "https://example.com"}},
+					{"type": "file_citation", "file_citation": {"index": 1, "file_id": "file-abc", "filename": "a.pdf"}}
+				]},
+				"logprobs": {"content": [{"token": "Paris", "logprob": -0.1, "bytes": [80, 97, 114, 105, 115],
+					"top_logprobs": [{"token": "Paris", "logprob": -0.1, "bytes": [80, 97, 114, 105, 115]}]}]},
+				"finish_reason": "stop"
+			}],
+			"usage": {"prompt_tokens": 20, "completion_tokens": 5, "total_tokens": 25,
+				"prompt_tokens_details": {"cached_tokens": 10, "audio_tokens": 2},
+				"completion_tokens_details": {"reasoning_tokens": 3, "audio_tokens": 1,
+					"accepted_prediction_tokens": 1, "rejected_prediction_tokens": 1}},
+			"service_tier": "default",
+			"system_fingerprint": "fp_1",
+			"metadata": {"team": "mtls"},
+			"debug": {"vendor_params": {"top_k": 40}, "logit_bias": {"50256": -100}}
+		}`)
+		if resp.Usage.PromptTokensDetails.CachedTokens != 10 {
+			t.Errorf("cached tokens = %d", resp.Usage.PromptTokensDetails.CachedTokens)
+		}
+	})
+
+	t.Run("ChatCompletionChunk", func(t *testing.T) {
+		roundTrip[ChatCompletionChunk](t, `{
+			"id": "chatcmpl-1", "object": "chat.completion.chunk", "created": 1700000000, "model": "gpt-4o",
+			"system_fingerprint": "fp_1",
+			"choices": [{"index": 0, "delta": {"role": "assistant", "content": "", "tool_calls": [
+				{"index": 0, "id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": ""}}
+			]}, "finish_reason": null}]
+		}`)
+		roundTrip[ChatCompletionChunk](t, `{
+			"id": "chatcmpl-1", "object": "chat.completion.chunk", "created": 1700000000, "model": "gpt-4o",
+			"choices": [{"index": 0, "delta": {"refusal": "I can't help with that."}, "finish_reason": "stop"}]
+		}`)
+
+		// The usage chunk stream_options asks for
+		chunk := roundTrip[ChatCompletionChunk](t, `{
+			"id": "chatcmpl-1", "object": "chat.completion.chunk", "created": 1700000000, "model": "gpt-4o",
+			"choices": [], "usage": {"prompt_tokens": 20, "completion_tokens": 5, "total_tokens": 25}
+		}`)
+		if chunk.Usage == nil || chunk.Usage.TotalTokens != 25 {
+			t.Errorf("usage = %+v", chunk.Usage)
+		}
+	})
+
+	t.Run("Embeddings", func(t *testing.T) {
+		roundTrip[EmbeddingsRequest](t, `{"model":"text-embedding-3-small","input":["a","b"],"encoding_format":"float","dimensions":256,"user":"user-1"}`)
+		roundTrip[EmbeddingsRequest](t, `{"model":"text-embedding-3-small","input":[[1,2,3]]}`)
+		roundTrip[EmbeddingsResponse](t, `{"object":"list","model":"text-embedding-3-small",
+			"data":[{"object":"embedding","embedding":[0.1,-0.2],"index":0}],
+			"usage":{"prompt_tokens":2,"total_tokens":2}}`)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		roundTrip[ErrorResponse](t, `{"error":{"message":"Invalid model","type":"invalid_request_error","param":"model","code":"model_not_found"}}`)
+		roundTrip[ErrorResponse](t, `{"error":{"message":"Internal error","type":"server_error","param":null,"code":null}}`)
+	})
+}
+
+func TestMessageContent(t *testing.T) {
+	var msg ChatMessage
+	if err := json.Unmarshal([]byte(`{"role":"user","content":[{"type":"text","text":"Hello"},{"type":"text","text":"there"}]}`), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Content.GetText(); got != "Hello there" {
+		t.Errorf("GetText = %q, want the text parts joined", got)
+	}
+
+	// A null content, as on assistant messages with tool calls, is empty
+	if err := json.Unmarshal([]byte(`{"role":"assistant","content":null}`), &msg); err != nil || msg.Content.GetText() != "" {
+		t.Errorf("null content: %q, %v", msg.Content.GetText(), err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"role":"user","content":42}`), &msg); err == nil {
+		t.Error("numeric content accepted")
+	}
+}